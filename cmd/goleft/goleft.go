@@ -12,8 +12,12 @@ import (
 	"github.com/brentp/goleft/depth"
 	"github.com/brentp/goleft/depthwed"
 	"github.com/brentp/goleft/indexcov"
+	"github.com/brentp/goleft/indexcov/serve"
 	"github.com/brentp/goleft/indexsplit"
+	"github.com/brentp/goleft/landing"
+	"github.com/brentp/goleft/liftover"
 	"github.com/brentp/goleft/samplename"
+	"github.com/brentp/goleft/validate"
 )
 
 type progPair struct {
@@ -22,12 +26,19 @@ type progPair struct {
 }
 
 var progs = map[string]progPair{
-	"depth":      progPair{"parallelize calls to samtools in user-defined windows", depth.Main},
-	"depthwed":   progPair{"matricize output from depth to n-sites * n-samples", depthwed.Main},
-	"covstats":   progPair{"coverage stats across bams by sampling", covstats.Main},
-	"indexcov":   progPair{"quick coverage estimate using only the bam index", indexcov.Main},
-	"indexsplit": progPair{"create regions of even coverage across bams/crams", indexsplit.Main},
-	"samplename": progPair{"report samplename(s) from a bam's SM tag", samplename.Main},
+	"depth":           progPair{"parallelize calls to samtools in user-defined windows", depth.Main},
+	"depthwed":        progPair{"matricize output from depth to n-sites * n-samples", depthwed.Main},
+	"covstats":        progPair{"coverage stats across bams by sampling", covstats.Main},
+	"indexcov":        progPair{"quick coverage estimate using only the bam index", indexcov.Main},
+	"indexcov-gather": progPair{"merge PCA sketches from indexcov --sketch runs on parts of a cohort", indexcov.GatherMain},
+	"indexcov-lookup": progPair{"print the depths of all samples in a region of a finished indexcov run", indexcov.LookupMain},
+	"indexcov-replot": progPair{"redo indexcov plots and ped from existing indexcov bed.gz files", indexcov.ReplotMain},
+	"indexcov-serve":  progPair{"serve indexcov output over HTTPS with optional basic-auth or OIDC", serve.Main},
+	"indexsplit":      progPair{"create regions of even coverage across bams/crams", indexsplit.Main},
+	"landing":         progPair{"write a page linking the outputs of goleft commands in a directory", landing.Main},
+	"liftover":        progPair{"lift indexcov bed and vcf outputs to another genome build with a chain file", liftover.Main},
+	"samplename":      progPair{"report samplename(s) from a bam's SM tag", samplename.Main},
+	"validate-inputs": progPair{"check bed, ped and metadata files and their samples before a long run", validate.Main},
 }
 
 func printProgs() {
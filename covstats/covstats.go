@@ -13,16 +13,21 @@ import (
 	arg "github.com/alexflint/go-arg"
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft"
+	"github.com/brentp/goleft/genomes"
 	"github.com/brentp/goleft/samplename"
 	"github.com/brentp/smoove/shared"
 	"github.com/brentp/xopen"
 )
 
 var cli = struct {
-	N       int      `arg:"-n,help:number of reads to sample for length"`
-	Regions string   `arg:"-r,help:optional bed file to specify target regions"`
-	Fasta   string   `arg:"-f,help:fasta file. required for cram format"`
-	Bams    []string `arg:"positional,required,help:bams/crams for which to estimate coverage"`
+	N          int      `arg:"-n,help:number of reads to sample for length"`
+	Regions    string   `arg:"-r,help:optional bed file to specify target regions"`
+	Fasta      string   `arg:"-f,help:fasta file. required for cram format"`
+	Lanes      string   `arg:"help:write the metrics of each flowcell and lane (from Illumina read names) to this file"`
+	Genome     string   `arg:"help:bundled genome (e.g. hg38). the coverage is estimated from its chromosomes only"`
+	GenomeFile string   `arg:"--genome-file,help:JSON file in the format of the goleft genomes package. the coverage is estimated from its chromosomes only"`
+	Bams       []string `arg:"positional,required,help:bams/crams for which to estimate coverage"`
 }{N: 1000000}
 
 func pcheck(e error) {
@@ -31,6 +36,13 @@ func pcheck(e error) {
 	}
 }
 
+// icheck exits with goleft.ExitInput for errors opening the inputs.
+func icheck(e error) {
+	if e != nil {
+		goleft.Fatal(goleft.InputErr(e))
+	}
+}
+
 const N_MADS = 10
 
 func readCoverage(path string) int {
@@ -88,8 +100,43 @@ func meanStd(arr []int) (mean, std float64) {
 	return mean, math.Sqrt(std)
 }
 
+// LibraryLayout is the layout of the reads in a bam found by BamStats.
+type LibraryLayout int
+
+const (
+	// UnknownLayout is used when there were no reads to sample.
+	UnknownLayout LibraryLayout = iota
+	// SingleEnd libraries have no mates so the insert metrics are not available.
+	SingleEnd
+	// PairedEnd libraries have mates flagged as paired.
+	PairedEnd
+	// InterleavedPaired libraries have each read followed by its mate, as from a name-sorted bam
+	// or an interleaved fastq, whether or not the reads are flagged as paired.
+	InterleavedPaired
+)
+
+func (l LibraryLayout) String() string {
+	switch l {
+	case SingleEnd:
+		return "single"
+	case PairedEnd:
+		return "paired"
+	case InterleavedPaired:
+		return "interleaved"
+	}
+	return "unknown"
+}
+
+// HasInserts returns true if the layout has mates so that insert sizes can be measured.
+func (l LibraryLayout) HasInserts() bool {
+	return l == PairedEnd || l == InterleavedPaired
+}
+
 // Stats hold info about a bam returned from `BamStats`
 type Stats struct {
+	// Layout is the library layout. The insert and template values and ProportionProperlyPaired
+	// are only meaningful when Layout.HasInserts() is true.
+	Layout     LibraryLayout
 	InsertMean float64
 	InsertSD   float64
 	// 5th percentile of insert size
@@ -109,15 +156,54 @@ type Stats struct {
 	MaxReadLength int
 
 	H []float64
+	// Lanes are the metrics of each flowcell and lane from the Illumina names of the sampled reads.
+	// It is nil if the reads do not have Illumina names.
+	Lanes []LaneStats
 }
 
 func (s Stats) String() string {
+	if !s.Layout.HasInserts() || len(s.H) == 0 {
+		return "NA\tNA\tNA\tNA\tNA\tNA"
+	}
 	return fmt.Sprintf("%.2f\t%.2f\t%d\t%d\t%.2f\t%.2f", s.InsertMean, s.InsertSD, s.InsertPct5, s.InsertPct95, s.TemplateMean, s.TemplateSD)
 }
 
 // number of reads to skip to avoid crap at start of chrom
 const skipReads = 100000
 
+// minInterleaved is the proportion of sampled reads that must be next to a read with the same
+// name for the library to be called InterleavedPaired.
+const minInterleaved = 0.9
+
+// sameTemplate returns true if a and b are names of reads from the same template. /1 and /2
+// suffixes, as from some interleaved fastqs, are ignored.
+func sameTemplate(a, b string) bool {
+	if a == "" {
+		return false
+	}
+	if len(a) > 2 && len(a) == len(b) && a[len(a)-2] == '/' && b[len(b)-2] == '/' {
+		a, b = a[:len(a)-2], b[:len(b)-2]
+	}
+	return a == b
+}
+
+// unflaggedInsert returns the insert size and template length of adjacent mates that are not
+// flagged as paired. ok is false unless both are mapped, well-behaved and face each other.
+func unflaggedInsert(a, b *sam.Record) (insert, tlen int, ok bool) {
+	const bad = sam.Unmapped | sam.Duplicate | sam.QCFail | sam.Secondary | sam.Supplementary
+	if a.Flags&bad != 0 || b.Flags&bad != 0 || a.Ref == nil || b.Ref == nil || a.Ref.ID() != b.Ref.ID() {
+		return 0, 0, false
+	}
+	if b.Pos < a.Pos {
+		a, b = b, a
+	}
+	simple := func(r *sam.Record) bool { return len(r.Cigar) == 1 && r.Cigar[0].Type() == sam.CigarMatch }
+	if !simple(a) || !simple(b) || a.Strand() != 1 || b.Strand() != -1 || b.Pos <= a.Pos {
+		return 0, 0, false
+	}
+	return b.Pos - a.End(), b.End() - a.Pos, true
+}
+
 // BamStats takes bam reader sample N well-behaved sites and return the coverage and insert-size info
 func BamStats(br *bam.Reader, n int, skipReads int) Stats {
 	br.Omit(bam.AllVariableLengthData)
@@ -134,6 +220,11 @@ func BamStats(br *bam.Reader, n int, skipReads int) Stats {
 	}
 	s := Stats{}
 	var k int
+	// nRead is the number of reads sampled and nAdjacent is the number of those that are next to
+	// their mate. nPaired is the number flagged as paired.
+	var nRead, nAdjacent, nPaired int
+	var prev *sam.Record
+	lanes := newLaneCounter()
 
 	for len(insertSizes) < n {
 		rec, err := br.Read()
@@ -141,6 +232,27 @@ func BamStats(br *bam.Reader, n int, skipReads int) Stats {
 			break
 		}
 		pcheck(err)
+		nRead++
+		lane := lanes.add(rec)
+		if rec.Flags&sam.Paired != 0 {
+			nPaired++
+		}
+		if prev != nil && sameTemplate(prev.Name, rec.Name) {
+			nAdjacent += 2
+			// interleaved reads without flags have no mate position so the insert is found from the pair.
+			if rec.Flags&sam.Paired == 0 {
+				if ins, tlen, ok := unflaggedInsert(prev, rec); ok {
+					insertSizes = append(insertSizes, ins)
+					templateLengths = append(templateLengths, tlen)
+					if lane != nil {
+						lane.inserts = append(lane.inserts, ins)
+					}
+				}
+			}
+			prev = nil
+		} else {
+			prev = rec
+		}
 		if rec.Flags&sam.Unmapped != 0 {
 			nUnmapped++
 			continue
@@ -169,15 +281,36 @@ func BamStats(br *bam.Reader, n int, skipReads int) Stats {
 		if rec.Pos < rec.MatePos && rec.Flags&sam.ProperPair == sam.ProperPair && len(rec.Cigar) == 1 && rec.Cigar[0].Type() == sam.CigarMatch {
 			insertSizes = append(insertSizes, rec.MatePos-rec.End())
 			templateLengths = append(templateLengths, rec.TempLen)
+			if lane != nil {
+				lane.inserts = append(lane.inserts, rec.MatePos-rec.End())
+			}
 		}
 	}
 
+	switch {
+	case nRead == 0:
+		s.Layout = UnknownLayout
+	case float64(nAdjacent) >= minInterleaved*float64(nRead):
+		s.Layout = InterleavedPaired
+	case nPaired > nRead/2:
+		s.Layout = PairedEnd
+	default:
+		s.Layout = SingleEnd
+	}
+	if !s.Layout.HasInserts() {
+		insertSizes, templateLengths = insertSizes[:0], templateLengths[:0]
+	}
+	s.Lanes = lanes.stats(s.Layout.HasInserts())
+
 	sort.Ints(sizes)
 
 	if len(sizes) > 0 {
 		s.ProportionBad = float64(nBad) / float64(k)
 		s.ProportionDuplicate = s.ProportionDuplicate / float64(k)
 		s.ProportionProperlyPaired = s.ProportionProperlyPaired / float64(k)
+		if !s.Layout.HasInserts() {
+			s.ProportionProperlyPaired = math.NaN()
+		}
 		s.ProportionUnmapped = float64(nUnmapped) / float64(k)
 		s.ReadLengthMedian = float64(sizes[(len(sizes)-1)/2]) - 1
 		s.ReadLengthMean, _ = meanStd(sizes)
@@ -221,13 +354,25 @@ func BamStats(br *bam.Reader, n int, skipReads int) Stats {
 
 // Main is called from the dispatcher
 func Main() {
-	fmt.Fprintln(os.Stdout, "coverage\tinsert_mean\tinsert_sd\tinsert_5th\tinsert_95th\ttemplate_mean\ttemplate_sd\tpct_unmapped\tpct_bad_reads\tpct_duplicate\tpct_proper_pair\tread_length\tbam\tsample")
+	fmt.Fprintln(os.Stdout, "coverage\tinsert_mean\tinsert_sd\tinsert_5th\tinsert_95th\ttemplate_mean\ttemplate_sd\tpct_unmapped\tpct_bad_reads\tpct_duplicate\tpct_proper_pair\tread_length\tbam\tsample\tlayout")
 
-	arg.MustParse(&cli)
+	p := arg.MustParse(&cli)
+	g, err := genomes.Resolve(cli.Genome, cli.GenomeFile)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	var lanes *os.File
+	if cli.Lanes != "" {
+		lanes, err = os.Create(cli.Lanes)
+		pcheck(err)
+		defer lanes.Close()
+		_, err = io.WriteString(lanes, laneHeader)
+		pcheck(err)
+	}
 	for _, bamPath := range cli.Bams {
 
 		brdr, err := shared.NewReader(bamPath, 2, cli.Fasta)
-		pcheck(err)
+		icheck(err)
 
 		names := strings.Join(samplename.Names(brdr.Header()), ",")
 		if names == "" {
@@ -243,10 +388,10 @@ func Main() {
 				// if .bam.bai didn't exist, check .bai
 				ifh, err = os.Open(bamPath[:len(bamPath)-4] + ".bai")
 			}
-			pcheck(err)
+			icheck(err)
 
 			idx, err = bam.ReadIndex(ifh)
-			pcheck(err)
+			icheck(err)
 		}
 
 		genomeBases := 0
@@ -254,6 +399,12 @@ func Main() {
 		sizes := BamStats(brdr, cli.N, skipReads)
 		var notFound []string
 		for _, ref := range brdr.Header().Refs() {
+			// decoys, alts and unplaced contigs are left out with a genome.
+			if g != nil {
+				if _, ok := g.Chromosome(ref.Name()); !ok {
+					continue
+				}
+			}
 			genomeBases += ref.Len()
 			if idx != nil {
 				stats, ok := idx.ReferenceStats(ref.ID())
@@ -272,14 +423,32 @@ func Main() {
 		if cli.Regions != "" {
 			genomeBases = readCoverage(cli.Regions)
 		}
+		if genomeBases == 0 && g != nil {
+			goleft.Fatal(goleft.InputErr(fmt.Errorf("covstats: no chromosomes of %s found in %s", g.Name, bamPath)))
+		}
 
 		// TODO: check that reads are from coverage regions.
 		coverage := (1 - sizes.ProportionBad) * float64(mapped) * sizes.ReadLengthMean / float64(genomeBases)
 
-		fmt.Fprintf(os.Stdout, "%.2f\t%s\t%.2f\t%.1f\t%.1f\t%.1f\t%d\t%s\t%s\n", coverage, sizes.String(), 100*sizes.ProportionUnmapped,
+		properPair := "NA"
+		if sizes.Layout.HasInserts() {
+			properPair = fmt.Sprintf("%.1f", 100*sizes.ProportionProperlyPaired)
+		}
+		fmt.Fprintf(os.Stdout, "%.2f\t%s\t%.2f\t%.1f\t%.1f\t%s\t%d\t%s\t%s\t%s\n", coverage, sizes.String(), 100*sizes.ProportionUnmapped,
 			100*sizes.ProportionBad,
 			100*sizes.ProportionDuplicate,
-			100*sizes.ProportionProperlyPaired,
-			sizes.MaxReadLength, bamPath, names)
+			properPair,
+			sizes.MaxReadLength, bamPath, names, sizes.Layout)
+		for _, l := range sizes.Lanes {
+			if len(l.Flags) > 0 {
+				fmt.Fprintf(os.Stderr, "covstats: lane %d of flowcell %s in %s differs from the other lanes in: %s\n", l.Lane, l.Flowcell, bamPath, strings.Join(l.Flags, ","))
+			}
+		}
+		if lanes != nil {
+			if sizes.Lanes == nil {
+				fmt.Fprintf(os.Stderr, "covstats: no flowcell and lane found in the read names of %s\n", bamPath)
+			}
+			pcheck(writeLanes(lanes, bamPath, names, sizes.Lanes))
+		}
 	}
 }
@@ -0,0 +1,206 @@
+package covstats
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// a lane is flagged if its mean insert size differs from the median of the lanes by more than
+// laneInsertDiff of that median or if its mean mapping quality differs by more than laneMapQDiff.
+// Lanes with fewer than laneMinReads sampled reads are not flagged.
+const (
+	laneInsertDiff = 0.1
+	laneMapQDiff   = 5
+	laneMinReads   = 1000
+	// minLaneNames is the proportion of sampled reads that must have Illumina names for the lanes
+	// to be reported.
+	minLaneNames = 0.9
+)
+
+// ReadNameLane returns the flowcell and lane from an Illumina read name. These are the 3rd and 4th
+// fields of instrument:run:flowcell:lane:tile:x:y (Casava 1.8 and later) and, for older names of
+// the form instrument:lane:tile:x:y#index/read, the instrument and lane.
+func ReadNameLane(name string) (flowcell string, lane int, ok bool) {
+	if i := strings.IndexAny(name, " /#"); i != -1 {
+		name = name[:i]
+	}
+	toks := strings.Split(name, ":")
+	var err error
+	switch len(toks) {
+	case 7:
+		flowcell = toks[2]
+		lane, err = strconv.Atoi(toks[3])
+	case 5:
+		flowcell = toks[0]
+		lane, err = strconv.Atoi(toks[1])
+	default:
+		return "", 0, false
+	}
+	if err != nil || flowcell == "" {
+		return "", 0, false
+	}
+	return flowcell, lane, true
+}
+
+// LaneStats are the metrics of the sampled reads of a flowcell and lane.
+type LaneStats struct {
+	Flowcell string
+	Lane     int
+	Reads    int
+	// InsertMean and InsertSD are NaN for libraries without mates.
+	InsertMean          float64
+	InsertSD            float64
+	MapQMean            float64
+	ProportionUnmapped  float64
+	ProportionDuplicate float64
+	// Flags are "insert" and "mapq" if the lane differs from the others in those metrics.
+	Flags []string
+}
+
+type laneCounts struct {
+	reads, unmapped, duplicate, mapped int
+	mapq                               float64
+	inserts                            []int
+}
+
+// laneCounter accumulates the reads sampled by BamStats by flowcell and lane.
+type laneCounter struct {
+	lanes map[string]*laneCounts
+	// named is the number of reads with an Illumina name.
+	named, n int
+	// last caches the lane of the previous name as reads from a lane are often together.
+	lastKey  string
+	lastLane *laneCounts
+}
+
+func newLaneCounter() *laneCounter {
+	return &laneCounter{lanes: make(map[string]*laneCounts)}
+}
+
+// add counts rec and returns its lane or nil if the name was not from Illumina.
+func (c *laneCounter) add(rec *sam.Record) *laneCounts {
+	c.n++
+	flowcell, lane, ok := ReadNameLane(rec.Name)
+	if !ok {
+		return nil
+	}
+	c.named++
+	key := flowcell + ":" + strconv.Itoa(lane)
+	lc := c.lastLane
+	if key != c.lastKey {
+		if lc = c.lanes[key]; lc == nil {
+			lc = &laneCounts{}
+			c.lanes[key] = lc
+		}
+		c.lastKey, c.lastLane = key, lc
+	}
+	lc.reads++
+	switch {
+	case rec.Flags&sam.Unmapped != 0:
+		lc.unmapped++
+	case rec.Flags&sam.Duplicate != 0:
+		lc.duplicate++
+	default:
+		lc.mapped++
+		lc.mapq += float64(rec.MapQ)
+	}
+	return lc
+}
+
+// stats returns the metrics of each lane with divergent lanes flagged. It is nil unless most
+// of the reads had Illumina names.
+func (c *laneCounter) stats(hasInserts bool) []LaneStats {
+	if c.n == 0 || float64(c.named) < minLaneNames*float64(c.n) {
+		return nil
+	}
+	out := make([]LaneStats, 0, len(c.lanes))
+	for key, lc := range c.lanes {
+		i := strings.LastIndexByte(key, ':')
+		lane, _ := strconv.Atoi(key[i+1:])
+		ls := LaneStats{Flowcell: key[:i], Lane: lane, Reads: lc.reads, InsertMean: math.NaN(), InsertSD: math.NaN(), MapQMean: math.NaN(),
+			ProportionUnmapped: float64(lc.unmapped) / float64(lc.reads), ProportionDuplicate: float64(lc.duplicate) / float64(lc.reads)}
+		if lc.mapped > 0 {
+			ls.MapQMean = lc.mapq / float64(lc.mapped)
+		}
+		if hasInserts && len(lc.inserts) > 0 {
+			ins := lc.inserts
+			if len(ins) > 2 {
+				ins = madFilter(ins, N_MADS)
+			}
+			ls.InsertMean, ls.InsertSD = meanStd(ins)
+		}
+		out = append(out, ls)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Flowcell < out[j].Flowcell || (out[i].Flowcell == out[j].Flowcell && out[i].Lane < out[j].Lane)
+	})
+	flagLanes(out)
+	return out
+}
+
+// flagLanes flags the lanes whose insert size or mapping quality differ from the median of the lanes.
+func flagLanes(lanes []LaneStats) {
+	var inserts, mapqs []float64
+	for _, l := range lanes {
+		if l.Reads < laneMinReads {
+			continue
+		}
+		if !math.IsNaN(l.InsertMean) {
+			inserts = append(inserts, l.InsertMean)
+		}
+		if !math.IsNaN(l.MapQMean) {
+			mapqs = append(mapqs, l.MapQMean)
+		}
+	}
+	for i := range lanes {
+		l := &lanes[i]
+		if l.Reads < laneMinReads {
+			continue
+		}
+		if len(inserts) > 1 && math.Abs(l.InsertMean-median(inserts)) > laneInsertDiff*median(inserts) {
+			l.Flags = append(l.Flags, "insert")
+		}
+		if len(mapqs) > 1 && math.Abs(l.MapQMean-median(mapqs)) > laneMapQDiff {
+			l.Flags = append(l.Flags, "mapq")
+		}
+	}
+}
+
+func median(vals []float64) float64 {
+	s := append([]float64{}, vals...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+const laneHeader = "bam\tsample\tflowcell\tlane\treads\tinsert_mean\tinsert_sd\tmapq_mean\tpct_unmapped\tpct_duplicate\tflags\n"
+
+// writeLanes writes a line for each lane of a bam.
+func writeLanes(w io.Writer, bamPath, sample string, lanes []LaneStats) error {
+	for _, l := range lanes {
+		flags := strings.Join(l.Flags, ",")
+		if flags == "" {
+			flags = "."
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%.2f\t%.2f\t%s\n", bamPath, sample, l.Flowcell, l.Lane, l.Reads,
+			naFloat(l.InsertMean), naFloat(l.InsertSD), naFloat(l.MapQMean), 100*l.ProportionUnmapped, 100*l.ProportionDuplicate, flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func naFloat(v float64) string {
+	if math.IsNaN(v) {
+		return "NA"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
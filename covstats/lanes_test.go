@@ -0,0 +1,97 @@
+package covstats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestReadNameLane(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		flowcell string
+		lane     int
+		ok       bool
+	}{
+		// Casava 1.8 and later, with and without the comment.
+		{"A00123:8:H5KJ3DSXX:2:1101:10004:10019", "H5KJ3DSXX", 2, true},
+		{"A00123:8:H5KJ3DSXX:3:1101:10004:10019 1:N:0:ATCACG", "H5KJ3DSXX", 3, true},
+		// older names use the instrument.
+		{"HWUSI-EAS100R:6:73:941:1973#0/1", "HWUSI-EAS100R", 6, true},
+		// not Illumina names.
+		{"SRR001666.1", "", 0, false},
+		{"V300012345L1C001R0010000001/1", "", 0, false},
+		{"m54006_160504_020705/4194360/ccs", "", 0, false},
+		{"A00123:8:H5KJ3DSXX:x:1101:10004:10019", "", 0, false},
+		{"A00123:8::2:1101:10004:10019", "", 0, false},
+	} {
+		flowcell, lane, ok := ReadNameLane(c.name)
+		if flowcell != c.flowcell || lane != c.lane || ok != c.ok {
+			t.Errorf("%s: expected %s %d %v, got %s %d %v", c.name, c.flowcell, c.lane, c.ok, flowcell, lane, ok)
+		}
+	}
+}
+
+func TestLaneCounter(t *testing.T) {
+	c := newLaneCounter()
+	for i := 0; i < 10; i++ {
+		c.add(&sam.Record{Name: "A1:1:FC1:1:1:1:1", MapQ: 60})
+		c.add(&sam.Record{Name: "A1:1:FC1:2:1:1:1", MapQ: 20, Flags: sam.Duplicate})
+	}
+	c.add(&sam.Record{Name: "A1:1:FC1:2:1:1:1", Flags: sam.Unmapped})
+	lanes := c.stats(false)
+	if len(lanes) != 2 {
+		t.Fatalf("expected 2 lanes, got %v", lanes)
+	}
+	if l := lanes[0]; l.Flowcell != "FC1" || l.Lane != 1 || l.Reads != 10 || l.MapQMean != 60 || l.ProportionDuplicate != 0 {
+		t.Errorf("unexpected lane 1: %+v", l)
+	}
+	if l := lanes[1]; l.Lane != 2 || l.Reads != 11 || !math.IsNaN(l.MapQMean) || math.Abs(l.ProportionUnmapped-1/11.) > 1e-9 ||
+		math.Abs(l.ProportionDuplicate-10/11.) > 1e-9 {
+		t.Errorf("unexpected lane 2: %+v", l)
+	}
+	if !math.IsNaN(lanes[0].InsertMean) {
+		t.Errorf("expected no insert size without mates, got %f", lanes[0].InsertMean)
+	}
+
+	// lanes are not reported unless most names are from Illumina.
+	c = newLaneCounter()
+	c.add(&sam.Record{Name: "A1:1:FC1:1:1:1:1"})
+	c.add(&sam.Record{Name: "SRR001666.1"})
+	if lanes := c.stats(false); lanes != nil {
+		t.Errorf("expected no lanes for mixed names, got %v", lanes)
+	}
+	if lanes := newLaneCounter().stats(false); lanes != nil {
+		t.Errorf("expected no lanes without reads, got %v", lanes)
+	}
+}
+
+func TestFlagLanes(t *testing.T) {
+	nan := math.NaN()
+	lanes := []LaneStats{
+		{Lane: 1, Reads: 5000, InsertMean: 300, MapQMean: 58},
+		{Lane: 2, Reads: 5000, InsertMean: 310, MapQMean: 57},
+		{Lane: 3, Reads: 5000, InsertMean: 400, MapQMean: 58},
+		{Lane: 4, Reads: 5000, InsertMean: 305, MapQMean: 40},
+		// too few reads to be flagged or to change the median.
+		{Lane: 5, Reads: 10, InsertMean: 1000, MapQMean: 0},
+		{Lane: 6, Reads: 5000, InsertMean: nan, MapQMean: 58},
+	}
+	flagLanes(lanes)
+	var got [][]string
+	for _, l := range lanes {
+		got = append(got, l.Flags)
+	}
+	exp := [][]string{nil, nil, {"insert"}, {"mapq"}, nil, nil}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected flags %v, got %v", exp, got)
+	}
+
+	// a single lane is never flagged.
+	one := []LaneStats{{Lane: 1, Reads: 5000, InsertMean: 300, MapQMean: 10}}
+	if flagLanes(one); one[0].Flags != nil {
+		t.Errorf("expected no flags for a single lane, got %v", one[0].Flags)
+	}
+}
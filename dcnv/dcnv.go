@@ -2,10 +2,8 @@ package main
 
 import (
 	"fmt"
-	"html/template"
 	"io"
 	"math"
-	"math/rand"
 	"os"
 	"strconv"
 	"strings"
@@ -13,10 +11,13 @@ import (
 	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 
+	arg "github.com/alexflint/go-arg"
 	"github.com/brentp/faidx"
-	chartjs "github.com/brentp/go-chartjs"
-	"github.com/brentp/go-chartjs/types"
 	"github.com/brentp/goleft/dcnv/debiaser"
+	"github.com/brentp/goleft/emdepth"
+	"github.com/brentp/goleft/genomes"
+	"github.com/brentp/goleft/grid"
+	"github.com/brentp/goleft/plots"
 	"github.com/brentp/xopen"
 	"go4.org/sort"
 )
@@ -36,6 +37,9 @@ type Intervals struct {
 	_depths []float64
 
 	GCB *GcBounds
+	// Genome, if set, gives the blacklist of regions whose windows are dropped.
+	Genome    *genomes.Genome
+	blacklist []genomes.Region
 
 	sampleMedians []float64
 	sampleScalars []float64
@@ -91,6 +95,11 @@ func (ivs *Intervals) addFromLine(l string, fa *faidx.Faidx, fp *faidx.FaPos) {
 	if ivs.GCB != nil && (st.GC > ivs.GCB.Max || st.GC < ivs.GCB.Min) {
 		return
 	}
+	for _, r := range ivs.blacklist {
+		if int(s) < r.End && int(e) > r.Start {
+			return
+		}
+	}
 
 	ivs.Starts = append(ivs.Starts, s)
 	ivs.Ends = append(ivs.Ends, e)
@@ -142,7 +151,7 @@ func median(b []float64) float64 {
 	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
 	if len(a)%2 == 0 {
 		am := a[len(a)/2]
-		bm := a[len(a)/2+1]
+		bm := a[len(a)/2-1]
 		return (am + bm) / 2
 	}
 	return a[len(a)/2]
@@ -177,6 +186,9 @@ func (ivs *Intervals) ReadRegions(path string, fasta string) {
 		if i == 0 || i == 1 {
 			ivs.Chrom = string(line[:strings.Index(line, "\t")])
 			fp.Chrom = ivs.Chrom
+			if ivs.Genome != nil {
+				ivs.blacklist = ivs.Genome.Regions(ivs.Genome.Blacklist, ivs.Chrom)
+			}
 		}
 		i++
 		ivs.addFromLine(line, fai, fp)
@@ -213,120 +225,38 @@ func Pipeliner(mat *mat.Dense, fns ...MatFn) {
 	}
 }
 
-// truncate depth values above this to cnMax
-const cnMax = 2.5
-
-type vs struct {
-	xs []float64
-	ys []float64
-}
-
-func (v *vs) Xs() []float64 {
-	return v.xs
-}
-
-func (v *vs) Ys() []float64 {
-	return v.ys
-}
-
-func (v *vs) Rs() []float64 {
-	return nil
-}
-
-func (v *vs) Len() int {
-	return len(v.xs)
-}
-
-// make it meet gonum/plot plotter.XYer
-
-func (v *vs) XY(i int) (x, y float64) {
-	return v.xs[i], v.ys[i]
-}
-
-func asValues(vals []float64, multiplier float64) chartjs.Values {
-
-	// skip until we find non-zero.
-	v := vs{xs: make([]float64, 0, len(vals)), ys: make([]float64, 0, len(vals))}
-	seenNonZero := false
-	for i, r := range vals {
-		if r == 0 && !seenNonZero {
-			continue
-		}
-		seenNonZero = true
-		v.xs = append(v.xs, float64(i)*multiplier)
-		if r > cnMax {
-			r = cnMax
-		}
-		v.ys = append(v.ys, float64(r))
-	}
-	return &v
-}
-
-func randomColor(s int) *types.RGBA {
-	rand.Seed(int64(s))
-	return &types.RGBA{
-		R: uint8(26 + rand.Intn(230)),
-		G: uint8(26 + rand.Intn(230)),
-		B: uint8(26 + rand.Intn(230)),
-		A: 240}
-}
-
+// plotDepths writes the depth plots of the samples (columns) in depths to $base-depth-$chrom.png and .html.
 func plotDepths(depths *mat.Dense, samples []string, chrom string, base string) error {
-	chart := chartjs.Chart{Label: chrom}
-	xa, err := chart.AddXAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Bottom, ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: "position on " + chrom, Display: chartjs.True}})
-	if err != nil {
-		return err
-	}
-	ya, err := chart.AddYAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Left,
-		Tick:       &chartjs.Tick{Min: 0, Max: 2.5},
-		ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: "scaled coverage", Display: chartjs.True}})
-	if err != nil {
-		return err
-	}
-
-	w := 0.4
 	r, nsamples := depths.Dims()
-	if nsamples > 30 {
-		w = 0.3
-	}
-	if nsamples > 50 {
-		w = 0.2
-	}
+	series := make([]plots.SampleSeries, nsamples)
 	depth := make([]float64, r)
-	for i := 0; i < nsamples; i++ {
+	for i := range series {
 		mat.Col(depth, i, depths)
-		xys := asValues(depth, 16384)
-		//log.Println(chrom, samples[i], len(xys.Xs()))
-		c := randomColor(i)
-		dataset := chartjs.Dataset{Data: xys, Label: samples[i], Fill: chartjs.False, PointRadius: 0, BorderWidth: w,
-			BorderColor: c, BackgroundColor: c, SteppedLine: chartjs.True, PointHitRadius: 6}
-		dataset.XAxisID = xa
-		dataset.YAxisID = ya
-		chart.AddDataset(dataset)
-	}
-	chart.Options.Responsive = chartjs.False
-	chart.Options.Tooltip = &chartjs.Tooltip{Mode: "nearest"}
-	wtr, err := os.Create(fmt.Sprintf("%s-depth-%s.html", base, chrom))
-	if err != nil {
-		return err
-	}
-	link := template.HTML(`<a href="index.html">back to index</a>`)
-	if err := chart.SaveHTML(wtr, map[string]interface{}{"width": 850, "height": 550, "customHTML": link}); err != nil {
-		return err
-	}
-	if err := wtr.Close(); err != nil {
-		return err
+		d := make([]float32, r)
+		for j, v := range depth {
+			d[j] = float32(v)
+		}
+		series[i] = plots.SampleSeries{Sample: samples[i], Depths: d}
 	}
-	return nil
+	return plots.Depths(series, chrom, grid.TileWidth, plots.Annotations{}, base, plots.Options{HTML: true})
 }
 
-func main() {
+var cli = struct {
+	Genome     string `arg:"help:bundled genome (e.g. hg38) whose blacklisted regions are dropped"`
+	GenomeFile string `arg:"--genome-file,help:JSON file in the format of the goleft genomes package whose blacklisted regions are dropped"`
+	Bed        string `arg:"positional,required,help:bed of the depth of each sample in windows of a chromosome"`
+	Fasta      string `arg:"positional,required,help:reference fasta for the GC content of the windows"`
+}{}
 
-	bed := os.Args[1]
-	fasta := os.Args[2]
-	ivs := &Intervals{}
+func main() {
+	p := arg.MustParse(&cli)
+	g, err := genomes.Resolve(cli.Genome, cli.GenomeFile)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	ivs := &Intervals{Genome: g}
 
-	ivs.ReadRegions(bed, fasta)
+	ivs.ReadRegions(cli.Bed, cli.Fasta)
 
 	db := debiaser.GeneralDebiaser{}
 	db.Window = 9
@@ -342,13 +272,51 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	plotDepths(ivs.Depths, ivs.Samples, ivs.Chrom, "dd")
+	if err := plotDepths(ivs.Depths, ivs.Samples, ivs.Chrom, "dd"); err != nil {
+		panic(err)
+	}
+	fcnv, err := os.Create("cnv.bed")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(fcnv, "#chrom\tstart\tend\tsample\tcn\tlog2fc\twindows")
+	// depths are normalized to 1 so they are scaled back to counts for the poisson in emdepth.
+	scale := float32(median(ivs.sampleMedians))
+	// only the calling is streamed. the GC correction and normalization above need all of ivs.Depths.
+	caller := emdepth.NewCaller(emdepth.Options{})
+	window := make([]float32, nsamples)
+
 	fmt.Fprintf(fdp, "#chrom\tstart\tend\t%s\n", strings.Join(ivs.Samples, "\t"))
 	for i := 0; i < nsites; i++ {
 		iv := ivs.Depths.RawRowView(i)
 		for si := range dps {
 			dps[si] = fmt.Sprintf("%.2f", iv[si])
+			window[si] = float32(iv[si]) * scale
 		}
 		fmt.Fprintf(fdp, "%s\t%d\t%d\t%s\n", ivs.Chrom, ivs.Starts[i], ivs.Ends[i], strings.Join(dps, "\t"))
+		if err := caller.Push(emdepth.Window{Chrom: ivs.Chrom, Position: emdepth.Position{Start: ivs.Starts[i], End: ivs.Ends[i]}, Depths: window}); err != nil {
+			panic(err)
+		}
+		writeCNVs(fcnv, caller.Ready(), ivs.Samples)
+	}
+	writeCNVs(fcnv, caller.Flush(), ivs.Samples)
+	if err := fdp.Close(); err != nil {
+		panic(err)
+	}
+	if err := fcnv.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// writeCNVs writes a line for each cnv with its median copy-number and log2 fold-change.
+func writeCNVs(w io.Writer, cnvs []*emdepth.CNV, samples []string) {
+	for _, c := range cnvs {
+		cns := make([]float64, len(c.CN))
+		fcs := make([]float64, len(c.Log2FC))
+		for i, cn := range c.CN {
+			cns[i], fcs[i] = float64(cn), float64(c.Log2FC[i])
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%.0f\t%.2f\t%d\n", c.Chrom, c.Position[0].Start, c.Position[len(c.Position)-1].End,
+			samples[c.SampleI], median(cns), median(fcs), len(c.Position))
 	}
 }
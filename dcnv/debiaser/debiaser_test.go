@@ -12,7 +12,7 @@ import (
 	"github.com/brentp/goleft/dcnv/scalers"
 )
 
-func fillMatrix(mat *mat.Dense) {
+func fillMatrix(imat *mat.Dense) {
 	r, c := imat.Dims()
 	for i := 0; i < r; i++ {
 		for j := 0; j < c; j++ {
@@ -59,9 +59,9 @@ func TestGeneralDebiasSort(t *testing.T) {
 		}
 	*/
 	if !reflect.DeepEqual(g.Vals, []float64{0, 1, 2, 3, 6, 8, 9, 10}) {
-		t.Fatalf("got %s", g.Vals)
+		t.Fatalf("got %v", g.Vals)
 	}
-	if reflect.DeepEqual(cpy, mat) {
+	if reflect.DeepEqual(cpy, imat) {
 		t.Fatalf("expected different matrix after sort")
 	}
 
@@ -72,7 +72,7 @@ func TestGeneralDebiasSort(t *testing.T) {
 		}
 	*/
 	if !reflect.DeepEqual(g.Vals, []float64{0, 1, 2, 3, 10, 9, 8, 6}) {
-		t.Fatalf("got %s", g.Vals)
+		t.Fatalf("got %v", g.Vals)
 	}
 	/*
 		fmt.Println("\nafter unsort")
@@ -158,7 +158,7 @@ func (l *Log2) UnScale(a *mat.Dense) {
 	for i := 0; i < r; i++ {
 		row := a.RawRowView(i)
 		for c, d := range row {
-			row[c] = math.Pow(2, d)
+			row[c] = math.Pow(2, d) - 1
 		}
 	}
 }
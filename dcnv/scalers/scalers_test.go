@@ -30,13 +30,13 @@ func TestZScoreRoundTrip(t *testing.T) {
 
 	zsc.Scale(imat)
 	for i := 0; i < r; i++ {
-		if math.Abs(stat.Mean(mat.RawRowView(i), nil)) > eps {
-			t.Fatalf("expected 0, got %f", stat.Mean(mat.RawRowView(i), nil))
+		if math.Abs(stat.Mean(imat.RawRowView(i), nil)) > eps {
+			t.Fatalf("expected 0, got %f", stat.Mean(imat.RawRowView(i), nil))
 		}
 	}
-	zsc.UnScale(mat)
+	zsc.UnScale(imat)
 	for i := 0; i < r; i++ {
-		row := mat.RawRowView(i)
+		row := imat.RawRowView(i)
 		for j := 0; j < c; j++ {
 			if math.Abs(row[j]-float64((i+1)*(j+1))) > eps {
 				log.Fatalf("expected: %f, got %f", float64((i+1)*(j+1)), row[j])
@@ -61,9 +61,12 @@ func TestLog2RoundTrip(t *testing.T) {
 	}
 
 	zsc.Scale(imat)
-	for i := 0; i < r; i++ {
-		if floats.Min(imat.RawRowView(i)) < 0 {
-			t.Fatalf("log2:expected >0, got %f", stat.Mean(imat.RawRowView(i), nil))
+	col := make([]float64, r)
+	for j := 0; j < c; j++ {
+		// each column is centered on its median.
+		mat.Col(col, j, imat)
+		if floats.Min(col) >= 0 || floats.Max(col) <= 0 {
+			t.Fatalf("log2: expected centered column, got %v", col)
 		}
 	}
 	zsc.UnScale(imat)
@@ -0,0 +1,238 @@
+package depth
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft"
+	"github.com/brentp/xopen"
+)
+
+// primer names end in one of these (optionally followed by e.g. _alt1) to give the side of the amplicon.
+const (
+	leftPrimer  = "_LEFT"
+	rightPrimer = "_RIGHT"
+)
+
+// amplicon holds the primer-trimmed depths of an amplicon.
+type amplicon struct {
+	name  string
+	chrom string
+	// start and end are the outer ends of the primers.
+	start, end int
+	// insertStart and insertEnd are the region between the primers. Only bases here are counted.
+	insertStart, insertEnd int
+	reads                  int
+	depths                 []int32
+}
+
+// ampliconCounter assigns each alignment to the amplicon it overlaps the most and counts its aligned
+// bases that are between the primers of that amplicon.
+type ampliconCounter struct {
+	amplicons []*amplicon
+	// byChrom is sorted by start.
+	byChrom map[string][]*amplicon
+	// longest is the length of the longest amplicon so that the search for overlaps can stop.
+	longest int
+}
+
+// primerSide returns the amplicon name and side of a primer name such as nCoV-2019_1_LEFT_alt1.
+func primerSide(name string) (string, string, bool) {
+	for _, side := range []string{leftPrimer, rightPrimer} {
+		if i := strings.LastIndex(name, side); i > 0 {
+			return name[:i], side, true
+		}
+	}
+	return "", "", false
+}
+
+// readPrimers reads a bed of primers with names (4th column) ending in _LEFT and _RIGHT and returns an
+// amplicon for each name in the order they are first seen. With alternate primers, the insert is between
+// the innermost primers.
+func readPrimers(path string) (*ampliconCounter, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	ac := &ampliconCounter{byChrom: make(map[string][]*amplicon)}
+	byName := make(map[string]*amplicon)
+	// has[name] is the sides found for each amplicon.
+	has := make(map[string]map[string]bool)
+	for i := 1; ; i++ {
+		line, err := rdr.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if l := bytes.TrimSpace(line); len(l) > 0 && l[0] != '#' && !bytes.HasPrefix(l, []byte("track")) {
+			toks := strings.Split(string(l), "\t")
+			if len(toks) < 4 {
+				return nil, fmt.Errorf("depth: expected a primer name in the 4th column at line %d of %s", i, path)
+			}
+			chrom, start, end, err := chromStartEndFromLine(l)
+			if err != nil {
+				return nil, fmt.Errorf("depth: line %d of %s: %s", i, path, err)
+			}
+			name, side, ok := primerSide(toks[3])
+			if !ok {
+				return nil, fmt.Errorf("depth: primer %s at line %d of %s does not end in %s or %s", toks[3], i, path, leftPrimer, rightPrimer)
+			}
+			a, ok := byName[name]
+			if !ok {
+				a = &amplicon{name: name, chrom: chrom, start: start, end: end, insertStart: -1, insertEnd: int(^uint(0) >> 1)}
+				byName[name] = a
+				has[name] = make(map[string]bool, 2)
+				ac.amplicons = append(ac.amplicons, a)
+			} else if a.chrom != chrom {
+				return nil, fmt.Errorf("depth: primers of %s are on %s and %s in %s", name, a.chrom, chrom, path)
+			}
+			a.start, a.end = min(a.start, start), max(a.end, end)
+			if side == leftPrimer {
+				a.insertStart = max(a.insertStart, end)
+			} else {
+				a.insertEnd = min(a.insertEnd, start)
+			}
+			has[name][side] = true
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(ac.amplicons) == 0 {
+		return nil, fmt.Errorf("depth: no primers found in %s", path)
+	}
+	for _, a := range ac.amplicons {
+		if !has[a.name][leftPrimer] || !has[a.name][rightPrimer] {
+			return nil, fmt.Errorf("depth: amplicon %s in %s needs both a %s and a %s primer", a.name, path, leftPrimer, rightPrimer)
+		}
+		if a.insertStart >= a.insertEnd {
+			return nil, fmt.Errorf("depth: the primers of amplicon %s in %s overlap", a.name, path)
+		}
+		a.depths = make([]int32, a.insertEnd-a.insertStart)
+		ac.byChrom[a.chrom] = append(ac.byChrom[a.chrom], a)
+		ac.longest = max(ac.longest, a.end-a.start)
+	}
+	for _, amps := range ac.byChrom {
+		sort.SliceStable(amps, func(i, j int) bool { return amps[i].start < amps[j].start })
+	}
+	return ac, nil
+}
+
+// best returns the amplicon that overlaps [start, end) on chrom the most. Ties go to the amplicon whose
+// start is closest to that of the alignment as reads start at a primer.
+func (ac *ampliconCounter) best(chrom string, start, end int) *amplicon {
+	amps := ac.byChrom[chrom]
+	// amplicons from i on start at or after end.
+	i := sort.Search(len(amps), func(i int) bool { return amps[i].start >= end })
+	var best *amplicon
+	var bestOv, bestDist int
+	for j := i - 1; j >= 0 && amps[j].start > start-ac.longest; j-- {
+		a := amps[j]
+		ov := min(end, a.end) - max(start, a.start)
+		if ov <= 0 {
+			continue
+		}
+		dist := start - a.start
+		if dist < 0 {
+			dist = -dist
+		}
+		if ov > bestOv || (ov == bestOv && dist < bestDist) {
+			best, bestOv, bestDist = a, ov, dist
+		}
+	}
+	return best
+}
+
+// add counts the bases of rec that are aligned (M, = or X) between the primers of its amplicon.
+func (ac *ampliconCounter) add(rec *sam.Record) {
+	a := ac.best(rec.Ref.Name(), rec.Pos, rec.End())
+	if a == nil {
+		return
+	}
+	a.reads++
+	pos := rec.Pos
+	for _, op := range rec.Cigar {
+		t := op.Type()
+		if t.Consumes().Reference == 0 {
+			continue
+		}
+		if t == sam.CigarMatch || t == sam.CigarEqual || t == sam.CigarMismatch {
+			s, e := max(pos, a.insertStart), min(pos+op.Len(), a.insertEnd)
+			for p := s; p < e; p++ {
+				a.depths[p-a.insertStart]++
+			}
+		}
+		pos += op.Len()
+	}
+}
+
+// readBam counts the alignments in the bam at path that pass the same filters as samtools depth.
+func (ac *ampliconCounter) readBam(path string, args dargs) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return goleft.InputErr(err)
+	}
+	defer f.Close()
+	b, err := bam.NewReader(f, 2)
+	if err != nil {
+		return goleft.InputErr(err)
+	}
+	defer b.Close()
+	for {
+		rec, err := b.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Ref == nil || rec.Flags&skipFlags != 0 || int(rec.MapQ) < args.Q {
+			continue
+		}
+		if args.Chrom != "" && rec.Ref.Name() != args.Chrom {
+			continue
+		}
+		ac.add(rec)
+	}
+}
+
+// write writes a line for each amplicon, in the order of the primer bed, with the insert, the number
+// of alignments assigned to it and the mean and minimum primer-trimmed depth and the proportion of the
+// insert with a depth of at least minCov.
+func (ac *ampliconCounter) write(path string, chrom string, minCov int) error {
+	fh, err := xopen.Wopen(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(fh, "#chrom\tstart\tend\tname\treads\tmean\tmin\tp.callable")
+	for _, a := range ac.amplicons {
+		if chrom != "" && a.chrom != chrom {
+			continue
+		}
+		var sum float64
+		var callable int
+		lo := a.depths[0]
+		for _, d := range a.depths {
+			sum += float64(d)
+			if int(d) >= minCov {
+				callable++
+			}
+			if d < lo {
+				lo = d
+			}
+		}
+		n := float64(len(a.depths))
+		fmt.Fprintf(fh, "%s\t%d\t%d\t%s\t%d\t%.4g\t%d\t%.4f\n", a.chrom, a.insertStart, a.insertEnd, a.name, a.reads, sum/n, lo, float64(callable)/n)
+	}
+	if err := fh.Flush(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
@@ -0,0 +1,144 @@
+package depth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePrimers(t *testing.T, dir, bed string) string {
+	path := filepath.Join(dir, "primers.bed")
+	if err := ioutil.WriteFile(path, []byte(bed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrimerSide(t *testing.T) {
+	for _, c := range []struct {
+		primer, name, side string
+		ok                 bool
+	}{
+		{"nCoV-2019_1_LEFT", "nCoV-2019_1", leftPrimer, true},
+		{"nCoV-2019_1_RIGHT_alt1", "nCoV-2019_1", rightPrimer, true},
+		{"amp_RIGHT_LEFT", "amp_RIGHT", leftPrimer, true},
+		{"_LEFT", "", "", false},
+		{"amp_1_left", "", "", false},
+	} {
+		name, side, ok := primerSide(c.primer)
+		if name != c.name || side != c.side || ok != c.ok {
+			t.Errorf("%s: expected %s %s %v, got %s %s %v", c.primer, c.name, c.side, c.ok, name, side, ok)
+		}
+	}
+}
+
+func TestReadPrimers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "amplicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ac, err := readPrimers(writePrimers(t, dir, `track name=primers
+# a scheme with an alternate primer and an amplicon on another chromosome.
+chr1	100	120	a_2_LEFT
+chr1	30	50	a_1_LEFT
+chr1	300	320	a_1_RIGHT
+chr1	35	55	a_1_LEFT_alt1
+chr1	295	318	a_1_RIGHT_alt1
+chr2	10	30	b_1_LEFT
+chr1	400	420	a_2_RIGHT
+chr2	200	220	b_1_RIGHT
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range ac.amplicons {
+		got = append(got, strings.Join([]string{a.name, a.chrom}, ":"))
+	}
+	if strings.Join(got, ",") != "a_2:chr1,a_1:chr1,b_1:chr2" {
+		t.Errorf("expected the amplicons in the order first seen, got %v", got)
+	}
+	// the insert is between the innermost primers and the amplicon spans the outermost.
+	a := ac.amplicons[1]
+	if a.start != 30 || a.end != 320 || a.insertStart != 55 || a.insertEnd != 295 || len(a.depths) != 240 {
+		t.Errorf("unexpected amplicon: %+v", a)
+	}
+	if amps := ac.byChrom["chr1"]; len(amps) != 2 || amps[0].name != "a_1" || amps[1].name != "a_2" {
+		t.Errorf("expected the amplicons of chr1 sorted by start, got %v", amps)
+	}
+	if ac.longest != 320 {
+		t.Errorf("expected the longest amplicon to be 320, got %d", ac.longest)
+	}
+
+	for _, c := range []struct {
+		bed string
+		err string
+	}{
+		{"chr1\t30\t50\n", "4th column"},
+		{"chr1\t30\t50\ta_1_FWD\n", "does not end in"},
+		{"chr1\t30\t50\ta_1_LEFT\n", "needs both"},
+		{"chr1\t30\t50\ta_1_LEFT\nchr2\t300\t320\ta_1_RIGHT\n", "are on chr1 and chr2"},
+		{"chr1\t30\t50\ta_1_LEFT\nchr1\t40\t60\ta_1_RIGHT\n", "overlap"},
+		{"# no primers\n", "no primers"},
+	} {
+		if _, err := readPrimers(writePrimers(t, dir, c.bed)); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: expected an error with %q, got %v", c.bed, c.err, err)
+		}
+	}
+}
+
+func TestBest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "amplicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// a tiled panel where the amplicons overlap by 100 bases and a long amplicon that ends past the next.
+	ac, err := readPrimers(writePrimers(t, dir, `chr1	0	20	t_1_LEFT
+chr1	380	400	t_1_RIGHT
+chr1	300	320	t_2_LEFT
+chr1	680	700	t_2_RIGHT
+chr1	600	620	t_3_LEFT
+chr1	980	1000	t_3_RIGHT
+chr1	2000	2020	long_LEFT
+chr1	4980	5000	long_RIGHT
+chr1	2100	2120	short_LEFT
+chr1	2280	2300	short_RIGHT
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		chrom      string
+		start, end int
+		exp        string
+	}{
+		{"chr1", 0, 150, "t_1"},
+		// overlaps t_1 by 100 and t_2 by 150.
+		{"chr1", 250, 450, "t_2"},
+		// overlaps both by 100: t_2 starts closer.
+		{"chr1", 300, 400, "t_2"},
+		// 30 bases of each: t_2 starts closer.
+		{"chr1", 350, 380, "t_2"},
+		{"chr1", 900, 1100, "t_3"},
+		// overlaps the long amplicon more than the short one inside it.
+		{"chr1", 2050, 2400, "long"},
+		{"chr1", 2100, 2300, "short"},
+		// the long amplicon starts well before the alignment.
+		{"chr1", 4000, 4100, "long"},
+		{"chr1", 1000, 1100, ""},
+		{"chr2", 0, 150, ""},
+	} {
+		got := ""
+		if a := ac.best(c.chrom, c.start, c.end); a != nil {
+			got = a.name
+		}
+		if got != c.exp {
+			t.Errorf("%s:%d-%d: expected %q, got %q", c.chrom, c.start, c.end, c.exp, got)
+		}
+	}
+}
@@ -2,6 +2,8 @@
 // 1) $prefix.callable.bed that contains collapsed per-base regions of NO/LOW/or CALLABLE coverage.
 // where low is < MinCov.
 // 2) $prefix.depth.bed that contains the average depth for each window interval specified by WindowSize.
+// 3) with ReportGaps, $prefix.gaps.bed of the merged NO_COVERAGE regions of at least that length.
+// 4) with Primers, $prefix.amplicons.bed of the depth of each amplicon excluding its primers.
 // TODO: output gc-content in depth windows.
 package depth
 
@@ -12,32 +14,58 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	arg "github.com/alexflint/go-arg"
 	"github.com/brentp/faidx"
 	"github.com/brentp/gargs/process"
+	"github.com/brentp/goleft"
+	"github.com/brentp/goleft/genomes"
+	"github.com/brentp/goleft/landing"
 	"github.com/brentp/xopen"
 	"github.com/fatih/color"
 )
 
 type dargs struct {
-	WindowSize   int       `arg:"-w,help:window size in which to calculate high-depth regions"`
-	MaxMeanDepth int       `arg:"-m,help:windows with depth > than this are high-depth. The default reports the depth of all regions."`
-	Ordered      bool      `arg:"-o,help:force output to be in same order as input even with -p."`
-	Q            int       `arg:"-Q,help:mapping quality cutoff"`
-	Chrom        string    `arg:"-c,help:optional chromosome to limit analysis"`
-	MinCov       int       `arg:"help:minimum depth considered callable"`
-	Stats        bool      `arg:"-s,help:report sequence stats [GC CpG masked] for each window"`
-	Reference    string    `arg:"-r,help:path to reference fasta"`
-	Processes    int       `arg:"-p,help:number of processors to parallelize."`
-	Bed          string    `arg:"-b,help:optional file of positions or regions to restrict depth calculations."`
-	Prefix       string    `arg:"required,help:prefix for output files depth.bed and callable.bed"`
-	Bam          string    `arg:"positional,required,help:bam for which to calculate depth"`
-	stdout       io.Writer `arg:"-"`
+	WindowSize   int           `arg:"-w,help:window size in which to calculate high-depth regions"`
+	MaxMeanDepth int           `arg:"-m,help:windows with depth > than this are high-depth. The default reports the depth of all regions."`
+	Ordered      bool          `arg:"-o,help:force output to be in same order as input even with -p."`
+	Q            int           `arg:"-Q,help:mapping quality cutoff"`
+	Chrom        string        `arg:"-c,help:optional chromosome to limit analysis"`
+	MinCov       int           `arg:"help:minimum depth considered callable"`
+	Stats        bool          `arg:"-s,help:report sequence stats [GC CpG masked] for each window"`
+	Reference    string        `arg:"-r,help:path to reference fasta"`
+	Processes    int           `arg:"-p,help:number of processors to parallelize."`
+	Bed          string        `arg:"-b,help:optional file of positions or regions to restrict depth calculations."`
+	ReportGaps   int           `arg:"--report-gaps,help:write regions without coverage of at least this many bases to $prefix.gaps.bed"`
+	GapMerge     int           `arg:"--gap-merge,help:with --report-gaps: merge regions without coverage that are within this many bases"`
+	Primers      string        `arg:"--primers,help:bed of amplicon primers named *_LEFT and *_RIGHT. write the primer-trimmed depth of each amplicon to $prefix.amplicons.bed"`
+	Genome       string        `arg:"help:bundled genome (e.g. hg38) whose chromosomes are the only ones reported without --bed"`
+	GenomeFile   string        `arg:"--genome-file,help:JSON file in the format of the goleft genomes package whose chromosomes are the only ones reported without --bed"`
+	Prefix       string        `arg:"required,help:prefix for output files depth.bed and callable.bed"`
+	LockWait     time.Duration `arg:"--lock-wait,help:how long to wait for another run with the same output prefix to finish (e.g. 30m). by default exit with 30 at once"`
+	Bam          string        `arg:"positional,required,help:bam for which to calculate depth. use - to stream a coordinate-sorted BAM or SAM from stdin"`
+	stdout       io.Writer     `arg:"-"`
+	// genome is from Genome or GenomeFile.
+	genome *genomes.Genome `arg:"-"`
+}
+
+// reported returns true if chrom is reported when the whole reference is tiled: it is --chrom if that
+// is given and one of the chromosomes (or their aliases) of --genome if that is given.
+func (args dargs) reported(chrom string) bool {
+	if args.Chrom != "" && chrom != args.Chrom {
+		return false
+	}
+	if args.genome != nil {
+		_, ok := args.genome.Chromosome(chrom)
+		return ok
+	}
+	return true
 }
 
 // we echo the region first so the callback knows the full extents even if there is NOTE
@@ -49,12 +77,6 @@ var step = 10000000
 
 var exitCode = 0
 
-func pcheck(e error) {
-	if e != nil {
-		log.Fatal(e)
-	}
-}
-
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -72,90 +94,123 @@ func max(a, b int) int {
 // match chrom:start-end and chrom\tstart\tend
 var re = regexp.MustCompile("(.+?)[:\t](\\d+)([\\-\t])(\\d+).*?")
 
-func chromStartEndFromLine(line []byte) (string, int, int) {
+func chromStartEndFromLine(line []byte) (string, int, int, error) {
 	ret := re.FindSubmatch(line)
 	if len(ret) != 5 {
-		log.Fatal("couldn't get region from line", string(line))
+		return "", 0, 0, fmt.Errorf("couldn't get region from line %s", bytes.TrimSpace(line))
 	}
 	chrom, start, isep, end := ret[1], ret[2], ret[3], ret[4]
 	// convert from bed to chrom:start-end region so add 1 to start
 	istart, err := strconv.Atoi(string(start))
 	if err != nil {
-		log.Fatal(err)
+		return "", 0, 0, err
 	}
 	if bytes.Equal(isep, []byte{'-'}) {
 		istart--
 	}
 	iend, err := strconv.Atoi(string(end))
 	if err != nil {
-		log.Fatal(err)
+		return "", 0, 0, err
 	}
-	return string(chrom), max(istart, 0), iend
+	return string(chrom), max(istart, 0), iend, nil
 }
 
-func regionFromLine(line []byte) string {
-	chrom, start, end := chromStartEndFromLine(line)
+func regionFromLine(line []byte) (string, error) {
+	chrom, start, end, err := chromStartEndFromLine(line)
 	// convert from bed to chrom:start-end region so add 1 to start
-	return fmt.Sprintf("%s:%d-%d", chrom, start+1, end)
+	return fmt.Sprintf("%s:%d-%d", chrom, start+1, end), err
 }
 
 // when the user specified a Bed file of regions for coverage, this is used.
-func genFromBed(ch chan string, args dargs) {
+func genFromBed(ch chan string, args dargs) error {
 	rdr, err := xopen.Ropen(args.Bed)
-	pcheck(err)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
 	for {
 		line, err := rdr.ReadBytes('\n')
 		if err == io.EOF {
 			break
 		}
-		pcheck(err)
+		if err != nil {
+			return err
+		}
 		if len(line) == 0 {
 			continue
 		}
-		region := regionFromLine(line)
+		region, err := regionFromLine(line)
+		if err != nil {
+			return goleft.InputErr(fmt.Errorf("depth: %s: %s", args.Bed, err))
+		}
 		ch <- fmt.Sprintf(command, region, args.Q, args.MaxMeanDepth+2500,
 			region, args.Bam)
 	}
-	close(ch)
+	return nil
 }
 
-func genCommands(args dargs) chan string {
+// genCommands sends the command for each region to the returned channel and closes it. An error that
+// stops it is sent to the error channel before the commands channel is closed.
+func genCommands(args dargs) (chan string, <-chan error) {
 	ch := make(chan string)
+	errc := make(chan error, 1)
+	gen := genFromFai
 	if args.Bed != "" {
-		go genFromBed(ch, args)
-		return ch
+		gen = genFromBed
 	}
-
 	go func() {
-		// make sure step jives with windowsize otherwise we get WindowSize
-		// where it doesn't have the right size
-		step = max(1, step/args.WindowSize) * args.WindowSize
-
-		rdr, err := xopen.Ropen(args.Reference + ".fai")
-		pcheck(err)
-		for {
-			line, err := rdr.ReadString('\n')
-			if err == io.EOF {
-				break
-			}
-			pcheck(err)
-			toks := strings.Split(line, "\t")
-
-			chrom := toks[0]
-			if args.Chrom != "" && chrom != args.Chrom {
-				continue
-			}
-			length, err := strconv.Atoi(toks[1])
-			pcheck(err)
-			for i := 0; i < length; i += step {
-				region := fmt.Sprintf("%s:%d-%d", chrom, i+1, min(i+step, length))
-				ch <- fmt.Sprintf(command, region, args.Q, args.MaxMeanDepth+2500,
-					region, args.Bam)
-			}
+		defer close(ch)
+		if err := gen(ch, args); err != nil {
+			errc <- err
 		}
-		close(ch)
 	}()
-	return ch
+	return ch, errc
+}
+
+// genFromFai sends the commands that tile the reference.
+func genFromFai(ch chan string, args dargs) error {
+	// make sure step jives with windowsize otherwise we get WindowSize
+	// where it doesn't have the right size
+	step = max(1, step/args.WindowSize) * args.WindowSize
+
+	rdr, err := xopen.Ropen(args.Reference + ".fai")
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	n := 0
+	for {
+		line, err := rdr.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		toks := strings.Split(line, "\t")
+
+		chrom := toks[0]
+		if !args.reported(chrom) {
+			continue
+		}
+		n++
+		if len(toks) < 2 {
+			return goleft.InputErr(fmt.Errorf("depth: expected a length for %s in %s.fai", chrom, args.Reference))
+		}
+		length, err := strconv.Atoi(toks[1])
+		if err != nil {
+			return goleft.InputErr(fmt.Errorf("depth: bad length for %s in %s.fai: %s", chrom, args.Reference, err))
+		}
+		for i := 0; i < length; i += step {
+			region := fmt.Sprintf("%s:%d-%d", chrom, i+1, min(i+step, length))
+			ch <- fmt.Sprintf(command, region, args.Q, args.MaxMeanDepth+2500,
+				region, args.Bam)
+		}
+	}
+	if n == 0 && args.genome != nil {
+		log.Printf("depth: WARNING: no chromosomes of %s found in %s.fai", args.genome.Name, args.Reference)
+	}
+	return nil
 }
 
 // Main is run from the dispatcher
@@ -169,11 +224,69 @@ func Main() {
 	if args.Prefix == "" {
 		p.Fail("you must specify an output prefix")
 	}
+	if args.ReportGaps < 0 || args.GapMerge < 0 {
+		p.Fail("--report-gaps and --gap-merge must not be negative")
+	}
+	if args.GapMerge > 0 && args.ReportGaps == 0 {
+		p.Fail("--gap-merge requires --report-gaps")
+	}
+	if args.Primers != "" && strings.Contains(args.Bam, "://") {
+		p.Fail("--primers requires a local bam or - for stdin")
+	}
+	g, err := genomes.Resolve(args.Genome, args.GenomeFile)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	args.genome = g
+	if err := checkInputs(args); err != nil {
+		goleft.Fatal(err)
+	}
+	lock, err := goleft.AcquireLock(args.Prefix, args.LockWait)
+	if err != nil {
+		goleft.Fatal(err)
+	}
 	runtime.GOMAXPROCS(args.Processes)
-	run(args)
+	err = run(args)
+	if err == nil {
+		// a failure to write the landing page does not fail the run.
+		if _, lerr := landing.Update(filepath.Dir(args.Prefix)); lerr != nil {
+			log.Printf("depth: WARNING: %s", lerr)
+		}
+	}
+	// os.Exit does not run deferred calls so the lock is released before any exit.
+	lock.Release()
+	if err != nil {
+		goleft.Fatal(err)
+	}
 	os.Exit(exitCode)
 }
 
+// checkInputs returns an input error if a file that is needed for the run can not be read.
+func checkInputs(args dargs) error {
+	paths := []string{args.Bed, args.Primers}
+	if args.Bam != "-" {
+		paths = append(paths, args.Bam)
+		if args.Bed == "" {
+			paths = append(paths, args.Reference+".fai")
+		}
+	}
+	if args.Stats {
+		paths = append(paths, args.Reference)
+	}
+	for _, p := range paths {
+		// samtools reads urls itself.
+		if p == "" || strings.Contains(p, "://") {
+			continue
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return goleft.InputErr(fmt.Errorf("depth: %s", err))
+		}
+		f.Close()
+	}
+	return nil
+}
+
 type ipos struct {
 	start int
 }
@@ -233,9 +346,11 @@ func getCovClass(depth, minCov, maxMeanDepth int) string {
 	return "CALLABLE"
 }
 
-func run(args dargs) {
-
-	callback := func(r io.Reader, w io.WriteCloser) error {
+// regionCallback returns the function that reads the output of a single command from genCommands (the
+// echo'd region followed by samtools depth lines) and writes the paths of the temporary callable and depth
+// beds that it created to w.
+func regionCallback(args dargs) func(r io.Reader, w io.WriteCloser) error {
+	return func(r io.Reader, w io.WriteCloser) error {
 		rdr := bufio.NewReader(r)
 		wtr := bufio.NewWriter(w)
 		defer w.Close()
@@ -259,7 +374,10 @@ func run(args dargs) {
 			return err
 		}
 		// this is the bounds of the region echo'd before the samtools depth call.
-		chrom, regionStart, regionEnd := chromStartEndFromLine(region)
+		chrom, regionStart, regionEnd, err := chromStartEndFromLine(region)
+		if err != nil {
+			return err
+		}
 		lastWindow := max(0, regionStart/args.WindowSize)
 		var cache [2]ipos
 		cache[0].start = regionStart - 1
@@ -362,7 +480,22 @@ func run(args dargs) {
 		wtr.Flush()
 		return w.Close()
 	}
+}
+
+// appendTmp copies the temporary bed at path to dst and removes it.
+func appendTmp(dst io.Writer, path string) error {
+	path = strings.TrimSpace(path)
+	src, err := xopen.Ropen(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	src.Close()
+	os.Remove(path)
+	return err
+}
 
+func run(args dargs) error {
 	cancel := make(chan bool)
 	defer close(cancel)
 	var stdout io.Writer
@@ -383,19 +516,37 @@ func run(args dargs) {
 	if args.Chrom != "" {
 		chrom = "." + args.Chrom
 	}
-	fhca, err := xopen.Wopen(fmt.Sprintf("%s%s.callable.bed", args.Prefix, chrom))
-	pcheck(err)
+	caPath := fmt.Sprintf("%s%s.callable.bed", args.Prefix, chrom)
+	fhca, err := xopen.Wopen(caPath)
+	if err != nil {
+		return err
+	}
+	defer fhca.Close()
 	fhhd, err := xopen.Wopen(fmt.Sprintf("%s%s.depth.bed", args.Prefix, chrom))
-	pcheck(err)
-	defer fhca.Flush()
-	defer fhhd.Flush()
-	opts := process.Options{Retries: 1, CallBack: callback, Ordered: args.Ordered}
+	if err != nil {
+		return err
+	}
+	defer fhhd.Close()
+	var amps *ampliconCounter
+	if args.Primers != "" {
+		if amps, err = readPrimers(args.Primers); err != nil {
+			return goleft.InputErr(err)
+		}
+	}
+	if args.Bam == "-" {
+		if err := stream(os.Stdin, args, fhca, fhhd, amps); err != nil {
+			return err
+		}
+		return finish(args, fhca, fhhd, caPath, chrom, amps, false)
+	}
+	opts := process.Options{Retries: 1, CallBack: regionCallback(args), Ordered: args.Ordered}
 
-	for cmd := range process.Runner(genCommands(args), cancel, &opts) {
+	cmds, errc := genCommands(args)
+	for cmd := range process.Runner(cmds, cancel, &opts) {
 		if ex := cmd.ExitCode(); ex != 0 && cmd.Err != io.EOF {
 			c := color.New(color.BgRed).Add(color.Bold)
 			fmt.Fprintf(os.Stderr, "%s\n", c.SprintFunc()(fmt.Sprintf("ERROR with command: %s", cmd)))
-			exitCode = max(exitCode, ex)
+			exitCode = goleft.ExitError
 		}
 		if cmd.Err == io.EOF {
 			continue
@@ -404,23 +555,55 @@ func run(args dargs) {
 		if err != nil {
 			log.Println(cmd.CmdStr, err, cmd.Err)
 		}
-		caSrc, err := xopen.Ropen(strings.TrimSpace(caPath))
-		pcheck(err)
-		io.Copy(fhca, caSrc)
-		os.Remove(strings.TrimSpace(caPath))
+		if err := appendTmp(fhca, caPath); err != nil {
+			return err
+		}
 
 		hdPath, err := cmd.ReadString('\n')
 		if err != nil {
 			log.Println(err)
 		}
-		hdSrc, err := xopen.Ropen(strings.TrimSpace(hdPath))
-		pcheck(err)
-		io.Copy(fhhd, hdSrc)
-		os.Remove(strings.TrimSpace(hdPath))
+		if err := appendTmp(fhhd, hdPath); err != nil {
+			return err
+		}
 		cmd.Cleanup()
 	}
-	fhca.Flush()
-	fhca.Close()
-	fhhd.Flush()
-	fhhd.Close()
+	// the commands channel is closed after any error is sent.
+	select {
+	case err := <-errc:
+		return err
+	default:
+	}
+	return finish(args, fhca, fhhd, caPath, chrom, amps, true)
+}
+
+// finish closes the callable and depth beds and writes the gaps and amplicons that are made from them.
+// With reread, the alignments are read again for the amplicons as samtools depth can not trim primers.
+func finish(args dargs, fhca, fhhd *xopen.Writer, caPath, chrom string, amps *ampliconCounter, reread bool) error {
+	if err := fhca.Close(); err != nil {
+		return err
+	}
+	if err := fhhd.Close(); err != nil {
+		return err
+	}
+	if err := reportGaps(args, caPath, chrom); err != nil {
+		return err
+	}
+	if amps == nil {
+		return nil
+	}
+	if reread {
+		if err := amps.readBam(args.Bam, args); err != nil {
+			return err
+		}
+	}
+	return amps.write(fmt.Sprintf("%s%s.amplicons.bed", args.Prefix, chrom), args.Chrom, args.MinCov)
+}
+
+// reportGaps writes $prefix.gaps.bed from the callable.bed at caPath if requested.
+func reportGaps(args dargs, caPath, chrom string) error {
+	if args.ReportGaps == 0 {
+		return nil
+	}
+	return writeGaps(caPath, fmt.Sprintf("%s%s.gaps.bed", args.Prefix, chrom), args)
 }
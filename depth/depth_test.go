@@ -0,0 +1,35 @@
+package depth
+
+import (
+	"testing"
+
+	"github.com/brentp/goleft/genomes"
+)
+
+func TestReported(t *testing.T) {
+	g, err := genomes.Get("hg38")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		chrom  string
+		genome *genomes.Genome
+		only   string
+		exp    bool
+	}{
+		{"chrUn_KI270302v1", nil, "", true},
+		{"chr2", nil, "chr1", false},
+		// with a genome, only its chromosomes by any of their names.
+		{"chr1", g, "", true},
+		{"1", g, "", true},
+		{"chrUn_KI270302v1", g, "", false},
+		{"chr1_KI270706v1_random", g, "", false},
+		{"chrEBV", g, "", false},
+		{"chr1", g, "chr2", false},
+	} {
+		args := dargs{Chrom: c.only, genome: c.genome}
+		if got := args.reported(c.chrom); got != c.exp {
+			t.Errorf("%s with genome %v and --chrom %q: expected %v, got %v", c.chrom, c.genome != nil, c.only, c.exp, got)
+		}
+	}
+}
@@ -53,6 +53,34 @@ assert_equal "$(check_uniq x.callable.bed)" "OK"
 run compare_to_samtools_100 python test/cmp.py x.depth.bed test/t.bam
 assert_exit_code 0
 
+# streaming the bam from stdin gives the same output as the indexed bam.
+run check_stdin bash -c "samtools view -u test/t.bam | ./goleft depth -Q 1 --windowsize 100 --stats --prefix xs --reference test/hg19.fa -"
+assert_exit_code 0
+assert_equal "$(diff x.depth.bed xs.depth.bed)" ""
+assert_equal "$(diff x.callable.bed xs.callable.bed)" ""
+
+run check_stdin_bed bash -c "samtools view -u test/t.bam | ./goleft depth --bed test/windows.bed -Q 1 --windowsize 10 --prefix xs -"
+assert_exit_code 0
+./goleft depth --bed test/windows.bed -Q 1 --ordered --windowsize 10 --prefix xb --reference test/hg19.fa test/t.bam
+assert_equal "$(diff xb.depth.bed xs.depth.bed)" ""
+assert_equal "$(diff xb.callable.bed xs.callable.bed)" ""
+rm -f xs.* xb.*
+
+# the amplicons are far apart so every alignment over an insert is assigned to its amplicon and the
+# primer-trimmed depth is that of samtools depth over the insert.
+expected_amplicons() {
+    printf "chrM\t100\t1000\tamp_1\nchrM\t2005\t5000\tamp_2\n" | while read chrom start end name; do
+        samtools depth -a -Q 1 -r $chrom:$((start+1))-$end test/t.bam \
+            | awk -v OFS='\t' -v c=$chrom -v s=$start -v e=$end -v n=$name \
+                '{ sum += $3; if (NR == 1 || $3 < lo) { lo = $3 } } END { printf "%s\t%d\t%d\t%s\t%.4g\t%d\n", c, s, e, n, sum / NR, lo }'
+    done
+}
+run check_amplicons ./goleft depth -Q 1 --ordered --windowsize 100 --primers test/primers.bed --prefix xa --reference test/hg19.fa test/t.bam
+assert_exit_code 0
+assert_equal "$(head -1 xa.amplicons.bed)" "$(printf '#chrom\tstart\tend\tname\treads\tmean\tmin\tp.callable')"
+assert_equal "$(tail -n +2 xa.amplicons.bed | cut -f 1-4,6,7)" "$(expected_amplicons)"
+rm -f xa.*
+
 run check_wgs_big_window ./goleft depth -Q 1 --ordered --windowsize 1000000000 --stats --prefix x --reference test/hg19.fa test/t.bam
 assert_exit_code 0
 assert_equal "$(check_with_fai_bt test/hg19.fa.fai x.depth.bed)" ""
@@ -115,6 +143,17 @@ assert_equal "$(check_uniq x.depth.bed bed)" "OK"
 assert_equal "$(check_uniq x.callable.bed bed)" "OK"
 
 
+run check_gaps ./goleft depth --report-gaps 20 -Q 1 --ordered --windowsize 100 --prefix x --reference test/hg19.fa test/t.bam
+assert_exit_code 0
+# without --gap-merge, every gap is NO_COVERAGE in the callable.bed and at least 20 bases.
+assert_equal "$(grep -v ^# x.gaps.bed | bedtools subtract -a - -b <(grep NO_COVERAGE x.callable.bed))" ""
+assert_equal "$(grep -v ^# x.gaps.bed | awk '$3 - $2 < 20')" ""
+assert_equal "$(check_uniq x.gaps.bed)" "OK"
+
+run check_gaps_merge ./goleft depth --report-gaps 20 --gap-merge 10 -Q 1 --ordered --windowsize 100 --prefix x --reference test/hg19.fa test/t.bam
+assert_exit_code 0
+assert_equal "$(grep -v ^# x.gaps.bed | bedtools merge -d 10 -i - | wc -l)" "$(grep -vc ^# x.gaps.bed)"
+
 run check_hla ./goleft depth -r test/fake.fa --prefix /tmp/xx test/hla.bam
 assert_exit_code 0
 
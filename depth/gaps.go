@@ -0,0 +1,110 @@
+package depth
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/goleft/samplename"
+	"github.com/brentp/xopen"
+)
+
+// gap is a region without coverage.
+type gap struct {
+	chrom      string
+	start, end int
+}
+
+// readGaps returns the NO_COVERAGE regions from a callable.bed. The regions are sorted by start
+// within each chromosome and chromosomes are in the order they are first seen as the output is
+// not sorted when it is written in parallel without --ordered.
+func readGaps(path string) ([]gap, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	order := make(map[string]int)
+	var gaps []gap
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if toks := strings.Split(strings.TrimRight(line, "\r\n"), "\t"); len(toks) == 4 && toks[3] == "NO_COVERAGE" {
+			g := gap{chrom: toks[0]}
+			if g.start, err = strconv.Atoi(toks[1]); err != nil {
+				return nil, fmt.Errorf("depth: bad start in %s: %s", path, line)
+			}
+			if g.end, err = strconv.Atoi(toks[2]); err != nil {
+				return nil, fmt.Errorf("depth: bad end in %s: %s", path, line)
+			}
+			if _, ok := order[g.chrom]; !ok {
+				order[g.chrom] = len(order)
+			}
+			gaps = append(gaps, g)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	sort.SliceStable(gaps, func(i, j int) bool {
+		if gaps[i].chrom != gaps[j].chrom {
+			return order[gaps[i].chrom] < order[gaps[j].chrom]
+		}
+		return gaps[i].start < gaps[j].start
+	})
+	return gaps, nil
+}
+
+// mergeGaps merges gaps on the same chromosome that are separated by at most dist bases (so adjacent
+// gaps, as at the boundaries of the chunks that are run in parallel, are always merged) and then
+// drops those shorter than minLen.
+func mergeGaps(gaps []gap, dist, minLen int) []gap {
+	var merged []gap
+	for _, g := range gaps {
+		if n := len(merged); n > 0 && merged[n-1].chrom == g.chrom && g.start-merged[n-1].end <= dist {
+			if g.end > merged[n-1].end {
+				merged[n-1].end = g.end
+			}
+			continue
+		}
+		merged = append(merged, g)
+	}
+	kept := merged[:0]
+	for _, g := range merged {
+		if g.end-g.start >= minLen {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
+
+// writeGaps writes the NO_COVERAGE regions in the callable.bed at callable, merged within
+// args.GapMerge bases and of at least args.ReportGaps bases, to path with their length and the
+// sample name so that the files of several samples can be concatenated.
+func writeGaps(callable, path string, args dargs) error {
+	gaps, err := readGaps(callable)
+	if err != nil {
+		return err
+	}
+	sample := samplename.FromPath(args.Bam)
+	if args.Bam == "-" {
+		sample = samplename.FromPath(args.Prefix)
+	}
+	fh, err := xopen.Wopen(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(fh, "#chrom\tstart\tend\tlength\tsample")
+	for _, g := range mergeGaps(gaps, args.GapMerge, args.ReportGaps) {
+		fmt.Fprintf(fh, "%s\t%d\t%d\t%d\t%s\n", g.chrom, g.start, g.end, g.end-g.start, sample)
+	}
+	if err := fh.Flush(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
@@ -62,7 +62,10 @@ func ReadTree(ps ...string) map[string]*interval.IntTree {
 				panic(err)
 			}
 
-			chrom, start, end := chromStartEndFromLine(line)
+			chrom, start, end, err := chromStartEndFromLine(line)
+			if err != nil {
+				panic(err)
+			}
 			if start >= end {
 				continue
 			}
@@ -0,0 +1,345 @@
+package depth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft"
+	"github.com/brentp/xopen"
+)
+
+// when streaming, alignments with any of these flags are skipped as they are by samtools depth.
+const skipFlags = sam.Unmapped | sam.Secondary | sam.QCFail | sam.Duplicate
+
+type recordReader interface {
+	Read() (*sam.Record, error)
+}
+
+type region struct {
+	chrom      string
+	start, end int
+}
+
+// openRegion is a region whose depths are being sent to a regionCallback.
+type openRegion struct {
+	region
+	pw   *io.PipeWriter
+	w    *bufio.Writer
+	out  *pathBuffer
+	done chan error
+}
+
+// pathBuffer receives the paths of the temporary beds written by the callback.
+type pathBuffer struct{ bytes.Buffer }
+
+func (p *pathBuffer) Close() error { return nil }
+
+// streamer computes per-base depth from a coordinate-sorted stream of alignments in a single pass
+// without an index. Depths are sent, in the same format as samtools depth, to a regionCallback for each
+// region so that the output is the same as for an indexed bam.
+type streamer struct {
+	args     dargs
+	callback func(io.Reader, io.WriteCloser) error
+	fhca     io.Writer
+	fhhd     io.Writer
+
+	chroms  []string
+	regions map[string][]region
+	ichrom  int
+
+	chrom   string
+	pending []region
+	active  []*openRegion
+
+	// depths[i] is the depth at off+i. positions before off are final.
+	off    int
+	depths []int32
+}
+
+// stream reads a BAM or SAM from r and writes the callable and depth beds to fhca and fhhd. If amps is
+// not nil, the alignments are also counted for each amplicon.
+func stream(r io.Reader, args dargs, fhca, fhhd io.Writer, amps *ampliconCounter) error {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return goleft.InputErr(fmt.Errorf("depth: error reading from stdin: %s", err))
+	}
+	var rdr recordReader
+	var hdr *sam.Header
+	// BAM is always bgzf-compressed, even for samtools view -u.
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		b, err := bam.NewReader(br, 2)
+		if err != nil {
+			return goleft.InputErr(err)
+		}
+		defer b.Close()
+		rdr, hdr = b, b.Header()
+	} else {
+		s, err := sam.NewReader(br)
+		if err != nil {
+			return goleft.InputErr(err)
+		}
+		rdr, hdr = s, s.Header()
+	}
+	if hdr == nil || len(hdr.Refs()) == 0 {
+		return goleft.InputErr(fmt.Errorf("depth: no references found in the header from stdin"))
+	}
+
+	s := &streamer{args: args, callback: regionCallback(args), fhca: fhca, fhhd: fhhd,
+		regions: make(map[string][]region), ichrom: -1}
+	if err := s.setRegions(hdr.Refs()); err != nil {
+		return goleft.InputErr(err)
+	}
+	index := make(map[string]int, len(s.chroms))
+	for i, c := range s.chroms {
+		index[c] = i
+	}
+
+	n := 0
+	for {
+		rec, err := rdr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return goleft.InputErr(err)
+		}
+		if rec.Ref == nil || rec.Flags&skipFlags != 0 || int(rec.MapQ) < args.Q {
+			continue
+		}
+		if amps != nil && (args.Chrom == "" || rec.Ref.Name() == args.Chrom) {
+			amps.add(rec)
+		}
+		if rec.Ref.Name() != s.chrom {
+			i, ok := index[rec.Ref.Name()]
+			if !ok {
+				// not in --chrom or the bed.
+				continue
+			}
+			if i < s.ichrom {
+				return goleft.InputErr(fmt.Errorf("depth: input from stdin must be sorted by coordinate. found %s after %s", rec.Ref.Name(), s.chrom))
+			}
+			if err := s.toChrom(i); err != nil {
+				return err
+			}
+		}
+		if rec.Pos < s.off {
+			return goleft.InputErr(fmt.Errorf("depth: input from stdin must be sorted by coordinate. found %s:%d after %s:%d", s.chrom, rec.Pos+1, s.chrom, s.off+1))
+		}
+		if err := s.flush(rec.Pos); err != nil {
+			return err
+		}
+		s.add(rec)
+		n++
+	}
+	if n == 0 {
+		log.Println("depth: no alignments found on stdin")
+	}
+	return s.toChrom(len(s.chroms))
+}
+
+// setRegions sets the regions to report from the --bed or from the references in the header.
+func (s *streamer) setRegions(refs []*sam.Reference) error {
+	if s.args.Bed == "" {
+		for _, ref := range refs {
+			if !s.args.reported(ref.Name()) {
+				continue
+			}
+			s.chroms = append(s.chroms, ref.Name())
+			s.regions[ref.Name()] = []region{{ref.Name(), 0, ref.Len()}}
+		}
+		if len(s.chroms) == 0 && s.args.genome != nil {
+			return fmt.Errorf("depth: no chromosomes of %s found in the header from stdin", s.args.genome.Name)
+		}
+		if len(s.chroms) == 0 {
+			return fmt.Errorf("depth: chromosome %s not found in the header from stdin", s.args.Chrom)
+		}
+		return nil
+	}
+	rdr, err := xopen.Ropen(s.args.Bed)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	for {
+		line, err := rdr.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		chrom, start, end, err := chromStartEndFromLine(line)
+		if err != nil {
+			return goleft.InputErr(fmt.Errorf("depth: %s: %s", s.args.Bed, err))
+		}
+		if s.args.Chrom != "" && chrom != s.args.Chrom {
+			continue
+		}
+		s.regions[chrom] = append(s.regions[chrom], region{chrom, start, end})
+	}
+	found := 0
+	for _, ref := range refs {
+		regs, ok := s.regions[ref.Name()]
+		if !ok {
+			continue
+		}
+		kept := regs[:0]
+		for _, r := range regs {
+			if r.end = min(r.end, ref.Len()); r.start < r.end {
+				kept = append(kept, r)
+			}
+		}
+		regs = kept
+		s.regions[ref.Name()] = regs
+		sort.SliceStable(regs, func(i, j int) bool { return regs[i].start < regs[j].start })
+		s.chroms = append(s.chroms, ref.Name())
+		found += len(regs)
+	}
+	for chrom := range s.regions {
+		if _, ok := s.index(chrom); !ok {
+			log.Printf("depth: skipping regions on %s which is not in the header from stdin", chrom)
+		}
+	}
+	if found == 0 {
+		return fmt.Errorf("depth: no regions from %s are on chromosomes in the header from stdin", s.args.Bed)
+	}
+	return nil
+}
+
+func (s *streamer) index(chrom string) (int, bool) {
+	for i, c := range s.chroms {
+		if c == chrom {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// toChrom finishes the current chromosome and any without alignments before chroms[i].
+func (s *streamer) toChrom(i int) error {
+	for ; s.ichrom < i; s.ichrom++ {
+		if s.chrom != "" {
+			if err := s.flush(s.off + len(s.depths)); err != nil {
+				return err
+			}
+			if err := s.advance(int(^uint(0) >> 1)); err != nil {
+				return err
+			}
+		}
+		s.chrom, s.off, s.depths = "", 0, s.depths[:0]
+		if s.ichrom+1 < len(s.chroms) {
+			s.chrom = s.chroms[s.ichrom+1]
+			s.pending = s.regions[s.chrom]
+		}
+	}
+	return nil
+}
+
+// add increments the depth at each base covered by an aligned (M, = or X) cigar operation of rec.
+// deletions and skipped bases are not counted.
+func (s *streamer) add(rec *sam.Record) {
+	pos := rec.Pos
+	for _, op := range rec.Cigar {
+		t := op.Type()
+		if t.Consumes().Reference == 0 {
+			continue
+		}
+		if t == sam.CigarMatch || t == sam.CigarEqual || t == sam.CigarMismatch {
+			for len(s.depths) < pos+op.Len()-s.off {
+				s.depths = append(s.depths, 0)
+			}
+			d := s.depths[pos-s.off : pos+op.Len()-s.off]
+			for i := range d {
+				d[i]++
+			}
+		}
+		pos += op.Len()
+	}
+}
+
+// flush sends the final depths of positions before end to the regions that contain them.
+func (s *streamer) flush(end int) error {
+	n := min(len(s.depths), max(0, end-s.off))
+	for i, d := range s.depths[:n] {
+		pos := s.off + i
+		if err := s.advance(pos); err != nil {
+			return err
+		}
+		if d == 0 {
+			continue
+		}
+		for _, o := range s.active {
+			if pos >= o.start && pos < o.end {
+				fmt.Fprintf(o.w, "%s\t%d\t%d\n", s.chrom, pos+1, d)
+			}
+		}
+	}
+	s.depths = s.depths[:copy(s.depths, s.depths[n:])]
+	s.off = max(s.off, end)
+	return s.advance(s.off)
+}
+
+// advance opens the regions that start at or before pos and closes those that end at or before pos. Closed
+// regions are written to the output in the order they were opened.
+func (s *streamer) advance(pos int) error {
+	for len(s.pending) > 0 && s.pending[0].start <= pos {
+		s.open(s.pending[0])
+		s.pending = s.pending[1:]
+	}
+	for _, o := range s.active {
+		if o.pw != nil && o.end <= pos {
+			if err := o.w.Flush(); err != nil {
+				return err
+			}
+			o.pw.Close()
+			o.pw = nil
+		}
+	}
+	for len(s.active) > 0 && s.active[0].pw == nil {
+		o := s.active[0]
+		if err := <-o.done; err != nil {
+			return err
+		}
+		ca, err := o.out.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		hd, err := o.out.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if err := appendTmp(s.fhca, ca); err != nil {
+			return err
+		}
+		if err := appendTmp(s.fhhd, hd); err != nil {
+			return err
+		}
+		s.active = s.active[1:]
+	}
+	return nil
+}
+
+// open starts the callback for r and sends it the region line that samtools depth commands are preceded by.
+func (s *streamer) open(r region) {
+	pr, pw := io.Pipe()
+	o := &openRegion{region: r, pw: pw, w: bufio.NewWriter(pw), out: &pathBuffer{}, done: make(chan error, 1)}
+	go func() {
+		err := s.callback(pr, o.out)
+		// drain so that writes do not block if the callback returned early.
+		io.Copy(ioutil.Discard, pr)
+		o.done <- err
+	}()
+	fmt.Fprintf(o.w, "%s\t%d\t%d\n", r.chrom, r.start, r.end)
+	s.active = append(s.active, o)
+}
@@ -12,6 +12,8 @@ import (
 	"strings"
 
 	arg "github.com/alexflint/go-arg"
+	"github.com/brentp/goleft"
+	"github.com/brentp/goleft/grid"
 	"github.com/brentp/xopen"
 )
 
@@ -20,9 +22,10 @@ type cliargs struct {
 	Beds []string `arg:"positional,required,help:depth.bed files from goleft depth"`
 }
 
+// pcheck exits with goleft.ExitInput as errors here are from reading the depth.bed files.
 func pcheck(e error) {
 	if e != nil {
-		log.Fatal(e)
+		goleft.Fatal(goleft.InputErr(e))
 	}
 }
 
@@ -59,6 +62,13 @@ func run(args cliargs) {
 		pcheck(err)
 		names[i+3] = getNameFromFile(f)
 	}
+	// the windows of the output must be made of whole windows of the input.
+	in, err := inputGrid(beds[0].Reader)
+	pcheck(err)
+	out, err := grid.New("", args.Size)
+	pcheck(err)
+	_, err = in.Coarsens(out)
+	pcheck(err)
 	stdout.WriteString(strings.Join(names, "\t") + "\n")
 
 	depths, eof := next(beds, args.Size)
@@ -72,6 +82,24 @@ func run(args cliargs) {
 
 }
 
+// inputGrid returns the grid of the windows of a depth.bed from its first line.
+func inputGrid(r *bufio.Reader) (grid.Grid, error) {
+	line, _ := r.Peek(256)
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	toks := strings.Split(string(line), "\t")
+	if len(toks) < 4 {
+		return grid.Grid{}, fmt.Errorf("depthwed: expected chrom, start, end and depth in the first line, got: %q", line)
+	}
+	start, serr := strconv.Atoi(toks[1])
+	end, eerr := strconv.Atoi(toks[2])
+	if serr != nil || eerr != nil {
+		return grid.Grid{}, fmt.Errorf("depthwed: bad interval in the first line: %q", line)
+	}
+	return grid.New("", end-start)
+}
+
 type depth struct {
 	chrom string
 	start int
@@ -139,7 +167,7 @@ func next(beds []*xopen.Reader, size int) (depths []depth, eof bool) {
 			if k == 0 {
 				depths[i] = sFromLine(line)
 				if depths[i].chrom != chrom {
-					log.Fatalf("got unexpected chromosome from %s: %s", bed, depths[i].chrom)
+					log.Fatalf("got unexpected chromosome from bed %d: %s", i+1, depths[i].chrom)
 				}
 				if size%(depths[i].end-depths[i].start) != 0 && !endSeen {
 					endSeen = true
@@ -0,0 +1,130 @@
+package emdepth
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Options configures a Caller.
+type Options struct {
+	// MaxGap ends the CNV of a sample once a window starts this many bases after its last aberrant
+	// window. Default is DefaultMaxGap.
+	MaxGap uint32
+	// MaxWindows is the most windows kept for the CNV of a single sample. Longer CNVs (e.g. a whole
+	// chromosome arm) are returned in pieces of this many windows so that the memory used does not
+	// grow with the length of a chromosome. Default is DefaultMaxWindows. A negative value is no
+	// limit.
+	MaxWindows int
+}
+
+// DefaultMaxWindows is the number of windows after which the CNV of a sample is returned in pieces.
+// With windows of 16KB, it is 160Mb.
+const DefaultMaxWindows = 10000
+
+// Window holds the depths of every sample, in the same order for each window, in a region.
+type Window struct {
+	Chrom string
+	Position
+	Depths []float32
+}
+
+// Caller calls CNVs from windows that are pushed one at a time in sorted order so that a whole
+// genome can be processed without holding the depth matrix in memory. Only the windows of samples
+// with an open CNV are kept.
+//
+//	caller := emdepth.NewCaller(opts)
+//	for each window {
+//		if err := caller.Push(w); err != nil { ... }
+//		write(caller.Ready())
+//	}
+//	write(caller.Flush())
+type Caller struct {
+	opts     Options
+	chrom    string
+	nSamples int
+	cache    Cache
+	done     []*CNV
+}
+
+// NewCaller returns a Caller with the given options.
+func NewCaller(opts Options) *Caller {
+	c := &Caller{opts: opts}
+	c.reset()
+	return c
+}
+
+func (c *Caller) reset() {
+	max := c.opts.MaxWindows
+	if max == 0 {
+		max = DefaultMaxWindows
+	}
+	// the cache has no limit for 0.
+	if max < 0 {
+		max = 0
+	}
+	c.cache = Cache{maxGap: c.opts.MaxGap, maxWindows: max}
+}
+
+// Push adds the depths of a window. Windows must be sorted by position within a chromosome and must
+// have the same number of samples. A new chromosome ends all open CNVs. The depths are copied so the
+// slice can be re-used by the caller.
+func (c *Caller) Push(w Window) error {
+	if len(w.Depths) == 0 {
+		return fmt.Errorf("emdepth: no depths in window at %s:%s", w.Chrom, &w.Position)
+	}
+	if c.nSamples == 0 {
+		c.nSamples = len(w.Depths)
+	} else if len(w.Depths) != c.nSamples {
+		return fmt.Errorf("emdepth: expected %d samples in window at %s:%s, got %d", c.nSamples, w.Chrom, &w.Position, len(w.Depths))
+	}
+	if c.cache.last != nil {
+		if w.Chrom != c.chrom {
+			c.end(c.cache.Clear(nil))
+			c.reset()
+		} else if w.Start < c.cache.last.Position.Start {
+			return fmt.Errorf("emdepth: window at %s:%s is before the previous window at %s", w.Chrom, &w.Position, &c.cache.last.Position)
+		}
+	}
+	c.chrom = w.Chrom
+	depths := make([]float32, len(w.Depths))
+	copy(depths, w.Depths)
+	c.end(c.cache.Add(EMDepth(depths, w.Position)))
+	return nil
+}
+
+// end sets the chromosome of the cnvs and keeps them until they are returned by Ready or Flush.
+func (c *Caller) end(cnvs []*CNV) {
+	for _, cnv := range cnvs {
+		cnv.Chrom = c.chrom
+	}
+	sortCNVs(cnvs)
+	c.done = append(c.done, cnvs...)
+}
+
+// Ready returns the CNVs that have ended since the last call to Ready or Flush. CNVs that may still
+// be extended by later windows are kept.
+func (c *Caller) Ready() []*CNV {
+	done := c.done
+	c.done = nil
+	return done
+}
+
+// Flush ends all open CNVs and returns them along with any that were not yet returned by Ready. The
+// Caller can then be used for another set of windows.
+func (c *Caller) Flush() []*CNV {
+	c.end(c.cache.Clear(nil))
+	c.reset()
+	c.chrom, c.nSamples = "", 0
+	return c.Ready()
+}
+
+// sortCNVs sorts CNVs that end at the same time by start and then by sample.
+func sortCNVs(cnvs []*CNV) {
+	sort.Slice(cnvs, func(i, j int) bool {
+		a, b := cnvs[i].Position[0].Start, cnvs[j].Position[0].Start
+		if a != b {
+			return a < b
+		}
+		return cnvs[i].SampleI < cnvs[j].SampleI
+	})
+}
@@ -4,7 +4,10 @@
 // have copy-number 2.
 // emdepth consists of a single function EMDepth that iteratively assigns depths to copy-numbers, adjusts
 // the center of each copy-number bin. and re-assigns...
+// A Caller merges the copy-numbers of adjacent windows into CNVs as the windows of a whole genome are
+// pushed one at a time.
 // This package does no normalization and therefore expects incoming data to be normalized.
+// The gcnorm package can be used to correct depths for GC-content and mappability first.
 package emdepth
 
 import (
@@ -63,6 +66,10 @@ func abs32(a float32) float32 {
 }
 
 const maxCN = 8
+
+// DefaultMaxGap is the distance (in bases) past the last aberrant window of a sample after which its
+// CNV is ended.
+const DefaultMaxGap = 30000
 const maxiter = 10
 const eps = 0.01
 
@@ -276,7 +283,8 @@ func (e *EMD) Type(d float32) int {
 	if idx == 0 {
 		cn = 0
 	} else if idx == len(e.Lambda) {
-		cn = len(e.Lambda)
+		// depths above the center of the highest copy-number are capped at maxCN.
+		cn = len(e.Lambda) - 1
 	} else {
 		// idx will always be the index of the value >= d so we check if idx or idx-1 is better.
 		if abs(df-e.Lambda[idx]) < abs(df-e.Lambda[idx-1]) {
@@ -311,10 +319,15 @@ type Cache struct {
 	last *EMD
 	// for each sample (key by sample index), where is it non-CN2?
 	cnvs map[int][]*EMD
+	// maxGap and maxWindows are set from the Options of a Caller. See Options.
+	maxGap     uint32
+	maxWindows int
 }
 
 // CNV holds the information for a CNV for a single sample.
 type CNV struct {
+	// Chrom is set only for CNVs from a Caller.
+	Chrom    string
 	SampleI  int
 	Depth    []float32
 	Position []Position
@@ -339,31 +352,43 @@ func (c *Cache) Add(e *EMD) []*CNV {
 	noncn2, _, _ := c.last.Same(e)
 	for _, si := range noncn2 {
 		c.cnvs[si] = append(c.cnvs[si], e)
+		// a long CNV is ejected in pieces so that the memory used is bounded.
+		if c.maxWindows > 0 && len(c.cnvs[si]) >= c.maxWindows {
+			if putative := makecnvs(c.cnvs[si], si); putative != nil {
+				putative.PSize = len(c.cnvs)
+				ret = append(ret, putative)
+			}
+			delete(c.cnvs, si)
+		}
 	}
 	c.last = e
 	return ret
 
 }
 
+// Clear returns the CNVs of samples whose last aberrant window ends far enough before p and removes
+// them from the cache. With a nil p, all CNVs are returned.
 func (c *Cache) Clear(p *Position) []*CNV {
-	if p == nil {
-		if c.last == nil {
-			return nil
-		}
-		p = &Position{End: c.last.Position.End + 100000, Start: c.last.Position.Start + 100000}
+	if p == nil && c.last == nil {
+		return nil
+	}
+	gap := c.maxGap
+	if gap == 0 {
+		gap = DefaultMaxGap
 	}
 	cnvs := make([]*CNV, 0, 5)
-	keys := make([]int, len(c.cnvs))
+	keys := make([]int, 0, len(c.cnvs))
 	//L := p.End - p.Start
 	for si, emd := range c.cnvs {
 		// not a big enough gap yet.
-		if p.Start-emd[len(emd)-1].Position.End < 30000 {
+		if p != nil && p.Start < emd[len(emd)-1].Position.End+gap {
 			continue
 		}
+		// samples whose windows are all too close to CN2 are also removed so they are not kept forever.
+		keys = append(keys, si)
 		if putative := makecnvs(emd, si); putative != nil {
 			cnvs = append(cnvs, putative)
 			cnvs[len(cnvs)-1].PSize = len(c.cnvs)
-			keys = append(keys, si)
 		}
 	}
 	for _, key := range keys {
@@ -53,6 +53,108 @@ func TestBig(t *testing.T) {
 	fmt.Println(em2.Log2FC())
 }
 
+func TestTypeCap(t *testing.T) {
+	v := []float32{30, 28, 33, 34, 35, 37, 31, 22, 38}
+	em := EMDepth(v, p)
+	if len(em.Lambda) != maxCN+1 {
+		t.Fatalf("expected %d lambdas, got %d", maxCN+1, len(em.Lambda))
+	}
+	top := float32(em.Lambda[maxCN])
+	for _, d := range []float32{top + 1, 10 * top, 1e9} {
+		if cn := em.Type(d); cn != maxCN {
+			t.Errorf("expected depth %.1f above the highest lambda %.1f to be capped at %d, got %d", d, top, maxCN, cn)
+		}
+	}
+	if cn := em.Type(top); cn != maxCN {
+		t.Errorf("expected %d at the highest lambda, got %d", maxCN, cn)
+	}
+}
+
+func TestCaller(t *testing.T) {
+	base := []float32{30, 31, 29, 32, 28, 30, 33, 27, 30, 31}
+	buf := make([]float32, len(base))
+	// sample si has depth d in windows [lo, hi) of each chromosome.
+	push := func(c *Caller, chrom string, n, si int, d float32, lo, hi int) [][]*CNV {
+		var ready [][]*CNV
+		for i := 0; i < n; i++ {
+			// the buffer is re-used to check that the caller copies it.
+			copy(buf, base)
+			if i >= lo && i < hi {
+				buf[si] = d
+			}
+			if err := c.Push(Window{Chrom: chrom, Position: Position{Start: uint32(i * 1000), End: uint32((i + 1) * 1000)}, Depths: buf}); err != nil {
+				t.Fatal(err)
+			}
+			ready = append(ready, c.Ready())
+		}
+		return ready
+	}
+
+	c := NewCaller(Options{})
+	ready := push(c, "chr1", 60, 0, 12, 10, 20)
+	for i, r := range ready {
+		// the gap after the last aberrant window ends the CNV.
+		if (i == 50) != (len(r) == 1) {
+			t.Fatalf("unexpected CNVs after window %d: %v", i, r)
+		}
+	}
+	cnv := ready[50][0]
+	if cnv.Chrom != "chr1" || cnv.SampleI != 0 || cnv.Position[len(cnv.Position)-1].End != 20000 || cnv.Log2FC[0] > -0.5 {
+		t.Errorf("unexpected CNV: %s %d %v %v", cnv.Chrom, cnv.SampleI, cnv.Position, cnv.Log2FC)
+	}
+
+	// a new chromosome ends the open CNVs of the last.
+	push(c, "chr2", 10, 5, 12, 5, 10)
+	ready = push(c, "chr3", 10, 5, 66, 2, 8)
+	if len(ready[0]) != 1 || ready[0][0].Chrom != "chr2" || ready[0][0].SampleI != 5 {
+		t.Errorf("expected the CNV on chr2 to end with chr3, got: %v", ready[0])
+	}
+	flushed := c.Flush()
+	if len(flushed) != 1 || flushed[0].Chrom != "chr3" || flushed[0].SampleI != 5 || !reflect.DeepEqual(flushed[0].CN, []int{4, 4, 4, 4, 4}) {
+		t.Errorf("unexpected CNVs from Flush: %v", flushed)
+	}
+	if len(c.Flush()) != 0 {
+		t.Error("expected no CNVs from a second Flush")
+	}
+
+	// long CNVs are returned in pieces.
+	c = NewCaller(Options{MaxWindows: 2})
+	var pieces []*CNV
+	for _, r := range push(c, "chr1", 10, 5, 66, 2, 8) {
+		pieces = append(pieces, r...)
+	}
+	pieces = append(pieces, c.Flush()...)
+	if len(pieces) != 3 || len(pieces[0].Position) != 2 || len(pieces[2].Position) != 1 {
+		t.Errorf("expected 3 pieces of the CNV, got: %v", pieces)
+	}
+
+	// the default limit applies unless it is turned off.
+	for _, max := range []int{0, -1} {
+		c = NewCaller(Options{MaxWindows: max})
+		pieces = nil
+		for _, r := range push(c, "chr1", DefaultMaxWindows+10, 5, 66, 2, DefaultMaxWindows+7) {
+			pieces = append(pieces, r...)
+		}
+		pieces = append(pieces, c.Flush()...)
+		if max == 0 && (len(pieces) != 2 || len(pieces[0].Position) != DefaultMaxWindows || len(pieces[1].Position) != 4) {
+			t.Errorf("expected 2 pieces of the CNV with the default limit, got %d", len(pieces))
+		}
+		if max == -1 && (len(pieces) != 1 || len(pieces[0].Position) != DefaultMaxWindows+4) {
+			t.Errorf("expected 1 CNV with no limit, got %d", len(pieces))
+		}
+	}
+
+	if err := c.Push(Window{Chrom: "chr1", Position: Position{Start: 1000, End: 2000}, Depths: base}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Push(Window{Chrom: "chr1", Position: Position{Start: 0, End: 1000}, Depths: base}); err == nil {
+		t.Error("expected an error for an unsorted window")
+	}
+	if err := c.Push(Window{Chrom: "chr1", Position: Position{Start: 2000, End: 3000}, Depths: base[1:]}); err == nil {
+		t.Error("expected an error for a different number of samples")
+	}
+}
+
 func BenchmarkEMDepth(b *testing.B) {
 	v := []float32{296.6, 16.7, 17.0, 319.2, 14.4, 16.5, 14.2, 22, 33, 44, 66, 22, 33, 11, 15, 18, 22, 22, 44, 31, 22, 66, 22, 21, 23, 16, 17, 19}
 	v = append(v, v...)
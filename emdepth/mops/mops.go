@@ -1,7 +1,11 @@
 // Package mops implements the EM algorithm described in the cn.mops paper.
 package mops
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"sort"
+)
 
 // mean of all except highest and lowest values.
 func mean32(a []float32) float32 {
@@ -26,32 +30,85 @@ func abs32(a float32) float32 {
 	return a
 }
 
-const maxCN = 8
+// DefaultMaxCN is the highest copy-number in the model used by Fit and Mops.
+const DefaultMaxCN = 8
+
+// Amplified is the copy-number reported for a sample whose depth implies a copy-number above the
+// MaxCN of the model. It is larger than any copy-number so that checks such as cn > 2 still hold.
+const Amplified = math.MaxInt32
+
+// above linearCN, the copy-numbers in the model are spaced on a log scale with each this much larger
+// than the last so that a high MaxCN does not need a component for every copy-number.
+const (
+	linearCN = 8
+	logStep  = 1.25
+)
+
 const delta = 0.001
 const maxiter = 10
 
 const eps = 0.001
 
-func pmf(k int, pmean float64) float64 {
-	// TODO: pre-calc exp(-pmean) if math.Exp show up in bench.
-	return math.Pow(pmean, float64(k)) * math.Exp(-pmean) / math.Gamma(float64(k+1))
+// MaxQual is the highest phred-scaled quality reported for a copy-number.
+const MaxQual = 99
+
+// logpmf is the log of the poisson probability of k given the mean. It is computed in log-space
+// so that high depths do not overflow.
+func logpmf(k int, pmean float64) float64 {
+	if pmean == 0 {
+		if k == 0 {
+			return 0
+		}
+		return math.Inf(-1)
+	}
+	lg, _ := math.Lgamma(float64(k + 1))
+	return float64(k)*math.Log(pmean) - pmean - lg
 }
 
-// top right of eqn 5.
-func pdepth(depths []float32, cn int, lambda float32, out []float32) {
-	// i indexes copy-number, k indexes sample
-	beta := float64(cn) / 2 * float64(lambda)
+// mean depth of a copy-number given the mean depth of CN2.
+func cnMean(cn int, lambda float32) float64 {
 	if cn == 0 {
-		beta = 0.001 / 2 * float64(lambda)
+		return eps / 2 * float64(lambda)
 	}
-	//em := math.Exp(-beta)
-	for k, d := range depths {
-		out[k] = float32(pmf(int(d+0.5), beta))
+	return float64(cn) / 2 * float64(lambda)
+}
+
+// isAmplified returns true if the copy-number implied by depth d, given the depth of CN2, rounds to
+// more than maxCN.
+func isAmplified(d, cn2 float32, maxCN int) bool {
+	return 2*float64(d)/float64(cn2) > float64(maxCN)+0.5
+}
+
+func median32(a []float32) float32 {
+	b := make([]float32, len(a))
+	copy(b, a)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	if len(b)%2 == 1 {
+		return b[len(b)/2]
+	}
+	return (b[len(b)/2-1] + b[len(b)/2]) / 2
+}
+
+// copyNumbers returns the copy-numbers in the model: each from 0 to linearCN and then log-spaced
+// values up to and including maxCN.
+func copyNumbers(maxCN int) []int {
+	cns := make([]int, 0, maxCN+1)
+	for cn := 0; cn <= maxCN && cn <= linearCN; cn++ {
+		cns = append(cns, cn)
 	}
+	for cn := linearCN; cn < maxCN; {
+		next := int(math.Round(float64(cn) * logStep))
+		if next > maxCN {
+			next = maxCN
+		}
+		cns = append(cns, next)
+		cn = next
+	}
+	return cns
 }
 
-// returns a square alpha ik.
-func estep(alpha []float32, depths []float32, lambda float32, aik [][]float32) [][]float32 {
+// returns a square alpha ik: the posterior probability of each copy-number (i) for each sample (k).
+func estep(alpha []float32, cns []int, depths []float32, lambda float32, aik [][]float32) [][]float32 {
 	// i index copy-numbers
 	//	k indexes sample
 	// N is number of samples.
@@ -63,31 +120,29 @@ func estep(alpha []float32, depths []float32, lambda float32, aik [][]float32) [
 			aik[i] = make([]float32, N)
 		}
 	}
-	//expm := math.Exp(float64(-lambda))
 
-	denom := make([]float32, len(depths))
-	n := len(alpha)
-	// calculte prob for cn 2.
-	// eqn(1), eqn(5) denom.
+	lps := make([]float64, len(alpha))
 	for k, d := range depths {
-		for i := 0; i < n; i++ {
-			denom[k] += alpha[i] * float32(pmf(int(d+0.5), float64(float32(i)/2*lambda)))
+		// eqn 5 from cn.mops with the denominator (eqn 1) summed in log-space.
+		top := math.Inf(-1)
+		for i, a := range alpha {
+			lps[i] = math.Log(float64(a)) + logpmf(int(d+0.5), cnMean(cns[i], lambda))
+			if lps[i] > top {
+				top = lps[i]
+			}
 		}
-	}
-
-	// calculate prob for other cns.
-	for cn := range alpha {
-		// aik[cn] is set by this call to avoid allocation by re-use
-		pdepth(depths, cn, lambda, aik[cn])
-		// eqn 5 from cn.mops.
-		for k, ad := range aik[cn] {
-			aik[cn][k] = alpha[cn] * ad / denom[k]
+		var denom float64
+		for _, lp := range lps {
+			denom += math.Exp(lp - top)
+		}
+		for i, lp := range lps {
+			aik[i][k] = float32(math.Exp(lp-top) / denom)
 		}
 	}
 	return aik
 }
 
-func mstep(depths []float32, adepths [][]float32) (alpha []float32, lambda float32) {
+func mstep(depths []float32, cns []int, adepths [][]float32) (alpha []float32, lambda float32) {
 	n := len(adepths)
 	alpha = make([]float32, n)
 	N := float32(len(depths))
@@ -98,14 +153,15 @@ func mstep(depths []float32, adepths [][]float32) (alpha []float32, lambda float
 
 	alphaDenom := 1 + 1/N*float32(ys-float32(n))
 
-	for cn, ai := range adepths {
+	for i, ai := range adepths {
+		cn := cns[i]
 		amean := mean32(ai)
 		yi := float32(1)
 		if cn == 2 {
 			yi = 1 + G
 		}
 		top := amean + 1/N*(yi-1)
-		alpha[cn] = top / alphaDenom
+		alpha[i] = top / alphaDenom
 
 		// eqn 7
 		if cn == 0 {
@@ -118,17 +174,21 @@ func mstep(depths []float32, adepths [][]float32) (alpha []float32, lambda float
 	return alpha, mean32(depths) / lambdaDenom
 }
 
+// Mopped is the fit of the copy-number mixture for the depths of all samples at a single site.
 type Mopped struct {
 	aik   [][]float32
 	alpha []float32
+	cns   []int
+	// amplified is true for samples with a depth above that expected for the highest copy-number.
+	amplified []bool
 }
 
 func (m *Mopped) Gain() float32 {
 	// cn.mops eqn(8)
 	var ig float32
 	for i, ai := range m.aik {
-		v := float64(i)
-		if i == 0 {
+		v := float64(m.cns[i])
+		if m.cns[i] == 0 {
 			v = float64(eps)
 		}
 		ig += mean32(ai) * float32(abs(math.Log(float64(v/2))))
@@ -136,28 +196,154 @@ func (m *Mopped) Gain() float32 {
 	return ig
 }
 
-func Mops(depths []float32) *Mopped {
+// best returns the index of the most likely copy-number in the model for each sample.
+func (m *Mopped) best() []int {
+	idx := make([]int, len(m.aik[0]))
+	for k := range idx {
+		best := float32(-1)
+		for i, ai := range m.aik {
+			if ai[k] > best {
+				best, idx[k] = ai[k], i
+			}
+		}
+	}
+	return idx
+}
+
+// CN returns the most likely copy-number of each sample or Amplified for samples with a depth above
+// that of the highest copy-number in the model.
+func (m *Mopped) CN() []int {
+	cns := m.best()
+	for k, i := range cns {
+		cns[k] = m.cns[i]
+		if m.amplified[k] {
+			cns[k] = Amplified
+		}
+	}
+	return cns
+}
+
+// CopyNumbers returns the copy-numbers in the model in the order used by Posteriors. These are
+// each from 0 to 8 and then, for a MaxCN above 8, log-spaced values up to MaxCN. A sample with
+// a copy-number between those in the model is given the closest.
+func (m *Mopped) CopyNumbers() []int {
+	return m.cns
+}
+
+// Posteriors returns the probability of each copy-number (in the order of CopyNumbers) for each
+// sample. These are the responsibilities of the mixture components.
+func (m *Mopped) Posteriors() [][]float32 {
+	post := make([][]float32, len(m.aik[0]))
+	for k := range post {
+		post[k] = make([]float32, len(m.aik))
+		for cn, ai := range m.aik {
+			post[k][cn] = ai[k]
+		}
+	}
+	return post
+}
+
+// Quals returns the phred-scaled quality of the copy-number from CN for each sample, i.e.
+// -10 * log10 of the probability that it is wrong. It is at most MaxQual. For an Amplified
+// sample, it is the quality of the highest copy-number in the model.
+func (m *Mopped) Quals() []float32 {
+	idx := m.best()
+	quals := make([]float32, len(idx))
+	for k, i := range idx {
+		quals[k] = phred(1 - float64(m.aik[i][k]))
+	}
+	return quals
+}
+
+func phred(perr float64) float32 {
+	if perr <= 0 {
+		return MaxQual
+	}
+	return float32(math.Min(MaxQual, -10*math.Log10(perr)))
+}
+
+// Options configures FitOptions.
+type Options struct {
+	// MaxCN is the highest copy-number in the model. 0 means DefaultMaxCN; it must otherwise be at
+	// least 3. Samples with a depth implying a higher copy-number are reported as Amplified.
+	MaxCN int
+}
+
+// Fit runs the EM on the depths of all samples at a single site with the default Options.
+func Fit(depths []float32) *Mopped {
+	// the default options are valid.
+	m, _ := FitOptions(depths, Options{})
+	return m
+}
+
+// FitOptions runs the EM on the depths of all samples at a single site. An error is returned if
+// opts.MaxCN is invalid.
+func FitOptions(depths []float32, opts Options) (*Mopped, error) {
+	maxCN := opts.MaxCN
+	if maxCN == 0 {
+		maxCN = DefaultMaxCN
+	}
+	if maxCN < 3 {
+		return nil, fmt.Errorf("mops: MaxCN must be at least 3, got %d", maxCN)
+	}
+	cns := copyNumbers(maxCN)
+	// samples far above the highest copy-number would pull the depth of CN2 up if they were fit as
+	// maxCN so they are set aside using the median, which is CN2 for most sites, and are only given
+	// posteriors once the fit is done.
+	fit := depths
+	if len(depths) == 0 {
+		return &Mopped{cns: cns}, nil
+	}
+	if med := median32(depths); med > 0 {
+		fit = make([]float32, 0, len(depths))
+		for _, d := range depths {
+			if !isAmplified(d, med, maxCN) {
+				fit = append(fit, d)
+			}
+		}
+		if len(fit) == 0 {
+			fit = depths
+		}
+	}
 	// alpha[i] is percentage of samples with CNi
 	// lambda is mean read-count for CN2
 	// x is depth
 	// p(x|i) is likely that read-count x is from CNi == 1/x! * e^-(i/2 * lambda) * i/2*lambda
-	alpha := make([]float32, maxCN)
+	alpha := make([]float32, len(cns))
 	for i := 0; i < len(alpha); i++ {
 		alpha[i] = eps
 	}
 	alpha[2] = 1.0 - 5*eps*float32(len(alpha)-1)
 
-	nlambda := mean32(depths)
+	// the median is a better start than the mean when there are high copy-numbers.
+	nlambda := median32(fit)
+	if nlambda == 0 {
+		nlambda = mean32(fit)
+	}
 	lambda := float32(math.MaxFloat32) / 10.0
 	var aik [][]float32
 
 	// em iterations.
-	for n := 0; abs32(lambda-nlambda) > 0.01 && n < 10; n++ {
+	for n := 0; abs32(lambda-nlambda) > 0.01 && n < maxiter; n++ {
 		lambda = nlambda
-		aik = estep(alpha, depths, lambda, aik)
-		alpha, nlambda = mstep(depths, aik)
+		aik = estep(alpha, cns, fit, lambda, aik)
+		alpha, nlambda = mstep(fit, cns, aik)
+	}
+	if len(fit) != len(depths) {
+		aik = estep(alpha, cns, depths, lambda, nil)
+	}
+	amplified := make([]bool, len(depths))
+	for k, d := range depths {
+		amplified[k] = isAmplified(d, lambda, maxCN)
 	}
-	return &Mopped{aik: aik, alpha: alpha}
+	return &Mopped{aik: aik, alpha: alpha, cns: cns, amplified: amplified}, nil
+}
+
+// Mops returns the most likely copy-number (or Amplified) of each sample given the depths of all
+// samples at a single site. Use Fit for the posteriors and qualities. Before v0.2.1 Mops returned
+// the *Mopped that Fit returns.
+func Mops(depths []float32) []int {
+	return Fit(depths).CN()
 }
 
 func CNINI(cns []int, depths []float32, centers []float64) float32 {
@@ -25,16 +25,119 @@ func TestDepth(t *testing.T) {
 }
 
 func TestBig(t *testing.T) {
-	v := []float32{296.6, 16.7, 17.0, 319.2, 14.4, 16.5, 14.2}
+	v := []float32{296.6, 16.7, 17.0, 319.2, 14.4, 16.5, 14.2, 120}
 	cns := mops.Mops(v)
-	exp := []int{8, 2, 2, 8, 2, 2, 2}
+	// 120 is CN ~15 which is above the default MaxCN of 8.
+	exp := []int{mops.Amplified, 2, 2, mops.Amplified, 2, 2, 2, mops.Amplified}
 
 	if !reflect.DeepEqual(cns, exp) {
 		t.Errorf("expected: %v, got: %v", exp, cns)
 	}
+
+	// with a higher ceiling, the copy-number is the closest of the log-spaced values in the model.
+	m, err := mops.FitOptions(v, mops.Options{MaxCN: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = []int{39, 2, 2, 39, 2, 2, 2, 16}
+	if !reflect.DeepEqual(m.CN(), exp) {
+		t.Errorf("expected: %v, got: %v", exp, m.CN())
+	}
+	if got := m.CopyNumbers(); got[len(got)-1] != 64 || len(got) >= 64 {
+		t.Errorf("unexpected copy-numbers in the model: %v", got)
+	}
+}
+
+func TestAmplified(t *testing.T) {
+	// depths of thousands in a few samples must not change the copy-number of the rest.
+	v := []float32{3019.2, 5000, 30, 31, 29, 33, 28, 30, 14, 60}
+	exp := []int{mops.Amplified, mops.Amplified, 2, 2, 2, 2, 2, 2, 1, 4}
+	for _, maxCN := range []int{0, 8, 100} {
+		m, err := mops.FitOptions(v, mops.Options{MaxCN: maxCN})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m.CN(), exp) {
+			t.Errorf("MaxCN %d: expected: %v, got: %v", maxCN, exp, m.CN())
+		}
+		for k, p := range m.Posteriors() {
+			var sum float32
+			for _, pc := range p {
+				sum += pc
+			}
+			if sum < 0.999 || sum > 1.001 {
+				t.Errorf("MaxCN %d: expected posteriors of sample %d to sum to 1, got: %v", maxCN, k, sum)
+			}
+		}
+		// amplified samples are most likely the highest copy-number in the model.
+		if q := m.Quals()[0]; q < 20 {
+			t.Errorf("MaxCN %d: expected a high quality for the amplified sample, got: %v", maxCN, q)
+		}
+	}
+
+	m, err := mops.FitOptions(v, mops.Options{MaxCN: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cns := m.CN()
+	if cns[0] < 160 || cns[0] > 250 || cns[1] < 270 || cns[1] > 400 {
+		t.Errorf("expected copy-numbers of ~200 and ~333, got: %v", cns)
+	}
+
+	for _, maxCN := range []int{2, 1, -1} {
+		if m, err := mops.FitOptions(v, mops.Options{MaxCN: maxCN}); err == nil || m != nil {
+			t.Errorf("expected an error for a MaxCN of %d", maxCN)
+		}
+	}
 }
 
 func TestMops(t *testing.T) {
 	v := []float32{93, 34, 33, 34, 35, 37, 33, 36, 32}
 	_ = mops.Mops(v)
 }
+
+func TestQuals(t *testing.T) {
+	v := []float32{1, 8, 33, 34, 35, 37, 31, 22, 66}
+	m := mops.Fit(v)
+	for k, p := range m.Posteriors() {
+		var sum float32
+		for _, pc := range p {
+			sum += pc
+		}
+		if sum < 0.999 || sum > 1.001 {
+			t.Errorf("expected posteriors of sample %d to sum to 1, got: %v", k, sum)
+		}
+	}
+	quals := m.Quals()
+	for k, q := range quals {
+		if q < 0 || q > mops.MaxQual {
+			t.Errorf("quality out of range for sample %d: %v", k, q)
+		}
+	}
+	// 22 is between CN1 and CN2 so it is less certain than 34.
+	if quals[7] >= quals[3] {
+		t.Errorf("expected lower quality for 22 than for 34, got: %v", quals)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	cns := []int{2, 2, 1, 1, 1, 2, 3}
+	quals := []float32{50, 50, 20, 10, 30, 99, 5}
+	segs := mops.Segments(cns, quals)
+	if len(segs) != 4 {
+		t.Fatalf("expected 4 segments, got: %v", segs)
+	}
+	del := segs[1]
+	if del.Start != 2 || del.End != 5 || del.CN != 1 || del.Len() != 3 || del.MinQual != 10 {
+		t.Errorf("unexpected segment: %+v", del)
+	}
+	if del.Qual >= del.MinQual {
+		t.Errorf("expected segment quality below that of its worst site, got: %+v", del)
+	}
+	if segs[3].Qual != 5 {
+		t.Errorf("expected quality of single site segment to be that of the site, got: %+v", segs[3])
+	}
+	if len(mops.Segments(nil, nil)) != 0 {
+		t.Errorf("expected no segments")
+	}
+}
@@ -0,0 +1,45 @@
+package mops
+
+import "math"
+
+// Segment is a run of consecutive sites of a sample with the same copy-number.
+type Segment struct {
+	// Start and End are the indexes of the first site and one past the last site.
+	Start, End int
+	CN         int
+	// Qual is the phred-scaled probability that any site in the segment has the wrong
+	// copy-number assuming the sites are independent. It is at most MaxQual.
+	Qual float32
+	// MinQual is the lowest quality of any site in the segment.
+	MinQual float32
+}
+
+// Len is the number of sites in the segment.
+func (s Segment) Len() int { return s.End - s.Start }
+
+// Segments merges consecutive sites with the same copy-number into segments. cns and quals are
+// the copy-numbers and qualities (from Mopped.CN and Mopped.Quals) of a single sample across sites.
+func Segments(cns []int, quals []float32) []Segment {
+	var segs []Segment
+	// pok is the log probability that all sites so far in the segment are correct.
+	var pok float64
+	for i, cn := range cns {
+		if i == 0 || cn != cns[i-1] {
+			if i > 0 {
+				segs[len(segs)-1].Qual = phred(-math.Expm1(pok))
+			}
+			segs = append(segs, Segment{Start: i, CN: cn, MinQual: MaxQual})
+			pok = 0
+		}
+		s := &segs[len(segs)-1]
+		s.End = i + 1
+		if quals[i] < s.MinQual {
+			s.MinQual = quals[i]
+		}
+		pok += math.Log1p(-math.Pow(10, -float64(quals[i])/10))
+	}
+	if len(segs) > 0 {
+		segs[len(segs)-1].Qual = phred(-math.Expm1(pok))
+	}
+	return segs
+}
@@ -0,0 +1,112 @@
+package goleft
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Exit statuses shared by the subcommands so that a pipeline can branch on the outcome of a run
+// without parsing the logs. Errors in the command-line arguments exit with 255 from the argument parser.
+const (
+	// ExitOK is used when the run finished and no QC failure was found (or --fail-on never).
+	ExitOK = 0
+	// ExitError is used for unexpected errors, e.g. a failed write or an external command that failed.
+	ExitError = 1
+	// ExitQC is used when the run finished but samples failed QC (or had warnings with --fail-on warn).
+	ExitQC = 10
+	// ExitInput is used when an input is missing, unreadable or malformed.
+	ExitInput = 20
+	// ExitLocked is used when another run holds the lock of the output prefix. It is safe to retry.
+	ExitLocked = 30
+)
+
+// InputError marks an error as caused by the inputs rather than by the program or the system.
+type InputError struct {
+	Err error
+}
+
+func (e *InputError) Error() string { return e.Err.Error() }
+
+func (e *InputError) Unwrap() error { return e.Err }
+
+// InputErr returns err as an *InputError. It returns nil if err is nil.
+func InputErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &InputError{Err: err}
+}
+
+// IsInputError returns true if err is or wraps an *InputError.
+func IsInputError(err error) bool {
+	var ie *InputError
+	return errors.As(err, &ie)
+}
+
+// ExitStatus returns ExitInput for input errors, ExitLocked for a *LockedError, ExitError for other
+// errors and ExitOK for nil.
+func ExitStatus(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if IsInputError(err) {
+		return ExitInput
+	}
+	var le *LockedError
+	if errors.As(err, &le) {
+		return ExitLocked
+	}
+	return ExitError
+}
+
+// Fatal logs err and exits with the status from ExitStatus.
+func Fatal(err error) {
+	log.Println(err)
+	os.Exit(ExitStatus(err))
+}
+
+// FailOn sets which QC outcomes give a non-zero exit status.
+type FailOn int
+
+const (
+	// FailNever exits with ExitOK regardless of QC.
+	FailNever FailOn = iota
+	// FailOnFail exits with ExitQC when any sample fails QC.
+	FailOnFail
+	// FailOnWarn exits with ExitQC when any sample fails QC or has a warning.
+	FailOnWarn
+)
+
+// ParseFailOn parses the value given to --fail-on: never, fail or warn.
+func ParseFailOn(s string) (FailOn, error) {
+	switch s {
+	case "never", "":
+		return FailNever, nil
+	case "fail":
+		return FailOnFail, nil
+	case "warn":
+		return FailOnWarn, nil
+	}
+	return FailNever, fmt.Errorf("expected never, fail or warn for --fail-on. got %q", s)
+}
+
+func (f FailOn) String() string {
+	switch f {
+	case FailOnFail:
+		return "fail"
+	case FailOnWarn:
+		return "warn"
+	}
+	return "never"
+}
+
+// Status returns the exit status for a run where failed samples (or regions) failed QC and warned had
+// warnings.
+func (f FailOn) Status(failed, warned int) int {
+	if (f >= FailOnFail && failed > 0) || (f == FailOnWarn && warned > 0) {
+		return ExitQC
+	}
+	return ExitOK
+}
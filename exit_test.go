@@ -0,0 +1,92 @@
+package goleft
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	locked := &LockedError{Path: "out.lock", Owner: "host 1 2018-01-01T00:00:00Z"}
+	cases := []struct {
+		name string
+		err  error
+		exp  int
+	}{
+		{"nil", nil, ExitOK},
+		{"error", errors.New("write failed"), ExitError},
+		{"input", InputErr(errors.New("no such file")), ExitInput},
+		{"wrapped input", fmt.Errorf("indexcov: %w", InputErr(errors.New("bad bed"))), ExitInput},
+		{"locked", locked, ExitLocked},
+		{"wrapped locked", fmt.Errorf("depth: %w", locked), ExitLocked},
+	}
+	for _, c := range cases {
+		if got := ExitStatus(c.err); got != c.exp {
+			t.Errorf("%s: expected %d, got %d", c.name, c.exp, got)
+		}
+	}
+	// the statuses are documented for pipelines so they must not change.
+	for exp, got := range map[int]int{0: ExitOK, 1: ExitError, 10: ExitQC, 20: ExitInput, 30: ExitLocked} {
+		if got != exp {
+			t.Errorf("expected status %d, got %d", exp, got)
+		}
+	}
+}
+
+func TestInputErr(t *testing.T) {
+	if InputErr(nil) != nil {
+		t.Error("expected nil for a nil error")
+	}
+	base := errors.New("bad ped")
+	err := InputErr(base)
+	if err.Error() != "bad ped" || !errors.Is(err, base) {
+		t.Errorf("expected the message and the wrapped error, got %v", err)
+	}
+	if !IsInputError(fmt.Errorf("validate: %w", err)) {
+		t.Error("expected a wrapped input error to be found")
+	}
+	if IsInputError(base) || IsInputError(nil) {
+		t.Error("expected only input errors to be input errors")
+	}
+}
+
+func TestFailOn(t *testing.T) {
+	cases := []struct {
+		in      string
+		exp     FailOn
+		str     string
+		failed  int
+		warned  int
+		status  int
+		invalid bool
+	}{
+		{in: "", exp: FailNever, str: "never", failed: 3, warned: 3, status: ExitOK},
+		{in: "never", exp: FailNever, str: "never", failed: 3, warned: 3, status: ExitOK},
+		{in: "fail", exp: FailOnFail, str: "fail", failed: 1, status: ExitQC},
+		{in: "fail", exp: FailOnFail, str: "fail", warned: 2, status: ExitOK},
+		{in: "fail", exp: FailOnFail, str: "fail", status: ExitOK},
+		{in: "warn", exp: FailOnWarn, str: "warn", failed: 1, status: ExitQC},
+		{in: "warn", exp: FailOnWarn, str: "warn", warned: 1, status: ExitQC},
+		{in: "warn", exp: FailOnWarn, str: "warn", status: ExitOK},
+		{in: "Fail", invalid: true},
+		{in: "always", invalid: true},
+	}
+	for _, c := range cases {
+		f, err := ParseFailOn(c.in)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %s", c.in, err)
+		}
+		if f != c.exp || f.String() != c.str {
+			t.Errorf("%q: expected %s, got %s", c.in, c.str, f)
+		}
+		if got := f.Status(c.failed, c.warned); got != c.status {
+			t.Errorf("%q with %d failed and %d warned: expected %d, got %d", c.in, c.failed, c.warned, c.status, got)
+		}
+	}
+}
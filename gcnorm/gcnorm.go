@@ -0,0 +1,345 @@
+// Package gcnorm corrects depths for GC-content and mappability bias. Depths estimated from
+// short reads drift with the GC-content of the region so that a profile looks wavy and can have
+// false copy-number changes. For each sample, the median depth of regions in each GC (or
+// mappability) bin is found, smoothed over neighboring bins, and depths are divided by the ratio
+// of that to the overall median. It works on parallel slices of depths and covariates so that it
+// can be used by indexcov (16KB tiles) and with emdepth (any windows).
+package gcnorm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/faidx"
+	"github.com/brentp/xopen"
+)
+
+// GCBins is the number of bins that GC-content (0 to 1) is split into for the fit.
+const GCBins = 100
+
+// MapBins is the number of bins that mappability (0 to 1) is split into for the fit.
+const MapBins = 20
+
+// MinTiles is the fewest regions in a bin for its median to be used. Bins with fewer are
+// filled from their neighbors.
+const MinTiles = 20
+
+// span is the number of bins on either side used to smooth the medians.
+const span = 3
+
+// Track holds the GC-content and, optionally, the mappability of fixed-width tiles of each
+// chromosome. Tiles with no value are NaN.
+type Track struct {
+	Width int
+	gc    map[string][]float32
+	mapp  map[string][]float32
+}
+
+// GC returns the GC-content (0 to 1) of each tile of chrom or nil if it is not in the track.
+func (t *Track) GC(chrom string) []float32 {
+	return t.gc[chrom]
+}
+
+// Mappability returns the mappability (0 to 1) of each tile of chrom or nil if it is not known.
+func (t *Track) Mappability(chrom string) []float32 {
+	return t.mapp[chrom]
+}
+
+// Chroms returns the names of the chromosomes in the track.
+func (t *Track) Chroms() []string {
+	chroms := make([]string, 0, len(t.gc))
+	for c := range t.gc {
+		chroms = append(chroms, c)
+	}
+	sort.Strings(chroms)
+	return chroms
+}
+
+func nans(n int) []float32 {
+	v := make([]float32, n)
+	for i := range v {
+		v[i] = float32(math.NaN())
+	}
+	return v
+}
+
+// FromFasta computes the GC-content of each tile of the given chromosomes from an indexed fasta.
+func FromFasta(path string, chroms []string, lengths []int, width int) (*Track, error) {
+	fa, err := faidx.New(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fa.Close()
+	t := &Track{Width: width, gc: make(map[string][]float32, len(chroms))}
+	for i, chrom := range chroms {
+		gcs := nans(lengths[i]/width + 1)
+		for k := range gcs {
+			s, e := k*width, (k+1)*width
+			if e > lengths[i] {
+				e = lengths[i]
+			}
+			if s >= e {
+				continue
+			}
+			seq, err := fa.Get(chrom, s, e)
+			if err != nil {
+				return nil, fmt.Errorf("gcnorm: error getting sequence for %s:%d-%d: %s", chrom, s, e, err)
+			}
+			gcs[k] = gcContent(seq)
+		}
+		t.gc[chrom] = gcs
+	}
+	return t, nil
+}
+
+// gcContent returns the proportion of G and C among the A, C, G and T bases of seq. It is NaN
+// when most of seq is N (e.g. gaps and centromeres).
+func gcContent(seq string) float32 {
+	var gc, acgt int
+	for i := 0; i < len(seq); i++ {
+		switch seq[i] {
+		case 'G', 'C', 'g', 'c':
+			gc++
+			acgt++
+		case 'A', 'T', 'a', 't':
+			acgt++
+		}
+	}
+	if acgt == 0 || acgt < len(seq)/2 {
+		return float32(math.NaN())
+	}
+	return float32(gc) / float32(acgt)
+}
+
+// ReadBed reads a precomputed track with columns chrom, start, end, GC (0 to 1) and an optional
+// mappability (0 to 1). Intervals of any size are averaged into the tiles that they overlap.
+func ReadBed(path string, width int) (*Track, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	type acc struct{ gc, mapp, n, nmap []float64 }
+	accs := make(map[string]*acc)
+	grow := func(v []float64, n int) []float64 {
+		for len(v) < n {
+			v = append(v, 0)
+		}
+		return v
+	}
+	br := bufio.NewReader(rdr)
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' && !strings.HasPrefix(line, "track") {
+			toks := strings.Split(line, "\t")
+			if len(toks) < 4 {
+				return nil, fmt.Errorf("gcnorm: expected at least 4 columns at line %d of %s", i, path)
+			}
+			start, serr := strconv.Atoi(toks[1])
+			end, eerr := strconv.Atoi(toks[2])
+			gc, gerr := strconv.ParseFloat(toks[3], 64)
+			if serr != nil || eerr != nil || gerr != nil {
+				return nil, fmt.Errorf("gcnorm: bad value at line %d of %s", i, path)
+			}
+			mapp := math.NaN()
+			if len(toks) > 4 {
+				if mapp, err = strconv.ParseFloat(toks[4], 64); err != nil {
+					return nil, fmt.Errorf("gcnorm: bad mappability at line %d of %s", i, path)
+				}
+			}
+			a, ok := accs[toks[0]]
+			if !ok {
+				a = &acc{}
+				accs[toks[0]] = a
+			}
+			n := (end-1)/width + 1
+			a.gc, a.n = grow(a.gc, n), grow(a.n, n)
+			a.mapp, a.nmap = grow(a.mapp, n), grow(a.nmap, n)
+			for k := start / width; k*width < end; k++ {
+				s, e := math.Max(float64(start), float64(k*width)), math.Min(float64(end), float64((k+1)*width))
+				a.gc[k] += gc * (e - s)
+				a.n[k] += e - s
+				if !math.IsNaN(mapp) {
+					a.mapp[k] += mapp * (e - s)
+					a.nmap[k] += e - s
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	t := &Track{Width: width, gc: make(map[string][]float32, len(accs)), mapp: make(map[string][]float32)}
+	for chrom, a := range accs {
+		gcs := nans(len(a.gc))
+		for k := range gcs {
+			if a.n[k] > 0 {
+				gcs[k] = float32(a.gc[k] / a.n[k])
+			}
+		}
+		t.gc[chrom] = gcs
+		if sum(a.nmap) == 0 {
+			continue
+		}
+		mapps := nans(len(a.mapp))
+		for k := range mapps {
+			if a.nmap[k] > 0 {
+				mapps[k] = float32(a.mapp[k] / a.nmap[k])
+			}
+		}
+		t.mapp[chrom] = mapps
+	}
+	return t, nil
+}
+
+func sum(a []float64) float64 {
+	var s float64
+	for _, v := range a {
+		s += v
+	}
+	return s
+}
+
+// Curve gives the depth of each bin of a covariate relative to the overall median.
+type Curve []float64
+
+// Model is the GC and, if given, mappability correction of a single sample.
+type Model struct {
+	GC          Curve
+	Mappability Curve
+}
+
+func bin(v float32, nbins int) int {
+	b := int(v * float32(nbins))
+	if b >= nbins {
+		b = nbins - 1
+	}
+	if b < 0 {
+		b = 0
+	}
+	return b
+}
+
+func median(vals []float64) float64 {
+	sort.Float64s(vals)
+	n := len(vals)
+	if n%2 == 1 {
+		return vals[n/2]
+	}
+	return (vals[n/2-1] + vals[n/2]) / 2
+}
+
+// FitCurve finds the median depth in each of nbins bins of the covariate, relative to the median
+// of all depths, and smooths it with tricube weights over neighboring bins as in loess.
+// Depths <= 0 and NaN covariates are not used. Bins past either end of the data take the
+// nearest fitted value and those in a wide gap are 1.
+func FitCurve(depths []float32, covariate []float32, nbins int) Curve {
+	byBin := make([][]float64, nbins)
+	all := make([]float64, 0, len(depths))
+	for i, d := range depths {
+		if d <= 0 || i >= len(covariate) || math.IsNaN(float64(covariate[i])) {
+			continue
+		}
+		b := bin(covariate[i], nbins)
+		byBin[b] = append(byBin[b], float64(d))
+		all = append(all, float64(d))
+	}
+	curve := make(Curve, nbins)
+	if len(all) == 0 {
+		for i := range curve {
+			curve[i] = 1
+		}
+		return curve
+	}
+	mid := median(all)
+	meds := make([]float64, nbins)
+	counts := make([]float64, nbins)
+	for b, vals := range byBin {
+		if len(vals) >= MinTiles {
+			meds[b] = median(vals) / mid
+			counts[b] = float64(len(vals))
+		}
+	}
+	for b := range curve {
+		var num, den float64
+		for o := -span; o <= span; o++ {
+			j := b + o
+			if j < 0 || j >= nbins || counts[j] == 0 {
+				continue
+			}
+			u := math.Abs(float64(o)) / float64(span+1)
+			w := math.Pow(1-u*u*u, 3) * math.Sqrt(counts[j])
+			num += w * meds[j]
+			den += w
+		}
+		curve[b] = 1
+		if den > 0 {
+			curve[b] = num / den
+		}
+	}
+	// bins beyond the data take the nearest fitted value.
+	first, last := -1, -1
+	for b := range counts {
+		if counts[b] > 0 {
+			if first == -1 {
+				first = b
+			}
+			last = b
+		}
+	}
+	for b := range curve {
+		if first != -1 && b < first-span {
+			curve[b] = curve[first]
+		} else if last != -1 && b > last+span {
+			curve[b] = curve[last]
+		}
+	}
+	return curve
+}
+
+// Fit fits the GC correction and, if mapp is not nil, a mappability correction of the GC
+// corrected depths. depths, gc and mapp are parallel and should come from regions that are
+// expected to have 2 copies (e.g. the autosomes).
+func Fit(depths []float32, gc []float32, mapp []float32) *Model {
+	m := &Model{GC: FitCurve(depths, gc, GCBins)}
+	if mapp != nil {
+		corrected := append([]float32{}, depths...)
+		m.GC.correct(corrected, gc, GCBins)
+		m.Mappability = FitCurve(corrected, mapp, MapBins)
+	}
+	return m
+}
+
+// minFactor limits the correction of regions with very low expected depth.
+const minFactor = 0.1
+
+func (c Curve) correct(depths []float32, covariate []float32, nbins int) {
+	if c == nil || covariate == nil {
+		return
+	}
+	for i, d := range depths {
+		if i >= len(covariate) || math.IsNaN(float64(covariate[i])) {
+			continue
+		}
+		if f := c[bin(covariate[i], nbins)]; f > minFactor {
+			depths[i] = float32(float64(d) / f)
+		}
+	}
+}
+
+// Correct divides each depth in place by the expected depth for its GC and mappability.
+// Depths with a NaN covariate are not changed.
+func (m *Model) Correct(depths []float32, gc []float32, mapp []float32) {
+	m.GC.correct(depths, gc, GCBins)
+	m.Mappability.correct(depths, mapp, MapBins)
+}
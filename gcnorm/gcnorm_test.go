@@ -0,0 +1,50 @@
+package gcnorm_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/brentp/goleft/gcnorm"
+)
+
+func TestCorrect(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := 20000
+	depths := make([]float32, n)
+	gc := make([]float32, n)
+	for i := range depths {
+		gc[i] = float32(0.3 + 0.3*rng.Float64())
+		// depth falls off away from 45% GC.
+		bias := 1 - 4*math.Pow(float64(gc[i])-0.45, 2)
+		depths[i] = float32(bias * (1 + 0.02*rng.NormFloat64()))
+	}
+	gc[0] = float32(math.NaN())
+	m := gcnorm.Fit(depths, gc, nil)
+	m.Correct(depths, gc, nil)
+	// after correction, depths should be flat across GC.
+	var means []float64
+	for _, lim := range [][2]float32{{0.3, 0.33}, {0.44, 0.46}, {0.57, 0.6}} {
+		var sum float64
+		var k int
+		for i, g := range gc {
+			if g >= lim[0] && g < lim[1] {
+				sum += float64(depths[i])
+				k++
+			}
+		}
+		means = append(means, sum/float64(k))
+	}
+	for _, mean := range means {
+		if math.Abs(mean-means[1]) > 0.02 {
+			t.Errorf("expected same depth for all GC after correction, got: %.3f", means)
+		}
+	}
+}
+
+func TestFitCurveNoData(t *testing.T) {
+	c := gcnorm.FitCurve(nil, nil, gcnorm.GCBins)
+	if len(c) != gcnorm.GCBins || c[0] != 1 {
+		t.Errorf("expected flat curve with no data, got: %v", c)
+	}
+}
@@ -0,0 +1,182 @@
+//go:build ignore
+
+// update fills the cytobands of the bundled genomes from the UCSC cytoBand tables and the blacklist
+// from the ENCODE blacklists (v2) of Amemiya et al. It needs network access and is run from the
+// genomes directory with:
+//
+//	go generate
+//
+// Only the regions on the chromosomes of each genome are kept and they are named as in the
+// chromosomes (e.g. 7 rather than chr7). The rest of each file is left as it is.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/goleft/genomes"
+)
+
+// source is a table of chrom, start and end and, for cytobands, the name.
+type source struct {
+	genome string
+	url    string
+}
+
+var cytobands = []source{
+	{"GRCh37", "https://hgdownload.soe.ucsc.edu/goldenPath/hg19/database/cytoBand.txt.gz"},
+	{"GRCh38", "https://hgdownload.soe.ucsc.edu/goldenPath/hg38/database/cytoBand.txt.gz"},
+}
+
+var blacklists = []source{
+	{"GRCh37", "https://github.com/Boyle-Lab/Blacklist/raw/master/lists/hg19-blacklist.v2.bed.gz"},
+	{"GRCh38", "https://github.com/Boyle-Lab/Blacklist/raw/master/lists/hg38-blacklist.v2.bed.gz"},
+}
+
+// row is a line of a table with the chromosome named as in the genome.
+type row struct {
+	chrom      string
+	start, end int
+	name       string
+}
+
+// fetch reads the rows of the table at url on the chromosomes of g.
+func fetch(g *genomes.Genome, url string) ([]row, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting %s: %s", url, resp.Status)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var rows []row
+	order := make(map[string]int)
+	for i, c := range g.Chromosomes {
+		order[c.Name] = i
+	}
+	sc := bufio.NewScanner(gz)
+	for sc.Scan() {
+		toks := strings.Split(sc.Text(), "\t")
+		if len(toks) < 3 || strings.HasPrefix(toks[0], "#") {
+			continue
+		}
+		c, ok := g.Chromosome(toks[0])
+		if !ok {
+			continue
+		}
+		start, serr := strconv.Atoi(toks[1])
+		end, eerr := strconv.Atoi(toks[2])
+		if serr != nil || eerr != nil {
+			return nil, fmt.Errorf("bad line in %s: %s", url, sc.Text())
+		}
+		r := row{chrom: c.Name, start: start, end: end}
+		if len(toks) > 3 {
+			r.name = toks[3]
+		}
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].chrom != rows[j].chrom {
+			return order[rows[i].chrom] < order[rows[j].chrom]
+		}
+		return rows[i].start < rows[j].start
+	})
+	return rows, sc.Err()
+}
+
+// replace sets the array of key in the JSON in b to lines, one per element.
+func replace(b []byte, key string, lines []string) ([]byte, error) {
+	start := bytes.Index(b, []byte("\n  \""+key+"\": ["))
+	if start == -1 {
+		return nil, fmt.Errorf("no %s in file", key)
+	}
+	start++
+	end := bytes.Index(b[start:], []byte("]"))
+	// the array is either [] or ends with a "  ]" line.
+	if bytes.HasPrefix(b[start+end-1:], []byte("[]")) {
+		end++
+	} else if end = bytes.Index(b[start:], []byte("\n  ]")); end == -1 {
+		return nil, fmt.Errorf("unterminated %s in file", key)
+	} else {
+		end += len("\n  ]")
+	}
+	var out bytes.Buffer
+	out.Write(b[:start])
+	fmt.Fprintf(&out, "  %q: [", key)
+	if len(lines) > 0 {
+		out.WriteString("\n    " + strings.Join(lines, ",\n    ") + "\n  ")
+	}
+	out.WriteString("]")
+	out.Write(b[start+end:])
+	return out.Bytes(), nil
+}
+
+func update(genome, key string, lines []string) {
+	path := "data/" + genome + ".json"
+	orig, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := replace(orig, key, lines)
+	if err != nil {
+		log.Fatalf("%s: %s", path, err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+	// Load checks that the regions are on the chromosomes.
+	if _, err := genomes.Load(path); err != nil {
+		ioutil.WriteFile(path, orig, 0644)
+		log.Fatalf("%s: %s", path, err)
+	}
+	log.Printf("wrote %d %s to %s", len(lines), key, path)
+}
+
+func main() {
+	for _, s := range cytobands {
+		g, rows := fetchGenome(s)
+		var lines []string
+		for _, r := range rows {
+			// some contigs in the UCSC files have a single band with no name.
+			if r.name != "" {
+				lines = append(lines, fmt.Sprintf(`{"chrom": %q, "start": %d, "end": %d, "name": %q}`, r.chrom, r.start, r.end, r.name))
+			}
+		}
+		update(g.Name, "cytobands", lines)
+	}
+	for _, s := range blacklists {
+		g, rows := fetchGenome(s)
+		lines := make([]string, len(rows))
+		for i, r := range rows {
+			// the 4th column of the blacklists is the reason, which is not kept.
+			lines[i] = fmt.Sprintf(`{"chrom": %q, "start": %d, "end": %d}`, r.chrom, r.start, r.end)
+		}
+		update(g.Name, "blacklist", lines)
+	}
+}
+
+// fetchGenome returns the genome of s and the rows of its table.
+func fetchGenome(s source) (*genomes.Genome, []row) {
+	g, err := genomes.Get(s.genome)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rows, err := fetch(g, s.url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return g, rows
+}
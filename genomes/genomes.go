@@ -0,0 +1,325 @@
+// Package genomes is a registry of the build-specific data used by goleft: the chromosomes and their
+// lengths and centromeres, the sex chromosomes and their pseudo-autosomal regions, regions to
+// blacklist, cytobands and the aliases of each chromosome name. GRCh37 and GRCh38 are embedded as JSON
+// and other builds or organisms can be loaded from a file in the same format with Load and added
+// with Register.
+package genomes
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/brentp/xopen"
+)
+
+//go:generate go run data/update.go
+
+//go:embed data/*.json
+var bundled embed.FS
+
+// Region is a 0-based, half-open interval.
+type Region struct {
+	Chrom string `json:"chrom"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Band is a cytoband such as q11.23.
+type Band struct {
+	Chrom string `json:"chrom"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Name  string `json:"name"`
+}
+
+// Chromosome is a chromosome of a build. Centromere is the boundary of the p and q arms or 0 if
+// it is not known (or the chromosome is acrocentric without a p arm in the assembly).
+type Chromosome struct {
+	Name       string `json:"name"`
+	Length     int    `json:"length"`
+	Centromere int    `json:"centromere,omitempty"`
+}
+
+// Genome holds the data of a build.
+type Genome struct {
+	// Name is the name of the build, e.g. GRCh38.
+	Name string `json:"name"`
+	// Aliases are other names of the build that can be given to Get, e.g. hg38.
+	Aliases []string `json:"aliases,omitempty"`
+	// Sex are the names of the sex chromosomes.
+	Sex []string `json:"sex,omitempty"`
+	// Chromosomes are the primary chromosomes of the build. A build is detected from the
+	// lengths of these.
+	Chromosomes []Chromosome `json:"chromosomes"`
+	// PARs are the pseudo-autosomal regions of the sex chromosomes.
+	PARs []Region `json:"pars,omitempty"`
+	// Blacklist are regions with artifactual depth such as the ENCODE blacklists. go generate
+	// fills those of GRCh37 and GRCh38; other lists can be set in a file for Load.
+	Blacklist []Region `json:"blacklist,omitempty"`
+	// Cytobands are the bands used to label positions. If empty, Bands returns the arms from the
+	// centromeres.
+	Cytobands []Band `json:"cytobands,omitempty"`
+	// ChromAliases are groups of names for the same chromosome, e.g. 1, chr1 and NC_000001.11.
+	// The first name of each group is the one used in the other fields.
+	ChromAliases [][]string `json:"chrom_aliases,omitempty"`
+}
+
+var (
+	mu       sync.RWMutex
+	registry []*Genome
+)
+
+func init() {
+	files, err := bundled.ReadDir("data")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		b, err := bundled.ReadFile("data/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		g, err := parse(b, f.Name())
+		if err != nil {
+			panic(err)
+		}
+		if err := Register(g); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// parse decodes and validates a genome from the JSON in b.
+func parse(b []byte, path string) (*Genome, error) {
+	g := &Genome{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(g); err != nil {
+		return nil, fmt.Errorf("genomes: error reading %s: %s", path, err)
+	}
+	if err := g.validate(); err != nil {
+		return nil, fmt.Errorf("genomes: %s: %s", path, err)
+	}
+	return g, nil
+}
+
+// validate checks that the name and all regions are set and that regions are on known chromosomes.
+func (g *Genome) validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("a name is required")
+	}
+	if len(g.Chromosomes) == 0 {
+		return fmt.Errorf("%s: at least 1 chromosome is required", g.Name)
+	}
+	lengths := make(map[string]int, len(g.Chromosomes))
+	for _, c := range g.Chromosomes {
+		if c.Name == "" || c.Length <= 0 || c.Centromere < 0 || c.Centromere >= c.Length {
+			return fmt.Errorf("%s: bad chromosome: %+v", g.Name, c)
+		}
+		if _, ok := lengths[c.Name]; ok {
+			return fmt.Errorf("%s: chromosome %s is given more than once", g.Name, c.Name)
+		}
+		lengths[c.Name] = c.Length
+	}
+	check := func(kind, chrom string, start, end int) error {
+		l, ok := lengths[chrom]
+		if !ok {
+			return fmt.Errorf("%s: %s on unknown chromosome %s", g.Name, kind, chrom)
+		}
+		if start < 0 || end <= start || end > l {
+			return fmt.Errorf("%s: bad %s %s:%d-%d", g.Name, kind, chrom, start, end)
+		}
+		return nil
+	}
+	for _, s := range g.Sex {
+		if _, ok := lengths[s]; !ok {
+			return fmt.Errorf("%s: unknown sex chromosome %s", g.Name, s)
+		}
+	}
+	for _, r := range g.PARs {
+		if err := check("PAR", r.Chrom, r.Start, r.End); err != nil {
+			return err
+		}
+	}
+	for _, r := range g.Blacklist {
+		if err := check("blacklist region", r.Chrom, r.Start, r.End); err != nil {
+			return err
+		}
+	}
+	for _, b := range g.Cytobands {
+		if err := check("band", b.Chrom, b.Start, b.End); err != nil {
+			return err
+		}
+	}
+	for _, a := range g.ChromAliases {
+		if len(a) < 2 {
+			return fmt.Errorf("%s: a group of chromosome aliases needs at least 2 names: %v", g.Name, a)
+		}
+	}
+	return nil
+}
+
+// Register adds g to the registry so that it can be found with Get and is used by Detect. It is an
+// error if its name or one of its aliases is already registered.
+func Register(g *Genome) error {
+	if err := g.validate(); err != nil {
+		return fmt.Errorf("genomes: %s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, n := range append([]string{g.Name}, g.Aliases...) {
+		if o := find(n); o != nil {
+			return fmt.Errorf("genomes: %s is already registered for %s", n, o.Name)
+		}
+	}
+	registry = append(registry, g)
+	return nil
+}
+
+// find returns the registered genome with the name or alias n (ignoring case) or nil.
+func find(n string) *Genome {
+	for _, g := range registry {
+		if strings.EqualFold(g.Name, n) {
+			return g
+		}
+		for _, a := range g.Aliases {
+			if strings.EqualFold(a, n) {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// Get returns the registered genome with the given name or alias, e.g. GRCh38 or hg38.
+func Get(name string) (*Genome, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if g := find(name); g != nil {
+		return g, nil
+	}
+	names := make([]string, len(registry))
+	for i, g := range registry {
+		names[i] = g.Name
+	}
+	return nil, fmt.Errorf("genomes: unknown genome %s. use one of %s or a file", name, strings.Join(names, ", "))
+}
+
+// All returns the registered genomes sorted by name.
+func All() []*Genome {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := append([]*Genome{}, registry...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Load reads a genome from a JSON file (optionally gzipped) in the format of the bundled genomes.
+// It is not registered.
+func Load(path string) (*Genome, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	b, err := io.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	return parse(b, path)
+}
+
+// Resolve returns the genome from file if it is set and otherwise the registered genome name. It
+// returns nil if both are empty.
+func Resolve(name, file string) (*Genome, error) {
+	if file != "" {
+		if name != "" {
+			return nil, fmt.Errorf("genomes: only one of a genome name (%s) and file (%s) can be given", name, file)
+		}
+		return Load(file)
+	}
+	if name == "" {
+		return nil, nil
+	}
+	return Get(name)
+}
+
+// Chromosome returns the chromosome with the given name, which may be any of its aliases.
+func (g *Genome) Chromosome(name string) (Chromosome, bool) {
+	name = g.key(name)
+	for _, c := range g.Chromosomes {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Chromosome{}, false
+}
+
+// key returns the name used for chrom in g.
+func (g *Genome) key(chrom string) string {
+	for _, a := range g.ChromAliases {
+		for _, n := range a {
+			if n == chrom {
+				return a[0]
+			}
+		}
+	}
+	return chrom
+}
+
+// Matches returns true if the genome has a chromosome with the name (or an alias) and length.
+func (g *Genome) Matches(name string, length int) bool {
+	c, ok := g.Chromosome(name)
+	return ok && c.Length == length
+}
+
+// Bands returns the cytobands of a chromosome sorted by start. If the genome has no cytobands, the
+// p and q arms are returned for chromosomes with a known centromere.
+func (g *Genome) Bands(chrom string) []Band {
+	c, ok := g.Chromosome(chrom)
+	if !ok {
+		return nil
+	}
+	var bands []Band
+	for _, b := range g.Cytobands {
+		if b.Chrom == c.Name {
+			bands = append(bands, b)
+		}
+	}
+	if len(bands) == 0 && c.Centromere > 0 {
+		return []Band{{c.Name, 0, c.Centromere, "p"}, {c.Name, c.Centromere, c.Length, "q"}}
+	}
+	sort.Slice(bands, func(i, j int) bool { return bands[i].Start < bands[j].Start })
+	return bands
+}
+
+// Regions returns the regions of rs on a chromosome, which may be named by any of its aliases.
+func (g *Genome) Regions(rs []Region, chrom string) []Region {
+	chrom = g.key(chrom)
+	var out []Region
+	for _, r := range rs {
+		if r.Chrom == chrom {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Detect returns the registered genome for which a chromosome has the given name (or an alias) and
+// length, or nil.
+func Detect(name string, length int) *Genome {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, g := range registry {
+		if g.Matches(name, length) {
+			return g
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package genomes_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/brentp/goleft/genomes"
+)
+
+func TestBundled(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{{"GRCh38", "GRCh38"}, {"hg38", "GRCh38"}, {"grch37", "GRCh37"}, {"hg19", "GRCh37"}, {"hs37d5", "GRCh37"}} {
+		g, err := genomes.Get(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Name != tc.want {
+			t.Errorf("Get(%s): got %s, want %s", tc.name, g.Name, tc.want)
+		}
+	}
+	if _, err := genomes.Get("hg17"); err == nil {
+		t.Error("expected an error for an unknown genome")
+	}
+
+	g, _ := genomes.Get("hg38")
+	if !reflect.DeepEqual(g.Sex, []string{"X", "Y"}) || len(g.Chromosomes) != 24 || len(g.PARs) != 4 {
+		t.Errorf("unexpected GRCh38: sex: %v, %d chromosomes, %d PARs", g.Sex, len(g.Chromosomes), len(g.PARs))
+	}
+	// chromosomes can be found by any alias.
+	for _, n := range []string{"X", "chrX", "NC_000023.11"} {
+		if c, ok := g.Chromosome(n); !ok || c.Length != 156040895 {
+			t.Errorf("Chromosome(%s): got %v %v", n, c, ok)
+		}
+	}
+	if pars := g.Regions(g.PARs, "chrY"); len(pars) != 2 || pars[0].Start != 10000 {
+		t.Errorf("unexpected PARs of chrY: %v", pars)
+	}
+	arms := g.Bands("chr7")
+	if len(arms) != 2 || arms[0].Name != "p" || arms[1].Start != 60100000 || arms[1].End != 159345973 {
+		t.Errorf("unexpected arms of chr7: %v", arms)
+	}
+
+	if d := genomes.Detect("chr1", 249250621); d == nil || d.Name != "GRCh37" {
+		t.Errorf("expected to detect GRCh37, got: %v", d)
+	}
+	if d := genomes.Detect("chr1", 1000); d != nil {
+		t.Errorf("expected no genome for an unknown length, got: %s", d.Name)
+	}
+}
+
+const custom = `{
+  "name": "galGal6",
+  "aliases": ["chicken"],
+  "sex": ["Z", "W"],
+  "chromosomes": [
+    {"name": "1", "length": 197608386},
+    {"name": "Z", "length": 82529921, "centromere": 42000000},
+    {"name": "W", "length": 6813114}
+  ],
+  "blacklist": [{"chrom": "1", "start": 0, "end": 10000}],
+  "cytobands": [{"chrom": "Z", "start": 0, "end": 42000000, "name": "p1"}, {"chrom": "Z", "start": 42000000, "end": 82529921, "name": "q1"}],
+  "chrom_aliases": [["1", "chr1"], ["Z", "chrZ"], ["W", "chrW"]]
+}`
+
+func write(t *testing.T, dir, name, s string) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genomes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, err := genomes.Resolve("", write(t, dir, "chicken.json", custom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Name != "galGal6" || len(g.Blacklist) != 1 || len(g.Bands("chrZ")) != 2 || g.Bands("chr1") != nil {
+		t.Errorf("unexpected genome: %+v", g)
+	}
+	// it is not registered until Register is called.
+	if _, err := genomes.Get("chicken"); err == nil {
+		t.Error("expected Load not to register the genome")
+	}
+	if err := genomes.Register(g); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := genomes.Get("chicken"); err != nil || r != g {
+		t.Errorf("expected the registered genome, got: %v %v", r, err)
+	}
+	if err := genomes.Register(g); err == nil {
+		t.Error("expected an error for a genome that is already registered")
+	}
+
+	for name, bad := range map[string]string{
+		"no-name":      `{"chromosomes": [{"name": "1", "length": 10}]}`,
+		"no-chroms":    `{"name": "x"}`,
+		"unknown":      `{"name": "x", "chromosomes": [{"name": "1", "length": 10}], "extra": 1}`,
+		"bad-region":   `{"name": "x", "chromosomes": [{"name": "1", "length": 10}], "pars": [{"chrom": "1", "start": 5, "end": 11}]}`,
+		"bad-chrom":    `{"name": "x", "chromosomes": [{"name": "1", "length": 10}], "blacklist": [{"chrom": "2", "start": 0, "end": 1}]}`,
+		"bad-sex":      `{"name": "x", "chromosomes": [{"name": "1", "length": 10}], "sex": ["X"]}`,
+		"bad-centro":   `{"name": "x", "chromosomes": [{"name": "1", "length": 10, "centromere": 10}]}`,
+		"dup-chrom":    `{"name": "x", "chromosomes": [{"name": "1", "length": 10}, {"name": "1", "length": 10}]}`,
+		"short-alias":  `{"name": "x", "chromosomes": [{"name": "1", "length": 10}], "chrom_aliases": [["1"]]}`,
+		"not-json.txt": `chr1	10`,
+	} {
+		if _, err := genomes.Load(write(t, dir, name, bad)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := genomes.Resolve("hg38", filepath.Join(dir, "chicken.json")); err == nil {
+		t.Error("expected an error for both a name and a file")
+	}
+	if g, err := genomes.Resolve("", ""); g != nil || err != nil {
+		t.Errorf("expected no genome, got: %v %v", g, err)
+	}
+}
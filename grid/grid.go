@@ -0,0 +1,151 @@
+// Package grid defines the bins in which goleft tools report depths so that the outputs of
+// different tools and runs (indexcov, depth, depthwed, dcnv, indexcov-gather) and of external tools
+// can be checked to be comparable rather than relying on each using the same coordinate arithmetic.
+//
+// A grid is the bins [i*Width, (i+1)*Width) of each chromosome of a build with the last bin ending
+// at the end of the chromosome. Its name, e.g. goleft-grid-v1:GRCh38:16384, can be written with an
+// output and parsed by a reader. Version is increased if the definition of the bins changes.
+package grid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/goleft/genomes"
+)
+
+// TileWidth is the length of the tiles of the linear index of a bai (and of a crai or csi as read by
+// indexcov). Depths from indexes are on a grid of this width or a multiple of it.
+const TileWidth = 16384
+
+// Version is the version of the definition of the bins.
+const Version = 1
+
+const prefix = "goleft-grid-v"
+
+// Grid is a binning of the chromosomes of a build.
+type Grid struct {
+	// Build is the name of the genome build, e.g. GRCh38, or "" if it is not known. A grid with an
+	// unknown build is compatible with one of any build.
+	Build   string
+	Width   int
+	Version int
+}
+
+// New returns the grid with bins of width on build. Aliases of bundled builds (e.g. hg38) are
+// replaced by the name of the build so that they compare equal.
+func New(build string, width int) (Grid, error) {
+	if width <= 0 {
+		return Grid{}, fmt.Errorf("grid: width must be positive, got %d", width)
+	}
+	if strings.Contains(build, ":") {
+		return Grid{}, fmt.Errorf("grid: build must not contain ':', got %q", build)
+	}
+	if g, err := genomes.Get(build); err == nil {
+		build = g.Name
+	}
+	return Grid{Build: build, Width: width, Version: Version}, nil
+}
+
+// Tiles returns the grid of the 16KB tiles of an index on build.
+func Tiles(build string) Grid {
+	g, err := New(build, TileWidth)
+	if err != nil {
+		return Grid{Width: TileWidth, Version: Version}
+	}
+	return g
+}
+
+// String returns the name of the grid, e.g. goleft-grid-v1:GRCh38:16384.
+func (g Grid) String() string {
+	return fmt.Sprintf("%s%d:%s:%d", prefix, g.Version, g.Build, g.Width)
+}
+
+// Parse parses the name of a grid from String.
+func Parse(name string) (Grid, error) {
+	toks := strings.Split(name, ":")
+	if len(toks) != 3 || !strings.HasPrefix(toks[0], prefix) {
+		return Grid{}, fmt.Errorf("grid: expected a name like %s%d:GRCh38:%d, got %q", prefix, Version, TileWidth, name)
+	}
+	v, err := strconv.Atoi(strings.TrimPrefix(toks[0], prefix))
+	if err != nil || v < 1 {
+		return Grid{}, fmt.Errorf("grid: bad version in %q", name)
+	}
+	if v > Version {
+		return Grid{}, fmt.Errorf("grid: %q is from a newer version of goleft (grid version %d)", name, v)
+	}
+	w, err := strconv.Atoi(toks[2])
+	if err != nil || w <= 0 {
+		return Grid{}, fmt.Errorf("grid: bad width in %q", name)
+	}
+	return Grid{Build: toks[1], Width: w, Version: v}, nil
+}
+
+// IsTiled returns true if each bin is made of whole index tiles.
+func (g Grid) IsTiled() bool {
+	return g.Width%TileWidth == 0
+}
+
+// Bin returns the bin that contains pos.
+func (g Grid) Bin(pos int) int {
+	return pos / g.Width
+}
+
+// Bins returns the number of bins on a chromosome of length bases.
+func (g Grid) Bins(length int) int {
+	return (length + g.Width - 1) / g.Width
+}
+
+// Interval returns the start and end of a bin on a chromosome of length bases.
+func (g Grid) Interval(bin, length int) (int, int) {
+	start, end := bin*g.Width, (bin+1)*g.Width
+	if end > length {
+		end = length
+	}
+	return start, end
+}
+
+// Aligned returns an error if [start, end) is not a bin of a chromosome of length bases, e.g.
+// for a line of an output that should be on the grid. Intervals lifted to another build are
+// generally not on its grid.
+func (g Grid) Aligned(start, end, length int) error {
+	if start%g.Width != 0 {
+		return fmt.Errorf("grid: start %d is not a multiple of %d", start, g.Width)
+	}
+	if s, e := g.Interval(g.Bin(start), length); s != start || e != end {
+		return fmt.Errorf("grid: %d-%d is not a bin of %s. expected %d-%d", start, end, g, s, e)
+	}
+	return nil
+}
+
+func (g Grid) sameBuild(o Grid) bool {
+	return g.Build == "" || o.Build == "" || g.Build == o.Build
+}
+
+// Check returns an error if the bins of g and o differ so that values on them can not be compared
+// bin by bin.
+func (g Grid) Check(o Grid) error {
+	if g.Version != o.Version {
+		return fmt.Errorf("grid: %s and %s are different versions", g, o)
+	}
+	if !g.sameBuild(o) {
+		return fmt.Errorf("grid: %s and %s are on different builds", g, o)
+	}
+	if g.Width != o.Width {
+		return fmt.Errorf("grid: %s and %s have different widths", g, o)
+	}
+	return nil
+}
+
+// Coarsens returns the number of bins of g in each bin of o or an error if the bins of o are not
+// made of whole bins of g so that values on g can not be summed or averaged to o.
+func (g Grid) Coarsens(o Grid) (int, error) {
+	if g.Version != o.Version || !g.sameBuild(o) {
+		return 0, fmt.Errorf("grid: %s and %s are different versions or builds", g, o)
+	}
+	if o.Width%g.Width != 0 {
+		return 0, fmt.Errorf("grid: bins of %d are not made of whole bins of %d", o.Width, g.Width)
+	}
+	return o.Width / g.Width, nil
+}
@@ -0,0 +1,77 @@
+package grid_test
+
+import (
+	"testing"
+
+	"github.com/brentp/goleft/grid"
+)
+
+func TestName(t *testing.T) {
+	g, err := grid.New("hg38", 65536)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.String() != "goleft-grid-v1:GRCh38:65536" {
+		t.Errorf("unexpected name: %s", g)
+	}
+	p, err := grid.Parse(g.String())
+	if err != nil || p != g {
+		t.Errorf("Parse(%s): got %v %v", g, p, err)
+	}
+	if u := grid.Tiles(""); u.String() != "goleft-grid-v1::16384" || !u.IsTiled() {
+		t.Errorf("unexpected grid of an unknown build: %s", u)
+	}
+	for _, bad := range []string{"", "goleft-grid-v1:GRCh38", "goleft-grid-vx:GRCh38:16384", "goleft-grid-v1:GRCh38:0", "goleft-grid-v9:GRCh38:16384"} {
+		if _, err := grid.Parse(bad); err == nil {
+			t.Errorf("Parse(%q): expected an error", bad)
+		}
+	}
+	if _, err := grid.New("GRCh38", 0); err == nil {
+		t.Error("expected an error for a width of 0")
+	}
+}
+
+func TestBins(t *testing.T) {
+	g := grid.Tiles("GRCh37")
+	if g.Bin(16383) != 0 || g.Bin(16384) != 1 || g.Bins(40000) != 3 {
+		t.Errorf("unexpected bins: %d %d %d", g.Bin(16383), g.Bin(16384), g.Bins(40000))
+	}
+	if s, e := g.Interval(2, 40000); s != 32768 || e != 40000 {
+		t.Errorf("unexpected last bin: %d-%d", s, e)
+	}
+	if err := g.Aligned(32768, 40000, 40000); err != nil {
+		t.Error(err)
+	}
+	for _, iv := range [][2]int{{100, 16484}, {0, 10000}, {16384, 40000}} {
+		if err := g.Aligned(iv[0], iv[1], 40000); err == nil {
+			t.Errorf("expected %v not to be aligned", iv)
+		}
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	a := grid.Tiles("GRCh38")
+	if err := a.Check(grid.Tiles("hg38")); err != nil {
+		t.Error(err)
+	}
+	if err := a.Check(grid.Tiles("")); err != nil {
+		t.Errorf("expected an unknown build to match: %s", err)
+	}
+	if err := a.Check(grid.Tiles("GRCh37")); err == nil {
+		t.Error("expected different builds to differ")
+	}
+	b, _ := grid.New("GRCh38", 4*grid.TileWidth)
+	if err := a.Check(b); err == nil {
+		t.Error("expected different widths to differ")
+	}
+	if n, err := a.Coarsens(b); err != nil || n != 4 {
+		t.Errorf("Coarsens: got %d %v", n, err)
+	}
+	if _, err := b.Coarsens(a); err == nil {
+		t.Error("expected a larger grid not to coarsen to a smaller one")
+	}
+	c, _ := grid.New("GRCh38", 100000)
+	if _, err := a.Coarsens(c); err == nil {
+		t.Error("expected 100KB bins not to be made of 16KB tiles")
+	}
+}
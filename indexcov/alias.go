@@ -0,0 +1,141 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/brentp/goleft/genomes"
+	"github.com/brentp/xopen"
+)
+
+// chromAliases unifies the names used for the same chromosome by different builds and
+// databases, e.g. NC_000001.11, 1 and chr1.
+type chromAliases struct {
+	// group gives the index into names of each alias.
+	group map[string]int
+	// names holds the aliases of each chromosome. The first is the key used for matching.
+	names [][]string
+}
+
+// bundledAliases returns the aliases of the chromosomes of the registered genomes: the primary
+// chromosomes of GRCh37 and GRCh38 with UCSC, Ensembl and RefSeq names.
+func bundledAliases() [][]string {
+	var groups [][]string
+	for _, g := range genomes.All() {
+		groups = append(groups, g.ChromAliases...)
+	}
+	return groups
+}
+
+func newChromAliases(groups [][]string) *chromAliases {
+	a := &chromAliases{group: make(map[string]int)}
+	for _, g := range groups {
+		a.add(g)
+	}
+	return a
+}
+
+// defaultAliases are the aliases of the bundled genomes. They are used by the exported functions
+// and are not changed: each Run adds those of its genome and Options.AliasFile to a copy.
+var defaultAliases = newChromAliases(bundledAliases())
+
+// add adds a group of names for the same chromosome. If any of the names is already known,
+// the group is merged with the existing one.
+func (a *chromAliases) add(names []string) {
+	g := -1
+	for _, n := range names {
+		if i, ok := a.group[n]; ok {
+			g = i
+			break
+		}
+	}
+	if g == -1 {
+		g = len(a.names)
+		a.names = append(a.names, nil)
+	}
+	for _, n := range names {
+		if _, ok := a.group[n]; ok {
+			continue
+		}
+		a.group[n] = g
+		a.names[g] = append(a.names[g], n)
+	}
+}
+
+// key returns the name used to match chrom. Names that are not in the table are their own key.
+func (a *chromAliases) key(chrom string) string {
+	if g, ok := a.group[chrom]; ok {
+		return a.names[g][0]
+	}
+	return chrom
+}
+
+// same returns true if x and y are names for the same chromosome.
+func (a *chromAliases) same(x, y string) bool {
+	return x == y || a.key(x) == a.key(y)
+}
+
+// isAccession returns true for RefSeq names of known chromosomes. The default --excludepatt
+// has ^NC for unplaced contigs and these must not be excluded by it.
+func (a *chromAliases) isAccession(chrom string) bool {
+	_, ok := a.group[chrom]
+	return ok && strings.HasPrefix(chrom, "NC_")
+}
+
+// with returns a copy of a with the groups of names added.
+func (a *chromAliases) with(groups [][]string) *chromAliases {
+	out := newChromAliases(a.names)
+	for _, g := range groups {
+		out.add(g)
+	}
+	return out
+}
+
+// readAliases reads a UCSC chromAlias style file with the names of a chromosome on each
+// tab-delimited line and returns a copy of a with those added.
+func (a *chromAliases) readAliases(path string) (*chromAliases, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	out := newChromAliases(a.names)
+	br := bufio.NewReader(rdr)
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' {
+			toks := strings.Fields(line)
+			if len(toks) < 2 {
+				return nil, fmt.Errorf("indexcov: expected at least 2 names at line %d of %s", i, path)
+			}
+			out.add(toks)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return out, nil
+}
+
+// excludeChrom returns true if the chromosome name matches the exclude pattern. RefSeq names
+// of known chromosomes are matched using their other names.
+func excludeChrom(opts *Options, chrom string) bool {
+	if opts.Exclude == nil {
+		return false
+	}
+	al := opts.aliasTable()
+	if !al.isAccession(chrom) {
+		return opts.Exclude.MatchString(chrom)
+	}
+	for _, n := range al.names[al.group[chrom]] {
+		if !strings.HasPrefix(n, "NC_") && opts.Exclude.MatchString(n) {
+			return true
+		}
+	}
+	return false
+}
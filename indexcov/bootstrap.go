@@ -0,0 +1,185 @@
+package indexcov
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// tiles are resampled in blocks of this many adjacent tiles so that the intervals account for the
+// correlation of the depths of nearby tiles.
+const bootBlock = 8
+
+// the seed of the bootstrap of each sample is this plus the index of the sample so that the intervals
+// are the same from run to run.
+const bootSeed = 42
+
+// cnInterval is a copy-number estimate with its 95% bootstrap interval.
+type cnInterval struct {
+	CN jsonFloat `json:"cn"`
+	Lo jsonFloat `json:"lo"`
+	Hi jsonFloat `json:"hi"`
+}
+
+// bootstrapper resamples the tiles of each chromosome to give intervals for the copy-number
+// estimates and the confidence of the sex calls.
+type bootstrapper struct {
+	n      int
+	ploidy int
+	mu     sync.Mutex
+	// sex holds the bootstrap estimates of each sample for each sex chromosome keyed as in --sex.
+	sex map[string][][]float64
+}
+
+func newBootstrapper(n int, ploidy int) *bootstrapper {
+	return &bootstrapper{n: n, ploidy: ploidy, sex: make(map[string][][]float64)}
+}
+
+// cnTiles returns the tiles used by GetCN: tiles that are exactly 0 (e.g. the centromere) are
+// dropped and, if more than 30% of the tiles are below 0.02 (as on Y), so are those.
+func cnTiles(d []float32) []float32 {
+	tmp := make([]float32, 0, len(d))
+	lows := 0
+	for _, dp := range d {
+		if dp != 0 {
+			tmp = append(tmp, dp)
+			if dp < 0.02 {
+				lows++
+			}
+		}
+	}
+	if float64(lows)/float64(len(d)) <= 0.3 {
+		return tmp
+	}
+	kept := tmp[:0]
+	for _, dp := range tmp {
+		if dp >= 0.02 {
+			kept = append(kept, dp)
+		}
+	}
+	return kept
+}
+
+// bootstrapCN returns n estimates of the copy-number (as in GetCN) from circular block resamples of
+// the tiles in d for samples of the given ploidy. The median of each resample is found from the count of each tile so that
+// the tiles are only sorted once.
+func bootstrapCN(d []float32, n int, ploidy int, rng *rand.Rand) []float64 {
+	vals := cnTiles(d)
+	m := len(vals)
+	if m == 0 {
+		return nil
+	}
+	order := make([]int, m)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return vals[order[a]] < vals[order[b]] })
+	blocks := (m + bootBlock - 1) / bootBlock
+	half := blocks * bootBlock / 2
+	counts := make([]int32, m)
+	reps := make([]float64, n)
+	for b := range reps {
+		for i := range counts {
+			counts[i] = 0
+		}
+		for k := 0; k < blocks; k++ {
+			s := rng.Intn(m)
+			for j := 0; j < bootBlock; j++ {
+				counts[(s+j)%m]++
+			}
+		}
+		cum := 0
+		for _, o := range order {
+			if cum += int(counts[o]); cum > half {
+				reps[b] = float64(ploidy) * float64(vals[o])
+				break
+			}
+		}
+	}
+	return reps
+}
+
+// interval returns the 2.5th and 97.5th percentiles of the estimates.
+func interval(reps []float64) (lo, hi float64) {
+	if len(reps) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	s := append([]float64{}, reps...)
+	sort.Float64s(s)
+	return s[int(0.025*float64(len(s)-1)+0.5)], s[int(0.975*float64(len(s)-1)+0.5)]
+}
+
+// chrom returns the copy-number and interval of each sample for a chromosome.
+func (b *bootstrapper) chrom(depths [][]float32, processes int) ([]cnInterval, [][]float64) {
+	cns := getCN(depths, b.ploidy)
+	out := make([]cnInterval, len(depths))
+	reps := make([][]float64, len(depths))
+	parallel(len(depths), processes, func(k int) {
+		reps[k] = bootstrapCN(depths[k], b.n, b.ploidy, rand.New(rand.NewSource(bootSeed+int64(k))))
+		lo, hi := interval(reps[k])
+		out[k] = cnInterval{CN: jsonFloat(cns[k]), Lo: jsonFloat(lo), Hi: jsonFloat(hi)}
+	})
+	return out, reps
+}
+
+// addSex keeps the estimates for a sex chromosome for the ped columns.
+func (b *bootstrapper) addSex(name string, reps [][]float64) {
+	b.mu.Lock()
+	b.sex[name] = reps
+	b.mu.Unlock()
+}
+
+// addColumns adds CN$chrom.lo and CN$chrom.hi for each sex chromosome and sex.conf, the proportion
+// of the estimates of the first sex chromosome that give the same sex as the full data.
+func (b *bootstrapper) addColumns(t *sampleTable, samples []string, keys []string, sexes map[string][]float64) {
+	rows := make([]int, len(t.rows))
+	for i := range rows {
+		rows[i] = -1
+	}
+	for i, s := range samples {
+		if r, ok := t.index[s]; ok {
+			rows[r] = i
+		}
+	}
+	for _, k := range keys {
+		reps, ok := b.sex[k]
+		if !ok {
+			continue
+		}
+		lo, hi := make([]string, len(rows)), make([]string, len(rows))
+		for r, i := range rows {
+			lo[r], hi[r] = "NA", "NA"
+			if i == -1 || len(reps[i]) == 0 {
+				continue
+			}
+			l, h := interval(reps[i])
+			lo[r], hi[r] = fmt.Sprintf("%.2f", l), fmt.Sprintf("%.2f", h)
+		}
+		t.addColumn("CN"+k+".lo", lo)
+		t.addColumn("CN"+k+".hi", hi)
+	}
+	if len(keys) == 0 {
+		return
+	}
+	reps, ok := b.sex[keys[0]]
+	if !ok {
+		return
+	}
+	conf := make([]string, len(rows))
+	for r, i := range rows {
+		conf[r] = "NA"
+		if i == -1 || len(reps[i]) == 0 {
+			continue
+		}
+		inferred, same := int(0.5+sexes[keys[0]][i]), 0
+		for _, v := range reps[i] {
+			if int(0.5+v) == inferred {
+				same++
+			}
+		}
+		conf[r] = fmt.Sprintf("%.3f", float64(same)/float64(len(reps[i])))
+	}
+	t.addColumn("sex.conf", conf)
+}
@@ -0,0 +1,110 @@
+package indexcov
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// noisyDepths returns the normalized depths of a chromosome of m tiles for a sample with copy-number
+// cn out of 2 with the given noise.
+func noisyDepths(cn float64, m int, sd float64, rng *rand.Rand) []float32 {
+	d := make([]float32, m)
+	for i := range d {
+		d[i] = float32(math.Max(0.03, cn/2+sd*rng.NormFloat64()))
+	}
+	return d
+}
+
+func TestCnTiles(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		in   []float32
+		exp  []float32
+	}{
+		{"zeros are dropped", []float32{0, 1, 0, 1.1}, []float32{1, 1.1}},
+		{"few low tiles are kept", []float32{0.01, 1, 1, 1, 1}, []float32{0.01, 1, 1, 1, 1}},
+		{"many low tiles are dropped", []float32{0.01, 0.01, 0.5, 0.01, 0.6}, []float32{0.5, 0.6}},
+	} {
+		if got := cnTiles(c.in); !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+func TestBootstrapCN(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cns := []float64{1, 2, 3, 2.5}
+	depths := make([][]float32, len(cns))
+	for k, cn := range cns {
+		depths[k] = noisyDepths(cn, 2000, 0.1, rng)
+	}
+	for _, ploidy := range []int{2, 4} {
+		b := newBootstrapper(500, ploidy)
+		got, reps := b.chrom(depths, 2)
+		for k, cn := range cns {
+			exp := cn * float64(ploidy) / 2
+			iv := got[k]
+			if len(reps[k]) != 500 {
+				t.Errorf("ploidy %d, sample %d: expected 500 estimates, got %d", ploidy, k, len(reps[k]))
+			}
+			if !(float64(iv.Lo) <= exp && exp <= float64(iv.Hi)) {
+				t.Errorf("ploidy %d, sample %d: expected the interval to contain %.2f, got %.3f-%.3f", ploidy, k, exp, iv.Lo, iv.Hi)
+			}
+			if !(iv.Lo <= iv.CN && iv.CN <= iv.Hi) || iv.Hi-iv.Lo > 0.1*jsonFloat(ploidy) {
+				t.Errorf("ploidy %d, sample %d: expected a narrow interval around %.3f, got %.3f-%.3f", ploidy, k, iv.CN, iv.Lo, iv.Hi)
+			}
+		}
+		// the intervals are the same from run to run.
+		again, _ := b.chrom(depths, 1)
+		if !reflect.DeepEqual(got, again) {
+			t.Errorf("ploidy %d: expected the same intervals, got %v and %v", ploidy, got, again)
+		}
+	}
+
+	// noisier depths give wider intervals.
+	b := newBootstrapper(500, 2)
+	narrow, _ := b.chrom([][]float32{noisyDepths(2, 2000, 0.05, rng)}, 1)
+	wide, _ := b.chrom([][]float32{noisyDepths(2, 2000, 0.3, rng)}, 1)
+	if narrow[0].Hi-narrow[0].Lo >= wide[0].Hi-wide[0].Lo {
+		t.Errorf("expected a wider interval for noisier depths, got %v and %v", narrow[0], wide[0])
+	}
+
+	if reps := bootstrapCN([]float32{0, 0}, 10, 2, rng); reps != nil {
+		t.Errorf("expected no estimates without tiles, got %v", reps)
+	}
+	if lo, hi := interval(nil); !math.IsNaN(lo) || !math.IsNaN(hi) {
+		t.Errorf("expected NaN for no estimates, got %v, %v", lo, hi)
+	}
+}
+
+func TestBootstrapSexColumns(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	// a male, a female and a sample between the two.
+	x := [][]float32{noisyDepths(1, 1000, 0.1, rng), noisyDepths(2, 1000, 0.1, rng), noisyDepths(1.5, 1000, 0.2, rng)}
+	b := newBootstrapper(200, 2)
+	ivs, reps := b.chrom(x, 1)
+	b.addSex("X", reps)
+	sexes := map[string][]float64{"X": {float64(ivs[0].CN), float64(ivs[1].CN), float64(ivs[2].CN)}}
+
+	tbl := newSampleTable([]string{"sample_id"})
+	// the table has a sample that is not in the run.
+	for _, s := range []string{"female", "male", "mid", "missing"} {
+		tbl.add(s, []string{s})
+	}
+	b.addColumns(tbl, []string{"male", "female", "mid"}, []string{"X", "Y"}, sexes)
+	if want := []string{"sample_id", "CNX.lo", "CNX.hi", "sex.conf"}; !reflect.DeepEqual(tbl.columns, want) {
+		t.Fatalf("expected columns %v, got %v", want, tbl.columns)
+	}
+	conf, err := tbl.floats("sex.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf[0] != 1 || conf[1] != 1 {
+		t.Errorf("expected full confidence for the male and female, got %v", conf)
+	}
+	if !(conf[2] < 1) || !math.IsNaN(conf[3]) {
+		t.Errorf("expected less confidence between the sexes and NA for a sample not in the run, got %v", conf)
+	}
+}
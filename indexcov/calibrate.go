@@ -0,0 +1,263 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// The index counts the compressed bytes of every alignment so secondary and supplementary alignments
+// (and, optionally, duplicates) inflate the depth, most of all in segmental duplications. --calibrate
+// reads up to calibrateReads alignments in each of the calibrateTiles deepest tiles (of at least
+// calibrateMinDepth) of each sample to measure the proportion of the bytes that are from them.
+const (
+	calibrateTiles    = 50
+	calibrateReads    = 2000
+	calibrateMinDepth = 1.5
+	// tiles with at least this proportion of inflated bytes are flagged.
+	calibrateFlagged = 0.2
+)
+
+var calibrateFlagNames = map[string]sam.Flags{
+	"secondary":     sam.Secondary,
+	"supplementary": sam.Supplementary,
+	"duplicate":     sam.Duplicate,
+}
+
+// inflationFlags returns the flags of the alignments that are counted as inflation. The default is
+// secondary and supplementary.
+func inflationFlags(names []string) (sam.Flags, error) {
+	if len(names) == 0 {
+		return sam.Secondary | sam.Supplementary, nil
+	}
+	var f sam.Flags
+	for _, n := range names {
+		v, ok := calibrateFlagNames[strings.ToLower(strings.TrimSpace(n))]
+		if !ok {
+			return 0, fmt.Errorf("indexcov: calibrate flags must be secondary, supplementary or duplicate. got %s", n)
+		}
+		f |= v
+	}
+	return f, nil
+}
+
+// calibratedTile is a sampled tile of a sample.
+type calibratedTile struct {
+	ref, tile int
+	depth     float32
+	reads     int
+	// inflation is the proportion of the bytes of the alignments in the tile that are from
+	// alignments with the inflation flags.
+	inflation float32
+}
+
+// calibratedSource is a DepthSource with the inflated bytes removed from the sampled tiles.
+type calibratedSource struct {
+	DepthSource
+	// scale maps the reference id to the tiles that are scaled.
+	scale map[int]map[int]float32
+}
+
+// NormalizedDepth implements DepthSource.
+func (c *calibratedSource) NormalizedDepth(refID int) []float32 {
+	depths := c.DepthSource.NormalizedDepth(refID)
+	tiles, ok := c.scale[refID]
+	if !ok {
+		return depths
+	}
+	depths = append([]float32{}, depths...)
+	for i, s := range tiles {
+		if i < len(depths) {
+			depths[i] *= s
+		}
+	}
+	return depths
+}
+
+// recordBytes is the (uncompressed) size of the bam encoding of rec.
+func recordBytes(rec *sam.Record) int {
+	n := 36 + len(rec.Name) + 1 + 4*len(rec.Cigar) + (rec.Seq.Length+1)/2 + len(rec.Qual)
+	for _, a := range rec.AuxFields {
+		n += len(a)
+	}
+	return n
+}
+
+// calibrateBam measures the inflation of each of the tiles from the alignments in the bam of x. The
+// .bai is read again as init drops the bins that give the chunks of a tile once the sizes are known.
+func calibrateBam(x *Index, refs []*sam.Reference, tiles []calibratedTile, flags sam.Flags) error {
+	bf, err := os.Open(x.bai)
+	if err != nil {
+		return err
+	}
+	bai, err := bam.ReadIndex(bufio.NewReader(bf))
+	bf.Close()
+	if err != nil {
+		return fmt.Errorf("indexcov: error reading index for %s: %s", x.path, err)
+	}
+	f, err := os.Open(x.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br, err := bam.NewReader(f, 1)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+	for t := range tiles {
+		ct := &tiles[t]
+		start, end := ct.tile*TileWidth, (ct.tile+1)*TileWidth
+		chunks, err := bai.Chunks(refs[ct.ref], start, end)
+		if err != nil {
+			return fmt.Errorf("indexcov: error calibrating %s: %s", x.path, err)
+		}
+		if len(chunks) == 0 {
+			continue
+		}
+		it, err := bam.NewIterator(br, chunks)
+		if err != nil {
+			return err
+		}
+		var total, inflated int
+		for ct.reads < calibrateReads && it.Next() {
+			rec := it.Record()
+			// alignments that start before the tile are counted in the tile before it.
+			if rec.Pos < start {
+				continue
+			}
+			if rec.Pos >= end {
+				break
+			}
+			ct.reads++
+			n := recordBytes(rec)
+			total += n
+			if rec.Flags&flags != 0 {
+				inflated += n
+			}
+		}
+		if err := it.Error(); err != nil {
+			it.Close()
+			return fmt.Errorf("indexcov: error calibrating %s: %s", x.path, err)
+		}
+		it.Close()
+		if total > 0 {
+			ct.inflation = float32(inflated) / float32(total)
+		}
+	}
+	return nil
+}
+
+// deepestTiles returns up to calibrateTiles of the tiles of src with a depth of at least
+// calibrateMinDepth, deepest first.
+func deepestTiles(opts *Options, refs []*sam.Reference, src DepthSource) []calibratedTile {
+	var tiles []calibratedTile
+	for i, r := range refs {
+		if excludeChrom(opts, r.Name()) {
+			continue
+		}
+		for t, d := range src.NormalizedDepth(i) {
+			if d >= calibrateMinDepth {
+				tiles = append(tiles, calibratedTile{ref: i, tile: t, depth: d})
+			}
+		}
+	}
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].depth > tiles[j].depth })
+	if len(tiles) > calibrateTiles {
+		tiles = tiles[:calibrateTiles]
+	}
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i].ref < tiles[j].ref || (tiles[i].ref == tiles[j].ref && tiles[i].tile < tiles[j].tile)
+	})
+	return tiles
+}
+
+// calibratable returns the index of src if it is a local bam with a .bai.
+func calibratable(src DepthSource) *Index {
+	x, ok := src.(*Index)
+	if !ok || x.bai == "" || isRemote(x.path) || isRemote(x.bai) || !strings.HasSuffix(x.path, ".bam") {
+		return nil
+	}
+	return x
+}
+
+// calibrate measures the inflation of the deepest tiles of each sample that is a local bam with a
+// .bai and writes them to $prefix-indexcov-calibration.tsv. With opts.Calibrate of "correct", the
+// returned sources have the inflated bytes removed from those tiles. Other samples are unchanged.
+func calibrate(opts *Options, refs []*sam.Reference, idxs, srcs []DepthSource, names []string, path string) ([]DepthSource, error) {
+	flags, err := inflationFlags(opts.CalibrateFlags)
+	if err != nil {
+		return nil, err
+	}
+	tiles := make([][]calibratedTile, len(idxs))
+	errs := make([]error, len(idxs))
+	var skipped int
+	for _, src := range idxs {
+		if calibratable(src) == nil {
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		log.Printf("indexcov: --calibrate only reads local bams with a .bai. %d of %d samples are not calibrated", skipped, len(idxs))
+	}
+	parallel(len(idxs), opts.processes(), func(k int) {
+		x := calibratable(idxs[k])
+		if x == nil {
+			return
+		}
+		tiles[k] = deepestTiles(opts, refs, idxs[k])
+		errs[k] = calibrateBam(x, refs, tiles[k], flags)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample\tchrom\tstart\tend\tdepth\treads\tinflation\tcorrected\tflagged")
+	out := make([]DepthSource, len(srcs))
+	copy(out, srcs)
+	for k, ts := range tiles {
+		scale := make(map[int]map[int]float32)
+		nflagged := 0
+		for _, t := range ts {
+			corrected := t.depth
+			if opts.Calibrate == "correct" {
+				corrected = t.depth * (1 - t.inflation)
+				if scale[t.ref] == nil {
+					scale[t.ref] = make(map[int]float32)
+				}
+				scale[t.ref][t.tile] = 1 - t.inflation
+			}
+			flagged := "no"
+			if t.inflation >= calibrateFlagged {
+				flagged = "yes"
+				nflagged++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.3f\t%d\t%.3f\t%.3f\t%s\n", names[k], refs[t.ref].Name(),
+				t.tile*TileWidth, (t.tile+1)*TileWidth, t.depth, t.reads, t.inflation, corrected, flagged)
+		}
+		if nflagged > 0 {
+			log.Printf("indexcov: %d of %d deep tiles of %s have at least %.0f%% of their bytes from inflating alignments", nflagged, len(ts), names[k], 100*calibrateFlagged)
+		}
+		if len(scale) > 0 {
+			out[k] = &calibratedSource{DepthSource: srcs[k], scale: scale}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return out, f.Close()
+}
@@ -0,0 +1,242 @@
+package indexcov
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// bgzfBlock returns data as a single bgzf block.
+func bgzfBlock(t *testing.T, data []byte) []byte {
+	var cbuf bytes.Buffer
+	fw, err := flate.NewWriter(&cbuf, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	fw.Close()
+	hdr := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff, 6, 0, 'B', 'C', 2, 0, 0, 0}
+	binary.LittleEndian.PutUint16(hdr[16:], uint16(len(hdr)+cbuf.Len()+8-1))
+	var tail [8]byte
+	binary.LittleEndian.PutUint32(tail[:], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(tail[4:], uint32(len(data)))
+	return append(append(hdr, cbuf.Bytes()...), tail[:]...)
+}
+
+// calibrationTiles is the number of tiles of the test chromosome. The last tile only ends the
+// linear index so the sizes of the tiles before it are known.
+const calibrationTiles = 11
+
+// tileReads gives the primary and the inflating (secondary or supplementary) reads of a tile.
+func tileReads(tile int) (primary, inflating int) {
+	switch tile {
+	case 3:
+		return 40, 20
+	case 6:
+		return 60, 0
+	}
+	return 20, 0
+}
+
+// writeCalibrationBam writes a bam and .bai of a single chromosome where tiles 3 and 6 have 3 times
+// the reads of the others and a third of the reads of tile 3 are secondary or supplementary.
+func writeCalibrationBam(t *testing.T, dir string) string {
+	const readLen = 50
+	le := binary.LittleEndian
+	var hdr bytes.Buffer
+	text := "@HD\tVN:1.4\tSO:coordinate\n@SQ\tSN:chr1\tLN:180224\n@RG\tID:rg\tSM:calib\n"
+	hdr.WriteString("BAM\x01")
+	binary.Write(&hdr, le, int32(len(text)))
+	hdr.WriteString(text)
+	binary.Write(&hdr, le, []int32{1, 5})
+	hdr.WriteString("chr1\x00")
+	binary.Write(&hdr, le, int32(calibrationTiles*TileWidth))
+	block0 := bgzfBlock(t, hdr.Bytes())
+
+	type chunk struct{ beg, end uint64 }
+	bins := make(map[uint32][]chunk)
+	var binOrder []uint32
+	linear := make([]uint64, calibrationTiles)
+	var recs bytes.Buffer
+	voff := func(o int) uint64 { return uint64(len(block0))<<16 | uint64(o) }
+	n := 0
+	for tile := 0; tile < calibrationTiles; tile++ {
+		primary, inflating := tileReads(tile)
+		for i := 0; i < primary+inflating; i++ {
+			pos := tile*TileWidth + i*200
+			flag := uint16(0)
+			if i >= primary {
+				flag = uint16(sam.Secondary)
+				if i%2 == 0 {
+					flag = uint16(sam.Supplementary)
+				}
+			}
+			name := fmt.Sprintf("r%05d\x00", n)
+			n++
+			var r bytes.Buffer
+			bin := uint16(((1<<15)-1)/7 + (pos >> 14))
+			binary.Write(&r, le, []int32{0, int32(pos)})
+			binary.Write(&r, le, []uint8{uint8(len(name)), 60})
+			binary.Write(&r, le, []uint16{bin, 1, flag})
+			binary.Write(&r, le, []int32{readLen, -1, -1, 0})
+			r.WriteString(name)
+			binary.Write(&r, le, uint32(readLen<<4))
+			r.Write(bytes.Repeat([]byte{0x11}, readLen/2))
+			r.Write(bytes.Repeat([]byte{30}, readLen))
+
+			beg := voff(recs.Len())
+			binary.Write(&recs, le, int32(r.Len()))
+			recs.Write(r.Bytes())
+			end := voff(recs.Len())
+			b := uint32(bin)
+			if cs := bins[b]; len(cs) > 0 && cs[len(cs)-1].end == beg {
+				cs[len(cs)-1].end = end
+			} else {
+				if len(cs) == 0 {
+					binOrder = append(binOrder, b)
+				}
+				bins[b] = append(cs, chunk{beg, end})
+			}
+			if i == 0 {
+				linear[tile] = beg
+			}
+		}
+	}
+	if recs.Len() > 0xff00 {
+		t.Fatalf("expected the alignments to fit in a bgzf block, got %d bytes", recs.Len())
+	}
+	block1 := bgzfBlock(t, recs.Bytes())
+	eof := bgzfBlock(t, nil)
+
+	path := filepath.Join(dir, "calib.bam")
+	if err := ioutil.WriteFile(path, append(append(block0, block1...), eof...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var bai bytes.Buffer
+	bai.WriteString("BAI\x01")
+	binary.Write(&bai, le, []int32{1, int32(len(binOrder) + 1)})
+	for _, b := range binOrder {
+		binary.Write(&bai, le, b)
+		binary.Write(&bai, le, int32(len(bins[b])))
+		for _, c := range bins[b] {
+			binary.Write(&bai, le, []uint64{c.beg, c.end})
+		}
+	}
+	// the pseudo-bin with the extent of the chromosome and its mapped and unmapped counts.
+	binary.Write(&bai, le, uint32(37450))
+	binary.Write(&bai, le, int32(2))
+	binary.Write(&bai, le, []uint64{voff(0), voff(recs.Len()), uint64(n), 0})
+	binary.Write(&bai, le, int32(len(linear)))
+	binary.Write(&bai, le, linear)
+	binary.Write(&bai, le, uint64(0))
+	if err := ioutil.WriteFile(path+".bai", bai.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCalibrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-calibrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeCalibrationBam(t, dir)
+
+	f, err := os.Open(path + ".bai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bai, err := bam.ReadIndex(bufio.NewReader(f))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bai.NumRefs() != 1 {
+		t.Skip("the bam package of this build does not read indexes")
+	}
+
+	idx, err := ReadIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calibratable(idx) == nil {
+		t.Fatal("expected a local bam with a .bai to be calibrated")
+	}
+	refs, err := RefsFromBam(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	depths := idx.NormalizedDepth(0)
+	if len(depths) != calibrationTiles-1 {
+		t.Fatalf("expected %d tiles, got %d", calibrationTiles-1, len(depths))
+	}
+
+	for _, mode := range []string{"flag", "correct"} {
+		opts := &Options{Calibrate: mode, Processes: 1}
+		tsv := filepath.Join(dir, mode+"-calibration.tsv")
+		srcs := []DepthSource{idx}
+		out, err := calibrate(opts, refs, srcs, srcs, []string{"calib"}, tsv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(tsv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		// the header and tiles 3 and 6, the only tiles of at least calibrateMinDepth.
+		if len(lines) != 3 {
+			t.Fatalf("%s: expected a header and 2 tiles, got %q", mode, lines)
+		}
+		for i, exp := range []struct {
+			start, reads      int
+			inflation         float64
+			corrected, scaled float32
+			flagged           string
+		}{
+			{3 * TileWidth, 60, 1. / 3, depths[3], depths[3] * 2 / 3, "yes"},
+			{6 * TileWidth, 60, 0, depths[6], depths[6], "no"},
+		} {
+			toks := strings.Split(lines[i+1], "\t")
+			if toks[0] != "calib" || toks[1] != "chr1" || toks[2] != fmt.Sprint(exp.start) || toks[5] != fmt.Sprint(exp.reads) || toks[8] != exp.flagged {
+				t.Errorf("%s: unexpected row %q", mode, lines[i+1])
+			}
+			var inflation float64
+			fmt.Sscan(toks[6], &inflation)
+			if math.Abs(inflation-exp.inflation) > 0.001 {
+				t.Errorf("%s: expected an inflation of %.3f, got %s", mode, exp.inflation, toks[6])
+			}
+			if mode == "correct" {
+				exp.corrected = exp.scaled
+			}
+			if toks[7] != fmt.Sprintf("%.3f", exp.corrected) {
+				t.Errorf("%s: expected a corrected depth of %.3f, got %s", mode, exp.corrected, toks[7])
+			}
+		}
+
+		got := out[0].NormalizedDepth(0)
+		for k, d := range depths {
+			exp := d
+			if mode == "correct" && k == 3 {
+				exp = d * 2 / 3
+			}
+			if math.Abs(float64(got[k]-exp)) > 1e-5 {
+				t.Errorf("%s: tile %d: expected a depth of %.3f, got %.3f", mode, k, exp, got[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft"
+	"github.com/brentp/goleft/emdepth"
+)
+
+// samples with a log2 fold-change (relative to the emdepth copy-number 2 center) inside
+// of these are called as the expected ploidy. These match the cutoffs in emdepth.
+const (
+	delFC = -0.5
+	dupFC = 0.3
+)
+
+// bins where the median normalized depth across samples is below this are gaps
+// (e.g. centromeres) and end any segment.
+const gapDepth = 0.05
+
+// copy-numbers in each sample are smoothed with a median over this many bins.
+const callSmooth = 5
+
+// non-neutral segments with fewer bins than this are not reported.
+const minCallBins = 3
+
+// segment is a run of adjacent bins with the same copy-number in a single sample.
+type segment struct {
+	chrom      string
+	start, end int
+	sample     int
+	cn         int
+	// mean normalized depth of the bins in the segment.
+	depth float64
+	bins  int
+}
+
+// svtype is DEL for a segment below ploidy and DUP otherwise.
+func (s segment) svtype(ploidy int) string {
+	if s.cn < ploidy {
+		return "DEL"
+	}
+	return "DUP"
+}
+
+// callCNs assigns a copy-number to each bin of each sample. emdepth finds the depth
+// of the expected ploidy across samples and samples that differ enough from that are
+// given a copy-number proportional to their depth. Gaps are given a copy-number of -1.
+// width is the length of each bin and copy-numbers are capped at maxCN.
+func callCNs(depths [][]float32, longest int, width int, ploidy int, maxCN float32) [][]int {
+	cns := make([][]int, len(depths))
+	for k := range cns {
+		cns[k] = make([]int, longest)
+	}
+	col := make([]float32, len(depths))
+	gaps := findGaps(depths, longest)
+	for i := 0; i < longest; i++ {
+		for k, d := range depths {
+			col[k] = 0
+			if i < len(d) {
+				col[k] = d[i]
+			}
+		}
+		if gaps[i] {
+			for k := range cns {
+				cns[k][i] = -1
+			}
+			continue
+		}
+		e := emdepth.EMDepth(col, emdepth.Position{Start: uint32(i * width), End: uint32((i + 1) * width)})
+		for k, fc := range e.Log2FC() {
+			if fc > delFC && fc < dupFC {
+				cns[k][i] = ploidy
+				continue
+			}
+			cn := int(float64(ploidy)*float64(col[k])/e.Lambda[2] + 0.5)
+			if cn > int(maxCN) {
+				cn = int(maxCN)
+			}
+			cns[k][i] = cn
+		}
+	}
+	return cns
+}
+
+// findGaps returns true for each bin where the median depth across samples is below gapDepth.
+func findGaps(depths [][]float32, longest int) []bool {
+	gaps := make([]bool, longest)
+	col := make([]float32, len(depths))
+	for i := range gaps {
+		for k, d := range depths {
+			col[k] = 0
+			if i < len(d) {
+				col[k] = d[i]
+			}
+		}
+		sort.Slice(col, func(a, b int) bool { return col[a] < col[b] })
+		gaps[i] = col[len(col)/2] < gapDepth
+	}
+	return gaps
+}
+
+// smoothCNs replaces each copy-number with the median of the surrounding window, ignoring gaps.
+func smoothCNs(cns []int, w int) []int {
+	out := make([]int, len(cns))
+	win := make([]int, 0, w)
+	for i, cn := range cns {
+		if cn == -1 {
+			out[i] = -1
+			continue
+		}
+		win = win[:0]
+		for j := i - w/2; j <= i+w/2; j++ {
+			if j >= 0 && j < len(cns) && cns[j] != -1 {
+				win = append(win, cns[j])
+			}
+		}
+		sort.Ints(win)
+		out[i] = win[len(win)/2]
+	}
+	return out
+}
+
+// segmentCNs merges adjacent bins with the same copy-number. All segments, including
+// those at the expected ploidy, are returned. Gaps are not included. width is the length of each bin.
+func segmentCNs(chrom string, sample int, cns []int, depths []float32, width int) []segment {
+	var segs []segment
+	for i := 0; i < len(cns); {
+		j := i
+		for j < len(cns) && cns[j] == cns[i] {
+			j++
+		}
+		if cns[i] != -1 {
+			var sum float64
+			for k := i; k < j && k < len(depths); k++ {
+				sum += float64(depths[k])
+			}
+			segs = append(segs, segment{chrom: chrom, start: i * width, end: j * width, sample: sample,
+				cn: cns[i], depth: sum / float64(j-i), bins: j - i})
+		}
+		i = j
+	}
+	return segs
+}
+
+// callSegments returns the non-neutral segments for all samples on a chromosome sorted by start.
+func callSegments(chrom string, depths [][]float32, longest int, width int, ploidy int, maxCN float32) []segment {
+	var calls []segment
+	for k, cns := range callCNs(depths, longest, width, ploidy, maxCN) {
+		for _, s := range segmentCNs(chrom, k, smoothCNs(cns, callSmooth), depths[k], width) {
+			if s.cn != ploidy && s.bins >= minCallBins {
+				calls = append(calls, s)
+			}
+		}
+	}
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].start < calls[j].start })
+	return calls
+}
+
+// callWriter writes CNV calls as BED and VCF as each chromosome is processed.
+type callWriter struct {
+	samples []string
+	bands   *cytobands
+	// width is the length of each bin.
+	width int
+	// ploidy and maxCN are as in Options.
+	ploidy   int
+	maxCN    float32
+	bgzs     []*bgzf.Writer
+	bed, vcf *bufio.Writer
+	nCalls   int
+	// events, if not nil, gets each call for the events.tsv.
+	events *eventTable
+}
+
+func newCallWriter(base string, refs []*sam.Reference, samples []string, bands *cytobands, width int, opts *Options) (*callWriter, error) {
+	c := &callWriter{samples: samples, bands: bands, width: width, ploidy: opts.ploidy(), maxCN: opts.maxCN()}
+	for _, p := range []string{base + "-calls.bed.gz", base + "-calls.vcf.gz"} {
+		w, err := getWriter(p, opts.WriteThreads)
+		if err != nil {
+			return nil, err
+		}
+		c.bgzs = append(c.bgzs, w)
+	}
+	c.bed, c.vcf = bufio.NewWriter(c.bgzs[0]), bufio.NewWriter(c.bgzs[1])
+	fmt.Fprintln(c.bed, "#chrom\tstart\tend\tsample\tsvtype\tcn\tdepth\tbins\tband")
+	return c, writeVCFHeader(c.vcf, refs, samples)
+}
+
+func writeVCFHeader(w io.Writer, refs []*sam.Reference, samples []string) error {
+	hdr := []string{
+		"##fileformat=VCFv4.2",
+		"##source=goleft-indexcov-" + goleft.Version,
+		`##ALT=<ID=DEL,Description="Deletion">`,
+		`##ALT=<ID=DUP,Description="Duplication">`,
+		`##INFO=<ID=SVTYPE,Number=1,Type=String,Description="Type of structural variant">`,
+		`##INFO=<ID=END,Number=1,Type=Integer,Description="End position of the variant">`,
+		`##INFO=<ID=SVLEN,Number=1,Type=Integer,Description="Difference in length between REF and ALT alleles">`,
+		`##INFO=<ID=DEPTH,Number=1,Type=Float,Description="Mean normalized depth of the carrier across the event">`,
+		`##INFO=<ID=CYTOBAND,Number=1,Type=String,Description="Cytobands spanned by the event">`,
+		`##FORMAT=<ID=CN,Number=1,Type=Integer,Description="Copy number">`,
+	}
+	for _, r := range refs {
+		hdr = append(hdr, fmt.Sprintf("##contig=<ID=%s,length=%d>", r.Name(), r.Len()))
+	}
+	hdr = append(hdr, "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t"+strings.Join(samples, "\t"))
+	_, err := fmt.Fprintln(w, strings.Join(hdr, "\n"))
+	return err
+}
+
+// add writes the calls for a single chromosome.
+func (c *callWriter) add(ref *sam.Reference, depths [][]float32, longest int) error {
+	cols := make([]string, len(c.samples))
+	for _, s := range callSegments(ref.Name(), depths, longest, c.width, c.ploidy, c.maxCN) {
+		svtype := s.svtype(c.ploidy)
+		if s.end > ref.Len() {
+			s.end = ref.Len()
+		}
+		bands := c.bands.span(s.chrom, s.start, s.end)
+		if _, err := fmt.Fprintf(c.bed, "%s\t%d\t%d\t%s\t%s\t%d\t%.3f\t%d\t%s\n", s.chrom, s.start, s.end,
+			c.samples[s.sample], svtype, s.cn, s.depth, s.bins, bands); err != nil {
+			return err
+		}
+		if c.events != nil {
+			c.events.addCall(s, svtype, c.samples[s.sample], bands)
+		}
+		for i := range cols {
+			cols[i] = "."
+		}
+		cols[s.sample] = fmt.Sprintf("%d", s.cn)
+		svlen := s.end - s.start
+		if svtype == "DEL" {
+			svlen = -svlen
+		}
+		c.nCalls++
+		// POS is 1-based and, as is usual for symbolic alleles, END is the last base of the event.
+		if _, err := fmt.Fprintf(c.vcf, "%s\t%d\tindexcov_%d\tN\t<%s>\t.\tPASS\tSVTYPE=%s;END=%d;SVLEN=%d;DEPTH=%.3f;CYTOBAND=%s\tCN\t%s\n",
+			s.chrom, s.start+1, c.nCalls, svtype, svtype, s.end, svlen, s.depth, bands, strings.Join(cols, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *callWriter) Close() error {
+	for _, b := range []*bufio.Writer{c.bed, c.vcf} {
+		if err := b.Flush(); err != nil {
+			return err
+		}
+	}
+	for _, w := range c.bgzs {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package indexcov
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSegmentCNs(t *testing.T) {
+	cns := []int{2, 2, -1, -1, 3, 3, 3, 2}
+	depths := []float32{1, 1, 0, 0, 1.5, 1.4, 1.6, 0.9}
+	segs := segmentCNs("chr1", 4, cns, depths, 10)
+	exp := []segment{
+		{chrom: "chr1", start: 0, end: 20, sample: 4, cn: 2, depth: 1, bins: 2},
+		{chrom: "chr1", start: 40, end: 70, sample: 4, cn: 3, depth: (1.5 + float64(float32(1.4)) + float64(float32(1.6))) / 3, bins: 3},
+		{chrom: "chr1", start: 70, end: 80, sample: 4, cn: 2, depth: float64(float32(0.9)), bins: 1},
+	}
+	if !reflect.DeepEqual(segs, exp) {
+		t.Errorf("expected: %v, got: %v", exp, segs)
+	}
+
+	// gaps are not segments and depths shorter than the copy-numbers are allowed.
+	if segs := segmentCNs("chr1", 0, []int{-1, -1}, nil, 10); len(segs) != 0 {
+		t.Errorf("expected no segments for gaps, got: %v", segs)
+	}
+	segs = segmentCNs("chr1", 0, []int{1, 1, 1}, []float32{0.5}, 10)
+	if len(segs) != 1 || segs[0].bins != 3 || segs[0].end != 30 {
+		t.Errorf("unexpected segments: %v", segs)
+	}
+}
+
+func TestSmoothCNs(t *testing.T) {
+	got := smoothCNs([]int{2, 2, 3, 2, 2, -1, 1, 1, 1}, 3)
+	exp := []int{2, 2, 2, 2, 2, -1, 1, 1, 1}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+}
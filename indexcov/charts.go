@@ -0,0 +1,139 @@
+package indexcov
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brentp/goleft"
+)
+
+// chartAxis says where the values of an axis of a chart come from.
+type chartAxis struct {
+	Label string `json:"label"`
+	// Column is the column of the data file. "$sample" means a column per sample.
+	Column string `json:"column,omitempty"`
+	// Expr is set for values computed from columns of the data file, e.g. bins.lo / (bins.in + bins.out).
+	Expr  string   `json:"expr,omitempty"`
+	Scale string   `json:"scale"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// chartSpec describes a chart made by indexcov so that it can be drawn from the data files by other
+// tools. Paths are relative to the output directory.
+type chartSpec struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Type is scatter, line or step (a line with a step at each bin) or image for charts that are
+	// only available as a png.
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	// Format is the layout of Data: ped (a row per sample), bed (a row per bin with a column per
+	// sample) or roc (a row per chromosome and cutoff with a column per sample).
+	Format string `json:"format,omitempty"`
+	// Filter selects rows of Data by the value of a column, e.g. chrom.
+	Filter map[string]string `json:"filter,omitempty"`
+	X      *chartAxis        `json:"x,omitempty"`
+	Y      *chartAxis        `json:"y,omitempty"`
+	// Color is the column whose values give the color of each point.
+	Color string `json:"color,omitempty"`
+	// Series is sample when each sample is drawn as its own line.
+	Series string                 `json:"series,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+	HTML   string                 `json:"html,omitempty"`
+	PNG    string                 `json:"png,omitempty"`
+}
+
+// chartManifest is written to $prefix-indexcov-charts.json.
+type chartManifest struct {
+	Version string      `json:"version"`
+	Name    string      `json:"name"`
+	Samples []string    `json:"samples"`
+	Charts  []chartSpec `json:"charts"`
+}
+
+func fptr(v float64) *float64 { return &v }
+
+// chartSpecs returns the specs of the charts written by writeIndex and by run for each of the chroms.
+func chartSpecs(opts *Options, chroms []string, samples []string, vars []float64, hasSex bool, hasMapped bool, pairsPNG string) ([]chartSpec, error) {
+	name := opts.name() + "-indexcov"
+	ped, bed, roc := name+".ped", name+".bed.gz", name+".roc"
+	var specs []chartSpec
+	if hasSex {
+		keys := opts.Sex[:2]
+		specs = append(specs, chartSpec{ID: "sex", Title: "inferred sex", Type: "scatter", Data: ped, Format: "ped",
+			X:     &chartAxis{Label: keys[0] + " Copy Number", Column: "CN" + keys[0], Scale: "linear", Min: fptr(0)},
+			Y:     &chartAxis{Label: keys[1] + " Copy Number", Column: "CN" + keys[1], Scale: "linear", Min: fptr(0)},
+			Color: "sex", HTML: "index.html", PNG: name + "-sex.png"})
+	}
+	specs = append(specs, chartSpec{ID: "bins", Title: "problematic bins", Type: "scatter", Data: ped, Format: "ped",
+		X:      &chartAxis{Label: "proportion of bins with depth < 0.15", Expr: "bins.lo / (bins.in + bins.out)", Scale: "linear"},
+		Y:      &chartAxis{Label: "proportion of bins with depth outside of (0.85, 1.15)", Expr: "bins.out / (bins.in + bins.out)", Scale: "linear"},
+		Params: map[string]interface{}{"low": 0.15, "in": []float64{0.85, 1.15}}, HTML: "index.html"})
+	if len(vars) > 2 {
+		for _, pc := range []int{2, 3} {
+			specs = append(specs, chartSpec{ID: fmt.Sprintf("pca-1-%d", pc), Title: fmt.Sprintf("PC1 vs PC%d", pc), Type: "scatter", Data: ped, Format: "ped",
+				X:      &chartAxis{Label: fmt.Sprintf("PC1 (variance explained: %.2f%%)", 100*vars[0]), Column: "PC1", Scale: "linear"},
+				Y:      &chartAxis{Label: fmt.Sprintf("PC%d (variance explained: %.2f%%)", pc, 100*vars[pc-1]), Column: fmt.Sprintf("PC%d", pc), Scale: "linear"},
+				Params: map[string]interface{}{"variance_explained": vars}, HTML: "index.html"})
+		}
+	}
+	if hasMapped {
+		specs = append(specs, chartSpec{ID: "mapped", Title: "mapped and unmapped reads", Type: "scatter", Data: ped, Format: "ped",
+			X: &chartAxis{Label: "log(mapped reads)", Column: "mapped", Scale: "log"},
+			Y: &chartAxis{Label: "log(unmapped reads)", Column: "unmapped", Scale: "log"}, HTML: "index.html"})
+	}
+	if pairsPNG != "" {
+		specs = append(specs, chartSpec{ID: "pairs", Title: "correlation of samples", Type: "image", Data: name + ".pairs.tsv", PNG: pairsPNG})
+	}
+	for _, spec := range opts.Plot {
+		p, err := parsePlotSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		cs := chartSpec{ID: "plot-" + p.name(), Title: p.x + " vs " + p.y, Type: "scatter", Data: ped, Format: "ped",
+			X:     &chartAxis{Label: p.x, Column: p.x, Scale: "linear"},
+			Y:     &chartAxis{Label: p.y, Column: p.y, Scale: "linear"},
+			Color: p.color, HTML: fmt.Sprintf("%s-plot-%s.html", name, p.name())}
+		if opts.Metadata != "" {
+			cs.Params = map[string]interface{}{"metadata": opts.Metadata}
+		}
+		specs = append(specs, cs)
+	}
+	for _, chrom := range chroms {
+		depth := chartSpec{ID: "depth-" + chrom, Title: "scaled depth of " + chrom, Type: "step", Data: bed, Format: "bed",
+			Filter: map[string]string{"chrom": chrom},
+			X:      &chartAxis{Label: "position on " + chrom, Column: "start", Scale: "linear"},
+			Y:      &chartAxis{Label: "scaled coverage", Column: "$sample", Scale: "linear", Min: fptr(0), Max: fptr(2.5)},
+			Series: "sample", Params: map[string]interface{}{"window": opts.window()},
+			PNG: fmt.Sprintf("%s-depth-%s.png", name, chrom)}
+		if len(samples) <= maxSamples {
+			depth.HTML = fmt.Sprintf("%s-depth-%s.html", name, chrom)
+		}
+		specs = append(specs, depth, chartSpec{ID: "roc-" + chrom, Title: "coverage of " + chrom, Type: "line", Data: roc, Format: "roc",
+			Filter: map[string]string{"chrom": chrom},
+			X:      &chartAxis{Label: "scaled coverage for " + chrom, Column: "cov", Scale: "linear"},
+			Y:      &chartAxis{Label: "proportion of regions covered", Column: "$sample", Scale: "linear", Min: fptr(0), Max: fptr(1)},
+			Series: "sample", HTML: fmt.Sprintf("%s-roc-%s.html", name, chrom), PNG: fmt.Sprintf("%s-roc-%s.png", name, chrom)})
+	}
+	return specs, nil
+}
+
+// writeCharts writes the chart specs to path.
+func writeCharts(path string, opts *Options, specs []chartSpec, samples []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	m := chartManifest{Version: goleft.Version, Name: opts.name(), Samples: samples, Charts: specs}
+	if err := enc.Encode(m); err != nil {
+		f.Close()
+		return fmt.Errorf("indexcov: error writing %s: %s", filepath.Base(path), err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,45 @@
+package indexcov
+
+import (
+	"fmt"
+	"io"
+)
+
+// writeChromStats writes a line for each sample with the median and scaled MAD of its depths on chrom
+// and the proportion of the bins that are zero and that are outside of (0.85, 1.15). Bins excluded
+// by the mask are left out and, as for the bins.* columns in the ped, bins missing from a sample that
+// are in the longest are counted as zero.
+func writeChromStats(w io.Writer, chrom string, depths [][]float32, names []string, longest int, mask []bool, procs int) error {
+	type stats struct {
+		med, mad, zero, out float64
+	}
+	n := nUnmasked(longest, mask)
+	if n == 0 {
+		return nil
+	}
+	sts := make([]stats, len(depths))
+	parallel(len(depths), procs, func(k int) {
+		kept := unmasked(depths[k], mask)
+		vals := make([]float64, n)
+		var zero, out int
+		for i, d := range kept {
+			vals[i] = float64(d)
+			if d == 0 {
+				zero++
+			}
+			if d < 0.85 || d > 1.15 {
+				out++
+			}
+		}
+		zero += n - len(kept)
+		out += n - len(kept)
+		med, mad := medianMAD(vals)
+		sts[k] = stats{med: med, mad: mad, zero: float64(zero) / float64(n), out: float64(out) / float64(n)}
+	})
+	for k, s := range sts {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%d\t%.3f\t%.3f\t%.4f\t%.4f\n", chrom, names[k], n, s.med, s.mad, s.zero, s.out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
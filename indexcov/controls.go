@@ -0,0 +1,195 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// the observed depth of a control without a low and high in the bed must be within this factor
+// of the expected depth, e.g. 0.71 to 1.41 times it.
+const controlTolerance = 1.41
+
+// control is a region with a known relative depth such as a spike-in or a region that does not vary
+// in copy-number in the assay.
+type control struct {
+	chrom      string
+	start, end int
+	name       string
+	// expected is the scaled depth of the control where a typical bin is 1. low and high are the
+	// range of the observed depth that is in spec.
+	expected, low, high float64
+}
+
+// controlChecker measures the depth of each sample at each control as chromosomes are processed.
+type controlChecker struct {
+	controls []control
+	// byChrom gives the controls on each chromosome by its key in aliases.
+	byChrom map[string][]int
+	aliases *chromAliases
+	// observed[j][k] is the depth of sample k at control j or NaN if its chromosome was not seen.
+	observed [][]float64
+	samples  []string
+}
+
+// readControls reads a bed of chrom, start, end, name and expected scaled depth with optional low
+// and high columns for the range that is in spec. Chromosomes are matched with al.
+func readControls(path string, samples []string, al *chromAliases) (*controlChecker, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	c := &controlChecker{byChrom: make(map[string][]int), aliases: al, samples: samples}
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && line[0] != '#' && !strings.HasPrefix(line, "track") && !strings.HasPrefix(line, "browser") {
+			toks := strings.Split(line, "\t")
+			if len(toks) != 5 && len(toks) != 7 {
+				return nil, fmt.Errorf("indexcov: expected chrom, start, end, name, expected and optional low and high at line %d of %s", i, path)
+			}
+			ct := control{chrom: toks[0], name: toks[3]}
+			var serr, eerr error
+			ct.start, serr = strconv.Atoi(toks[1])
+			ct.end, eerr = strconv.Atoi(toks[2])
+			if serr != nil || eerr != nil || ct.end <= ct.start {
+				return nil, fmt.Errorf("indexcov: bad interval at line %d of %s: %s", i, path, line)
+			}
+			vals := make([]float64, len(toks)-4)
+			for j, t := range toks[4:] {
+				if vals[j], err = strconv.ParseFloat(t, 64); err != nil || vals[j] < 0 {
+					return nil, fmt.Errorf("indexcov: bad depth at line %d of %s: %s", i, path, t)
+				}
+			}
+			ct.expected, ct.low, ct.high = vals[0], vals[0]/controlTolerance, vals[0]*controlTolerance
+			if len(vals) == 3 {
+				ct.low, ct.high = vals[1], vals[2]
+			}
+			if ct.low > ct.high {
+				return nil, fmt.Errorf("indexcov: low is greater than high at line %d of %s", i, path)
+			}
+			key := al.key(ct.chrom)
+			c.byChrom[key] = append(c.byChrom[key], len(c.controls))
+			c.controls = append(c.controls, ct)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(c.controls) == 0 {
+		return nil, fmt.Errorf("indexcov: no controls found in %s", path)
+	}
+	c.observed = make([][]float64, len(c.controls))
+	for j := range c.observed {
+		c.observed[j] = make([]float64, len(samples))
+		for k := range samples {
+			c.observed[j][k] = math.NaN()
+		}
+	}
+	return c, nil
+}
+
+// add measures the depth of each sample at the controls on chrom. depths are the scaled depths in
+// bins of width.
+func (c *controlChecker) add(chrom string, depths [][]float32, width int) {
+	for _, j := range c.byChrom[c.aliases.key(chrom)] {
+		ct := c.controls[j]
+		for k, d := range depths {
+			c.observed[j][k] = float64(MeanDepth(d, width, ct.start, ct.end))
+		}
+	}
+}
+
+// status returns PASS, LOW, HIGH or NA for sample k at control j.
+func (c *controlChecker) status(j, k int) string {
+	o, ct := c.observed[j][k], c.controls[j]
+	switch {
+	case math.IsNaN(o):
+		return "NA"
+	case o < ct.low:
+		return "LOW"
+	case o > ct.high:
+		return "HIGH"
+	}
+	return "PASS"
+}
+
+// reasons returns the qc reason codes of sample k: CONTROL_LOW and CONTROL_HIGH if any control
+// is below or above its range.
+func (c *controlChecker) reasons(k int) []string {
+	var low, high bool
+	for j := range c.controls {
+		switch c.status(j, k) {
+		case "LOW":
+			low = true
+		case "HIGH":
+			high = true
+		}
+	}
+	var r []string
+	if low {
+		r = append(r, "CONTROL_LOW")
+	}
+	if high {
+		r = append(r, "CONTROL_HIGH")
+	}
+	return r
+}
+
+// nOut returns the number of controls of sample k that are out of spec.
+func (c *controlChecker) nOut(k int) int {
+	n := 0
+	for j := range c.controls {
+		if s := c.status(j, k); s == "LOW" || s == "HIGH" {
+			n++
+		}
+	}
+	return n
+}
+
+// write writes a row for each sample at each control to path.
+func (c *controlChecker) write(path string) error {
+	for j, ct := range c.controls {
+		if len(c.samples) > 0 && math.IsNaN(c.observed[j][0]) {
+			log.Printf("indexcov: WARNING: control %s is on %s which was not found or was excluded", ct.name, ct.chrom)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample\tcontrol\tchrom\tstart\tend\texpected\tlow\thigh\tobserved\tlog2.ratio\tstatus")
+	for k, sample := range c.samples {
+		for j, ct := range c.controls {
+			obs, ratio := ".", "."
+			if o := c.observed[j][k]; !math.IsNaN(o) {
+				obs = fmt.Sprintf("%.3f", o)
+				if o > 0 && ct.expected > 0 {
+					ratio = fmt.Sprintf("%.3f", math.Log2(o/ct.expected))
+				}
+			}
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.3f\t%.3f\t%.3f\t%s\t%s\t%s\n", sample, ct.name, ct.chrom,
+				ct.start, ct.end, ct.expected, ct.low, ct.high, obs, ratio, c.status(j, k)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
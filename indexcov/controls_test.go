@@ -0,0 +1,132 @@
+package indexcov
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadControlsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-controls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, c := range []struct {
+		name, bed, msg string
+	}{
+		{"columns", "chr1\t0\t100\tA\n", "expected chrom, start, end, name, expected"},
+		{"interval", "chr1\t100\t100\tA\t1\n", "bad interval at line 1"},
+		{"depth", "#header\nchr1\t0\t100\tA\t-1\n", "bad depth at line 2"},
+		{"range", "chr1\t0\t100\tA\t1\t1.2\t0.8\n", "low is greater than high"},
+		{"empty", "track name=x\n", "no controls found"},
+	} {
+		path := filepath.Join(dir, c.name+".bed")
+		if err := ioutil.WriteFile(path, []byte(c.bed), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readControls(path, []string{"s"}, defaultAliases); err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%s: expected an error with %q, got %v", c.name, c.msg, err)
+		}
+	}
+}
+
+func TestControls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-controls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := TileWidth
+	bed := "#chrom\tstart\tend\tname\texpected\tlow\thigh\n" +
+		fmt.Sprintf("chr1\t%d\t%d\tdrop\t1\n", 3*w, 5*w) +
+		fmt.Sprintf("chr1\t%d\t%d\tgain\t1\n", 8*w, 9*w) +
+		// a spike-in at half the depth with a narrow range.
+		fmt.Sprintf("chr1\t%d\t%d\tspike\t0.5\t0.45\t0.55\n", 12*w, 13*w) +
+		fmt.Sprintf("chr9\t0\t%d\tunseen\t1\n", w)
+	path := filepath.Join(dir, "controls.bed")
+	if err := ioutil.WriteFile(path, []byte(bed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	samples := []string{"ok", "dropped", "gained"}
+	c, err := readControls(path, samples, defaultAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	depths := make([][]float32, len(samples))
+	for k := range depths {
+		depths[k] = constantDepths(1, 16)
+		for i := 11; i < 14; i++ {
+			depths[k][i] = 0.5
+		}
+	}
+	for i := 2; i < 6; i++ {
+		depths[1][i] = 0.3
+	}
+	for i := 7; i < 10; i++ {
+		depths[2][i] = 2
+	}
+	// the spike-in of the gained sample is just out of its range.
+	for i := 11; i < 14; i++ {
+		depths[2][i] = 0.6
+	}
+	// the bams name the chromosome without the prefix.
+	c.add("1", depths, w)
+
+	for _, e := range []struct {
+		sample  int
+		status  []string
+		reasons []string
+	}{
+		{0, []string{"PASS", "PASS", "PASS", "NA"}, nil},
+		{1, []string{"LOW", "PASS", "PASS", "NA"}, []string{"CONTROL_LOW"}},
+		{2, []string{"PASS", "HIGH", "HIGH", "NA"}, []string{"CONTROL_HIGH"}},
+	} {
+		for j, exp := range e.status {
+			if got := c.status(j, e.sample); got != exp {
+				t.Errorf("%s at %s: expected %s, got %s", samples[e.sample], c.controls[j].name, exp, got)
+			}
+		}
+		if got := c.reasons(e.sample); strings.Join(got, ",") != strings.Join(e.reasons, ",") {
+			t.Errorf("%s: expected reasons %v, got %v", samples[e.sample], e.reasons, got)
+		}
+		nout := 0
+		for _, s := range e.status {
+			if s == "LOW" || s == "HIGH" {
+				nout++
+			}
+		}
+		if got := c.nOut(e.sample); got != nout {
+			t.Errorf("%s: expected %d controls out of spec, got %d", samples[e.sample], nout, got)
+		}
+	}
+
+	out := filepath.Join(dir, "controls.tsv")
+	if err := c.write(out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1+len(samples)*len(c.controls) {
+		t.Fatalf("expected a row for each sample and control, got %d lines", len(lines))
+	}
+	for _, exp := range []string{
+		"dropped\tdrop\tchr1\t49152\t81920\t1.000\t0.709\t1.410\t0.300\t-1.737\tLOW",
+		"gained\tspike\tchr1\t196608\t212992\t0.500\t0.450\t0.550\t0.600\t0.263\tHIGH",
+		"ok\tunseen\tchr9\t0\t16384\t1.000\t0.709\t1.410\t.\t.\tNA",
+	} {
+		found := false
+		for _, l := range lines {
+			found = found || l == exp
+		}
+		if !found {
+			t.Errorf("expected the row %q, got %q", exp, lines)
+		}
+	}
+}
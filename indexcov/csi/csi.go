@@ -0,0 +1,208 @@
+// Package csi reads the coordinate-sorted index (CSI) format used for bams aligned to
+// references with chromosomes longer than the 512Mb limit of the bam index.
+//
+// The CSI format has no linear index. Instead, the loffset stored with each bin holds the
+// virtual file offset of the first record overlapping that bin so the leaf bins give the
+// same information as the linear index of a .bai at a resolution of 2^min_shift bases.
+package csi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TileWidth is the size of the regions that are reported by Sizes. It matches the bam index.
+const TileWidth = 16384
+
+const tileShift = 14
+
+var csiMagic = [4]byte{'C', 'S', 'I', 1}
+
+// Index holds the information from a CSI needed to estimate coverage.
+type Index struct {
+	MinShift int
+	Depth    int
+	// Aux is the auxilliary data, e.g. a tabix header.
+	Aux []byte
+
+	refs []refIndex
+}
+
+type refIndex struct {
+	// offsets of the leaf bins keyed by leaf number.
+	leaves   map[int]uint64
+	maxLeaf  int
+	mapped   uint64
+	unmapped uint64
+	hasStats bool
+}
+
+// ReadIndex reads a CSI from r. r is expected to be BGZF compressed.
+func ReadIndex(r io.Reader) (*Index, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("csi: %s", err)
+	}
+	defer gz.Close()
+	return readIndex(bufio.NewReader(gz))
+}
+
+func readIndex(r io.Reader) (*Index, error) {
+	var magic [4]byte
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return nil, fmt.Errorf("csi: error reading magic: %s", err)
+	}
+	if magic != csiMagic {
+		return nil, errors.New("csi: magic number mismatch. not a CSI file")
+	}
+	var hdr struct {
+		MinShift int32
+		Depth    int32
+		NAux     int32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("csi: error reading header: %s", err)
+	}
+	if hdr.MinShift < 0 || hdr.Depth < 0 || hdr.NAux < 0 {
+		return nil, fmt.Errorf("csi: invalid header values: min_shift: %d depth: %d l_aux: %d", hdr.MinShift, hdr.Depth, hdr.NAux)
+	}
+	idx := &Index{MinShift: int(hdr.MinShift), Depth: int(hdr.Depth), Aux: make([]byte, hdr.NAux)}
+	if _, err := io.ReadFull(r, idx.Aux); err != nil {
+		return nil, fmt.Errorf("csi: error reading auxilliary data: %s", err)
+	}
+	var nRef int32
+	if err := binary.Read(r, binary.LittleEndian, &nRef); err != nil {
+		return nil, fmt.Errorf("csi: error reading number of references: %s", err)
+	}
+
+	firstLeaf := binFirst(idx.Depth)
+	statsBin := uint32(binFirst(idx.Depth+1)) + 1
+	idx.refs = make([]refIndex, nRef)
+	for i := range idx.refs {
+		ref := refIndex{leaves: make(map[int]uint64), maxLeaf: -1}
+		var nBin int32
+		if err := binary.Read(r, binary.LittleEndian, &nBin); err != nil {
+			return nil, fmt.Errorf("csi: error reading bins for reference %d: %s", i, err)
+		}
+		for b := 0; b < int(nBin); b++ {
+			var bin struct {
+				Bin     uint32
+				Loffset uint64
+				NChunk  int32
+			}
+			if err := binary.Read(r, binary.LittleEndian, &bin); err != nil {
+				return nil, fmt.Errorf("csi: error reading bin for reference %d: %s", i, err)
+			}
+			chunks := make([]uint64, 2*bin.NChunk)
+			if err := binary.Read(r, binary.LittleEndian, chunks); err != nil {
+				return nil, fmt.Errorf("csi: error reading chunks for reference %d: %s", i, err)
+			}
+			if bin.Bin == statsBin {
+				if len(chunks) == 4 {
+					ref.mapped, ref.unmapped, ref.hasStats = chunks[2], chunks[3], true
+				}
+				continue
+			}
+			if int(bin.Bin) >= firstLeaf {
+				leaf := int(bin.Bin) - firstLeaf
+				ref.leaves[leaf] = bin.Loffset
+				if leaf > ref.maxLeaf {
+					ref.maxLeaf = leaf
+				}
+			}
+		}
+		idx.refs[i] = ref
+	}
+	return idx, nil
+}
+
+// binFirst returns the number of the first bin at the given level.
+func binFirst(level int) int {
+	return ((1 << uint(3*level)) - 1) / 7
+}
+
+// vOffset converts the bgzf virtual offset as stored in the index (coffset<<16|uoffset)
+// to a value that increases with the amount of data.
+func vOffset(o uint64) int64 {
+	return int64(o)
+}
+
+// NumRefs returns the number of references in the index.
+func (i *Index) NumRefs() int {
+	return len(i.refs)
+}
+
+// ReferenceStats returns the number of mapped and unmapped reads for the given reference.
+func (i *Index) ReferenceStats(id int) (mapped, unmapped uint64, ok bool) {
+	if id < 0 || id >= len(i.refs) {
+		return 0, 0, false
+	}
+	r := i.refs[id]
+	return r.mapped, r.unmapped, r.hasStats
+}
+
+// Sizes returns the change in file offset for each 16KB region of each reference.
+// When min_shift is not 14, the values are summed or split evenly to get 16KB regions.
+func (i *Index) Sizes() [][]int64 {
+	sizes := make([][]int64, len(i.refs))
+	for k, r := range i.refs {
+		sizes[k] = rescale(r.sizes(), i.MinShift)
+	}
+	return sizes
+}
+
+// sizes returns the change in offset for each leaf bin. Leaves without data are
+// given the previous offset as is done for the linear index in the bam index.
+func (r refIndex) sizes() []int64 {
+	if r.maxLeaf < 1 {
+		return make([]int64, 0)
+	}
+	offsets := make([]int64, r.maxLeaf+1)
+	var last int64
+	for k := 0; k <= r.maxLeaf; k++ {
+		if o, ok := r.leaves[k]; ok {
+			last = vOffset(o)
+		}
+		offsets[k] = last
+	}
+	sizes := make([]int64, r.maxLeaf)
+	for k := range sizes {
+		sizes[k] = offsets[k+1] - offsets[k]
+		if sizes[k] < 0 {
+			sizes[k] = 0
+		}
+	}
+	return sizes
+}
+
+// rescale converts sizes at 2^minShift resolution to 16KB tiles.
+func rescale(sizes []int64, minShift int) []int64 {
+	switch {
+	case minShift == tileShift:
+		return sizes
+	case minShift < tileShift:
+		n := 1 << uint(tileShift-minShift)
+		out := make([]int64, 0, len(sizes)/n+1)
+		for k := 0; k < len(sizes); k += n {
+			var s int64
+			for j := k; j < k+n && j < len(sizes); j++ {
+				s += sizes[j]
+			}
+			out = append(out, s)
+		}
+		return out
+	default:
+		n := 1 << uint(minShift-tileShift)
+		out := make([]int64, 0, len(sizes)*n)
+		for _, s := range sizes {
+			for j := 0; j < n; j++ {
+				out = append(out, s/int64(n))
+			}
+		}
+		return out
+	}
+}
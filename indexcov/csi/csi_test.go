@@ -0,0 +1,115 @@
+package csi_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/brentp/goleft/indexcov/csi"
+)
+
+type bin struct {
+	bin     uint32
+	loffset uint64
+	chunks  []uint64
+}
+
+func makeCSI(t *testing.T, minShift, depth int32, bins []bin) *bytes.Buffer {
+	var raw bytes.Buffer
+	w := func(v interface{}) {
+		if err := binary.Write(&raw, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w([4]byte{'C', 'S', 'I', 1})
+	w(minShift)
+	w(depth)
+	w(int32(0))
+	// 1 reference
+	w(int32(1))
+	w(int32(len(bins)))
+	for _, b := range bins {
+		w(b.bin)
+		w(b.loffset)
+		w(int32(len(b.chunks) / 2))
+		w(b.chunks)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(raw.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// with depth 5, the first leaf is bin 4681 and the pseudo-bin is 37450.
+func TestSizes(t *testing.T) {
+	bins := []bin{
+		{4681, 100 << 16, []uint64{100 << 16, 200 << 16}},
+		{4682, 200 << 16, []uint64{200 << 16, 300 << 16}},
+		{4684, 500 << 16, []uint64{500 << 16, 600 << 16}},
+		// non-leaf bin is ignored.
+		{0, 50 << 16, []uint64{50 << 16, 60 << 16}},
+		{37450, 0, []uint64{100 << 16, 600 << 16, 33, 4}},
+	}
+	idx, err := csi.ReadIndex(makeCSI(t, 14, 5, bins))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.NumRefs() != 1 {
+		t.Fatalf("expected 1 reference, got %d", idx.NumRefs())
+	}
+	// leaf 2 has no data so it gets the offset of leaf 1.
+	exp := []int64{100 << 16, 0, 300 << 16}
+	if got := idx.Sizes()[0]; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected sizes: %v, got: %v", exp, got)
+	}
+	m, u, ok := idx.ReferenceStats(0)
+	if !ok || m != 33 || u != 4 {
+		t.Errorf("expected 33 mapped and 4 unmapped, got %d, %d (%v)", m, u, ok)
+	}
+}
+
+func TestRescale(t *testing.T) {
+	bins := []bin{
+		{4681, 100, nil},
+		{4682, 200, nil},
+		{4683, 400, nil},
+		{4684, 700, nil},
+		{4685, 800, nil},
+	}
+	idx, err := csi.ReadIndex(makeCSI(t, 13, 5, bins))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 8KB leaves are summed in pairs.
+	exp := []int64{300, 400}
+	if got := idx.Sizes()[0]; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected sizes: %v, got: %v", exp, got)
+	}
+
+	idx, err = csi.ReadIndex(makeCSI(t, 15, 5, bins[:3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 32KB leaves are split in 2.
+	exp = []int64{50, 50, 100, 100}
+	if got := idx.Sizes()[0]; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected sizes: %v, got: %v", exp, got)
+	}
+}
+
+func TestMagic(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("BAI\x01xxxxxxxxxxxx"))
+	gz.Close()
+	if _, err := csi.ReadIndex(&buf); err == nil {
+		t.Error("expected error for bad magic")
+	}
+}
@@ -0,0 +1,162 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft/genomes"
+	"github.com/brentp/goleft/plots"
+	"github.com/brentp/xopen"
+)
+
+// band is a cytoband (0-based, half-open) such as q11.23.
+type band struct {
+	start, end int
+	name       string
+}
+
+// cytobands holds the sorted bands of each chromosome keyed by the key of its name in aliases.
+type cytobands struct {
+	bands   map[string][]band
+	aliases *chromAliases
+}
+
+// loadCytobands returns the bands used to label positions. If path is "none", nothing is
+// labeled. If it is a UCSC cytoBand file, its bands are used. Otherwise the bands (usually just the
+// arms) of the genome g are used or, if g is nil, those of a registered genome (GRCh37 or GRCh38)
+// for chromosomes whose length matches that build. Chromosomes are matched with al.
+func loadCytobands(path string, refs []*sam.Reference, g *genomes.Genome, al *chromAliases) (*cytobands, error) {
+	cb := &cytobands{aliases: al}
+	if path == "none" {
+		return cb, nil
+	}
+	if path != "" {
+		return readCytobands(path, al)
+	}
+	builds := make(map[*genomes.Genome]bool)
+	for _, ref := range refs {
+		build, c, ok := genomeChrom(g, ref, al)
+		if !ok {
+			continue
+		}
+		gbands := build.Bands(c.Name)
+		if len(gbands) == 0 {
+			continue
+		}
+		if cb.bands == nil {
+			cb.bands = make(map[string][]band)
+		}
+		bands := make([]band, len(gbands))
+		for i, b := range gbands {
+			bands[i] = band{b.Start, b.End, b.Name}
+		}
+		cb.bands[al.key(ref.Name())] = bands
+		builds[build] = true
+	}
+	for b := range builds {
+		if len(b.Cytobands) == 0 {
+			log.Printf("indexcov: labeling %s chromosome arms. use --cytobands for full bands", b.Name)
+		} else {
+			log.Printf("indexcov: labeling %s cytobands", b.Name)
+		}
+	}
+	return cb, nil
+}
+
+// readCytobands reads a UCSC cytoBand file with chrom, start, end, name and stain columns.
+func readCytobands(path string, al *chromAliases) (*cytobands, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	cb := &cytobands{bands: make(map[string][]band), aliases: al}
+	br := bufio.NewReader(rdr)
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' {
+			toks := strings.Split(line, "\t")
+			if len(toks) < 4 {
+				return nil, fmt.Errorf("indexcov: expected at least 4 columns at line %d of %s", i, path)
+			}
+			start, serr := strconv.Atoi(toks[1])
+			end, eerr := strconv.Atoi(toks[2])
+			if serr != nil || eerr != nil {
+				return nil, fmt.Errorf("indexcov: bad position at line %d of %s", i, path)
+			}
+			// some contigs in the UCSC files have a single band with no name.
+			if toks[3] != "" {
+				k := al.key(toks[0])
+				cb.bands[k] = append(cb.bands[k], band{start, end, toks[3]})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	for _, bands := range cb.bands {
+		sort.Slice(bands, func(i, j int) bool { return bands[i].start < bands[j].start })
+	}
+	return cb, nil
+}
+
+// chromLabel is the chromosome name as it is written in a band, e.g. 7 for chr7.
+func (c *cytobands) chromLabel(chrom string) string {
+	return strings.TrimPrefix(c.aliases.key(chrom), "chr")
+}
+
+// findBand returns the index of the band containing pos or -1.
+func findBand(bands []band, pos int) int {
+	i := sort.Search(len(bands), func(i int) bool { return bands[i].end > pos })
+	if i == len(bands) || bands[i].start > pos {
+		return -1
+	}
+	return i
+}
+
+// chrom returns the bands of a chromosome.
+func (c *cytobands) chrom(chrom string) []band {
+	return c.bands[c.aliases.key(chrom)]
+}
+
+// label returns the band of a position, e.g. 7q11.23, or "" if it is not known.
+func (c *cytobands) label(chrom string, pos int) string {
+	bands := c.chrom(chrom)
+	if i := findBand(bands, pos); i >= 0 {
+		return c.chromLabel(chrom) + bands[i].name
+	}
+	return ""
+}
+
+// annotations returns the bands of a chromosome to label the depth plots.
+func (c *cytobands) annotations(chrom string) plots.Annotations {
+	bands := c.chrom(chrom)
+	ann := plots.Annotations{Prefix: c.chromLabel(chrom), Bands: make([]plots.Band, len(bands))}
+	for i, b := range bands {
+		ann.Bands[i] = plots.Band{Start: b.start, End: b.end, Name: b.name}
+	}
+	return ann
+}
+
+// span returns the bands of an interval, e.g. 7q11.22-q11.23, or "." if they are not known.
+func (c *cytobands) span(chrom string, start, end int) string {
+	bands := c.chrom(chrom)
+	s := findBand(bands, start)
+	e := findBand(bands, imax(start, end-1))
+	if s < 0 || e < 0 {
+		return "."
+	}
+	if s == e {
+		return c.chromLabel(chrom) + bands[s].name
+	}
+	return c.chromLabel(chrom) + bands[s].name + "-" + bands[e].name
+}
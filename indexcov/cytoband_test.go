@@ -0,0 +1,92 @@
+package indexcov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft/genomes"
+)
+
+func TestReadCytobands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-cytoband")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the columns of a UCSC cytoBand table, out of order and with an unnamed band as on some contigs.
+	path := filepath.Join(dir, "cytoBand.txt")
+	table := "#chrom\tchromStart\tchromEnd\tname\tgieStain\n" +
+		"chr7\t2000\t3000\tq11.23\tgneg\n" +
+		"chr7\t0\t1000\tp11.1\tacen\n" +
+		"chr7\t1000\t2000\tq11.22\tgpos50\n" +
+		"chrUn_x\t0\t500\t\tgneg\n"
+	if err := ioutil.WriteFile(path, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cb, err := readCytobands(path, defaultAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		chrom string
+		pos   int
+		exp   string
+	}{
+		{"chr7", 0, "7p11.1"},
+		{"chr7", 2500, "7q11.23"},
+		// the bams can name the chromosomes differently from the table.
+		{"7", 1999, "7q11.22"},
+		{"chr7", 3000, ""},
+		{"chrUn_x", 10, ""},
+	} {
+		if got := cb.label(c.chrom, c.pos); got != c.exp {
+			t.Errorf("%s:%d: expected %q, got %q", c.chrom, c.pos, c.exp, got)
+		}
+	}
+	if got := cb.span("chr7", 1500, 2500); got != "7q11.22-q11.23" {
+		t.Errorf("expected 7q11.22-q11.23, got %s", got)
+	}
+	if got := cb.span("chr8", 0, 10); got != "." {
+		t.Errorf("expected . for a chromosome with no bands, got %s", got)
+	}
+}
+
+// TestGenomeCytobands checks that a position in ELN, in the Williams syndrome region, is labeled
+// from the bands of the bundled genomes.
+func TestGenomeCytobands(t *testing.T) {
+	for _, c := range []struct {
+		build string
+		pos   int
+	}{
+		{"GRCh37", 73450000},
+		{"GRCh38", 74030000},
+	} {
+		g, err := genomes.Get(c.build)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chr7, _ := g.Chromosome("7")
+		ref, err := sam.NewReference("chr7", "", "", chr7.Length, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cb, err := loadCytobands("", []*sam.Reference{ref}, g, defaultAliases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// without cytobands, the arms from the centromere are used.
+		p, q := "7p22.3", "7q11.23"
+		if len(g.Cytobands) == 0 {
+			p, q = "7p", "7q"
+		}
+		if got := cb.label("chr7", c.pos); got != q {
+			t.Errorf("%s: expected %s, got %q", c.build, q, got)
+		}
+		if got := cb.label("7", 1000); got != p {
+			t.Errorf("%s: expected %s, got %q", c.build, p, got)
+		}
+	}
+}
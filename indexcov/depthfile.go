@@ -0,0 +1,245 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/xopen"
+)
+
+// DepthSource gives the scaled depth of each 16KB tile of a single sample. Index is a
+// DepthSource and per-window depth files from other tools are read into one so that
+// they are analyzed (sex, PCA, bins, plots and calls) in the same way as indexes.
+type DepthSource interface {
+	// NormalizedDepth returns the depth of each tile of the reference with the given id
+	// in the cohort references scaled so that a typical tile is 1. It must be safe to
+	// call from multiple goroutines.
+	NormalizedDepth(refID int) []float32
+}
+
+// depth file suffixes for mosdepth (chrom, start, end, ..., depth) and samtools depth
+// (chrom, pos, depth) output.
+var (
+	bedDepthSuffixes      = []string{".regions.bed.gz", ".per-base.bed.gz", ".bed.gz", ".bed"}
+	samtoolsDepthSuffixes = []string{".depth.gz", ".depth", ".depth.txt.gz", ".depth.txt"}
+)
+
+func hasSuffix(path string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if strings.HasSuffix(path, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDepthFile returns true if path is a mosdepth or samtools depth file rather than an alignment or index.
+func isDepthFile(path string) bool {
+	return hasSuffix(path, bedDepthSuffixes) || hasSuffix(path, samtoolsDepthSuffixes)
+}
+
+// depthFile holds the mean depth of each 16KB tile from a per-window or per-base depth file.
+type depthFile struct {
+	path string
+	// tiles are indexed by the id of the cohort references.
+	tiles  [][]float64
+	median float64
+	// match is the proportion of the depth that is on the cohort references.
+	match float64
+}
+
+// depthRecord parses a line from a depth file into a 0-based, half-open interval and its depth.
+// For beds, the depth is in the last column so that mosdepth output with a name column is read.
+func depthRecord(line string, bed bool) (chrom string, start, end int, depth float64, err error) {
+	toks := strings.Split(line, "\t")
+	if bed {
+		if len(toks) < 4 {
+			return "", 0, 0, 0, fmt.Errorf("expected at least 4 columns, got %d", len(toks))
+		}
+		if start, err = strconv.Atoi(toks[1]); err != nil {
+			return "", 0, 0, 0, err
+		}
+		if end, err = strconv.Atoi(toks[2]); err != nil {
+			return "", 0, 0, 0, err
+		}
+		depth, err = strconv.ParseFloat(toks[len(toks)-1], 64)
+		return toks[0], start, end, depth, err
+	}
+	if len(toks) < 3 {
+		return "", 0, 0, 0, fmt.Errorf("expected at least 3 columns, got %d", len(toks))
+	}
+	if end, err = strconv.Atoi(toks[1]); err != nil {
+		return "", 0, 0, 0, err
+	}
+	// with multiple samples, samtools depth has a column per sample. only the first is used.
+	depth, err = strconv.ParseFloat(toks[2], 64)
+	return toks[0], end - 1, end, depth, err
+}
+
+// readDepthLines calls fn for each record in a depth file.
+func readDepthLines(path string, fn func(chrom string, start, end int, depth float64)) error {
+	bed := hasSuffix(path, bedDepthSuffixes)
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	br := bufio.NewReaderSize(rdr, 1<<20)
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' && !strings.HasPrefix(line, "track") {
+			chrom, start, end, depth, perr := depthRecord(line, bed)
+			if perr != nil {
+				return fmt.Errorf("indexcov: error at line %d of %s: %s", i, path, perr)
+			}
+			fn(chrom, start, end, depth)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// ReadDepthFile reads a mosdepth bed (e.g. $prefix.regions.bed.gz from mosdepth --by 16384) or
+// samtools depth output and returns the mean depth of each 16KB tile of refs. Windows of any size
+// are split among the tiles that they overlap. Depth on chromosomes not in refs is ignored.
+func ReadDepthFile(path string, refs []*sam.Reference) (DepthSource, error) {
+	return readDepthFile(path, refs, defaultAliases)
+}
+
+// readDepthFile is ReadDepthFile with the chromosomes matched by al.
+func readDepthFile(path string, refs []*sam.Reference, al *chromAliases) (*depthFile, error) {
+	ids := make(map[string]int, len(refs))
+	for _, r := range refs {
+		ids[al.key(r.Name())] = r.ID()
+	}
+	d := &depthFile{path: path, tiles: make([][]float64, len(refs))}
+	var total, matched float64
+	err := readDepthLines(path, func(chrom string, start, end int, depth float64) {
+		total += depth * float64(end-start)
+		id, ok := ids[al.key(chrom)]
+		if !ok {
+			return
+		}
+		if d.tiles[id] == nil {
+			d.tiles[id] = make([]float64, refs[id].Len()/TileWidth+1)
+		}
+		tiles := d.tiles[id]
+		for t := start / TileWidth; t < len(tiles) && t*TileWidth < end; t++ {
+			s, e := t*TileWidth, (t+1)*TileWidth
+			if start > s {
+				s = start
+			}
+			if end < e {
+				e = end
+			}
+			tiles[t] += depth * float64(e-s)
+			matched += depth * float64(e-s)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]float64, 0, 16384)
+	for id, tiles := range d.tiles {
+		if tiles == nil {
+			tiles = make([]float64, 0)
+			d.tiles[id] = tiles
+		}
+		for t := range tiles {
+			tiles[t] /= TileWidth
+		}
+		all = append(all, tiles...)
+	}
+	if len(all) < 1 || matched == 0 {
+		return nil, fmt.Errorf("indexcov: no depth on the cohort chromosomes in %s", path)
+	}
+	d.match = matched / total
+	d.median = medianTile(all)
+	return d, nil
+}
+
+// medianTile returns the tile at the middle of the cumulative depth with tiles capped at
+// the 98th percentile. This matches the normalization of indexes in Index.init.
+func medianTile(vals []float64) float64 {
+	sort.Float64s(vals)
+	n98 := vals[int(0.98*float64(len(vals)))]
+	total := float64(0)
+	cumsum := make([]float64, len(vals))
+	for i, v := range vals {
+		if v > n98 {
+			v = n98
+		}
+		total += v
+		cumsum[i] = total
+	}
+	idx := sort.Search(len(cumsum), func(i int) bool { return cumsum[i] > total/2 })
+	if idx >= len(vals) {
+		idx = len(vals) - 1
+	}
+	return vals[idx]
+}
+
+// NormalizedDepth implements DepthSource.
+func (d *depthFile) NormalizedDepth(refID int) []float32 {
+	if refID >= len(d.tiles) {
+		return make([]float32, 0)
+	}
+	depths := make([]float32, len(d.tiles[refID]))
+	if d.median == 0 {
+		return depths
+	}
+	for i, v := range d.tiles[refID] {
+		depths[i] = float32(v / d.median)
+		if depths[i] > 50000 {
+			depths[i] = 50000
+		}
+	}
+	return depths
+}
+
+// refsFromDepth gets the references from the chromosomes in a depth file when no bam or fai
+// is given. The length of each is the end of its last record. chrom is matched with al.
+func refsFromDepth(path string, chrom string, al *chromAliases) ([]*sam.Reference, error) {
+	var order []string
+	ends := make(map[string]int)
+	err := readDepthLines(path, func(c string, start, end int, depth float64) {
+		if chrom != "" && !al.same(c, chrom) {
+			return
+		}
+		if _, ok := ends[c]; !ok {
+			order = append(order, c)
+		}
+		if end > ends[c] {
+			ends[c] = end
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("indexcov: no chromosomes found in %s", path)
+	}
+	refs := make([]*sam.Reference, 0, len(order))
+	for _, c := range order {
+		ref, err := sam.NewReference(c, "", "", ends[c], nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		return nil, err
+	}
+	return h.Refs(), nil
+}
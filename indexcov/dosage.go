@@ -0,0 +1,199 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// dosagePercentiles are the percentiles of the dosage of each autosome that are kept in a dosage
+// reference. The percentile of a sample is interpolated between these.
+var dosagePercentiles = []float64{1, 5, 25, 50, 75, 95, 99}
+
+// dosageMedian is the index of the 50th percentile in dosagePercentiles.
+const dosageMedian = 3
+
+// minDosageSamples is the fewest samples with a dosage on a chromosome that are used to write a reference.
+const minDosageSamples = 20
+
+// dosageRef holds the percentiles of the dosage of each autosome in a reference cohort keyed by
+// the key of the chromosome in aliases.
+type dosageRef struct {
+	chroms  map[string][]float64
+	n       map[string]int
+	aliases *chromAliases
+}
+
+// readDosageRef reads a reference written by writeRef: chrom, n and the dosage at each of the
+// dosagePercentiles. Chromosomes are matched with al.
+func readDosageRef(path string, al *chromAliases) (*dosageRef, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	ref := &dosageRef{chroms: make(map[string][]float64), n: make(map[string]int), aliases: al}
+	for i := 1; ; i++ {
+		line, err := rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && line[0] != '#' {
+			toks := strings.Split(line, "\t")
+			if len(toks) != 2+len(dosagePercentiles) {
+				return nil, fmt.Errorf("indexcov: expected %d columns at line %d of %s", 2+len(dosagePercentiles), i, path)
+			}
+			n, err := strconv.Atoi(toks[1])
+			if err != nil {
+				return nil, fmt.Errorf("indexcov: bad count at line %d of %s: %s", i, path, toks[1])
+			}
+			qs := make([]float64, len(dosagePercentiles))
+			for j, t := range toks[2:] {
+				if qs[j], err = strconv.ParseFloat(t, 64); err != nil || (j > 0 && qs[j] < qs[j-1]) {
+					return nil, fmt.Errorf("indexcov: expected increasing dosages at line %d of %s", i, path)
+				}
+			}
+			key := al.key(toks[0])
+			ref.chroms[key], ref.n[key] = qs, n
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(ref.chroms) == 0 {
+		return nil, fmt.Errorf("indexcov: no chromosomes found in dosage reference %s", path)
+	}
+	return ref, nil
+}
+
+// percentile returns the percentile of v among the dosages of chrom in the reference. It is
+// interpolated between the kept percentiles and clamped to the lowest and highest of them so
+// that values outside are reported as < 1 or > 99.
+func (r *dosageRef) percentile(chrom string, v float64) (float64, bool) {
+	qs, ok := r.chroms[r.aliases.key(chrom)]
+	if !ok {
+		return 0, false
+	}
+	if v <= qs[0] {
+		return dosagePercentiles[0], true
+	}
+	last := len(qs) - 1
+	if v >= qs[last] {
+		return dosagePercentiles[last], true
+	}
+	i := sort.SearchFloat64s(qs, v)
+	lo, hi := qs[i-1], qs[i]
+	if hi == lo {
+		return dosagePercentiles[i], true
+	}
+	return dosagePercentiles[i-1] + (v-lo)/(hi-lo)*(dosagePercentiles[i]-dosagePercentiles[i-1]), true
+}
+
+// dosages holds the dosage (the copy-number over the ploidy) of each sample on each autosome.
+type dosages struct {
+	chroms []string
+	vals   [][]float64
+	ploidy int
+}
+
+// add adds the copy-numbers of the samples (from GetCN) on chrom.
+func (d *dosages) add(chrom string, cns []float64) {
+	vals := make([]float64, len(cns))
+	for i, cn := range cns {
+		vals[i] = cn / float64(d.ploidy)
+	}
+	d.chroms = append(d.chroms, chrom)
+	d.vals = append(d.vals, vals)
+}
+
+// write writes the dosage of each sample on each autosome with its percentile in ref (if any).
+func (d *dosages) write(path string, names []string, ref *dosageRef) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample\tchrom\tdosage\tpercentile\tref.median")
+	for k, name := range names {
+		for c, chrom := range d.chroms {
+			v := d.vals[c][k]
+			if v < 0 {
+				// no usable tiles.
+				continue
+			}
+			pct, med := "NA", "NA"
+			if ref != nil {
+				if p, ok := ref.percentile(chrom, v); ok {
+					pct = fmt.Sprintf("%.1f", p)
+					switch p {
+					case dosagePercentiles[0]:
+						pct = fmt.Sprintf("<%.0f", p)
+					case dosagePercentiles[len(dosagePercentiles)-1]:
+						pct = fmt.Sprintf(">%.0f", p)
+					}
+					med = fmt.Sprintf("%.3f", ref.chroms[ref.aliases.key(chrom)][dosageMedian])
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.3f\t%s\t%s\n", name, chrom, v, pct, med)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeRef writes the percentiles of the dosages of this cohort as a reference for readDosageRef.
+// Chromosomes with fewer than minDosageSamples samples are left out.
+func (d *dosages) writeRef(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprint(w, "#chrom\tn")
+	for _, p := range dosagePercentiles {
+		fmt.Fprintf(w, "\tp%.0f", p)
+	}
+	fmt.Fprintln(w)
+	for c, chrom := range d.chroms {
+		vals := make([]float64, 0, len(d.vals[c]))
+		for _, v := range d.vals[c] {
+			if v >= 0 {
+				vals = append(vals, v)
+			}
+		}
+		if len(vals) < minDosageSamples {
+			continue
+		}
+		sort.Float64s(vals)
+		fmt.Fprintf(w, "%s\t%d", chrom, len(vals))
+		for _, p := range dosagePercentiles {
+			fmt.Fprintf(w, "\t%.4f", quantile(vals, p/100))
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// quantile returns the q quantile of sorted vals with linear interpolation.
+func quantile(vals []float64, q float64) float64 {
+	pos := q * float64(len(vals)-1)
+	i := int(pos)
+	if i+1 >= len(vals) {
+		return vals[len(vals)-1]
+	}
+	return vals[i] + (pos-float64(i))*(vals[i+1]-vals[i])
+}
@@ -0,0 +1,152 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// kinds of events in the order they are written for each sample.
+const (
+	eventQC = iota
+	eventSex
+	eventAneuploidy
+	eventCNV
+)
+
+var eventNames = []string{"qc", "sex", "aneuploidy", "cnv"}
+
+// event is a row of the $prefix-indexcov-events.tsv. chrom is "" for events of the whole sample
+// and score and detail are NaN and "" when they do not apply.
+type event struct {
+	sample     string
+	kind       int
+	typ        string
+	chrom      string
+	start, end int
+	score      float64
+	detail     string
+}
+
+// eventTable collects the qc failures, sex mismatches, arm-level gains and losses and copy-number
+// calls of each sample into a single long-format table for loading into a LIMS.
+type eventTable struct {
+	events []event
+}
+
+// addQC adds an event for each reason a sample failed qc. reasons may be nil when the qc column
+// is only from --apply-thresholds. pedSex is the sex of each sample in the --ped.
+func (et *eventTable) addQC(t *sampleTable, reasons [][]string, pedSex map[string]string) {
+	qc, err := t.strings("qc")
+	if err != nil {
+		return
+	}
+	sex, _ := t.strings("sex")
+	// metrics maps a reason code to its column so its value can be used as the score.
+	metrics := make(map[string]string, 2*len(t.columns))
+	for _, c := range t.columns {
+		metrics[reasonCode(c, true)] = c
+		metrics[reasonCode(c, false)] = c
+	}
+	for i, sample := range t.samples {
+		if qc[i] != "FAIL" {
+			continue
+		}
+		rs := []string{"COHORT_THRESHOLD"}
+		if reasons != nil {
+			rs = reasons[i]
+		}
+		for _, r := range rs {
+			if r == sexMismatch && sex != nil {
+				et.events = append(et.events, event{sample: sample, kind: eventSex, typ: r, score: math.NaN(),
+					detail: fmt.Sprintf("ped=%s;inferred=%s", pedSex[sample], sex[i])})
+				continue
+			}
+			e := event{sample: sample, kind: eventQC, typ: r, score: math.NaN()}
+			if c, ok := metrics[r]; ok {
+				e.detail = c
+				if vals, err := t.floats(c); err == nil {
+					e.score = vals[i]
+				}
+			}
+			et.events = append(et.events, e)
+		}
+	}
+}
+
+// addKaryotype adds the arms of each sample that are not called normal with the copy-number
+// as the score.
+func (et *eventTable) addKaryotype(ky *karyotyper, samples []string) {
+	for k, evs := range ky.events {
+		for _, e := range evs {
+			call, frac := e.call(ky.ploidy)
+			if call == "normal" {
+				continue
+			}
+			detail := fmt.Sprintf("arm=%s;se=%.3f", e.arm, e.se)
+			if !math.IsNaN(frac) {
+				detail += fmt.Sprintf(";mosaic.fraction=%.2f", frac)
+			}
+			et.events = append(et.events, event{sample: samples[k], kind: eventAneuploidy, typ: call, chrom: e.chrom,
+				start: e.start, end: e.end, score: e.cn, detail: detail})
+		}
+	}
+}
+
+// addCall adds a copy-number call of svtype with the integer copy-number as the score.
+func (et *eventTable) addCall(s segment, svtype string, sample string, bands string) {
+	detail := fmt.Sprintf("depth=%.3f;bins=%d", s.depth, s.bins)
+	if bands != "" && bands != "." {
+		detail += ";bands=" + bands
+	}
+	et.events = append(et.events, event{sample: sample, kind: eventCNV, typ: svtype, chrom: s.chrom,
+		start: s.start, end: s.end, score: float64(s.cn), detail: detail})
+}
+
+// write writes the events of each sample, in the order of samples, to path. Within a sample,
+// events are ordered by kind and then in the order they were found.
+func (et *eventTable) write(path string, samples []string) error {
+	order := make(map[string]int, len(samples))
+	for i, s := range samples {
+		order[s] = i
+	}
+	sort.SliceStable(et.events, func(i, j int) bool {
+		a, b := et.events[i], et.events[j]
+		if order[a.sample] != order[b.sample] {
+			return order[a.sample] < order[b.sample]
+		}
+		return a.kind < b.kind
+	})
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample\tevent\ttype\tchrom\tstart\tend\tscore\tdetail")
+	for _, e := range et.events {
+		chrom, start, end := ".", ".", "."
+		if e.chrom != "" {
+			chrom, start, end = e.chrom, fmt.Sprint(e.start), fmt.Sprint(e.end)
+		}
+		score := "."
+		if !math.IsNaN(e.score) {
+			score = strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.3f", e.score), "0"), ".")
+		}
+		detail := e.detail
+		if detail == "" {
+			detail = "."
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.sample, eventNames[e.kind], e.typ,
+			chrom, start, end, score, detail); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
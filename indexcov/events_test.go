@@ -0,0 +1,79 @@
+package indexcov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEventTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	samples := []string{"s1", "s2", "s3"}
+	tbl := newSampleTable([]string{"sample_id", "sex", "slope", "qc"})
+	tbl.add("s1", []string{"s1", "1", "0.91", "FAIL"})
+	tbl.add("s2", []string{"s2", "2", "0.10", "PASS"})
+	tbl.add("s3", []string{"s3", "2", "0.12", "FAIL"})
+	reasons := [][]string{{"SLOPE_HIGH", sexMismatch, "CONTROL_LOW"}, nil, {"CONTROL_HIGH"}}
+
+	ky := newKaryotyper(len(samples), TileWidth, 2)
+	ky.events[0] = []karyoEvent{{chrom: "chr1", arm: "p", start: 0, end: 1000, cn: 2.02, se: 0.01}}
+	ky.events[1] = []karyoEvent{{chrom: "chr21", arm: "q", start: 100, end: 2000, cn: 3, se: 0.01},
+		// a shift that is not well supported.
+		{chrom: "chr22", arm: "q", start: 100, end: 2000, cn: 2.3, se: 0.2},
+		{chrom: "chr8", arm: "p", start: 0, end: 500, cn: 1.7, se: 0.02}}
+
+	var et eventTable
+	// calls and arms are added before the qc so the rows must be sorted by kind.
+	et.addCall(segment{chrom: "chr3", start: 16384, end: 65536, cn: 1, depth: 0.49, bins: 3}, "DEL", "s1", "3p26.3")
+	et.addCall(segment{chrom: "chr5", start: 0, end: 32768, cn: 4, depth: 2.01, bins: 2}, "DUP", "s2", ".")
+	et.addKaryotype(ky, samples)
+	et.addQC(tbl, reasons, map[string]string{"s1": "2"})
+
+	path := filepath.Join(dir, "events.tsv")
+	if err := et.write(path, samples); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"#sample\tevent\ttype\tchrom\tstart\tend\tscore\tdetail",
+		"s1\tqc\tSLOPE_HIGH\t.\t.\t.\t0.91\tslope",
+		"s1\tqc\tCONTROL_LOW\t.\t.\t.\t.\t.",
+		"s1\tsex\tSEX_MISMATCH\t.\t.\t.\t.\tped=2;inferred=1",
+		"s1\tcnv\tDEL\tchr3\t16384\t65536\t1\tdepth=0.490;bins=3;bands=3p26.3",
+		"s2\taneuploidy\tgain\tchr21\t100\t2000\t3\tarm=q;se=0.010",
+		"s2\taneuploidy\tmosaic-loss\tchr8\t0\t500\t1.7\tarm=p;se=0.020;mosaic.fraction=0.30",
+		"s2\tcnv\tDUP\tchr5\t0\t32768\t4\tdepth=2.010;bins=2",
+		"s3\tqc\tCONTROL_HIGH\t.\t.\t.\t.\t.",
+	}
+	if got := strings.Split(strings.TrimSpace(string(b)), "\n"); strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(exp, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestEventTableThresholds(t *testing.T) {
+	tbl := newSampleTable([]string{"sample_id", "qc"})
+	tbl.add("s1", []string{"s1", "FAIL"})
+	tbl.add("s2", []string{"s2", "PASS"})
+	var et eventTable
+	// without reasons, the qc column is only from --apply-thresholds.
+	et.addQC(tbl, nil, nil)
+	if len(et.events) != 1 || et.events[0].sample != "s1" || et.events[0].typ != "COHORT_THRESHOLD" {
+		t.Errorf("expected a COHORT_THRESHOLD event for s1, got %v", et.events)
+	}
+
+	// a table without a qc column has no qc events.
+	et = eventTable{}
+	et.addQC(newSampleTable([]string{"sample_id"}), nil, nil)
+	if len(et.events) != 0 {
+		t.Errorf("expected no events, got %v", et.events)
+	}
+}
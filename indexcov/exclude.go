@@ -0,0 +1,111 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// excludedTiles holds, for each chromosome, the 16KB tiles that overlap a region in the --exclude bed.
+// Chromosomes are keyed by their key in aliases so that the bed can use any naming.
+type excludedTiles struct {
+	tiles   map[string][]bool
+	aliases *chromAliases
+}
+
+func newExcludedTiles(al *chromAliases) *excludedTiles {
+	return &excludedTiles{tiles: make(map[string][]bool), aliases: al}
+}
+
+// readExcluded reads a bed file (optionally gzipped) of regions to mask. Header, track and
+// comment lines are ignored. Chromosomes are matched with al.
+func readExcluded(path string, al *chromAliases) (*excludedTiles, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	ex := newExcludedTiles(al)
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && line[0] != '#' && !strings.HasPrefix(line, "track") && !strings.HasPrefix(line, "browser") {
+			toks := strings.SplitN(line, "\t", 4)
+			if len(toks) < 3 {
+				return nil, fmt.Errorf("indexcov: expected at least 3 columns at line %d of %s", i, path)
+			}
+			start, serr := strconv.Atoi(toks[1])
+			end, eerr := strconv.Atoi(toks[2])
+			if serr != nil || eerr != nil || end < start {
+				return nil, fmt.Errorf("indexcov: bad interval at line %d of %s: %s", i, path, line)
+			}
+			ex.add(toks[0], start, end)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return ex, nil
+}
+
+func (e *excludedTiles) add(chrom string, start, end int) {
+	if end == start {
+		return
+	}
+	last := (end - 1) / TileWidth
+	chrom = e.aliases.key(chrom)
+	tiles := e.tiles[chrom]
+	if len(tiles) <= last {
+		tiles = append(tiles, make([]bool, last+1-len(tiles))...)
+	}
+	for t := start / TileWidth; t <= last; t++ {
+		tiles[t] = true
+	}
+	e.tiles[chrom] = tiles
+}
+
+// mask returns a slice that is true for each tile of chrom that is excluded. It is nil if
+// no tiles are excluded.
+func (e *excludedTiles) mask(chrom string) []bool {
+	if e == nil {
+		return nil
+	}
+	return e.tiles[e.aliases.key(chrom)]
+}
+
+func isMasked(mask []bool, i int) bool {
+	return i < len(mask) && mask[i]
+}
+
+// unmasked returns the depths that are not excluded by the mask.
+func unmasked(depths []float32, mask []bool) []float32 {
+	if mask == nil {
+		return depths
+	}
+	kept := make([]float32, 0, len(depths))
+	for i, d := range depths {
+		if !isMasked(mask, i) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// nUnmasked returns the number of tiles in the first n that are not excluded.
+func nUnmasked(n int, mask []bool) int {
+	kept := n
+	for i := 0; i < n && i < len(mask); i++ {
+		if mask[i] {
+			kept--
+		}
+	}
+	return kept
+}
@@ -98,6 +98,22 @@ run check_cohort ./goleft_test indexcov -d /tmp/tt samples/*.bam
 assert_exit_code 0
 assert_equal $(num_colcounts /tmp/tt/tt-indexcov.ped) 1
 
+run check_calls ./goleft_test indexcov --calls -d /tmp/tt samples/*.bam
+assert_exit_code 0
+assert_equal $(zcat /tmp/tt/tt-indexcov-calls.bed.gz | head -1 | tr '\t' ,) "#chrom,start,end,sample,svtype,cn,depth,bins,band"
+assert_equal $(zcat /tmp/tt/tt-indexcov-calls.bed.gz | awk -F'\t' '{ print NF }' | sort -u) 9
+assert_equal $(zgrep -v ^# /tmp/tt/tt-indexcov-calls.bed.gz | cut -f 5 | grep -vc "^DEL$\|^DUP$") 0
+# a VCF record for each BED record with a column for each sample.
+assert_equal $(zgrep -vc ^# /tmp/tt/tt-indexcov-calls.vcf.gz) $(zgrep -vc ^# /tmp/tt/tt-indexcov-calls.bed.gz)
+assert_equal $(zgrep -m 1 ^#CHROM /tmp/tt/tt-indexcov-calls.vcf.gz | awk -F'\t' '{ print NF - 9 }') $(ls samples/*.bam | wc -l)
+
+# lookup reads the region with the index written for the bed.gz and gives the same as a full read.
+assert_equal $(test -s /tmp/tt/tt-indexcov.bed.gz.tbi && echo indexed) indexed
+./goleft_test indexcov-lookup /tmp/tt/tt-indexcov 1:20,000,000-21,000,000 > /tmp/tt/lookup-indexed.txt
+run check_lookup_index bash -c "rm /tmp/tt/tt-indexcov.bed.gz.tbi && ./goleft_test indexcov-lookup /tmp/tt/tt-indexcov 1:20,000,000-21,000,000 | diff - /tmp/tt/lookup-indexed.txt"
+assert_exit_code 0
+rm -f /tmp/tt/lookup-indexed.txt
+
 
 rm -f /tmp/tt/tt-indexcov.bed.gz
 run check_exclude ./goleft_test indexcov --excludepatt '^1$' -d /tmp/tt samples/sample_paper_0001.bam
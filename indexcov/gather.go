@@ -0,0 +1,73 @@
+package indexcov
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/brentp/goleft"
+)
+
+var gatherCli = &struct {
+	Directory string        `arg:"-d,required,help:directory for output files"`
+	PCs       int           `arg:"--pcs,help:number of principal components (at least 3) in the loadings"`
+	LockWait  time.Duration `arg:"--lock-wait,help:how long to wait for another run with the same output prefix to finish (e.g. 30m). by default exit with 30 at once"`
+	Sketches  []string      `arg:"positional,required,help:$prefix-indexcov.sketch file(s) from indexcov --sketch or earlier gathers"`
+}{PCs: 5}
+
+// GatherMain is called from the goleft dispatcher as indexcov-gather. It merges the PCA
+// sketches of runs on parts of a cohort and writes the loadings of the whole cohort.
+func GatherMain() {
+	p := arg.MustParse(gatherCli)
+	if gatherCli.PCs < 3 {
+		p.Fail("indexcov-gather: --pcs must be at least 3")
+	}
+	opts := Options{Directory: gatherCli.Directory, PCs: gatherCli.PCs, LockWait: gatherCli.LockWait}
+	if err := gather(&opts, gatherCli.Sketches); err != nil {
+		goleft.Fatal(err)
+	}
+}
+
+// gather merges the sketches and writes the merged sketch, so that more can be added later,
+// and the loadings of the merged sketch.
+func gather(opts *Options, paths []string) error {
+	if exists, err := getDirectory(opts.Directory); err != nil || !exists {
+		return fmt.Errorf("indexcov: error creating specified directory: %s, %v", opts.Directory, err)
+	}
+	base := opts.base()
+	lock, err := goleft.AcquireLock(base, opts.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	var s *pcaSketch
+	for _, p := range paths {
+		if mustAbs(p) == mustAbs(base+".sketch") {
+			return goleft.InputErr(fmt.Errorf("indexcov: %s would be overwritten. use a different --directory", p))
+		}
+		o, err := readPCASketch(p)
+		if err != nil {
+			return goleft.InputErr(err)
+		}
+		if s == nil {
+			s = o
+		} else if err := s.merge(o); err != nil {
+			return goleft.InputErr(fmt.Errorf("%s: %s", p, err))
+		}
+	}
+	fit, err := s.fit(opts.pcs())
+	if err != nil {
+		return err
+	}
+	vars := make([]string, len(fit.vars))
+	for i, v := range fit.vars {
+		vars[i] = fmt.Sprintf("PC%d: %.3f", i+1, v)
+	}
+	fmt.Fprintf(os.Stderr, "indexcov-gather: %d samples. proportion of variance: %s\n", s.N, strings.Join(vars, " "))
+	if err := s.write(base + ".sketch"); err != nil {
+		return err
+	}
+	return fit.writeLoadings(base+"-loadings.bed.gz", s.tiles(), s.Width, 1)
+}
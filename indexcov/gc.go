@@ -0,0 +1,101 @@
+package indexcov
+
+import (
+	"log"
+	"math"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft/gcnorm"
+)
+
+// gcSource is a DepthSource with depths corrected for GC-content and mappability.
+type gcSource struct {
+	DepthSource
+	model *gcnorm.Model
+	// gc and mapp are indexed by reference id.
+	gc, mapp [][]float32
+}
+
+// NormalizedDepth implements DepthSource.
+func (g *gcSource) NormalizedDepth(refID int) []float32 {
+	depths := append([]float32{}, g.DepthSource.NormalizedDepth(refID)...)
+	if refID < len(g.gc) {
+		g.model.Correct(depths, g.gc[refID], g.mapp[refID])
+	}
+	return depths
+}
+
+// isGCTrack returns true if path is a bed of GC (and mappability) rather than a fasta.
+func isGCTrack(path string) bool {
+	return strings.HasSuffix(path, ".bed") || strings.HasSuffix(path, ".bed.gz")
+}
+
+// readGCTrack returns the GC-content and mappability of each tile of each reference from
+// a fasta or a bed. mapp is nil for references without mappability. Chromosomes are matched with al.
+func readGCTrack(path string, refs []*sam.Reference, al *chromAliases) (gc, mapp [][]float32, err error) {
+	var track *gcnorm.Track
+	if isGCTrack(path) {
+		track, err = gcnorm.ReadBed(path, TileWidth)
+	} else {
+		names := make([]string, len(refs))
+		lengths := make([]int, len(refs))
+		for i, r := range refs {
+			names[i], lengths[i] = r.Name(), r.Len()
+		}
+		track, err = gcnorm.FromFasta(path, names, lengths, TileWidth)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	byKey := make(map[string]string)
+	for _, c := range track.Chroms() {
+		byKey[al.key(c)] = c
+	}
+	gc, mapp = make([][]float32, len(refs)), make([][]float32, len(refs))
+	for i, r := range refs {
+		if c, ok := byKey[al.key(r.Name())]; ok {
+			gc[i], mapp[i] = track.GC(c), track.Mappability(c)
+		}
+	}
+	return gc, mapp, nil
+}
+
+// gcCorrect fits a GC (and mappability) correction for each sample using the autosomes and
+// returns sources that apply it so that the ROC, PCA, ped and calls all use corrected depths.
+func gcCorrect(opts *Options, refs []*sam.Reference, srcs []DepthSource, gc, mapp [][]float32) []DepthSource {
+	hasMap := false
+	for _, m := range mapp {
+		hasMap = hasMap || m != nil
+	}
+	log.Printf("indexcov: correcting depths for GC content (mappability: %v)", hasMap)
+
+	out := make([]DepthSource, len(srcs))
+	parallel(len(srcs), opts.processes(), func(k int) {
+		var depths, gcs, mapps []float32
+		for i, r := range refs {
+			if gc[i] == nil || sameChrom(opts.aliasTable(), opts.Sex, r.Name()) || excludeChrom(opts, r.Name()) {
+				continue
+			}
+			d := srcs[k].NormalizedDepth(i)
+			n := imin(len(d), len(gc[i]))
+			depths = append(depths, d[:n]...)
+			gcs = append(gcs, gc[i][:n]...)
+			if hasMap {
+				mapps = append(mapps, padNaN(mapp[i], n)...)
+			}
+		}
+		out[k] = &gcSource{DepthSource: srcs[k], model: gcnorm.Fit(depths, gcs, mapps), gc: gc, mapp: mapp}
+	})
+	return out
+}
+
+// padNaN returns the first n values of v with NaN for those past its end.
+func padNaN(v []float32, n int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = float32(math.NaN())
+	}
+	copy(out, v)
+	return out
+}
@@ -0,0 +1,67 @@
+package indexcov
+
+import (
+	"log"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft/genomes"
+)
+
+// defaultSex is the default of -X. The sex chromosomes of --genome are used in its place.
+const defaultSex = "X,Y"
+
+// genomeSex returns the sex chromosomes from -X or, if -X is the default and a genome is given,
+// those of the genome.
+func genomeSex(sex, name, file string) ([]string, error) {
+	g, err := genomes.Resolve(name, file)
+	if err != nil {
+		return nil, err
+	}
+	if g != nil && len(g.Sex) > 0 && sex == defaultSex {
+		return g.Sex, nil
+	}
+	if len(sex) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSpace(sex), ","), nil
+}
+
+// genomeChrom returns the genome and its chromosome for ref. With a genome from --genome or
+// --genome-file, the chromosome is matched by name (or alias) only. Otherwise a registered genome
+// is detected from the name and the length. al gives the other name that is tried.
+func genomeChrom(g *genomes.Genome, ref *sam.Reference, al *chromAliases) (*genomes.Genome, genomes.Chromosome, bool) {
+	names := []string{ref.Name(), al.key(ref.Name())}
+	for _, n := range names {
+		build := g
+		if build == nil {
+			if build = genomes.Detect(n, ref.Len()); build == nil {
+				continue
+			}
+		}
+		if c, ok := build.Chromosome(n); ok {
+			return build, c, true
+		}
+	}
+	return nil, genomes.Chromosome{}, false
+}
+
+// checkGenome warns about chromosomes in refs with a different length in g as the regions and
+// bands of g are used for them regardless.
+func checkGenome(g *genomes.Genome, refs []*sam.Reference, al *chromAliases) {
+	if g == nil {
+		return
+	}
+	n := 0
+	for _, ref := range refs {
+		if _, c, ok := genomeChrom(g, ref, al); ok {
+			n++
+			if c.Length != ref.Len() {
+				log.Printf("indexcov: WARNING: %s has a length of %d but of %d in %s", ref.Name(), ref.Len(), c.Length, g.Name)
+			}
+		}
+	}
+	if n == 0 {
+		log.Printf("indexcov: WARNING: none of the chromosomes are in %s", g.Name)
+	}
+}
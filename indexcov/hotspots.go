@@ -0,0 +1,159 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// The linear index gives the offset of the first alignment that overlaps each 16KB tile while the
+// 16KB (leaf) bins hold only the alignments that start and end in the tile. Alignments that cross
+// tiles, such as clipped, split and discordant reads with long or odd alignments, are put in larger
+// bins so, where they pile up, the bytes of the leaf bin differ from those of the linear index.
+// Tiles where the log2 ratio of these differs from the median of the sample by at least hotspotLog2
+// are reported. Tiles with a depth below hotspotMinDepth are too noisy to use.
+const (
+	hotspotLog2     = 1
+	hotspotMinDepth = 0.25
+	// leafBin is the first bin of the 16KB level of the BAI binning scheme.
+	leafBin = 4681
+)
+
+// hotspot is a run of adjacent tiles of a sample with discordant byte counts.
+type hotspot struct {
+	ref, start, end int
+	sample          int
+	// sum is the sum of the differences of the log2 ratios of the tiles from the median.
+	sum float64
+}
+
+func (h hotspot) tiles() int { return (h.end - h.start) / TileWidth }
+
+// screenIndex returns the hotspots of the bam index of x.
+func screenIndex(opts *Options, refs []*sam.Reference, x *Index, k int) ([]hotspot, error) {
+	rdr, err := openFile(x.bai)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	idx, err := bam.ReadIndex(bufio.NewReader(rdr))
+	if err != nil {
+		return nil, fmt.Errorf("indexcov: error reading index for %s: %s", x.path, err)
+	}
+	return screenRefs(opts, refs, refIndexes(idx), x.medianSizePerTile, k), nil
+}
+
+// screenRefs returns the hotspots of sample k from the bins and linear index of each reference.
+// medianSize is the median bytes of a tile of the sample.
+func screenRefs(opts *Options, refs []*sam.Reference, ris []oRefIndex, medianSize float64, k int) []hotspot {
+	type tile struct {
+		ref, i int
+		ratio  float64
+	}
+	var tiles []tile
+	var ratios []float64
+	for i, r := range refs {
+		if i >= len(ris) || excludeChrom(opts, r.Name()) {
+			continue
+		}
+		ri := ris[i]
+		leaf := make(map[int]int64)
+		for _, b := range ri.Bins {
+			if b.Bin < leafBin || b.Bin >= StatsDummyBin {
+				continue
+			}
+			for _, c := range b.Chunks {
+				leaf[int(b.Bin)-leafBin] += vOffset(c.End) - vOffset(c.Begin)
+			}
+		}
+		for t := 0; t+1 < len(ri.Intervals); t++ {
+			lin := vOffset(ri.Intervals[t+1]) - vOffset(ri.Intervals[t])
+			if float64(lin) < hotspotMinDepth*medianSize {
+				continue
+			}
+			ratio := math.Log2(float64(leaf[t]+1) / float64(lin+1))
+			tiles = append(tiles, tile{ref: i, i: t, ratio: ratio})
+			ratios = append(ratios, ratio)
+		}
+	}
+	if len(ratios) == 0 {
+		return nil
+	}
+	sort.Float64s(ratios)
+	med := ratios[len(ratios)/2]
+
+	var spots []hotspot
+	for _, t := range tiles {
+		d := t.ratio - med
+		if math.Abs(d) < hotspotLog2 {
+			continue
+		}
+		start := t.i * TileWidth
+		// extend the last hotspot if this tile is next to it and differs in the same direction.
+		if n := len(spots); n > 0 && spots[n-1].ref == t.ref && spots[n-1].end == start && (spots[n-1].sum > 0) == (d > 0) {
+			spots[n-1].end += TileWidth
+			spots[n-1].sum += d
+			continue
+		}
+		spots = append(spots, hotspot{ref: t.ref, start: start, end: start + TileWidth, sample: k, sum: d})
+	}
+	return spots
+}
+
+// writeHotspots screens the bai of each sample and writes the hotspots of all samples, sorted by
+// position, to path. Samples without a bai are skipped.
+func writeHotspots(opts *Options, refs []*sam.Reference, idxs []DepthSource, names []string, path string) error {
+	spots := make([][]hotspot, len(idxs))
+	errs := make([]error, len(idxs))
+	var skipped int
+	for _, src := range idxs {
+		if x, ok := src.(*Index); !ok || x.bai == "" {
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		log.Printf("indexcov: --hotspots needs a .bai. %d of %d samples are not screened", skipped, len(idxs))
+	}
+	parallel(len(idxs), opts.processes(), func(k int) {
+		if x, ok := idxs[k].(*Index); ok && x.bai != "" {
+			spots[k], errs[k] = screenIndex(opts, refs, x, k)
+		}
+	})
+	var all []hotspot
+	for k, err := range errs {
+		if err != nil {
+			return err
+		}
+		all = append(all, spots[k]...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.ref != b.ref {
+			return a.ref < b.ref
+		}
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		return a.sample < b.sample
+	})
+
+	w, err := getWriter(path, opts.WriteThreads)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#chrom\tstart\tend\tsample\ttiles\tlog2.ratio\n")
+	for _, h := range all {
+		fmt.Fprintf(bw, "%s\t%d\t%d\t%s\t%d\t%.2f\n", refs[h.ref].Name(), h.start, h.end, names[h.sample], h.tiles(), h.sum/float64(h.tiles()))
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	log.Printf("indexcov: wrote %d hotspots in %d samples to %s", len(all), len(idxs)-skipped, path)
+	return w.Close()
+}
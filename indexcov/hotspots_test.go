@@ -0,0 +1,98 @@
+package indexcov
+
+import (
+	"math"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/sam"
+)
+
+// hotspotIndex makes the index of a reference with the given bytes of each tile in the linear
+// index and in its leaf bin.
+func hotspotIndex(linear, leaf []int64) oRefIndex {
+	var ri oRefIndex
+	var off int64
+	for t := range linear {
+		ri.Intervals = append(ri.Intervals, bgzf.Offset{File: off})
+		if leaf[t] > 0 {
+			c := bgzf.Chunk{Begin: bgzf.Offset{File: off}, End: bgzf.Offset{File: off + leaf[t]}}
+			ri.Bins = append(ri.Bins, bin{Bin: uint32(leafBin + t), Chunks: []bgzf.Chunk{c}})
+		}
+		off += linear[t]
+	}
+	ri.Intervals = append(ri.Intervals, bgzf.Offset{File: off})
+	// the larger bins and the stats bin are not counted.
+	ri.Bins = append(ri.Bins, bin{Bin: 0, Chunks: []bgzf.Chunk{{Begin: bgzf.Offset{}, End: bgzf.Offset{File: off}}}},
+		bin{Bin: StatsDummyBin, Chunks: []bgzf.Chunk{{Begin: bgzf.Offset{}, End: bgzf.Offset{File: off}}}})
+	return ri
+}
+
+func TestScreenRefs(t *testing.T) {
+	var refs []*sam.Reference
+	for _, name := range []string{"chr1", "chrUn_x", "chr3"} {
+		r, err := sam.NewReference(name, "", "", 20*TileWidth, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, r)
+	}
+	linear := make([]int64, 20)
+	leaf := make([]int64, 20)
+	for i := range linear {
+		linear[i], leaf[i] = 1000, 800
+	}
+	// tiles 5 and 6 are one hotspot where most alignments cross tiles.
+	leaf[5], leaf[6] = 100, 100
+	// tiles 10 and 11 differ in opposite directions so they are not merged.
+	leaf[10], leaf[11] = 3200, 100
+	// tile 15 has too few bytes to be used.
+	linear[15], leaf[15] = 100, 0
+	// the excluded reference would be all hotspots and chr3 is not in the index.
+	ris := []oRefIndex{hotspotIndex(linear, leaf), hotspotIndex(linear[:3], []int64{10, 10, 10})}
+
+	opts := &Options{Exclude: regexp.MustCompile("^chrUn")}
+	// the bytes are in virtual offsets as is the median size of a tile.
+	median := float64(1000 << 16)
+	spots := screenRefs(opts, refs, ris, median, 3)
+	exp := []struct {
+		start, end int
+		log2       float64
+	}{
+		{5 * TileWidth, 7 * TileWidth, -3},
+		{10 * TileWidth, 11 * TileWidth, 2},
+		{11 * TileWidth, 12 * TileWidth, -3},
+	}
+	if len(spots) != len(exp) {
+		t.Fatalf("expected %d hotspots, got %v", len(exp), spots)
+	}
+	for i, e := range exp {
+		h := spots[i]
+		if h.ref != 0 || h.sample != 3 || h.start != e.start || h.end != e.end {
+			t.Errorf("hotspot %d: expected chr1:%d-%d of sample 3, got %+v", i, e.start, e.end, h)
+		}
+		if got := h.sum / float64(h.tiles()); math.Abs(got-e.log2) > 0.01 {
+			t.Errorf("hotspot %d: expected a log2 ratio of %.2f, got %.2f", i, e.log2, got)
+		}
+	}
+
+	// without the exclusion, every tile of chrUn_x is a hotspot and they are merged.
+	all := screenRefs(&Options{}, refs, ris, median, 3)
+	if len(all) != len(exp)+1 || all[len(exp)].ref != 1 || all[len(exp)].tiles() != 3 {
+		t.Errorf("expected a hotspot over all of chrUn_x, got %v", all)
+	}
+	if !reflect.DeepEqual(all[:len(exp)], spots) {
+		t.Errorf("expected the same hotspots on chr1, got %v and %v", all[:len(exp)], spots)
+	}
+
+	// a sample with the same ratio in every tile has no hotspots.
+	even := hotspotIndex(linear[:5], leaf[:5])
+	if got := screenRefs(opts, refs, []oRefIndex{even}, median, 0); len(got) != 0 {
+		t.Errorf("expected no hotspots, got %v", got)
+	}
+	if got := screenRefs(opts, refs, nil, median, 0); got != nil {
+		t.Errorf("expected no hotspots without tiles, got %v", got)
+	}
+}
@@ -0,0 +1,165 @@
+package indexcov
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+
+	"github.com/biogo/hts/sam"
+)
+
+// size of the ideograms in pixels. The longest chromosome is ideoHeight pixels tall and each
+// chromosome is a bar of ideoBar pixels. Thumbnails average thumbRows rows of the full image.
+const (
+	ideoHeight = 240
+	ideoBar    = 10
+	ideoGap    = 6
+	thumbRows  = 4
+	thumbBar   = 3
+	thumbGap   = 1
+)
+
+var (
+	ideoNormal = color.RGBA{220, 220, 220, 255}
+	ideoLoss   = color.RGBA{33, 102, 172, 255}
+	ideoGain   = color.RGBA{178, 24, 43, 255}
+	ideoEdge   = color.RGBA{120, 120, 120, 255}
+)
+
+// ideogram accumulates the depth of each sample along each chromosome at the resolution of the
+// image so that a genome-wide overview can be drawn without keeping every tile.
+type ideogram struct {
+	bpPerPx int
+	chroms  []string
+	// px[c][k] is the mean depth of sample k in each pixel of chromosome c. It is NaN where there
+	// are no covered tiles.
+	px [][][]float32
+}
+
+// newIdeogram sets the scale so that the longest of the chromosomes that are used fits the image.
+func newIdeogram(opts *Options, refs []*sam.Reference) *ideogram {
+	longest := 1
+	for _, r := range refs {
+		if !excludeChrom(opts, r.Name()) && r.Len() > longest {
+			longest = r.Len()
+		}
+	}
+	return &ideogram{bpPerPx: (longest + ideoHeight - 1) / ideoHeight}
+}
+
+// add averages the unmasked tiles of each sample on a chromosome of the given length into pixels.
+// Tiles that are exactly 0 (e.g. the centromere) are left out as for the copy-number.
+func (g *ideogram) add(chrom string, length int, depths [][]float32, width int, mask []bool, processes int) {
+	n := (length + g.bpPerPx - 1) / g.bpPerPx
+	px := make([][]float32, len(depths))
+	parallel(len(depths), processes, func(k int) {
+		sums := make([]float64, n)
+		counts := make([]int, n)
+		for i, dp := range depths[k] {
+			if dp == 0 || isMasked(mask, i) {
+				continue
+			}
+			if p := i * width / g.bpPerPx; p < n {
+				sums[p] += float64(dp)
+				counts[p]++
+			}
+		}
+		px[k] = make([]float32, n)
+		for p := range sums {
+			px[k][p] = float32(math.NaN())
+			if counts[p] > 0 {
+				px[k][p] = float32(sums[p] / float64(counts[p]))
+			}
+		}
+	})
+	g.chroms = append(g.chroms, chrom)
+	g.px = append(g.px, px)
+}
+
+// ideoColor shades a depth from gray at 1 to blue at half and red at twice the expected depth.
+func ideoColor(d float64) color.RGBA {
+	v := math.Max(-1, math.Min(1, math.Log2(math.Max(d, 1e-3))))
+	to := ideoGain
+	if v < 0 {
+		to, v = ideoLoss, -v
+	}
+	mix := func(a, b uint8) uint8 { return uint8(float64(a) + v*(float64(b)-float64(a)) + 0.5) }
+	return color.RGBA{mix(ideoNormal.R, to.R), mix(ideoNormal.G, to.G), mix(ideoNormal.B, to.B), 255}
+}
+
+// draw draws the chromosomes of sample k left to right in the order they were added. Each row of
+// the image is the mean of rows pixels of the chromosome.
+func (g *ideogram) draw(k int, bar, gap, rows int) *image.RGBA {
+	h := (ideoHeight+rows-1)/rows + 2
+	img := image.NewRGBA(image.Rect(0, 0, gap+len(g.chroms)*(bar+gap), h))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for c, px := range g.px {
+		d := px[k]
+		n := (len(d) + rows - 1) / rows
+		x0 := gap + c*(bar+gap)
+		for y := 0; y < n; y++ {
+			var sum float64
+			var m int
+			for _, v := range d[y*rows : imin(len(d), (y+1)*rows)] {
+				if !math.IsNaN(float64(v)) {
+					sum += float64(v)
+					m++
+				}
+			}
+			col := color.RGBA{255, 255, 255, 255}
+			if m > 0 {
+				col = ideoColor(sum / float64(m))
+			}
+			for x := x0 + 1; x < x0+bar-1; x++ {
+				img.SetRGBA(x, y+1, col)
+			}
+			img.SetRGBA(x0, y+1, ideoEdge)
+			img.SetRGBA(x0+bar-1, y+1, ideoEdge)
+		}
+		for x := x0; x < x0+bar; x++ {
+			img.SetRGBA(x, 0, ideoEdge)
+			img.SetRGBA(x, n+1, ideoEdge)
+		}
+	}
+	return img
+}
+
+// write writes the ideogram of each sample to $base-ideogram-$n.png where n is the 1-based index of
+// the sample and returns the thumbnails as data URIs.
+func (g *ideogram) write(base string, samples []string, processes int) ([]string, error) {
+	thumbs := make([]string, len(samples))
+	errs := make([]error, len(samples))
+	parallel(len(samples), processes, func(k int) {
+		f, err := os.Create(fmt.Sprintf("%s-ideogram-%d.png", base, k+1))
+		if err != nil {
+			errs[k] = err
+			return
+		}
+		if err := png.Encode(f, g.draw(k, ideoBar, ideoGap, 1)); err != nil {
+			f.Close()
+			errs[k] = err
+			return
+		}
+		if errs[k] = f.Close(); errs[k] != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if errs[k] = png.Encode(&buf, g.draw(k, thumbBar, thumbGap, thumbRows)); errs[k] != nil {
+			return
+		}
+		thumbs[k] = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return thumbs, nil
+}
@@ -3,13 +3,13 @@ package indexcov
 import (
 	"bufio"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
@@ -18,9 +18,7 @@ import (
 	"sync"
 	"time"
 
-	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
-	"gonum.org/v1/gonum/stat"
 
 	arg "github.com/alexflint/go-arg"
 	"github.com/biogo/biogo/io/seqio/fai"
@@ -31,34 +29,102 @@ import (
 	"github.com/brentp/go-chartjs/types"
 	"github.com/brentp/goleft"
 	"github.com/brentp/goleft/indexcov/crai"
-	"github.com/brentp/xopen"
+	"github.com/brentp/goleft/indexcov/csi"
+	"github.com/brentp/goleft/indexcov/matrix"
+	"github.com/brentp/goleft/indexcov/tabix"
+	"github.com/brentp/goleft/plots"
+	"github.com/brentp/goleft/samplename"
 )
 
-// Ploidy indicates the expected ploidy of the samples.
+// Ploidy indicates the expected ploidy of the samples for GetCN. Run uses Options.Ploidy.
 var Ploidy = 2
 
 var cli = &struct {
-	Directory   string         `arg:"-d,required,help:directory for output files"`
-	IncludeGL   bool           `arg:"-e,help:plot GL chromosomes like: GL000201.1 which are not plotted by default"`
-	ExcludePatt string         `arg:-p,help:regular expression of chromosome names to exclude"`
-	Sex         string         `arg:"-X,help:comma delimited names of the sex chromosome(s) used to infer sex. Set to '' if no sex chromosomes are present."`
-	Chrom       string         `arg:"-c,help:optional chromosome to extract depth. default is entire genome."`
-	Fai         string         `arg:"-f,help:fasta index file. Required when crais are used."`
-	Bam         []string       `arg:"positional,required,help:bam(s) or crais for which to estimate coverage"`
-	sex         []string       `arg:"-"`
-	exclude     *regexp.Regexp `arg:"-"`
-}{Sex: "X,Y", ExcludePatt: `^chrEBV$|^NC|_random$|Un_|^HLA\-|_alt$|hap\d$`}
-
-// MaxCN is the maximum normalized value.
+	Directory   string   `arg:"-d,required,help:directory for output files"`
+	IncludeGL   bool     `arg:"-e,help:plot GL chromosomes like: GL000201.1 which are not plotted by default"`
+	ExcludePatt string   `arg:"-p,help:regular expression of chromosome names to exclude"`
+	Sex         string   `arg:"-X,help:comma delimited names of the sex chromosome(s) used to infer sex. Set to '' if no sex chromosomes are present."`
+	Chrom       string   `arg:"-c,help:optional chromosome to extract depth. default is entire genome."`
+	Fai         string   `arg:"-f,help:fasta index file. Required when crais are used."`
+	AliasFile   string   `arg:"--alias-file,help:tab-delimited file with the names of a chromosome on each line to match names like NC_000001.11 and 1 and chr1. GRCh37 and GRCh38 are built in"`
+	Metadata    string   `arg:"help:optional tab-delimited file with a header and sample_id in the first column. Columns can be used in --plot"`
+	Plot        []string `arg:"help:extra scatter plot(s) of ped or metadata columns given as comma-delimited x=COL and y=COL and optional color=COL"`
+
+	SuggestThresholds bool    `arg:"--suggest-thresholds,help:print outlier cutoffs (median + nmads * MAD) for bins.out and bins.lo and p.out across the cohort"`
+	ApplyThresholds   bool    `arg:"--apply-thresholds,help:use the suggested cutoffs to add a PASS/FAIL qc column to the ped file"`
+	NMADs             float64 `arg:"help:number of MADs above the median used for suggested cutoffs"`
+	ReferenceRanges   string  `arg:"--reference-ranges,help:tab-delimited file of metric and low and high values giving reference intervals for ped columns. samples outside are flagged."`
+	QCRules           string  `arg:"--qc,help:tab-delimited file of metric and low and high values. samples outside fail qc and are written with reason codes to $prefix-indexcov.qc.tsv"`
+	Controls          string  `arg:"help:bed of chrom and start and end and name and expected scaled depth (and optional low and high) of control regions. samples with a control out of range fail qc"`
+	Ped               string  `arg:"help:ped file with the reported sex of each sample. samples where the inferred sex differs fail qc. families and declared twins are checked against the correlation of their depths"`
+	QCExit            bool    `arg:"--qc-exit,help:same as --fail-on fail"`
+	FailOn            string  `arg:"--fail-on,help:exit with status 10 if any sample fails qc (fail) or fails or has a warning (warn). never always exits 0 after a run. input errors exit with 20"`
+	GC                string  `arg:"--gc,help:fasta or bed of chrom start end GC and optional mappability used to correct each sample for GC bias"`
+	Cytobands         string  `arg:"help:UCSC cytoBand file used to label plots and calls with bands. arms of GRCh37 and GRCh38 are used by default. use 'none' to turn off"`
+	Window            int     `arg:"help:length of the bins used for all output. a multiple of 16384. larger bins are less noisy for low-coverage samples"`
+	PAR               string  `arg:"--par,help:bed of pseudo-autosomal regions left out of sex inference. detected for GRCh37 and GRCh38 by default. use 'none' to keep them"`
+
+	PCs           int     `arg:"--pcs,help:number of principal components (at least 3) to compute and write to the ped file"`
+	PCAIncludeSex bool    `arg:"--pca-include-sex,help:use the sex chromosomes in the PCA"`
+	PCAExclude    string  `arg:"--pca-exclude,help:comma-delimited chromosomes to leave out of the PCA"`
+	PCAWeight     string  `arg:"--pca-weight,help:weight of each bin in the PCA: none or variance (unit variance) or mappability (from the --gc bed)"`
+	Loadings      bool    `arg:"help:write the mean and loadings of each bin used for the PCA to $prefix-indexcov-loadings.bed.gz for projecting other batches"`
+	Project       string  `arg:"help:loadings (from --loadings or indexcov-gather) of a reference panel of the same build. samples are projected onto its PC1 and PC2 as ref.PC1 and ref.PC2"`
+	Sketch        bool    `arg:"help:write a sketch of the PCA matrix to $prefix-indexcov.sketch to merge with other runs using indexcov-gather"`
+	Pairs         bool    `arg:"help:write the correlation of depths between samples to $prefix-indexcov.pairs.tsv and a clustered heatmap to flag duplicates"`
+	PairsMinR     float64 `arg:"--pairs-min-r,help:pairs of samples with a correlation of at least this are flagged as duplicates by --pairs"`
+	Calls         bool    `arg:"help:segment depths into copy-number calls written to $prefix-indexcov-calls.bed.gz and .vcf.gz"`
+	WriteThreads  int     `arg:"--write-threads,help:number of goroutines used to compress the output files"`
+	Precision     int     `arg:"help:number of significant digits (2 or 3 or 4) written for depths in the bed.gz. lower values give smaller files"`
+	Matrix        bool    `arg:"help:also write the depths to $prefix-indexcov.matrix which indexcov-lookup and indexcov-replot and indexcov-serve read in milliseconds for large cohorts"`
+	Purity        bool    `arg:"help:write rough tumor purity and ploidy estimates for each sample to $prefix-indexcov-purity.tsv"`
+	Bootstrap     int     `arg:"help:number of resamples of the tiles for 95% intervals on the sex chromosome copy-numbers and the sex call. 0 is off"`
+	Ideogram      bool    `arg:"help:draw the chromosomes of each sample colored by depth to $prefix-indexcov-ideogram-$n.png with thumbnails in the --single-html table"`
+	Replicates    string  `arg:"help:file of sample pairs that are technical replicates. concordance and MA plots are written to $prefix-indexcov-replicates.tsv"`
+	Recenter      string  `arg:"help:median or modal. modal scales each sample so the most common segment level is 1 (for tumors)"`
+	Karyotype     bool    `arg:"help:write the copy-number of each autosome arm with full and mosaic gains and losses to $prefix-indexcov-karyotype.tsv"`
+	Events        bool    `arg:"help:write a row per sample and event (qc failure or sex mismatch or --karyotype gain or loss or --calls CNV) to $prefix-indexcov-events.tsv"`
+	JSON          bool    `arg:"help:write the values in the ped file and a summary of each chromosome to $prefix-indexcov-report.json"`
+	TSV           bool    `arg:"help:write the values in the ped file and a summary of each chromosome to $prefix-indexcov-report.tsv"`
+	SingleHTML    bool    `arg:"--single-html,help:write all plots and the ped table to a single $prefix-indexcov.report.html that works without network access"`
+
+	Calibrate       string `arg:"help:flag or correct. read the alignments in the deepest tiles of each local bam to measure the bytes from secondary and supplementary alignments. correct removes them from the depth"`
+	CalibrateFlags  string `arg:"--calibrate-flags,help:comma-delimited alignments counted by --calibrate: secondary and supplementary by default or duplicate"`
+	DosageReference string `arg:"--dosage-reference,help:percentiles of the dosage of each autosome in a reference cohort. each sample's percentiles are written to $prefix-indexcov-dosage.tsv"`
+	DosageRefOut    string `arg:"--dosage-reference-out,help:write the percentiles of the dosage of each autosome in this cohort to this file for use with --dosage-reference"`
+	Hotspots        bool   `arg:"help:write regions where the linear index and the 16KB bins of a bai disagree (from clipped or discordant reads) to $prefix-indexcov-hotspots.bed.gz"`
+	SampleMap       string `arg:"--sample-map,help:tab-delimited file of a sample name (SM or file name) and the name to use for it in all output"`
+	Anonymize       string `arg:"help:file of sample names and pseudonyms (created readable only by you and extended with random pseudonyms for new samples). all output uses the pseudonyms"`
+	Genome          string `arg:"help:bundled genome (GRCh37 or GRCh38 or an alias like hg38) whose PARs and arms and blacklist and chromosome aliases are used. detected from chromosome lengths by default"`
+	GenomeFile      string `arg:"--genome-file,help:JSON file in the format of the goleft genomes package for a custom build or organism. used in place of --genome"`
+
+	Processes      int    `arg:"help:number of indexes to read and normalize in parallel. default is the number of CPUs"`
+	SexAmbiguous   string `arg:"--sex-ambiguous,help:comma-delimited low and high copy-number of the first --sex chromosome for which sex is set to 0 (unknown) in the ped file"`
+	SexMaskFailed  bool   `arg:"--sex-mask-failed,help:also set the sex to 0 (unknown) in the ped file for samples that fail qc"`
+	Manifest       string `arg:"help:tab-delimited file of path and read-group and sample. pooled bams or crams in this file are split into a sample per read-group"`
+	ExcludeRegions string `arg:"--exclude,help:bed file of regions (e.g. centromeres or a blacklist) whose 16KB tiles are left out of the ROC and PCA and ped bin columns"`
+	Examples       bool   `arg:"help:print detailed usage with examples and the columns of each output file"`
+
+	LockWait time.Duration `arg:"--lock-wait,help:how long to wait for another run with the same output prefix to finish (e.g. 30m). by default exit with 30 at once"`
+
+	Bam []string `arg:"positional,required,help:bam(s) or crais or mosdepth (.bed.gz) or samtools depth (.depth.gz) files for which to estimate coverage"`
+}{Sex: defaultSex, NMADs: 5, PCs: 5, PairsMinR: 0.95, WriteThreads: 1, Precision: 3, Window: TileWidth, FailOn: "never", Recenter: "median", ExcludePatt: `^chrEBV$|^NC|_random$|Un_|^HLA\-|_alt$|hap\d$`}
+
+// MaxCN is the default maximum normalized value. Run uses Options.MaxCN.
 var MaxCN = float32(8)
 
 // Index wraps a bam.Index to cache calculated values.
 type Index struct {
 	*bam.Index
 	crai *crai.Index
+	csi  *csi.Index
 	path string
+	// bai is the path of the .bai for bams indexed with one.
+	bai string
+	// refs are the references from the header of the bam or cram if it was available.
+	refs []*sam.Reference
 
-	//mu                *sync.RWMutex
+	mu                sync.Mutex
 	medianSizePerTile float64
 	sizes             [][]int64
 	mapped            uint64
@@ -75,13 +141,25 @@ func vOffset(o bgzf.Offset) int64 {
 }
 
 // init sets the medianSizePerTile
-func (x *Index) init() {
+func (x *Index) init() error {
 	if x.Index != nil {
-		x.sizes, x.mapped, x.unmapped = getSizes(x.Index)
+		var err error
+		if x.sizes, x.mapped, x.unmapped, err = getSizes(x.Index); err != nil {
+			return fmt.Errorf("indexcov: error reading %s: %s", x.path, err)
+		}
 		x.Index = nil
 	} else if x.crai != nil {
 		x.sizes = x.crai.Sizes()
 		x.crai = nil
+	} else if x.csi != nil {
+		x.sizes = x.csi.Sizes()
+		for i := 0; i < x.csi.NumRefs(); i++ {
+			if m, u, ok := x.csi.ReferenceStats(i); ok {
+				x.mapped += m
+				x.unmapped += u
+			}
+		}
+		x.csi = nil
 	}
 
 	// sizes is used to get the median.
@@ -90,7 +168,7 @@ func (x *Index) init() {
 		sizes = append(sizes, x.sizes[k]...)
 	}
 	if len(sizes) < 1 {
-		log.Fatalf("indexcov: no usable chromsomes in bam: %s", x.path)
+		return fmt.Errorf("indexcov: no usable chromsomes in bam: %s", x.path)
 	}
 
 	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
@@ -114,34 +192,72 @@ func (x *Index) init() {
 	}
 
 	x.medianSizePerTile = float64(sizes[idx])
+	return nil
+}
+
+// median returns the median size per tile, reading the index on first use.
+func (x *Index) median() float64 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.medianSizePerTile == 0.0 {
+		if err := x.init(); err != nil {
+			log.Println(err)
+		}
+	}
+	return x.medianSizePerTile
+}
+
+// scaled returns the size of a tile divided by the median.
+func scaled(size int64, median float64) float32 {
+	d := float32(float64(size) / median)
+	if d > 50000 {
+		d = 50000
+	}
+	return d
 }
 
 // NormalizedDepth returns a list of numbers for the normalized depth of the given region.
 // Values are scaled to have a mean of 1. If end is 0, the full chromosome is returned.
+// It is safe to call from multiple goroutines.
 func (x *Index) NormalizedDepth(refID int) []float32 {
-
-	if x.medianSizePerTile == 0.0 {
-		x.init()
-	}
+	median := x.median()
 	if refID >= len(x.sizes) {
 		return make([]float32, 0)
 	}
 	ref := x.sizes[refID]
 
 	depths := make([]float32, 0, len(ref))
-	if x.medianSizePerTile == 0 {
+	if median == 0 {
 		return depths
 	}
 
-	for i, o := range ref {
-		depths = append(depths, float32(float64(o)/x.medianSizePerTile))
-		if depths[i] > 50000 {
-			depths[i] = 50000
-		}
+	for _, o := range ref {
+		depths = append(depths, scaled(o, median))
 	}
 	return depths
 }
 
+// DepthAt returns the normalized depth at pos on the reference with the given id interpolated
+// between the centers of the tiles (see Interpolate). Unlike NormalizedDepth, only the tiles
+// around pos are scaled. It is safe to call from multiple goroutines.
+func (x *Index) DepthAt(refID, pos int) float32 {
+	median := x.median()
+	if refID < 0 || refID >= len(x.sizes) || median == 0 || pos < 0 {
+		return 0
+	}
+	ref := x.sizes[refID]
+	lo, hi := imax(0, pos/TileWidth-1), imin(len(ref), pos/TileWidth+2)
+	if lo >= hi {
+		return 0
+	}
+	var buf [3]float32
+	depths := buf[:hi-lo]
+	for i := range depths {
+		depths[i] = scaled(ref[lo+i], median)
+	}
+	return Interpolate(depths, TileWidth, pos-lo*TileWidth)
+}
+
 const slots = 70
 
 // with 0.5, we'll get centered at 1 and max of 2.
@@ -181,8 +297,12 @@ func CountsROC(counts []int) []float32 {
 	return roc
 }
 
-func getRef(b *bam.Reader, chrom string) *sam.Reference {
-	refs := b.Header().Refs()
+func getRef(al *chromAliases, refs []*sam.Reference, chrom string) *sam.Reference {
+	for _, ref := range refs {
+		if al.same(chrom, ref.Name()) {
+			return ref
+		}
+	}
 	if strings.HasPrefix(chrom, "chr") {
 		chrom = chrom[3:]
 	}
@@ -199,43 +319,86 @@ func getRef(b *bam.Reader, chrom string) *sam.Reference {
 	return nil
 }
 
+// GetShortName returns the sample name of a bam, cram or index. For a bam, it is the SM of the
+// read-groups and, for an index or a cram (or a bam with no SM), the file name. See samplename.Name.
 func GetShortName(b string, isCrai bool) (string, error) {
-	// TODO: replace this with samplename.Names()
-
 	if !isCrai {
-		fh, err := os.Open(b)
+		h, err := (*headerCache)(nil).bamHeader(b)
 		if err != nil {
 			return "", err
 		}
-		defer fh.Close()
-		br, err := bam.NewReader(fh, 1)
-		if err != nil {
-			return "", err
-		}
-		defer br.Close()
-		m := make(map[string]bool)
-		for _, rg := range br.Header().RGs() {
-			m[rg.Get(sam.Tag([2]byte{'S', 'M'}))] = true
-		}
-		if len(m) > 1 {
-			return "", fmt.Errorf("bam reagroup: more than one RG for %s", b)
-		}
-		for sm := range m {
-			return sm, nil
+		return h.name, h.nameErr
+	}
+	return shortName(b, nil)
+}
+
+// headerInfo is what is kept from the header of a bam. The rest of the header (which can be
+// large for some assemblies or pipelines) is not kept.
+type headerInfo struct {
+	refs []*sam.Reference
+	name string
+	// nameErr is set if the read-groups have more than 1 sample.
+	nameErr error
+}
+
+// headerCache caches the header of each bam that has been read as both the sample name and the
+// references are needed, sometimes in different steps. Each Run has its own so that a bam that has
+// changed since an earlier run is read again. A nil *headerCache reads the header each time.
+type headerCache struct {
+	sync.Mutex
+	m map[string]*headerInfo
+}
+
+func newHeaderCache() *headerCache {
+	return &headerCache{m: make(map[string]*headerInfo)}
+}
+
+// bamHeader reads only the header of the bam at path.
+func (headers *headerCache) bamHeader(path string) (*headerInfo, error) {
+	if headers != nil {
+		headers.Lock()
+		hi, ok := headers.m[path]
+		headers.Unlock()
+		if ok {
+			return hi, nil
 		}
 	}
-	vs := strings.Split(b, "/")
-	v := vs[len(vs)-1]
-	vs = strings.SplitN(v, ".", 2)
-	return vs[0], nil
+	fh, err := openHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	h, err := samplename.ReadHeader(fh)
+	if err != nil {
+		return nil, fmt.Errorf("indexcov: error reading header of %s: %s", path, err)
+	}
+	hi := &headerInfo{refs: h.Refs()}
+	hi.name, hi.nameErr = shortName(path, h)
+	if headers != nil {
+		headers.Lock()
+		headers.m[path] = hi
+		headers.Unlock()
+	}
+	return hi, nil
 }
 
-func getWriter(base string) (*bgzf.Writer, error) {
-	fh, err := os.Create(fmt.Sprintf("%s.bed.gz", base))
+// shortName returns the sample from the read-groups in h or, if h is nil or has no read-group
+// with an SM, the file name up to the first '.'.
+func shortName(b string, h *sam.Header) (string, error) {
+	name, _, err := samplename.Name(b, samplename.Names(h))
+	return name, err
+}
+
+// getWriter returns a bgzf writer that compresses blocks with the given number of goroutines.
+func getWriter(path string, threads int) (*bgzf.Writer, error) {
+	fh, err := os.Create(path)
 	if err != nil {
 		return nil, err
 	}
-	w := bgzf.NewWriter(fh, 1)
+	if threads < 1 {
+		threads = 1
+	}
+	w := bgzf.NewWriter(fh, threads)
 	w.ModTime = time.Unix(0, 0)
 	w.OS = 0xff
 	return w, nil
@@ -260,16 +423,20 @@ func getDirectory(path string) (bool, error) {
 
 // ReadFai returns a slit of references from the fai path.
 // If chrom is "" all chromosomes are returned.
-func ReadFai(path string, chrom string) []*sam.Reference {
+func ReadFai(path string, chrom string) ([]*sam.Reference, error) {
+	return readFai(path, chrom, defaultAliases)
+}
+
+// readFai is ReadFai with chrom matched by al.
+func readFai(path string, chrom string, al *chromAliases) ([]*sam.Reference, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error opening fai: %s. Is is present?\n", path)
-		panic(err)
+		return nil, fmt.Errorf("indexcov: error opening fai: %s. Is it present? %s", path, err)
 	}
+	defer f.Close()
 	idx, err := fai.ReadFrom(f)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error opening fai: %s. are you sure this i  s a valid fasta index?\n", path)
-		panic(err)
+		return nil, fmt.Errorf("indexcov: error opening fai: %s. are you sure this is a valid fasta index? %s", path, err)
 	}
 	recs := make([]fai.Record, 0, len(idx))
 	for _, rec := range idx {
@@ -278,12 +445,12 @@ func ReadFai(path string, chrom string) []*sam.Reference {
 	sort.Slice(recs, func(i, j int) bool { return recs[i].Start < recs[j].Start })
 	refs := make([]*sam.Reference, 0, len(idx))
 	for _, rec := range recs {
-		if chrom != "" && rec.Name != chrom {
+		if chrom != "" && !al.same(rec.Name, chrom) {
 			continue
 		}
 		ref, err := sam.NewReference(rec.Name, "", "", rec.Length, nil, nil)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		// add to the header so the id gets set.
 		refs = append(refs, ref)
@@ -291,209 +458,458 @@ func ReadFai(path string, chrom string) []*sam.Reference {
 
 	if len(refs) == 0 {
 		if chrom != "" {
-			log.Printf("ERROR: didn't find %s in %s", chrom, path)
+			return nil, fmt.Errorf("indexcov: didn't find %s in %s", chrom, path)
 		}
-		panic(fmt.Sprintf("ERROR: didn't find any usable chromosomes in %s", path))
+		return nil, fmt.Errorf("indexcov: didn't find any usable chromosomes in %s", path)
 	}
 	h, err := sam.NewHeader(nil, refs)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return h.Refs()
+	return h.Refs(), nil
 }
 
-func RefsFromBam(path string, chrom string) []*sam.Reference {
-	rdr, err := os.Open(path)
-	if err != nil {
-		log.Println("ERROR: since no .fai was specified, expected input to be a list of bams")
-		log.Printf("ERROR: got, e.g. %s", path)
-		panic(err)
-	}
-	brdr, err := bam.NewReader(rdr, 2)
+// RefsFromBam returns the references from the header of the bam at path or, if chrom is not empty,
+// only the reference that matches chrom.
+func RefsFromBam(path string, chrom string) ([]*sam.Reference, error) {
+	return refsFromBam(path, chrom, nil, defaultAliases)
+}
+
+// refsFromBam is RefsFromBam with the header from headers and chrom matched by al.
+func refsFromBam(path string, chrom string, headers *headerCache, al *chromAliases) ([]*sam.Reference, error) {
+	h, err := headers.bamHeader(path)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("indexcov: since no .fai was specified, expected input to be a list of bams. got, e.g. %s: %s", path, err)
 	}
 
-	refs := brdr.Header().Refs()
-	if chrom != "" {
-		refs = append(refs, getRef(brdr, chrom))
+	if chrom == "" {
+		// copy so callers can not change the cached header.
+		return append([]*sam.Reference(nil), h.refs...), nil
 	}
-	rdr.Close()
-	brdr.Close()
-	if refs == nil {
-		panic(fmt.Sprintf("indexcov: chromosome: %s not found", chrom))
+	ref := getRef(al, h.refs, chrom)
+	if ref == nil {
+		return nil, fmt.Errorf("indexcov: chromosome: %s not found in %s", chrom, path)
 	}
-	return refs
+	return []*sam.Reference{ref}, nil
 }
 
-func getReferences() []*sam.Reference {
-	if strings.HasSuffix(cli.Bam[0], ".bam") {
-		return RefsFromBam(cli.Bam[0], cli.Chrom)
+func getReferences(opts *Options) ([]*sam.Reference, error) {
+	// with mixed bam and cram input, any bam header gives the references.
+	for _, b := range opts.Paths {
+		if strings.HasSuffix(b, ".bam") {
+			return refsFromBam(b, opts.Chrom, opts.headers, opts.aliasTable())
+		}
 	}
 
-	if cli.Fai != "" {
-		return ReadFai(cli.Fai, cli.Chrom)
+	if opts.Fai != "" {
+		return readFai(opts.Fai, opts.Chrom, opts.aliasTable())
 	}
 
-	if strings.HasSuffix(cli.Bam[0], ".crai") {
-		path := cli.Bam[0][:len(cli.Bam[0])-5]
-		if xopen.Exists(cli.Bam[0][:len(cli.Bam[0])-5] + ".cram") {
-			path = cli.Bam[0][:len(cli.Bam[0])-5] + ".cram"
-		}
+	if len(opts.Paths) == 0 {
+		return nil, errors.New("indexcov: a fai is required when only depth sources are given")
+	}
+	if isDepthFile(opts.Paths[0]) {
+		return refsFromDepth(opts.Paths[0], opts.Chrom, opts.aliasTable())
+	}
 
-		if p, err := exec.LookPath("samtools"); err == nil {
-			log.Println(p, "view", "-H", path)
-			if out, e := exec.Command(p, "view", "-H", path).Output(); e == nil {
-				if h, e := sam.NewHeader(out, nil); e == nil {
-					return h.Refs()
-				}
-			} else {
-				log.Println(e)
-			}
+	if strings.HasSuffix(opts.Paths[0], ".crai") || strings.HasSuffix(opts.Paths[0], ".cram") {
+		path := cramPath(opts.Paths[0])
+
+		if h, err := cramHeader(path); err == nil {
+			return h.Refs(), nil
+		} else if err == errNoSamtools {
+			return nil, errors.New("indexcov: samtools is required to be on the path if indexcov is given cram indexes without an fai")
 		} else {
 			log.Println(err)
-			log.Fatal("indexcov: samtools is required to be on the path if indexcov is given cram indexes without an fai")
 		}
 	}
-	return RefsFromBam(cli.Bam[0], cli.Chrom)
+	return refsFromBam(opts.Paths[0], opts.Chrom, opts.headers, opts.aliasTable())
+}
+
+var errNoSamtools = errors.New("indexcov: samtools not found on $PATH")
+
+// cramPath returns the path to the cram given the path to a cram or crai.
+// If the cram is not found next to the crai, the crai path without the suffix is returned.
+func cramPath(path string) string {
+	if !strings.HasSuffix(path, ".crai") {
+		return path
+	}
+	path = path[:len(path)-5]
+	if strings.HasSuffix(path, ".cram") || !fileExists(path+".cram") {
+		return path
+	}
+	return path + ".cram"
+}
+
+// craiPath finds the index for a cram as $cram.crai or with .cram replaced by .crai.
+func craiPath(cram string) (string, error) {
+	if fileExists(cram + ".crai") {
+		return cram + ".crai", nil
+	}
+	if p := cram[:len(cram)-5] + ".crai"; fileExists(p) {
+		return p, nil
+	}
+	return "", fmt.Errorf("indexcov: no .crai index found for %s", cram)
+}
+
+// cramHeader uses samtools to read the header of a cram since there is no cram parser in go.
+func cramHeader(path string) (*sam.Header, error) {
+	p, err := exec.LookPath("samtools")
+	if err != nil {
+		return nil, errNoSamtools
+	}
+	log.Println(p, "view", "-H", path)
+	out, err := exec.Command(p, "view", "-H", path).Output()
+	if err != nil {
+		return nil, err
+	}
+	return sam.NewHeader(out, nil)
 }
 
 // Main is called from the goleft dispatcher
 func Main() {
+	if len(os.Args) > 1 && os.Args[1] == "lookup" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		LookupMain()
+		return
+	}
+	if wantsExamples(os.Args[1:]) {
+		if err := indexcovCommand.write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	chartjs.XFloatFormat = "%.0f"
 	p := arg.MustParse(cli)
+	failOn, err := goleft.ParseFailOn(cli.FailOn)
+	if err != nil {
+		p.Fail("indexcov: " + err.Error())
+	}
+	if cli.QCExit && failOn == goleft.FailNever {
+		failOn = goleft.FailOnFail
+	}
 	if len(cli.Bam) == 0 {
 		p.Fail(fmt.Sprintf("indexcov: expected at least 1 bam/bai/crai: %s", os.Args))
 	}
-	if len(cli.Sex) > 0 {
-		cli.sex = strings.Split(strings.TrimSpace(cli.Sex), ",")
+	opts := Options{
+		Directory:         cli.Directory,
+		LockWait:          cli.LockWait,
+		Paths:             cli.Bam,
+		Fai:               cli.Fai,
+		AliasFile:         cli.AliasFile,
+		Chrom:             cli.Chrom,
+		IncludeGL:         cli.IncludeGL,
+		Metadata:          cli.Metadata,
+		Plot:              cli.Plot,
+		SuggestThresholds: cli.SuggestThresholds,
+		ApplyThresholds:   cli.ApplyThresholds,
+		NMADs:             cli.NMADs,
+		ReferenceRanges:   cli.ReferenceRanges,
+		QCRules:           cli.QCRules,
+		Ped:               cli.Ped,
+		Controls:          cli.Controls,
+		PAR:               cli.PAR,
+		Cytobands:         cli.Cytobands,
+		Window:            cli.Window,
+		GC:                cli.GC,
+		Calls:             cli.Calls,
+		PCs:               cli.PCs,
+		Loadings:          cli.Loadings,
+		Project:           cli.Project,
+		Sketch:            cli.Sketch,
+		PCAIncludeSex:     cli.PCAIncludeSex,
+		PCAWeight:         cli.PCAWeight,
+		Pairs:             cli.Pairs,
+		PairsMinR:         cli.PairsMinR,
+		Purity:            cli.Purity,
+		Recenter:          cli.Recenter,
+		Replicates:        cli.Replicates,
+		Ideogram:          cli.Ideogram,
+		Bootstrap:         cli.Bootstrap,
+		Calibrate:         cli.Calibrate,
+		Hotspots:          cli.Hotspots,
+		DosageReference:   cli.DosageReference,
+		DosageRefOut:      cli.DosageRefOut,
+		SampleMap:         cli.SampleMap,
+		Anonymize:         cli.Anonymize,
+		Genome:            cli.Genome,
+		GenomeFile:        cli.GenomeFile,
+		Manifest:          cli.Manifest,
+		Karyotype:         cli.Karyotype,
+		Events:            cli.Events,
+		JSON:              cli.JSON,
+		TSV:               cli.TSV,
+		SingleHTML:        cli.SingleHTML,
+		ExcludeRegions:    cli.ExcludeRegions,
+		WriteThreads:      cli.WriteThreads,
+		Precision:         cli.Precision,
+		Matrix:            cli.Matrix,
+		Processes:         cli.Processes,
+		SexMaskFailed:     cli.SexMaskFailed,
+	}
+	if cli.PCs < 3 {
+		p.Fail("indexcov: --pcs must be at least 3")
+	}
+	if cli.Precision < 2 || cli.Precision > 4 {
+		p.Fail("indexcov: --precision must be 2, 3 or 4")
+	}
+	if cli.Window < TileWidth || cli.Window%TileWidth != 0 {
+		p.Fail(fmt.Sprintf("indexcov: --window must be a multiple of %d", TileWidth))
+	}
+	if cli.Bootstrap < 0 {
+		p.Fail("indexcov: --bootstrap must be positive")
+	}
+	if cli.Calibrate != "" && cli.Calibrate != "flag" && cli.Calibrate != "correct" {
+		p.Fail("indexcov: --calibrate must be flag or correct")
+	}
+	if cli.CalibrateFlags != "" {
+		opts.CalibrateFlags = strings.Split(strings.TrimSpace(cli.CalibrateFlags), ",")
+	}
+	if cli.Recenter != "median" && cli.Recenter != "modal" {
+		p.Fail("indexcov: --recenter must be median or modal")
+	}
+	if cli.SexAmbiguous != "" {
+		var err error
+		if opts.SexAmbiguous, err = parseInterval(cli.SexAmbiguous); err != nil {
+			p.Fail(fmt.Sprintf("indexcov: bad --sex-ambiguous: %s", err))
+		}
+	}
+	if cli.PCAExclude != "" {
+		opts.PCAExclude = strings.Split(strings.TrimSpace(cli.PCAExclude), ",")
+	}
+	if opts.Sex, err = genomeSex(cli.Sex, cli.Genome, cli.GenomeFile); err != nil {
+		p.Fail("indexcov: " + err.Error())
 	}
 	if cli.ExcludePatt != "" {
-		cli.exclude = regexp.MustCompile(cli.ExcludePatt)
+		var err error
+		if opts.Exclude, err = regexp.Compile(cli.ExcludePatt); err != nil {
+			p.Fail(fmt.Sprintf("indexcov: bad --excludepatt: %s", err))
+		}
 	}
 
-	if exists, err := getDirectory(cli.Directory); err != nil || !exists {
-		log.Fatalf("indexcov: error creating specified directory: %s, %s", cli.Directory, err)
+	envPlotOptions(&opts)
+
+	res, err := Run(opts)
+	if err != nil {
+		goleft.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "indexcov finished: see %s for overview of output\n", res.IndexHTML)
+	if len(res.Failed) > 0 {
+		fmt.Fprintf(os.Stderr, "indexcov: %d of %d samples failed qc\n", len(res.Failed), len(res.Samples))
+	}
+	if len(res.Warned) > 0 {
+		fmt.Fprintf(os.Stderr, "indexcov: %d of %d samples have qc warnings\n", len(res.Warned), len(res.Samples))
 	}
+	os.Exit(failOn.Status(len(res.Failed), len(res.Warned)))
+}
 
-	// the lengths and names of references from bams or fasta
-	refs := getReferences()
+// ReadIndex returns an Index pointer from the specified bam, bai, csi or crai path.
+func ReadIndex(path string) (*Index, error) {
+	idx, _, err := readIndex(path, nil)
+	return idx, err
+}
 
-	names := make([]string, len(cli.Bam))
-	idxs := make([]*Index, len(cli.Bam))
-	ch := make(chan rdi, 8)
+// parallel calls fn for each of 0..n-1 using the given number of goroutines.
+func parallel(n int, processes int, fn func(i int)) {
+	if processes > n {
+		processes = n
+	}
+	ch := make(chan int, processes)
 	wg := &sync.WaitGroup{}
-	wg.Add(8)
-	for k := 0; k < 8; k++ {
+	wg.Add(processes)
+	for k := 0; k < processes; k++ {
 		go func() {
-			for r := range ch {
-				idx, name, i := readIndex(r)
-				names[i] = name
-				idxs[i] = idx
+			for i := range ch {
+				fn(i)
 			}
 			wg.Done()
 		}()
 	}
-
-	for i, b := range cli.Bam {
-		ch <- rdi{bamPath: b, i: i}
+	for i := 0; i < n; i++ {
+		ch <- i
 	}
 	close(ch)
 	wg.Wait()
+}
 
-	sexes, counts, pca8, chromNames, slopes := run(refs, idxs, names, getBase(cli.Directory))
-	mapped := make([]uint64, len(names))
-	unmapped := make([]uint64, len(names))
-	anygt := false
-	for i, ix := range idxs {
-		mapped[i] = ix.mapped
-		unmapped[i] = ix.unmapped
-		if ix.mapped > 0 || ix.unmapped > 0 {
-			anygt = true
+// readSources reads the index or depth file for each path in parallel. The names
+// are returned in the same order as the paths. The time and bytes read of each are added to opts.stats.
+func readSources(opts *Options, paths []string, refs []*sam.Reference) ([]DepthSource, []string, error) {
+	stats := opts.stats
+	names := make([]string, len(paths))
+	srcs := make([]DepthSource, len(paths))
+	errs := make([]error, len(paths))
+	parallel(len(paths), opts.processes(), func(i int) {
+		t := time.Now()
+		if isDepthFile(paths[i]) {
+			var d *depthFile
+			if d, errs[i] = readDepthFile(paths[i], refs, opts.aliasTable()); errs[i] == nil {
+				srcs[i] = d
+				names[i], errs[i] = shortName(paths[i], nil)
+			}
+			// depth files are read with xopen so the size of the file is used.
+			var n int64
+			if fi, err := os.Stat(paths[i]); err == nil {
+				n = fi.Size()
+				reads.add(paths[i], n)
+			}
+			stats.addInput(paths[i], time.Since(t), n)
+			return
+		}
+		var idx *Index
+		if idx, names[i], errs[i] = readIndex(paths[i], opts.headers); errs[i] == nil {
+			srcs[i] = idx
+			stats.addInput(paths[i], time.Since(t), stats.read(paths[i], idx.path, idx.bai))
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
 		}
 	}
-	if !anygt {
-		mapped = nil
-		unmapped = nil
-	}
-
-	chartjs.XFloatFormat = "%.2f"
-	if indexPath := writeIndex(sexes, counts, cli.sex, names, cli.Directory, pca8, slopes, chromNames, mapped, unmapped); indexPath != "" {
-		fmt.Fprintf(os.Stderr, "indexcov finished: see %s for overview of output\n", indexPath)
-	}
+	return srcs, names, nil
 }
 
-type rdi struct {
-	bamPath string
-	i       int
-}
+// get an initialized index and the sample name from a bam, cram or index path. The header of a bam
+// is read with headers.
+func readIndex(b string, headers *headerCache) (*Index, string, error) {
 
-// ReadIndex returns an Index pointer from the specified bam or crai path.
-func ReadIndex(path string) *Index {
-	i, _, _ := readIndex(rdi{path, 0})
-	return i
-}
-
-// get an initialized index from a bamPath.
-// `i` is used in the return when parallelized to keep same order.
-func readIndex(r rdi) (*Index, string, int) {
-	b := r.bamPath
-
-	if strings.HasSuffix(b, ".crai") {
-		f, err := os.Open(b)
+	if strings.HasSuffix(b, ".cram") {
+		ci, err := craiPath(b)
 		if err != nil {
-			panic(err)
+			return nil, "", err
 		}
-		gz, err := gzip.NewReader(f)
+		idx, err := readCrai(ci)
 		if err != nil {
-			panic(err)
+			return nil, "", err
+		}
+		// the sample name can come from the cram header if samtools is available.
+		var h *sam.Header
+		if h, err = cramHeader(b); err == nil {
+			idx.refs = h.Refs()
 		}
-		cr, err := crai.ReadIndex(gz)
+		nm, err := shortName(b, h)
+		return idx, nm, err
+	}
+
+	if strings.HasSuffix(b, ".csi") {
+		idx, err := readCsi(b)
 		if err != nil {
-			panic(err)
+			return nil, "", err
 		}
-		idx := &Index{crai: cr, path: b}
-		idx.init()
 		nm, err := GetShortName(b, true)
+		return idx, nm, err
+	}
+
+	if strings.HasSuffix(b, ".crai") {
+		idx, err := readCrai(b)
 		if err != nil {
-			panic(err)
+			return nil, "", err
 		}
-		return idx, nm, r.i
+		nm, err := GetShortName(b, true)
+		return idx, nm, err
 	}
 
 	suf := ".bai"
 	if strings.HasSuffix(b, ".bai") {
 		suf = ""
 	}
-	rdr, err := os.Open(b + suf)
+	bai := b + suf
+	rdr, err := openFile(bai)
 	if err != nil {
 		var terr error
-		rdr, terr = os.Open(b[:(len(b)-4)] + suf)
+		bai = b[:(len(b)-4)] + suf
+		rdr, terr = openFile(bai)
 		if terr != nil {
-			panic(err)
+			// bams with chromosomes > 512Mb only have a .csi index.
+			if csiPath := b + ".csi"; suf != "" && fileExists(csiPath) {
+				idx, err := readCsi(csiPath)
+				if err != nil {
+					return nil, "", err
+				}
+				return bamName(idx, b, headers)
+			}
+			return nil, "", err
 		}
 	}
+	defer rdr.Close()
 
 	dx, err := bam.ReadIndex(bufio.NewReader(rdr))
 	if err != nil {
-		panic(err)
+		return nil, "", fmt.Errorf("indexcov: error reading index for %s: %s", b, err)
 	}
-	idx := &Index{Index: dx, path: b}
-	idx.init()
-	nm, err := GetShortName(b, strings.HasSuffix(b, ".bai"))
+	idx := &Index{Index: dx, path: b, bai: bai}
+	if err := idx.init(); err != nil {
+		return nil, "", err
+	}
+	if strings.HasSuffix(b, ".bai") {
+		nm, err := GetShortName(b, true)
+		return idx, nm, err
+	}
+	return bamName(idx, b, headers)
+}
+
+// bamName reads the header of the bam to get the sample name and
+// the references that the sample was aligned to.
+func bamName(idx *Index, b string, headers *headerCache) (*Index, string, error) {
+	h, err := headers.bamHeader(b)
+	if err != nil {
+		return nil, "", err
+	}
+	idx.refs = h.refs
+	return idx, h.name, h.nameErr
+}
+
+func readCrai(path string) (*Index, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("indexcov: error reading %s: %s", path, err)
+	}
+	cr, err := crai.ReadIndex(gz)
+	if err != nil {
+		return nil, fmt.Errorf("indexcov: error reading %s: %s", path, err)
+	}
+	idx := &Index{crai: cr, path: path}
+	return idx, idx.init()
+}
+
+func readCsi(path string) (*Index, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cx, err := csi.ReadIndex(bufio.NewReader(f))
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("indexcov: error reading %s: %s", path, err)
 	}
-	return idx, nm, r.i
+	idx := &Index{csi: cx, path: path}
+	return idx, idx.init()
 }
 
 // if there are more samples than this then the depth plots won't be drawn.
 const maxSamples = 100
 
-func sameChrom(as []string, b string) bool {
+// sexName returns the name in sex that matches chrom with al.
+func sexName(al *chromAliases, sex []string, chrom string) string {
+	for _, s := range sex {
+		if al.same(s, chrom) {
+			return s
+		}
+	}
+	return chrom
+}
+
+// sameChrom returns true if b is any of the chromosomes in as. Names are matched
+// with the chromosome aliases in al so that e.g. chrX, X and NC_000023.11 are the same.
+func sameChrom(al *chromAliases, as []string, b string) bool {
 	for _, a := range as {
-		if a == b {
+		if al.same(a, b) {
 			return true
 		}
 		na := a
@@ -509,7 +925,22 @@ func sameChrom(as []string, b string) bool {
 	return false
 }
 
-func run(refs []*sam.Reference, idxs []*Index, names []string, base string) (map[string][]float64, []*counter, [][]uint8, []string, []float32) {
+// coverage is what run gathers from the depths of all samples for the ped, PCA and plots.
+type coverage struct {
+	// sexes is the copy-number of each sex chromosome for each sample.
+	sexes  map[string][]float64
+	counts []*counter
+	// pca8 is the depth of each sample in each tile used for the PCA. pcaTiles gives the position
+	// of each column.
+	pca8     [][]uint8
+	pcaTiles []pcaTile
+	// chroms are the chromosomes in the output.
+	chroms []string
+	// slopes is the slope of the coverage line between 1-delta and 1+delta of each sample.
+	slopes []float32
+}
+
+func run(opts *Options, refs []*sam.Reference, idxs []DepthSource, names []string, base string, rep *report, page *singleReport, boot *bootstrapper) (*coverage, error) {
 	// keep a slice of charts since we plot all of the coverage roc charts in a single html file.
 	sexes := make(map[string][]float64)
 	counts := make([][]int, len(idxs))
@@ -520,116 +951,367 @@ func run(refs []*sam.Reference, idxs []*Index, names []string, base string) (map
 	offs := make([]*counter, len(idxs))
 	// uint8 to use less memory.
 	pca8 := make([][]uint8, len(idxs))
+	// pcaTiles gives the position of each column of pca8.
+	var pcaTiles []pcaTile
 	log.Printf("indexcov: running on %d indexes", len(idxs))
 	if len(idxs) > maxSamples {
 		log.Printf("indexcov: creating only static (no interactive) plots for depth because # of samples %d is > %d\n", len(idxs), maxSamples)
 	}
 
-	tmp, err := getWriter(base)
+	dfmt := fmt.Sprintf("%%.%dg", opts.precision())
+	tmp, err := getWriter(fmt.Sprintf("%s.bed.gz", base), opts.WriteThreads)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer tmp.Close()
 	bgz := bufio.NewWriter(tmp)
-	defer bgz.Flush()
+	// the bed.gz is closed and indexed at the end unless there is an error.
+	bedDone := false
+	defer func() {
+		if !bedDone {
+			bgz.Flush()
+			tmp.Close()
+		}
+	}()
 
 	rtmp, err := os.Create(fmt.Sprintf("%s.roc", base))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer rtmp.Close()
 	rfh := bufio.NewWriter(rtmp)
 	defer rfh.Flush()
+
+	stmp, err := os.Create(fmt.Sprintf("%s.chrom-stats.tsv", base))
+	if err != nil {
+		return nil, err
+	}
+	defer stmp.Close()
+	sfh := bufio.NewWriter(stmp)
+	defer sfh.Flush()
+	fmt.Fprintln(sfh, "#chrom\tsample\tbins\tmedian\tmad\tp.zero\tp.out")
 	chromNames := make([]string, 0, len(refs))
 
-	fmt.Fprintf(bgz, "#chrom\tstart\tend\t%s\n", strings.Join(names, "\t"))
+	// each bin is the mean of this many 16KB tiles.
+	width := opts.window()
+	tiles := width / TileWidth
+	bands, err := loadCytobands(opts.Cytobands, refs, opts.genome, opts.aliasTable())
+	if err != nil {
+		return nil, goleft.InputErr(err)
+	}
+	var calls *callWriter
+	if opts.Calls {
+		if calls, err = newCallWriter(base, refs, names, bands, width, opts); err != nil {
+			return nil, err
+		}
+		calls.events = opts.events
+	}
+
+	var purity *purityFitter
+	if opts.Purity {
+		purity = newPurityFitter(len(idxs), opts.ploidy())
+	}
+	var karyo *karyotyper
+	if opts.Karyotype {
+		karyo = newKaryotyper(len(idxs), width, opts.ploidy())
+	}
+	var reps *replicateChecker
+	if opts.Replicates != "" {
+		if reps, err = readReplicates(opts.Replicates, names, opts.pseudonyms); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+	}
+
+	var ideo *ideogram
+	if opts.Ideogram {
+		ideo = newIdeogram(opts, refs)
+	}
+
+	var dref *dosageRef
+	var dos *dosages
+	if opts.DosageReference != "" {
+		if dref, err = readDosageRef(opts.DosageReference, opts.aliasTable()); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+	}
+	if opts.DosageReference != "" || opts.DosageRefOut != "" {
+		dos = &dosages{ploidy: opts.ploidy()}
+	}
+
+	var excluded *excludedTiles
+	if opts.ExcludeRegions != "" {
+		if excluded, err = readExcluded(opts.ExcludeRegions, opts.aliasTable()); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+	} else if opts.genome != nil && len(opts.genome.Blacklist) > 0 {
+		excluded = newExcludedTiles(opts.aliasTable())
+		for _, r := range opts.genome.Blacklist {
+			excluded.add(r.Chrom, r.Start, r.End)
+		}
+		log.Printf("indexcov: excluding %d blacklist regions of %s", len(opts.genome.Blacklist), opts.genome.Name)
+	}
+	if excluded != nil {
+		// excluded tiles are still written to the bed, flagged by this column.
+		fmt.Fprintf(bgz, "#chrom\tstart\tend\texcluded\t%s\n", strings.Join(names, "\t"))
+	} else {
+		fmt.Fprintf(bgz, "#chrom\tstart\tend\t%s\n", strings.Join(names, "\t"))
+	}
+	var mx *matrix.Writer
+	if opts.Matrix {
+		if mx, err = matrix.Create(base+".matrix", names, width, opts.grid().String()); err != nil {
+			return nil, err
+		}
+	}
+	pars, err := parTiles(opts.PAR, refs, opts.Sex, opts.genome, opts.aliasTable())
+	if err != nil {
+		return nil, err
+	}
 	ir := -1
 	for _, ref := range refs {
 		chrom := ref.Name()
-		if cli.exclude != nil && cli.exclude.Match([]byte(chrom)) {
-			log.Printf("indexcov: excluding chromosome: %s because of exclude-pattern: %s", chrom, cli.ExcludePatt)
+		if excludeChrom(opts, chrom) {
+			log.Printf("indexcov: excluding chromosome: %s because of exclude-pattern: %s", chrom, opts.Exclude)
 			continue
 		}
 		ir++
 		// Some samples may not have all the data, so we always take the longest sample for printing.
 		longest, longesti := 0, 0
+		mask := windowMask(excluded.mask(chrom), tiles)
 
-		for k, idx := range idxs {
-			if ir == 0 {
+		first := ir == 0
+		done := opts.stats.begin(stageDepth)
+		parallel(len(idxs), opts.processes(), func(k int) {
+			if first {
 				pca8[k] = make([]uint8, 0, 2e5)
 				offs[k] = &counter{}
 			}
-			depths[k] = idx.NormalizedDepth(ref.ID())
-			if len(depths[k]) > longest {
-				longesti = k
-				longest = len(depths[k])
-			}
-			if ir == 0 {
+			depths[k] = idxs[k].NormalizedDepth(ref.ID())
+			if first {
 				counts[k] = make([]int, slots)
 			} else {
 				zero(counts[k])
 			}
 
-			CountsAtDepth(depths[k], counts[k])
+			CountsAtDepth(unmasked(depths[k], mask), counts[k])
+		})
+		done()
+		for k := range idxs {
+			if len(depths[k]) > longest {
+				longesti = k
+				longest = len(depths[k])
+			}
 		}
 
+		done = opts.stats.begin(stageWrite)
 		for i := 0; i < len(depths[longesti]); i++ {
-			fmt.Fprintf(bgz, "%s\t%d\t%d\t%s\n", chrom, i*16384, (i+1)*16384, depthsFor(depths, i))
+			if excluded != nil {
+				flag := 0
+				if isMasked(mask, i) {
+					flag = 1
+				}
+				fmt.Fprintf(bgz, "%s\t%d\t%d\t%d\t%s\n", chrom, i*width, (i+1)*width, flag, depthsFor(depths, i, dfmt))
+			} else {
+				fmt.Fprintf(bgz, "%s\t%d\t%d\t%s\n", chrom, i*width, (i+1)*width, depthsFor(depths, i, dfmt))
+			}
+		}
+		if mx != nil {
+			if err := mx.Add(chrom, ref.Len(), depths); err != nil {
+				return nil, err
+			}
+		}
+		// before the depths of autosomes are capped at maxCN below.
+		if err := writeChromStats(sfh, chrom, depths, names, longest, mask, opts.processes()); err != nil {
+			return nil, err
+		}
+		done()
+		if opts.controls != nil {
+			opts.controls.add(chrom, depths, width)
 		}
 
-		isSex := sameChrom(cli.sex, chrom)
+		isSex := sameChrom(opts.aliasTable(), opts.Sex, chrom)
+		cnDepths := depths
 		if isSex {
 			if len(depths[longesti]) > 0 {
-				sexes[chrom] = GetCN(depths)
+				cnDepths = withoutPAR(depths, windowMask(pars.mask(chrom), tiles))
+				// keyed by the name given in --sex which may be an alias of chrom.
+				sexes[sexName(opts.aliasTable(), opts.Sex, chrom)] = getCN(cnDepths, opts.ploidy())
 			}
-		} else {
-			// now add non-sex chromosomes to the pca data since we know the longest.
-			var dp float32
-			for k := range idxs {
-				i := -1 // initalize to -1 to differentiate from never entering loop.
+		}
+		if boot != nil && len(depths[longesti]) > 0 && (isSex || rep != nil) {
+			cis, reps := boot.chrom(cnDepths, opts.processes())
+			if isSex {
+				boot.addSex(sexName(opts.aliasTable(), opts.Sex, chrom), reps)
+			}
+			if rep != nil {
+				rep.addCNs(chrom, cis)
+			}
+		}
+		if ideo != nil && (opts.IncludeGL || !strings.HasPrefix(chrom, "GL")) && longest > 2 {
+			ideo.add(chrom, ref.Len(), depths, width, mask, opts.processes())
+		}
+		maxCN := opts.maxCN()
+		if pcaChrom(opts, chrom) {
+			// now add the chromosome to the pca data since we know the longest.
+			parallel(len(idxs), opts.processes(), func(k int) {
 				dps := depths[k]
-				for i, dp = range dps {
-					// := depths[k][i]
-					if dp > MaxCN {
-						dp = MaxCN
-						depths[k][i] = dp
+				for i, dp := range dps {
+					if dp > maxCN {
+						dp = maxCN
+					}
+					if !isMasked(mask, i) {
+						// scale to 0..255; larger values would overflow the uint8.
+						pca8[k] = append(pca8[k], uint8(255/maxCN*dp+0.5))
+					}
+				}
+				// pad shorter samples so that the tiles line up across samples.
+				for i := len(dps); i < longest; i++ {
+					if !isMasked(mask, i) {
+						pca8[k] = append(pca8[k], 0)
 					}
-					pca8[k] = append(pca8[k], uint8(65535/MaxCN*dp+0.5))
 				}
-				for ; i < longest; i++ {
-					pca8[k] = append(pca8[k], 0)
+			})
+			for i := 0; i < longest; i++ {
+				if !isMasked(mask, i) {
+					pcaTiles = append(pcaTiles, pcaTile{chrom: chrom, tile: i})
 				}
-				offs[k].count(dps, longest)
+			}
+		}
+		if !isSex {
+			parallel(len(idxs), opts.processes(), func(k int) {
+				dps := depths[k]
+				for i, dp := range dps {
+					if dp > maxCN {
+						dps[i] = maxCN
+					}
+				}
+				offs[k].count(unmasked(dps, mask), nUnmasked(longest, mask))
+			})
+			if calls != nil && longest > 0 {
+				if err := calls.add(ref, depths, longest); err != nil {
+					return nil, err
+				}
+			}
+			if purity != nil && longest > 0 {
+				purity.add(chrom, depths, longest)
+			}
+			if karyo != nil && longest > 0 {
+				karyo.add(chrom, depths, longest, mask)
+			}
+			if reps != nil && longest > 0 {
+				reps.add(depths, longest, mask)
+			}
+			if dos != nil && longest > 0 && (opts.IncludeGL || !strings.HasPrefix(chrom, "GL")) {
+				dos.add(chrom, getCN(depths, opts.ploidy()))
 			}
 		}
 
 		if len(depths[longesti]) > 0 {
-			c, rocs := writeROCs(counts, names, chrom, rfh)
+			c, rocs, err := writeROCs(counts, names, chrom, rfh, opts.plot())
+			if err != nil {
+				return nil, err
+			}
+			if rep != nil {
+				rep.addROCs(chrom, rocs)
+			}
 			// only plot those with at least 3 regions.
-			if (cli.IncludeGL || !strings.HasPrefix(chrom, "GL")) && len(depths[longesti]) > 2 {
+			if (opts.IncludeGL || !strings.HasPrefix(chrom, "GL")) && len(depths[longesti]) > 2 {
 				if !isSex && longest > 100 {
 					updateSlopes(rocs, float32(ref.Len())/1e6, slopes)
 					nSlopes++
 				}
 				chromNames = append(chromNames, chrom)
-				if err := plotDepths(depths, names, chrom, base, len(names) <= maxSamples); err != nil {
-					panic(err)
+				done := opts.stats.begin(stagePlots)
+				if err := plotDepths(opts, depths, names, chrom, width, bands, base, len(names) <= maxSamples); err != nil {
+					return nil, err
 				}
-				tmp := chartjs.XFloatFormat
-				chartjs.XFloatFormat = "%.2f"
 				c.Options.Legend = &chartjs.Legend{Display: types.False}
 				link := `<a href="index.html">back to index</a>`
-				saveCharts(fmt.Sprintf("%s-roc-%s.html", base, chrom), "", link, c)
-				chartjs.XFloatFormat = tmp
-				asPng(fmt.Sprintf("%s-roc-%s.png", base, chrom), c, 4, 3)
+				if err := withXFormat("%.2f", func() error {
+					return saveCharts(fmt.Sprintf("%s-roc-%s.html", base, chrom), "", link, c)
+				}); err != nil {
+					return nil, err
+				}
+				if err := plots.SavePNG(fmt.Sprintf("%s-roc-%s.png", base, chrom), c, 4, 3, opts.plot()); err != nil {
+					return nil, err
+				}
+				if page != nil {
+					if err := page.addChrom(chrom, depths, rocs, width, fmt.Sprintf("%s-depth-%s.png", base, chrom)); err != nil {
+						return nil, err
+					}
+				}
+				done()
 			}
 		}
 	}
 	for i, s := range slopes {
 		slopes[i] = s / float32(nSlopes)
 	}
-	checkSexes(sexes, cli.sex)
-	return sexes, offs, pca8, chromNames, slopes
+	if calls != nil {
+		if err := calls.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if mx != nil {
+		if err := mx.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if purity != nil {
+		if err := purity.write(base+"-purity.tsv", names); err != nil {
+			return nil, err
+		}
+	}
+	if opts.controls != nil {
+		if err := opts.controls.write(base + "-controls.tsv"); err != nil {
+			return nil, err
+		}
+	}
+	if karyo != nil {
+		if err := karyo.write(base+"-karyotype.tsv", names); err != nil {
+			return nil, err
+		}
+		if opts.events != nil {
+			opts.events.addKaryotype(karyo, names)
+		}
+	}
+	if reps != nil {
+		if err := reps.write(base, names); err != nil {
+			return nil, err
+		}
+	}
+	if dos != nil {
+		if err := dos.write(base+"-dosage.tsv", names, dref); err != nil {
+			return nil, err
+		}
+		if opts.DosageRefOut != "" {
+			if err := dos.writeRef(opts.DosageRefOut); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if ideo != nil {
+		thumbs, err := ideo.write(base, names, opts.processes())
+		if err != nil {
+			return nil, err
+		}
+		if page != nil {
+			page.Ideograms = thumbs
+		}
+	}
+	if err := checkSexes(sexes, opts.Sex); err != nil {
+		return nil, err
+	}
+	bedDone = true
+	if err := bgz.Flush(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	// lookup reads a region with the index. without it, the whole bed.gz is read.
+	if err := tabix.Write(base + ".bed.gz"); err != nil {
+		log.Printf("indexcov: not indexing %s.bed.gz: %s", base, err)
+	}
+	return &coverage{sexes: sexes, counts: offs, pca8: pca8, pcaTiles: pcaTiles, chroms: chromNames, slopes: slopes}, nil
 }
 
 // updateSlopes adjusts the slopes slice for each sample.
@@ -656,61 +1338,39 @@ func keys(o map[string][]float64) []string {
 	return skeys
 }
 
-func checkSexes(obs map[string][]float64, exp []string) {
+func checkSexes(obs map[string][]float64, exp []string) error {
 	if len(obs) != len(exp) {
 		msg := fmt.Sprintf("indexcov: expected %d sex chromosomes, found: %d.", len(exp), len(obs))
 		msg += fmt.Sprintf("\nyou can set the expected with --sex '%s'", strings.Join(keys(obs), ","))
 		// if it found no sex chromosomes *and* it was not the default, then error.
 		// but it it was the default, it's just a warning.
 		if len(obs) == 0 && !reflect.DeepEqual(exp, []string{"X", "Y"}) {
-			log.Fatal("(FATAL) " + msg)
+			return errors.New(msg)
 		}
 		fmt.Fprintln(os.Stderr, "(WARNING) "+msg)
 	}
+	return nil
 }
 
-func pca(pca8 [][]uint8, samples []string) (*mat.Dense, []chartjs.Chart, string) {
-	imat := mat.NewDense(len(pca8), len(pca8[0]), nil)
-	row := make([]float64, len(pca8[0]))
-	for i := 0; i < len(pca8); i++ {
-		for j, v := range pca8[i] {
-			row[j] = float64(v)
-		}
-		imat.SetRow(i, row)
-	}
-	var pc stat.PC
-	if ok := pc.PrincipalComponents(imat, nil); !ok {
-		panic("indexcov: error with principal components")
+// pca projects the samples onto the top k principal components of their depths on the autosomes.
+// At least 3 components are needed for the plots.
+func pca(pca8 [][]uint8, samples []string, k int, weights []float64, processes int, po plots.Options) (*mat.Dense, *pcaFit, []chartjs.Chart, string, error) {
+	if n := imin(len(pca8), len(pca8[0])); n < 3 {
+		log.Printf("indexcov: %d principal components, not plotting", n)
+		return nil, nil, nil, "", nil
 	}
-
-	k := 5
-	vars := pc.VarsTo(nil)
-	floats.Scale(1/floats.Sum(vars), vars)
-	if len(vars) < k {
-		k = len(vars)
-		log.Printf("got: %d principal components", len(vars))
-		if k < 3 {
-			log.Printf("indexcov: %d principal components, not plotting", k)
-			return nil, nil, ""
-		}
+	proj, fit, err := randomizedPCA(pca8, k, weights, processes)
+	if err != nil {
+		return nil, nil, nil, "", err
 	}
-	vars = vars[:k]
-
-	var proj mat.Dense
-	proj.Mul(imat, pc.VectorsTo(nil).Slice(0, len(pca8[0]), 0, k))
-	pcaPlots, customjs := plotPCA(&proj, samples, vars)
-
-	return &proj, pcaPlots, customjs
-}
-
-func getBase(directory string) string {
-	prefix := filepath.Base(directory)
-	return directory + string(os.PathSeparator) + prefix + "-indexcov"
+	pcaPlots, customjs, err := plots.PCA(proj, samples, fit.vars, po)
+	return proj, fit, pcaPlots, customjs, err
 }
 
 // write an index.html and a ped file. includes the PC projections and inferred sexes.
-func writeIndex(sexes map[string][]float64, counts []*counter, keys []string, samples []string, directory string, pca8 [][]uint8, slopes []float32,
-	chromNames []string, mapped []uint64, unmapped []uint64) string {
+func writeIndex(opts *Options, sexes map[string][]float64, counts []*counter, samples []string, pca8 [][]uint8, pcaTiles []pcaTile, weights []float64, slopes []float32,
+	chromNames []string, mapped []uint64, unmapped []uint64, refMatch []float64, rep *report, page *singleReport, boot *bootstrapper) (string, *sampleTable, error) {
+	keys, base := opts.Sex, opts.base()
 	if len(sexes) == 0 {
 		log.Println("sex chromosomes not found.")
 	} else {
@@ -720,13 +1380,60 @@ func writeIndex(sexes map[string][]float64, counts []*counter, keys []string, sa
 			}
 		}
 	}
-	pcs, pcaPlots, pcajs := pca(pca8, samples)
-	binChart, binjs := plotBins(counts, samples)
+	done := opts.stats.begin(stagePCA)
+	pcs, fit, pcaPlots, pcajs, err := pca(pca8, samples, opts.pcs(), weights, opts.processes(), opts.plot())
+	done()
+	if err != nil {
+		return "", nil, err
+	}
+	if fit != nil && opts.Loadings {
+		if err := fit.writeLoadings(base+"-loadings.bed.gz", pcaTiles, opts.window(), opts.WriteThreads); err != nil {
+			return "", nil, err
+		}
+	}
+	binChart, binjs, err := plotBins(counts, samples, opts.Backgrounds)
+	if err != nil {
+		return "", nil, err
+	}
+	var pairsPNG string
+	var pairOrder []string
+	var ps *pairScreen
+	if opts.Pairs {
+		ps = screenPairs(pca8, opts.pairsMinR(), opts.processes())
+		if err := ps.write(base+".pairs.tsv", samples, opts.pairsMinR()); err != nil {
+			return "", nil, err
+		}
+		for _, p := range ps.dups {
+			log.Printf("indexcov: WARNING: samples %s and %s have a correlation of %.4f. are they duplicates?", samples[p.a], samples[p.b], p.r)
+		}
+		if ps.matrix != nil {
+			order := clusterOrder(ps.matrix)
+			if err := ps.writeHeatmap(base+"-pairs.png", order); err != nil {
+				return "", nil, err
+			}
+			pairsPNG = opts.name() + "-indexcov-pairs.png"
+			for _, o := range order {
+				pairOrder = append(pairOrder, samples[o])
+			}
+		}
+	}
+	var pedFams []pedFamily
+	if opts.Ped != "" {
+		people, err := readPedFamilies(opts.Ped, opts.pseudonyms)
+		if err != nil {
+			return "", nil, goleft.InputErr(fmt.Errorf("indexcov: error reading ped: %s", err))
+		}
+		pairs := pedPairs(people, samples, pca8, ps)
+		if err := writePedPairs(base+".ped-pairs.tsv", pairs, samples, people, opts.pairsMinR()); err != nil {
+			return "", nil, err
+		}
+		pedFams = pedFamilies(pairs, samples, people, opts.pairsMinR())
+	}
 
 	sexes["_inferred"] = make([]float64, len(samples))
-	f, err := os.Create(fmt.Sprintf("%s.ped", getBase(directory)))
+	f, err := os.Create(fmt.Sprintf("%s.ped", base))
 	if err != nil {
-		panic(err)
+		return "", nil, err
 	}
 	defer f.Close()
 	hdr := make([]string, len(keys), len(keys)+7)
@@ -737,7 +1444,7 @@ func writeIndex(sexes map[string][]float64, counts []*counter, keys []string, sa
 	var c int
 	if pcs != nil {
 		_, c = pcs.Dims()
-		for i := 0; i < 5 && i < c; i++ {
+		for i := 0; i < c; i++ {
 			hdr = append(hdr, fmt.Sprintf("PC%d", i+1))
 		}
 	}
@@ -745,11 +1452,11 @@ func writeIndex(sexes map[string][]float64, counts []*counter, keys []string, sa
 		hdr = append(hdr, "mapped")
 		hdr = append(hdr, "unmapped")
 	}
+	hdr = append(hdr, "ref.match")
 
-	fmt.Fprintf(f, "#family_id\tsample_id\tpaternal_id\tmaternal_id\tsex\tphenotype\t%s\n", strings.Join(hdr, "\t"))
-	tmpl := "unknown\t%s\t-9\t-9\t%d\t-9\t"
+	table := newSampleTable(append([]string{"family_id", "sample_id", "paternal_id", "maternal_id", "sex", "phenotype"}, hdr...))
 	var inferred int
-	for i, s := range samples {
+	for i, sample := range samples {
 		if counts[i] == nil {
 			continue
 		}
@@ -758,9 +1465,8 @@ func writeIndex(sexes map[string][]float64, counts []*counter, keys []string, sa
 		} else {
 			inferred = -9
 		}
-		fmt.Fprintf(f, tmpl, s, inferred)
 		sexes["_inferred"][i] = float64(inferred)
-		s := make([]string, 0, len(keys)+4)
+		s := []string{"unknown", sample, "-9", "-9", strconv.Itoa(inferred), "-9"}
 		for _, k := range keys {
 			if _, ok := sexes[k]; ok {
 				s = append(s, fmt.Sprintf("%.2f", sexes[k][i]))
@@ -777,42 +1483,157 @@ func writeIndex(sexes map[string][]float64, counts []*counter, keys []string, sa
 			fmt.Sprintf("%.3f", slopes[i]),
 			fmt.Sprintf("%.2f", float64(cnt.out)/float64(cnt.in)),
 		}...)
-		for j := 0; j < c && j < 5; j++ {
+		for j := 0; j < c; j++ {
 			s = append(s, fmt.Sprintf("%.2f", pcs.At(i, j)))
 		}
 		if mapped != nil {
 			s = append(s, strconv.Itoa(int(mapped[i])))
 			s = append(s, strconv.Itoa(int(unmapped[i])))
 		}
+		s = append(s, fmt.Sprintf("%.3f", refMatch[i]))
+		table.add(sample, s)
+	}
+	if opts.panel != nil {
+		coords, err := opts.panel.project(pca8, pcaTiles, opts.window())
+		if err != nil {
+			return "", nil, goleft.InputErr(fmt.Errorf("indexcov: error projecting onto %s: %s", opts.Project, err))
+		}
+		var pc1, pc2 []string
+		for i, c := range coords {
+			if counts[i] != nil {
+				pc1 = append(pc1, fmt.Sprintf("%.2f", c[0]))
+				pc2 = append(pc2, fmt.Sprintf("%.2f", c[1]))
+			}
+		}
+		table.addColumn("ref.PC1", pc1)
+		table.addColumn("ref.PC2", pc2)
+	}
+	if boot != nil {
+		boot.addColumns(table, samples, keys, sexes)
+	}
+	if opts.SuggestThresholds || opts.ApplyThresholds {
+		nMADs := opts.nMADs()
+		ths, err := suggestThresholds(table, nMADs)
+		if err != nil {
+			return "", nil, err
+		}
+		writeThresholds(os.Stderr, ths, nMADs)
+		if opts.ApplyThresholds {
+			qc, err := applyThresholds(table, ths)
+			if err != nil {
+				return "", nil, err
+			}
+			table.addColumn("qc", qc)
+		}
+	}
+	var outsideRef []bool
+	if opts.ReferenceRanges != "" {
+		ranges, err := readReferenceRanges(opts.ReferenceRanges)
+		if err != nil {
+			return "", nil, goleft.InputErr(fmt.Errorf("indexcov: error reading reference ranges: %s", err))
+		}
+		var flags []string
+		if flags, outsideRef, err = outsideReference(table, ranges); err != nil {
+			return "", nil, fmt.Errorf("indexcov: error with reference ranges: %s", err)
+		}
+		table.addColumn("outside.ref", flags)
+	}
+	if opts.QCRules != "" || opts.Ped != "" || opts.controls != nil {
+		if err := runQC(opts, table); err != nil {
+			return "", nil, err
+		}
+	} else if opts.events != nil {
+		opts.events.addQC(table, nil, nil)
+	}
+	if len(sexes) > 1 {
+		maskSex(table, samples, sexes[keys[0]], sexes["_inferred"], opts.SexAmbiguous, opts.SexMaskFailed)
+	}
+	if err := table.write(f); err != nil {
+		return "", nil, err
+	}
+	if page != nil {
+		addSingleScatters(page, keys, sexes, counts, pcs, fit, mapped, unmapped)
+		if err := page.write(base+".report.html", table); err != nil {
+			return "", nil, err
+		}
+	}
+	if rep != nil && opts.JSON {
+		if err := rep.writeJSON(base+"-report.json", table, keys, opts.aliasTable()); err != nil {
+			return "", nil, err
+		}
+	}
+	if rep != nil && opts.TSV {
+		if err := rep.writeTSV(base+"-report.tsv", table); err != nil {
+			return "", nil, err
+		}
+	}
 
-		fmt.Fprintln(f, strings.Join(s, "\t"))
+	if opts.Metadata != "" {
+		if err := table.readMetadata(opts.Metadata, opts.pseudonyms); err != nil {
+			return "", nil, goleft.InputErr(fmt.Errorf("indexcov: error reading metadata: %s", err))
+		}
+	}
+	defer opts.stats.begin(stagePlots)()
+	var extraPlots []string
+	for _, spec := range opts.Plot {
+		p, err := writeColumnPlot(table, spec, base, outsideRef)
+		if err != nil {
+			return "", nil, fmt.Errorf("indexcov: error with plot %s: %s", spec, err)
+		}
+		extraPlots = append(extraPlots, p)
+	}
+	if opts.panel != nil {
+		p, err := writeColumnPlot(table, "x=ref.PC1,y=ref.PC2", base, outsideRef)
+		if err != nil {
+			return "", nil, err
+		}
+		extraPlots = append(extraPlots, p)
 	}
 	var sexChart *chartjs.Chart
 	var sexjs string
 
 	if len(keys) > 1 && len(sexes) > 1 {
-		sexChart, sexjs, err = plotSex(sexes, keys[:2], samples)
+		if len(samples)-opts.Backgrounds > maxSexPoints {
+			sexChart, sexjs, err = plotSexDensity(sexes, keys[:2], samples, opts.Backgrounds)
+		} else {
+			sexChart, sexjs, err = plots.Sex(sexCNs(sexes, keys[:2]), samples, opts.plot())
+		}
 		if err != nil {
-			panic(err)
+			return "", nil, err
 		}
 	}
 
 	var mapChart *chartjs.Chart
 	var mapjs string
 	if mapped != nil {
-		mapChart, mapjs, err = plotMapped(mapped, unmapped, samples)
+		mapChart, mapjs, err = plotMapped(mapped, unmapped, samples, opts.Backgrounds)
 		if err != nil {
-			panic(err)
+			return "", nil, err
 		}
 	}
 
-	indexPath := fmt.Sprintf("%s%cindex.html", directory, os.PathSeparator)
+	var vars []float64
+	if fit != nil {
+		vars = fit.vars
+	}
+	specs, err := chartSpecs(opts, chromNames, samples, vars, sexChart != nil, mapped != nil, pairsPNG)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := writeCharts(base+"-charts.json", opts, specs, samples); err != nil {
+		return "", nil, err
+	}
+
+	indexPath := fmt.Sprintf("%s%cindex.html", opts.Directory, os.PathSeparator)
 	wtr, err := os.Create(indexPath)
 	if err != nil {
-		panic(err)
+		return "", nil, err
 	}
+	defer wtr.Close()
 	if sexChart != nil {
-		asPng(fmt.Sprintf("%s-sex.png", getBase(directory)), *sexChart, 6, 6)
+		if err := plots.SavePNG(fmt.Sprintf("%s-sex.png", base), *sexChart, 6, 6, opts.plot()); err != nil {
+			return "", nil, err
+		}
 	}
 
 	chartMap := map[string]interface{}{"pcajs": template.JS(pcajs), "pcbjs": template.JS(pcajs),
@@ -826,12 +1647,16 @@ func writeIndex(sexes map[string][]float64, counts []*counter, keys []string, sa
 		"hasMap":   mapped != nil,
 		"mapjs":    template.JS(mapjs),
 
-		"bin":     binChart,
-		"binjs":   template.JS(binjs),
-		"version": goleft.Version,
-		"prefix":  getBase(directory),
-		"name":    filepath.Base(directory),
-		"chroms":  chromNames}
+		"bin":       binChart,
+		"binjs":     template.JS(binjs),
+		"version":   goleft.Version,
+		"prefix":    base,
+		"name":      opts.name(),
+		"chroms":    chromNames,
+		"plots":     extraPlots,
+		"pairsPNG":  pairsPNG,
+		"pairOrder": strings.Join(pairOrder, ", "),
+		"pedFams":   pedFams}
 	if len(pcaPlots) > 1 {
 		chartMap["pca"] = pcaPlots[0]
 		chartMap["pcb"] = pcaPlots[1]
@@ -840,40 +1665,51 @@ func writeIndex(sexes map[string][]float64, counts []*counter, keys []string, sa
 		chartMap["hasPCA"] = false
 	}
 	chartMap["notmany"] = len(samples) <= maxSamples
-	if err := chartjs.SaveCharts(wtr, chartMap, chartjs.Chart{}); err != nil {
-		panic(err)
+	if err := withXFormat("%.2f", func() error {
+		return chartjs.SaveCharts(wtr, chartMap, chartjs.Chart{})
+	}); err != nil {
+		return "", nil, err
+	}
+	return indexPath, table, wtr.Close()
+}
+
+// maskSex sets the sex to 0 (unknown) in the ped table and in inferred when the copy-number of the
+// first sex chromosome is within the ambiguous interval or, if failed is true, when the sample
+// failed qc, as tools that use the ped file will take the sex as truth.
+func maskSex(t *sampleTable, samples []string, cn []float64, inferred []float64, ambiguous []float64, failed bool) {
+	si := t.column("sex")
+	qc, _ := t.strings("qc")
+	for i, sample := range samples {
+		r, ok := t.index[sample]
+		if !ok {
+			continue
+		}
+		if (len(ambiguous) == 2 && cn[i] >= ambiguous[0] && cn[i] <= ambiguous[1]) || (failed && qc != nil && qc[r] == "FAIL") {
+			t.rows[r][si] = "0"
+			inferred[i] = 0
+		}
 	}
-	wtr.Close()
-	return indexPath
 }
 
 // GetCN returns an float per sample estimating the number of copies of that chromosome.
 // It is a very crude estimate, but that's what indexcov is and it tends to work well.
 func GetCN(depths [][]float32) []float64 {
+	return getCN(depths, Ploidy)
+}
+
+// getCN is GetCN for samples of the given ploidy.
+func getCN(depths [][]float32, ploidy int) []float64 {
 	if depths == nil {
 		return nil
 	}
 	meds := make([]float64, 0, len(depths))
 	for _, d := range depths {
-		tmp := make([]float32, 0, len(d))
-		lows := 0
-		for _, dp := range d {
-			// exclude sites that are exactly 0 as these are the centromere.
-			if dp != 0 {
-				tmp = append(tmp, dp)
-				if dp < 0.02 {
-					lows++
-				}
-			}
-		}
+		// exclude sites that are exactly 0 as these are the centromere and, e.g. on Y, the many
+		// very low values in males and females.
+		tmp := cnTiles(d)
 		if len(tmp) > 0 {
 			sort.Slice(tmp, func(i, j int) bool { return tmp[i] < tmp[j] })
-			// e.g. on Y, there are so many very low values in males and females that we have to filter...
-			pLo := float64(lows) / float64(len(d))
-			if pLo > 0.3 {
-				tmp = tmp[lows:]
-			}
-			med := float64(float32(Ploidy) * tmp[int(float64(len(tmp))*0.5)])
+			med := float64(float32(ploidy) * tmp[int(float64(len(tmp))*0.5)])
 			meds = append(meds, med)
 		} else {
 			meds = append(meds, -0.1)
@@ -882,19 +1718,17 @@ func GetCN(depths [][]float32) []float64 {
 	return meds
 }
 
-func saveCharts(path string, customjs string, customHTML string, charts ...chartjs.Chart) {
+func saveCharts(path string, customjs string, customHTML string, charts ...chartjs.Chart) error {
 	if len(charts) == 0 {
-		return
+		return nil
 	}
 	wtr, err := os.Create(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer wtr.Close()
-	if err := chartjs.SaveCharts(wtr, map[string]interface{}{"height": 550, "width": 650, "custom": template.JS(customjs),
-		"customHTML": template.HTML(customHTML)}, charts...); err != nil {
-		panic(err)
-	}
+	return chartjs.SaveCharts(wtr, map[string]interface{}{"height": 550, "width": 650, "custom": template.JS(customjs),
+		"customHTML": template.HTML(customHTML)}, charts...)
 }
 
 func getROCs(counts [][]int) [][]float32 {
@@ -907,11 +1741,11 @@ func getROCs(counts [][]int) [][]float32 {
 
 }
 
-func writeROCs(counts [][]int, names []string, chrom string, fh io.Writer) (chartjs.Chart, [][]float32) {
+func writeROCs(counts [][]int, names []string, chrom string, fh io.Writer, po plots.Options) (chartjs.Chart, [][]float32, error) {
 	rocs := getROCs(counts)
-	chart, err := plotROCs(rocs, names, chrom)
+	chart, err := plotROCs(rocs, names, chrom, po)
 	if err != nil {
-		panic(err)
+		return chart, nil, err
 	}
 	fmt.Fprintf(fh, "#chrom\tcov\t%s\n", strings.Join(names, "\t"))
 	nSamples := len(names)
@@ -924,16 +1758,17 @@ func writeROCs(counts [][]int, names []string, chrom string, fh io.Writer) (char
 		}
 		fmt.Fprintf(fh, "%s\t%.2f\t%s\n", chrom, float64(i)/(slots*slotsMid), strings.Join(vals, "\t"))
 	}
-	return chart, rocs
+	return chart, rocs, nil
 }
 
-func depthsFor(depths [][]float32, i int) string {
+// depthsFor formats the depths of all samples at tile i with dfmt, e.g. %.3g.
+func depthsFor(depths [][]float32, i int, dfmt string) string {
 	s := make([]string, len(depths))
 	for j := 0; j < len(depths); j++ {
 		if i >= len(depths[j]) {
 			s[j] = "0"
 		} else {
-			s[j] = fmt.Sprintf("%.3g", depths[j][i])
+			s[j] = fmt.Sprintf(dfmt, depths[j][i])
 		}
 	}
 	return strings.Join(s, "\t")
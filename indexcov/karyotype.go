@@ -0,0 +1,148 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+)
+
+// a run of at least this many gap tiles (e.g. 1.6MB) inside a chromosome is taken as the
+// centromere and splits the chromosome into arms.
+const minCentromereTiles = 100
+
+// arms with fewer usable tiles than this are not reported.
+const minArmTiles = 20
+
+// the copy-number of an arm must differ from the ploidy by at least this much and by
+// karyoSEs standard errors to be reported as a change.
+const (
+	minKaryoShift = 0.1
+	karyoSEs      = 3
+)
+
+// changes within this distance of an integer copy-number are full aneuploidies. Others are
+// reported as mosaic.
+const integerTol = 0.15
+
+// arm is a span of tiles [start, end) on a chromosome.
+type arm struct {
+	name       string
+	start, end int
+}
+
+// chromArms splits a chromosome at the longest run of gaps. If there is no such run, the
+// chromosome is a single arm named ".". The p arm of acrocentric chromosomes is returned but is
+// mostly gaps so it has fewer than minArmTiles usable tiles and is not reported.
+func chromArms(gaps []bool) []arm {
+	bs, be := 0, 0
+	for i := 0; i < len(gaps); {
+		j := i
+		for j < len(gaps) && gaps[j] {
+			j++
+		}
+		if j-i > be-bs {
+			bs, be = i, j
+		}
+		i = j + 1
+	}
+	if be-bs < minCentromereTiles {
+		return []arm{{name: ".", start: 0, end: len(gaps)}}
+	}
+	return []arm{{name: "p", start: 0, end: bs}, {name: "q", start: be, end: len(gaps)}}
+}
+
+// karyoEvent is the copy-number of an arm in a single sample.
+type karyoEvent struct {
+	chrom, arm string
+	start, end int
+	tiles      int
+	cn, se     float64
+}
+
+// call classifies the event as normal, a full gain or loss or a mosaic gain or loss.
+// For mosaic events, the fraction of cells that carry a single copy change from the integer
+// level on the side of ploidy is returned, e.g. 0.4 for a copy-number of 2.4 or 3.4 and 0.3 for 1.7.
+func (e karyoEvent) call(ploidy int) (string, float64) {
+	dev := e.cn - float64(ploidy)
+	if math.Abs(dev) < minKaryoShift || math.Abs(dev) < karyoSEs*e.se {
+		return "normal", math.NaN()
+	}
+	kind := "gain"
+	if dev < 0 {
+		kind = "loss"
+	}
+	if math.Abs(e.cn-math.Round(e.cn)) <= integerTol {
+		return kind, math.NaN()
+	}
+	if dev < 0 {
+		return "mosaic-" + kind, math.Ceil(e.cn) - e.cn
+	}
+	return "mosaic-" + kind, e.cn - math.Floor(e.cn)
+}
+
+// karyotyper collects the copy-number of each arm of each sample as chromosomes are processed.
+type karyotyper struct {
+	events [][]karyoEvent
+	// width is the length of each bin.
+	width  int
+	ploidy int
+}
+
+func newKaryotyper(n int, width int, ploidy int) *karyotyper {
+	return &karyotyper{events: make([][]karyoEvent, n), width: width, ploidy: ploidy}
+}
+
+// add estimates the copy-number of each arm of chrom as the ploidy times the median depth of
+// its tiles. Gaps and excluded tiles are not used.
+func (ky *karyotyper) add(chrom string, depths [][]float32, longest int, mask []bool) {
+	gaps := findGaps(depths, longest)
+	arms := chromArms(gaps)
+	vals := make([]float64, 0, longest)
+	for k, d := range depths {
+		for _, a := range arms {
+			vals = vals[:0]
+			for i := a.start; i < a.end && i < len(d); i++ {
+				if !gaps[i] && !isMasked(mask, i) {
+					vals = append(vals, float64(ky.ploidy)*float64(d[i]))
+				}
+			}
+			if len(vals) < minArmTiles {
+				continue
+			}
+			med, mad := medianMAD(vals)
+			// the standard error of the median is ~1.25 times that of the mean.
+			se := 1.2533 * mad / math.Sqrt(float64(len(vals)))
+			ky.events[k] = append(ky.events[k], karyoEvent{chrom: chrom, arm: a.name, start: a.start * ky.width,
+				end: a.end * ky.width, tiles: len(vals), cn: med, se: se})
+		}
+	}
+}
+
+// write writes a row for each arm of each sample to path.
+func (ky *karyotyper) write(path string, samples []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample\tchrom\tarm\tstart\tend\ttiles\tcn\tse\tcall\tmosaic.fraction")
+	for k, evs := range ky.events {
+		for _, e := range evs {
+			call, frac := e.call(ky.ploidy)
+			sfrac := "."
+			if !math.IsNaN(frac) {
+				sfrac = fmt.Sprintf("%.2f", frac)
+			}
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%.3f\t%.3f\t%s\t%s\n", samples[k], e.chrom, e.arm,
+				e.start, e.end, e.tiles, e.cn, e.se, call, sfrac); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
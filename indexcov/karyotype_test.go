@@ -0,0 +1,63 @@
+package indexcov
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKaryoEventCall(t *testing.T) {
+	for _, c := range []struct {
+		cn, se float64
+		call   string
+		frac   float64
+	}{
+		{2.05, 0.01, "normal", math.NaN()},
+		// a shift that is not 3 standard errors from Ploidy is normal.
+		{2.5, 0.2, "normal", math.NaN()},
+		{3.02, 0.01, "gain", math.NaN()},
+		{0.95, 0.01, "loss", math.NaN()},
+		{2.4, 0.01, "mosaic-gain", 0.4},
+		{1.7, 0.01, "mosaic-loss", 0.3},
+		// mosaic changes on top of a full gain or loss.
+		{3.4, 0.01, "mosaic-gain", 0.4},
+		{0.6, 0.01, "mosaic-loss", 0.4},
+	} {
+		call, frac := karyoEvent{cn: c.cn, se: c.se}.call(2)
+		if call != c.call {
+			t.Errorf("cn %.2f: expected %s, got %s", c.cn, c.call, call)
+		}
+		if math.IsNaN(c.frac) != math.IsNaN(frac) || math.Abs(frac-c.frac) > 1e-9 {
+			t.Errorf("cn %.2f: expected mosaic fraction %.2f, got %.2f", c.cn, c.frac, frac)
+		}
+	}
+}
+
+func TestChromArms(t *testing.T) {
+	gaps := make([]bool, 1000)
+	for i := 300; i < 300+minCentromereTiles; i++ {
+		gaps[i] = true
+	}
+	// a shorter run of gaps is not the centromere.
+	for i := 800; i < 810; i++ {
+		gaps[i] = true
+	}
+	arms := chromArms(gaps)
+	if len(arms) != 2 || arms[0] != (arm{name: "p", start: 0, end: 300}) || arms[1] != (arm{name: "q", start: 400, end: 1000}) {
+		t.Errorf("unexpected arms: %v", arms)
+	}
+
+	// an acrocentric chromosome has a short p arm that is mostly gaps.
+	acro := make([]bool, 1000)
+	for i := 5; i < 200; i++ {
+		acro[i] = true
+	}
+	arms = chromArms(acro)
+	if len(arms) != 2 || arms[0].end-arms[0].start >= minArmTiles {
+		t.Errorf("expected a p arm with fewer than %d tiles, got: %v", minArmTiles, arms)
+	}
+
+	arms = chromArms(make([]bool, 100))
+	if len(arms) != 1 || arms[0] != (arm{name: ".", start: 0, end: 100}) {
+		t.Errorf("expected a single arm with no centromere, got: %v", arms)
+	}
+}
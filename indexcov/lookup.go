@@ -0,0 +1,486 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/brentp/goleft"
+	"github.com/brentp/goleft/genomes"
+	"github.com/brentp/goleft/indexcov/matrix"
+	"github.com/brentp/goleft/indexcov/tabix"
+	"github.com/brentp/xopen"
+	"github.com/fatih/color"
+)
+
+var lookupCli = &struct {
+	Samples    string `arg:"-s,help:comma-delimited names of samples to show. default is all"`
+	Sort       bool   `arg:"help:order the samples by their mean depth in the region rather than as in the bed"`
+	Columns    int    `arg:"help:most bins to show. adjacent bins are averaged to fit"`
+	Genome     string `arg:"help:bundled genome whose chromosome aliases are used to match the region"`
+	GenomeFile string `arg:"--genome-file,help:JSON file in the format of the goleft genomes package whose chromosome aliases are used to match the region"`
+	Prefix     string `arg:"positional,required,help:$dir/$name-indexcov prefix or bed.gz or matrix of a finished run or its directory"`
+	Region     string `arg:"positional,required,help:region as chrom:start-end (commas are allowed) or chrom"`
+}{Columns: 12}
+
+// lookupLow and lookupHigh are the depths below and above which a value is colored on a terminal.
+const (
+	lookupLow  = 0.7
+	lookupHigh = 1.3
+)
+
+// LookupMain is called from the goleft dispatcher as indexcov-lookup (or as indexcov lookup). It
+// prints the depths of all samples in a region of a finished run with the mean and SD of the cohort.
+func LookupMain() {
+	p := arg.MustParse(lookupCli)
+	if lookupCli.Columns < 1 {
+		p.Fail("indexcov-lookup: --columns must be at least 1")
+	}
+	chrom, start, end, err := parseRegion(lookupCli.Region)
+	if err != nil {
+		p.Fail("indexcov-lookup: " + err.Error())
+	}
+	g, err := genomes.Resolve(lookupCli.Genome, lookupCli.GenomeFile)
+	if err != nil {
+		p.Fail("indexcov-lookup: " + err.Error())
+	}
+	al := defaultAliases
+	if g != nil {
+		al = al.with(g.ChromAliases)
+	}
+	var samples []string
+	if lookupCli.Samples != "" {
+		samples = strings.Split(lookupCli.Samples, ",")
+	}
+	var reg *regionDepths
+	if path, ok := lookupMatrix(lookupCli.Prefix); ok {
+		reg, err = readRegionMatrix(path, chrom, start, end, samples, al)
+	} else {
+		path, err = lookupBed(lookupCli.Prefix)
+		if err != nil {
+			goleft.Fatal(goleft.InputErr(err))
+		}
+		reg, err = readRegion(path, chrom, start, end, al)
+	}
+	if err != nil {
+		goleft.Fatal(goleft.InputErr(err))
+	}
+	if err := reg.keep(samples); err != nil {
+		goleft.Fatal(goleft.InputErr(err))
+	}
+	if err := reg.write(os.Stdout, lookupCli.Columns, lookupCli.Sort); err != nil {
+		goleft.Fatal(err)
+	}
+}
+
+// parseRegion parses chrom:start-end with 1-based inclusive coordinates as used by samtools and
+// tabix and returns them as 0-based and half-open. An end of -1 is the end of the chromosome.
+func parseRegion(region string) (string, int, int, error) {
+	region = strings.Replace(strings.TrimSpace(region), ",", "", -1)
+	i := strings.LastIndexByte(region, ':')
+	if i == -1 {
+		if region == "" {
+			return "", 0, 0, fmt.Errorf("empty region")
+		}
+		return region, 0, -1, nil
+	}
+	chrom, se := region[:i], region[i+1:]
+	toks := strings.SplitN(se, "-", 2)
+	start, err := strconv.Atoi(toks[0])
+	if err != nil || start < 1 {
+		return "", 0, 0, fmt.Errorf("bad start in region %s", region)
+	}
+	end := start
+	if len(toks) == 2 {
+		if end, err = strconv.Atoi(toks[1]); err != nil || end < start {
+			return "", 0, 0, fmt.Errorf("bad end in region %s", region)
+		}
+	}
+	return chrom, start - 1, end, nil
+}
+
+// regionString is the inverse of parseRegion.
+func regionString(chrom string, start, end int) string {
+	if end == -1 {
+		return chrom
+	}
+	return fmt.Sprintf("%s:%d-%d", chrom, start+1, end)
+}
+
+// lookupBed returns the bed.gz of a run given its prefix, the bed.gz itself or the directory.
+func lookupBed(prefix string) (string, error) {
+	if strings.HasSuffix(prefix, ".bed.gz") {
+		return prefix, nil
+	}
+	if fileExists(prefix + ".bed.gz") {
+		return prefix + ".bed.gz", nil
+	}
+	if fi, err := os.Stat(prefix); err == nil && fi.IsDir() {
+		beds, _ := filepath.Glob(filepath.Join(prefix, "*-indexcov.bed.gz"))
+		if len(beds) == 1 {
+			return beds[0], nil
+		}
+		if len(beds) > 1 {
+			return "", fmt.Errorf("indexcov: %d runs found in %s. give the prefix of one", len(beds), prefix)
+		}
+	}
+	return "", fmt.Errorf("indexcov: no indexcov bed.gz found for %s", prefix)
+}
+
+// lookupMatrix returns the matrix of a run (from --matrix) given its prefix, its bed.gz, the matrix
+// itself or the directory. It is false if the run has no matrix.
+func lookupMatrix(prefix string) (string, bool) {
+	if strings.HasSuffix(prefix, ".matrix") {
+		return prefix, true
+	}
+	if p := strings.TrimSuffix(prefix, ".bed.gz") + ".matrix"; fileExists(p) {
+		return p, true
+	}
+	if fi, err := os.Stat(prefix); err == nil && fi.IsDir() {
+		if ms, _ := filepath.Glob(filepath.Join(prefix, "*-indexcov.matrix")); len(ms) == 1 {
+			return ms[0], true
+		}
+	}
+	return "", false
+}
+
+// regionDepths are the depths of each sample in the bins of a region.
+type regionDepths struct {
+	chrom      string
+	start, end int
+	samples    []string
+	// starts and ends of the bins and, for each sample, the depth in each bin.
+	starts, ends []int
+	depths       [][]float64
+	// aliases match the chromosome of the region to those in the file.
+	aliases *chromAliases
+}
+
+// readRegion reads the bins of the bed at path that overlap the region. If there is a tabix index
+// (as written by indexcov) only the region is read. Otherwise the bed is read up to the region.
+// chrom is matched with al.
+func readRegion(path, chrom string, start, end int, al *chromAliases) (*regionDepths, error) {
+	reg := &regionDepths{chrom: chrom, start: start, end: end, aliases: al}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, ok := indexedRegion(f, path, chrom, start, al)
+	if !ok {
+		rdr, err := xopen.Ropen(path)
+		if err != nil {
+			return nil, err
+		}
+		defer rdr.Close()
+		r = rdr
+	}
+	if err := reg.parse(bufio.NewReaderSize(r, 1<<20), path); err != nil {
+		return nil, err
+	}
+	if reg.samples == nil {
+		return nil, fmt.Errorf("indexcov: no header found in %s. is it from indexcov?", path)
+	}
+	if len(reg.starts) == 0 {
+		return nil, fmt.Errorf("indexcov: no bins found for %s in %s", regionString(chrom, start, end), path)
+	}
+	return reg, nil
+}
+
+// readRegionMatrix reads the bins of the matrix at path that overlap the region. Only the blocks of
+// the samples (or of all samples if samples is empty) in the region are read.
+func readRegionMatrix(path, chrom string, start, end int, samples []string, al *chromAliases) (*regionDepths, error) {
+	r, err := matrix.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	ci := -1
+	for i, c := range r.Chroms {
+		if al.same(c.Name, chrom) {
+			ci = i
+		}
+	}
+	if ci == -1 {
+		return nil, fmt.Errorf("indexcov: no bins found for %s in %s", regionString(chrom, start, end), path)
+	}
+	reg := &regionDepths{chrom: chrom, start: start, end: end, samples: r.Samples, aliases: al}
+	var cols []int
+	if len(samples) > 0 {
+		reg.samples = make([]string, len(samples))
+		for i, s := range samples {
+			reg.samples[i] = strings.TrimSpace(s)
+			if cols = append(cols, r.Column(reg.samples[i])); cols[i] == -1 {
+				return nil, fmt.Errorf("indexcov: sample %s not found", reg.samples[i])
+			}
+		}
+	}
+	from, to := start/r.Width, r.Chroms[ci].Bins
+	if end != -1 && (end+r.Width-1)/r.Width < to {
+		to = (end + r.Width - 1) / r.Width
+	}
+	if from >= to {
+		return nil, fmt.Errorf("indexcov: no bins found for %s in %s", regionString(chrom, start, end), path)
+	}
+	depths, err := r.Slice(ci, from, to, cols)
+	if err != nil {
+		return nil, err
+	}
+	reg.depths = make([][]float64, len(depths))
+	for k, ds := range depths {
+		reg.depths[k] = make([]float64, len(ds))
+		for i, d := range ds {
+			reg.depths[k][i] = float64(d)
+		}
+	}
+	for i := from; i < to; i++ {
+		reg.starts = append(reg.starts, i*r.Width)
+		reg.ends = append(reg.ends, (i+1)*r.Width)
+	}
+	return reg, nil
+}
+
+// indexedRegion returns the header and the lines of f from the first that may overlap the region
+// using the tabix index of path. It is false if there is no index or the chromosome is not in it.
+// chrom is matched with al.
+func indexedRegion(f *os.File, path, chrom string, start int, al *chromAliases) (io.Reader, bool) {
+	idx, err := tabix.Read(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("indexcov: %s. reading the whole file", err)
+		}
+		return nil, false
+	}
+	for i, name := range idx.Names {
+		if al.same(name, chrom) {
+			r, err := idx.Region(f, i, start)
+			if err != nil {
+				log.Printf("indexcov: error reading %s with its index: %s. reading the whole file", path, err)
+				return nil, false
+			}
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// parse reads the header and the bins of the region from br. The bins of a chromosome are
+// together so reading stops at the first bin past the region.
+func (r *regionDepths) parse(br *bufio.Reader, path string) error {
+	skip := 3
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			if line[0] == '#' {
+				toks := strings.Split(line, "\t")
+				if len(toks) > 3 && toks[3] == "excluded" {
+					skip = 4
+				}
+				r.samples = toks[skip:]
+				r.depths = make([][]float64, len(r.samples))
+			} else if toks := strings.SplitN(line, "\t", 4); len(toks) == 4 && r.aliases.same(toks[0], r.chrom) {
+				s, serr := strconv.Atoi(toks[1])
+				e, eerr := strconv.Atoi(toks[2])
+				if serr != nil || eerr != nil {
+					return fmt.Errorf("indexcov: bad bin in %s: %.50s", path, line)
+				}
+				if r.end != -1 && s >= r.end {
+					return nil
+				}
+				if e > r.start {
+					if err := r.add(s, e, strings.Split(line, "\t")[skip:], path); err != nil {
+						return err
+					}
+				}
+			} else if len(r.starts) > 0 {
+				// past the chromosome.
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (r *regionDepths) add(start, end int, toks []string, path string) error {
+	if len(toks) != len(r.samples) {
+		return fmt.Errorf("indexcov: expected %d samples in %s, got %d", len(r.samples), path, len(toks))
+	}
+	for k, t := range toks {
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return fmt.Errorf("indexcov: bad depth in %s: %s", path, err)
+		}
+		r.depths[k] = append(r.depths[k], v)
+	}
+	r.starts = append(r.starts, start)
+	r.ends = append(r.ends, end)
+	return nil
+}
+
+// keep leaves only the given samples in the order given. All are kept if samples is empty.
+func (r *regionDepths) keep(samples []string) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	cols := make(map[string]int, len(r.samples))
+	for k, s := range r.samples {
+		cols[s] = k
+	}
+	depths := make([][]float64, 0, len(samples))
+	for i, s := range samples {
+		s = strings.TrimSpace(s)
+		k, ok := cols[s]
+		if !ok {
+			return fmt.Errorf("indexcov: sample %s not found", s)
+		}
+		samples[i] = s
+		depths = append(depths, r.depths[k])
+	}
+	r.samples, r.depths = samples, depths
+	return nil
+}
+
+// columns averages adjacent bins so there are at most n and returns the start of each column and
+// the depths of each sample in them.
+func (r *regionDepths) columns(n int) ([]int, [][]float64) {
+	per := (len(r.starts) + n - 1) / n
+	var starts []int
+	for i := 0; i < len(r.starts); i += per {
+		starts = append(starts, r.starts[i])
+	}
+	depths := make([][]float64, len(r.samples))
+	for k, ds := range r.depths {
+		depths[k] = make([]float64, len(starts))
+		for c := range starts {
+			j := (c + 1) * per
+			if j > len(ds) {
+				j = len(ds)
+			}
+			depths[k][c], _ = meanSD(ds[c*per : j])
+		}
+	}
+	return starts, depths
+}
+
+func meanSD(vals []float64) (float64, float64) {
+	if len(vals) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	var s float64
+	for _, v := range vals {
+		s += v
+	}
+	m := s / float64(len(vals))
+	var ss float64
+	for _, v := range vals {
+		ss += (v - m) * (v - m)
+	}
+	if len(vals) == 1 {
+		return m, 0
+	}
+	return m, math.Sqrt(ss / float64(len(vals)-1))
+}
+
+// commas formats v with thousands separators as in the region given on the command-line.
+func commas(v int) string {
+	s := strconv.Itoa(v)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// write prints a row for each sample with its depth in each column and its mean and z-score in the
+// region followed by rows with the mean and SD of the cohort. Low and high depths are colored when
+// w is a terminal.
+func (r *regionDepths) write(w io.Writer, ncols int, byMean bool) error {
+	starts, depths := r.columns(ncols)
+	means := make([]float64, len(r.samples))
+	for k, ds := range r.depths {
+		means[k], _ = meanSD(ds)
+	}
+	cohortMean, cohortSD := meanSD(means)
+
+	order := make([]int, len(r.samples))
+	width := len("sample")
+	for k, s := range r.samples {
+		order[k] = k
+		if len(s) > width {
+			width = len(s)
+		}
+	}
+	if byMean {
+		sort.SliceStable(order, func(i, j int) bool { return means[order[i]] < means[order[j]] })
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s:%s-%s  %d samples  %d bins of %d", r.chrom, commas(r.starts[0]+1), commas(r.ends[len(r.ends)-1]),
+		len(r.samples), len(r.starts), r.ends[0]-r.starts[0])
+	if len(starts) < len(r.starts) {
+		fmt.Fprintf(bw, " averaged to %d columns", len(starts))
+	}
+	fmt.Fprint(bw, ". columns are labeled by their start in Mb\n\n")
+
+	label := "%-" + strconv.Itoa(width) + "s"
+	fmt.Fprintf(bw, label, "sample")
+	for c := range starts {
+		fmt.Fprintf(bw, " %7.3f", float64(starts[c])/1e6)
+	}
+	fmt.Fprintf(bw, " | %6s %6s\n", "mean", "z")
+	low, high := color.New(color.FgBlue).SprintFunc(), color.New(color.FgRed).SprintFunc()
+	cell := func(v float64) string {
+		s := fmt.Sprintf(" %7.2f", v)
+		switch {
+		case v < lookupLow:
+			return low(s)
+		case v > lookupHigh:
+			return high(s)
+		}
+		return s
+	}
+	for _, k := range order {
+		fmt.Fprintf(bw, label, r.samples[k])
+		for _, v := range depths[k] {
+			fmt.Fprint(bw, cell(v))
+		}
+		z := math.NaN()
+		if cohortSD > 0 {
+			z = (means[k] - cohortMean) / cohortSD
+		}
+		fmt.Fprintf(bw, " | %6.2f %6.2f\n", means[k], z)
+	}
+
+	fmt.Fprintln(bw, strings.Repeat("-", width+8*len(starts)+16))
+	colMeans := make([]float64, len(starts))
+	colSDs := make([]float64, len(starts))
+	for c := range starts {
+		vals := make([]float64, len(depths))
+		for k := range depths {
+			vals[k] = depths[k][c]
+		}
+		colMeans[c], colSDs[c] = meanSD(vals)
+	}
+	fmt.Fprintf(bw, label, "mean")
+	for _, v := range colMeans {
+		fmt.Fprintf(bw, " %7.2f", v)
+	}
+	fmt.Fprintf(bw, " | %6.2f\n", cohortMean)
+	fmt.Fprintf(bw, label, "sd")
+	for _, v := range colSDs {
+		fmt.Fprintf(bw, " %7.2f", v)
+	}
+	fmt.Fprintf(bw, " | %6.2f\n", cohortSD)
+	return bw.Flush()
+}
@@ -0,0 +1,36 @@
+package indexcov
+
+import "testing"
+
+func TestParseRegion(t *testing.T) {
+	for _, c := range []struct {
+		region     string
+		chrom      string
+		start, end int
+	}{
+		{"chr1", "chr1", 0, -1},
+		{"chr1:1-100", "chr1", 0, 100},
+		{" 7:1,000,001-2,000,000 ", "7", 1000000, 2000000},
+		{"chr2:50", "chr2", 49, 50},
+		// only the last colon separates the chromosome.
+		{"HLA-A*01:01:01:01:1-10", "HLA-A*01:01:01:01", 0, 10},
+	} {
+		chrom, start, end, err := parseRegion(c.region)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.region, err)
+			continue
+		}
+		if chrom != c.chrom || start != c.start || end != c.end {
+			t.Errorf("%s: expected %s %d %d, got %s %d %d", c.region, c.chrom, c.start, c.end, chrom, start, end)
+		}
+		if c.end != -1 && regionString(chrom, start, end) != regionString(c.chrom, c.start, c.end) {
+			t.Errorf("%s: regionString did not round-trip", c.region)
+		}
+	}
+
+	for _, bad := range []string{"", "chr1:0-10", "chr1:x-10", "chr1:20-10", "chr1:10-y"} {
+		if _, _, _, err := parseRegion(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
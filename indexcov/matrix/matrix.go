@@ -0,0 +1,164 @@
+// Package matrix reads and writes a binary matrix of the scaled depth of each sample (column) in
+// each bin (row) of an indexcov run so that a few samples or a small region of a cohort of tens
+// of thousands of samples can be read in milliseconds rather than by scanning the bed.gz.
+//
+// The depths of each sample in each block of BlockRows bins of a chromosome are compressed
+// separately with a code that is fast to decode and an index of the offset of every block is
+// stored at the end of the file. The file is memory-mapped by Open so only the index entries and
+// blocks that are needed are read.
+//
+// The layout, with all integers little-endian, is:
+//
+//	magic      "GLMX" and the version as a byte followed by 3 zero bytes
+//	blocks     each the depths of one sample in up to BlockRows bins coded as in encode. The
+//	           blocks of a chromosome are in the order row-block, sample.
+//	header     JSON with the samples, chromosomes, bin width, grid and BlockRows
+//	index      a uint64 offset for each block and the end of the last block
+//	trailer    uint64 offsets of the header and the index, the uint64 length of the header
+//	           and "GLMX" with 4 zero bytes
+package matrix
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Version is the version of the format that is written.
+const Version = 1
+
+// BlockRows is the number of bins in each block. With bins of 16KB, a block is 16Mb.
+const BlockRows = 1024
+
+// Scale is the number of codes per unit of depth. Depths are stored to the nearest 0.001 and
+// those above MaxDepth are stored as MaxDepth.
+const Scale = 1000
+
+// MaxDepth is the largest depth that can be stored.
+const MaxDepth = float32(missing-1) / Scale
+
+// missing is the code of a NaN depth.
+const missing = math.MaxUint16
+
+var magic = [4]byte{'G', 'L', 'M', 'X'}
+
+const trailerLen = 32
+
+// ErrFormat is returned by Open for a file that is not a matrix.
+var ErrFormat = errors.New("matrix: not a goleft matrix file")
+
+// Chrom is a chromosome in a matrix.
+type Chrom struct {
+	Name string `json:"name"`
+	// Length is the length of the chromosome. The last bin ends at Length.
+	Length int `json:"length"`
+	// Bins is the number of rows of the chromosome.
+	Bins int `json:"bins"`
+	// Block is the index of the first block of the chromosome.
+	Block int `json:"block"`
+}
+
+// blocks returns the number of row-blocks of the chromosome.
+func (c *Chrom) blocks() int {
+	return (c.Bins + BlockRows - 1) / BlockRows
+}
+
+// header is the JSON header of a matrix.
+type header struct {
+	Version   int      `json:"version"`
+	Samples   []string `json:"samples"`
+	Chroms    []Chrom  `json:"chroms"`
+	Width     int      `json:"width"`
+	Grid      string   `json:"grid,omitempty"`
+	BlockRows int      `json:"block_rows"`
+}
+
+// encode appends the depths as codes to buf. Each code is written as the zig-zag varint of its
+// difference from the previous code so that most bins take a byte. As 0 would be a difference of
+// 0, it is followed by the varint of the length of a run of equal codes less 1 so that the flat
+// regions (e.g. of 0 depth) that make up much of a genome take a few bytes.
+func encode(depths []float32, buf []byte) []byte {
+	var prev uint16
+	for i := 0; i < len(depths); {
+		c := code(depths[i])
+		if c == prev {
+			n := 1
+			for i+n < len(depths) && code(depths[i+n]) == prev {
+				n++
+			}
+			buf = binary.AppendUvarint(append(buf, 0), uint64(n-1))
+			i += n
+			continue
+		}
+		delta := int64(c) - int64(prev)
+		buf = binary.AppendUvarint(buf, uint64((delta<<1)^(delta>>63)))
+		prev = c
+		i++
+	}
+	return buf
+}
+
+// decode is the inverse of encode. It writes the first len(dst) of the bins coded in buf to dst so
+// that a region at the start of a block does not need the whole block to be decoded. buf must be
+// a whole block of bins.
+func decode(buf []byte, dst []float32, bins int) error {
+	var prev int64
+	for i := 0; i < len(dst); {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errBlock
+		}
+		buf = buf[n:]
+		if v == 0 {
+			r, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(bins-i) <= r {
+				return errBlock
+			}
+			buf = buf[n:]
+			d := depth(uint16(prev))
+			for j := i; j <= i+int(r) && j < len(dst); j++ {
+				dst[j] = d
+			}
+			i += int(r) + 1
+			continue
+		}
+		prev += int64(v>>1) ^ -int64(v&1)
+		if prev < 0 || prev > missing {
+			return errBlock
+		}
+		dst[i] = depth(uint16(prev))
+		i++
+	}
+	if len(dst) == bins && len(buf) != 0 {
+		return errBlock
+	}
+	return nil
+}
+
+var errBlock = errors.New("matrix: corrupt block")
+
+func code(d float32) uint16 {
+	switch {
+	case d != d:
+		return missing
+	case d <= 0:
+		return 0
+	case d >= MaxDepth:
+		return missing - 1
+	}
+	return uint16(d*Scale + 0.5)
+}
+
+func depth(c uint16) float32 {
+	if c == missing {
+		return float32(math.NaN())
+	}
+	return float32(c) / Scale
+}
+
+func putTrailer(b []byte, headerOff, indexOff, headerLen uint64) {
+	binary.LittleEndian.PutUint64(b[0:], headerOff)
+	binary.LittleEndian.PutUint64(b[8:], indexOff)
+	binary.LittleEndian.PutUint64(b[16:], headerLen)
+	copy(b[24:], magic[:])
+}
@@ -0,0 +1,106 @@
+package matrix_test
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brentp/goleft/indexcov/matrix"
+)
+
+func write(t *testing.T, depths map[string][][]float32, lengths map[string]int, order []string, samples []string) string {
+	dir, err := ioutil.TempDir("", "matrix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "x.matrix")
+	w, err := matrix.Create(path, samples, 16384, "goleft-grid-v1:GRCh38:16384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range order {
+		if err := w.Add(c, lengths[c], depths[c]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRoundTrip(t *testing.T) {
+	n := 2*matrix.BlockRows + 17
+	a, b, c := make([]float32, n), make([]float32, n), make([]float32, 10)
+	for i := range a {
+		a[i] = float32(i%100) / 50
+		b[i] = 1
+	}
+	b[5] = float32(math.NaN())
+	b[6] = 1000
+	b[7] = -1
+	path := write(t, map[string][][]float32{"chr1": {a, b, c[:3]}, "chr2": {c, c, c}},
+		map[string]int{"chr1": n*16384 - 100, "chr2": 10 * 16384}, []string{"chr1", "chr2"}, []string{"s1", "s2", "s3"})
+
+	r, err := matrix.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.Samples) != 3 || r.Width != 16384 || r.Grid != "goleft-grid-v1:GRCh38:16384" || len(r.Chroms) != 2 {
+		t.Fatalf("unexpected header: %v %d %s %v", r.Samples, r.Width, r.Grid, r.Chroms)
+	}
+	if r.Column("s2") != 1 || r.Column("x") != -1 || r.Chrom("chr2") != 1 || r.Chrom("2") != -1 {
+		t.Error("unexpected column or chrom lookup")
+	}
+	got, err := r.Depths(0, 0, 0, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range a {
+		if math.Abs(float64(got[i]-a[i])) > 0.0006 {
+			t.Fatalf("bin %d: expected %.3f got %.3f", i, a[i], got[i])
+		}
+	}
+	// across a block boundary and past the end of the chromosome.
+	s, err := r.Slice(0, matrix.BlockRows-2, n+10, []int{2, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 2 || len(s[0]) != n-matrix.BlockRows+2 || s[0][0] != 0 {
+		t.Fatalf("unexpected slice: %d %d", len(s), len(s[0]))
+	}
+	s, err = r.Slice(0, 4, 9, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s[0][0] != 1 || !math.IsNaN(float64(s[0][1])) || s[0][2] != matrix.MaxDepth || s[0][3] != 0 || s[0][4] != 1 {
+		t.Errorf("unexpected values: %v", s[0])
+	}
+	all, err := r.Slice(1, 0, 10, nil)
+	if err != nil || len(all) != 3 || len(all[2]) != 10 {
+		t.Errorf("unexpected slice of all samples: %v %v", all, err)
+	}
+	if _, err := r.Slice(2, 0, 1, nil); err == nil {
+		t.Error("expected an error for a missing chromosome")
+	}
+	if _, err := r.Slice(0, 0, 1, []int{3}); err == nil {
+		t.Error("expected an error for a missing column")
+	}
+}
+
+func TestNotMatrix(t *testing.T) {
+	f, err := ioutil.TempFile("", "matrix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("#chrom\tstart\tend\ts1\nchr1\t0\t16384\t1\n")
+	f.Close()
+	if _, err := matrix.Open(f.Name()); err == nil {
+		t.Error("expected an error for a bed")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package matrix
+
+import "os"
+
+// mmap reads the file into memory where memory-mapping is not supported.
+func mmap(f *os.File, size int) ([]byte, func() error, error) {
+	return readAll(f)
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package matrix
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps size bytes of f read-only. The file is read into memory if it can not be mapped
+// (e.g. on some network filesystems).
+func mmap(f *os.File, size int) ([]byte, func() error, error) {
+	b, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return readAll(f)
+	}
+	return b, func() error { return syscall.Munmap(b) }, nil
+}
@@ -0,0 +1,240 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Reader reads the depths of any samples in any bins of a memory-mapped matrix. It is safe for
+// concurrent use until Close is called.
+type Reader struct {
+	// Samples are the names of the columns.
+	Samples []string
+	Chroms  []Chrom
+	// Width is the length of the bins.
+	Width int
+	// Grid is the name of the grid of the bins or "" if it is not known.
+	Grid string
+
+	data    []byte
+	unmap   func() error
+	index   []byte
+	columns map[string]int
+	chroms  map[string]int
+}
+
+// Open maps the matrix at path. Close must be called to release it.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < int64(len(magic)+4+trailerLen) {
+		return nil, fmt.Errorf("%w: %s is too short", ErrFormat, path)
+	}
+	data, unmap, err := mmap(f, int(fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+	r, err := newReader(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("%w (%s)", err, path)
+	}
+	r.unmap = unmap
+	return r, nil
+}
+
+// newReader reads the header and index of a matrix in data.
+func newReader(data []byte) (*Reader, error) {
+	if !bytes.Equal(data[:4], magic[:]) || !bytes.Equal(data[len(data)-8:len(data)-4], magic[:]) {
+		return nil, ErrFormat
+	}
+	if v := int(data[4]); v > Version {
+		return nil, fmt.Errorf("matrix: file is version %d. this version of goleft reads up to %d", v, Version)
+	}
+	t := data[len(data)-trailerLen:]
+	headerOff := binary.LittleEndian.Uint64(t[0:])
+	indexOff := binary.LittleEndian.Uint64(t[8:])
+	headerLen := binary.LittleEndian.Uint64(t[16:])
+	end := uint64(len(data) - trailerLen)
+	if headerOff > indexOff || headerOff+headerLen != indexOff || indexOff > end || (end-indexOff)%8 != 0 {
+		return nil, fmt.Errorf("%w: bad trailer", ErrFormat)
+	}
+	var h header
+	if err := json.Unmarshal(data[headerOff:indexOff], &h); err != nil {
+		return nil, fmt.Errorf("matrix: bad header: %s", err)
+	}
+	if h.BlockRows != BlockRows || h.Width <= 0 {
+		return nil, fmt.Errorf("matrix: unsupported block rows %d or width %d", h.BlockRows, h.Width)
+	}
+	r := &Reader{Samples: h.Samples, Chroms: h.Chroms, Width: h.Width, Grid: h.Grid, data: data,
+		index: data[indexOff:end], columns: make(map[string]int, len(h.Samples)), chroms: make(map[string]int, len(h.Chroms))}
+	blocks := 0
+	for i, c := range h.Chroms {
+		if c.Block != blocks {
+			return nil, fmt.Errorf("matrix: bad first block for %s", c.Name)
+		}
+		blocks += c.blocks() * len(h.Samples)
+		r.chroms[c.Name] = i
+	}
+	if len(r.index) != 8*(blocks+1) {
+		return nil, fmt.Errorf("matrix: expected %d blocks in index, got %d", blocks, len(r.index)/8-1)
+	}
+	for k, s := range h.Samples {
+		r.columns[s] = k
+	}
+	return r, nil
+}
+
+// Close unmaps the file. Slices returned by the Reader remain valid.
+func (r *Reader) Close() error {
+	if r.unmap == nil {
+		return nil
+	}
+	err := r.unmap()
+	r.unmap, r.data, r.index = nil, nil, nil
+	return err
+}
+
+// Column returns the column of the sample or -1 if it is not found.
+func (r *Reader) Column(sample string) int {
+	if k, ok := r.columns[sample]; ok {
+		return k
+	}
+	return -1
+}
+
+// Chrom returns the index of the chromosome in Chroms or -1 if it is not found. Names must match
+// exactly.
+func (r *Reader) Chrom(name string) int {
+	if i, ok := r.chroms[name]; ok {
+		return i
+	}
+	return -1
+}
+
+// Depths returns the depths of the sample in column col in the bins [from, to) of chromosome
+// chrom (an index in Chroms). to is limited to the bins of the chromosome.
+func (r *Reader) Depths(col, chrom, from, to int) ([]float32, error) {
+	d, err := r.Slice(chrom, from, to, []int{col})
+	if err != nil {
+		return nil, err
+	}
+	return d[0], nil
+}
+
+// parallelColumns is the number of columns above which Slice decodes them in parallel.
+const parallelColumns = 256
+
+// Slice returns the depths of each sample in cols in the bins [from, to) of chromosome chrom.
+// Only the blocks of those samples that overlap the bins are read. All samples are read if cols
+// is nil.
+func (r *Reader) Slice(chrom, from, to int, cols []int) ([][]float32, error) {
+	if r.data == nil {
+		return nil, fmt.Errorf("matrix: read from closed matrix")
+	}
+	if chrom < 0 || chrom >= len(r.Chroms) {
+		return nil, fmt.Errorf("matrix: no chromosome %d", chrom)
+	}
+	c := &r.Chroms[chrom]
+	if to > c.Bins {
+		to = c.Bins
+	}
+	if from < 0 || from > to {
+		return nil, fmt.Errorf("matrix: bad bins %d-%d of %s", from, to, c.Name)
+	}
+	if cols == nil {
+		cols = make([]int, len(r.Samples))
+		for k := range cols {
+			cols[k] = k
+		}
+	}
+	for _, k := range cols {
+		if k < 0 || k >= len(r.Samples) {
+			return nil, fmt.Errorf("matrix: no column %d", k)
+		}
+	}
+	out := make([][]float32, len(cols))
+	procs := runtime.GOMAXPROCS(0)
+	if len(cols) < parallelColumns || procs == 1 {
+		return out, r.slice(c, from, to, cols, out)
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, procs)
+	per := (len(cols) + procs - 1) / procs
+	for p := 0; p < procs && p*per < len(cols); p++ {
+		lo, hi := p*per, (p+1)*per
+		if hi > len(cols) {
+			hi = len(cols)
+		}
+		wg.Add(1)
+		go func(p, lo, hi int) {
+			defer wg.Done()
+			errs[p] = r.slice(c, from, to, cols[lo:hi], out[lo:hi])
+		}(p, lo, hi)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// slice reads the bins [from, to) of c for cols into out.
+func (r *Reader) slice(c *Chrom, from, to int, cols []int, out [][]float32) error {
+	block := make([]float32, BlockRows)
+	for j, k := range cols {
+		out[j] = make([]float32, 0, to-from)
+		for b := from / BlockRows; b*BlockRows < to; b++ {
+			lo, hi := b*BlockRows, (b+1)*BlockRows
+			if hi > c.Bins {
+				hi = c.Bins
+			}
+			s, e := lo, hi
+			if from > s {
+				s = from
+			}
+			if to < e {
+				e = to
+			}
+			if err := r.readBlock(c.Block+b*len(r.Samples)+k, block[:e-lo], hi-lo); err != nil {
+				return fmt.Errorf("%s in %s", err, c.Name)
+			}
+			out[j] = append(out[j], block[s-lo:e-lo]...)
+		}
+	}
+	return nil
+}
+
+// readBlock decodes the first len(dst) of the bins of block i into dst.
+func (r *Reader) readBlock(i int, dst []float32, bins int) error {
+	start := binary.LittleEndian.Uint64(r.index[8*i:])
+	end := binary.LittleEndian.Uint64(r.index[8*i+8:])
+	if start > end || end > uint64(len(r.data)) {
+		return fmt.Errorf("matrix: bad offsets for block %d", i)
+	}
+	if err := decode(r.data[start:end], dst, bins); err != nil {
+		return fmt.Errorf("%s %d", err, i)
+	}
+	return nil
+}
+
+// readAll is used to read the file where it can not be mapped.
+func readAll(f *os.File) ([]byte, func() error, error) {
+	b, err := ioutil.ReadAll(f)
+	return b, func() error { return nil }, err
+}
@@ -0,0 +1,145 @@
+package matrix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Writer writes a matrix one chromosome at a time.
+type Writer struct {
+	path string
+	f    *os.File
+	bw   *bufio.Writer
+	hdr  header
+	// offsets of the blocks written so far.
+	offsets []uint64
+	cw      countWriter
+	buf     []byte
+}
+
+// countWriter counts the bytes written to the file so the offset of each block is known.
+type countWriter struct {
+	w *bufio.Writer
+	n uint64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+// Create starts a matrix at path with a column for each sample and bins of width. grid is the name
+// of the grid of the bins (see the goleft grid package) or "" if it is not known. The matrix is
+// written to a temporary file that replaces path on Close so that readers that have mapped an
+// earlier matrix at path are not affected.
+func Create(path string, samples []string, width int, grid string) (*Writer, error) {
+	if width <= 0 {
+		return nil, fmt.Errorf("matrix: width must be positive, got %d", width)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, f: f, bw: bufio.NewWriterSize(f, 1<<20),
+		hdr: header{Version: Version, Samples: samples, Width: width, Grid: grid, BlockRows: BlockRows}}
+	w.cw.w = w.bw
+	if _, err := w.cw.Write(append(magic[:], Version, 0, 0, 0)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return w, nil
+}
+
+// Add writes the depths of each sample on chrom which has the given length. depths[k] is the
+// depths of sample k. Samples with fewer bins than the longest are padded with 0 as in the bed.gz.
+func (w *Writer) Add(chrom string, length int, depths [][]float32) error {
+	if len(depths) != len(w.hdr.Samples) {
+		return fmt.Errorf("matrix: expected depths for %d samples on %s, got %d", len(w.hdr.Samples), chrom, len(depths))
+	}
+	bins := 0
+	for _, d := range depths {
+		if len(d) > bins {
+			bins = len(d)
+		}
+	}
+	c := Chrom{Name: chrom, Length: length, Bins: bins, Block: len(w.offsets)}
+	if length < (bins-1)*w.hdr.Width {
+		return fmt.Errorf("matrix: %d bins of %d do not fit in %s of length %d", bins, w.hdr.Width, chrom, length)
+	}
+	row := make([]float32, BlockRows)
+	for b := 0; b < c.blocks(); b++ {
+		lo, hi := b*BlockRows, (b+1)*BlockRows
+		if hi > bins {
+			hi = bins
+		}
+		for _, d := range depths {
+			for i := lo; i < hi; i++ {
+				if i < len(d) {
+					row[i-lo] = d[i]
+				} else {
+					row[i-lo] = 0
+				}
+			}
+			w.buf = encode(row[:hi-lo], w.buf[:0])
+			w.offsets = append(w.offsets, w.cw.n)
+			if _, err := w.cw.Write(w.buf); err != nil {
+				return err
+			}
+		}
+	}
+	w.hdr.Chroms = append(w.hdr.Chroms, c)
+	return nil
+}
+
+// Close writes the header, index and trailer and moves the matrix to its path. The partial file
+// is removed if there is an error.
+func (w *Writer) Close() error {
+	err := w.finish()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile creates the file readable only by the user.
+		if err = os.Chmod(w.f.Name(), 0644); err == nil {
+			err = os.Rename(w.f.Name(), w.path)
+		}
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+	}
+	return err
+}
+
+func (w *Writer) finish() error {
+	hdr, err := json.Marshal(w.hdr)
+	if err != nil {
+		return err
+	}
+	headerOff := w.cw.n
+	if _, err := w.cw.Write(hdr); err != nil {
+		return err
+	}
+	indexOff := w.cw.n
+	b := make([]byte, 8*(len(w.offsets)+1))
+	for i, o := range w.offsets {
+		binary.LittleEndian.PutUint64(b[8*i:], o)
+	}
+	// the end of the last block.
+	binary.LittleEndian.PutUint64(b[8*len(w.offsets):], headerOff)
+	if _, err := w.cw.Write(b); err != nil {
+		return err
+	}
+	t := make([]byte, trailerLen)
+	putTrailer(t, headerOff, indexOff, uint64(len(hdr)))
+	if _, err := w.cw.Write(t); err != nil {
+		return err
+	}
+	return w.bw.Flush()
+}
@@ -0,0 +1,97 @@
+package indexcov
+
+import (
+	"sync"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft/indexcov/matrix"
+)
+
+// matrixSource reads the depths of the samples in a matrix written with --matrix. Unlike a bed.gz,
+// each sample and chromosome is read on its own so they can be requested in any order.
+type matrixSource struct {
+	r *matrix.Reader
+	// chroms gives the index in the matrix of each chromosome by its key in aliases.
+	chroms  map[string]int
+	aliases *chromAliases
+	// refs are the references used for the cohort and give the names for reference ids.
+	refs []*sam.Reference
+
+	mu sync.Mutex
+	// err is the first error from reading the depths.
+	err error
+}
+
+// scanMatrix opens the matrix at path and returns its chromosomes as references. Chromosomes are
+// matched with al.
+func scanMatrix(path string, al *chromAliases) (*matrixSource, []*sam.Reference, error) {
+	r, err := matrix.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	src := &matrixSource{r: r, chroms: make(map[string]int, len(r.Chroms)), aliases: al}
+	refs := make([]*sam.Reference, 0, len(r.Chroms))
+	for i, c := range r.Chroms {
+		src.chroms[al.key(c.Name)] = i
+		ref, err := sam.NewReference(c.Name, "", "", c.Length, nil, nil)
+		if err != nil {
+			r.Close()
+			return nil, nil, err
+		}
+		refs = append(refs, ref)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		r.Close()
+		return nil, nil, err
+	}
+	return src, h.Refs(), nil
+}
+
+func (s *matrixSource) names() []string               { return s.r.Samples }
+func (s *matrixSource) binWidth() int                 { return s.r.Width }
+func (s *matrixSource) setRefs(refs []*sam.Reference) { s.refs = refs }
+func (s *matrixSource) sample(col int) DepthSource    { return &matrixSample{src: s, col: col} }
+
+func (s *matrixSource) error() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// matrixSample is the DepthSource for a single sample column of a matrix.
+type matrixSample struct {
+	src *matrixSource
+	col int
+}
+
+// NormalizedDepth implements DepthSource. Errors are reported after the run with bedError.
+func (m *matrixSample) NormalizedDepth(refID int) []float32 {
+	s := m.src
+	if refID >= len(s.refs) {
+		return make([]float32, 0)
+	}
+	i, ok := s.chroms[s.aliases.key(s.refs[refID].Name())]
+	if !ok {
+		return make([]float32, 0)
+	}
+	d, err := s.r.Depths(m.col, i, 0, s.r.Chroms[i].Bins)
+	if err != nil {
+		s.mu.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+		return make([]float32, 0)
+	}
+	return d
+}
+
+// closeMatrices unmaps the matrices of the samples.
+func closeMatrices(idxs []DepthSource) {
+	for _, idx := range idxs {
+		if m, ok := idx.(*matrixSample); ok {
+			m.src.r.Close()
+		}
+	}
+}
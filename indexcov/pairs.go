@@ -0,0 +1,304 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// samples are processed in blocks of this many so that the rows of 2 blocks stay in cache.
+const pairBlock = 64
+
+// columns are summed in chunks of this many so that the products of uint8 values fit in a uint32.
+const pairChunk = 8192
+
+// the heatmap is only drawn for cohorts up to this size as it needs the full matrix of correlations.
+const maxHeatmapSamples = 2000
+
+// pair is the correlation of the depths of 2 samples.
+type pair struct {
+	a, b int
+	r    float64
+}
+
+// rowStats returns the mean and standard deviation of each row.
+func rowStats(x [][]uint8) ([]float64, []float64) {
+	means, sds := make([]float64, len(x)), make([]float64, len(x))
+	for i, row := range x {
+		var s, ss uint64
+		for _, v := range row {
+			s += uint64(v)
+			ss += uint64(v) * uint64(v)
+		}
+		m := float64(len(row))
+		means[i] = float64(s) / m
+		sds[i] = math.Sqrt(math.Max(0, float64(ss)/m-means[i]*means[i]))
+	}
+	return means, sds
+}
+
+// correlations calls fn with the pearson correlation of each pair of rows in x. Rows must be of the
+// same length. Pairs of blocks of rows are processed in parallel and fn is called with a lock held.
+func correlations(x [][]uint8, processes int, fn func(a, b int, r float64)) {
+	n := len(x)
+	if n < 2 || len(x[0]) == 0 {
+		return
+	}
+	m := len(x[0])
+	means, sds := rowStats(x)
+	type job struct{ a, b int }
+	var jobs []job
+	for a := 0; a < n; a += pairBlock {
+		for b := a; b < n; b += pairBlock {
+			jobs = append(jobs, job{a, b})
+		}
+	}
+	var mu sync.Mutex
+	parallel(len(jobs), processes, func(k int) {
+		a0, b0 := jobs[k].a, jobs[k].b
+		a1, b1 := imin(a0+pairBlock, n), imin(b0+pairBlock, n)
+		dots := make([]uint64, pairBlock*pairBlock)
+		for c0 := 0; c0 < m; c0 += pairChunk {
+			c1 := imin(c0+pairChunk, m)
+			for i := a0; i < a1; i++ {
+				xi := x[i][c0:c1]
+				for j := imax(b0, i+1); j < b1; j++ {
+					xj := x[j][c0:c1]
+					var s uint32
+					for t, v := range xi {
+						s += uint32(v) * uint32(xj[t])
+					}
+					dots[(i-a0)*pairBlock+j-b0] += uint64(s)
+				}
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for i := a0; i < a1; i++ {
+			for j := imax(b0, i+1); j < b1; j++ {
+				cov := float64(dots[(i-a0)*pairBlock+j-b0])/float64(m) - means[i]*means[j]
+				fn(i, j, cov/(sds[i]*sds[j]))
+			}
+		}
+	})
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// pairScreen holds the pairs of samples to report and, for small cohorts, all correlations.
+type pairScreen struct {
+	// best is the most correlated other sample for each sample.
+	best []pair
+	// dups are the pairs with a correlation of at least minR.
+	dups []pair
+	// matrix is only set for cohorts of up to maxHeatmapSamples.
+	matrix [][]float32
+}
+
+// screenPairs finds near-identical samples from the correlation of their depths on the autosomes.
+func screenPairs(pca8 [][]uint8, minR float64, processes int) *pairScreen {
+	n := len(pca8)
+	ps := &pairScreen{best: make([]pair, n)}
+	for i := range ps.best {
+		ps.best[i] = pair{a: i, b: -1, r: math.Inf(-1)}
+	}
+	if n <= maxHeatmapSamples {
+		ps.matrix = make([][]float32, n)
+		for i := range ps.matrix {
+			ps.matrix[i] = make([]float32, n)
+			ps.matrix[i][i] = 1
+		}
+	}
+	correlations(pca8, processes, func(a, b int, r float64) {
+		if math.IsNaN(r) {
+			return
+		}
+		if r > ps.best[a].r {
+			ps.best[a] = pair{a: a, b: b, r: r}
+		}
+		if r > ps.best[b].r {
+			ps.best[b] = pair{a: b, b: a, r: r}
+		}
+		if r >= minR {
+			ps.dups = append(ps.dups, pair{a: a, b: b, r: r})
+		}
+		if ps.matrix != nil {
+			ps.matrix[a][b], ps.matrix[b][a] = float32(r), float32(r)
+		}
+	})
+	return ps
+}
+
+// write writes the duplicate pairs and the most correlated sample of each sample, sorted by
+// correlation, to path.
+func (ps *pairScreen) write(path string, samples []string, minR float64) error {
+	seen := make(map[[2]int]bool)
+	var rows []pair
+	for _, p := range append(append([]pair{}, ps.dups...), ps.best...) {
+		if p.b == -1 {
+			continue
+		}
+		key := [2]int{imin(p.a, p.b), imax(p.a, p.b)}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, p)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].r > rows[j].r })
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample_a\tsample_b\tr\tduplicate")
+	for _, p := range rows {
+		dup := "no"
+		if p.r >= minR {
+			dup = "yes"
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%.4f\t%s\n", samples[p.a], samples[p.b], p.r, dup); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// clusterOrder orders the samples by average-linkage clustering of 1 - r so that similar samples are
+// adjacent in the heatmap. It uses the nearest-neighbor chain algorithm which is O(n^2).
+func clusterOrder(matrix [][]float32) []int {
+	n := len(matrix)
+	d := make([][]float64, n)
+	members := make([][]int, n)
+	active := make([]bool, n)
+	for i := range d {
+		d[i] = make([]float64, n)
+		for j, r := range matrix[i] {
+			d[i][j] = 1 - float64(r)
+		}
+		members[i] = []int{i}
+		active[i] = true
+	}
+	remaining := n
+	var chain []int
+	for remaining > 1 {
+		if len(chain) == 0 {
+			for i := range active {
+				if active[i] {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+		a := chain[len(chain)-1]
+		b, best := -1, math.Inf(1)
+		if len(chain) > 1 {
+			// prefer the previous element on ties so that the chain terminates.
+			b, best = chain[len(chain)-2], d[a][chain[len(chain)-2]]
+		}
+		for j := range d[a] {
+			if active[j] && j != a && d[a][j] < best {
+				b, best = j, d[a][j]
+			}
+		}
+		if len(chain) < 2 || b != chain[len(chain)-2] {
+			chain = append(chain, b)
+			continue
+		}
+		// merge b into a using the average linkage.
+		chain = chain[:len(chain)-2]
+		na, nb := float64(len(members[a])), float64(len(members[b]))
+		for k := range d {
+			if active[k] && k != a && k != b {
+				v := (na*d[a][k] + nb*d[b][k]) / (na + nb)
+				d[a][k], d[k][a] = v, v
+			}
+		}
+		members[a] = append(members[a], members[b]...)
+		members[b], active[b] = nil, false
+		remaining--
+	}
+	for i := range active {
+		if active[i] {
+			return members[i]
+		}
+	}
+	return nil
+}
+
+// heatColor maps v in [lo, 1] from blue through white to red.
+func heatColor(v, lo float64) color.RGBA {
+	t := (v - lo) / (1 - lo)
+	if math.IsNaN(t) || t < 0 {
+		t = 0
+	} else if t > 1 {
+		t = 1
+	}
+	if t < 0.5 {
+		c := uint8(255 * t * 2)
+		return color.RGBA{c, c, 255, 255}
+	}
+	c := uint8(255 * (1 - t) * 2)
+	return color.RGBA{255, c, c, 255}
+}
+
+// writeHeatmap draws the correlation matrix in the given sample order to a png. The color scale
+// goes from the 5th percentile of the correlations (blue) to 1 (red).
+func (ps *pairScreen) writeHeatmap(path string, order []int) error {
+	n := len(order)
+	vals := make([]float64, 0, n*(n-1)/2)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			vals = append(vals, float64(ps.matrix[i][j]))
+		}
+	}
+	lo := 0.0
+	if len(vals) > 0 {
+		sort.Float64s(vals)
+		lo = vals[int(0.05*float64(len(vals)))]
+	}
+	px := imax(1, 800/imax(n, 1))
+	img := image.NewRGBA(image.Rect(0, 0, n*px, n*px))
+	for i, a := range order {
+		for j, b := range order {
+			c := heatColor(float64(ps.matrix[a][b]), lo)
+			for y := i * px; y < (i+1)*px; y++ {
+				for x := j * px; x < (j+1)*px; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,123 @@
+package indexcov
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pearson is the correlation of a and b computed directly.
+func pearson(a, b []uint8) float64 {
+	var ma, mb float64
+	for i := range a {
+		ma += float64(a[i])
+		mb += float64(b[i])
+	}
+	ma /= float64(len(a))
+	mb /= float64(len(b))
+	var sab, saa, sbb float64
+	for i := range a {
+		da, db := float64(a[i])-ma, float64(b[i])-mb
+		sab += da * db
+		saa += da * da
+		sbb += db * db
+	}
+	return sab / math.Sqrt(saa*sbb)
+}
+
+// randomDepths returns n samples of independent depths on m tiles around 100.
+func randomDepths(n, m int, rng *rand.Rand) [][]uint8 {
+	x := make([][]uint8, n)
+	for i := range x {
+		x[i] = make([]uint8, m)
+		for j := range x[i] {
+			x[i][j] = uint8(100 + rng.Intn(40) - 20)
+		}
+	}
+	return x
+}
+
+func TestCorrelations(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	// more samples than a block and more tiles than a chunk.
+	x := randomDepths(pairBlock+6, pairChunk+100, rng)
+	for j := range x[3] {
+		x[3][j] = x[1][j]/2 + x[3][j]/2
+	}
+	seen := make(map[[2]int]bool)
+	correlations(x, 3, func(a, b int, r float64) {
+		if a >= b || seen[[2]int{a, b}] {
+			t.Errorf("unexpected pair %d, %d", a, b)
+		}
+		seen[[2]int{a, b}] = true
+		if exp := pearson(x[a], x[b]); math.Abs(r-exp) > 1e-9 {
+			t.Errorf("%d, %d: expected r=%.6f, got %.6f", a, b, exp, r)
+		}
+	})
+	if n := len(x); len(seen) != n*(n-1)/2 {
+		t.Errorf("expected %d pairs, got %d", n*(n-1)/2, len(seen))
+	}
+}
+
+func TestScreenPairs(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	x := randomDepths(8, 2000, rng)
+	// sample 5 is sample 2 sequenced again: the same depths with a little noise.
+	for j := range x[5] {
+		x[5][j] = x[2][j] + uint8(rng.Intn(2))
+	}
+	ps := screenPairs(x, 0.95, 2)
+	if len(ps.dups) != 1 || ps.dups[0].a != 2 || ps.dups[0].b != 5 {
+		t.Fatalf("expected samples 2 and 5 as the only duplicates, got %v", ps.dups)
+	}
+	if ps.best[2].b != 5 || ps.best[5].b != 2 {
+		t.Errorf("expected 2 and 5 to be each other's best match, got %v and %v", ps.best[2], ps.best[5])
+	}
+	for i, p := range ps.best {
+		if i != 2 && i != 5 && p.r > 0.2 {
+			t.Errorf("expected sample %d to have no close match, got %v", i, p)
+		}
+	}
+
+	order := clusterOrder(ps.matrix)
+	if len(order) != 8 {
+		t.Fatalf("expected all 8 samples in the order, got %v", order)
+	}
+	for k, i := range order {
+		if i == 2 && !(k > 0 && order[k-1] == 5 || k < 7 && order[k+1] == 5) {
+			t.Errorf("expected the duplicates to be adjacent in the heatmap, got %v", order)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "indexcov-pairs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pairs.tsv")
+	samples := make([]string, 8)
+	for i := range samples {
+		samples[i] = fmt.Sprintf("s%d", i)
+	}
+	if err := ps.write(path, samples, 0.95); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if !strings.HasPrefix(lines[1], "s2\ts5\t") || !strings.HasSuffix(lines[1], "\tyes") {
+		t.Errorf("expected the duplicate pair first, got %q", lines[1])
+	}
+	for _, l := range lines[2:] {
+		if !strings.HasSuffix(l, "\tno") {
+			t.Errorf("expected only 1 duplicate, got %q", l)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package indexcov
+
+import (
+	"log"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft/genomes"
+)
+
+// parTiles returns the tiles of the sex chromosomes that are in pseudo-autosomal regions. These
+// have 2 copies in both sexes and so are left out of the copy-number estimates used to infer sex.
+// If path is "none", nothing is masked. If it is a bed file, its regions are used. Otherwise the
+// regions of the genome g are used or, if g is nil, those of a registered genome (GRCh37 or GRCh38)
+// when the length of a sex chromosome matches that build.
+func parTiles(path string, refs []*sam.Reference, sex []string, g *genomes.Genome, al *chromAliases) (*excludedTiles, error) {
+	if path == "none" {
+		return nil, nil
+	}
+	if path != "" {
+		return readExcluded(path, al)
+	}
+	var pars *excludedTiles
+	for _, ref := range refs {
+		if !sameChrom(al, sex, ref.Name()) {
+			continue
+		}
+		build, c, ok := genomeChrom(g, ref, al)
+		if !ok {
+			continue
+		}
+		regions := build.Regions(build.PARs, c.Name)
+		if len(regions) == 0 {
+			continue
+		}
+		if pars == nil {
+			pars = newExcludedTiles(al)
+		}
+		for _, r := range regions {
+			pars.add(ref.Name(), r.Start, r.End)
+		}
+		log.Printf("indexcov: masking %s pseudo-autosomal regions of %s for sex inference", build.Name, ref.Name())
+	}
+	return pars, nil
+}
+
+// withoutPAR returns the depths of each sample with tiles in the mask removed.
+func withoutPAR(depths [][]float32, mask []bool) [][]float32 {
+	if mask == nil {
+		return depths
+	}
+	kept := make([][]float32, len(depths))
+	for k, d := range depths {
+		kept[k] = unmasked(d, mask)
+	}
+	return kept
+}
@@ -0,0 +1,287 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// A ped has no standard column for monozygotic twins or for the same individual sequenced twice so
+// they are declared in an extra column with one of these names in the header. Its value is the
+// sample id of the twin or duplicate.
+var twinColumns = map[string]bool{"twin": true, "mz_twin": true, "duplicate": true, "duplicate_of": true}
+
+// maxPedFamilies is the most families shown in the index.html. Families with a discordant pair are
+// shown first.
+const maxPedFamilies = 50
+
+// the relation of a pair of samples in the ped.
+const (
+	relDuplicate = "duplicate"
+	relParent    = "parent-child"
+	relSibling   = "sibling"
+	relFamily    = "family"
+	relUnrelated = "unrelated"
+)
+
+type pedPerson struct {
+	family, father, mother, twin string
+}
+
+// pedMissing returns true for the values used for a missing id in a ped.
+func pedMissing(v string) bool {
+	return v == "" || v == "0" || v == "." || v == "-9"
+}
+
+// readPedFamilies reads the family, parents and, if there is a twin column, the declared twin or
+// duplicate of each sample in a ped. Samples are named by their pseudonyms in ps.
+func readPedFamilies(path string, ps pseudonyms) (map[string]*pedPerson, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	people := make(map[string]*pedPerson)
+	twinCol := -1
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			if line[0] == '#' {
+				for j, h := range strings.Fields(strings.TrimLeft(line, "#")) {
+					if twinColumns[strings.ToLower(h)] {
+						twinCol = j
+					}
+				}
+			} else {
+				toks := strings.Fields(line)
+				if len(toks) < 5 {
+					return nil, fmt.Errorf("indexcov: expected at least 5 columns at line %d of %s", i, path)
+				}
+				p := &pedPerson{family: toks[0], father: ps.name(toks[2]), mother: ps.name(toks[3])}
+				if twinCol != -1 && twinCol < len(toks) && !pedMissing(toks[twinCol]) {
+					p.twin = ps.name(toks[twinCol])
+				}
+				people[ps.name(toks[1])] = p
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return people, nil
+}
+
+// relation returns the declared relation of samples a and b.
+func relation(a, b string, pa, pb *pedPerson) string {
+	switch {
+	case pa.twin == b || pb.twin == a:
+		return relDuplicate
+	case pa.family != pb.family:
+		return relUnrelated
+	case pa.father == b || pa.mother == b || pb.father == a || pb.mother == a:
+		return relParent
+	case !pedMissing(pa.father) && !pedMissing(pa.mother) && pa.father == pb.father && pa.mother == pb.mother:
+		return relSibling
+	}
+	return relFamily
+}
+
+// pearsonU8 returns the correlation of x and y which must be of the same length.
+func pearsonU8(x, y []uint8) float64 {
+	var sx, sy, sxx, syy, sxy float64
+	for i, v := range x {
+		a, b := float64(v), float64(y[i])
+		sx += a
+		sy += b
+		sxx += a * a
+		syy += b * b
+		sxy += a * b
+	}
+	n := float64(len(x))
+	return (sxy - sx*sy/n) / math.Sqrt((sxx-sx*sx/n)*(syy-sy*sy/n))
+}
+
+// pedPair is a pair of samples with a declared relation (or unrelated samples that are near-identical).
+type pedPair struct {
+	a, b int
+	rel  string
+	r    float64
+}
+
+// ok is false for declared duplicates that differ and for other pairs that are near-identical.
+func (p pedPair) ok(minR float64) bool {
+	if p.rel == relDuplicate {
+		return p.r >= minR
+	}
+	return math.IsNaN(p.r) || p.r < minR
+}
+
+// pedPairs returns the correlation of the depths of each pair of samples in the same family and of
+// each declared duplicate pair. With the pair screen of --pairs, near-identical samples in different
+// families are also returned.
+func pedPairs(people map[string]*pedPerson, samples []string, pca8 [][]uint8, ps *pairScreen) []pedPair {
+	idx := make(map[string]int, len(samples))
+	families := make(map[string][]int)
+	for k, s := range samples {
+		idx[s] = k
+		if p, ok := people[s]; ok {
+			families[p.family] = append(families[p.family], k)
+		}
+	}
+	var pairs []pedPair
+	seen := make(map[[2]int]bool)
+	add := func(a, b int, r float64) {
+		if a > b {
+			a, b = b, a
+		}
+		if a == b || seen[[2]int{a, b}] {
+			return
+		}
+		seen[[2]int{a, b}] = true
+		if math.IsNaN(r) {
+			r = pearsonU8(pca8[a], pca8[b])
+		}
+		pairs = append(pairs, pedPair{a: a, b: b, rel: relation(samples[a], samples[b], people[samples[a]], people[samples[b]]), r: r})
+	}
+	for _, ks := range families {
+		for i, a := range ks {
+			for _, b := range ks[i+1:] {
+				add(a, b, math.NaN())
+			}
+		}
+	}
+	for s, p := range people {
+		a, aok := idx[s]
+		if !aok || p.twin == "" {
+			continue
+		}
+		b, bok := idx[p.twin]
+		if _, inPed := people[p.twin]; bok && inPed {
+			add(a, b, math.NaN())
+		} else {
+			log.Printf("indexcov: WARNING: %s is the declared twin or duplicate of %s but is missing from the run or the ped", p.twin, s)
+		}
+	}
+	if ps != nil {
+		for _, d := range ps.dups {
+			_, aok := people[samples[d.a]]
+			_, bok := people[samples[d.b]]
+			if aok && bok {
+				add(d.a, d.b, d.r)
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].a != pairs[j].a {
+			return pairs[i].a < pairs[j].a
+		}
+		return pairs[i].b < pairs[j].b
+	})
+	return pairs
+}
+
+// writePedPairs writes the pairs to path and logs those that disagree with the ped.
+func writePedPairs(path string, pairs []pedPair, samples []string, people map[string]*pedPerson, minR float64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample_a\tsample_b\tfamily_a\tfamily_b\trelation\tr\texpected\tstatus")
+	for _, p := range pairs {
+		expected, status := "distinct", "ok"
+		if p.rel == relDuplicate {
+			expected = "identical"
+		}
+		a, b := samples[p.a], samples[p.b]
+		if !p.ok(minR) {
+			status = "FAIL"
+			log.Printf("indexcov: WARNING: %s and %s are declared %s in the ped but have a correlation of %.4f", a, b, p.rel, p.r)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.4f\t%s\t%s\n", a, b, people[a].family, people[b].family, p.rel, p.r, expected, status)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pedCell is a cell of the matrix of a family in the index.html.
+type pedCell struct {
+	R     string
+	Rel   string
+	Color string
+}
+
+// pedFamily is the matrix of the correlations of the samples in a family that is shown in the index.html.
+type pedFamily struct {
+	Family  string
+	Samples []string
+	Rows    [][]pedCell
+	Failed  bool
+}
+
+// pedFamilies returns the matrix of each family with at least 2 samples. Cells of pairs that
+// disagree with the ped are red. Pairs across families are not shown.
+func pedFamilies(pairs []pedPair, samples []string, people map[string]*pedPerson, minR float64) []pedFamily {
+	byFamily := make(map[string][]pedPair)
+	for _, p := range pairs {
+		if p.rel != relUnrelated {
+			fam := people[samples[p.a]].family
+			byFamily[fam] = append(byFamily[fam], p)
+		}
+	}
+	var out []pedFamily
+	for fam, ps := range byFamily {
+		col := make(map[int]int)
+		var members []int
+		for _, p := range ps {
+			for _, k := range []int{p.a, p.b} {
+				if _, ok := col[k]; !ok {
+					col[k] = len(members)
+					members = append(members, k)
+				}
+			}
+		}
+		pf := pedFamily{Family: fam, Rows: make([][]pedCell, len(members))}
+		for i, k := range members {
+			pf.Samples = append(pf.Samples, samples[k])
+			pf.Rows[i] = make([]pedCell, len(members))
+			pf.Rows[i][i] = pedCell{R: "1", Rel: "self", Color: "#eee"}
+		}
+		for _, p := range ps {
+			c := pedCell{R: fmt.Sprintf("%.3f", p.r), Rel: p.rel, Color: "#fff"}
+			if p.rel == relDuplicate {
+				c.Color = "#ddf"
+			}
+			if !p.ok(minR) {
+				c.Color, pf.Failed = "#f99", true
+			}
+			i, j := col[p.a], col[p.b]
+			pf.Rows[i][j], pf.Rows[j][i] = c, c
+		}
+		out = append(out, pf)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Failed != out[j].Failed {
+			return out[i].Failed
+		}
+		return out[i].Family < out[j].Family
+	})
+	if len(out) > maxPedFamilies {
+		out = out[:maxPedFamilies]
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package indexcov
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelation(t *testing.T) {
+	people := map[string]*pedPerson{
+		"kid":   {family: "f1", father: "dad", mother: "mom", twin: "kid2"},
+		"kid2":  {family: "f1", father: "dad", mother: "mom"},
+		"sib":   {family: "f1", father: "dad", mother: "mom"},
+		"dad":   {family: "f1", father: "0", mother: "0"},
+		"mom":   {family: "f1", father: "0", mother: "0"},
+		"aunt":  {family: "f1", father: "0", mother: "0"},
+		"other": {family: "f2", father: "0", mother: "0", twin: "mom"},
+	}
+	for _, c := range []struct {
+		a, b, exp string
+	}{
+		{"kid", "kid2", relDuplicate},
+		{"kid2", "kid", relDuplicate},
+		{"mom", "other", relDuplicate},
+		{"kid", "dad", relParent},
+		{"mom", "sib", relParent},
+		{"kid2", "sib", relSibling},
+		// parents with missing parents of their own are not siblings.
+		{"dad", "mom", relFamily},
+		{"aunt", "kid", relFamily},
+		{"dad", "other", relUnrelated},
+	} {
+		if got := relation(c.a, c.b, people[c.a], people[c.b]); got != c.exp {
+			t.Errorf("%s, %s: expected %s, got %s", c.a, c.b, c.exp, got)
+		}
+	}
+}
+
+func TestPedPairs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-pedpairs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ped := filepath.Join(dir, "fam.ped")
+	content := "#family_id\tsample_id\tpaternal_id\tmaternal_id\tsex\tphenotype\ttwin\n" +
+		"f1\tkid\tdad\tmom\t1\t-9\tkid_rep\n" +
+		"f1\tdad\t0\t0\t1\t-9\tother\n" +
+		"f1\tmom\t0\t0\t2\t-9\t.\n" +
+		"f1\tkid_rep\tdad\tmom\t1\t-9\t.\n" +
+		"f2\tother\t0\t0\t1\t-9\t.\n" +
+		"f3\tswap\t0\t0\t2\t-9\t.\n"
+	if err := ioutil.WriteFile(ped, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	people, err := readPedFamilies(ped, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 6 || people["kid"].twin != "kid_rep" || people["mom"].twin != "" {
+		t.Fatalf("unexpected ped: %v", people)
+	}
+
+	samples := []string{"kid", "dad", "mom", "kid_rep", "other", "swap"}
+	x := randomDepths(len(samples), 3000, rand.New(rand.NewSource(3)))
+	// kid_rep is the declared duplicate of kid and swap, in another family, is really mom. dad is
+	// declared as the duplicate of other but is not.
+	copy(x[3], x[0])
+	copy(x[5], x[2])
+	ps := screenPairs(x, 0.95, 1)
+	pairs := pedPairs(people, samples, x, ps)
+
+	exp := map[[2]string]struct {
+		rel string
+		ok  bool
+	}{
+		{"kid", "dad"}:     {relParent, true},
+		{"kid", "mom"}:     {relParent, true},
+		{"kid", "kid_rep"}: {relDuplicate, true},
+		{"dad", "mom"}:     {relFamily, true},
+		{"dad", "kid_rep"}: {relParent, true},
+		{"mom", "kid_rep"}: {relParent, true},
+		{"dad", "other"}:   {relDuplicate, false},
+		{"mom", "swap"}:    {relUnrelated, false},
+	}
+	if len(pairs) != len(exp) {
+		t.Errorf("expected %d pairs, got %d: %v", len(exp), len(pairs), pairs)
+	}
+	for _, p := range pairs {
+		key := [2]string{samples[p.a], samples[p.b]}
+		e, ok := exp[key]
+		if !ok {
+			t.Errorf("unexpected pair %v", key)
+			continue
+		}
+		if p.rel != e.rel || p.ok(0.95) != e.ok {
+			t.Errorf("%v: expected %s (ok: %v), got %s (ok: %v) with r=%.3f", key, e.rel, e.ok, p.rel, p.ok(0.95), p.r)
+		}
+	}
+
+	out := filepath.Join(dir, "ped-pairs.tsv")
+	if err := writePedPairs(out, pairs, samples, people, 0.95); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []string
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n")[1:] {
+		if toks := strings.Split(l, "\t"); toks[7] == "FAIL" {
+			failed = append(failed, toks[0]+","+toks[1])
+		}
+	}
+	if strings.Join(failed, " ") != "dad,other mom,swap" {
+		t.Errorf("expected dad,other and mom,swap to fail, got %v", failed)
+	}
+
+	// the unrelated pair is only in the tsv.
+	fams := pedFamilies(pairs, samples, people, 0.95)
+	if len(fams) != 1 || fams[0].Family != "f1" || !fams[0].Failed {
+		t.Fatalf("expected f1 to fail for the duplicate of dad, got %+v", fams)
+	}
+	if len(fams[0].Samples) != 5 {
+		t.Errorf("expected the 4 samples of f1 and the declared duplicate, got %v", fams[0].Samples)
+	}
+}
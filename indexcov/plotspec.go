@@ -0,0 +1,179 @@
+package indexcov
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	chartjs "github.com/brentp/go-chartjs"
+	"github.com/brentp/go-chartjs/types"
+	"github.com/brentp/goleft/plots"
+)
+
+// plotSpec describes a user-requested scatter plot of 2 sample columns
+// with an optional (categorical) column used to color the points.
+type plotSpec struct {
+	x, y, color string
+}
+
+// parsePlotSpec parses strings like: x=bins.out,y=PC1,color=batch
+func parsePlotSpec(spec string) (plotSpec, error) {
+	var p plotSpec
+	for _, kv := range strings.Split(spec, ",") {
+		toks := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(toks) != 2 || toks[1] == "" {
+			return p, fmt.Errorf("expected key=value, got: %q", kv)
+		}
+		switch toks[0] {
+		case "x":
+			p.x = toks[1]
+		case "y":
+			p.y = toks[1]
+		case "color":
+			p.color = toks[1]
+		default:
+			return p, fmt.Errorf("unknown key %q. must be one of x, y, color", toks[0])
+		}
+	}
+	if p.x == "" || p.y == "" {
+		return p, fmt.Errorf("both x and y are required")
+	}
+	return p, nil
+}
+
+func (p plotSpec) name() string {
+	n := p.x + "-" + p.y
+	if p.color != "" {
+		n += "-" + p.color
+	}
+	return strings.Replace(n, string(filepath.Separator), "_", -1)
+}
+
+// plotColumns creates a scatter plot of the columns in p with a dataset for each
+// distinct value in the color column. If outsideRef is not nil, samples outside of
+// the reference ranges are drawn in a separate dataset for each group.
+func plotColumns(t *sampleTable, p plotSpec, outsideRef []bool) (*chartjs.Chart, string, error) {
+	xs, err := t.floats(p.x)
+	if err != nil {
+		return nil, "", err
+	}
+	ys, err := t.floats(p.y)
+	if err != nil {
+		return nil, "", err
+	}
+	groups := make([]string, len(t.samples))
+	if p.color != "" {
+		if groups, err = t.strings(p.color); err != nil {
+			return nil, "", err
+		}
+	}
+	uniq := make(map[string]bool)
+	for _, g := range groups {
+		uniq[g] = true
+	}
+	names := make([]string, 0, len(uniq))
+	for g := range uniq {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+
+	chart := chartjs.Chart{Label: p.name()}
+	xa, err := chart.AddXAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Bottom,
+		ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: p.x, Display: chartjs.True}})
+	if err != nil {
+		return nil, "", err
+	}
+	ya, err := chart.AddYAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Left,
+		ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: p.y, Display: chartjs.True}})
+	if err != nil {
+		return nil, "", err
+	}
+
+	jssamples := make([][]string, 0, len(names))
+	for k, g := range names {
+		c := plots.Color(k)
+		label := "samples"
+		if p.color != "" {
+			label = fmt.Sprintf("%s: %s", p.color, g)
+		}
+		for _, outside := range []bool{false, true} {
+			vals := &plots.XYs{X: make([]float64, 0, len(xs)), Y: make([]float64, 0, len(xs))}
+			gsamples := make([]string, 0, len(xs))
+			for i, grp := range groups {
+				if grp != g || math.IsNaN(xs[i]) || math.IsNaN(ys[i]) || (outsideRef != nil && outsideRef[i] != outside) {
+					continue
+				}
+				vals.X = append(vals.X, xs[i])
+				vals.Y = append(vals.Y, ys[i])
+				gsamples = append(gsamples, t.samples[i])
+			}
+			if outside && len(gsamples) == 0 {
+				continue
+			}
+			jssamples = append(jssamples, gsamples)
+			dataset := chartjs.Dataset{Data: vals, Label: label, Fill: chartjs.False, PointRadius: 5, BorderWidth: 0,
+				BorderColor: &types.RGBA{R: 90, G: 90, B: 90, A: 150}, PointBackgroundColor: c, BackgroundColor: c,
+				ShowLine: chartjs.False, PointHitRadius: 6}
+			if outside {
+				dataset.Label += " (outside reference range)"
+				dataset.PointStyle = "triangle"
+				dataset.PointRadius = 7
+				dataset.BorderWidth = 2
+				dataset.BorderColor = &types.RGBA{R: 220, G: 20, B: 20, A: 255}
+			}
+			dataset.XAxisID = xa
+			dataset.YAxisID = ya
+			chart.AddDataset(dataset)
+		}
+	}
+	sjson, err := json.Marshal(jssamples)
+	if err != nil {
+		return nil, "", err
+	}
+	jsfunc := fmt.Sprintf(`
+	chart.options.hover.mode = 'index'
+	chart.options.tooltips.callbacks.title = function(tts, data) {
+		var names = %s
+		var out = []
+		tts.forEach(function(ti) {
+			out.push(names[ti.datasetIndex][ti.index])
+		})
+		return out.join(",")
+	}`, sjson)
+	chart.Options.Responsive = chartjs.False
+	chart.Options.Legend = &chartjs.Legend{Display: chartjs.False}
+	if len(jssamples) > 1 {
+		chart.Options.Legend.Display = chartjs.True
+	}
+	chart.Options.Tooltip = &chartjs.Tooltip{Mode: "nearest"}
+	return &chart, jsfunc, nil
+}
+
+// writeColumnPlot parses the --plot spec, draws the chart and returns the
+// name of the html file (relative to the output directory).
+func writeColumnPlot(t *sampleTable, spec string, base string, outsideRef []bool) (string, error) {
+	p, err := parsePlotSpec(spec)
+	if err != nil {
+		return "", err
+	}
+	chart, js, err := plotColumns(t, p, outsideRef)
+	if err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("%s-plot-%s.html", base, p.name())
+	wtr, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer wtr.Close()
+	err = withXFormat("%.2f", func() error {
+		return chartjs.SaveCharts(wtr, map[string]interface{}{"template": singleChartTemplate, "title": p.name(),
+			"chart": chart, "chartjs": template.JS(js)}, chartjs.Chart{})
+	})
+	return filepath.Base(path), err
+}
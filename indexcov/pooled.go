@@ -0,0 +1,214 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/xopen"
+)
+
+// manifest maps the path of a pooled bam or cram to the sample of each of its read-groups.
+type manifest map[string]map[string]string
+
+// readManifest reads a tab-delimited file of path, read-group id, sample. Lines starting
+// with '#' are ignored.
+func readManifest(path string) (manifest, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	m := make(manifest)
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' {
+			toks := strings.Split(line, "\t")
+			if len(toks) != 3 {
+				return nil, fmt.Errorf("indexcov: expected 3 columns (path, read-group, sample) at line %d of %s, got %d", i, path, len(toks))
+			}
+			if m[toks[0]] == nil {
+				m[toks[0]] = make(map[string]string)
+			}
+			m[toks[0]][toks[1]] = toks[2]
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return m, nil
+}
+
+// readPooled splits a multi-sample bam or cram into an Index per sample. The index of the
+// file can not tell which reads come from which read-group so the alignments are read with
+// samtools and the reads of each sample are counted in each 16KB tile. These counts are
+// used in place of the sizes from the index. Samples are returned in sorted order. Chromosomes are
+// matched with al.
+func readPooled(path string, rgs map[string]string, al *chromAliases) ([]*Index, []string, error) {
+	h, err := cramHeader(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("indexcov: error reading header of pooled file %s: %s", path, err)
+	}
+	refs := h.Refs()
+	ids := make(map[string]int, len(refs))
+	for _, r := range refs {
+		ids[al.key(r.Name())] = r.ID()
+	}
+
+	var names []string
+	bySample := make(map[string]*Index)
+	byRG := make(map[string]*Index, len(rgs))
+	for rg, sample := range rgs {
+		idx, ok := bySample[sample]
+		if !ok {
+			idx = &Index{path: path, refs: refs, sizes: make([][]int64, len(refs))}
+			bySample[sample] = idx
+			names = append(names, sample)
+		}
+		byRG[rg] = idx
+	}
+	sort.Strings(names)
+
+	p, err := exec.LookPath("samtools")
+	if err != nil {
+		return nil, nil, errNoSamtools
+	}
+	// skip secondary, qc-fail, duplicate and supplementary alignments.
+	cmd := exec.Command(p, "view", "-F", "0xF00", path)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Println(p, "view", "-F", "0xF00", path)
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	unknown := 0
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 1<<20), 1<<28)
+	for scanner.Scan() {
+		toks := strings.SplitN(scanner.Text(), "\t", 12)
+		if len(toks) < 11 {
+			continue
+		}
+		var idx *Index
+		if len(toks) == 12 {
+			if i := strings.Index(toks[11], "RG:Z:"); i != -1 {
+				rg := toks[11][i+5:]
+				if j := strings.IndexByte(rg, '\t'); j != -1 {
+					rg = rg[:j]
+				}
+				idx = byRG[rg]
+			}
+		}
+		if idx == nil {
+			unknown++
+			continue
+		}
+		flag, _ := strconv.Atoi(toks[1])
+		id, ok := ids[al.key(toks[2])]
+		if flag&0x4 != 0 || !ok {
+			idx.unmapped++
+			continue
+		}
+		pos, err := strconv.Atoi(toks[3])
+		if err != nil || pos < 1 {
+			idx.unmapped++
+			continue
+		}
+		tile := (pos - 1) / TileWidth
+		if n := len(idx.sizes[id]); n <= tile {
+			idx.sizes[id] = append(idx.sizes[id], make([]int64, tile+1-n)...)
+		}
+		idx.sizes[id][tile]++
+		idx.mapped++
+	}
+	if err := scanner.Err(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, nil, fmt.Errorf("indexcov: error reading pooled file %s: %s", path, err)
+	}
+	if unknown > 0 {
+		log.Printf("indexcov: WARNING: skipped %d reads in %s with a read-group that is not in the manifest", unknown, path)
+	}
+	idxs := make([]*Index, len(names))
+	for i, n := range names {
+		idxs[i] = bySample[n]
+		if err := idxs[i].init(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return idxs, names, nil
+}
+
+// readInputs reads the indexes or depth files of the input files. Files in the manifest are
+// split into a sample per read-group. Samples are in the order of the paths.
+func readInputs(opts *Options, refs []*sam.Reference) ([]DepthSource, []string, error) {
+	if opts.Manifest == "" {
+		return readSources(opts, opts.Paths, refs)
+	}
+	man, err := readManifest(opts.Manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	var plain []string
+	var pooled []string
+	for _, p := range opts.Paths {
+		if _, ok := man[p]; ok {
+			pooled = append(pooled, p)
+		} else {
+			plain = append(plain, p)
+		}
+	}
+	if len(pooled) == 0 {
+		log.Printf("indexcov: WARNING: none of the files in %s were given as input", opts.Manifest)
+	}
+	pidxs, pnames, err := readSources(opts, plain, refs)
+	if err != nil {
+		return nil, nil, err
+	}
+	type result struct {
+		idxs  []*Index
+		names []string
+		err   error
+	}
+	results := make([]result, len(pooled))
+	parallel(len(pooled), opts.processes(), func(i int) {
+		r := &results[i]
+		r.idxs, r.names, r.err = readPooled(pooled[i], man[pooled[i]], opts.aliasTable())
+	})
+
+	var idxs []DepthSource
+	var names []string
+	ip, ipooled := 0, 0
+	for _, p := range opts.Paths {
+		if _, ok := man[p]; ok {
+			r := results[ipooled]
+			if r.err != nil {
+				return nil, nil, r.err
+			}
+			for _, idx := range r.idxs {
+				idxs = append(idxs, idx)
+			}
+			names = append(names, r.names...)
+			ipooled++
+		} else {
+			idxs, names = append(idxs, pidxs[ip]), append(names, pnames[ip])
+			ip++
+		}
+	}
+	return idxs, names, nil
+}
@@ -0,0 +1,129 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// refPanel holds the loadings of the first 2 components of a PCA of a reference panel (from
+// --loadings or indexcov-gather) so that samples can be placed in the space of the panel. No panel
+// is bundled as the loadings depend on the reference, window and exclusions of the run.
+type refPanel struct {
+	path  string
+	width int
+	// rows maps the key in aliases of a chromosome and the start of a bin to its row.
+	rows     map[string]map[int]int
+	aliases  *chromAliases
+	means    []float64
+	weights  []float64
+	loadings [][2]float64
+}
+
+// readPanel reads a $prefix-indexcov-loadings.bed.gz. Only PC1 and PC2 are used. Chromosomes are
+// matched with al.
+func readPanel(path string, al *chromAliases) (*refPanel, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	p := &refPanel{path: path, rows: make(map[string]map[int]int), aliases: al}
+	cols := map[string]int{}
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			toks := strings.Split(line, "\t")
+			if line[0] == '#' {
+				for j, t := range toks {
+					cols[t] = j
+				}
+			} else {
+				if len(cols) == 0 || len(toks) < 3 {
+					return nil, fmt.Errorf("expected a header with mean, weight, PC1 and PC2 then a line per bin in %s", path)
+				}
+				vals := make([]float64, 4)
+				for j, name := range []string{"mean", "weight", "PC1", "PC2"} {
+					c, ok := cols[name]
+					if !ok {
+						return nil, fmt.Errorf("no %s column in %s", name, path)
+					}
+					if c >= len(toks) {
+						return nil, fmt.Errorf("expected %d columns at line %d of %s, got %d", len(cols), i, path, len(toks))
+					}
+					if vals[j], err = strconv.ParseFloat(toks[c], 64); err != nil {
+						return nil, fmt.Errorf("bad %s at line %d of %s: %s", name, i, path, toks[c])
+					}
+				}
+				start, serr := strconv.Atoi(toks[1])
+				end, eerr := strconv.Atoi(toks[2])
+				if serr != nil || eerr != nil || end <= start {
+					return nil, fmt.Errorf("bad interval at line %d of %s: %s", i, path, line)
+				}
+				if p.width == 0 {
+					p.width = end - start
+				} else if end-start != p.width {
+					return nil, fmt.Errorf("expected bins of %d at line %d of %s, got %d", p.width, i, path, end-start)
+				}
+				key := al.key(toks[0])
+				if p.rows[key] == nil {
+					p.rows[key] = make(map[int]int)
+				}
+				p.rows[key][start] = len(p.means)
+				p.means = append(p.means, vals[0])
+				p.weights = append(p.weights, vals[1])
+				p.loadings = append(p.loadings, [2]float64{vals[2], vals[3]})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(p.means) == 0 {
+		return nil, fmt.Errorf("no loadings found in %s", path)
+	}
+	return p, nil
+}
+
+// project returns the coordinates of each sample (a row of pca8 with the bins in tiles) on the
+// first 2 components of the panel. Bins of the panel that are not in tiles (e.g. from --exclude)
+// are taken to be at the panel mean and so do not move a sample.
+func (p *refPanel) project(pca8 [][]uint8, tiles []pcaTile, width int) ([][2]float64, error) {
+	// rows[j] is the row in the panel of tiles[j] or -1.
+	rows := make([]int, len(tiles))
+	found := 0
+	for j, t := range tiles {
+		rows[j] = -1
+		if r, ok := p.rows[p.aliases.key(t.chrom)][t.tile*width]; ok {
+			rows[j] = r
+			found++
+		}
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("none of the bins of %s are in the PCA. are they from the same build?", p.path)
+	}
+	if frac := float64(found) / float64(len(p.means)); frac < 0.9 {
+		log.Printf("indexcov: WARNING: only %.1f%% of the bins of %s are in the PCA. the projection may be off", 100*frac, p.path)
+	}
+	coords := make([][2]float64, len(pca8))
+	for i, x := range pca8 {
+		for j, r := range rows {
+			if r < 0 {
+				continue
+			}
+			d := p.weights[r] * (float64(x[j]) - p.means[r])
+			coords[i][0] += d * p.loadings[r][0]
+			coords[i][1] += d * p.loadings[r][1]
+		}
+	}
+	return coords, nil
+}
@@ -0,0 +1,119 @@
+package indexcov
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// panelDepths returns the 8-bit depths of n samples on m tiles that vary along 2 directions.
+func panelDepths(n, m int) [][]uint8 {
+	x := make([][]uint8, n)
+	for i := range x {
+		x[i] = make([]uint8, m)
+		a, b := float64(i%4)-1.5, float64(i/4)*2-1
+		for j := range x[i] {
+			v := 128 + 20*a*math.Sin(float64(j)/5) + 12*b*math.Cos(float64(j)/3) + float64((i*7+j*3)%5)
+			x[i][j] = uint8(v + 0.5)
+		}
+	}
+	return x
+}
+
+func TestProjectLoadings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const width = 16384
+	x := panelDepths(8, 60)
+	scores, fit, err := randomizedPCA(x, 2, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiles := make([]pcaTile, 60)
+	for j := range tiles {
+		tiles[j] = pcaTile{chrom: "1", tile: j}
+	}
+	path := filepath.Join(dir, "panel-indexcov-loadings.bed")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fit.formatLoadings(f, tiles, width); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	p, err := readPanel(path, defaultAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.width != width || len(p.means) != 60 {
+		t.Fatalf("expected 60 bins of %d, got %d of %d", width, len(p.means), p.width)
+	}
+	// the run names the chromosome chr1 and the panel names it 1.
+	runTiles := make([]pcaTile, len(tiles))
+	for j, tl := range tiles {
+		runTiles[j] = pcaTile{chrom: "chr1", tile: tl.tile}
+	}
+	coords, err := p.project(x, runTiles, width)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the panel projected onto its own loadings gives its scores.
+	for i, c := range coords {
+		for k := 0; k < 2; k++ {
+			if math.Abs(c[k]-scores.At(i, k)) > 0.01*math.Max(1, math.Abs(scores.At(i, k))) {
+				t.Errorf("sample %d PC%d: expected %.3f, got %.3f", i, k+1, scores.At(i, k), c[k])
+			}
+		}
+	}
+
+	// masked bins are taken to be at the panel mean.
+	means := make([]uint8, 60)
+	for j, m := range p.means {
+		means[j] = uint8(m + 0.5)
+	}
+	coords, err = p.project([][]uint8{means[:30]}, runTiles[:30], width)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(coords[0][0]) > 1 || math.Abs(coords[0][1]) > 1 {
+		t.Errorf("expected a sample at the panel mean to be near the origin, got %v", coords[0])
+	}
+
+	if _, err := p.project(x, []pcaTile{{chrom: "2", tile: 0}}, width); err == nil {
+		t.Error("expected an error when no bins are in the panel")
+	}
+}
+
+func TestReadPanelErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-panel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hdr := "#chrom\tstart\tend\tmean\tweight\tPC1\tPC2\n"
+	for _, c := range []struct {
+		name, content, msg string
+	}{
+		{"no header", "1\t0\t10\t1\t1\t0.1\t0.2\n", "expected a header"},
+		{"no PC2", "#chrom\tstart\tend\tmean\tweight\tPC1\n1\t0\t10\t1\t1\t0.1\n", "no PC2 column"},
+		{"width", hdr + "1\t0\t10\t1\t1\t0.1\t0.2\n1\t10\t30\t1\t1\t0.1\t0.2\n", "expected bins of 10"},
+		{"value", hdr + "1\t0\t10\tx\t1\t0.1\t0.2\n", "bad mean"},
+		{"empty", hdr, "no loadings"},
+	} {
+		path := filepath.Join(dir, strings.Replace(c.name, " ", "-", -1)+".bed")
+		if err := ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readPanel(path, defaultAliases); err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%s: expected an error with %q, got %v", c.name, c.msg, err)
+		}
+	}
+}
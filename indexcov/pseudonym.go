@@ -0,0 +1,100 @@
+package indexcov
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pseudonyms maps the name of each sample to its pseudonym when Options.Anonymize is used so that
+// the samples in the ped, metadata and replicates files can still be matched.
+type pseudonyms map[string]string
+
+// name returns the pseudonym of a sample name from an input file or the name if it has none.
+func (ps pseudonyms) name(name string) string {
+	if p, ok := ps[name]; ok {
+		return p
+	}
+	return name
+}
+
+// newPseudonym returns a random name that is not in used. It is random rather than a hash of the
+// name so that it can not be found from a guess of the name.
+func newPseudonym(used map[string]bool) (string, error) {
+	b := make([]byte, 4)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		if p := "anon-" + hex.EncodeToString(b); !used[p] {
+			return p, nil
+		}
+	}
+}
+
+// anonymize returns the names replaced by their pseudonyms in the mapping at path and the mapping
+// of each name to its pseudonym. Names that are not in the mapping get a new pseudonym which is
+// appended so that a sample keeps its pseudonym across runs. The file is created readable only by
+// the user.
+func anonymize(path string, names []string, dir string) ([]string, map[string]string, error) {
+	m := make(map[string]string)
+	if fi, err := os.Stat(path); err == nil {
+		if m, err = readSampleMap(path); err != nil {
+			return nil, nil, err
+		}
+		if fi.Mode().Perm()&0077 != 0 {
+			log.Printf("indexcov: WARNING: %s with the real sample names can be read by other users (mode %s)", path, fi.Mode().Perm())
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	used := make(map[string]bool, len(m))
+	for _, to := range m {
+		if used[to] {
+			return nil, nil, fmt.Errorf("indexcov: pseudonym %s is used for more than 1 sample in %s", to, path)
+		}
+		used[to] = true
+	}
+	var added []string
+	out := make([]string, len(names))
+	for k, n := range names {
+		p, ok := m[n]
+		if !ok {
+			var err error
+			if p, err = newPseudonym(used); err != nil {
+				return nil, nil, err
+			}
+			m[n], used[p] = p, true
+			added = append(added, n)
+		}
+		out[k] = p
+	}
+	if len(added) > 0 {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, nil, err
+		}
+		var b strings.Builder
+		for _, n := range added {
+			fmt.Fprintf(&b, "%s\t%s\n", n, m[n])
+		}
+		_, err = f.WriteString(b.String())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("indexcov: error writing pseudonyms to %s: %s", path, err)
+		}
+	}
+	if a, err := filepath.Abs(path); err == nil {
+		if d, err := filepath.Abs(dir); err == nil && strings.HasPrefix(a, d+string(os.PathSeparator)) {
+			log.Printf("indexcov: WARNING: the pseudonyms in %s are in the output directory. move it before sharing the output", path)
+		}
+	}
+	log.Printf("indexcov: anonymized %d samples (%d new) with the pseudonyms in %s", len(names), len(added), path)
+	return out, m, nil
+}
@@ -0,0 +1,225 @@
+package indexcov
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	chartjs "github.com/brentp/go-chartjs"
+)
+
+func TestAnonymize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-anonymize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pseudonyms.tsv")
+	out, m, err := anonymize(path, []string{"a", "b", "c"}, filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^anon-[0-9a-f]{8}$`)
+	seen := make(map[string]bool)
+	for k, p := range out {
+		if !re.MatchString(p) || seen[p] || m[[]string{"a", "b", "c"}[k]] != p {
+			t.Errorf("expected distinct anon- pseudonyms in the mapping, got %v and %v", out, m)
+		}
+		seen[p] = true
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the mapping to be readable only by the user, got %s", fi.Mode().Perm())
+	}
+
+	// a later run keeps the pseudonyms and adds the new sample.
+	again, m2, err := anonymize(path, []string{"c", "a", "d"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0] != out[2] || again[1] != out[0] || seen[again[2]] || !re.MatchString(again[2]) {
+		t.Errorf("expected the same pseudonyms for c and a and a new one for d, got %v then %v", out, again)
+	}
+	if len(m2) != 4 || m2["b"] != out[1] {
+		t.Errorf("expected all 4 samples in the mapping, got %v", m2)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 4 || lines[3] != "d\t"+again[2] {
+		t.Errorf("expected d to be appended to the mapping, got %q", lines)
+	}
+
+	dup := filepath.Join(dir, "dup.tsv")
+	if err := ioutil.WriteFile(dup, []byte("a\tanon-00000000\nb\tanon-00000000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := anonymize(dup, []string{"a"}, dir); err == nil || !strings.Contains(err.Error(), "used for more than 1 sample") {
+		t.Errorf("expected an error for a pseudonym used twice, got %v", err)
+	}
+}
+
+// TestPseudonymInputs checks that the samples in the ped, metadata and replicates files, which have
+// the real names, are matched to the samples named by their pseudonyms.
+func TestPseudonymInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-anonymize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ps := pseudonyms{"kid": "anon-00000001", "dad": "anon-00000002", "mom": "anon-00000003", "rep": "anon-00000004"}
+	samples := []string{"anon-00000001", "anon-00000002", "anon-00000003", "anon-00000004", "other"}
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	ped := write("x.ped", "#family\tsample\tpaternal\tmaternal\tsex\tpheno\ttwin\n"+
+		"f1\tkid\tdad\tmom\t2\t-9\trep\n"+
+		"f1\tdad\t0\t0\t1\t-9\t0\n"+
+		"f1\tmom\t0\t0\t2\t-9\t0\n"+
+		"f2\tother\t0\t0\t1\t-9\t0\n")
+	sexes, err := readPedSex(ped, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"anon-00000001": "2", "anon-00000002": "1", "anon-00000003": "2", "other": "1"}; !reflect.DeepEqual(sexes, exp) {
+		t.Errorf("expected the sexes by pseudonym %v, got %v", exp, sexes)
+	}
+	people, err := readPedFamilies(ped, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid := people["anon-00000001"]
+	if kid == nil || kid.father != "anon-00000002" || kid.mother != "anon-00000003" || kid.twin != "anon-00000004" {
+		t.Errorf("expected the parents and twin of the kid by pseudonym, got %+v", kid)
+	}
+	if _, ok := people["kid"]; ok {
+		t.Error("expected no sample by its real name")
+	}
+
+	tbl := newSampleTable([]string{"sample_id"})
+	for _, s := range samples {
+		tbl.add(s, []string{s})
+	}
+	if err := tbl.readMetadata(write("meta.tsv", "sample\tbatch\nmom\tb1\nother\tb2\n"), ps); err != nil {
+		t.Fatal(err)
+	}
+	if batch, _ := tbl.strings("batch"); !reflect.DeepEqual(batch, []string{"NA", "NA", "b1", "NA", "b2"}) {
+		t.Errorf("expected the metadata joined by pseudonym, got %v", batch)
+	}
+
+	rc, err := readReplicates(write("reps.tsv", "# replicates\nkid\trep\n"), samples, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.pairs) != 1 || rc.pairs[0].a != 0 || rc.pairs[0].b != 3 {
+		t.Errorf("expected kid and rep to be paired by pseudonym, got %v", rc.pairs)
+	}
+}
+
+// TestRunAnonymized checks that the real sample names are in none of the output of a run with
+// Anonymize and that they are kept from run to run.
+func TestRunAnonymized(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexcov-anonymize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var probe chartjs.Chart
+	if probe.AddXAxis(chartjs.Axis{}); len(probe.Options.Scales.XAxes) == 0 {
+		t.Skip("the chartjs package of this build does not add axes")
+	}
+	chroms := []string{"chr1", "chr2", "chr3", "chrX", "chrY"}
+	rng := rand.New(rand.NewSource(3))
+	names := []string{"alice", "bob", "carol", "dave", "erin", "frank"}
+	var paths []string
+	for k, n := range names {
+		// alternate female and male samples.
+		cns := []float64{2, 2, 2, 2, 0}
+		if k%2 == 1 {
+			cns[3], cns[4] = 1, 1
+		}
+		var b strings.Builder
+		for i, c := range chroms {
+			for j, d := range noisyDepths(cns[i], 300, 0.05, rng) {
+				fmt.Fprintf(&b, "%s\t%d\t%d\t%.2f\n", c, j*TileWidth, (j+1)*TileWidth, 30*d)
+			}
+		}
+		path := filepath.Join(dir, n+".bed")
+		if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	ped := filepath.Join(dir, "in.ped")
+	if err := ioutil.WriteFile(ped, []byte("f1\talice\tbob\tcarol\t1\t-9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapping := filepath.Join(dir, "pseudonyms.tsv")
+	run := func(out string) *Result {
+		res, err := Run(Options{Directory: filepath.Join(dir, out), Paths: paths,
+			Sex: []string{"X", "Y"}, Ped: ped, Anonymize: mapping, Processes: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	first, second := run("a"), run("b")
+	if !reflect.DeepEqual(first.Samples, second.Samples) {
+		t.Errorf("expected the same pseudonyms in both runs, got %v and %v", first.Samples, second.Samples)
+	}
+	b, err := ioutil.ReadFile(first.Ped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range first.Samples {
+		if !strings.HasPrefix(p, "anon-") || !strings.Contains(string(b), p+"\t") {
+			t.Errorf("expected a row for %s in %s", p, first.Ped)
+		}
+	}
+	for _, out := range []string{"a", "b"} {
+		err := filepath.Walk(filepath.Join(dir, out), func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if strings.HasSuffix(path, ".gz") && len(b) > 0 {
+				gz, err := gzip.NewReader(bytes.NewReader(b))
+				if err != nil {
+					return err
+				}
+				if b, err = ioutil.ReadAll(gz); err != nil {
+					return err
+				}
+			}
+			for _, n := range names {
+				if strings.Contains(string(b), n) {
+					t.Errorf("expected no real sample names in %s, found %s", path, n)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+)
+
+// depths are quantized to steps of this size before segmenting for the purity fit.
+const purityStep = 0.1
+
+// search space for the purity/ploidy fit. Copy-numbers up to maxPurityCN are considered.
+const (
+	minPurity, maxPurity, purityInc = 0.1, 1.0, 0.01
+	minPloidy, maxPloidy, ploidyInc = 1.5, 5.0, 0.05
+	maxPurityCN                     = 8
+)
+
+// solutions where the mean integer copy-number of the segments differs from the
+// ploidy by more than this are inconsistent and are not used.
+const maxPloidyDiff = 0.5
+
+// the fit is penalized by this much per copy away from the normal ploidy so that a doubled solution,
+// which has more levels to fit noise, is only chosen when it is clearly better.
+const ploidyPenalty = 0.01
+
+// purityFit is the result of fitting a single sample.
+type purityFit struct {
+	purity, ploidy float64
+	// mse is the mean squared distance (in units of copy-number) from each segment to the nearest copy-number.
+	mse      float64
+	segments int
+}
+
+// purityFitter collects segments of each sample as chromosomes are processed.
+type purityFitter struct {
+	segs [][]segment
+	// normal is the ploidy of the normal cells.
+	normal int
+}
+
+func newPurityFitter(n int, normal int) *purityFitter {
+	return &purityFitter{segs: make([][]segment, n), normal: normal}
+}
+
+// add segments the depths of each sample on a single chromosome. Unlike the calls, the
+// segments are made on the depth rather than on an integer copy-number so that changes in
+// impure tumor samples, which are not at integer copy-numbers, are kept.
+func (p *purityFitter) add(chrom string, depths [][]float32, longest int) {
+	// gaps are found across samples, as for the calls.
+	gaps := findGaps(depths, longest)
+	q := make([]int, longest)
+	for k, d := range depths {
+		for i := range q {
+			q[i] = -1
+			if i < len(d) && !gaps[i] {
+				q[i] = int(float64(d[i])/purityStep + 0.5)
+			}
+		}
+		for _, s := range segmentCNs(chrom, k, smoothCNs(q, callSmooth), d, TileWidth) {
+			if s.bins >= minCallBins {
+				p.segs[k] = append(p.segs[k], s)
+			}
+		}
+	}
+}
+
+// expected depth, relative to the sample median, of a segment with copy-number cn when the normal
+// cells have a ploidy of normal.
+func relativeDepth(cn int, purity, ploidy float64, normal int) float64 {
+	return (purity*float64(cn) + float64(normal)*(1-purity)) / (purity*ploidy + float64(normal)*(1-purity))
+}
+
+// fitPurity does a grid search over purity and ploidy for the values that best place the
+// segments at integer copy-numbers. Segments are weighted by their number of bins. The
+// depths are normalized to the sample median so a sample with no copy-number changes will
+// fit as pure with the normal ploidy.
+func fitPurity(segs []segment, normal int) purityFit {
+	best := purityFit{purity: math.NaN(), ploidy: math.NaN(), mse: math.Inf(1), segments: len(segs)}
+	bestScore := math.Inf(1)
+	var total float64
+	for _, s := range segs {
+		total += float64(s.bins)
+	}
+	if total == 0 {
+		return best
+	}
+	// ploidy is searched from low to high and purity from high to low so that ties, e.g. from
+	// whole-genome doubling, go to the lowest ploidy and highest purity.
+	for ploidy := minPloidy; ploidy <= maxPloidy+1e-9; ploidy += ploidyInc {
+		for purity := maxPurity; purity >= minPurity-1e-9; purity -= purityInc {
+			// difference in relative depth between adjacent copy-numbers.
+			spacing := relativeDepth(1, purity, ploidy, normal) - relativeDepth(0, purity, ploidy, normal)
+			var sse, meanCN float64
+			for _, s := range segs {
+				cn := int(math.Round((s.depth - relativeDepth(0, purity, ploidy, normal)) / spacing))
+				if cn < 0 {
+					cn = 0
+				} else if cn > maxPurityCN {
+					cn = maxPurityCN
+				}
+				d := (s.depth - relativeDepth(cn, purity, ploidy, normal)) / spacing
+				sse += float64(s.bins) * d * d
+				meanCN += float64(s.bins) * float64(cn)
+			}
+			if math.Abs(meanCN/total-ploidy) > maxPloidyDiff {
+				continue
+			}
+			mse := sse / total
+			if score := mse + ploidyPenalty*math.Abs(ploidy-float64(normal)); score < bestScore-1e-6 {
+				bestScore = score
+				best.purity, best.ploidy, best.mse = purity, ploidy, mse
+			}
+		}
+	}
+	return best
+}
+
+// write fits each sample and writes the estimates to path.
+func (p *purityFitter) write(path string, samples []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample\tpurity\tploidy\tmse\tsegments")
+	for k, segs := range p.segs {
+		fit := fitPurity(segs, p.normal)
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%.4f\t%d\n", samples[k], fit.purity, fit.ploidy, fit.mse, fit.segments)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
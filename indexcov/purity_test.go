@@ -0,0 +1,43 @@
+package indexcov
+
+import (
+	"math"
+	"testing"
+)
+
+// puritySegments makes a segment for each copy-number with the depth expected at the purity and
+// ploidy.
+func puritySegments(purity, ploidy float64, bins map[int]int) []segment {
+	var segs []segment
+	for cn, n := range bins {
+		segs = append(segs, segment{chrom: "chr1", cn: cn, bins: n, depth: relativeDepth(cn, purity, ploidy, 2)})
+	}
+	return segs
+}
+
+func TestFitPurity(t *testing.T) {
+	for _, c := range []struct {
+		purity, ploidy float64
+		bins           map[int]int
+	}{
+		{1, 2, map[int]int{2: 1000}},
+		{0.6, 2, map[int]int{1: 200, 2: 600, 3: 200}},
+		{0.8, 2, map[int]int{0: 100, 2: 700, 4: 200}},
+	} {
+		fit := fitPurity(puritySegments(c.purity, c.ploidy, c.bins), 2)
+		if math.Abs(fit.purity-c.purity) > 0.02 || math.Abs(fit.ploidy-c.ploidy) > 0.06 {
+			t.Errorf("expected purity %.2f and ploidy %.2f, got %.2f and %.2f", c.purity, c.ploidy, fit.purity, fit.ploidy)
+		}
+		if fit.mse > 1e-3 {
+			t.Errorf("expected a close fit for purity %.2f, got mse %f", c.purity, fit.mse)
+		}
+		if fit.segments != len(c.bins) {
+			t.Errorf("expected %d segments, got %d", len(c.bins), fit.segments)
+		}
+	}
+
+	fit := fitPurity(nil, 2)
+	if !math.IsNaN(fit.purity) || !math.IsNaN(fit.ploidy) {
+		t.Errorf("expected NaN for no segments, got %v", fit)
+	}
+}
@@ -0,0 +1,236 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/goleft"
+	"github.com/brentp/xopen"
+)
+
+// reason code for samples where the sex in the --ped does not match the inferred sex.
+const sexMismatch = "SEX_MISMATCH"
+
+// pcaDistance returns, for each sample, the distance from the cohort center in the space
+// of the PCs in the table. Each PC is scaled by its MAD so that this is robust to outliers.
+func pcaDistance(t *sampleTable) ([]float64, error) {
+	dist := make([]float64, len(t.rows))
+	for p := 1; p <= 5; p++ {
+		if t.column(fmt.Sprintf("PC%d", p)) == -1 {
+			break
+		}
+		vals, err := t.floats(fmt.Sprintf("PC%d", p))
+		if err != nil {
+			return nil, err
+		}
+		med, mad := medianMAD(vals)
+		if mad == 0 || math.IsNaN(mad) {
+			continue
+		}
+		for i, v := range vals {
+			z := (v - med) / mad
+			dist[i] += z * z
+		}
+	}
+	for i, d := range dist {
+		dist[i] = math.Sqrt(d)
+	}
+	return dist, nil
+}
+
+// readPedSex reads the sample (column 2), named by its pseudonym in ps, and sex (column 5) from a ped file.
+func readPedSex(path string, ps pseudonyms) (map[string]string, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	sexes := make(map[string]string)
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' {
+			toks := strings.Fields(line)
+			if len(toks) < 5 {
+				return nil, fmt.Errorf("indexcov: expected at least 5 columns at line %d of %s", i, path)
+			}
+			sexes[ps.name(toks[1])] = toks[4]
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return sexes, nil
+}
+
+// reasonCode gives the code used in the qc.tsv for a metric outside of its range, e.g. P_OUT_HIGH.
+func reasonCode(metric string, high bool) string {
+	code := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(metric))
+	if high {
+		return code + "_HIGH"
+	}
+	return code + "_LOW"
+}
+
+// checkQC applies the rules (metric, low, high) and, if pedSex is not nil, compares the
+// reported sex to the inferred sex in the table. It returns the reasons that each sample
+// failed. Rules for metrics that are NA for a sample are skipped for that sample.
+func checkQC(t *sampleTable, rules []refRange, pedSex map[string]string) ([][]string, error) {
+	reasons := make([][]string, len(t.rows))
+	for _, r := range rules {
+		vals, err := t.floats(r.metric)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range vals {
+			if r.contains(v) {
+				continue
+			}
+			reasons[i] = append(reasons[i], reasonCode(r.metric, v > r.hi))
+		}
+	}
+	if pedSex != nil {
+		inferred, err := t.strings("sex")
+		if err != nil {
+			return nil, err
+		}
+		for i, sample := range t.samples {
+			reported := pedSex[sample]
+			if (reported == "1" || reported == "2") && (inferred[i] == "1" || inferred[i] == "2") && reported != inferred[i] {
+				reasons[i] = append(reasons[i], sexMismatch)
+				log.Printf("indexcov: WARNING: sample %s has sex %s in the ped file but the inferred sex is %s", sample, reported, inferred[i])
+			}
+		}
+	}
+	return reasons, nil
+}
+
+// runQC adds a pca.dist column to the table, checks each sample against the --qc rules and
+// the --ped and sets the qc column.
+func runQC(opts *Options, t *sampleTable) error {
+	dist, err := pcaDistance(t)
+	if err != nil {
+		return err
+	}
+	sdist := make([]string, len(dist))
+	for i, d := range dist {
+		sdist[i] = fmt.Sprintf("%.2f", d)
+	}
+	t.addColumn("pca.dist", sdist)
+
+	var rules []refRange
+	if opts.QCRules != "" {
+		if rules, err = readReferenceRanges(opts.QCRules); err != nil {
+			return goleft.InputErr(fmt.Errorf("indexcov: error reading qc rules: %s", err))
+		}
+	}
+	var pedSex map[string]string
+	if opts.Ped != "" {
+		if pedSex, err = readPedSex(opts.Ped, opts.pseudonyms); err != nil {
+			return goleft.InputErr(err)
+		}
+	}
+	reasons, err := checkQC(t, rules, pedSex)
+	if err != nil {
+		return goleft.InputErr(fmt.Errorf("indexcov: error with qc rules: %s", err))
+	}
+	if c := opts.controls; c != nil {
+		index := make(map[string]int, len(c.samples))
+		for k, s := range c.samples {
+			index[s] = k
+		}
+		nOut := make([]string, len(t.samples))
+		for i, s := range t.samples {
+			k, ok := index[s]
+			if !ok {
+				nOut[i] = "NA"
+				continue
+			}
+			nOut[i] = strconv.Itoa(c.nOut(k))
+			reasons[i] = append(reasons[i], c.reasons(k)...)
+		}
+		t.addColumn("controls.out", nOut)
+	}
+	if _, err = addQC(t, reasons, opts.base()+".qc.tsv"); err != nil {
+		return err
+	}
+	if opts.events != nil {
+		opts.events.addQC(t, reasons, pedSex)
+	}
+	return nil
+}
+
+// addQC adds the qc column to the table, keeping any FAIL from --apply-thresholds,
+// and writes the qc.tsv. It returns the number of samples that failed.
+func addQC(t *sampleTable, reasons [][]string, path string) (int, error) {
+	prev, _ := t.strings("qc")
+	qc := make([]string, len(t.rows))
+	nFail := 0
+	for i := range qc {
+		qc[i] = "PASS"
+		if prev != nil && prev[i] == "FAIL" {
+			reasons[i] = append(reasons[i], "COHORT_THRESHOLD")
+		}
+		if len(reasons[i]) > 0 {
+			qc[i] = "FAIL"
+			nFail++
+		}
+	}
+	if ci := t.column("qc"); ci != -1 {
+		for i := range t.rows {
+			t.rows[i][ci] = qc[i]
+		}
+	} else {
+		t.addColumn("qc", qc)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample\tqc\treasons")
+	for i, sample := range t.samples {
+		r := "."
+		if len(reasons[i]) > 0 {
+			r = strings.Join(reasons[i], ",")
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", sample, qc[i], r); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return nFail, f.Close()
+}
+
+// warnedSamples returns the samples that did not fail qc but have a warning: less than minRefMatch of
+// their data on the cohort references, an ambiguous sex (written as 0) or metrics outside of the
+// --reference-ranges.
+func warnedSamples(t *sampleTable) []string {
+	qc, _ := t.strings("qc")
+	sex, _ := t.strings("sex")
+	outside, _ := t.strings("outside.ref")
+	match, _ := t.floats("ref.match")
+	var warned []string
+	for i, sample := range t.samples {
+		if qc != nil && qc[i] == "FAIL" {
+			continue
+		}
+		if (match != nil && match[i] < minRefMatch) || (sex != nil && sex[i] == "0") || (outside != nil && outside[i] != ".") {
+			warned = append(warned, sample)
+		}
+	}
+	return warned
+}
@@ -0,0 +1,37 @@
+package indexcov
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCheckQC(t *testing.T) {
+	tbl := newSampleTable([]string{"sample_id", "sex", "p.out", "bins.lo"})
+	tbl.add("a", []string{"a", "1", "0.01", "10"})
+	tbl.add("b", []string{"b", "2", "0.5", "NA"})
+	tbl.add("c", []string{"c", "2", "0.01", "500"})
+
+	rules := []refRange{{metric: "p.out", lo: math.Inf(-1), hi: 0.1}, {metric: "bins.lo", lo: 5, hi: 100}}
+	reasons, err := checkQC(tbl, rules, map[string]string{"a": "2", "b": "2", "c": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := [][]string{{sexMismatch}, {"P_OUT_HIGH"}, {"BINS_LO_HIGH"}}
+	if !reflect.DeepEqual(reasons, exp) {
+		t.Errorf("expected: %v, got: %v", exp, reasons)
+	}
+
+	if _, err := checkQC(tbl, []refRange{{metric: "missing", lo: 0, hi: 1}}, nil); err == nil {
+		t.Error("expected an error for a rule on a missing column")
+	}
+}
+
+func TestReasonCode(t *testing.T) {
+	if c := reasonCode("bins.out", false); c != "BINS_OUT_LOW" {
+		t.Errorf("expected BINS_OUT_LOW, got %s", c)
+	}
+	if c := reasonCode("CN-X", true); c != "CN_X_HIGH" {
+		t.Errorf("expected CN_X_HIGH, got %s", c)
+	}
+}
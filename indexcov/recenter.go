@@ -0,0 +1,111 @@
+package indexcov
+
+import (
+	"log"
+	"math"
+
+	"github.com/biogo/hts/sam"
+)
+
+// segment depths are counted in bins of this width to find the modal level.
+const modalBin = purityStep / 2
+
+// recenterSource is a DepthSource scaled so that the most common level of the segments of the sample,
+// rather than the median tile, is 1.
+type recenterSource struct {
+	DepthSource
+	scale float32
+}
+
+// NormalizedDepth implements DepthSource.
+func (r *recenterSource) NormalizedDepth(refID int) []float32 {
+	depths := append([]float32{}, r.DepthSource.NormalizedDepth(refID)...)
+	for i := range depths {
+		depths[i] *= r.scale
+	}
+	return depths
+}
+
+// sampleSegments segments the depths of a single sample on the autosomes as for the purity fit.
+// Tiles below gapDepth end a segment.
+func sampleSegments(opts *Options, refs []*sam.Reference, src DepthSource) []segment {
+	var segs []segment
+	for i, r := range refs {
+		if sameChrom(opts.aliasTable(), opts.Sex, r.Name()) || excludeChrom(opts, r.Name()) {
+			continue
+		}
+		d := src.NormalizedDepth(i)
+		q := make([]int, len(d))
+		for k, v := range d {
+			q[k] = -1
+			if v >= gapDepth {
+				q[k] = int(float64(v)/purityStep + 0.5)
+			}
+		}
+		for _, s := range segmentCNs(r.Name(), 0, smoothCNs(q, callSmooth), d, TileWidth) {
+			if s.bins >= minCallBins {
+				segs = append(segs, s)
+			}
+		}
+	}
+	return segs
+}
+
+// modalLevel returns the depth of the most common level of the segments, weighted by their number of
+// bins. The peak of a histogram of the segment depths is refined to the weighted mean of the segments
+// within half a purityStep of it. Depths above maxCN are not counted. It returns NaN if there are no segments.
+func modalLevel(segs []segment, maxCN float32) float64 {
+	hist := make([]float64, int(float64(maxCN)/modalBin)+1)
+	for _, s := range segs {
+		if b := int(s.depth / modalBin); b >= 0 && b < len(hist) {
+			hist[b] += float64(s.bins)
+		}
+	}
+	best, peak := 0.0, -1
+	for b := range hist {
+		// smooth over adjacent bins so a level split across a bin edge is not missed.
+		v := hist[b]
+		if b > 0 {
+			v += hist[b-1] / 2
+		}
+		if b < len(hist)-1 {
+			v += hist[b+1] / 2
+		}
+		if v > best {
+			best, peak = v, b
+		}
+	}
+	if peak == -1 {
+		return math.NaN()
+	}
+	center := (float64(peak) + 0.5) * modalBin
+	var sum, n float64
+	for _, s := range segs {
+		if math.Abs(s.depth-center) <= purityStep/2 {
+			sum += s.depth * float64(s.bins)
+			n += float64(s.bins)
+		}
+	}
+	if n == 0 {
+		return center
+	}
+	return sum / n
+}
+
+// recenterModal returns sources scaled so that the modal segment level of each sample is 1. In tumors
+// where much of the genome is gained or lost, the median tile is not at the level of the normal
+// copy-number and the whole genome would otherwise appear shifted.
+func recenterModal(opts *Options, refs []*sam.Reference, srcs []DepthSource, names []string) []DepthSource {
+	log.Printf("indexcov: recentering depths on the modal segment level of each sample")
+	out := make([]DepthSource, len(srcs))
+	parallel(len(srcs), opts.processes(), func(k int) {
+		mode := modalLevel(sampleSegments(opts, refs, srcs[k]), opts.maxCN())
+		if math.IsNaN(mode) || mode < gapDepth {
+			log.Printf("indexcov: no modal level found for %s. keeping the median", names[k])
+			out[k] = srcs[k]
+			return
+		}
+		out[k] = &recenterSource{DepthSource: srcs[k], scale: float32(1 / mode)}
+	})
+	return out
+}
@@ -0,0 +1,75 @@
+package indexcov
+
+import (
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+// sliceSource is a DepthSource with the depths of each reference.
+type sliceSource [][]float32
+
+func (s sliceSource) NormalizedDepth(refID int) []float32 { return s[refID] }
+
+func constantDepths(d float32, n int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = d
+	}
+	return out
+}
+
+func TestModalLevel(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		segs []segment
+		exp  float64
+	}{
+		{"single level", []segment{{depth: 1, bins: 10}}, 1},
+		{"refined to the mean of the peak", []segment{{depth: 1, bins: 100}, {depth: 1.02, bins: 100}, {depth: 0.5, bins: 150}}, 1.01},
+		{"weighted by bins", []segment{{depth: 0.5, bins: 300}, {depth: 1, bins: 350}, {depth: 1.5, bins: 400}}, 1.5},
+		{"above maxCN is not counted", []segment{{depth: 9, bins: 1000}, {depth: 2, bins: 10}}, 2},
+		{"no segments", nil, math.NaN()},
+	} {
+		got := modalLevel(c.segs, 8)
+		if math.IsNaN(c.exp) != math.IsNaN(got) || (!math.IsNaN(c.exp) && math.Abs(got-c.exp) > 1e-6) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+func TestRecenterModal(t *testing.T) {
+	var refs []*sam.Reference
+	for _, name := range []string{"chr1", "chr2", "chr3", "chrX", "chrUn_x"} {
+		r, err := sam.NewReference(name, "", "", 1000*TileWidth, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, r)
+	}
+	// the median tile of the autosomes is on chr2 but most of the genome is gained. the sex
+	// chromosomes and the excluded contig would be the most common level if they were counted.
+	gained := sliceSource{constantDepths(0.5, 300), constantDepths(1, 350), constantDepths(1.5, 400),
+		constantDepths(0.5, 1000), constantDepths(0.5, 1000)}
+	empty := sliceSource{nil, nil, nil, nil, nil}
+	opts := &Options{Sex: []string{"X", "Y"}, Exclude: regexp.MustCompile("^chrUn"), Processes: 2}
+	out := recenterModal(opts, refs, []DepthSource{gained, empty}, []string{"gained", "empty"})
+
+	r, ok := out[0].(*recenterSource)
+	if !ok || math.Abs(float64(r.scale)-1/1.5) > 1e-5 {
+		t.Fatalf("expected the gained sample to be scaled by %.3f, got %v", 1/1.5, out[0])
+	}
+	for refID, exp := range []float32{0.5 / 1.5, 1 / 1.5, 1} {
+		if got := out[0].NormalizedDepth(refID); math.Abs(float64(got[0]-exp)) > 1e-5 {
+			t.Errorf("chromosome %d: expected a depth of %.3f, got %.3f", refID, exp, got[0])
+		}
+	}
+	if gained[2][0] != 1.5 {
+		t.Errorf("expected the depths of the source to be unchanged, got %.3f", gained[2][0])
+	}
+	if _, ok := out[1].(sliceSource); !ok {
+		t.Errorf("expected a sample with no modal level to be kept, got %v", out[1])
+	}
+}
@@ -0,0 +1,96 @@
+package indexcov
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// samples with less than this proportion of their data on the references used for
+// the cohort were likely aligned to a different genome.
+const minRefMatch = 0.5
+
+// matchReferences returns the proportion of the data in the index that is on the given
+// references. When the sample's own references are known from its header, the sizes are
+// re-ordered to match refs by name and length and data on other contigs is dropped.
+// Without a header, data on reference ids past the end of refs or past the end of a
+// chromosome is counted as not matching. Names are matched with al.
+func (x *Index) matchReferences(refs []*sam.Reference, al *chromAliases) float64 {
+	var total, matched int64
+	if x.refs == nil {
+		for id, sizes := range x.sizes {
+			n := 0
+			if id < len(refs) {
+				n = refs[id].Len()/TileWidth + 1
+			}
+			for i, s := range sizes {
+				total += s
+				if i < n {
+					matched += s
+				}
+			}
+		}
+	} else {
+		ids := make(map[string]int, len(refs))
+		for _, r := range refs {
+			ids[refKey(al, r)] = r.ID()
+		}
+		remapped := make([][]int64, len(refs))
+		for k, r := range x.refs {
+			if k >= len(x.sizes) {
+				break
+			}
+			sum := int64(0)
+			for _, s := range x.sizes[k] {
+				sum += s
+			}
+			total += sum
+			if id, ok := ids[refKey(al, r)]; ok && id < len(remapped) {
+				remapped[id] = x.sizes[k]
+				matched += sum
+			}
+		}
+		for id := range remapped {
+			if remapped[id] == nil {
+				remapped[id] = make([]int64, 0)
+			}
+		}
+		x.sizes = remapped
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(matched) / float64(total)
+}
+
+// refKey identifies a reference by name and length. Names are matched with the
+// chromosome aliases in al and a leading "chr" is ignored so that samples aligned to UCSC
+// and Ensembl/NCBI style builds still match.
+func refKey(al *chromAliases, r *sam.Reference) string {
+	return fmt.Sprintf("%s:%d", strings.TrimPrefix(al.key(r.Name()), "chr"), r.Len())
+}
+
+// matchSamples reports samples where most of the data is on contigs that are not in
+// refs, e.g. a mouse sample in a human cohort, as these would otherwise show as a
+// sample with no coverage. Depths from indexcov output and other sources are taken
+// to be on the cohort references.
+func matchSamples(idxs []DepthSource, names []string, refs []*sam.Reference, al *chromAliases) []float64 {
+	matches := make([]float64, len(idxs))
+	for i, src := range idxs {
+		switch idx := src.(type) {
+		case *Index:
+			matches[i] = idx.matchReferences(refs, al)
+		case *depthFile:
+			matches[i] = idx.match
+		default:
+			matches[i] = 1
+		}
+		if matches[i] < minRefMatch {
+			log.Printf("indexcov: WARNING: sample %s has only %.1f%% of its data on the chromosomes used for the cohort. was it aligned to a different genome?",
+				names[i], 100*matches[i])
+		}
+	}
+	return matches
+}
@@ -0,0 +1,93 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// refRange is a published or site-specific reference interval for a metric in the ped file.
+// Unlike the cutoffs from --suggest-thresholds, these do not depend on the cohort.
+type refRange struct {
+	metric string
+	lo, hi float64
+}
+
+func (r refRange) contains(v float64) bool {
+	return math.IsNaN(v) || (v >= r.lo && v <= r.hi)
+}
+
+// readReferenceRanges reads a tab-delimited file of metric, low, high. Either bound can be
+// "NA" or empty to leave that side open. Lines starting with '#' are ignored.
+func readReferenceRanges(path string) ([]refRange, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	var ranges []refRange
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' {
+			toks := strings.Split(line, "\t")
+			if len(toks) != 3 {
+				return nil, fmt.Errorf("expected 3 columns (metric, low, high) at line %d of %s, got %d", i, path, len(toks))
+			}
+			r := refRange{metric: toks[0]}
+			if r.lo, err = parseBound(toks[1], math.Inf(-1)); err != nil {
+				return nil, fmt.Errorf("bad low value at line %d of %s: %s", i, path, err)
+			}
+			if r.hi, err = parseBound(toks[2], math.Inf(1)); err != nil {
+				return nil, fmt.Errorf("bad high value at line %d of %s: %s", i, path, err)
+			}
+			ranges = append(ranges, r)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return ranges, nil
+}
+
+func parseBound(s string, open float64) (float64, error) {
+	if s == "" || s == "NA" {
+		return open, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// outsideReference returns, for each sample, a comma-delimited list of the metrics outside of
+// the reference interval (or "." if all are inside) and a bool indicating if any were outside.
+func outsideReference(t *sampleTable, ranges []refRange) ([]string, []bool, error) {
+	flags := make([][]string, len(t.rows))
+	for _, r := range ranges {
+		vals, err := t.floats(r.metric)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, v := range vals {
+			if !r.contains(v) {
+				flags[i] = append(flags[i], r.metric)
+			}
+		}
+	}
+	out := make([]string, len(flags))
+	outside := make([]bool, len(flags))
+	for i, f := range flags {
+		out[i] = "."
+		if len(f) > 0 {
+			out[i] = strings.Join(f, ",")
+			outside[i] = true
+		}
+	}
+	return out, outside, nil
+}
@@ -0,0 +1,234 @@
+package indexcov
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brentp/xopen"
+)
+
+// remote files are read over http(s). s3:// and gs:// paths are converted to https urls.
+// Only the index and, for bams, the header are read so the alignments are never downloaded.
+
+var httpClient = &http.Client{Timeout: 10 * time.Minute}
+
+// the header of a remote bam is read in chunks of this size.
+const rangeChunk = 256 * 1024
+
+func isRemote(path string) bool {
+	for _, p := range []string{"http://", "https://", "s3://", "gs://"} {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// openFile opens a local or remote file for reading.
+// The bytes read are counted for the summary at the end of a run.
+func openFile(path string) (io.ReadCloser, error) {
+	if !isRemote(path) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &countedReader{ReadCloser: f, path: path}, nil
+	}
+	resp, err := remoteGet(path, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("indexcov: error reading %s: %s", path, resp.Status)
+	}
+	return &countedReader{ReadCloser: resp.Body, path: path}, nil
+}
+
+// openHeader opens a bam so that its header can be read. For remote bams, this uses
+// range requests so only the start of the file is fetched.
+func openHeader(path string) (io.ReadCloser, error) {
+	if !isRemote(path) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &countedReader{ReadCloser: f, path: path}, nil
+	}
+	return &countedReader{ReadCloser: &rangeReader{path: path}, path: path}, nil
+}
+
+// fileExists reports if the local or remote path exists.
+func fileExists(path string) bool {
+	if !isRemote(path) {
+		return xopen.Exists(path)
+	}
+	req, err := newRemoteRequest("HEAD", path)
+	if err != nil {
+		return false
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func remoteGet(path string, byteRange string) (*http.Response, error) {
+	req, err := newRemoteRequest("GET", path)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	return httpClient.Do(req)
+}
+
+// rangeReader reads a remote file in chunks using http range requests.
+type rangeReader struct {
+	path string
+	off  int64
+	buf  []byte
+	eof  bool
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.fetch(); err != nil {
+			return 0, err
+		}
+		if len(r.buf) == 0 {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *rangeReader) fetch() error {
+	resp, err := remoteGet(r.path, fmt.Sprintf("bytes=%d-%d", r.off, r.off+rangeChunk-1))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		r.eof = true
+		return nil
+	case http.StatusPartialContent:
+	default:
+		return fmt.Errorf("indexcov: range request for %s failed (server must support range requests): %s", r.path, resp.Status)
+	}
+	r.buf, err = ioutil.ReadAll(io.LimitReader(resp.Body, rangeChunk))
+	if err != nil {
+		return err
+	}
+	r.off += int64(len(r.buf))
+	r.eof = len(r.buf) < rangeChunk
+	return nil
+}
+
+func (r *rangeReader) Close() error { return nil }
+
+// newRemoteRequest creates a request for the path. gs:// urls use the token in $GCS_OAUTH_TOKEN
+// if it is set and s3:// urls are signed if $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY are set.
+// These are the same variables used by htslib.
+func newRemoteRequest(method, path string) (*http.Request, error) {
+	switch {
+	case strings.HasPrefix(path, "gs://"):
+		req, err := http.NewRequest(method, "https://storage.googleapis.com/"+path[len("gs://"):], nil)
+		if err != nil {
+			return nil, err
+		}
+		if tok := os.Getenv("GCS_OAUTH_TOKEN"); tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+		return req, nil
+	case strings.HasPrefix(path, "s3://"):
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		host := fmt.Sprintf("s3.%s.amazonaws.com", region)
+		req, err := http.NewRequest(method, "https://"+host+"/"+awsEscape(path[len("s3://"):]), nil)
+		if err != nil {
+			return nil, err
+		}
+		if key, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); key != "" && secret != "" {
+			signS3(req, key, secret, os.Getenv("AWS_SESSION_TOKEN"), region, time.Now().UTC())
+		}
+		return req, nil
+	}
+	return http.NewRequest(method, path, nil)
+}
+
+// awsEscape escapes a path as required for the canonical request of AWS signature version 4.
+func awsEscape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("-_.~/", c) != -1 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signS3 adds AWS signature version 4 headers to a request with no body.
+func signS3(req *http.Request, key, secret, token, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	// sha256 of the empty body.
+	payload := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payload)
+	headers := []string{"host:" + req.URL.Host, "x-amz-content-sha256:" + payload, "x-amz-date:" + amzDate}
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	if token != "" {
+		req.Header.Set("x-amz-security-token", token)
+		headers = append(headers, "x-amz-security-token:"+token)
+		signed += ";x-amz-security-token"
+	}
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), canonicalQuery(req.URL),
+		strings.Join(headers, "\n") + "\n", signed, payload}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + region + "/s3/aws4_request"
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(sum[:])}, "\n")
+
+	k := hmacSHA256([]byte("AWS4"+secret), day)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		key, scope, signed, hex.EncodeToString(hmacSHA256(k, toSign))))
+}
+
+func canonicalQuery(u *url.URL) string {
+	return strings.Replace(u.Query().Encode(), "+", "%20", -1)
+}
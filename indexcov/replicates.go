@@ -0,0 +1,235 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// tiles where |M| (the log2 ratio of the replicates) is above this are discordant. Tiles where both
+// replicates are below gapDepth are not used.
+const discordantM = 0.5
+
+// size and ranges of the MA plots. A is the mean log2 depth and M the log2 ratio.
+const (
+	maWidth, maHeight = 600, 400
+	maMinA, maMaxA    = -3.0, 3.0
+	maMaxM            = 2.0
+)
+
+// replicatePair is a pair of technical replicates with the M and A value of each usable tile.
+type replicatePair struct {
+	a, b int
+	m, A []float32
+	// sums for the correlation and concordance of the depths.
+	n                     float64
+	sx, sy, sxx, syy, sxy float64
+}
+
+// replicateChecker accumulates the depths of pairs of replicates as chromosomes are processed.
+type replicateChecker struct {
+	pairs []*replicatePair
+}
+
+// readReplicates reads a tab-delimited file of sample pairs that are replicates of the same library.
+// Samples are named by their pseudonyms in ps. Blank lines and those starting with # are ignored.
+func readReplicates(path string, samples []string, ps pseudonyms) (*replicateChecker, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	index := make(map[string]int, len(samples))
+	for i, s := range samples {
+		index[s] = i
+	}
+	rc := &replicateChecker{}
+	for i := 1; ; i++ {
+		line, err := rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if l := strings.TrimSpace(line); l != "" && l[0] != '#' {
+			toks := strings.Fields(l)
+			if len(toks) < 2 {
+				return nil, fmt.Errorf("indexcov: expected 2 samples at line %d of %s", i, path)
+			}
+			a, aok := index[ps.name(toks[0])]
+			b, bok := index[ps.name(toks[1])]
+			if !aok || !bok {
+				return nil, fmt.Errorf("indexcov: replicates %s and %s at line %d of %s are not both in the input", toks[0], toks[1], i, path)
+			}
+			if a == b {
+				return nil, fmt.Errorf("indexcov: sample %s is paired with itself at line %d of %s", toks[0], i, path)
+			}
+			rc.pairs = append(rc.pairs, &replicatePair{a: a, b: b})
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(rc.pairs) == 0 {
+		return nil, fmt.Errorf("indexcov: no replicate pairs found in %s", path)
+	}
+	return rc, nil
+}
+
+// add accumulates the unmasked tiles of each pair on a single autosome.
+func (rc *replicateChecker) add(depths [][]float32, longest int, mask []bool) {
+	for _, p := range rc.pairs {
+		da, db := depths[p.a], depths[p.b]
+		for i := 0; i < longest && i < len(da) && i < len(db); i++ {
+			x, y := float64(da[i]), float64(db[i])
+			if isMasked(mask, i) || (x < gapDepth && y < gapDepth) {
+				continue
+			}
+			p.n++
+			p.sx += x
+			p.sy += y
+			p.sxx += x * x
+			p.syy += y * y
+			p.sxy += x * y
+			// a small pseudo-count keeps tiles with no reads in one replicate at the edge of the plot.
+			lx, ly := math.Log2(x+0.01), math.Log2(y+0.01)
+			p.m = append(p.m, float32(lx-ly))
+			p.A = append(p.A, float32((lx+ly)/2))
+		}
+	}
+}
+
+// replicateStats are the concordance metrics of a pair.
+type replicateStats struct {
+	tiles int
+	// r is the pearson correlation and ccc is Lin's concordance correlation coefficient which is also
+	// lowered by a difference in scale between the replicates.
+	r, ccc float64
+	// medianM is the bias and madM the noise of the log2 ratio of the replicates.
+	medianM, madM float64
+	// discordant is the proportion of tiles with |M| > discordantM.
+	discordant float64
+}
+
+func (p *replicatePair) stats() replicateStats {
+	st := replicateStats{tiles: int(p.n), r: math.NaN(), ccc: math.NaN(), medianM: math.NaN(), madM: math.NaN(), discordant: math.NaN()}
+	if p.n < 2 {
+		return st
+	}
+	mx, my := p.sx/p.n, p.sy/p.n
+	vx, vy := p.sxx/p.n-mx*mx, p.syy/p.n-my*my
+	cov := p.sxy/p.n - mx*my
+	st.r = cov / math.Sqrt(vx*vy)
+	st.ccc = 2 * cov / (vx + vy + (mx-my)*(mx-my))
+
+	m := make([]float64, len(p.m))
+	nd := 0
+	for i, v := range p.m {
+		m[i] = float64(v)
+		if math.Abs(m[i]) > discordantM {
+			nd++
+		}
+	}
+	sort.Float64s(m)
+	st.medianM = m[len(m)/2]
+	dev := make([]float64, len(m))
+	for i, v := range m {
+		dev[i] = math.Abs(v - st.medianM)
+	}
+	sort.Float64s(dev)
+	st.madM = dev[len(dev)/2]
+	st.discordant = float64(nd) / float64(len(m))
+	return st
+}
+
+// maPlot draws the MA plot of a pair as a density image: darker pixels have more tiles. The blue
+// line is M=0 and the red line is the median M.
+func (p *replicatePair) maPlot(path string, medianM float64) error {
+	counts := make([]int, maWidth*maHeight)
+	most := 0
+	for i, m := range p.m {
+		x := int((float64(p.A[i]) - maMinA) / (maMaxA - maMinA) * maWidth)
+		y := int((maMaxM - float64(m)) / (2 * maMaxM) * maHeight)
+		if x < 0 || x >= maWidth || y < 0 || y >= maHeight {
+			continue
+		}
+		counts[y*maWidth+x]++
+		if c := counts[y*maWidth+x]; c > most {
+			most = c
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, maWidth, maHeight))
+	for y := 0; y < maHeight; y++ {
+		for x := 0; x < maWidth; x++ {
+			v := uint8(255)
+			if c := counts[y*maWidth+x]; c > 0 {
+				v = uint8(220 - 220*math.Log1p(float64(c))/math.Log1p(float64(most)))
+			}
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	line := func(m float64, c color.RGBA) {
+		if y := int((maMaxM - m) / (2 * maMaxM) * maHeight); y >= 0 && y < maHeight {
+			for x := 0; x < maWidth; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	line(0, color.RGBA{0, 0, 255, 255})
+	if !math.IsNaN(medianM) {
+		line(medianM, color.RGBA{255, 0, 0, 255})
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// write writes the metrics of each pair to $base-replicates.tsv and an MA plot of each pair to
+// $base-replicate-$n.png where n is the 1-based row of the pair.
+func (rc *replicateChecker) write(base string, samples []string) error {
+	f, err := os.Create(base + "-replicates.tsv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#sample_a\tsample_b\ttiles\tr\tccc\tmedian.M\tmad.M\tp.discordant\tpng")
+	cccs := make([]float64, 0, len(rc.pairs))
+	for i, p := range rc.pairs {
+		st := p.stats()
+		if !math.IsNaN(st.ccc) {
+			cccs = append(cccs, st.ccc)
+		}
+		pngPath := fmt.Sprintf("%s-replicate-%d.png", base, i+1)
+		if err := p.maPlot(pngPath, st.medianM); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%d\t%.4f\t%.4f\t%.3f\t%.3f\t%.4f\t%s\n", samples[p.a], samples[p.b], st.tiles,
+			st.r, st.ccc, st.medianM, st.madM, st.discordant, filepath.Base(pngPath)); err != nil {
+			return err
+		}
+	}
+	if len(cccs) > 0 {
+		sort.Float64s(cccs)
+		log.Printf("indexcov: %d replicate pairs. concordance (ccc) median: %.4f min: %.4f", len(rc.pairs), cccs[len(cccs)/2], cccs[0])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,381 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft"
+	"github.com/brentp/xopen"
+)
+
+var replotCli = &struct {
+	Directory string   `arg:"-d,required,help:directory for output files"`
+	IncludeGL bool     `arg:"-e,help:plot GL chromosomes like: GL000201.1 which are not plotted by default"`
+	Sex       string   `arg:"-X,help:comma delimited names of the sex chromosome(s) used to infer sex. Set to '' if no sex chromosomes are present."`
+	Drop      string   `arg:"help:comma-delimited names of samples to leave out"`
+	AliasFile string   `arg:"--alias-file,help:tab-delimited file with the names of a chromosome on each line used to match chromosomes across beds"`
+	Cytobands string   `arg:"help:UCSC cytoBand file used to label the depth plots with bands"`
+	Window    int      `arg:"help:length of the bins used for all output. a multiple of the bins in the beds. default is the bins of the beds"`
+	Metadata  string   `arg:"help:optional tab-delimited file with a header and sample_id in the first column. Columns can be used in --plot"`
+	Plot      []string `arg:"help:extra scatter plot(s) of ped or metadata columns given as comma-delimited x=COL and y=COL and optional color=COL"`
+	JSON      bool     `arg:"help:write the values in the ped file and a summary of each chromosome to $prefix-indexcov-report.json"`
+	TSV       bool     `arg:"help:write the values in the ped file and a summary of each chromosome to $prefix-indexcov-report.tsv"`
+	Precision int      `arg:"help:number of significant digits (2 or 3 or 4) written for depths in the bed.gz"`
+	Matrix    bool     `arg:"help:also write the depths to $prefix-indexcov.matrix for fast reads by indexcov-lookup and indexcov-serve"`
+	FailOn    string   `arg:"--fail-on,help:exit with status 10 if any sample fails qc (fail) or fails or has a warning (warn). never always exits 0 after a run"`
+	Examples  bool     `arg:"help:print detailed usage with examples and the columns of each output file"`
+
+	DosageReference string `arg:"--dosage-reference,help:percentiles of the dosage of each autosome in a reference cohort. each sample's percentiles are written to $prefix-indexcov-dosage.tsv"`
+	DosageRefOut    string `arg:"--dosage-reference-out,help:write the percentiles of the dosage of each autosome in these beds to this file for use with --dosage-reference"`
+	SampleMap       string `arg:"--sample-map,help:tab-delimited file of a sample name in the beds and the name to use for it in all output. --drop uses the names in the beds"`
+	Anonymize       string `arg:"help:file of sample names and pseudonyms (created readable only by you and extended with random pseudonyms for new samples). all output uses the pseudonyms"`
+	Project         string `arg:"help:loadings (from --loadings or indexcov-gather) of a reference panel of the same build. samples are projected onto its PC1 and PC2 as ref.PC1 and ref.PC2"`
+	Genome          string `arg:"help:bundled genome (GRCh37 or GRCh38 or an alias like hg38) whose PARs and arms and blacklist and chromosome aliases are used"`
+	GenomeFile      string `arg:"--genome-file,help:JSON file in the format of the goleft genomes package for a custom build or organism. used in place of --genome"`
+
+	LockWait time.Duration `arg:"--lock-wait,help:how long to wait for another run with the same output prefix to finish (e.g. 30m). by default exit with 30 at once"`
+
+	Beds []string `arg:"positional,required,help:$prefix-indexcov.bed.gz (or $prefix-indexcov.matrix) file(s) from previous runs. samples from all files are merged"`
+}{Sex: defaultSex, Precision: 3, FailOn: "never"}
+
+// ReplotMain is called from the goleft dispatcher as indexcov-replot. It redoes the
+// output of indexcov from existing bed.gz files without re-reading the indexes.
+func ReplotMain() {
+	if wantsExamples(os.Args[1:]) {
+		if err := replotCommand.write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	p := arg.MustParse(replotCli)
+	failOn, err := goleft.ParseFailOn(replotCli.FailOn)
+	if err != nil {
+		p.Fail("indexcov-replot: " + err.Error())
+	}
+	opts := Options{
+		Directory: replotCli.Directory,
+		FromBeds:  replotCli.Beds,
+		AliasFile: replotCli.AliasFile,
+		Cytobands: replotCli.Cytobands,
+		Window:    replotCli.Window,
+		IncludeGL: replotCli.IncludeGL,
+		Metadata:  replotCli.Metadata,
+		Plot:      replotCli.Plot,
+		JSON:      replotCli.JSON,
+		TSV:       replotCli.TSV,
+		Precision: replotCli.Precision,
+		Matrix:    replotCli.Matrix,
+
+		DosageReference: replotCli.DosageReference,
+		DosageRefOut:    replotCli.DosageRefOut,
+		SampleMap:       replotCli.SampleMap,
+		Anonymize:       replotCli.Anonymize,
+		Project:         replotCli.Project,
+		Genome:          replotCli.Genome,
+		GenomeFile:      replotCli.GenomeFile,
+		LockWait:        replotCli.LockWait,
+	}
+	if replotCli.Precision < 2 || replotCli.Precision > 4 {
+		p.Fail("indexcov-replot: --precision must be 2, 3 or 4")
+	}
+	if opts.Sex, err = genomeSex(replotCli.Sex, replotCli.Genome, replotCli.GenomeFile); err != nil {
+		p.Fail("indexcov-replot: " + err.Error())
+	}
+	if replotCli.Drop != "" {
+		opts.Drop = strings.Split(replotCli.Drop, ",")
+	}
+	for _, b := range opts.FromBeds {
+		if abs, _ := filepath.Abs(b); abs == mustAbs(opts.base()+".bed.gz") || abs == mustAbs(opts.base()+".matrix") {
+			p.Fail(fmt.Sprintf("indexcov-replot: %s would be overwritten. use a different --directory", b))
+		}
+	}
+	envPlotOptions(&opts)
+	res, err := Run(opts)
+	if err != nil {
+		goleft.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "indexcov-replot finished: see %s for overview of output\n", res.IndexHTML)
+	if len(res.Warned) > 0 {
+		fmt.Fprintf(os.Stderr, "indexcov-replot: %d of %d samples have qc warnings\n", len(res.Warned), len(res.Samples))
+	}
+	os.Exit(failOn.Status(len(res.Failed), len(res.Warned)))
+}
+
+func mustAbs(p string) string {
+	abs, _ := filepath.Abs(p)
+	return abs
+}
+
+// bedSource reads the scaled depths of all samples in a bed.gz written by indexcov one
+// chromosome at a time so that large cohorts don't need to fit in memory. Chromosomes must
+// be requested in the order of the file.
+type bedSource struct {
+	path    string
+	samples []string
+	// number of columns before the samples. 4 if the bed has an excluded column.
+	skip int
+	// width is the length of the bins from the first line.
+	width int
+	// chromosomes in the file by their key in aliases.
+	chroms  map[string]bool
+	aliases *chromAliases
+	// refs are the references used for the cohort and give the names for reference ids.
+	refs []*sam.Reference
+
+	mu sync.Mutex
+	// err is the first error from reading the depths.
+	err   error
+	rdr   io.ReadCloser
+	br    *bufio.Reader
+	next  []string
+	chrom string
+	block [][]float32
+}
+
+// scanBed reads the header of the bed and the extent of each chromosome. Chromosomes are matched with al.
+func scanBed(path string, al *chromAliases) (*bedSource, []*sam.Reference, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rdr.Close()
+	br := bufio.NewReaderSize(rdr, 1<<20)
+	src := &bedSource{path: path, skip: 3, chroms: make(map[string]bool), aliases: al}
+	var order []string
+	ends := make(map[string]int)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			if line[0] == '#' {
+				toks := strings.Split(line, "\t")
+				if len(toks) > 3 && toks[3] == "excluded" {
+					src.skip = 4
+				}
+				src.samples = toks[src.skip:]
+			} else {
+				toks := strings.SplitN(line, "\t", 4)
+				if len(toks) < 4 {
+					return nil, nil, fmt.Errorf("indexcov: unexpected line in %s: %.50s", path, line)
+				}
+				if !src.chroms[al.key(toks[0])] {
+					src.chroms[al.key(toks[0])] = true
+					order = append(order, toks[0])
+				}
+				end, err := strconv.Atoi(toks[2])
+				if err != nil {
+					return nil, nil, fmt.Errorf("indexcov: bad end in %s: %s", path, err)
+				}
+				if src.width == 0 {
+					start, err := strconv.Atoi(toks[1])
+					if err != nil || end <= start || start%(end-start) != 0 {
+						return nil, nil, fmt.Errorf("indexcov: bad bin at first line of %s: %.50s", path, line)
+					}
+					src.width = end - start
+				}
+				ends[toks[0]] = end
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if src.samples == nil {
+		return nil, nil, fmt.Errorf("indexcov: no header found in %s. is it from indexcov?", path)
+	}
+	if src.width == 0 {
+		src.width = TileWidth
+	}
+	refs := make([]*sam.Reference, 0, len(order))
+	for _, c := range order {
+		ref, err := sam.NewReference(c, "", "", ends[c], nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		refs = append(refs, ref)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, h.Refs(), nil
+}
+
+// depths returns a copy of the depths of sample column col for the reference with the given id.
+func (s *bedSource) depths(refID int, col int) ([]float32, error) {
+	if refID >= len(s.refs) || !s.chroms[s.aliases.key(s.refs[refID].Name())] {
+		return make([]float32, 0), nil
+	}
+	chrom := s.refs[refID].Name()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.chrom != chrom {
+		if s.err = s.advance(chrom); s.err != nil {
+			return nil, s.err
+		}
+	}
+	return append([]float32(nil), s.block[col]...), nil
+}
+
+// advance reads through the bed until the block for chrom is loaded.
+func (s *bedSource) advance(chrom string) error {
+	if s.br == nil {
+		rdr, err := xopen.Ropen(s.path)
+		if err != nil {
+			return err
+		}
+		s.rdr, s.br = rdr, bufio.NewReaderSize(rdr, 1<<20)
+	}
+	s.chrom, s.block = "", make([][]float32, len(s.samples))
+	for {
+		toks := s.next
+		s.next = nil
+		if toks == nil {
+			line, err := s.br.ReadString('\n')
+			if err == io.EOF && line == "" {
+				s.rdr.Close()
+				if s.chrom == chrom {
+					return nil
+				}
+				return fmt.Errorf("indexcov: %s not found in %s. chromosomes must be in the same order in all beds", chrom, s.path)
+			}
+			if err != nil && err != io.EOF {
+				return err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			toks = strings.Split(line, "\t")
+		}
+		if s.chrom != "" && !s.aliases.same(toks[0], s.chrom) {
+			// start of the next chromosome.
+			s.next = toks
+			return nil
+		}
+		if !s.aliases.same(toks[0], chrom) {
+			continue
+		}
+		s.chrom = chrom
+		if len(toks) != s.skip+len(s.samples) {
+			return fmt.Errorf("indexcov: expected %d columns in %s, got %d", s.skip+len(s.samples), s.path, len(toks))
+		}
+		for k, t := range toks[s.skip:] {
+			v, err := strconv.ParseFloat(t, 32)
+			if err != nil {
+				return fmt.Errorf("indexcov: bad depth in %s: %s", s.path, err)
+			}
+			s.block[k] = append(s.block[k], float32(v))
+		}
+	}
+}
+
+// bedSample is the DepthSource for a single sample column of a bed.gz.
+type bedSample struct {
+	src *bedSource
+	col int
+}
+
+// NormalizedDepth implements DepthSource. Errors are reported after the run with bedError.
+func (b *bedSample) NormalizedDepth(refID int) []float32 {
+	d, _ := b.src.depths(refID, b.col)
+	return d
+}
+
+// bedError returns the first error from reading depths from a bed or a matrix.
+func bedError(idxs []DepthSource) error {
+	for _, idx := range idxs {
+		switch b := idx.(type) {
+		case *bedSample:
+			if b.src.err != nil {
+				return b.src.err
+			}
+		case *matrixSample:
+			if err := b.src.error(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cohortFile is a bed.gz or a matrix from a previous run.
+type cohortFile interface {
+	names() []string
+	binWidth() int
+	// setRefs sets the references of the cohort that give the names of the ids used by the samples.
+	setRefs([]*sam.Reference)
+	sample(col int) DepthSource
+}
+
+func (s *bedSource) names() []string               { return s.samples }
+func (s *bedSource) binWidth() int                 { return s.width }
+func (s *bedSource) setRefs(refs []*sam.Reference) { s.refs = refs }
+func (s *bedSource) sample(col int) DepthSource    { return &bedSample{src: s, col: col} }
+
+// readBeds returns a DepthSource for each sample in the beds (or matrix files from --matrix) so they can
+// be used in place of indexes. The references are taken from the first bed. Samples in drop are left out.
+// The bins of all beds must have the same width, which is returned. Chromosomes are matched with al.
+func readBeds(paths []string, drop []string, al *chromAliases) ([]*sam.Reference, []DepthSource, []string, int, error) {
+	dropped := make(map[string]bool, len(drop))
+	for _, d := range drop {
+		dropped[strings.TrimSpace(d)] = true
+	}
+	found := make(map[string]bool, len(drop))
+	var refs []*sam.Reference
+	var idxs []DepthSource
+	var names []string
+	var width int
+	for i, p := range paths {
+		var src cohortFile
+		var brefs []*sam.Reference
+		var err error
+		if strings.HasSuffix(p, ".matrix") {
+			src, brefs, err = scanMatrix(p, al)
+		} else {
+			src, brefs, err = scanBed(p, al)
+		}
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
+		if i == 0 {
+			refs, width = brefs, src.binWidth()
+		} else if src.binWidth() != width {
+			return nil, nil, nil, 0, fmt.Errorf("indexcov: bins of %d in %s differ from %d in %s", src.binWidth(), p, width, paths[0])
+		}
+		src.setRefs(refs)
+		for col, sample := range src.names() {
+			if dropped[sample] {
+				found[sample] = true
+				continue
+			}
+			idxs = append(idxs, src.sample(col))
+			names = append(names, sample)
+		}
+	}
+	for d := range dropped {
+		if found[d] {
+			continue
+		}
+		log.Printf("indexcov: WARNING: sample %s given to drop was not found", d)
+	}
+	if len(idxs) == 0 {
+		return nil, nil, nil, 0, fmt.Errorf("indexcov: no samples found in %s", strings.Join(paths, ","))
+	}
+	return refs, idxs, names, width, nil
+}
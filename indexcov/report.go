@@ -0,0 +1,238 @@
+package indexcov
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/goleft"
+)
+
+// report collects the values in the ped file and a summary of the ROC of each chromosome
+// for machine-readable output with --json and --tsv.
+type report struct {
+	samples []string
+	chroms  []string
+	// rocs[i][k] is the summary for chromosome i and sample k.
+	rocs [][]rocSummary
+	// cns[chrom][k] is the copy-number with its bootstrap interval. It is only set with --bootstrap.
+	cns map[string][]cnInterval
+}
+
+// rocSummary gives the proportion of 16KB tiles of a chromosome that are low (< 0.15), inside
+// (0.85, 1.15) and high (> 1.15). These match the bins.* columns in the ped file.
+type rocSummary struct {
+	Lo jsonFloat `json:"p.lo"`
+	In jsonFloat `json:"p.in"`
+	Hi jsonFloat `json:"p.hi"`
+}
+
+// jsonFloat is written as null when it is NaN or Inf which are not valid JSON.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(v, 'f', -1, 64)), nil
+}
+
+func newReport(samples []string) *report {
+	return &report{samples: samples, cns: make(map[string][]cnInterval)}
+}
+
+func (r *report) addCNs(chrom string, cis []cnInterval) {
+	r.cns[chrom] = cis
+}
+
+// rocAt returns the proportion of tiles with a scaled depth of at least d.
+func rocAt(roc []float32, d float64) float64 {
+	return float64(roc[tint(float32(d*slots*slotsMid)+0.5)])
+}
+
+// round4 avoids writing float32 noise like 0.10000002.
+func round4(v float64) jsonFloat {
+	return jsonFloat(math.Round(v*1e4) / 1e4)
+}
+
+func (r *report) addROCs(chrom string, rocs [][]float32) {
+	sums := make([]rocSummary, len(rocs))
+	for k, roc := range rocs {
+		lo, in, hi := rocAt(roc, 0.15), rocAt(roc, 0.85), rocAt(roc, 1.15)
+		sums[k] = rocSummary{Lo: round4(1 - lo), In: round4(in - hi), Hi: round4(hi)}
+	}
+	r.chroms = append(r.chroms, chrom)
+	r.rocs = append(r.rocs, sums)
+}
+
+// pedIDs are the ped columns that are placeholders and are not included in the report.
+var pedIDs = map[string]bool{"family_id": true, "paternal_id": true, "maternal_id": true, "phenotype": true}
+
+type reportSample struct {
+	Sample string               `json:"sample"`
+	Sex    int                  `json:"sex"`
+	CN     map[string]jsonFloat `json:"cn"`
+	// CNInterval is the 95% bootstrap interval of each value in CN with --bootstrap.
+	CNInterval map[string][2]jsonFloat `json:"cn_interval,omitempty"`
+	Bins       map[string]int          `json:"bins"`
+	Slope      jsonFloat               `json:"slope"`
+	POut       jsonFloat               `json:"p.out"`
+	PCs        []jsonFloat             `json:"pcs"`
+	// Other holds the remaining ped columns, e.g. qc, mapped and ref.match.
+	Other map[string]interface{} `json:"other,omitempty"`
+	ROC   map[string]rocSummary  `json:"roc"`
+	// ChromCN is the copy-number and bootstrap interval of each chromosome with --bootstrap.
+	ChromCN map[string]cnInterval `json:"chrom_cn,omitempty"`
+}
+
+func parseFloat(s string) jsonFloat {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return jsonFloat(math.NaN())
+	}
+	return jsonFloat(v)
+}
+
+// writeJSON writes the ped table and ROC summaries as a JSON object to path.
+func (r *report) writeJSON(path string, t *sampleTable, sexChroms []string, al *chromAliases) error {
+	index := make(map[string]int, len(r.samples))
+	for k, s := range r.samples {
+		index[s] = k
+	}
+	out := struct {
+		Version   string         `json:"version"`
+		SexChroms []string       `json:"sex_chroms"`
+		Chroms    []string       `json:"chroms"`
+		Samples   []reportSample `json:"samples"`
+	}{Version: goleft.Version, SexChroms: sexChroms, Chroms: r.chroms}
+	if out.Chroms == nil {
+		out.Chroms = []string{}
+	}
+
+	for i, row := range t.rows {
+		s := reportSample{Sample: t.samples[i], CN: make(map[string]jsonFloat), Bins: make(map[string]int),
+			PCs: []jsonFloat{}, Other: make(map[string]interface{}), ROC: make(map[string]rocSummary)}
+		for j, col := range t.columns {
+			if j >= len(row) || pedIDs[col] || col == "sample_id" {
+				continue
+			}
+			v := row[j]
+			switch {
+			case col == "sex":
+				s.Sex, _ = strconv.Atoi(v)
+			case strings.HasPrefix(col, "CN") && sameChrom(al, sexChroms, col[2:]):
+				s.CN[col[2:]] = parseFloat(v)
+			case strings.HasPrefix(col, "CN") && (strings.HasSuffix(col, ".lo") || strings.HasSuffix(col, ".hi")) &&
+				sameChrom(al, sexChroms, col[2:len(col)-3]):
+				if s.CNInterval == nil {
+					s.CNInterval = make(map[string][2]jsonFloat)
+				}
+				ci := s.CNInterval[col[2:len(col)-3]]
+				if strings.HasSuffix(col, ".lo") {
+					ci[0] = parseFloat(v)
+				} else {
+					ci[1] = parseFloat(v)
+				}
+				s.CNInterval[col[2:len(col)-3]] = ci
+			case strings.HasPrefix(col, "bins."):
+				s.Bins[col[5:]], _ = strconv.Atoi(v)
+			case col == "slope":
+				s.Slope = parseFloat(v)
+			case col == "p.out":
+				s.POut = parseFloat(v)
+			case strings.HasPrefix(col, "PC"):
+				s.PCs = append(s.PCs, parseFloat(v))
+			default:
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					s.Other[col] = jsonFloat(f)
+				} else {
+					s.Other[col] = v
+				}
+			}
+		}
+		if k, ok := index[t.samples[i]]; ok {
+			for c, chrom := range r.chroms {
+				s.ROC[chrom] = r.rocs[c][k]
+			}
+			for chrom, cis := range r.cns {
+				if s.ChromCN == nil {
+					s.ChromCN = make(map[string]cnInterval)
+				}
+				s.ChromCN[chrom] = cis[k]
+			}
+		}
+		out.Samples = append(out.Samples, s)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeTSV writes a row per sample with the ped columns followed by the ROC summary
+// of each chromosome as $chrom.p.lo, $chrom.p.in and $chrom.p.hi.
+func (r *report) writeTSV(path string, t *sampleTable) error {
+	index := make(map[string]int, len(r.samples))
+	for k, s := range r.samples {
+		index[s] = k
+	}
+	var cols []int
+	hdr := make([]string, 0, len(t.columns)+3*len(r.chroms))
+	for j, col := range t.columns {
+		if !pedIDs[col] {
+			cols = append(cols, j)
+			hdr = append(hdr, col)
+		}
+	}
+	for _, chrom := range r.chroms {
+		hdr = append(hdr, chrom+".p.lo", chrom+".p.in", chrom+".p.hi")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "#%s\n", strings.Join(hdr, "\t"))
+	vals := make([]string, 0, len(hdr))
+	for i, row := range t.rows {
+		vals = vals[:0]
+		for _, j := range cols {
+			if j < len(row) {
+				vals = append(vals, row[j])
+			} else {
+				vals = append(vals, "NA")
+			}
+		}
+		k, ok := index[t.samples[i]]
+		for c := range r.chroms {
+			if !ok {
+				vals = append(vals, "NA", "NA", "NA")
+				continue
+			}
+			s := r.rocs[c][k]
+			vals = append(vals, fmt.Sprintf("%.4f", s.Lo), fmt.Sprintf("%.4f", s.In), fmt.Sprintf("%.4f", s.Hi))
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(vals, "\t")); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,79 @@
+package indexcov
+
+import "math"
+
+// The depths of a DepthSource are for bins of a fixed width starting at 0. Interpolate, MeanDepth and
+// Resample give values at other positions and windows so that they can be compared with the output
+// of tools that use a different grid (e.g. 100KB bins or 1-based windows). The depth is taken as a
+// line between the centers of adjacent bins, as the value of the first or last bin before or after
+// their centers and as 0 past the end of the last bin.
+
+// interpolate returns the depth at x which must be less than the end of the last bin.
+func interpolate(depths []float32, width int, x float64) float64 {
+	t := x/float64(width) - 0.5
+	if t <= 0 {
+		return float64(depths[0])
+	}
+	if t >= float64(len(depths)-1) {
+		return float64(depths[len(depths)-1])
+	}
+	i := int(t)
+	f := t - float64(i)
+	return (1-f)*float64(depths[i]) + f*float64(depths[i+1])
+}
+
+// Interpolate returns the depth at pos from the depths of bins of width bases, e.g. from
+// NormalizedDepth with TileWidth.
+func Interpolate(depths []float32, width int, pos int) float32 {
+	if pos < 0 || width <= 0 || pos >= len(depths)*width {
+		return 0
+	}
+	return float32(interpolate(depths, width, float64(pos)))
+}
+
+// MeanDepth returns the mean of the interpolated depths in the window [start, end). Windows
+// smaller than a bin follow the line between bin centers while larger ones are close to the mean
+// of the bins they overlap weighted by the overlap.
+func MeanDepth(depths []float32, width int, start, end int) float32 {
+	if start < 0 {
+		start = 0
+	}
+	if end <= start || width <= 0 || len(depths) == 0 {
+		return 0
+	}
+	w := float64(width)
+	s, e := float64(start), math.Min(float64(end), float64(len(depths))*w)
+	// the depth is linear between bin centers so the integral of each piece is exact.
+	var sum float64
+	k := int(math.Floor(s/w-0.5)) + 1
+	if k < 0 {
+		k = 0
+	}
+	for a := s; a < e; k++ {
+		b := e
+		if k < len(depths) {
+			if c := (float64(k) + 0.5) * w; c < e {
+				b = c
+			}
+		}
+		sum += (b - a) * (interpolate(depths, width, a) + interpolate(depths, width, b)) / 2
+		a = b
+	}
+	return float32(sum / float64(end-start))
+}
+
+// Resample returns the MeanDepth of each window of size bases from 0 to length. The last window
+// ends at length. If length is 0, the windows cover all of the bins.
+func Resample(depths []float32, width int, size int, length int) []float32 {
+	if size <= 0 {
+		return nil
+	}
+	if length <= 0 {
+		length = len(depths) * width
+	}
+	out := make([]float32, (length+size-1)/size)
+	for i := range out {
+		out[i] = MeanDepth(depths, width, i*size, imin((i+1)*size, length))
+	}
+	return out
+}
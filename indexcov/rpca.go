@@ -0,0 +1,346 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"gonum.org/v1/gonum/mat"
+)
+
+// extra random vectors used beyond the number of components to improve the accuracy of the
+// randomized PCA.
+const pcaOversample = 10
+
+// number of power iterations of the randomized PCA. These help when the singular values decay slowly.
+const pcaPowerIters = 3
+
+// columns of the uint8 matrix are processed in chunks of this many by each goroutine.
+const pcaChunk = 4096
+
+// pcaTile is the position of a column of the PCA matrix.
+type pcaTile struct {
+	chrom string
+	tile  int
+}
+
+// pcaFit holds the column means and loadings of a PCA so that other samples can be projected
+// into the same space.
+type pcaFit struct {
+	means []float64
+	// weights scale each centered tile before the PCA. nil is unweighted.
+	weights []float64
+	// loadings is tiles × components.
+	loadings *mat.Dense
+	// vars is the proportion of the variance explained by each component.
+	vars []float64
+}
+
+// colStats returns the mean and variance of each column of x.
+func colStats(x [][]uint8, processes int) ([]float64, []float64) {
+	m := len(x[0])
+	sums := make([]float64, m)
+	sqs := make([]float64, m)
+	nChunks := (m + pcaChunk - 1) / pcaChunk
+	parallel(nChunks, processes, func(c int) {
+		j0, j1 := c*pcaChunk, imin((c+1)*pcaChunk, m)
+		for _, row := range x {
+			for j, v := range row[j0:j1] {
+				sums[j0+j] += float64(v)
+				sqs[j0+j] += float64(v) * float64(v)
+			}
+		}
+	})
+	n := float64(len(x))
+	for j := range sums {
+		sums[j] /= n
+		sqs[j] = math.Max(0, sqs[j]/n-sums[j]*sums[j])
+	}
+	return sums, sqs
+}
+
+// pcaChrom returns true if the tiles of chrom are used in the PCA. The sex chromosomes are left
+// out unless Options.PCAIncludeSex is set since they separate the samples by sex.
+func pcaChrom(opts *Options, chrom string) bool {
+	if sameChrom(opts.aliasTable(), opts.PCAExclude, chrom) {
+		return false
+	}
+	return opts.PCAIncludeSex || !sameChrom(opts.aliasTable(), opts.Sex, chrom)
+}
+
+// pcaWeights returns the weight of each tile in the PCA for Options.PCAWeight. With "variance",
+// tiles are scaled to unit variance so that each contributes equally. With "mappability", each
+// tile is scaled by its mappability from the --gc track so that tiles that are hard to map
+// contribute less. mapp is indexed by reference id.
+func pcaWeights(opts *Options, x [][]uint8, tiles []pcaTile, refs []*sam.Reference, mapp [][]float32) ([]float64, error) {
+	switch opts.PCAWeight {
+	case "", "none":
+		return nil, nil
+	case "variance":
+		if len(x) == 0 {
+			return nil, nil
+		}
+		_, vars := colStats(x, opts.processes())
+		w := make([]float64, len(vars))
+		for j, v := range vars {
+			if v > 0 {
+				w[j] = 1 / math.Sqrt(v)
+			}
+		}
+		return w, nil
+	case "mappability":
+		ids := make(map[string]int, len(refs))
+		for _, r := range refs {
+			ids[r.Name()] = r.ID()
+		}
+		// with --window, the weight of a bin is the mean mappability of its tiles.
+		n := opts.window() / TileWidth
+		w := make([]float64, len(tiles))
+		found := false
+		for j, t := range tiles {
+			m := mapp[ids[t.chrom]]
+			var sum float64
+			var k int
+			for i := t.tile * n; i < (t.tile+1)*n && i < len(m); i++ {
+				if !math.IsNaN(float64(m[i])) {
+					sum += float64(m[i])
+					k++
+				}
+			}
+			if k > 0 {
+				w[j] = sum / float64(k)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("indexcov: --pca-weight mappability needs a --gc bed with a mappability column")
+		}
+		return w, nil
+	}
+	return nil, fmt.Errorf("indexcov: unknown --pca-weight: %s. use none, variance or mappability", opts.PCAWeight)
+}
+
+// weighted returns b (m × c, stored by row) with each row scaled by its weight.
+func weighted(b []float64, weights []float64, c int) []float64 {
+	if weights == nil {
+		return b
+	}
+	out := make([]float64, len(b))
+	for j, w := range weights {
+		for t := 0; t < c; t++ {
+			out[j*c+t] = w * b[j*c+t]
+		}
+	}
+	return out
+}
+
+// mulCentered returns (x - means) * b where b is m × c, stored by row. The result is n × c.
+func mulCentered(x [][]uint8, means []float64, b []float64, c int, processes int) []float64 {
+	// the centering is subtracted once from each row: means' * b.
+	off := make([]float64, c)
+	for j, mu := range means {
+		for t := 0; t < c; t++ {
+			off[t] += mu * b[j*c+t]
+		}
+	}
+	out := make([]float64, len(x)*c)
+	parallel(len(x), processes, func(i int) {
+		acc := out[i*c : (i+1)*c]
+		for j, v := range x[i] {
+			if v == 0 {
+				continue
+			}
+			fv := float64(v)
+			for t, bv := range b[j*c : (j+1)*c] {
+				acc[t] += fv * bv
+			}
+		}
+		for t := range acc {
+			acc[t] -= off[t]
+		}
+	})
+	return out
+}
+
+// mulCenteredT returns (x - means)' * q where q is n × c, stored by row. The result is m × c.
+func mulCenteredT(x [][]uint8, means []float64, q []float64, c int, processes int) []float64 {
+	m := len(means)
+	colSums := make([]float64, c)
+	for i := range x {
+		for t := 0; t < c; t++ {
+			colSums[t] += q[i*c+t]
+		}
+	}
+	out := make([]float64, m*c)
+	nChunks := (m + pcaChunk - 1) / pcaChunk
+	parallel(nChunks, processes, func(ch int) {
+		j0, j1 := ch*pcaChunk, imin((ch+1)*pcaChunk, m)
+		for i, row := range x {
+			qi := q[i*c : (i+1)*c]
+			for j, v := range row[j0:j1] {
+				if v == 0 {
+					continue
+				}
+				fv := float64(v)
+				o := out[(j0+j)*c : (j0+j+1)*c]
+				for t, qv := range qi {
+					o[t] += fv * qv
+				}
+			}
+		}
+		for j := j0; j < j1; j++ {
+			for t := 0; t < c; t++ {
+				out[j*c+t] -= means[j] * colSums[t]
+			}
+		}
+	})
+	return out
+}
+
+// orthonormalize makes the c columns of a (stored by row) orthonormal in place with
+// modified Gram-Schmidt. Columns that are dependent on earlier columns are set to 0.
+func orthonormalize(a []float64, c int) {
+	rows := len(a) / c
+	for t := 0; t < c; t++ {
+		for p := 0; p < t; p++ {
+			dot := 0.0
+			for i := 0; i < rows; i++ {
+				dot += a[i*c+t] * a[i*c+p]
+			}
+			for i := 0; i < rows; i++ {
+				a[i*c+t] -= dot * a[i*c+p]
+			}
+		}
+		norm := 0.0
+		for i := 0; i < rows; i++ {
+			norm += a[i*c+t] * a[i*c+t]
+		}
+		norm = math.Sqrt(norm)
+		for i := 0; i < rows; i++ {
+			if norm > 1e-10 {
+				a[i*c+t] /= norm
+			} else {
+				a[i*c+t] = 0
+			}
+		}
+	}
+}
+
+// randomizedPCA finds the top k principal components of the samples (rows) in x with the
+// randomized algorithm of Halko, Martinsson and Tropp. x is never copied to floats; only
+// n × (k+10) and m × (k+10) matrices are used. If weights is not nil, each centered column is
+// scaled by its weight. It returns the scores (n × k) and the fit.
+func randomizedPCA(x [][]uint8, k int, weights []float64, processes int) (*mat.Dense, *pcaFit, error) {
+	n, m := len(x), len(x[0])
+	l := imin(k+pcaOversample, imin(n, m))
+	if k > l {
+		k = l
+	}
+	means, colVars := colStats(x, processes)
+	total := 0.0
+	for j, v := range colVars {
+		w := 1.0
+		if weights != nil {
+			w = weights[j]
+		}
+		total += float64(n) * w * w * v
+	}
+	// the weighted matrix is (x - means) W so products with it scale b before and the result
+	// of the transpose after.
+	mul := func(b []float64) []float64 { return mulCentered(x, means, weighted(b, weights, l), l, processes) }
+	mulT := func(q []float64) []float64 { return weighted(mulCenteredT(x, means, q, l, processes), weights, l) }
+
+	// a fixed seed so that the output is reproducible.
+	rng := rand.New(rand.NewSource(42))
+	omega := make([]float64, m*l)
+	for i := range omega {
+		omega[i] = rng.NormFloat64()
+	}
+	y := mul(omega)
+	for it := 0; it < pcaPowerIters; it++ {
+		orthonormalize(y, l)
+		z := mulT(y)
+		orthonormalize(z, l)
+		y = mul(z)
+	}
+	orthonormalize(y, l)
+
+	// B' = (x - means)' * Q is m × l. Its SVD B' = W S U' gives x - means ~= (Q U) S W'.
+	bt := mat.NewDense(m, l, mulT(y))
+	var svd mat.SVD
+	if ok := svd.Factorize(bt, mat.SVDThin); !ok {
+		return nil, nil, fmt.Errorf("indexcov: error with principal components")
+	}
+	s := svd.Values(nil)
+	w := svd.UTo(nil)
+	u := svd.VTo(nil)
+
+	var qu mat.Dense
+	qu.Mul(mat.NewDense(n, l, y), u)
+	scores := mat.NewDense(n, k, nil)
+	loadings := mat.NewDense(m, k, nil)
+	vars := make([]float64, k)
+	for t := 0; t < k; t++ {
+		// flip the sign so that the sample with the largest absolute score is positive.
+		sign, big := 1.0, 0.0
+		for i := 0; i < n; i++ {
+			if v := qu.At(i, t); math.Abs(v) > big {
+				big = math.Abs(v)
+				sign = math.Copysign(1, v)
+			}
+		}
+		for i := 0; i < n; i++ {
+			scores.Set(i, t, sign*qu.At(i, t)*s[t])
+		}
+		for j := 0; j < m; j++ {
+			loadings.Set(j, t, sign*w.At(j, t))
+		}
+		if total > 0 {
+			vars[t] = s[t] * s[t] / total
+		}
+	}
+	return scores, &pcaFit{means: means, weights: weights, loadings: loadings, vars: vars}, nil
+}
+
+// writeLoadings writes the mean and the loading of each component for each tile used in the PCA
+// so that other batches can be projected onto the same components.
+func (f *pcaFit) writeLoadings(path string, tiles []pcaTile, width int, threads int) error {
+	fh, err := getWriter(path, threads)
+	if err != nil {
+		return err
+	}
+	if err := f.formatLoadings(fh, tiles, width); err != nil {
+		return err
+	}
+	return fh.Close()
+}
+
+// formatLoadings writes the table of writeLoadings to fh.
+func (f *pcaFit) formatLoadings(fh io.Writer, tiles []pcaTile, width int) error {
+	w := bufio.NewWriter(fh)
+	_, k := f.loadings.Dims()
+	hdr := []string{"#chrom", "start", "end", "mean", "weight"}
+	for t := 0; t < k; t++ {
+		hdr = append(hdr, fmt.Sprintf("PC%d", t+1))
+	}
+	fmt.Fprintln(w, strings.Join(hdr, "\t"))
+	vals := make([]string, k)
+	for j, tl := range tiles {
+		for t := 0; t < k; t++ {
+			vals[t] = fmt.Sprintf("%.6g", f.loadings.At(j, t))
+		}
+		wt := 1.0
+		if f.weights != nil {
+			wt = f.weights[j]
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%d\t%d\t%.4f\t%.6g\t%s\n", tl.chrom, tl.tile*width, (tl.tile+1)*width,
+			f.means[j], wt, strings.Join(vals, "\t")); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,131 @@
+package indexcov
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// lowRankDepths returns the 8-bit depths of n samples on m tiles with rank components of
+// decreasing size and some noise.
+func lowRankDepths(n, m, rank int, seed int64) [][]uint8 {
+	rng := rand.New(rand.NewSource(seed))
+	x := make([][]uint8, n)
+	load := make([][]float64, rank)
+	for r := range load {
+		load[r] = make([]float64, m)
+		for j := range load[r] {
+			load[r][j] = rng.NormFloat64()
+		}
+	}
+	for i := range x {
+		x[i] = make([]uint8, m)
+		score := make([]float64, rank)
+		for r := range score {
+			score[r] = rng.NormFloat64() * 12 / float64(r+1)
+		}
+		for j := range x[i] {
+			v := 100 + rng.NormFloat64()
+			for r := range score {
+				v += score[r] * load[r][j]
+			}
+			x[i][j] = uint8(math.Max(0, math.Min(255, math.Round(v))))
+		}
+	}
+	return x
+}
+
+// exactPCA returns the scores and the proportion of the variance of the top k components
+// from the SVD of the centered and weighted depths.
+func exactPCA(x [][]uint8, k int, weights []float64) (*mat.Dense, []float64) {
+	n, m := len(x), len(x[0])
+	means, _ := colStats(x, 1)
+	a := mat.NewDense(n, m, nil)
+	total := 0.0
+	for i, row := range x {
+		for j, v := range row {
+			w := 1.0
+			if weights != nil {
+				w = weights[j]
+			}
+			d := w * (float64(v) - means[j])
+			a.Set(i, j, d)
+			total += d * d
+		}
+	}
+	var svd mat.SVD
+	svd.Factorize(a, mat.SVDThin)
+	s := svd.Values(nil)
+	u := svd.UTo(nil)
+	scores := mat.NewDense(n, k, nil)
+	vars := make([]float64, k)
+	for t := 0; t < k; t++ {
+		// the same sign convention as randomizedPCA.
+		sign, big := 1.0, 0.0
+		for i := 0; i < n; i++ {
+			if v := u.At(i, t); math.Abs(v) > big {
+				big, sign = math.Abs(v), math.Copysign(1, v)
+			}
+		}
+		for i := 0; i < n; i++ {
+			scores.Set(i, t, sign*u.At(i, t)*s[t])
+		}
+		vars[t] = s[t] * s[t] / total
+	}
+	return scores, vars
+}
+
+func TestRandomizedPCA(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		n, m      int
+		k         int
+		processes int
+		weight    bool
+	}{
+		{"small", 20, 300, 3, 1, false},
+		// more tiles than a chunk so the columns are split across goroutines.
+		{"chunks", 15, pcaChunk + 500, 3, 4, false},
+		{"weighted", 25, 400, 3, 2, true},
+		// fewer samples than components plus the oversampling.
+		{"few samples", 8, 200, 5, 1, false},
+	} {
+		x := lowRankDepths(c.n, c.m, 3, int64(c.n))
+		var weights []float64
+		if c.weight {
+			weights = make([]float64, c.m)
+			for j := range weights {
+				weights[j] = 0.5 + float64(j%3)/2
+			}
+		}
+		got, fit, err := randomizedPCA(x, c.k, weights, c.processes)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		exp, vars := exactPCA(x, c.k, weights)
+		if r, k := got.Dims(); r != c.n || k != c.k {
+			t.Fatalf("%s: expected %d × %d scores, got %d × %d", c.name, c.n, c.k, r, k)
+		}
+		// only the components of the signal are well separated from the noise.
+		for comp := 0; comp < 3; comp++ {
+			scale := 0.0
+			for i := 0; i < c.n; i++ {
+				scale = math.Max(scale, math.Abs(exp.At(i, comp)))
+			}
+			for i := 0; i < c.n; i++ {
+				if d := math.Abs(got.At(i, comp) - exp.At(i, comp)); d > 1e-3*scale {
+					t.Errorf("%s: PC%d of sample %d: expected %.4f, got %.4f", c.name, comp+1, i, exp.At(i, comp), got.At(i, comp))
+					break
+				}
+			}
+			if math.Abs(fit.vars[comp]-vars[comp]) > 1e-4 {
+				t.Errorf("%s: PC%d: expected %.4f of the variance, got %.4f", c.name, comp+1, vars[comp], fit.vars[comp])
+			}
+		}
+		if r, k := fit.loadings.Dims(); r != c.m || k != c.k {
+			t.Errorf("%s: expected %d × %d loadings, got %d × %d", c.name, c.m, c.k, r, k)
+		}
+	}
+}
@@ -0,0 +1,720 @@
+package indexcov
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft"
+	"github.com/brentp/goleft/genomes"
+	"github.com/brentp/goleft/grid"
+	"github.com/brentp/goleft/landing"
+	"github.com/brentp/goleft/plots"
+)
+
+// Options configures a call to Run. Directory and Paths are required.
+type Options struct {
+	// Directory is where all output is written. It is created if it does not exist.
+	Directory string
+	// Name is used to prefix output files as $Name-indexcov.*. Default is the base of Directory.
+	Name string
+	// LockWait is how long Run waits for another run (in this or another process) with the same
+	// Directory and Name to finish. Without it, a *goleft.LockedError is returned at once.
+	LockWait time.Duration
+	// Paths are the bams, crams or indexes (.bai, .crai, .csi) for which to estimate coverage. Per-window
+	// depth files from mosdepth (.bed.gz) or samtools depth (.depth.gz) can be given in place of indexes.
+	Paths []string
+	// Sources are depths from other providers that are analyzed along with Paths. Each must give
+	// depths for the 16KB tiles of the cohort references (from Fai or Paths). SourceNames holds
+	// the sample name of each.
+	Sources     []DepthSource
+	SourceNames []string
+	// FromBeds are $prefix-indexcov.bed.gz files from earlier runs to use instead of Paths.
+	// The samples in all of the files are merged.
+	FromBeds []string
+	// Drop holds names of samples in FromBeds to leave out.
+	Drop []string
+	// Manifest is a file of path, read-group, sample. Paths in the manifest are pooled files that
+	// are split into a sample per read-group. These are read in full with samtools.
+	Manifest string
+	// Fai is the fasta index. It is required when only crais or csis are given.
+	Fai string
+	// Chrom limits the output to a single chromosome.
+	Chrom string
+	// AliasFile is a tab-delimited file with the names of a chromosome on each line (like UCSC's
+	// chromAlias.txt). These are added to the aliases of the bundled genomes (and of Genome) so
+	// that names like NC_000001.11, 1 and chr1 match across inputs and options.
+	AliasFile string
+	// Genome is the name (e.g. GRCh38 or hg38) of a genome in the genomes package whose pseudo-autosomal
+	// regions, chromosome arms (or cytobands), blacklist and chromosome aliases are used. If empty, a
+	// bundled genome is detected from the chromosome lengths for the PARs and arms.
+	Genome string
+	// GenomeFile is a JSON file in the format of the genomes package for a custom build or organism.
+	// It is used in place of Genome.
+	GenomeFile string
+	// genome is set from Genome or GenomeFile by Run.
+	genome *genomes.Genome
+	// Sex holds the names of the sex chromosomes used to infer sex. Usually X, Y.
+	Sex []string
+	// Exclude matches the names of chromosomes that are skipped.
+	Exclude *regexp.Regexp
+	// IncludeGL plots chromosomes like GL000201.1 which are not plotted by default.
+	IncludeGL bool
+	// Ploidy is the expected copy-number of the autosomes. Default is 2.
+	Ploidy int
+	// MaxCN caps the normalized depths of the autosomes in the ROCs, the PCA and the calls. Default is 8.
+	MaxCN float32
+	// aliases are the chromosome aliases of the bundled genomes with those of genome and AliasFile.
+	// They are set by Run.
+	aliases *chromAliases
+	// Backgrounds is the number of samples, from the first, that are drawn in gray in the plots.
+	Backgrounds int
+	// PlotFormat is svg or eps to also write each png plot in that format.
+	PlotFormat string
+
+	// Metadata is a tab-delimited file with a header and sample in the first column.
+	Metadata string
+	// Plot holds specs for extra scatter plots, e.g.: x=bins.out,y=PC1,color=batch
+	Plot []string
+
+	// SuggestThresholds prints robust outlier cutoffs to stderr.
+	SuggestThresholds bool
+	// ApplyThresholds adds a PASS/FAIL qc column to the ped file.
+	ApplyThresholds bool
+	// NMADs is the number of MADs above the median for the cutoffs. Default is 5.
+	NMADs float64
+	// ReferenceRanges is a file of metric, low, high used to flag samples.
+	ReferenceRanges string
+	// QCRules is a file of metric, low, high. Samples with a value for any ped column outside of
+	// the interval fail QC. Rules can use the pca.dist column that is added when QC is run.
+	QCRules string
+	// Ped is a ped file with the reported sex of each sample. Samples where this differs from the
+	// inferred sex fail QC. The depths of the samples in each family are also compared: twins or
+	// duplicates declared in a twin column should be near-identical and other pairs should not.
+	Ped string
+	// Controls is a bed of chrom, start, end, name and the expected scaled depth (with optional low and
+	// high columns for the range in spec) of control regions such as spike-ins. The depth of each sample
+	// at each control is written to $prefix-indexcov-controls.tsv and samples with a control out of spec
+	// fail QC. Without low and high, the depth must be within 1.41 times the expected depth.
+	Controls string
+	// controls is read from Controls by Run.
+	controls *controlChecker
+	// PAR is a bed file of pseudo-autosomal regions on the sex chromosomes that are left out of the
+	// copy-number estimates used to infer sex. If empty, the regions of the Genome are used or those
+	// of a bundled genome detected from the chromosome lengths. Use "none" to keep all regions.
+	PAR string
+	// GC is a fasta (with a .fai) or a bed of chrom, start, end, GC-content (0-1) and an optional
+	// mappability (0-1) used to correct the depth of each sample for GC (and mappability) bias
+	// before any output is made. The correction is fit on the autosomes.
+	GC string
+	// PCAIncludeSex uses the sex chromosomes in the PCA. They are left out by default since they
+	// separate the samples by sex.
+	PCAIncludeSex bool
+	// PCAExclude are chromosomes that are left out of the PCA.
+	PCAExclude []string
+	// PCAWeight scales the tiles in the PCA: "none" (the default), "variance" to scale each tile
+	// to unit variance or "mappability" to scale each by its mappability from a GC bed.
+	PCAWeight string
+	// Cytobands is a UCSC cytoBand file whose bands (e.g. 7q11.23) label the x-axes of the depth
+	// plots and the calls. If empty, the cytobands (or arms) of the Genome are used or those of a
+	// bundled genome detected from the chromosome lengths. Use "none" to turn off the labels.
+	Cytobands string
+	// Window is the length of the bins used for all output. It must be a multiple of 16384 and
+	// the depths of each 16KB tile in a bin are averaged before anything else. Larger bins are
+	// less noisy for low-coverage samples. Default is 16384 or, for FromBeds, the bin size of the beds.
+	Window int
+
+	// Calls segments the depths of each sample on the autosomes into copy-number
+	// calls written as BED and VCF.
+	Calls bool
+	// PCs is the number of principal components computed with a randomized PCA and written to
+	// the ped file. It must be at least 3. Default is 5.
+	PCs int
+	// Loadings writes the mean and loadings of each tile used in the PCA so that other batches
+	// can be projected onto the same components.
+	Loadings bool
+	// Project is a loadings file (from Loadings or indexcov-gather) of a reference panel such as a
+	// public cohort of the same build. Each sample is projected onto its first 2 components, which are
+	// written to the ped file as ref.PC1 and ref.PC2, to place the cohort against the panel.
+	Project string
+	// panel is read from Project by Run.
+	panel *refPanel
+	// Sketch writes a frequent-directions sketch of the PCA matrix to $prefix-indexcov.sketch.
+	// Sketches of runs on parts of a cohort are merged by indexcov-gather into the loadings of
+	// the whole cohort.
+	Sketch bool
+	// Pairs computes the correlation of the depths of each pair of samples on the autosomes to find
+	// duplicates. Pairs with a correlation of at least PairsMinR (default 0.95) are flagged.
+	Pairs     bool
+	PairsMinR float64
+	// WriteThreads is the number of goroutines used to compress output. Default is 1.
+	WriteThreads int
+	// Precision is the number of significant digits (2, 3 or 4) written for each depth in the bed.gz.
+	// Default is 3.
+	Precision int
+	// Matrix writes the depths of the bed.gz to $prefix-indexcov.matrix, a binary file indexed by
+	// sample and bin that indexcov-lookup, indexcov-replot and indexcov-serve read without scanning
+	// the bed.gz. Depths are stored to the nearest 0.001. See the matrix package.
+	Matrix bool
+	// Purity fits a rough tumor purity and ploidy for each sample from the depths of segments on the autosomes.
+	Purity bool
+	// Recenter is median (the default) to scale the depths of each sample by its median tile or modal to
+	// scale them by the most common level of its segments on the autosomes. modal is for tumors where
+	// much of the genome is gained or lost.
+	Recenter string
+	// Replicates is a file of pairs of samples (tab-delimited) that are technical replicates of the same
+	// library. The concordance of the depths of each pair on the autosomes is written with an MA plot.
+	Replicates string
+	// Ideogram draws the chromosomes of each sample to scale, colored by the deviation of the depth from 1,
+	// to $prefix-indexcov-ideogram-$n.png where n is the 1-based index of the sample.
+	Ideogram bool
+	// Bootstrap is the number of block resamples of the tiles of each chromosome used for 95% intervals on
+	// the copy-number of the sex chromosomes and the confidence of the sex call in the ped file and, with
+	// JSON, on the copy-number of every chromosome. 0 (the default) turns this off.
+	Bootstrap int
+	// Calibrate is flag or correct to read the alignments in the deepest tiles of each sample (only local
+	// bams with a .bai) and measure the proportion of their bytes from alignments with CalibrateFlags.
+	// These are written to $prefix-indexcov-calibration.tsv and, with correct, removed from the depth
+	// of those tiles. CalibrateFlags are any of secondary, supplementary (the default is both) and duplicate.
+	Calibrate      string
+	CalibrateFlags []string
+	// DosageReference is a file of the percentiles of the dosage (the copy-number over the ploidy) of each
+	// autosome in a reference cohort as written with DosageRefOut. The dosage of each sample on each
+	// autosome and its percentile in the reference are written to $prefix-indexcov-dosage.tsv.
+	// DosageRefOut writes the percentiles of this cohort (which should be large and mostly
+	// euploid) to be used as a DosageReference for other runs.
+	DosageReference string
+	DosageRefOut    string
+	// Hotspots compares the bytes of the linear index and the 16KB bins of each bam with a .bai to find
+	// regions of clipped, split or discordant alignments. These are written to $prefix-indexcov-hotspots.bed.gz.
+	Hotspots bool
+	// Karyotype estimates the copy-number of each autosome arm and reports full and mosaic gains and losses.
+	Karyotype bool
+	// Events writes $prefix-indexcov-events.tsv with a row for each qc failure, sex mismatch,
+	// arm-level gain or loss (from Karyotype) and copy-number call (from Calls) of each sample.
+	Events bool
+	// events collects the rows of the events.tsv during Run.
+	events *eventTable
+	// SampleMap is a tab-delimited file of the name of a sample in the input and the name to use in all
+	// output. It is applied as soon as the inputs are read so Metadata, Ped and Replicates use the new
+	// names while Drop uses the names in FromBeds.
+	SampleMap string
+	// Anonymize is the path of a tab-delimited file of sample names and their pseudonyms. Samples are
+	// named by their pseudonyms (after SampleMap) in all output. Samples that are not in the file are
+	// given random pseudonyms that are added to it so that they are the same in later runs. The ped,
+	// Metadata and Replicates files can use the real names. The file is created readable only by the user
+	// and should be kept out of the output directory.
+	Anonymize string
+	// pseudonyms are read from Anonymize by Run.
+	pseudonyms pseudonyms
+
+	// SingleHTML writes $prefix-indexcov.report.html: a single file with the depth and ROC of each
+	// chromosome, the sample plots and the ped table that needs no network access to view.
+	SingleHTML bool
+
+	// JSON and TSV write the values in the ped file along with a summary of the ROC of each chromosome
+	// as machine-readable reports.
+	JSON bool
+	TSV  bool
+	// ExcludeRegions is a bed file of regions to mask. Overlapping tiles are not used for the ROCs, the
+	// ped bin columns or the PCA but are still written, flagged, to the bed.gz.
+	ExcludeRegions string
+	// SexAmbiguous is the (inclusive) low, high copy-number of the first Sex chromosome for
+	// which the sex is written as 0 (unknown).
+	SexAmbiguous []float64
+	// SexMaskFailed also writes the sex as 0 for samples that fail qc.
+	SexMaskFailed bool
+	// Processes is the number of indexes that are read and normalized in parallel.
+	// Default is the number of CPUs.
+	Processes int
+	// stats are the time and bytes read of each stage and input that Run logs at the end.
+	stats *runStats
+	// headers caches the bam headers read by Run.
+	headers *headerCache
+}
+
+func (o *Options) name() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	return filepath.Base(o.Directory)
+}
+
+// base is the path prefix of all output files.
+func (o *Options) base() string {
+	return o.Directory + string(os.PathSeparator) + o.name() + "-indexcov"
+}
+
+// parseInterval parses a string like "1.3,1.7" to a low, high pair.
+func parseInterval(s string) ([]float64, error) {
+	toks := strings.Split(s, ",")
+	if len(toks) != 2 {
+		return nil, fmt.Errorf("expected low,high, got: %s", s)
+	}
+	iv := make([]float64, 2)
+	for i, t := range toks {
+		v, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil {
+			return nil, err
+		}
+		iv[i] = v
+	}
+	if iv[0] > iv[1] {
+		return nil, fmt.Errorf("low (%v) must not be greater than high (%v)", iv[0], iv[1])
+	}
+	return iv, nil
+}
+
+func (o *Options) processes() int {
+	if o.Processes < 1 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.Processes
+}
+
+func (o *Options) precision() int {
+	if o.Precision == 0 {
+		return 3
+	}
+	return o.Precision
+}
+
+// window is the length of each bin.
+func (o *Options) window() int {
+	if o.Window == 0 {
+		return TileWidth
+	}
+	return o.Window
+}
+
+// grid is the grid of the bins of all output.
+func (o *Options) grid() grid.Grid {
+	build := ""
+	if o.genome != nil {
+		build = o.genome.Name
+	}
+	g, err := grid.New(build, o.window())
+	if err != nil {
+		return grid.Grid{Width: o.window(), Version: grid.Version}
+	}
+	return g
+}
+
+func (o *Options) ploidy() int {
+	if o.Ploidy == 0 {
+		return 2
+	}
+	return o.Ploidy
+}
+
+func (o *Options) maxCN() float32 {
+	if o.MaxCN == 0 {
+		return 8
+	}
+	return o.MaxCN
+}
+
+// aliasTable returns the chromosome aliases of the run or, before Run has set them, those of the
+// bundled genomes.
+func (o *Options) aliasTable() *chromAliases {
+	if o.aliases == nil {
+		return defaultAliases
+	}
+	return o.aliases
+}
+
+// plot returns the options of every plot.
+func (o *Options) plot() plots.Options {
+	return plots.Options{Backgrounds: o.Backgrounds, Format: o.PlotFormat}
+}
+
+func (o *Options) pcs() int {
+	if o.PCs == 0 {
+		return 5
+	}
+	return o.PCs
+}
+
+func (o *Options) pairsMinR() float64 {
+	if o.PairsMinR == 0 {
+		return 0.95
+	}
+	return o.PairsMinR
+}
+
+func (o *Options) nMADs() float64 {
+	if o.NMADs == 0 {
+		return 5
+	}
+	return o.NMADs
+}
+
+// Result holds the paths of the files written by Run along with the per-sample values.
+type Result struct {
+	// Samples are the sample names in the order of Options.Paths.
+	Samples []string
+	// Sexes maps each sex chromosome that was found to the estimated copy-number of each sample.
+	Sexes map[string][]float64
+	// Chroms are the chromosomes that were plotted.
+	Chroms []string
+	// Grid is the name of the bins of the bed.gz and the other per-bin outputs (see the grid
+	// package) so that they can be checked against the bins of other tools.
+	Grid string
+
+	IndexHTML string
+	// Charts is the json that gives the data files, axes and parameters of each chart.
+	Charts string
+	Ped    string
+	Bed    string
+	ROC    string
+	// ChromStats has the median, MAD and proportion of zero and out-of-range bins of each sample on
+	// each chromosome.
+	ChromStats string
+	// CallsBed and CallsVCF are only set when Options.Calls is true.
+	CallsBed string
+	CallsVCF string
+	// Purity is only set when Options.Purity is true.
+	Purity string
+	// Matrix is only set when Options.Matrix is true.
+	Matrix string
+	// QC is the qc.tsv with PASS/FAIL and reasons for each sample. It is only set when
+	// Options.QCRules, Options.Ped or Options.Controls is used.
+	QC string
+	// Controls is only set when Options.Controls is given.
+	Controls string
+	// PedPairs is the correlation of each pair of samples in the same family and of declared twins.
+	// It is only set when Options.Ped is used.
+	PedPairs string
+	// Failed holds the samples with a FAIL in the qc column of the ped file.
+	Failed []string
+	// Warned holds the samples that did not fail but have a low ref.match, an ambiguous sex or
+	// metrics outside of the reference ranges.
+	Warned []string
+	// Karyotype is only set when Options.Karyotype is true.
+	Karyotype string
+	// Events is only set when Options.Events is true.
+	Events string
+	// Calibration is only set when Options.Calibrate is given.
+	Calibration string
+	// Hotspots is only set when Options.Hotspots is true.
+	Hotspots string
+	// Dosage is only set when Options.DosageReference or Options.DosageRefOut is given.
+	Dosage string
+	// Loadings is only set when Options.Loadings is true.
+	Loadings string
+	// Sketch is only set when Options.Sketch is true.
+	Sketch string
+	// Pairs is only set when Options.Pairs is true. PairsPNG is the heatmap which is only
+	// drawn for cohorts of up to 2000 samples.
+	Pairs    string
+	PairsPNG string
+	// ReportJSON and ReportTSV are only set when Options.JSON and Options.TSV are true.
+	ReportJSON string
+	ReportTSV  string
+	// ReportHTML is only set when Options.SingleHTML is true.
+	ReportHTML string
+	// Replicates is only set when Options.Replicates is given.
+	Replicates string
+	// Ideograms holds the ideogram of each sample in the order of Samples. It is only set when
+	// Options.Ideogram is true.
+	Ideograms []string
+	// Landing is only set when the outputs of other goleft commands (e.g. depth) are in Directory
+	// and a landing page linking them all was written.
+	Landing string
+}
+
+// Run estimates coverage for the indexes in opts.Paths and writes the output files
+// to opts.Directory. It may be called from multiple goroutines with different output prefixes.
+func Run(opts Options) (*Result, error) {
+	if len(opts.Paths) == 0 && len(opts.FromBeds) == 0 && len(opts.Sources) == 0 {
+		return nil, goleft.InputErr(errors.New("indexcov: expected at least 1 bam/bai/crai"))
+	}
+	if opts.pcs() < 3 {
+		return nil, goleft.InputErr(fmt.Errorf("indexcov: at least 3 principal components are needed. got %d", opts.PCs))
+	}
+	if p := opts.precision(); p < 2 || p > 4 {
+		return nil, goleft.InputErr(fmt.Errorf("indexcov: precision must be 2, 3 or 4. got %d", p))
+	}
+	if w := opts.window(); w < TileWidth || w%TileWidth != 0 {
+		return nil, goleft.InputErr(fmt.Errorf("indexcov: window must be a multiple of %d. got %d", TileWidth, w))
+	}
+	if r := opts.Recenter; r != "" && r != "median" && r != "modal" {
+		return nil, goleft.InputErr(fmt.Errorf("indexcov: recenter must be median or modal. got %s", r))
+	}
+	if opts.Bootstrap < 0 {
+		return nil, goleft.InputErr(fmt.Errorf("indexcov: bootstrap must be positive. got %d", opts.Bootstrap))
+	}
+	if c := opts.Calibrate; c != "" && c != "flag" && c != "correct" {
+		return nil, goleft.InputErr(fmt.Errorf("indexcov: calibrate must be flag or correct. got %s", c))
+	}
+	if _, err := inflationFlags(opts.CalibrateFlags); err != nil {
+		return nil, goleft.InputErr(err)
+	}
+	if len(opts.Sources) != len(opts.SourceNames) {
+		return nil, goleft.InputErr(errors.New("indexcov: expected a name for each of the Sources"))
+	}
+	if opts.Directory == "" {
+		return nil, goleft.InputErr(errors.New("indexcov: output directory is required"))
+	}
+	if exists, err := getDirectory(opts.Directory); err != nil || !exists {
+		return nil, fmt.Errorf("indexcov: error creating specified directory: %s, %v", opts.Directory, err)
+	}
+	lock, err := goleft.AcquireLock(opts.base(), opts.LockWait)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	opts.stats = newRunStats()
+	opts.headers = newHeaderCache()
+	g, err := genomes.Resolve(opts.Genome, opts.GenomeFile)
+	if err != nil {
+		return nil, goleft.InputErr(err)
+	}
+	opts.aliases = defaultAliases
+	if opts.genome = g; g != nil {
+		opts.aliases = opts.aliases.with(g.ChromAliases)
+	}
+	if opts.AliasFile != "" {
+		if opts.aliases, err = opts.aliases.readAliases(opts.AliasFile); err != nil {
+			return nil, goleft.InputErr(fmt.Errorf("indexcov: error reading aliases: %s", err))
+		}
+	}
+
+	var refs []*sam.Reference
+	// srcWidth is the length of the bins of the sources before --window.
+	srcWidth := TileWidth
+	var idxs []DepthSource
+	var names []string
+	done := opts.stats.begin(stageIndex)
+	if len(opts.FromBeds) > 0 {
+		var width int
+		if refs, idxs, names, width, err = readBeds(opts.FromBeds, opts.Drop, opts.aliasTable()); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+		defer closeMatrices(idxs)
+		if opts.Window == 0 {
+			opts.Window = width
+		}
+		if opts.Window%width != 0 {
+			return nil, goleft.InputErr(fmt.Errorf("indexcov: window must be a multiple of the %d bins in the beds. got %d", width, opts.Window))
+		}
+		srcWidth = width
+	} else {
+		// the lengths and names of references from bams or fasta
+		if refs, err = getReferences(&opts); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+		if idxs, names, err = readInputs(&opts, refs); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+		idxs, names = append(idxs, opts.Sources...), append(names, opts.SourceNames...)
+	}
+	done()
+	checkGenome(opts.genome, refs, opts.aliasTable())
+	if opts.SampleMap != "" {
+		if names, err = renameSamples(opts.SampleMap, names); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+	}
+	if opts.Anonymize != "" {
+		var m map[string]string
+		if names, m, err = anonymize(opts.Anonymize, names, opts.Directory); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+		opts.pseudonyms = m
+	}
+	if opts.Controls != "" {
+		if opts.controls, err = readControls(opts.Controls, names, opts.aliasTable()); err != nil {
+			return nil, goleft.InputErr(err)
+		}
+	}
+	refMatch := matchSamples(idxs, names, refs, opts.aliasTable())
+	// srcs are the sources used for all output. idxs are kept for their read counts and errors.
+	srcs := idxs
+	if opts.Calibrate != "" && len(opts.FromBeds) == 0 {
+		if srcs, err = calibrate(&opts, refs, idxs, srcs, names, opts.base()+"-calibration.tsv"); err != nil {
+			return nil, err
+		}
+	}
+	// mapp is the mappability of each tile of each reference from a --gc bed.
+	var mapp [][]float32
+	if opts.GC != "" && len(opts.FromBeds) == 0 {
+		var gc [][]float32
+		if gc, mapp, err = readGCTrack(opts.GC, refs, opts.aliasTable()); err != nil {
+			return nil, goleft.InputErr(fmt.Errorf("indexcov: error reading GC: %s", err))
+		}
+		srcs = gcCorrect(&opts, refs, srcs, gc, mapp)
+	}
+	if opts.Recenter == "modal" {
+		srcs = recenterModal(&opts, refs, srcs, names)
+	}
+	srcs = windowSources(srcs, opts.window()/srcWidth)
+	if opts.Project != "" {
+		if opts.panel, err = readPanel(opts.Project, opts.aliasTable()); err != nil {
+			return nil, goleft.InputErr(fmt.Errorf("indexcov: error reading loadings: %s", err))
+		}
+		if opts.panel.width != opts.window() {
+			return nil, goleft.InputErr(fmt.Errorf("indexcov: the bins of %s are %d bases. use --window %d", opts.Project, opts.panel.width, opts.panel.width))
+		}
+	}
+
+	base := opts.base()
+	var rep *report
+	if opts.JSON || opts.TSV {
+		rep = newReport(names)
+	}
+	var page *singleReport
+	if opts.SingleHTML {
+		page = newSingleReport(opts.name(), names, opts.Backgrounds)
+	}
+	if opts.Events {
+		opts.events = &eventTable{}
+	}
+	var boot *bootstrapper
+	if opts.Bootstrap > 0 {
+		boot = newBootstrapper(opts.Bootstrap, opts.ploidy())
+	}
+	cov, err := run(&opts, refs, srcs, names, base, rep, page, boot)
+	if err != nil {
+		return nil, err
+	}
+	if err := bedError(idxs); err != nil {
+		return nil, err
+	}
+	if opts.Hotspots && len(opts.FromBeds) == 0 {
+		if err := writeHotspots(&opts, refs, idxs, names, base+"-hotspots.bed.gz"); err != nil {
+			return nil, err
+		}
+	}
+	done = opts.stats.begin(stagePCA)
+	weights, err := pcaWeights(&opts, cov.pca8, cov.pcaTiles, refs, mapp)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Sketch && len(cov.pcaTiles) > 0 {
+		sk := sketchPCA(cov.pca8, cov.pcaTiles, opts.window(), weights, opts.pcs())
+		sk.Grid = opts.grid().String()
+		if err := sk.write(base + ".sketch"); err != nil {
+			return nil, err
+		}
+	}
+	done()
+	mapped := make([]uint64, len(names))
+	unmapped := make([]uint64, len(names))
+	anygt := false
+	for i, src := range idxs {
+		ix, ok := src.(*Index)
+		if !ok {
+			continue
+		}
+		mapped[i] = ix.mapped
+		unmapped[i] = ix.unmapped
+		if ix.mapped > 0 || ix.unmapped > 0 {
+			anygt = true
+		}
+	}
+	if !anygt {
+		mapped = nil
+		unmapped = nil
+	}
+
+	indexPath, table, err := writeIndex(&opts, cov.sexes, cov.counts, names, cov.pca8, cov.pcaTiles, weights, cov.slopes, cov.chroms, mapped, unmapped, refMatch, rep, page, boot)
+	if err != nil {
+		return nil, err
+	}
+	if opts.events != nil {
+		if err := opts.events.write(base+"-events.tsv", names); err != nil {
+			return nil, err
+		}
+	}
+	delete(cov.sexes, "_inferred")
+	res := &Result{Samples: names, Sexes: cov.sexes, Chroms: cov.chroms, Grid: opts.grid().String(), IndexHTML: indexPath,
+		Charts: base + "-charts.json", Ped: base + ".ped", Bed: base + ".bed.gz", ROC: base + ".roc",
+		ChromStats: base + ".chrom-stats.tsv"}
+	if opts.Calls {
+		res.CallsBed, res.CallsVCF = base+"-calls.bed.gz", base+"-calls.vcf.gz"
+	}
+	if opts.Purity {
+		res.Purity = base + "-purity.tsv"
+	}
+	if opts.QCRules != "" || opts.Ped != "" || opts.Controls != "" {
+		res.QC = base + ".qc.tsv"
+	}
+	if opts.Controls != "" {
+		res.Controls = base + "-controls.tsv"
+	}
+	if opts.Matrix {
+		res.Matrix = base + ".matrix"
+	}
+	if opts.Ped != "" {
+		res.PedPairs = base + ".ped-pairs.tsv"
+	}
+	if qc, err := table.strings("qc"); err == nil {
+		for i, q := range qc {
+			if q == "FAIL" {
+				res.Failed = append(res.Failed, table.samples[i])
+			}
+		}
+	}
+	res.Warned = warnedSamples(table)
+	if opts.Karyotype {
+		res.Karyotype = base + "-karyotype.tsv"
+	}
+	if opts.Events {
+		res.Events = base + "-events.tsv"
+	}
+	if opts.Calibrate != "" && len(opts.FromBeds) == 0 {
+		res.Calibration = base + "-calibration.tsv"
+	}
+	if opts.Hotspots && len(opts.FromBeds) == 0 {
+		res.Hotspots = base + "-hotspots.bed.gz"
+	}
+	if opts.DosageReference != "" || opts.DosageRefOut != "" {
+		res.Dosage = base + "-dosage.tsv"
+	}
+	if opts.Replicates != "" {
+		res.Replicates = base + "-replicates.tsv"
+	}
+	if opts.Ideogram {
+		res.Ideograms = make([]string, len(names))
+		for k := range names {
+			res.Ideograms[k] = fmt.Sprintf("%s-ideogram-%d.png", base, k+1)
+		}
+	}
+	if opts.Loadings {
+		res.Loadings = base + "-loadings.bed.gz"
+	}
+	if opts.Sketch && len(cov.pcaTiles) > 0 {
+		res.Sketch = base + ".sketch"
+	}
+	if opts.Pairs {
+		res.Pairs = base + ".pairs.tsv"
+		if len(names) <= maxHeatmapSamples {
+			res.PairsPNG = base + "-pairs.png"
+		}
+	}
+	if opts.JSON {
+		res.ReportJSON = base + "-report.json"
+	}
+	if opts.SingleHTML {
+		res.ReportHTML = base + ".report.html"
+	}
+	if opts.TSV {
+		res.ReportTSV = base + "-report.tsv"
+	}
+	opts.stats.log()
+	// a failure to write the landing page does not fail the run.
+	if res.Landing, err = landing.Update(opts.Directory); err != nil {
+		log.Printf("indexcov: WARNING: %s", err)
+	}
+	return res, nil
+}
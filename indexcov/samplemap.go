@@ -0,0 +1,74 @@
+package indexcov
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// readSampleMap reads a tab-delimited file of the name of a sample in the input (its SM or file
+// name) and the name to use in the output. Blank lines and lines starting with '#' are skipped.
+func readSampleMap(path string) (map[string]string, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	m := make(map[string]string)
+	for i := 1; ; i++ {
+		line, err := rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && line[0] != '#' {
+			toks := strings.Split(line, "\t")
+			if len(toks) != 2 || strings.TrimSpace(toks[0]) == "" || strings.TrimSpace(toks[1]) == "" {
+				return nil, fmt.Errorf("indexcov: expected 2 tab-delimited columns at line %d of %s", i, path)
+			}
+			from, to := strings.TrimSpace(toks[0]), strings.TrimSpace(toks[1])
+			if prev, ok := m[from]; ok && prev != to {
+				return nil, fmt.Errorf("indexcov: %s is mapped to both %s and %s in %s", from, prev, to, path)
+			}
+			m[from] = to
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("indexcov: no samples found in %s", path)
+	}
+	return m, nil
+}
+
+// renameSamples returns the names with those in the sample map replaced. Samples that are not in
+// the map keep their names. It is an error for 2 samples to end up with the same name.
+func renameSamples(path string, names []string) ([]string, error) {
+	m, err := readSampleMap(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(names))
+	from := make(map[string]string, len(names))
+	var kept []string
+	for k, n := range names {
+		out[k] = n
+		if to, ok := m[n]; ok {
+			out[k] = to
+		} else {
+			kept = append(kept, n)
+		}
+		if other, ok := from[out[k]]; ok {
+			return nil, fmt.Errorf("indexcov: %s and %s would both be named %s with --sample-map", other, n, out[k])
+		}
+		from[out[k]] = n
+	}
+	if len(kept) > 0 {
+		log.Printf("indexcov: WARNING: %d of %d samples are not in %s and keep their names, e.g. %s", len(kept), len(names), path, kept[0])
+	}
+	return out, nil
+}
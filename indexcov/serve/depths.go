@@ -0,0 +1,169 @@
+package serve
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brentp/goleft/indexcov/matrix"
+)
+
+// DepthsPath is the path at which the depths of a region are served from the $prefix-indexcov.matrix
+// of a run written with indexcov --matrix, e.g. /depths?region=chr7:117480000-117680000&samples=a,b.
+// With more than one matrix in the directory, run gives the name of the run.
+const DepthsPath = "/depths"
+
+// maxCells is the most depths that are returned for a request.
+const maxCells = 1 << 24
+
+// matrices holds the matrices of the directory open so that each request reads only the blocks it
+// needs. A matrix is opened again when it is replaced.
+type matrices struct {
+	dir string
+	mu  sync.Mutex
+	m   map[string]*openMatrix
+}
+
+type openMatrix struct {
+	r   *matrix.Reader
+	mod time.Time
+}
+
+// get returns the matrix of run or the only matrix in the directory if run is empty.
+func (ms *matrices) get(run string) (*matrix.Reader, error) {
+	var path string
+	if run != "" {
+		if strings.ContainsAny(run, `/\`) {
+			return nil, fmt.Errorf("bad run %q", run)
+		}
+		path = filepath.Join(ms.dir, strings.TrimSuffix(strings.TrimSuffix(run, ".matrix"), "-indexcov")+"-indexcov.matrix")
+	} else {
+		paths, _ := filepath.Glob(filepath.Join(ms.dir, "*-indexcov.matrix"))
+		if len(paths) != 1 {
+			return nil, fmt.Errorf("found %d matrices from indexcov --matrix. give the run", len(paths))
+		}
+		path = paths[0]
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("no matrix for run %q", run)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if o, ok := ms.m[path]; ok && o.mod.Equal(fi.ModTime()) {
+		return o.r, nil
+	}
+	r, err := matrix.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// a replaced matrix is not closed as requests may still be reading it. matrix.Writer replaces
+	// the file rather than writing over it so the old mapping stays valid.
+	ms.m[path] = &openMatrix{r: r, mod: fi.ModTime()}
+	return r, nil
+}
+
+// parseRegion parses chrom:start-end (1-based and inclusive, with commas allowed) or chrom and
+// returns it as 0-based and half-open. An end of -1 is the end of the chromosome.
+func parseRegion(region string) (string, int, int, error) {
+	region = strings.Replace(strings.TrimSpace(region), ",", "", -1)
+	i := strings.LastIndexByte(region, ':')
+	if i == -1 {
+		if region == "" {
+			return "", 0, 0, fmt.Errorf("empty region")
+		}
+		return region, 0, -1, nil
+	}
+	toks := strings.SplitN(region[i+1:], "-", 2)
+	start, err := strconv.Atoi(toks[0])
+	if err != nil || start < 1 {
+		return "", 0, 0, fmt.Errorf("bad start in region %s", region)
+	}
+	end := start
+	if len(toks) == 2 {
+		if end, err = strconv.Atoi(toks[1]); err != nil || end < start {
+			return "", 0, 0, fmt.Errorf("bad end in region %s", region)
+		}
+	}
+	return region[:i], start - 1, end, nil
+}
+
+// findChrom returns the index of chrom in r allowing for a chr prefix that differs.
+func findChrom(r *matrix.Reader, chrom string) int {
+	for _, c := range []string{chrom, "chr" + chrom, strings.TrimPrefix(chrom, "chr")} {
+		if i := r.Chrom(c); i != -1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// serveDepths writes the depths of the samples in the bins of the region as a tab-delimited table
+// in the format of the bed.gz.
+func (ms *matrices) serveDepths(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	r, err := ms.get(q.Get("run"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	chrom, start, end, err := parseRegion(q.Get("region"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ci := findChrom(r, chrom)
+	if ci == -1 {
+		http.Error(w, fmt.Sprintf("chromosome %s not found", chrom), http.StatusNotFound)
+		return
+	}
+	samples := r.Samples
+	var cols []int
+	if s := q.Get("samples"); s != "" {
+		samples = strings.Split(s, ",")
+		for _, name := range samples {
+			k := r.Column(name)
+			if k == -1 {
+				http.Error(w, fmt.Sprintf("sample %s not found", name), http.StatusNotFound)
+				return
+			}
+			cols = append(cols, k)
+		}
+	}
+	from, to := start/r.Width, r.Chroms[ci].Bins
+	if end != -1 && (end+r.Width-1)/r.Width < to {
+		to = (end + r.Width - 1) / r.Width
+	}
+	if from >= to {
+		http.Error(w, "no bins in region", http.StatusNotFound)
+		return
+	}
+	if (to-from)*len(samples) > maxCells {
+		http.Error(w, fmt.Sprintf("%d bins of %d samples is too many. use a smaller region or fewer samples", to-from, len(samples)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	depths, err := r.Slice(ci, from, to, cols)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#chrom\tstart\tend\t%s\n", strings.Join(samples, "\t"))
+	name := r.Chroms[ci].Name
+	for i := from; i < to; i++ {
+		fmt.Fprintf(bw, "%s\t%d\t%d", name, i*r.Width, (i+1)*r.Width)
+		for _, d := range depths {
+			bw.WriteByte('\t')
+			bw.WriteString(strconv.FormatFloat(float64(d[i-from]), 'g', 4, 32))
+		}
+		bw.WriteByte('\n')
+	}
+	bw.Flush()
+}
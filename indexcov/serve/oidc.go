@@ -0,0 +1,277 @@
+package serve
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leeway is the allowed clock difference when checking the times in a token.
+const leeway = time.Minute
+
+// refetch is the shortest time between fetches of the keys of the issuer when a token is
+// signed with an unknown key.
+const refetch = time.Minute
+
+// OIDC checks that requests have an ID (or access) token signed by the issuer for the audience.
+// The keys are found from the discovery document of the issuer and cached. RS256 and ES256 are
+// supported.
+type OIDC struct {
+	Issuer   string
+	Audience string
+	// Header holds the token. Default is Authorization with a Bearer token.
+	Header string
+	// Client is used to fetch the keys. Default is an http.Client with a 10 second timeout.
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// Claims are the claims of a verified token that are used by the server.
+type Claims struct {
+	Issuer    string    `json:"iss"`
+	Subject   string    `json:"sub"`
+	Audience  audiences `json:"aud"`
+	Expires   int64     `json:"exp"`
+	NotBefore int64     `json:"nbf"`
+	Email     string    `json:"email"`
+}
+
+// audiences is the aud claim which may be a string or a list of strings.
+type audiences []string
+
+func (a *audiences) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audiences{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audiences) has(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *OIDC) header() string {
+	if o.Header == "" {
+		return "Authorization"
+	}
+	return o.Header
+}
+
+func (o *OIDC) client() *http.Client {
+	if o.Client == nil {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	return o.Client
+}
+
+// Verify returns the claims of the token in the request if it is valid.
+func (o *OIDC) Verify(r *http.Request) (*Claims, error) {
+	tok := strings.TrimSpace(r.Header.Get(o.header()))
+	if len(tok) > 7 && strings.EqualFold(tok[:7], "bearer ") {
+		tok = strings.TrimSpace(tok[7:])
+	}
+	if tok == "" {
+		return nil, errUnauthorized
+	}
+	return o.verify(tok, time.Now())
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func decodePart(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (o *OIDC) verify(tok string, now time.Time) (*Claims, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("serve: malformed token")
+	}
+	var h jwtHeader
+	if err := decodePart(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("serve: bad token header: %s", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("serve: bad token signature: %s", err)
+	}
+	key, err := o.key(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if h.Alg != "RS256" {
+			return nil, fmt.Errorf("serve: unsupported token algorithm %q for an RSA key", h.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, errors.New("serve: bad token signature")
+		}
+	case *ecdsa.PublicKey:
+		if h.Alg != "ES256" || len(sig) != 64 {
+			return nil, fmt.Errorf("serve: unsupported token algorithm %q for an EC key", h.Alg)
+		}
+		if !ecdsa.Verify(k, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, errors.New("serve: bad token signature")
+		}
+	default:
+		return nil, fmt.Errorf("serve: unsupported key for token algorithm %q", h.Alg)
+	}
+	var c Claims
+	if err := decodePart(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("serve: bad token claims: %s", err)
+	}
+	if strings.TrimRight(c.Issuer, "/") != strings.TrimRight(o.Issuer, "/") {
+		return nil, fmt.Errorf("serve: token issuer %q is not %q", c.Issuer, o.Issuer)
+	}
+	if !c.Audience.has(o.Audience) {
+		return nil, fmt.Errorf("serve: token is not for audience %q", o.Audience)
+	}
+	if c.Expires == 0 || now.After(time.Unix(c.Expires, 0).Add(leeway)) {
+		return nil, errors.New("serve: token has expired")
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return nil, errors.New("serve: token is not yet valid")
+	}
+	return &c, nil
+}
+
+// key returns the key with the given id, fetching the keys of the issuer if it is not known.
+func (o *OIDC) key(kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(o.fetched) < refetch {
+		return nil, fmt.Errorf("serve: unknown token key %q", kid)
+	}
+	o.fetched = time.Now()
+	keys, err := o.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+	o.keys = keys
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("serve: unknown token key %q", kid)
+}
+
+func (o *OIDC) getJSON(url string, v interface{}) error {
+	resp, err := o.client().Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("serve: got status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func b64int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// fetchKeys reads the signing keys from the jwks_uri of the discovery document of the issuer.
+func (o *OIDC) fetchKeys() (map[string]crypto.PublicKey, error) {
+	var disc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	url := strings.TrimRight(o.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := o.getJSON(url, &disc); err != nil {
+		return nil, fmt.Errorf("serve: error getting OIDC discovery: %s", err)
+	}
+	if disc.JWKSURI == "" {
+		return nil, fmt.Errorf("serve: no jwks_uri in %s", url)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.getJSON(disc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("serve: error getting OIDC keys: %s", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := b64int(k.N)
+			if err != nil {
+				continue
+			}
+			e, err := b64int(k.E)
+			if err != nil || !e.IsInt64() {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, xerr := b64int(k.X)
+			y, yerr := b64int(k.Y)
+			if xerr != nil || yerr != nil || !elliptic.P256().IsOnCurve(x, y) {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("serve: no usable keys at %s", disc.JWKSURI)
+	}
+	return keys, nil
+}
@@ -0,0 +1,257 @@
+// Package serve serves the output directory of indexcov over HTTP or HTTPS so that the
+// reports can be viewed on a network without a separate web server or reverse proxy.
+//
+// Access can be limited with basic-auth users from a file and with OpenID Connect (OIDC)
+// tokens that are checked against the keys of the issuer. When both are given, either is
+// accepted. Without a certificate and key the reports are served over plain HTTP, which is
+// only suitable for the default loopback address.
+package serve
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/brentp/goleft"
+)
+
+// Config holds the options of the server.
+type Config struct {
+	// Directory is the indexcov output directory that is served.
+	Directory string
+	// Addr is the host:port to listen on. Default is 127.0.0.1:8080.
+	Addr string
+	// CertFile and KeyFile are a PEM certificate (chain) and key. Both are required for TLS.
+	CertFile string
+	KeyFile  string
+	// Users maps basic-auth user names to the SHA-256 of their password. See ReadUsers.
+	Users map[string][sha256.Size]byte
+	// OIDC checks bearer tokens from an OpenID Connect provider when it is not nil.
+	OIDC *OIDC
+	// Allow limits access to these users, emails or token subjects. Empty allows any user that
+	// passes basic-auth or OIDC.
+	Allow []string
+}
+
+func (c *Config) addr() string {
+	if c.Addr == "" {
+		return "127.0.0.1:8080"
+	}
+	return c.Addr
+}
+
+func (c *Config) tls() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// ReadUsers reads a basic-auth file with a user:password per line. Passwords may be given as
+// {SHA256}hex so that the file does not hold them in plain text. Blank lines and those starting
+// with # are ignored.
+func ReadUsers(path string) (map[string][sha256.Size]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string][sha256.Size]byte)
+	sc := bufio.NewScanner(f)
+	for i := 1; sc.Scan(); i++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		toks := strings.SplitN(line, ":", 2)
+		if len(toks) != 2 || toks[0] == "" || toks[1] == "" {
+			return nil, fmt.Errorf("serve: expected user:password at line %d of %s", i, path)
+		}
+		var sum [sha256.Size]byte
+		if strings.HasPrefix(toks[1], "{SHA256}") {
+			b, err := hex.DecodeString(strings.TrimPrefix(toks[1], "{SHA256}"))
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("serve: bad {SHA256} password at line %d of %s", i, path)
+			}
+			copy(sum[:], b)
+		} else {
+			sum = sha256.Sum256([]byte(toks[1]))
+		}
+		users[toks[0]] = sum
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("serve: no users found in %s", path)
+	}
+	return users, nil
+}
+
+// Handler returns the handler that serves cfg.Directory with the checks in cfg. The depths of a region
+// are served at DepthsPath when the directory has a matrix from indexcov --matrix.
+func Handler(cfg *Config) (http.Handler, error) {
+	if st, err := os.Stat(cfg.Directory); err != nil || !st.IsDir() {
+		return nil, fmt.Errorf("serve: %s is not a directory", cfg.Directory)
+	}
+	files := http.FileServer(http.Dir(cfg.Directory))
+	ms := &matrices{dir: cfg.Directory, m: make(map[string]*openMatrix)}
+	allowed := make(map[string]bool, len(cfg.Allow))
+	for _, a := range cfg.Allow {
+		allowed[strings.TrimSpace(a)] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		if cfg.tls() {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		if cfg.Users != nil || cfg.OIDC != nil {
+			who, err := cfg.identify(r)
+			if err != nil {
+				if cfg.Users != nil {
+					w.Header().Set("WWW-Authenticate", `Basic realm="indexcov", charset="UTF-8"`)
+				} else {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="indexcov"`)
+				}
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if len(allowed) > 0 && !anyAllowed(allowed, who) {
+				log.Printf("serve: %s is not allowed", who[0])
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		if r.URL.Path == DepthsPath {
+			ms.serveDepths(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}), nil
+}
+
+func anyAllowed(allowed map[string]bool, who []string) bool {
+	for _, w := range who {
+		if allowed[w] {
+			return true
+		}
+	}
+	return false
+}
+
+var errUnauthorized = errors.New("serve: unauthorized")
+
+// identify returns the names (user or subject and email) of the requester or an error if
+// neither the basic-auth nor the OIDC check passes.
+func (cfg *Config) identify(r *http.Request) ([]string, error) {
+	if cfg.Users != nil {
+		if user, pass, ok := r.BasicAuth(); ok {
+			want, found := cfg.Users[user]
+			got := sha256.Sum256([]byte(pass))
+			// compare even when the user is not found so the time does not depend on it.
+			if subtle.ConstantTimeCompare(want[:], got[:]) == 1 && found {
+				return []string{user}, nil
+			}
+			return nil, errUnauthorized
+		}
+	}
+	if cfg.OIDC != nil {
+		claims, err := cfg.OIDC.Verify(r)
+		if err != nil {
+			return nil, err
+		}
+		who := []string{claims.Subject}
+		if claims.Email != "" {
+			who = append(who, claims.Email)
+		}
+		return who, nil
+	}
+	return nil, errUnauthorized
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ListenAndServe serves cfg.Directory until there is an error.
+func ListenAndServe(cfg *Config) error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("serve: both a certificate and a key are needed for TLS")
+	}
+	h, err := Handler(cfg)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: cfg.addr(), Handler: h,
+		ReadHeaderTimeout: 10 * time.Second, IdleTimeout: 2 * time.Minute}
+	if !cfg.tls() {
+		if !isLoopback(cfg.addr()) {
+			log.Printf("serve: WARNING: serving %s without TLS. use --cert and --key on a network", cfg.addr())
+		}
+		log.Printf("serve: serving %s at http://%s/", cfg.Directory, cfg.addr())
+		return srv.ListenAndServe()
+	}
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	log.Printf("serve: serving %s at https://%s/", cfg.Directory, cfg.addr())
+	return srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+}
+
+type cliargs struct {
+	Addr         string `arg:"-a,help:host:port to listen on. use 0.0.0.0:PORT to listen on all interfaces"`
+	Cert         string `arg:"help:PEM certificate (chain) for TLS"`
+	Key          string `arg:"help:PEM private key for TLS"`
+	BasicAuth    string `arg:"--basic-auth,help:file of user:password lines (or user:{SHA256}hex) allowed with basic-auth"`
+	OIDCIssuer   string `arg:"--oidc-issuer,help:URL of an OpenID Connect issuer whose bearer tokens are accepted"`
+	OIDCAudience string `arg:"--oidc-audience,help:client id that tokens from --oidc-issuer must be issued for"`
+	OIDCHeader   string `arg:"--oidc-header,help:header with the token. e.g. X-Forwarded-Access-Token from an auth gateway"`
+	Allow        string `arg:"help:comma-delimited users or emails or subjects that are allowed. default is any that authenticate"`
+
+	Directory string `arg:"positional,required,help:indexcov output directory to serve"`
+}
+
+func (c cliargs) Version() string {
+	return fmt.Sprintf("indexcov-serve %s", goleft.Version)
+}
+
+// Main is called from the goleft dispatcher as indexcov-serve.
+func Main() {
+	cli := &cliargs{Addr: "127.0.0.1:8080", OIDCHeader: "Authorization"}
+	p := arg.MustParse(cli)
+	if (cli.Cert == "") != (cli.Key == "") {
+		p.Fail("indexcov-serve: --cert and --key must be given together")
+	}
+	if (cli.OIDCIssuer == "") != (cli.OIDCAudience == "") {
+		p.Fail("indexcov-serve: --oidc-issuer and --oidc-audience must be given together")
+	}
+	cfg := &Config{Directory: cli.Directory, Addr: cli.Addr, CertFile: cli.Cert, KeyFile: cli.Key}
+	if cli.BasicAuth != "" {
+		var err error
+		if cfg.Users, err = ReadUsers(cli.BasicAuth); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cli.OIDCIssuer != "" {
+		cfg.OIDC = &OIDC{Issuer: cli.OIDCIssuer, Audience: cli.OIDCAudience, Header: cli.OIDCHeader}
+	}
+	if cli.Allow != "" {
+		cfg.Allow = strings.Split(cli.Allow, ",")
+	}
+	log.Fatal(ListenAndServe(cfg))
+}
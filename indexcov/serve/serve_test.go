@@ -0,0 +1,204 @@
+package serve_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brentp/goleft/indexcov/matrix"
+	"github.com/brentp/goleft/indexcov/serve"
+)
+
+func outDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "indexcov-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("indexcov"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func get(t *testing.T, h http.Handler, set func(*http.Request)) int {
+	r := httptest.NewRequest("GET", "/", nil)
+	if set != nil {
+		set(r)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestBasicAuth(t *testing.T) {
+	dir := outDir(t)
+	defer os.RemoveAll(dir)
+	users := filepath.Join(dir, "users")
+	sum := sha256.Sum256([]byte("s3cret"))
+	if err := ioutil.WriteFile(users, []byte(fmt.Sprintf("# users\nalice:hunter2\nbob:{SHA256}%x\n", sum)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	u, err := serve.ReadUsers(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := serve.Handler(&serve.Config{Directory: dir, Users: u, Allow: []string{"bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		user, pass string
+		want       int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"bob", "s3cret", http.StatusOK},
+		{"bob", "wrong", http.StatusUnauthorized},
+		{"carol", "s3cret", http.StatusUnauthorized},
+		// alice authenticates but is not in Allow.
+		{"alice", "hunter2", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		got := get(t, h, func(r *http.Request) {
+			if c.user != "" {
+				r.SetBasicAuth(c.user, c.pass)
+			}
+		})
+		if got != c.want {
+			t.Errorf("%s:%s: expected %d, got %d", c.user, c.pass, c.want, got)
+		}
+	}
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	hdr, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	msg := b64(hdr) + "." + b64(body)
+	sum := sha256.Sum256([]byte(msg))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg + "." + b64(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issuer string
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kty": "RSA", "kid": "k1", "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+	issuer = idp.URL
+
+	dir := outDir(t)
+	defer os.RemoveAll(dir)
+	h, err := serve.Handler(&serve.Config{Directory: dir, OIDC: &serve.OIDC{Issuer: issuer, Audience: "indexcov"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(aud interface{}, exp int64) map[string]interface{} {
+		return map[string]interface{}{"iss": issuer, "sub": "u1", "aud": aud, "exp": exp, "email": "u1@example.org"}
+	}
+	cases := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"none", "", http.StatusUnauthorized},
+		{"valid", sign(t, key, "k1", claims("indexcov", exp)), http.StatusOK},
+		{"audience list", sign(t, key, "k1", claims([]string{"other", "indexcov"}, exp)), http.StatusOK},
+		{"wrong audience", sign(t, key, "k1", claims("other", exp)), http.StatusUnauthorized},
+		{"expired", sign(t, key, "k1", claims("indexcov", time.Now().Add(-time.Hour).Unix())), http.StatusUnauthorized},
+		{"wrong key", sign(t, other, "k1", claims("indexcov", exp)), http.StatusUnauthorized},
+		{"unknown key", sign(t, key, "k2", claims("indexcov", exp)), http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		got := get(t, h, func(r *http.Request) {
+			if c.token != "" {
+				r.Header.Set("Authorization", "Bearer "+c.token)
+			}
+		})
+		if got != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, got)
+		}
+	}
+}
+
+func TestDepths(t *testing.T) {
+	dir := outDir(t)
+	defer os.RemoveAll(dir)
+	h, err := serve.Handler(&serve.Config{Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(q string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", serve.DepthsPath+"?"+q, nil))
+		return w.Code, w.Body.String()
+	}
+	if code, _ := query("region=chr1"); code != http.StatusNotFound {
+		t.Errorf("expected 404 without a matrix, got %d", code)
+	}
+
+	mw, err := matrix.Create(filepath.Join(dir, "cohort-indexcov.matrix"), []string{"s1", "s2"}, 16384, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Add("chr1", 5*16384, [][]float32{{1, 1, 0.5, 1, 1}, {1, 1.5, 1, 1, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	code, body := query("region=1:20000-40000&samples=s2")
+	if want := "#chrom\tstart\tend\ts2\nchr1\t16384\t32768\t1.5\nchr1\t32768\t49152\t1\n"; code != http.StatusOK || body != want {
+		t.Errorf("unexpected depths (%d): %q", code, body)
+	}
+	code, body = query("run=cohort&region=chr1")
+	if code != http.StatusOK || strings.Count(body, "\n") != 6 {
+		t.Errorf("unexpected depths of chromosome (%d): %q", code, body)
+	}
+	for q, want := range map[string]int{
+		"region=chr2":             http.StatusNotFound,
+		"region=chr1&samples=s3":  http.StatusNotFound,
+		"region=chr1:0-10":        http.StatusBadRequest,
+		"run=../x&region=chr1":    http.StatusNotFound,
+		"run=other&region=chr1":   http.StatusNotFound,
+		"region=chr1:900000-9e5x": http.StatusBadRequest,
+	} {
+		if code, _ := query(q); code != want {
+			t.Errorf("%s: expected %d, got %d", q, want, code)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package indexcov
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	chartjs "github.com/brentp/go-chartjs"
+	"github.com/brentp/go-chartjs/types"
+	"github.com/brentp/goleft/plots"
+)
+
+// with more samples than this, the sex plot shows the density of samples
+// rather than a point for each sample.
+const maxSexPoints = 1000
+
+// number of hexagons across the x-axis of the density plot.
+const hexCols = 40
+
+// samples in hexagons with fewer than this many samples are drawn as points.
+const minHexCount = 5
+
+// number of density levels drawn. each is a dataset with a different size and shade.
+const densityLevels = 5
+
+type hexKey struct{ i, j int }
+
+// hexbin assigns each point to a hexagon of radius r (in units of the scaled axes).
+// It follows the layout used by d3-hexbin with pointy-topped hexagons but compares the
+// distances to the 2 nearest rows of centers in the units of the axes so that each point
+// is in the hexagon with the nearest center.
+func hexbin(x, y, r float64) (hexKey, float64, float64) {
+	dx, dy := r*2*math.Sin(math.Pi/3), r*1.5
+	py := y / dy
+	pj := math.Round(py)
+	odd := float64(int(pj) & 1)
+	px := x/dx - odd/2
+	pi := math.Round(px)
+	py1 := py - pj
+	if math.Abs(py1)*3 > 1 {
+		px1 := px - pi
+		pi2 := pi + math.Copysign(0.5, px-pi)
+		pj2 := pj + math.Copysign(1, py-pj)
+		px2, py2 := px-pi2, py-pj2
+		if math.Hypot(px1*dx, py1*dy) > math.Hypot(px2*dx, py2*dy) {
+			pi = pi2 + 0.5
+			if odd == 0 {
+				pi = pi2 - 0.5
+			}
+			pj = pj2
+		}
+	}
+	cx := (pi + float64(int(pj)&1)/2) * dx
+	return hexKey{int(pi), int(pj)}, cx, pj * dy
+}
+
+// plotSexDensity draws the sex chromosome copy-numbers of large cohorts as hexagonal bins
+// shaded by the number of samples. Only samples in sparse bins are drawn individually so
+// that outliers can still be identified by name.
+func plotSexDensity(sexes map[string][]float64, chroms []string, samples []string, backgrounds int) (*chartjs.Chart, string, error) {
+	xs, ys := sexes[chroms[0]][backgrounds:], sexes[chroms[1]][backgrounds:]
+	samples = samples[backgrounds:]
+	xmin, xmax := minMax(xs)
+	ymin, ymax := minMax(ys)
+	xr, yr := math.Max(xmax-xmin, 1e-6), math.Max(ymax-ymin, 1e-6)
+	r := 1 / float64(hexCols)
+
+	type cell struct {
+		x, y    float64
+		members []int
+	}
+	cells := make(map[hexKey]*cell)
+	for i := range xs {
+		k, cx, cy := hexbin((xs[i]-xmin)/xr, (ys[i]-ymin)/yr, r)
+		c, ok := cells[k]
+		if !ok {
+			c = &cell{x: xmin + cx*xr, y: ymin + cy*yr}
+			cells[k] = c
+		}
+		c.members = append(c.members, i)
+	}
+
+	chart := chartjs.Chart{Label: "sex"}
+	xa, err := chart.AddXAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Bottom,
+		ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: chroms[0] + " Copy Number",
+			Display: chartjs.True}, Tick: &chartjs.Tick{Min: 0}})
+	if err != nil {
+		return nil, "", err
+	}
+	ya, err := chart.AddYAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Left,
+		ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: chroms[1] + " Copy Number",
+			Display: chartjs.True}, Tick: &chartjs.Tick{Min: 0}})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the tooltip for a density point gives the number of samples in the bin.
+	maxCount := minHexCount
+	for _, c := range cells {
+		if len(c.members) > maxCount {
+			maxCount = len(c.members)
+		}
+	}
+	levels := make([]*plots.XYs, densityLevels)
+	labels := make([][]string, densityLevels)
+	outliers := &plots.XYs{}
+	var outlierNames []string
+	keys := make([]hexKey, 0, len(cells))
+	for k := range cells {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a].j < keys[b].j || (keys[a].j == keys[b].j && keys[a].i < keys[b].i)
+	})
+	for _, k := range keys {
+		c := cells[k]
+		if len(c.members) < minHexCount {
+			for _, i := range c.members {
+				outliers.X = append(outliers.X, xs[i])
+				outliers.Y = append(outliers.Y, ys[i])
+				outlierNames = append(outlierNames, samples[i])
+			}
+			continue
+		}
+		// log-scale so that a few very dense bins don't hide the rest.
+		l := int(float64(densityLevels) * math.Log(float64(len(c.members))/minHexCount) / math.Log(float64(maxCount+1)/minHexCount))
+		if l >= densityLevels {
+			l = densityLevels - 1
+		}
+		if levels[l] == nil {
+			levels[l] = &plots.XYs{}
+		}
+		levels[l].X = append(levels[l].X, c.x)
+		levels[l].Y = append(levels[l].Y, c.y)
+		labels[l] = append(labels[l], fmt.Sprintf("%d samples", len(c.members)))
+	}
+
+	jssamples := make([][]string, 0, densityLevels+1)
+	for l, vals := range levels {
+		if vals == nil {
+			continue
+		}
+		shade := uint8(200 - 160*l/(densityLevels-1))
+		c := &types.RGBA{R: shade, G: shade, B: 255, A: 200}
+		dataset := chartjs.Dataset{Data: vals, Label: fmt.Sprintf("density level %d", l+1), Fill: chartjs.False,
+			PointRadius: float64(4 + l), PointStyle: "rectRot", BorderWidth: 0, BorderColor: c,
+			PointBackgroundColor: c, BackgroundColor: c, ShowLine: chartjs.False, PointHitRadius: 6}
+		dataset.XAxisID = xa
+		dataset.YAxisID = ya
+		chart.AddDataset(dataset)
+		jssamples = append(jssamples, labels[l])
+	}
+	if len(outlierNames) > 0 {
+		c := &types.RGBA{R: 220, G: 20, B: 20, A: 240}
+		dataset := chartjs.Dataset{Data: outliers, Label: "outliers", Fill: chartjs.False, PointRadius: 4, BorderWidth: 0,
+			BorderColor: c, PointBackgroundColor: c, BackgroundColor: c, ShowLine: chartjs.False, PointHitRadius: 6}
+		dataset.XAxisID = xa
+		dataset.YAxisID = ya
+		chart.AddDataset(dataset)
+		jssamples = append(jssamples, outlierNames)
+	}
+
+	sjson, err := json.Marshal(jssamples)
+	if err != nil {
+		return nil, "", err
+	}
+	jsfunc := fmt.Sprintf(`
+	chart.options.hover.mode = 'index'
+	chart.options.tooltips.callbacks.title = function(tts, data) {
+		var names = %s
+		var out = []
+		tts.forEach(function(ti) {
+			out.push(names[ti.datasetIndex][ti.index])
+		})
+		return out.join(",")
+	}`, sjson)
+	chart.Options.Responsive = chartjs.False
+	chart.Options.Tooltip = &chartjs.Tooltip{Mode: "nearest"}
+	return &chart, jsfunc, nil
+}
+
+func minMax(vals []float64) (float64, float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range vals {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	return min, max
+}
@@ -0,0 +1,121 @@
+package indexcov
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/brentp/goleft/plots"
+)
+
+func TestHexbin(t *testing.T) {
+	const r = 0.1
+	if k, x, y := hexbin(0, 0, r); k != (hexKey{0, 0}) || x != 0 || y != 0 {
+		t.Errorf("expected the origin to be the center of hexagon 0,0, got %v at %.3f,%.3f", k, x, y)
+	}
+	rng := rand.New(rand.NewSource(4))
+	centers := make(map[hexKey][2]float64)
+	for n := 0; n < 5000; n++ {
+		px, py := rng.Float64(), rng.Float64()
+		k, cx, cy := hexbin(px, py, r)
+		// a pointy-topped hexagon of radius r holds only points within r of its center.
+		if d := math.Hypot(px-cx, py-cy); d > r+1e-9 {
+			t.Fatalf("%.3f,%.3f: expected the center of its hexagon within %.2f, got %.3f,%.3f at %.3f", px, py, r, cx, cy, d)
+		}
+		if c, ok := centers[k]; ok && (c[0] != cx || c[1] != cy) {
+			t.Fatalf("expected a single center for hexagon %v, got %v and %.3f,%.3f", k, c, cx, cy)
+		}
+		centers[k] = [2]float64{cx, cy}
+	}
+	// each point is in the hexagon with the nearest center.
+	for n := 0; n < 1000; n++ {
+		px, py := rng.Float64(), rng.Float64()
+		_, cx, cy := hexbin(px, py, r)
+		d := math.Hypot(px-cx, py-cy)
+		for _, c := range centers {
+			if math.Hypot(px-c[0], py-c[1]) < d-1e-9 {
+				t.Fatalf("%.3f,%.3f: expected the nearest center, got %.3f,%.3f but %.3f,%.3f is nearer", px, py, cx, cy, c[0], c[1])
+			}
+		}
+	}
+}
+
+func TestPlotSexDensity(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	const backgrounds = 3
+	sexes := map[string][]float64{"X": nil, "Y": nil}
+	var samples []string
+	add := func(name string, x, y float64) {
+		samples = append(samples, name)
+		sexes["X"] = append(sexes["X"], x)
+		sexes["Y"] = append(sexes["Y"], y)
+	}
+	for i := 0; i < backgrounds; i++ {
+		// backgrounds far from the cohort would stretch the axes if they were counted.
+		add(fmt.Sprintf("bg%d", i), 10, 10)
+	}
+	for i := 0; i < 1200; i++ {
+		if i%2 == 0 {
+			add(fmt.Sprintf("female%d", i), 2+0.03*rng.NormFloat64(), math.Abs(0.01*rng.NormFloat64()))
+		} else {
+			add(fmt.Sprintf("male%d", i), 1+0.03*rng.NormFloat64(), 1+0.03*rng.NormFloat64())
+		}
+	}
+	add("xxy", 2, 1)
+	add("xo", 1, 0.02)
+	add("xyy", 1, 2)
+
+	chart, js, err := plotSexDensity(sexes, []string{"X", "Y"}, samples, backgrounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names [][]string
+	start := strings.Index(js, "var names = ") + len("var names = ")
+	if err := json.Unmarshal([]byte(js[start:start+strings.Index(js[start:], "\n")]), &names); err != nil {
+		t.Fatal(err)
+	}
+	ds := chart.Data.Datasets
+	if len(names) != len(ds) || len(ds) < 2 {
+		t.Fatalf("expected tooltip names for each of the datasets, got %d names and %d datasets", len(names), len(ds))
+	}
+	total := 0
+	for d, dataset := range ds {
+		xy := dataset.Data.(*plots.XYs)
+		if len(names[d]) != len(xy.X) {
+			t.Errorf("%s: expected a tooltip for each of %d points, got %d", dataset.Label, len(xy.X), len(names[d]))
+		}
+		if dataset.Label == "outliers" {
+			total += len(xy.X)
+			continue
+		}
+		for _, l := range names[d] {
+			var n int
+			if _, err := fmt.Sscanf(l, "%d samples", &n); err != nil || n < minHexCount {
+				t.Errorf("%s: expected at least %d samples in a hexagon, got %q", dataset.Label, minHexCount, l)
+			}
+			total += n
+		}
+	}
+	if total != len(samples)-backgrounds {
+		t.Errorf("expected every sample but the backgrounds to be drawn, got %d of %d", total, len(samples)-backgrounds)
+	}
+	// the aneuploid samples and the few at the edges of the clusters are drawn by name.
+	outliers := names[len(names)-1]
+	sort.Strings(outliers)
+	if ds[len(ds)-1].Label != "outliers" || len(outliers) > 50 {
+		t.Fatalf("expected a few outliers, got %v", outliers)
+	}
+	for _, s := range []string{"xo", "xxy", "xyy"} {
+		if i := sort.SearchStrings(outliers, s); i == len(outliers) || outliers[i] != s {
+			t.Errorf("expected %s to be drawn by name, got %v", s, outliers)
+		}
+	}
+	// the densest hexagons are in the last level.
+	if !strings.HasPrefix(ds[len(ds)-2].Label, fmt.Sprintf("density level %d", densityLevels)) {
+		t.Errorf("expected the densest hexagons in level %d, got %s", densityLevels, ds[len(ds)-2].Label)
+	}
+}
@@ -0,0 +1,394 @@
+package indexcov
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/brentp/goleft"
+	"gonum.org/v1/gonum/mat"
+)
+
+// singlePoints is the most points per sample drawn for the depth of a chromosome in the
+// single-file report. Adjacent bins are averaged to keep the file small.
+const singlePoints = 1000
+
+// singleReport holds the data of the plots written to the single-file report. It is filled by
+// run for each chromosome and by writeIndex for the sample plots.
+type singleReport struct {
+	Name        string        `json:"name"`
+	Version     string        `json:"version"`
+	Samples     []string      `json:"samples"`
+	Backgrounds int           `json:"backgrounds"`
+	ROCStep     float64       `json:"roc_step"`
+	Chroms      []singleChrom `json:"chroms"`
+	Scatters    []singleXY    `json:"scatters"`
+	Columns     []string      `json:"columns"`
+	Rows        [][]string    `json:"rows"`
+	// Ideograms are the thumbnails of each sample as data URIs. They are only set with --ideogram.
+	Ideograms []string `json:"ideograms,omitempty"`
+}
+
+// singleChrom is the depth and ROC of each sample on a chromosome. When there are too many
+// samples to draw the depths, PNG holds the static plot as a data URI instead.
+type singleChrom struct {
+	Name string `json:"name"`
+	// Step is the length of each depth point.
+	Step   int         `json:"step"`
+	Depths [][]float32 `json:"depths,omitempty"`
+	PNG    string      `json:"png,omitempty"`
+	ROC    [][]float32 `json:"roc"`
+}
+
+// singleXY is a scatter of a value of each sample. Values that are not known are null.
+type singleXY struct {
+	ID     string      `json:"id"`
+	XLabel string      `json:"xlabel"`
+	YLabel string      `json:"ylabel"`
+	X      []jsonFloat `json:"x"`
+	Y      []jsonFloat `json:"y"`
+	// Group gives the color of each point, e.g. the inferred sex.
+	Group []string `json:"group,omitempty"`
+}
+
+func newSingleReport(name string, samples []string, backgrounds int) *singleReport {
+	return &singleReport{Name: name, Version: goleft.Version, Samples: samples, Backgrounds: backgrounds,
+		ROCStep: 1 / (slots * slotsMid)}
+}
+
+func round3(v float32) float32 {
+	return float32(math.Round(float64(v)*1000) / 1000)
+}
+
+// addChrom adds the depths and ROCs of a chromosome with bins of the given width. png is the
+// static depth plot which is used when there are more than maxSamples.
+func (s *singleReport) addChrom(chrom string, depths [][]float32, rocs [][]float32, width int, png string) error {
+	c := singleChrom{Name: chrom, Step: width, ROC: make([][]float32, len(rocs))}
+	for k, roc := range rocs {
+		c.ROC[k] = make([]float32, len(roc))
+		for i, v := range roc {
+			c.ROC[k][i] = round3(v)
+		}
+	}
+	if len(depths) > maxSamples {
+		b, err := ioutil.ReadFile(png)
+		if err != nil {
+			return err
+		}
+		c.PNG = "data:image/png;base64," + base64.StdEncoding.EncodeToString(b)
+		s.Chroms = append(s.Chroms, c)
+		return nil
+	}
+	n := 0
+	for _, d := range depths {
+		n = imax(n, len(d))
+	}
+	per := (n + singlePoints - 1) / singlePoints
+	if per < 1 {
+		per = 1
+	}
+	c.Step = width * per
+	c.Depths = make([][]float32, len(depths))
+	for k, d := range depths {
+		c.Depths[k] = aggregate(d, per)
+		for i, v := range c.Depths[k] {
+			c.Depths[k][i] = round3(v)
+		}
+	}
+	s.Chroms = append(s.Chroms, c)
+	return nil
+}
+
+// addXY adds a scatter of 2 values of each sample.
+func (s *singleReport) addXY(id, xlabel, ylabel string, xs, ys []float64, group []string) {
+	xy := singleXY{ID: id, XLabel: xlabel, YLabel: ylabel, X: make([]jsonFloat, len(xs)), Y: make([]jsonFloat, len(ys)), Group: group}
+	for i := range xs {
+		xy.X[i], xy.Y[i] = round4(xs[i]), round4(ys[i])
+	}
+	s.Scatters = append(s.Scatters, xy)
+}
+
+// write writes the report with the ped table t to path.
+func (s *singleReport) write(path string, t *sampleTable) error {
+	s.Columns, s.Rows = t.columns, t.rows
+	tmpl, err := template.New("report").Parse(singleTemplate)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, s); err != nil {
+		f.Close()
+		return fmt.Errorf("indexcov: error writing %s: %s", path, err)
+	}
+	return f.Close()
+}
+
+// singleTemplate is the single-file report. It has no external scripts or styles so that it
+// can be viewed without network access. The plots are drawn on canvases from the data.
+const singleTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Name }}:indexcov report</title>
+<style type="text/css">
+body { font-family: Helvetica, Arial, sans-serif; margin: 12px; color: #222; }
+header { display: flex; flex-wrap: wrap; align-items: center; gap: 16px; padding-bottom: 8px; border-bottom: 1px solid #ccc; }
+header h1 { font-size: 1.3em; margin: 0; }
+.plots { display: flex; flex-wrap: wrap; gap: 12px; margin-top: 12px; }
+.plot { border: 1px solid #ddd; padding: 4px; position: relative; }
+.plot h3 { font-size: 0.95em; margin: 2px 4px; }
+#tip { position: absolute; display: none; background: #fff; border: 1px solid #888; padding: 2px 4px; font-size: 12px; pointer-events: none; z-index: 2; }
+table { border-collapse: collapse; font-size: 12px; margin-top: 12px; }
+img.ideo { display: block; image-rendering: pixelated; }
+td, th { border: 1px solid #ddd; padding: 2px 6px; text-align: right; }
+tr.hit td { background: #fde0dc; }
+#matches { font-size: 12px; color: #555; }
+.version { font-size: 12px; color: #777; }
+</style>
+</head>
+<body>
+<header>
+	<h1>{{ .Name }}: indexcov</h1>
+	<label>chromosome <select id="chrom"></select></label>
+	<label>samples <input id="search" type="text" size="30" placeholder="names or parts of names, comma-separated"></label>
+	<span id="matches"></span>
+	<span class="version">goleft {{ .Version }}</span>
+</header>
+<div class="plots">
+	<div class="plot"><h3 id="depth-title">depth</h3><canvas id="depth" width="820" height="380"></canvas><img id="depth-png" style="display:none;max-width:820px"></div>
+	<div class="plot"><h3 id="roc-title">coverage</h3><canvas id="roc" width="460" height="380"></canvas></div>
+</div>
+<div class="plots" id="scatters"></div>
+<table id="table"></table>
+<div id="tip"></div>
+<script>
+var data = {{ . }};
+var highlight = {};
+var nhigh = 0;
+
+function color(i, alpha) {
+	if (i < data.backgrounds) { return "rgba(180,180,180," + alpha + ")" }
+	return "hsla(" + ((i * 137.5) % 360) + ",70%,45%," + alpha + ")"
+}
+
+function style(i) {
+	if (nhigh == 0) { return {c: color(i, 0.8), w: 1.2} }
+	if (highlight[i]) { return {c: color(i, 1), w: 2.5} }
+	return {c: "rgba(200,200,200,0.5)", w: 1}
+}
+
+function ticks(lo, hi) {
+	var span = hi - lo, step = Math.pow(10, Math.floor(Math.log10(span / 5)));
+	if (span / step > 10) { step *= 2 }
+	if (span / step > 10) { step *= 2.5 }
+	var out = [];
+	for (var v = Math.ceil(lo / step) * step; v <= hi + step * 1e-9; v += step) { out.push(v) }
+	return out
+}
+
+function fmt(v) {
+	if (Math.abs(v) >= 1e6) { return (v / 1e6).toFixed(1) + "MB" }
+	return +v.toFixed(3) + ""
+}
+
+// plot draws series of {xs, ys, i, line} on a canvas and keeps the screen positions of the points
+// for tooltips. i is the sample index.
+function plot(canvas, p) {
+	var ctx = canvas.getContext("2d"), W = canvas.width, H = canvas.height;
+	var L = 60, R = 10, T = 10, B = 45;
+	ctx.clearRect(0, 0, W, H);
+	var xlo = p.xmin, xhi = p.xmax, ylo = p.ymin, yhi = p.ymax;
+	p.series.forEach(function(s) {
+		for (var j = 0; j < s.xs.length; j++) {
+			if (s.ys[j] === null || s.xs[j] === null) { continue }
+			if (p.xmin === undefined) { xlo = xlo === undefined ? s.xs[j] : Math.min(xlo, s.xs[j]) }
+			if (p.xmax === undefined) { xhi = xhi === undefined ? s.xs[j] : Math.max(xhi, s.xs[j]) }
+			if (p.ymin === undefined) { ylo = ylo === undefined ? s.ys[j] : Math.min(ylo, s.ys[j]) }
+			if (p.ymax === undefined) { yhi = yhi === undefined ? s.ys[j] : Math.max(yhi, s.ys[j]) }
+		}
+	});
+	if (xlo === undefined) { xlo = 0; xhi = 1; ylo = 0; yhi = 1 }
+	if (xhi == xlo) { xhi = xlo + 1 }
+	if (yhi == ylo) { yhi = ylo + 1 }
+	var sx = function(x) { return L + (x - xlo) / (xhi - xlo) * (W - L - R) };
+	var sy = function(y) { return H - B - (y - ylo) / (yhi - ylo) * (H - T - B) };
+	ctx.strokeStyle = "#888"; ctx.fillStyle = "#333"; ctx.lineWidth = 1; ctx.font = "11px sans-serif";
+	ctx.beginPath(); ctx.moveTo(L, T); ctx.lineTo(L, H - B); ctx.lineTo(W - R, H - B); ctx.stroke();
+	ctx.textAlign = "center";
+	ticks(xlo, xhi).forEach(function(v) { ctx.fillText(fmt(v), sx(v), H - B + 14) });
+	ctx.fillText(p.xlabel, L + (W - L - R) / 2, H - 8);
+	ctx.textAlign = "right";
+	ticks(ylo, yhi).forEach(function(v) { ctx.fillText(fmt(v), L - 4, sy(v) + 4) });
+	ctx.save(); ctx.translate(14, T + (H - T - B) / 2); ctx.rotate(-Math.PI / 2); ctx.textAlign = "center";
+	ctx.fillText(p.ylabel, 0, 0); ctx.restore();
+	ctx.save(); ctx.beginPath(); ctx.rect(L, T, W - L - R, H - T - B); ctx.clip();
+	canvas.points = [];
+	// highlighted samples are drawn last so they are on top.
+	var order = p.series.slice().sort(function(a, b) { return (highlight[a.i] ? 1 : 0) - (highlight[b.i] ? 1 : 0) });
+	order.forEach(function(s) {
+		var st = style(s.i);
+		ctx.strokeStyle = st.c; ctx.fillStyle = st.c; ctx.lineWidth = st.w;
+		if (s.line) { ctx.beginPath() }
+		var started = false;
+		for (var j = 0; j < s.xs.length; j++) {
+			if (s.ys[j] === null || s.xs[j] === null) { started = false; continue }
+			var x = sx(s.xs[j]), y = sy(Math.max(ylo, Math.min(yhi, s.ys[j])));
+			if (s.line) {
+				if (!started) { ctx.moveTo(x, y); started = true } else { ctx.lineTo(x, y) }
+				if (s.step) { ctx.lineTo(sx(s.xs[j] + s.step), y) }
+			} else {
+				ctx.beginPath(); ctx.arc(x, y, highlight[s.i] ? 5 : 3, 0, 2 * Math.PI); ctx.fill();
+			}
+			canvas.points.push([x, y, s.i, s.label]);
+		}
+		if (s.line) { ctx.stroke() }
+	});
+	ctx.restore();
+}
+
+function tooltip(canvas) {
+	var tip = document.getElementById("tip");
+	canvas.addEventListener("mousemove", function(e) {
+		var r = canvas.getBoundingClientRect(), mx = e.clientX - r.left, my = e.clientY - r.top;
+		var best = null, bd = 64;
+		(canvas.points || []).forEach(function(p) {
+			var d = (p[0] - mx) * (p[0] - mx) + (p[1] - my) * (p[1] - my);
+			if (d < bd) { bd = d; best = p }
+		});
+		if (best === null) { tip.style.display = "none"; return }
+		tip.textContent = best[3] || data.samples[best[2]];
+		tip.style.left = (e.pageX + 12) + "px"; tip.style.top = (e.pageY + 12) + "px";
+		tip.style.display = "block";
+	});
+	canvas.addEventListener("mouseleave", function() { tip.style.display = "none" });
+	canvas.addEventListener("click", function() {
+		if (tip.style.display == "block") { document.getElementById("search").value = tip.textContent; search() }
+	});
+}
+
+function drawChrom() {
+	var c = data.chroms[document.getElementById("chrom").value];
+	if (!c) { return }
+	var canvas = document.getElementById("depth"), img = document.getElementById("depth-png");
+	document.getElementById("depth-title").textContent = "scaled depth of " + c.name;
+	document.getElementById("roc-title").textContent = "coverage of " + c.name;
+	if (c.png) {
+		canvas.style.display = "none"; img.style.display = ""; img.src = c.png;
+	} else {
+		canvas.style.display = ""; img.style.display = "none";
+		plot(canvas, {xlabel: "position on " + c.name, ylabel: "scaled coverage", ymin: 0, ymax: 2.5,
+			series: c.depths.map(function(d, i) {
+				return {i: i, line: true, step: c.step, xs: d.map(function(v, j) { return j * c.step }), ys: d}
+			})});
+	}
+	plot(document.getElementById("roc"), {xlabel: "scaled coverage for " + c.name, ylabel: "proportion of regions covered",
+		xmin: 0, xmax: 1.5, ymin: 0, ymax: 1,
+		series: c.roc.map(function(r, i) {
+			return {i: i, line: true, xs: r.map(function(v, j) { return j * data.roc_step }), ys: r}
+		})});
+}
+
+function drawScatters() {
+	data.scatters.forEach(function(s) {
+		var canvas = document.getElementById("scatter-" + s.id);
+		plot(canvas, {xlabel: s.xlabel, ylabel: s.ylabel, series: s.x.map(function(x, i) {
+			return {i: i, xs: [x], ys: [s.y[i]], label: data.samples[i] + (s.group ? " (" + s.group[i] + ")" : "")}
+		})});
+	});
+}
+
+function drawTable() {
+	var t = document.getElementById("table"), html = "<tr>";
+	data.columns.forEach(function(c) { html += "<th>" + esc(c) + "</th>" });
+	if (data.ideograms) { html += "<th>ideogram</th>" }
+	html += "</tr>";
+	data.rows.forEach(function(r) {
+		var i = data.samples.indexOf(r[1]);
+		html += "<tr" + (highlight[i] ? " class=hit" : "") + ">";
+		r.forEach(function(v) { html += "<td>" + esc(v) + "</td>" });
+		if (data.ideograms) { html += "<td>" + (i >= 0 ? "<img class=ideo src=\"" + data.ideograms[i] + "\">" : "") + "</td>" }
+		html += "</tr>";
+	});
+	t.innerHTML = html;
+}
+
+function esc(s) {
+	return String(s).replace(/[&<>"]/g, function(c) { return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"}[c] })
+}
+
+function search() {
+	var terms = document.getElementById("search").value.toLowerCase().split(",").map(function(t) { return t.trim() })
+		.filter(function(t) { return t.length > 0 });
+	highlight = {}; nhigh = 0;
+	var names = [];
+	data.samples.forEach(function(s, i) {
+		var l = s.toLowerCase();
+		if (terms.some(function(t) { return l.indexOf(t) >= 0 })) { highlight[i] = true; nhigh++; names.push(s) }
+	});
+	document.getElementById("matches").textContent = terms.length == 0 ? "" :
+		nhigh + " matching: " + names.slice(0, 10).join(", ") + (names.length > 10 ? ", ..." : "");
+	drawChrom(); drawScatters(); drawTable();
+}
+
+(function() {
+	var sel = document.getElementById("chrom");
+	data.chroms.forEach(function(c, i) {
+		var o = document.createElement("option"); o.value = i; o.textContent = c.name; sel.appendChild(o);
+	});
+	sel.addEventListener("change", drawChrom);
+	var div = document.getElementById("scatters");
+	data.scatters.forEach(function(s) {
+		var d = document.createElement("div"); d.className = "plot";
+		var c = document.createElement("canvas"); c.id = "scatter-" + s.id; c.width = 420; c.height = 360;
+		d.appendChild(c); div.appendChild(d); tooltip(c);
+	});
+	tooltip(document.getElementById("depth")); tooltip(document.getElementById("roc"));
+	document.getElementById("search").addEventListener("input", search);
+	search();
+})();
+</script>
+</body>
+</html>
+`
+
+// addSingleScatters adds the sex, bin, PCA and read count plots of index.html to the report.
+func addSingleScatters(s *singleReport, keys []string, sexes map[string][]float64, counts []*counter, pcs *mat.Dense, fit *pcaFit, mapped, unmapped []uint64) {
+	n := len(s.Samples)
+	if len(keys) > 1 && sexes[keys[0]] != nil && sexes[keys[1]] != nil {
+		group := make([]string, n)
+		for i, v := range sexes["_inferred"] {
+			group[i] = fmt.Sprintf("sex: %d", int(v))
+		}
+		s.addXY("sex", keys[0]+" Copy Number", keys[1]+" Copy Number", sexes[keys[0]], sexes[keys[1]], group)
+	}
+	lo, out := make([]float64, n), make([]float64, n)
+	for i, c := range counts {
+		lo[i], out[i] = math.NaN(), math.NaN()
+		if c != nil {
+			tot := math.Max(float64(c.in+c.out), 1)
+			lo[i], out[i] = float64(c.low)/tot, float64(c.out)/tot
+		}
+	}
+	s.addXY("bins", "proportion of bins with depth < 0.15", "proportion of bins with depth outside of (0.85, 1.15)", lo, out, nil)
+	if pcs != nil && fit != nil {
+		if _, c := pcs.Dims(); c > 2 {
+			for _, pc := range []int{2, 3} {
+				s.addXY(fmt.Sprintf("pca-1-%d", pc), fmt.Sprintf("PC1 (variance explained: %.2f%%)", 100*fit.vars[0]),
+					fmt.Sprintf("PC%d (variance explained: %.2f%%)", pc, 100*fit.vars[pc-1]), mat.Col(nil, 0, pcs), mat.Col(nil, pc-1, pcs), nil)
+			}
+		}
+	}
+	if mapped != nil {
+		lm, lu := make([]float64, n), make([]float64, n)
+		for i := range mapped {
+			lm[i], lu[i] = math.Log10(float64(mapped[i])+1), math.Log10(float64(unmapped[i])+1)
+		}
+		s.addXY("mapped", "log10(mapped reads)", "log10(unmapped reads)", lm, lu, nil)
+	}
+}
@@ -0,0 +1,283 @@
+package indexcov
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+
+	"github.com/brentp/goleft/grid"
+	"github.com/brentp/xopen"
+	"gonum.org/v1/gonum/mat"
+)
+
+// pcaSketch is a frequent-directions sketch (Liberty, 2013) of the (weighted) PCA matrix of
+// a set of samples. Sketches of disjoint sets of samples with the same tiles can be merged so
+// that the PCA of a cohort can be found from runs on parts of it without the full matrix.
+type pcaSketch struct {
+	// Ell is the number of directions kept. Rows are shrunk when 2 * Ell are buffered.
+	Ell int
+	// Width is the length of each tile from --window.
+	Width int
+	// Grid is the name of the grid of the tiles. It is empty for sketches from before it was added.
+	Grid   string
+	Chroms []string
+	Tiles  []int
+	// Weights scale each tile. nil is unweighted.
+	Weights []float64
+	// N is the number of samples and Sums and SumSqs are the sums of each (weighted) tile so
+	// that the sketch can be centered and the total variance is exact.
+	N      int
+	Sums   []float64
+	SumSqs []float64
+	Rows   [][]float64
+}
+
+func newPCASketch(ell int, tiles []pcaTile, width int, weights []float64) *pcaSketch {
+	s := &pcaSketch{Ell: ell, Width: width, Weights: weights, Sums: make([]float64, len(tiles)), SumSqs: make([]float64, len(tiles))}
+	for _, t := range tiles {
+		s.Chroms = append(s.Chroms, t.chrom)
+		s.Tiles = append(s.Tiles, t.tile)
+	}
+	return s
+}
+
+func (s *pcaSketch) tiles() []pcaTile {
+	tiles := make([]pcaTile, len(s.Tiles))
+	for i, t := range s.Tiles {
+		tiles[i] = pcaTile{chrom: s.Chroms[i], tile: t}
+	}
+	return tiles
+}
+
+// add adds the depths of a sample on the 0-255 scale of the PCA matrix.
+func (s *pcaSketch) add(x []uint8) {
+	row := make([]float64, len(x))
+	for j, v := range x {
+		row[j] = float64(v)
+		if s.Weights != nil {
+			row[j] *= s.Weights[j]
+		}
+		s.Sums[j] += row[j]
+		s.SumSqs[j] += row[j] * row[j]
+	}
+	s.N++
+	s.push(row)
+}
+
+func (s *pcaSketch) push(row []float64) {
+	s.Rows = append(s.Rows, row)
+	if len(s.Rows) >= 2*s.Ell {
+		s.shrink()
+	}
+}
+
+// shrink reduces the rows to at most Ell by subtracting the Ell'th squared singular value
+// from all of them.
+func (s *pcaSketch) shrink() {
+	r, m := len(s.Rows), len(s.Sums)
+	b := mat.NewDense(r, m, nil)
+	for i, row := range s.Rows {
+		b.SetRow(i, row)
+	}
+	var svd mat.SVD
+	if ok := svd.Factorize(b, mat.SVDThin); !ok {
+		// keep the rows; they are still a valid, if larger, sketch.
+		return
+	}
+	sv := svd.Values(nil)
+	v := svd.VTo(nil)
+	delta := 0.0
+	if len(sv) > s.Ell {
+		delta = sv[s.Ell] * sv[s.Ell]
+	}
+	rows := make([][]float64, 0, s.Ell)
+	for i := 0; i < len(sv) && i < s.Ell; i++ {
+		sigma := math.Sqrt(math.Max(sv[i]*sv[i]-delta, 0))
+		if sigma == 0 {
+			break
+		}
+		row := make([]float64, m)
+		for j := range row {
+			row[j] = sigma * v.At(j, i)
+		}
+		rows = append(rows, row)
+	}
+	s.Rows = rows
+}
+
+// merge adds the samples in o to s. The sketches must have the same tiles and weights.
+func (s *pcaSketch) merge(o *pcaSketch) error {
+	if o.Width != s.Width {
+		return fmt.Errorf("indexcov: sketches have different --window: %d and %d", s.Width, o.Width)
+	}
+	if s.Grid != "" && o.Grid != "" {
+		a, err := grid.Parse(s.Grid)
+		if err != nil {
+			return err
+		}
+		b, err := grid.Parse(o.Grid)
+		if err != nil {
+			return err
+		}
+		if err := a.Check(b); err != nil {
+			return fmt.Errorf("indexcov: sketches are not on the same grid: %s", err)
+		}
+		// keep the build if only one of the sketches knew it.
+		if a.Build == "" {
+			s.Grid = o.Grid
+		}
+	} else if s.Grid == "" {
+		s.Grid = o.Grid
+	}
+	if len(o.Tiles) != len(s.Tiles) {
+		return fmt.Errorf("indexcov: sketches have different numbers of tiles: %d and %d", len(s.Tiles), len(o.Tiles))
+	}
+	for j := range s.Tiles {
+		if s.Tiles[j] != o.Tiles[j] || !defaultAliases.same(s.Chroms[j], o.Chroms[j]) {
+			return fmt.Errorf("indexcov: sketches have different tiles at %s:%d and %s:%d", s.Chroms[j], s.Tiles[j]*s.Width, o.Chroms[j], o.Tiles[j]*o.Width)
+		}
+		if (s.Weights == nil) != (o.Weights == nil) || (s.Weights != nil && s.Weights[j] != o.Weights[j]) {
+			return fmt.Errorf("indexcov: sketches have different --pca-weight")
+		}
+	}
+	for j := range s.Sums {
+		s.Sums[j] += o.Sums[j]
+		s.SumSqs[j] += o.SumSqs[j]
+	}
+	s.N += o.N
+	for _, row := range o.Rows {
+		s.push(row)
+	}
+	return nil
+}
+
+// fit finds the top k components of the centered matrix from the sketch. The covariance
+// B'B - N μμ' has rank at most Ell+1 so it is found from a QR of Z = [B' μ].
+func (s *pcaSketch) fit(k int) (*pcaFit, error) {
+	m := len(s.Sums)
+	r := len(s.Rows)
+	if s.N < 3 || r == 0 {
+		return nil, fmt.Errorf("indexcov: at least 3 samples are needed for the PCA. got %d", s.N)
+	}
+	n := float64(s.N)
+	c := r + 1
+	// z is m × c stored by row.
+	z := make([]float64, m*c)
+	means := make([]float64, m)
+	total := 0.0
+	for j := 0; j < m; j++ {
+		for i, row := range s.Rows {
+			z[j*c+i] = row[j]
+		}
+		means[j] = s.Sums[j] / n
+		z[j*c+r] = means[j]
+		total += s.SumSqs[j] - n*means[j]*means[j]
+	}
+	q := append([]float64{}, z...)
+	orthonormalize(q, c)
+	// R = Q'Z so that Z = QR and the covariance is Q R D R' Q' with D = diag(1, ..., 1, -N).
+	rr := mat.NewDense(c, c, nil)
+	for j := 0; j < m; j++ {
+		for a := 0; a < c; a++ {
+			qa := q[j*c+a]
+			if qa == 0 {
+				continue
+			}
+			for b := 0; b < c; b++ {
+				rr.Set(a, b, rr.At(a, b)+qa*z[j*c+b])
+			}
+		}
+	}
+	d := mat.NewDiagDense(c, nil)
+	for i := 0; i < r; i++ {
+		d.SetDiag(i, 1)
+	}
+	d.SetDiag(r, -n)
+	var tmp, sm mat.Dense
+	tmp.Mul(rr, d)
+	sm.Mul(&tmp, rr.T())
+	sym := mat.NewSymDense(c, nil)
+	for i := 0; i < c; i++ {
+		for j := i; j < c; j++ {
+			sym.SetSym(i, j, (sm.At(i, j)+sm.At(j, i))/2)
+		}
+	}
+	var eig mat.EigenSym
+	if ok := eig.Factorize(sym, true); !ok {
+		return nil, fmt.Errorf("indexcov: error with principal components of sketch")
+	}
+	vals := eig.Values(nil)
+	var vecs mat.Dense
+	eig.VectorsTo(&vecs)
+	if k > c {
+		k = c
+	}
+	loadings := mat.NewDense(m, k, nil)
+	vars := make([]float64, k)
+	// eigen values are in ascending order.
+	for t := 0; t < k; t++ {
+		col := c - 1 - t
+		l := make([]float64, m)
+		for j := range l {
+			for a := 0; a < c; a++ {
+				l[j] += q[j*c+a] * vecs.At(a, col)
+			}
+		}
+		// flip the sign so that the largest loading is positive.
+		sign, big := 1.0, 0.0
+		for _, v := range l {
+			if math.Abs(v) > big {
+				big, sign = math.Abs(v), math.Copysign(1, v)
+			}
+		}
+		for j, v := range l {
+			loadings.Set(j, t, sign*v)
+		}
+		if total > 0 {
+			vars[t] = math.Max(vals[col], 0) / total
+		}
+	}
+	// means are written on the unweighted scale.
+	if s.Weights != nil {
+		for j, w := range s.Weights {
+			if w != 0 {
+				means[j] /= w
+			}
+		}
+	}
+	return &pcaFit{means: means, weights: s.Weights, loadings: loadings, vars: vars}, nil
+}
+
+// write saves the sketch so that it can be merged with sketches of other samples.
+func (s *pcaSketch) write(path string) error {
+	fh, err := getWriter(path, 1)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(fh).Encode(s); err != nil {
+		return err
+	}
+	return fh.Close()
+}
+
+func readPCASketch(path string) (*pcaSketch, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	s := &pcaSketch{}
+	if err := gob.NewDecoder(rdr).Decode(s); err != nil {
+		return nil, fmt.Errorf("indexcov: error reading sketch %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// sketchPCA returns the sketch of the PCA matrix of a run.
+func sketchPCA(x [][]uint8, tiles []pcaTile, width int, weights []float64, k int) *pcaSketch {
+	s := newPCASketch(k+pcaOversample, tiles, width, weights)
+	for _, row := range x {
+		s.add(row)
+	}
+	return s
+}
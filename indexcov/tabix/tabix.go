@@ -0,0 +1,451 @@
+// Package tabix writes and reads the tabix index (.tbi) of the bgzipped beds written by indexcov so
+// that a region can be read without decompressing the whole file and without tabix on the PATH.
+//
+// Write builds the bins and the linear index as tabix -p bed does so the index can also be used by
+// tabix and htslib. Reading only uses the linear index which gives the offset of the first line
+// that overlaps each 16KB window of a chromosome.
+package tabix
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	minShift = 14
+	// maxLen is the longest chromosome that can be indexed with the bins of a .tbi.
+	maxLen = 1 << 29
+	// formatBed is the tabix preset for 0-based, half-open beds.
+	formatBed = 0x10000
+	meta      = '#'
+	// blockSize is the most data put in a bgzf block.
+	blockSize = 0xff00
+)
+
+var tbiMagic = [4]byte{'T', 'B', 'I', 1}
+
+// eofBlock is the empty bgzf block that ends a bgzf file.
+var eofBlock = []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff, 6, 0, 'B', 'C', 2, 0, 0x1b, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// Index is the linear index of a bgzipped bed.
+type Index struct {
+	// Names are the chromosomes in the order of the file.
+	Names  []string
+	linear [][]uint64
+}
+
+type chunk struct{ beg, end uint64 }
+
+// ref is the index of a chromosome as it is built.
+type ref struct {
+	bins   map[uint32][]chunk
+	linear []uint64
+	last   int
+}
+
+// builder indexes the lines of a file in order.
+type builder struct {
+	names []string
+	refs  []*ref
+	seen  map[string]bool
+}
+
+// Write indexes the bgzipped bed at path, which must be sorted by position within each chromosome,
+// and writes the index to path + ".tbi". Lines that start with '#' are the header. An error is
+// returned for a file that is not bgzipped or that has a chromosome longer than 512Mb. An earlier
+// index is removed if there is an error so that it is not used with the new file.
+func Write(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b := &builder{seen: make(map[string]bool)}
+	if err := b.scan(bufio.NewReaderSize(f, 1<<20)); err != nil {
+		os.Remove(path + ".tbi")
+		return fmt.Errorf("tabix: %s: %s", path, err)
+	}
+	out, err := os.Create(path + ".tbi")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	err = writeBGZF(bw, b.encode())
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".tbi")
+	}
+	return err
+}
+
+// scan reads each bgzf block of r and indexes its lines by their virtual offsets: the offset of
+// the block in the file shifted left 16 bits and the offset in the block.
+func (b *builder) scan(r *bufio.Reader) error {
+	var coff uint64
+	var line []byte
+	var lineStart uint64
+	inLine := false
+	for {
+		data, size, err := readBlock(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(data); {
+			if !inLine {
+				lineStart, inLine = coff<<16|uint64(i), true
+			}
+			j := bytes.IndexByte(data[i:], '\n')
+			if j == -1 {
+				line = append(line, data[i:]...)
+				break
+			}
+			line = append(line, data[i:i+j]...)
+			if err := b.add(line, lineStart, coff<<16|uint64(i+j+1)); err != nil {
+				return err
+			}
+			line, inLine = line[:0], false
+			i += j + 1
+		}
+		coff += uint64(size)
+	}
+	if inLine {
+		return b.add(line, lineStart, coff<<16)
+	}
+	return nil
+}
+
+// readBlock returns the uncompressed data of the next bgzf block of r and the size of the block.
+func readBlock(r *bufio.Reader) ([]byte, int, error) {
+	var hdr [12]byte
+	n, err := io.ReadFull(r, hdr[:])
+	if err == io.EOF {
+		return nil, 0, err
+	}
+	if n < 4 || hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 || hdr[3]&4 == 0 {
+		return nil, 0, errors.New("not bgzipped")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	extra := make([]byte, binary.LittleEndian.Uint16(hdr[10:]))
+	if _, err := io.ReadFull(r, extra); err != nil {
+		return nil, 0, err
+	}
+	size := -1
+	for x := extra; len(x) >= 4; {
+		n := int(binary.LittleEndian.Uint16(x[2:]))
+		if len(x) < 4+n {
+			break
+		}
+		if x[0] == 'B' && x[1] == 'C' && n == 2 {
+			size = int(binary.LittleEndian.Uint16(x[4:])) + 1
+		}
+		x = x[4+n:]
+	}
+	rest := size - len(hdr) - len(extra)
+	if size == -1 || rest < 8 {
+		return nil, 0, errors.New("not bgzipped: no block size")
+	}
+	body := make([]byte, rest)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, 0, err
+	}
+	data, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(body[:rest-8])))
+	if err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(body[rest-8:]) || len(data) != int(binary.LittleEndian.Uint32(body[rest-4:])) {
+		return nil, 0, errors.New("corrupt bgzf block")
+	}
+	return data, size, nil
+}
+
+// add indexes a line that spans the virtual offsets [beg, end).
+func (b *builder) add(line []byte, beg, end uint64) error {
+	if len(line) == 0 || line[0] == meta {
+		return nil
+	}
+	toks := bytes.SplitN(line, []byte{'\t'}, 4)
+	if len(toks) < 3 {
+		return fmt.Errorf("expected at least 3 columns in %.50s", line)
+	}
+	s, serr := strconv.Atoi(string(toks[1]))
+	e, eerr := strconv.Atoi(string(toks[2]))
+	if serr != nil || eerr != nil || s < 0 {
+		return fmt.Errorf("bad start or end in %.50s", line)
+	}
+	if e <= s {
+		e = s + 1
+	}
+	if e > maxLen {
+		return fmt.Errorf("%s is longer than the %d bases that can be indexed", toks[0], maxLen)
+	}
+	name := string(toks[0])
+	if len(b.names) == 0 || b.names[len(b.names)-1] != name {
+		if b.seen[name] {
+			return fmt.Errorf("the lines of %s are not together", name)
+		}
+		b.seen[name] = true
+		b.names = append(b.names, name)
+		b.refs = append(b.refs, &ref{bins: make(map[uint32][]chunk)})
+	}
+	rf := b.refs[len(b.refs)-1]
+	if s < rf.last {
+		return fmt.Errorf("%s is not sorted at %d", name, s)
+	}
+	rf.last = s
+
+	bin := reg2bin(s, e)
+	cs := rf.bins[bin]
+	if len(cs) > 0 && cs[len(cs)-1].end == beg {
+		cs[len(cs)-1].end = end
+	} else {
+		rf.bins[bin] = append(cs, chunk{beg, end})
+	}
+	for w := s >> minShift; w <= (e-1)>>minShift; w++ {
+		for len(rf.linear) <= w {
+			rf.linear = append(rf.linear, ^uint64(0))
+		}
+		if rf.linear[w] == ^uint64(0) {
+			rf.linear[w] = beg
+		}
+	}
+	return nil
+}
+
+// reg2bin returns the smallest bin that contains [beg, end) as in the SAM specification.
+func reg2bin(beg, end int) uint32 {
+	end--
+	switch {
+	case beg>>14 == end>>14:
+		return uint32(((1<<15)-1)/7 + (beg >> 14))
+	case beg>>17 == end>>17:
+		return uint32(((1<<12)-1)/7 + (beg >> 17))
+	case beg>>20 == end>>20:
+		return uint32(((1<<9)-1)/7 + (beg >> 20))
+	case beg>>23 == end>>23:
+		return uint32(((1<<6)-1)/7 + (beg >> 23))
+	case beg>>26 == end>>26:
+		return uint32(((1<<3)-1)/7 + (beg >> 26))
+	}
+	return 0
+}
+
+// encode returns the uncompressed .tbi.
+func (b *builder) encode() []byte {
+	var buf bytes.Buffer
+	w := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	var names []byte
+	for _, n := range b.names {
+		names = append(append(names, n...), 0)
+	}
+	w(tbiMagic)
+	// n_ref, format, col_seq, col_beg, col_end, meta, skip and l_nm.
+	w([]int32{int32(len(b.names)), formatBed, 1, 2, 3, meta, 0, int32(len(names))})
+	buf.Write(names)
+	for _, rf := range b.refs {
+		bins := make([]uint32, 0, len(rf.bins))
+		for bin := range rf.bins {
+			bins = append(bins, bin)
+		}
+		sort.Slice(bins, func(i, j int) bool { return bins[i] < bins[j] })
+		w(int32(len(bins)))
+		for _, bin := range bins {
+			w(bin)
+			w(int32(len(rf.bins[bin])))
+			for _, c := range rf.bins[bin] {
+				w([]uint64{c.beg, c.end})
+			}
+		}
+		// windows with no lines get the offset of the next window as tabix does.
+		for i := len(rf.linear) - 2; i >= 0; i-- {
+			if rf.linear[i] == ^uint64(0) {
+				rf.linear[i] = rf.linear[i+1]
+			}
+		}
+		w(int32(len(rf.linear)))
+		w(rf.linear)
+	}
+	return buf.Bytes()
+}
+
+// writeBGZF writes data to w as bgzf blocks followed by the bgzf EOF block.
+func writeBGZF(w io.Writer, data []byte) error {
+	var cbuf bytes.Buffer
+	fw, err := flate.NewWriter(&cbuf, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > blockSize {
+			n = blockSize
+		}
+		cbuf.Reset()
+		fw.Reset(&cbuf)
+		if _, err := fw.Write(data[:n]); err != nil {
+			return err
+		}
+		if err := fw.Close(); err != nil {
+			return err
+		}
+		hdr := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff, 6, 0, 'B', 'C', 2, 0, 0, 0}
+		binary.LittleEndian.PutUint16(hdr[16:], uint16(len(hdr)+cbuf.Len()+8-1))
+		var tail [8]byte
+		binary.LittleEndian.PutUint32(tail[:], crc32.ChecksumIEEE(data[:n]))
+		binary.LittleEndian.PutUint32(tail[4:], uint32(n))
+		for _, p := range [][]byte{hdr, cbuf.Bytes(), tail[:]} {
+			if _, err := w.Write(p); err != nil {
+				return err
+			}
+		}
+		data = data[n:]
+	}
+	_, err = w.Write(eofBlock)
+	return err
+}
+
+// Read reads the index of the bgzipped bed at path from path + ".tbi".
+func Read(path string) (*Index, error) {
+	f, err := os.Open(path + ".tbi")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("tabix: %s.tbi: %s", path, err)
+	}
+	defer gz.Close()
+	idx, err := readIndex(bufio.NewReader(gz))
+	if err != nil {
+		return nil, fmt.Errorf("tabix: %s.tbi: %s", path, err)
+	}
+	return idx, nil
+}
+
+func readIndex(r io.Reader) (*Index, error) {
+	var hdr struct {
+		Magic                                   [4]byte
+		NRef, Format, Seq, Beg, End, Meta, Skip int32
+		LNames                                  int32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("error reading header: %s", err)
+	}
+	if hdr.Magic != tbiMagic {
+		return nil, errors.New("magic number mismatch. not a tabix index")
+	}
+	if hdr.NRef < 0 || hdr.LNames < 0 {
+		return nil, fmt.Errorf("invalid header values: n_ref: %d l_nm: %d", hdr.NRef, hdr.LNames)
+	}
+	names := make([]byte, hdr.LNames)
+	if _, err := io.ReadFull(r, names); err != nil {
+		return nil, fmt.Errorf("error reading names: %s", err)
+	}
+	idx := &Index{}
+	for len(names) > 0 {
+		i := bytes.IndexByte(names, 0)
+		if i == -1 {
+			return nil, errors.New("unterminated name")
+		}
+		idx.Names = append(idx.Names, string(names[:i]))
+		names = names[i+1:]
+	}
+	if len(idx.Names) != int(hdr.NRef) {
+		return nil, fmt.Errorf("expected %d names, got %d", hdr.NRef, len(idx.Names))
+	}
+	var n int32
+	read := func(v interface{}) error { return binary.Read(r, binary.LittleEndian, v) }
+	for i := range idx.Names {
+		if err := read(&n); err != nil {
+			return nil, fmt.Errorf("error reading bins of %s: %s", idx.Names[i], err)
+		}
+		for j := int32(0); j < n; j++ {
+			var bin struct{ Bin, NChunk int32 }
+			if err := read(&bin); err != nil || bin.NChunk < 0 {
+				return nil, fmt.Errorf("error reading bins of %s", idx.Names[i])
+			}
+			if _, err := io.CopyN(ioutil.Discard, r, 16*int64(bin.NChunk)); err != nil {
+				return nil, fmt.Errorf("error reading chunks of %s: %s", idx.Names[i], err)
+			}
+		}
+		if err := read(&n); err != nil || n < 0 {
+			return nil, fmt.Errorf("error reading linear index of %s", idx.Names[i])
+		}
+		linear := make([]uint64, n)
+		if err := read(linear); err != nil {
+			return nil, fmt.Errorf("error reading linear index of %s: %s", idx.Names[i], err)
+		}
+		idx.linear = append(idx.linear, linear)
+	}
+	return idx, nil
+}
+
+// Offset returns the virtual offset of the first line of chromosome ref (an index in Names) that
+// may overlap start or, if start is past the chromosome, of the last window of the chromosome.
+func (idx *Index) Offset(ref, start int) uint64 {
+	l := idx.linear[ref]
+	if len(l) == 0 {
+		return 0
+	}
+	w := start >> minShift
+	if w >= len(l) {
+		w = len(l) - 1
+	}
+	return l[w]
+}
+
+// Region returns a reader of the header lines of the bgzipped bed f followed by the lines from the
+// first of chromosome ref that may overlap start to the end of the file.
+func (idx *Index) Region(f io.ReadSeeker, ref, start int) (io.Reader, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(gz)
+	var hdr bytes.Buffer
+	for {
+		if b, err := br.Peek(1); err != nil || b[0] != meta {
+			break
+		}
+		line, err := br.ReadBytes('\n')
+		hdr.Write(line)
+		if err != nil {
+			break
+		}
+	}
+	off := idx.Offset(ref, start)
+	if _, err := f.Seek(int64(off>>16), io.SeekStart); err != nil {
+		return nil, err
+	}
+	if gz, err = gzip.NewReader(f); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, gz, int64(off&0xffff)); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(&hdr, gz), nil
+}
@@ -0,0 +1,161 @@
+package tabix
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReg2bin(t *testing.T) {
+	cases := []struct {
+		beg, end int
+		bin      uint32
+	}{
+		{0, 1, 4681},
+		{16384, 32768, 4682},
+		{0, 16385, 585},
+		{1 << 17, 1<<17 + 1, 4681 + 8},
+		{0, 1 << 26, 1},
+		{0, 1<<26 + 1, 0},
+	}
+	for _, c := range cases {
+		if got := reg2bin(c.beg, c.end); got != c.bin {
+			t.Errorf("reg2bin(%d, %d): expected %d, got %d", c.beg, c.end, c.bin, got)
+		}
+	}
+}
+
+// writeBed writes a bgzipped bed with a header and bins of 16KB on 3 chromosomes that spans
+// many bgzf blocks.
+func writeBed(t *testing.T, path string) []string {
+	var buf bytes.Buffer
+	buf.WriteString("#chrom\tstart\tend\ts1\ts2\n")
+	var lines []string
+	for _, c := range []string{"1", "2", "X"} {
+		for i := 0; i < 6000; i++ {
+			line := fmt.Sprintf("%s\t%d\t%d\t%.3f\t%.3f", c, i*16384, (i+1)*16384, float64(i%97)/50, float64(i%89)/40)
+			lines = append(lines, line)
+			buf.WriteString(line + "\n")
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := writeBGZF(f, buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tabix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "t.bed.gz")
+	lines := writeBed(t, path)
+	if err := Write(path); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(idx.Names, ",") != "1,2,X" {
+		t.Fatalf("expected names 1,2,X, got %v", idx.Names)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for ref, chrom := range idx.Names {
+		for _, start := range []int{0, 100, 16384, 5e6, 50e6, 98300000, 200e6} {
+			r, err := idx.Region(f, ref, start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sc := bufio.NewScanner(r)
+			if !sc.Scan() || sc.Text() != "#chrom\tstart\tend\ts1\ts2" {
+				t.Fatalf("%s:%d: expected the header first, got %q", chrom, start, sc.Text())
+			}
+			var got []string
+			for sc.Scan() {
+				toks := strings.SplitN(sc.Text(), "\t", 4)
+				if toks[0] != chrom {
+					if len(got) > 0 {
+						break
+					}
+					continue
+				}
+				if e, _ := strconv.Atoi(toks[2]); e > start {
+					got = append(got, sc.Text())
+				}
+			}
+			var exp []string
+			for _, l := range lines {
+				toks := strings.SplitN(l, "\t", 4)
+				if e, _ := strconv.Atoi(toks[2]); toks[0] == chrom && e > start {
+					exp = append(exp, l)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+				t.Errorf("%s:%d: expected %d lines, got %d", chrom, start, len(exp), len(got))
+			}
+		}
+	}
+	// the offset is of the first line that overlaps the window.
+	if off := idx.Offset(0, 0); off>>16 != 0 || off&0xffff != uint64(len("#chrom\tstart\tend\ts1\ts2\n")) {
+		t.Errorf("expected the first line of 1 after the header, got %d:%d", off>>16, off&0xffff)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tabix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cases := []struct {
+		bed string
+		err string
+	}{
+		{"1\t0\t10\n2\t0\t10\n1\t10\t20\n", "not together"},
+		{"1\t10\t20\n1\t0\t10\n", "not sorted"},
+		{"1\tx\t10\n", "bad start"},
+		{"1\t0\n", "3 columns"},
+		{"1\t0\t600000000\n", "longer"},
+	}
+	path := filepath.Join(dir, "t.bed.gz")
+	for _, c := range cases {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeBGZF(f, []byte(c.bed)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if err := Write(path); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: expected an error with %q, got %v", c.bed, c.err, err)
+		}
+		if _, err := os.Stat(path + ".tbi"); err == nil {
+			t.Errorf("%q: expected no index", c.bed)
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte("1\t0\t10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(path); err == nil || !strings.Contains(err.Error(), "bgzipped") {
+		t.Errorf("expected an error for a plain bed, got %v", err)
+	}
+}
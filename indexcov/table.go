@@ -0,0 +1,152 @@
+package indexcov
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// sampleTable holds the per-sample columns that are written to the ped file.
+// Columns from a user-supplied metadata file can be joined to it so that any
+// column can be plotted or checked by name.
+type sampleTable struct {
+	columns []string
+	samples []string
+	rows    [][]string
+	// index of sample name into rows.
+	index map[string]int
+}
+
+func newSampleTable(columns []string) *sampleTable {
+	return &sampleTable{columns: columns, index: make(map[string]int)}
+}
+
+func (t *sampleTable) add(sample string, row []string) {
+	t.index[sample] = len(t.rows)
+	t.samples = append(t.samples, sample)
+	t.rows = append(t.rows, row)
+}
+
+// write the table in ped format with a commented header.
+func (t *sampleTable) write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "#%s\n", strings.Join(t.columns, "\t")); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sampleTable) column(name string) int {
+	for i, c := range t.columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// strings returns the values for the named column in sample order.
+func (t *sampleTable) strings(name string) ([]string, error) {
+	ci := t.column(name)
+	if ci == -1 {
+		return nil, fmt.Errorf("column %q not found. available columns: %s", name, strings.Join(t.columns, ","))
+	}
+	vals := make([]string, len(t.rows))
+	for i, row := range t.rows {
+		if ci < len(row) {
+			vals[i] = row[ci]
+		}
+	}
+	return vals, nil
+}
+
+// floats returns the values for the named column in sample order. "NA" is
+// returned as NaN. It is an error if any other value is not numeric.
+func (t *sampleTable) floats(name string) ([]float64, error) {
+	svals, err := t.strings(name)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]float64, len(svals))
+	for i, s := range svals {
+		if s == "NA" {
+			vals[i] = math.NaN()
+			continue
+		}
+		vals[i], err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %q has non-numeric value %q for sample %s", name, s, t.samples[i])
+		}
+	}
+	return vals, nil
+}
+
+// readMetadata joins the columns from a tab-delimited file with a header to the table.
+// The first column must contain the sample_id, which is named by its pseudonym in ps. Samples without
+// metadata get "NA".
+func (t *sampleTable) readMetadata(path string, ps pseudonyms) error {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	var header []string
+	seen := make(map[int]bool, len(t.rows))
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			toks := strings.Split(line, "\t")
+			if header == nil {
+				header = toks
+				for _, h := range header[1:] {
+					if t.column(h) != -1 {
+						return fmt.Errorf("metadata column %q is already in the ped file", h)
+					}
+				}
+				t.columns = append(t.columns, header[1:]...)
+			} else if i, ok := t.index[ps.name(toks[0])]; ok {
+				if len(toks) != len(header) {
+					return fmt.Errorf("expected %d columns for sample %s in %s, got %d", len(header), toks[0], path, len(toks))
+				}
+				t.rows[i] = append(t.rows[i], toks[1:]...)
+				seen[i] = true
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if header == nil {
+		return fmt.Errorf("no header found in %s", path)
+	}
+	for i := range t.rows {
+		if seen[i] {
+			continue
+		}
+		for range header[1:] {
+			t.rows[i] = append(t.rows[i], "NA")
+		}
+	}
+	return nil
+}
+
+// addColumn appends a column with a value for every sample.
+func (t *sampleTable) addColumn(name string, vals []string) {
+	t.columns = append(t.columns, name)
+	for i := range t.rows {
+		t.rows[i] = append(t.rows[i], vals[i])
+	}
+}
@@ -119,6 +119,46 @@ Click the <span class="help">?</span> above each plot for help describing that t
 
 </section><hr/>
 
+{{ $plots := index . "plots" }}
+{{ if $plots }}
+<section style="height:auto">
+	<span class="tt">Extra Plots</span>
+	<p>scatter plots of ped/metadata columns requested with --plot</p>
+	{{ range $idx, $plot := $plots }}
+	<a href="{{ $plot }}">{{ $plot }}</a><br/>
+	{{ end }}
+</section><hr/>
+{{ end }}
+
+{{ $pairs := index . "pairsPNG" }}
+{{ if $pairs }}
+<section style="height:auto">
+	<span class="tt">Sample Correlation</span>
+	<p>correlation of the scaled depths on the autosomes between each pair of samples (blue: low, red: 1) with similar samples
+	clustered together. near-identical pairs are listed in <a href="{{ $name }}-indexcov.pairs.tsv">{{ $name }}-indexcov.pairs.tsv</a></p>
+	<img src="{{ $pairs }}" style="max-width:800px" />
+	<details><summary>sample order</summary><p>{{ index . "pairOrder" }}</p></details>
+</section><hr/>
+{{ end }}
+
+{{ $pedFams := index . "pedFams" }}
+{{ if $pedFams }}
+<section style="height:auto">
+	<span class="tt">Declared Relationships</span>
+	<p>correlation of the scaled depths on the autosomes between the samples of each family in the ped. declared twins or
+	duplicates (blue) should be near-identical and other pairs should not. pairs that disagree with the ped are red and
+	families with such a pair are first. all pairs are in <a href="{{ $name }}-indexcov.ped-pairs.tsv">{{ $name }}-indexcov.ped-pairs.tsv</a></p>
+	{{ range $fam := $pedFams }}
+	<table style="display:inline-block;vertical-align:top;margin:4px;border-collapse:collapse;font-size:0.8em">
+		<caption>{{ $fam.Family }}</caption>
+		<tr><th></th>{{ range $s := $fam.Samples }}<th>{{ $s }}</th>{{ end }}</tr>
+		{{ range $i, $row := $fam.Rows }}
+		<tr><th>{{ index $fam.Samples $i }}</th>{{ range $c := $row }}<td title="{{ $c.Rel }}" style="border:1px solid #ccc;padding:2px;background-color:{{ $c.Color }}">{{ $c.R }}</td>{{ end }}</tr>
+		{{ end }}
+	</table>
+	{{ end }}
+</section><hr/>
+{{ end }}
 
 {{ if index . "hasPCA" }}
 
@@ -227,3 +267,24 @@ Click the <span class="help">?</span> above each plot for help describing that t
     </script>
 </html>
 `
+
+// singleChartTemplate is used for stand-alone pages with a single chart.
+const singleChartTemplate = `<!DOCTYPE html>
+<html>
+    <head>
+	<title>{{ index . "title" }}:indexcov</title>
+		<script src="{{ index . "JQuery" }}"></script>
+		<script src="{{ index . "ChartJS" }}"></script>
+    </head>
+    <body>
+	<a href="index.html">back to index</a><br/>
+	<canvas id="canvas-chart" style="height:550px;width:650px"></canvas>
+    </body>
+    <script>
+	Chart.defaults.global.animation.duration = 0;
+	var chart_ctx = document.getElementById("canvas-chart").getContext("2d");
+	var chart = new Chart(chart_ctx, {{ index . "chart" }});
+	{{ index . "chartjs" }}
+    </script>
+</html>
+`
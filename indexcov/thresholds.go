@@ -0,0 +1,102 @@
+package indexcov
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// thresholdMetrics are the ped columns for which cutoffs are suggested.
+// For all of these, higher values indicate worse samples.
+var thresholdMetrics = []string{"bins.out", "bins.lo", "p.out"}
+
+// madScale makes the MAD a consistent estimator of the standard deviation for normal data.
+const madScale = 1.4826
+
+// threshold is a data-driven cutoff for a single metric.
+type threshold struct {
+	metric string
+	median float64
+	mad    float64
+	cutoff float64
+	nFail  int
+}
+
+// medianMAD returns the median and the scaled median absolute deviation of vals.
+// NaNs are ignored.
+func medianMAD(vals []float64) (float64, float64) {
+	v := make([]float64, 0, len(vals))
+	for _, f := range vals {
+		if !math.IsNaN(f) {
+			v = append(v, f)
+		}
+	}
+	if len(v) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	sort.Float64s(v)
+	med := median64(v)
+	for i, f := range v {
+		v[i] = math.Abs(f - med)
+	}
+	sort.Float64s(v)
+	return med, madScale * median64(v)
+}
+
+// median64 expects sorted input.
+func median64(v []float64) float64 {
+	if len(v)%2 == 1 {
+		return v[len(v)/2]
+	}
+	return (v[len(v)/2-1] + v[len(v)/2]) / 2
+}
+
+// suggestThresholds fits median + nMADs * MAD for each metric across the cohort.
+func suggestThresholds(t *sampleTable, nMADs float64) ([]threshold, error) {
+	ths := make([]threshold, 0, len(thresholdMetrics))
+	for _, m := range thresholdMetrics {
+		vals, err := t.floats(m)
+		if err != nil {
+			return nil, err
+		}
+		th := threshold{metric: m}
+		th.median, th.mad = medianMAD(vals)
+		th.cutoff = th.median + nMADs*th.mad
+		for _, v := range vals {
+			if v > th.cutoff {
+				th.nFail++
+			}
+		}
+		ths = append(ths, th)
+	}
+	return ths, nil
+}
+
+func writeThresholds(w io.Writer, ths []threshold, nMADs float64) {
+	fmt.Fprintf(w, "indexcov: suggested thresholds (median + %.1f * MAD):\n", nMADs)
+	fmt.Fprintln(w, "metric\tmedian\tMAD\tcutoff\tn_above")
+	for _, th := range ths {
+		fmt.Fprintf(w, "%s\t%.4g\t%.4g\t%.4g\t%d\n", th.metric, th.median, th.mad, th.cutoff, th.nFail)
+	}
+}
+
+// applyThresholds returns PASS or FAIL for each sample in the table.
+func applyThresholds(t *sampleTable, ths []threshold) ([]string, error) {
+	qc := make([]string, len(t.rows))
+	for i := range qc {
+		qc[i] = "PASS"
+	}
+	for _, th := range ths {
+		vals, err := t.floats(th.metric)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range vals {
+			if v > th.cutoff {
+				qc[i] = "FAIL"
+			}
+		}
+	}
+	return qc, nil
+}
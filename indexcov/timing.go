@@ -0,0 +1,227 @@
+package indexcov
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stages of a run that are timed for the summary at the end of the log.
+const (
+	stageIndex = "index parse"
+	stageDepth = "depth calc"
+	stageWrite = "write"
+	stagePCA   = "pca"
+	stagePlots = "plots"
+)
+
+// the slowest this many inputs are listed in the summary.
+const slowestInputs = 5
+
+// readCounter counts the bytes read from each local or remote file opened by openFile and
+// openHeader so that slow storage can be told from large inputs. The counts are for the whole
+// process: each run keeps a copy from when it started so only its own reads of an input are
+// counted, but the bytes of a stage include those read by any run made at the same time.
+type readCounter struct {
+	mu sync.Mutex
+	n  map[string]int64
+	// sum is the total over all paths.
+	sum int64
+}
+
+var reads = &readCounter{n: make(map[string]int64)}
+
+func (c *readCounter) add(path string, n int64) {
+	c.mu.Lock()
+	c.n[path] += n
+	c.sum += n
+	c.mu.Unlock()
+}
+
+// get returns the bytes read from the given paths. Empty and repeated paths are skipped.
+func (c *readCounter) get(paths ...string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	for i, p := range paths {
+		dup := false
+		for _, q := range paths[:i] {
+			dup = dup || q == p
+		}
+		if p != "" && !dup {
+			n += c.n[p]
+		}
+	}
+	return n
+}
+
+func (c *readCounter) total() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sum
+}
+
+// clone returns a copy of the current counts.
+func (c *readCounter) clone() *readCounter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := make(map[string]int64, len(c.n))
+	for p, v := range c.n {
+		n[p] = v
+	}
+	return &readCounter{n: n, sum: c.sum}
+}
+
+func (c *readCounter) reset() {
+	c.mu.Lock()
+	c.n, c.sum = make(map[string]int64), 0
+	c.mu.Unlock()
+}
+
+// countedReader adds the bytes read from path to reads.
+type countedReader struct {
+	io.ReadCloser
+	path string
+}
+
+func (r *countedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	reads.add(r.path, int64(n))
+	return n, err
+}
+
+type stageStat struct {
+	name    string
+	elapsed time.Duration
+	bytes   int64
+}
+
+type inputStat struct {
+	path    string
+	elapsed time.Duration
+	bytes   int64
+}
+
+// runStats holds the time and bytes read of each stage and each input of a run. A nil
+// *runStats records nothing.
+type runStats struct {
+	mu     sync.Mutex
+	start  time.Time
+	stages []stageStat
+	inputs []inputStat
+	// before holds the bytes read before the run started.
+	before *readCounter
+}
+
+func newRunStats() *runStats {
+	return &runStats{start: time.Now(), before: reads.clone()}
+}
+
+// read returns the bytes read from the given paths since the run started.
+func (s *runStats) read(paths ...string) int64 {
+	if s == nil {
+		return 0
+	}
+	return reads.get(paths...) - s.before.get(paths...)
+}
+
+// begin returns a function that adds the time and the bytes read since begin was called to
+// the stage. Stages may be timed more than once (e.g. for each chromosome) and the times are summed.
+// Time spent in stages that overlap is counted in each.
+func (s *runStats) begin(stage string) func() {
+	if s == nil {
+		return func() {}
+	}
+	t, b := time.Now(), reads.total()
+	return func() {
+		elapsed, n := time.Since(t), reads.total()-b
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i := range s.stages {
+			if s.stages[i].name == stage {
+				s.stages[i].elapsed += elapsed
+				s.stages[i].bytes += n
+				return
+			}
+		}
+		s.stages = append(s.stages, stageStat{name: stage, elapsed: elapsed, bytes: n})
+	}
+}
+
+// addInput records the time taken to read an input and the bytes read from its files.
+func (s *runStats) addInput(path string, elapsed time.Duration, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.inputs = append(s.inputs, inputStat{path: path, elapsed: elapsed, bytes: bytes})
+	s.mu.Unlock()
+}
+
+// write writes a table of the stages and the slowest inputs.
+func (s *runStats) write(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := time.Since(s.start)
+	var b strings.Builder
+	fmt.Fprintf(&b, "indexcov: time and bytes read of each stage (%d inputs, %s in total):\n", len(s.inputs), roundDuration(total))
+	fmt.Fprintf(&b, "    %-12s %10s %7s %10s\n", "stage", "time", "%", "read")
+	var staged time.Duration
+	for _, st := range s.stages {
+		staged += st.elapsed
+		fmt.Fprintf(&b, "    %-12s %10s %6.1f%% %10s\n", st.name, roundDuration(st.elapsed), 100*st.elapsed.Seconds()/total.Seconds(), humanBytes(st.bytes))
+	}
+	if other := total - staged; other > 0 {
+		fmt.Fprintf(&b, "    %-12s %10s %6.1f%% %10s\n", "other", roundDuration(other), 100*other.Seconds()/total.Seconds(), "")
+	}
+	inputs := append([]inputStat(nil), s.inputs...)
+	sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].elapsed > inputs[j].elapsed })
+	if len(inputs) > slowestInputs {
+		inputs = inputs[:slowestInputs]
+	}
+	if len(inputs) > 0 {
+		var sum time.Duration
+		for _, in := range s.inputs {
+			sum += in.elapsed
+		}
+		fmt.Fprintf(&b, "indexcov: slowest inputs to read (mean %s):\n", roundDuration(sum/time.Duration(len(s.inputs))))
+		for _, in := range inputs {
+			fmt.Fprintf(&b, "    %10s %10s  %s\n", roundDuration(in.elapsed), humanBytes(in.bytes), in.path)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// log writes the table to the log.
+func (s *runStats) log() {
+	if s == nil {
+		return
+	}
+	if err := s.write(log.Writer()); err != nil {
+		log.Printf("indexcov: WARNING: %s", err)
+	}
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Minute {
+		return d.Round(time.Second)
+	}
+	return d.Round(time.Millisecond)
+}
+
+func humanBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
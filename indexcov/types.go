@@ -1,18 +1,20 @@
 package indexcov
 
 import (
+	"errors"
 	"log"
 	"reflect"
 	"unsafe"
 
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/bgzf"
+	"github.com/brentp/goleft/grid"
 )
 
 const (
 	// TileWidth is the length of the interval tiling used
 	// in BAI and tabix indexes.
-	TileWidth = 0x4000
+	TileWidth = grid.TileWidth
 
 	// StatsDummyBin is the bin number of the reference
 	// statistics bin used in BAI and tabix indexes.
@@ -42,12 +44,16 @@ type referenceStats struct {
 	Unmapped uint64
 }
 
-func getSizes(idx *bam.Index) ([][]int64, uint64, uint64) {
-	var mapped, unmapped uint64
+// refIndexes returns the bins and linear index of each reference which are not exported by bam.Index.
+func refIndexes(idx *bam.Index) []oRefIndex {
 	refs := reflect.ValueOf(*idx).FieldByName("idx").FieldByName("Refs")
 	ptr := unsafe.Pointer(refs.Pointer())
+	return (*(*[1 << 28]oRefIndex)(ptr))[:refs.Len()]
+}
 
-	ret := (*(*[1 << 28]oRefIndex)(ptr))[:refs.Len()]
+func getSizes(idx *bam.Index) ([][]int64, uint64, uint64, error) {
+	var mapped, unmapped uint64
+	ret := refIndexes(idx)
 	// save some memory.
 	m := make([][]int64, len(ret))
 	for i, r := range ret {
@@ -66,10 +72,10 @@ func getSizes(idx *bam.Index) ([][]int64, uint64, uint64) {
 		for k, iv := range r.Intervals[1:] {
 			m[i][k] = vOffset(iv) - vOffset(r.Intervals[k])
 			if m[i][k] < 0 {
-				panic("expected positive change in vOffset")
+				return nil, 0, 0, errors.New("expected positive change in vOffset")
 			}
 		}
 		r.Bins, r.Intervals = nil, nil
 	}
-	return m, mapped, unmapped
+	return m, mapped, unmapped, nil
 }
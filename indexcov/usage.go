@@ -0,0 +1,371 @@
+package indexcov
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// flagSpec is the documentation of a flag taken from its go-arg struct tag.
+type flagSpec struct {
+	field      string
+	short      string
+	long       string
+	help       string
+	kind       reflect.Kind
+	required   bool
+	positional bool
+	def        interface{}
+}
+
+// parseFlags reads the flags from a go-arg spec (a pointer to a struct) in the same way as go-arg.
+func parseFlags(spec interface{}) []flagSpec {
+	v := reflect.ValueOf(spec).Elem()
+	t := v.Type()
+	flags := make([]flagSpec, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fs := flagSpec{field: f.Name, long: strings.ToLower(f.Name), kind: f.Type.Kind(), def: v.Field(i).Interface()}
+		for _, tok := range strings.Split(f.Tag.Get("arg"), ",") {
+			switch {
+			case strings.HasPrefix(tok, "help:"):
+				fs.help = tok[5:]
+			case strings.HasPrefix(tok, "--"):
+				fs.long = tok[2:]
+			case strings.HasPrefix(tok, "-"):
+				fs.short = tok[1:]
+			case tok == "required":
+				fs.required = true
+			case tok == "positional":
+				fs.positional = true
+			}
+		}
+		flags = append(flags, fs)
+	}
+	return flags
+}
+
+// name is the flag as it is used on the command-line, e.g. -d, --directory.
+func (fs flagSpec) name() string {
+	if fs.positional {
+		return strings.ToUpper(fs.long)
+	}
+	if fs.short != "" {
+		return fmt.Sprintf("-%s, --%s", fs.short, fs.long)
+	}
+	return "--" + fs.long
+}
+
+// defaultString returns the default value of the flag or "" if it is the zero value.
+func (fs flagSpec) defaultString() string {
+	if fs.def == nil || reflect.ValueOf(fs.def).IsZero() {
+		return ""
+	}
+	return fmt.Sprint(fs.def)
+}
+
+// column describes a column of an output file.
+type column struct {
+	name  string
+	about string
+}
+
+// outputFile describes a file written by indexcov. flag is the option needed for it to be written.
+type outputFile struct {
+	path    string
+	flag    string
+	about   string
+	columns []column
+}
+
+// example is a command-line with a description of what it does.
+type example struct {
+	about string
+	cmd   string
+}
+
+// command holds the structured documentation of a subcommand that is printed by --examples.
+type command struct {
+	name     string
+	about    string
+	spec     interface{}
+	examples []example
+	// flagExamples are extra example values for flags keyed by the flag's field name.
+	flagExamples map[string]string
+	outputs      []outputFile
+}
+
+// wantsExamples returns true if --examples is in args. It is checked before parsing since
+// the required arguments are not needed.
+func wantsExamples(args []string) bool {
+	for _, a := range args {
+		if a == "--examples" {
+			return true
+		}
+	}
+	return false
+}
+
+// write prints the documentation in the sections of a man page.
+func (c *command) write(w io.Writer) error {
+	flags := parseFlags(c.spec)
+	var usage []string
+	for _, fs := range flags {
+		if fs.required && !fs.positional {
+			usage = append(usage, fmt.Sprintf("--%s %s", fs.long, strings.ToUpper(fs.long)))
+		}
+	}
+	for _, fs := range flags {
+		if fs.positional {
+			usage = append(usage, strings.ToUpper(fs.long)+"...")
+		}
+	}
+	fmt.Fprintf(w, "NAME\n    goleft %s - %s\n\n", c.name, c.about)
+	fmt.Fprintf(w, "SYNOPSIS\n    goleft %s [options] %s\n\n", c.name, strings.Join(usage, " "))
+
+	fmt.Fprintln(w, "OPTIONS")
+	for _, fs := range flags {
+		if fs.field == "Examples" {
+			continue
+		}
+		fmt.Fprintf(w, "    %s\n", fs.name())
+		if fs.help != "" {
+			fmt.Fprintf(w, "        %s\n", fs.help)
+		}
+		if fs.required {
+			fmt.Fprintln(w, "        required.")
+		}
+		if d := fs.defaultString(); d != "" && fs.kind != reflect.Bool {
+			fmt.Fprintf(w, "        default: %s\n", d)
+		}
+		if ex, ok := c.flagExamples[fs.field]; ok {
+			fmt.Fprintf(w, "        e.g.: %s\n", ex)
+		}
+	}
+
+	fmt.Fprintln(w, "\nEXAMPLES")
+	for _, ex := range c.examples {
+		fmt.Fprintf(w, "    # %s\n    %s\n\n", ex.about, ex.cmd)
+	}
+
+	fmt.Fprintln(w, "OUTPUT FILES")
+	fmt.Fprintln(w, "    $prefix is $directory/$name-indexcov where $name is the base of --directory.")
+	for _, o := range c.outputs {
+		when := "always"
+		if o.flag != "" {
+			when = "with " + o.flag
+		}
+		fmt.Fprintf(w, "\n    %s (%s)\n        %s\n", o.path, when, o.about)
+		for _, col := range o.columns {
+			fmt.Fprintf(w, "        %-18s %s\n", col.name, col.about)
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// outputs are the files written by indexcov and indexcov-replot.
+var outputs = []outputFile{
+	{path: "$directory/index.html", about: "summary page with the sex, bin, PCA and ROC plots and links to the other files."},
+	{path: "$prefix.ped", about: "a row per sample. the first 6 columns are a .ped/.fam stub.", columns: []column{
+		{"family_id", "always 'unknown'."},
+		{"sample_id", "sample name from the read-group or the file name."},
+		{"paternal_id", "always -9."},
+		{"maternal_id", "always -9."},
+		{"sex", "inferred sex: 1=male 2=female 0=ambiguous (--sex-ambiguous) or failed qc (--sex-mask-failed). -9 without sex chromosomes."},
+		{"phenotype", "always -9."},
+		{"CN$chrom", "copy-number estimate for each --sex chromosome."},
+		{"CN$chrom.lo CN$chrom.hi", "95% bootstrap interval of each CN$chrom with --bootstrap."},
+		{"sex.conf", "proportion of the bootstrap resamples that give the same sex with --bootstrap."},
+		{"bins.out", "bins with a scaled depth outside of (0.85 1.15)."},
+		{"bins.lo", "bins with a scaled depth < 0.15."},
+		{"bins.hi", "bins with a scaled depth > 1.15."},
+		{"bins.in", "bins with a scaled depth inside (0.85 1.15)."},
+		{"slope", "slope of the ROC between 0.85 and 1.15."},
+		{"p.out", "bins.out / bins.in."},
+		{"PC1..PC$k", "score on each of the --pcs principal components of the autosome depths."},
+		{"ref.PC1 ref.PC2", "projection onto the first 2 components of the --project loadings."},
+		{"mapped", "mapped reads from the index (bams and csis only)."},
+		{"unmapped", "unmapped reads from the index (bams and csis only)."},
+		{"ref.match", "proportion of the data on the chromosomes used for the cohort."},
+		{"qc", "PASS/FAIL with --apply-thresholds, --qc, --ped or --controls."},
+		{"outside.ref", "metrics outside of the --reference-ranges or '.'."},
+		{"pca.dist", "MAD-scaled distance from the cohort center in PC space with --qc or --ped."},
+		{"controls.out", "number of --controls that are out of spec."},
+	}},
+	{path: "$prefix.roc", about: "proportion of bins at or above each scaled depth for each chromosome.", columns: []column{
+		{"chrom", "chromosome."},
+		{"cov", "scaled depth cutoff from 0 to 1.5."},
+		{"$sample", "a column per sample with the proportion of bins >= cov."},
+	}},
+	{path: "$prefix.chrom-stats.tsv", about: "summary of the scaled depths of each sample on each chromosome.", columns: []column{
+		{"chrom", "chromosome."},
+		{"sample", "sample name."},
+		{"bins", "number of bins on the chromosome that are not in --exclude."},
+		{"median", "median scaled depth."},
+		{"mad", "median absolute deviation of the scaled depth (scaled to match the SD for normal data)."},
+		{"p.zero", "proportion of bins with a depth of 0."},
+		{"p.out", "proportion of bins outside of (0.85, 1.15)."},
+	}},
+	{path: "$prefix.bed.gz", about: "scaled depth of every 16KB bin (or --window).", columns: []column{
+		{"chrom start end", "the bin."},
+		{"excluded", "1 if the bin overlaps --exclude or the blacklist of --genome. only with either."},
+		{"$sample", "a column per sample with the scaled depth (~1 is normal) to --precision digits."},
+	}},
+	{path: "$prefix.matrix", flag: "--matrix", about: "the depths of the bed.gz in a binary file indexed by sample and bin for indexcov-lookup and indexcov-replot and indexcov-serve. see the indexcov/matrix package."},
+	{path: "$prefix-calls.bed.gz", flag: "--calls", about: "copy-number calls of each sample. a vcf.gz is also written.", columns: []column{
+		{"chrom start end", "the segment."},
+		{"sample", "sample with the call."},
+		{"svtype", "DEL or DUP."},
+		{"cn", "integer copy-number."},
+		{"depth", "mean scaled depth of the segment."},
+		{"bins", "number of bins."},
+		{"band", "cytobands of the segment (e.g. 7q11.22-q11.23) from --cytobands or the arm or '.'."},
+	}},
+	{path: "$prefix-purity.tsv", flag: "--purity", about: "rough tumor purity and ploidy.", columns: []column{
+		{"sample", "sample name."},
+		{"purity", "fraction of tumor cells."},
+		{"ploidy", "average copy-number of the tumor."},
+		{"mse", "mean squared error of the fit."},
+		{"segments", "number of segments used."},
+	}},
+	{path: "$prefix-karyotype.tsv", flag: "--karyotype", about: "copy-number of each autosome arm.", columns: []column{
+		{"sample", "sample name."},
+		{"chrom arm", "the arm: p or q or '.' without a centromere."},
+		{"start end", "extent of the arm."},
+		{"tiles", "number of bins used."},
+		{"cn se", "copy-number and its standard error."},
+		{"call", "normal gain loss mosaic-gain or mosaic-loss."},
+		{"mosaic.fraction", "fraction of cells with the change or '.'."},
+	}},
+	{path: "$prefix-events.tsv", flag: "--events", about: "a row per event of each sample for loading into a LIMS.", columns: []column{
+		{"sample", "sample name."},
+		{"event", "qc sex aneuploidy (from --karyotype) or cnv (from --calls)."},
+		{"type", "qc reason code or SEX_MISMATCH or the call: gain loss mosaic-gain mosaic-loss DEL or DUP."},
+		{"chrom start end", "extent of aneuploidy and cnv events or '.'."},
+		{"score", "value of the failed qc metric or the copy-number of the event or '.'."},
+		{"detail", "semicolon-delimited key=value pairs e.g. ped=2;inferred=1 or the qc metric."},
+	}},
+	{path: "$prefix-replicates.tsv", flag: "--replicates", about: "concordance of each pair of technical replicates.", columns: []column{
+		{"sample_a sample_b", "the replicates."},
+		{"tiles", "number of bins used."},
+		{"r ccc", "pearson correlation and concordance correlation coefficient of the depths."},
+		{"median.M mad.M", "median and MAD of the log2 ratio of the depths."},
+		{"p.discordant", "proportion of bins with a log2 ratio beyond +/-0.5."},
+		{"png", "the MA plot of the pair."},
+	}},
+	{path: "$prefix-calibration.tsv", flag: "--calibrate", about: "the inflation of the deepest tiles of each local bam.", columns: []column{
+		{"sample chrom start end", "a sampled tile."},
+		{"depth", "scaled depth of the tile from the index."},
+		{"reads", "number of alignments read from the tile (at most 2000)."},
+		{"inflation", "proportion of the bytes of those alignments from --calibrate-flags alignments."},
+		{"corrected", "depth without the inflation. this is used for all output with --calibrate correct."},
+		{"flagged", "yes if the inflation is at least 0.2."},
+	}},
+	{path: "$prefix-hotspots.bed.gz", flag: "--hotspots", about: "candidate SV hotspots where the linear index and 16KB bins of a bai disagree.", columns: []column{
+		{"chrom start end", "adjacent 16KB chunks that differ in the same direction."},
+		{"sample", "sample name."},
+		{"tiles", "number of chunks."},
+		{"log2.ratio", "mean log2 ratio of the bin to the linear-index bytes relative to the median of the sample."},
+	}},
+	{path: "$prefix-dosage.tsv", flag: "--dosage-reference or --dosage-reference-out", about: "the dosage of each autosome of each sample.", columns: []column{
+		{"sample chrom", "the sample and autosome."},
+		{"dosage", "copy-number over the ploidy. 1.5 is a trisomy."},
+		{"percentile", "percentile of the dosage in --dosage-reference (<1 and >99 are beyond the reference) or NA."},
+		{"ref.median", "median dosage of the chromosome in the reference or NA."},
+	}},
+	{path: "$prefix-ideogram-$n.png", flag: "--ideogram", about: "the chromosomes of the nth sample to scale. blue is below and red above the expected depth."},
+	{path: "$prefix-controls.tsv", flag: "--controls", about: "the depth of each sample at each control region.", columns: []column{
+		{"sample control", "the sample and the name of the control."},
+		{"chrom start end", "extent of the control."},
+		{"expected low high", "expected scaled depth and the range that is in spec."},
+		{"observed", "mean scaled depth of the sample over the control or '.'."},
+		{"log2.ratio", "log2 of observed / expected or '.'."},
+		{"status", "PASS LOW HIGH or NA when the chromosome was not found."},
+	}},
+	{path: "$prefix.qc.tsv", flag: "--qc or --ped or --controls", about: "the qc result of each sample.", columns: []column{
+		{"sample", "sample name."},
+		{"qc", "PASS or FAIL."},
+		{"reasons", "comma-delimited reason codes (e.g. P_OUT_HIGH SEX_MISMATCH CONTROL_LOW) or '.'."},
+	}},
+	{path: "$prefix.pairs.tsv", flag: "--pairs", about: "duplicate pairs and the most correlated sample of each sample.", columns: []column{
+		{"sample_a sample_b", "the pair."},
+		{"r", "correlation of the scaled depths on the autosomes."},
+		{"duplicate", "yes if r >= --pairs-min-r."},
+	}},
+	{path: "$prefix.ped-pairs.tsv", flag: "--ped", about: "pairs of samples in the same family and declared twins or duplicates.", columns: []column{
+		{"sample_a sample_b", "the pair."},
+		{"family_a family_b", "the families of the pair in the ped."},
+		{"relation", "duplicate (from a twin column) or parent-child or sibling or family or unrelated (near-identical with --pairs)."},
+		{"r", "correlation of the scaled depths on the autosomes."},
+		{"expected", "identical for duplicates and distinct otherwise."},
+		{"status", "FAIL if r does not agree with expected using --pairs-min-r."},
+	}},
+	{path: "$prefix-loadings.bed.gz", flag: "--loadings", about: "the PCA of the autosome depths for projecting other batches.", columns: []column{
+		{"chrom start end", "a bin used in the PCA."},
+		{"mean", "cohort mean of the bin on the 0-255 scale used for the PCA."},
+		{"weight", "weight of the bin from --pca-weight (1 without)."},
+		{"PC1..PC$k", "loading of the bin on each component."},
+	}},
+	{path: "$prefix.sketch", flag: "--sketch", about: "frequent-directions sketch of the PCA matrix for goleft indexcov-gather."},
+	{path: "$prefix.report.html", flag: "--single-html", about: "all plots and the ped table in a single file that needs no network access."},
+	{path: "$prefix-charts.json", about: "the id and type and data file and axes and parameters of each chart for drawing them with other tools."},
+	{path: "$prefix-report.json", flag: "--json", about: "the ped values and a p.lo/p.in/p.hi summary of each chromosome per sample."},
+	{path: "$prefix-report.tsv", flag: "--tsv", about: "the ped columns followed by $chrom.p.lo $chrom.p.in and $chrom.p.hi for each chromosome."},
+	{path: "$directory/goleft.html", about: "links to the outputs of all goleft commands in the directory. only when another command (e.g. depth) wrote there too."},
+}
+
+var indexcovCommand = &command{
+	name:  "indexcov",
+	about: "quick coverage estimate using only the bam index",
+	spec:  cli,
+	examples: []example{
+		{"a cohort of bams. sex, bins, PCA and plots are written to out/", "goleft indexcov --directory out/ /data/*.bam"},
+		{"crams (or crais) need the fasta index", "goleft indexcov --fai ref.fa.fai --directory out/ /data/*.cram"},
+		{"QC gate for a pipeline that fails on a sex mismatch or an outlier", "goleft indexcov --qc rules.tsv --ped samples.ped --fail-on fail -d out/ /data/*.bam"},
+		{"depth files from mosdepth --by 16384 when no index is available", "goleft indexcov --fai ref.fa.fai -d out/ /data/*.regions.bed.gz"},
+		{"bams in a bucket; only the index and header are fetched", "goleft indexcov -d out/ s3://bucket/s1.bam s3://bucket/s2.bam"},
+	},
+	flagExamples: map[string]string{
+		"Plot":           "--plot x=bins.out,y=PC1,color=batch",
+		"SexAmbiguous":   "--sex-ambiguous 1.3,1.7",
+		"Sex":            "--sex chrX,chrY",
+		"QCRules":        "--qc rules.tsv with lines like: p.out<TAB>NA<TAB>0.3",
+		"ExcludeRegions": "--exclude hg38-blacklist.bed.gz",
+		"GC":             "--gc hg38.fa or --gc gc-mappability.bed.gz with lines like: chr1<TAB>0<TAB>16384<TAB>0.41<TAB>0.98",
+		"Cytobands":      "--cytobands hg38.cytoBand.txt.gz from UCSC",
+		"Window":         "--window 65536 to average each 4 tiles for low-coverage samples",
+		"Manifest":       "--manifest pooled.tsv with lines like: /data/pool.cram<TAB>rg1<TAB>sampleA",
+		"Replicates":     "--replicates pairs.tsv with lines like: libA-run1<TAB>libA-run2",
+		"Bootstrap":      "--bootstrap 200",
+		"Ideogram":       "--ideogram --single-html",
+		"Calibrate":      "--calibrate correct --calibrate-flags secondary,supplementary,duplicate",
+		"Hotspots":       "--hotspots",
+		"DosageRefOut":   "--dosage-reference-out cohort-dosage.tsv on a large (mostly euploid) cohort then --dosage-reference cohort-dosage.tsv",
+		"SampleMap":      "--sample-map names.tsv with lines like: LAB-000123<TAB>patient1",
+		"Anonymize":      "--anonymize ~/private/pseudonyms.tsv to share the output with anon-3f9a2c1b style names",
+		"Genome":         "--genome hg38",
+		"GenomeFile":     "--genome-file galGal6.json (see the genomes package for the format)",
+		"Ped":            "--ped family.ped with a header like: #family_id<TAB>sample_id<TAB>paternal_id<TAB>maternal_id<TAB>sex<TAB>phenotype<TAB>twin",
+		"LockWait":       "--lock-wait 1h to queue behind another run writing to the same --directory",
+		"Controls":       "--controls spike-ins.bed with lines like: chr1<TAB>1000000<TAB>1100000<TAB>spike1<TAB>1.0",
+		"Matrix":         "--matrix for cohorts of thousands of samples that are looked up or served",
+		"Events":         "--events --qc rules.tsv --ped family.ped --karyotype --calls for all kinds of events",
+		"Project":        "--project panel-indexcov-loadings.bed.gz from a run with --loadings on a public cohort of the same build",
+		"Recenter":       "--recenter modal --calls --purity for tumors with many large copy-number changes",
+	},
+	outputs: outputs,
+}
+
+var replotCommand = &command{
+	name:  "indexcov-replot",
+	about: "redo indexcov plots and ped from existing indexcov bed.gz files",
+	spec:  replotCli,
+	examples: []example{
+		{"merge 2 earlier runs into a single report", "goleft indexcov-replot -d merged/ run1/run1-indexcov.bed.gz run2/run2-indexcov.bed.gz"},
+		{"drop samples that failed qc", "goleft indexcov-replot --drop s1,s7 -d clean/ run1/run1-indexcov.bed.gz"},
+		{"replot a large cohort from the matrix written with --matrix", "goleft indexcov-replot -d replot/ run1/run1-indexcov.matrix"},
+	},
+	outputs: outputs,
+}
@@ -0,0 +1,56 @@
+package indexcov
+
+// windowSource is a DepthSource whose depths are the mean of each n adjacent tiles of
+// another source. It is used for --window so that all output uses the larger bins.
+type windowSource struct {
+	DepthSource
+	n int
+}
+
+// NormalizedDepth implements DepthSource.
+func (w *windowSource) NormalizedDepth(refID int) []float32 {
+	return aggregate(w.DepthSource.NormalizedDepth(refID), w.n)
+}
+
+// aggregate returns the mean of each n adjacent values of depths. The last window
+// is the mean of the values that remain.
+func aggregate(depths []float32, n int) []float32 {
+	if n <= 1 {
+		return depths
+	}
+	out := make([]float32, (len(depths)+n-1)/n)
+	for i := range out {
+		s, e := i*n, imin((i+1)*n, len(depths))
+		var sum float32
+		for _, d := range depths[s:e] {
+			sum += d
+		}
+		out[i] = sum / float32(e-s)
+	}
+	return out
+}
+
+// windowMask returns a mask of windows of n tiles from a mask of tiles. A window is masked
+// if any of its tiles are.
+func windowMask(mask []bool, n int) []bool {
+	if n <= 1 || mask == nil {
+		return mask
+	}
+	out := make([]bool, (len(mask)+n-1)/n)
+	for i, m := range mask {
+		out[i/n] = out[i/n] || m
+	}
+	return out
+}
+
+// windowSources wraps each source so that its depths are in windows of n tiles.
+func windowSources(srcs []DepthSource, n int) []DepthSource {
+	if n <= 1 {
+		return srcs
+	}
+	out := make([]DepthSource, len(srcs))
+	for i, s := range srcs {
+		out[i] = &windowSource{DepthSource: s, n: n}
+	}
+	return out
+}
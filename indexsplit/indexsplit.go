@@ -17,6 +17,7 @@ import (
 	arg "github.com/alexflint/go-arg"
 	"github.com/biogo/hts/sam"
 	"github.com/biogo/store/interval"
+	"github.com/brentp/goleft"
 	"github.com/brentp/goleft/depth"
 	"github.com/brentp/goleft/indexcov"
 )
@@ -90,7 +91,11 @@ func Split(paths []string, refs []*sam.Reference, N int, probs map[string]*inter
 		scalar := float64(1000000000)
 
 		for _, path := range paths {
-			osz := indexcov.ReadIndex(path).Sizes()
+			idx, err := indexcov.ReadIndex(path)
+			if err != nil {
+				goleft.Fatal(goleft.InputErr(err))
+			}
+			osz := idx.Sizes()
 			for _, ref := range refs {
 				i := ref.ID()
 				for i >= len(sizes) {
@@ -205,10 +210,14 @@ func Main() {
 	}
 
 	var refs []*sam.Reference
+	var err error
 	if strings.HasSuffix(cli.Indexes[0], ".bam") {
-		refs = indexcov.RefsFromBam(cli.Indexes[0], "")
+		refs, err = indexcov.RefsFromBam(cli.Indexes[0], "")
 	} else {
-		refs = indexcov.ReadFai(cli.Fai, "")
+		refs, err = indexcov.ReadFai(cli.Fai, "")
+	}
+	if err != nil {
+		goleft.Fatal(goleft.InputErr(err))
 	}
 	for chunk := range Split(cli.Indexes, refs, cli.N, probs) {
 		fmt.Println(chunk)
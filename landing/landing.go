@@ -0,0 +1,303 @@
+// Package landing writes a single HTML page that links the outputs of the goleft commands that were
+// run into the same directory (e.g. indexcov with -d out/ and depth with --prefix out/sample) with a
+// brief description of each so that collaborators can be given one entry point.
+package landing
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/brentp/goleft"
+)
+
+// Name is the file name of the landing page in the output directory. indexcov writes its own
+// index.html so a different name is used.
+const Name = "goleft.html"
+
+// Output is a file written by a goleft command.
+type Output struct {
+	// Tool is the goleft command that wrote the file.
+	Tool string
+	// Prefix is the part of the file name before the suffix of the tool, e.g. the --prefix of depth
+	// or the name of an indexcov run.
+	Prefix string
+	// Path is relative to the scanned directory.
+	Path     string
+	About    string
+	Size     int64
+	Modified time.Time
+}
+
+// kind is a file written by a tool with a fixed suffix.
+type kind struct {
+	tool, suffix, about string
+}
+
+// kinds are the outputs found by their suffix. The indexcov plots and per-chromosome pages are
+// linked from its index.html so only the summary and data files are listed.
+var kinds = []kind{
+	{"indexcov", "-indexcov.report.html", "single-file report with all plots and the ped table"},
+	{"indexcov", "-indexcov.ped", "a row per sample with the inferred sex, bin counts, PCs and qc"},
+	{"indexcov", "-indexcov.roc", "proportion of bins at or above each scaled depth for each chromosome"},
+	{"indexcov", "-indexcov.chrom-stats.tsv", "summary of the scaled depths of each sample on each chromosome"},
+	{"indexcov", "-indexcov.bed.gz", "scaled depth of every bin for every sample"},
+	{"indexcov", "-indexcov.matrix", "scaled depth of every bin for every sample in a binary format"},
+	{"indexcov", "-indexcov-calls.bed.gz", "copy-number calls of each sample"},
+	{"indexcov", "-indexcov-calls.vcf.gz", "copy-number calls of each sample as a VCF"},
+	{"indexcov", "-indexcov-purity.tsv", "rough tumor purity and ploidy"},
+	{"indexcov", "-indexcov-karyotype.tsv", "copy-number of each autosome arm"},
+	{"indexcov", "-indexcov-controls.tsv", "depth of each sample at each control region"},
+	{"indexcov", "-indexcov-events.tsv", "qc, sex, aneuploidy and CNV events of each sample"},
+	{"indexcov", "-indexcov-replicates.tsv", "concordance of each pair of technical replicates"},
+	{"indexcov", "-indexcov-calibration.tsv", "inflation of the deepest tiles of each bam"},
+	{"indexcov", "-indexcov-hotspots.bed.gz", "candidate SV hotspots"},
+	{"indexcov", "-indexcov-dosage.tsv", "dosage of each autosome of each sample"},
+	{"indexcov", "-indexcov.qc.tsv", "qc result of each sample with reasons"},
+	{"indexcov", "-indexcov.pairs.tsv", "duplicate pairs and the most correlated sample of each sample"},
+	{"indexcov", "-indexcov.ped-pairs.tsv", "pairs of samples in the same family checked against their depths"},
+	{"indexcov", "-indexcov-loadings.bed.gz", "PCA loadings for projecting other batches"},
+	{"indexcov", "-indexcov.sketch", "sketch of the PCA matrix for indexcov-gather"},
+	{"indexcov", "-indexcov-report.json", "ped values and a summary of each chromosome per sample"},
+	{"indexcov", "-indexcov-report.tsv", "ped values and a summary of each chromosome per sample"},
+	{"depth", ".depth.bed", "mean depth of each window"},
+	{"depth", ".callable.bed", "regions that are callable or have low or no coverage"},
+	{"depth", ".gaps.bed", "merged regions without coverage"},
+	{"depth", ".amplicons.bed", "primer-trimmed depth of each amplicon"},
+}
+
+// headers identify tables that are written to stdout (and so have any name) by their first line.
+var headers = []kind{
+	{"covstats", "coverage\tinsert_mean\t", "coverage, insert size, duplicate and mapping estimates of each bam"},
+	{"covstats", "bam\tsample\tflowcell\tlane\t", "insert size and mapping quality of each lane (--lanes)"},
+}
+
+// tools is the order of the sections of the page.
+var tools = []string{"indexcov", "depth", "covstats"}
+
+// match returns the output kind of the file at path with the given name. The longest suffix wins
+// so that e.g. -indexcov-calls.bed.gz is not taken as -indexcov.bed.gz.
+func match(path, name string) (kind, string, bool) {
+	if name == "index.html" {
+		if m, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*-indexcov.ped")); len(m) > 0 {
+			return kind{"indexcov", "index.html", "summary page with the sex, bin, PCA and ROC plots"}, "", true
+		}
+	}
+	var best kind
+	for _, k := range kinds {
+		if strings.HasSuffix(name, k.suffix) && len(k.suffix) > len(best.suffix) && !strings.Contains(name, ".tmp.") {
+			best = k
+		}
+	}
+	if best.tool != "" {
+		return best, strings.TrimSuffix(name, best.suffix), true
+	}
+	if ext := filepath.Ext(name); ext == ".tsv" || ext == ".txt" || ext == ".covstats" {
+		f, err := os.Open(path)
+		if err != nil {
+			return kind{}, "", false
+		}
+		defer f.Close()
+		line, _ := bufio.NewReader(f).ReadString('\n')
+		for _, h := range headers {
+			if strings.HasPrefix(line, h.suffix) {
+				return h, strings.TrimSuffix(name, ext), true
+			}
+		}
+	}
+	return kind{}, "", false
+}
+
+// Scan returns the goleft outputs in dir and in its immediate subdirectories sorted by tool,
+// prefix and path.
+func Scan(dir string) ([]Output, error) {
+	var outs []Output
+	for _, d := range []string{"", "*"} {
+		paths, err := filepath.Glob(filepath.Join(dir, d, "*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			fi, err := os.Stat(p)
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			k, prefix, ok := match(p, fi.Name())
+			if !ok {
+				continue
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return nil, err
+			}
+			outs = append(outs, Output{Tool: k.tool, Prefix: prefix, Path: filepath.ToSlash(rel), About: k.about,
+				Size: fi.Size(), Modified: fi.ModTime()})
+		}
+	}
+	order := make(map[string]int, len(tools))
+	for i, t := range tools {
+		order[t] = i
+	}
+	sort.SliceStable(outs, func(i, j int) bool {
+		a, b := outs[i], outs[j]
+		if a.Tool != b.Tool {
+			return order[a.Tool] < order[b.Tool]
+		}
+		if a.Prefix != b.Prefix {
+			return a.Prefix < b.Prefix
+		}
+		return a.Path < b.Path
+	})
+	return outs, nil
+}
+
+// section is the outputs of a tool on the page.
+type section struct {
+	Tool    string
+	Outputs []Output
+}
+
+// Write writes the landing page for the outputs in dir to dir/goleft.html and returns its path.
+func Write(dir string) (string, error) {
+	outs, err := Scan(dir)
+	if err != nil {
+		return "", err
+	}
+	return write(dir, outs)
+}
+
+func write(dir string, outs []Output) (string, error) {
+	var secs []section
+	for _, o := range outs {
+		if n := len(secs); n == 0 || secs[n-1].Tool != o.Tool {
+			secs = append(secs, section{Tool: o.Tool})
+		}
+		secs[len(secs)-1].Outputs = append(secs[len(secs)-1].Outputs, o)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New("landing").Funcs(template.FuncMap{"size": humanSize}).Parse(pageTemplate)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, Name)
+	// write to a temporary file so that a page being viewed is never partly written.
+	f, err := ioutil.TempFile(dir, "."+Name)
+	if err != nil {
+		return "", err
+	}
+	err = tmpl.Execute(f, map[string]interface{}{"Name": filepath.Base(abs), "Sections": secs,
+		"Version": goleft.Version, "Time": time.Now().Format("2006-01-02 15:04")})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile creates the file as 0600.
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("landing: error writing %s: %s", path, err)
+	}
+	return path, os.Rename(f.Name(), path)
+}
+
+// Update writes the landing page if dir holds the outputs of more than 1 goleft command and returns
+// its path or "" if it was not written. The commands call it after they finish so that the page
+// always links the latest outputs.
+func Update(dir string) (string, error) {
+	outs, err := Scan(dir)
+	if err != nil {
+		return "", err
+	}
+	seen := make(map[string]bool)
+	for _, o := range outs {
+		seen[o.Tool] = true
+	}
+	if len(seen) < 2 {
+		return "", nil
+	}
+	return write(dir, outs)
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+type cliargs struct {
+	Directory string `arg:"positional,required,help:directory with the outputs of goleft commands. the page is written to $directory/goleft.html"`
+}
+
+func (c cliargs) Version() string {
+	return fmt.Sprintf("landing %s", goleft.Version)
+}
+
+// Main is called from the goleft dispatcher as landing. Unlike Update, it writes the page for the
+// outputs of a single command too.
+func Main() {
+	var cli cliargs
+	arg.MustParse(&cli)
+	if fi, err := os.Stat(cli.Directory); err != nil || !fi.IsDir() {
+		goleft.Fatal(goleft.InputErr(fmt.Errorf("landing: %s is not a directory", cli.Directory)))
+	}
+	outs, err := Scan(cli.Directory)
+	if err != nil {
+		goleft.Fatal(err)
+	}
+	if len(outs) == 0 {
+		goleft.Fatal(goleft.InputErr(fmt.Errorf("landing: no goleft outputs found in %s", cli.Directory)))
+	}
+	path, err := write(cli.Directory, outs)
+	if err != nil {
+		goleft.Fatal(err)
+	}
+	log.Printf("landing: wrote %s with %d files", path, len(outs))
+}
+
+// pageTemplate has no external scripts or styles so that it can be viewed without network access.
+const pageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Name }}: goleft</title>
+<style type="text/css">
+body { font-family: Helvetica, Arial, sans-serif; margin: 12px; color: #222; }
+h1 { font-size: 1.3em; padding-bottom: 8px; border-bottom: 1px solid #ccc; }
+h2 { font-size: 1.1em; margin-top: 20px; }
+table { border-collapse: collapse; font-size: 13px; }
+td, th { border: 1px solid #ddd; padding: 2px 8px; text-align: left; }
+td.num { text-align: right; }
+.version { font-size: 12px; color: #777; }
+</style>
+</head>
+<body>
+<h1>{{ .Name }}</h1>
+{{ range .Sections }}
+<h2>{{ .Tool }}</h2>
+<table>
+<tr><th>prefix</th><th>file</th><th>description</th><th>size</th><th>modified</th></tr>
+{{ range .Outputs }}<tr><td>{{ .Prefix }}</td><td><a href="{{ .Path }}">{{ .Path }}</a></td><td>{{ .About }}</td><td class="num">{{ size .Size }}</td><td>{{ .Modified.Format "2006-01-02 15:04" }}</td></tr>
+{{ end }}</table>
+{{ end }}
+<p class="version">written by goleft {{ .Version }} at {{ .Time }}</p>
+</body>
+</html>
+`
@@ -0,0 +1,84 @@
+package landing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func touch(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanAndUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "landing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	touch(t, filepath.Join(dir, "cohort-indexcov.ped"), "#family_id\n")
+	touch(t, filepath.Join(dir, "cohort-indexcov-calls.bed.gz"), "")
+	touch(t, filepath.Join(dir, "index.html"), "")
+	touch(t, filepath.Join(dir, "cohort-indexcov-depth-chr1.html"), "")
+	touch(t, filepath.Join(dir, "notes.txt"), "not from goleft\n")
+
+	// a single tool does not get a page.
+	path, err := Update(dir)
+	if err != nil || path != "" {
+		t.Fatalf("expected no page for a single tool, got: %q %v", path, err)
+	}
+
+	touch(t, filepath.Join(dir, "depth", "s1.depth.bed"), "")
+	touch(t, filepath.Join(dir, "depth", "s1.callable.bed"), "")
+	touch(t, filepath.Join(dir, "depth", "s1.chr1-0-100.tmp.depth.bed"), "")
+	touch(t, filepath.Join(dir, "stats.tsv"), "coverage\tinsert_mean\tinsert_sd\n30\t400\t50\n")
+
+	outs, err := Scan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range outs {
+		got = append(got, o.Tool+":"+o.Prefix+":"+o.Path)
+	}
+	want := []string{
+		"indexcov::index.html",
+		"indexcov:cohort:cohort-indexcov-calls.bed.gz",
+		"indexcov:cohort:cohort-indexcov.ped",
+		"depth:s1:depth/s1.callable.bed",
+		"depth:s1:depth/s1.depth.bed",
+		"covstats:stats:stats.tsv",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("unexpected outputs:\ngot:  %v\nwant: %v", got, want)
+	}
+
+	path, err = Update(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, Name) {
+		t.Fatalf("expected the page at %s, got: %q", filepath.Join(dir, Name), path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`href="depth/s1.depth.bed"`, "<h2>covstats</h2>", "copy-number calls of each sample"} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("expected %q in the page", s)
+		}
+	}
+	// the page is not an output itself.
+	if outs2, _ := Scan(dir); len(outs2) != len(outs) {
+		t.Errorf("expected %d outputs after writing the page, got %d", len(outs), len(outs2))
+	}
+}
@@ -0,0 +1,208 @@
+// Package liftover converts intervals between genome builds with a UCSC chain file (e.g.
+// hg19ToHg38.over.chain.gz) so that indexcov outputs from one build can be compared with cohorts that
+// were aligned to another. Intervals that are deleted, only partly mapped or split between chains in the
+// new build are reported rather than silently dropped or stretched.
+package liftover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/xopen"
+)
+
+// Reasons that an interval is not lifted. These are written to the unmapped file as liftOver does.
+const (
+	Deleted          = "Deleted in new"
+	PartiallyDeleted = "Partially deleted in new"
+	Split            = "Split in new"
+)
+
+// block is an ungapped alignment of [tStart, tEnd) of the old build to the new build.
+type block struct {
+	tStart, tEnd int
+	chain        int
+	qChrom       string
+	// qStart is the start in the coordinates of qStrand as in the chain file.
+	qStart int
+	qSize  int
+	rev    bool
+}
+
+// query returns the interval of the new build, on the + strand, that [a, b) (within the block) maps to.
+func (b *block) query(a, e int) (int, int) {
+	s, t := b.qStart+a-b.tStart, b.qStart+e-b.tStart
+	if b.rev {
+		return b.qSize - t, b.qSize - s
+	}
+	return s, t
+}
+
+// Interval is a region (0-based, half-open) of a build. Reverse is true if it maps to the - strand of
+// the new build.
+type Interval struct {
+	Chrom      string
+	Start, End int
+	Reverse    bool
+}
+
+// Chain holds the blocks of a chain file for each chromosome of the old build.
+type Chain struct {
+	blocks map[string][]block
+	// Sizes are the lengths of the chromosomes of the new build.
+	Sizes map[string]int
+	// MinMatch is the lowest proportion of the bases of an interval that must map. It is 0.95 by default.
+	MinMatch float64
+}
+
+// ReadChain reads a (possibly gzipped) UCSC chain file.
+func ReadChain(path string) (*Chain, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	return readChain(bufio.NewReader(rdr), path)
+}
+
+func readChain(br *bufio.Reader, path string) (*Chain, error) {
+	c := &Chain{blocks: make(map[string][]block), Sizes: make(map[string]int), MinMatch: 0.95}
+	var cur *block
+	var tChrom string
+	var tPos, qPos int
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		toks := strings.Fields(line)
+		switch {
+		case len(toks) == 0 || toks[0][0] == '#':
+		case toks[0] == "chain":
+			// chain score tName tSize tStrand tStart tEnd qName qSize qStrand qStart qEnd id
+			if len(toks) < 13 {
+				return nil, fmt.Errorf("liftover: expected 13 fields in chain header at line %d of %s", i, path)
+			}
+			ints, err := atois(toks[5], toks[8], toks[10], toks[12])
+			if err != nil {
+				return nil, fmt.Errorf("liftover: bad chain header at line %d of %s: %s", i, path, err)
+			}
+			if toks[4] != "+" {
+				return nil, fmt.Errorf("liftover: expected + for the strand of the old build at line %d of %s", i, path)
+			}
+			tChrom, tPos, qPos = toks[2], ints[0], ints[2]
+			cur = &block{chain: ints[3], qChrom: toks[7], qSize: ints[1], rev: toks[9] == "-"}
+			c.Sizes[toks[7]] = ints[1]
+		default:
+			// size [dt dq]: an aligned block followed by the gaps in the old and new builds.
+			if cur == nil {
+				return nil, fmt.Errorf("liftover: alignment data before a chain header at line %d of %s", i, path)
+			}
+			ints, err := atois(toks...)
+			if err != nil || (len(ints) != 1 && len(ints) != 3) {
+				return nil, fmt.Errorf("liftover: expected 1 or 3 integers at line %d of %s", i, path)
+			}
+			b := *cur
+			b.tStart, b.tEnd, b.qStart = tPos, tPos+ints[0], qPos
+			c.blocks[tChrom] = append(c.blocks[tChrom], b)
+			tPos, qPos = b.tEnd, qPos+ints[0]
+			if len(ints) == 3 {
+				tPos += ints[1]
+				qPos += ints[2]
+			} else {
+				cur = nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(c.blocks) == 0 {
+		return nil, fmt.Errorf("liftover: no chains found in %s", path)
+	}
+	for _, bs := range c.blocks {
+		sort.Slice(bs, func(i, j int) bool { return bs[i].tStart < bs[j].tStart })
+	}
+	return c, nil
+}
+
+func atois(toks ...string) ([]int, error) {
+	ints := make([]int, len(toks))
+	for i, t := range toks {
+		v, err := strconv.Atoi(t)
+		if err != nil {
+			return nil, err
+		}
+		ints[i] = v
+	}
+	return ints, nil
+}
+
+// Lift returns the interval of the new build for [start, end) of chrom. If less than MinMatch of the
+// bases map, it returns the reason. If the bases map to more than 1 chain (e.g. to 2 chromosomes or
+// strands) the reason is Split and, only with split, a piece is returned for each chain.
+func (c *Chain) Lift(chrom string, start, end int, split bool) ([]Interval, string) {
+	if end <= start {
+		// a point, e.g. an insertion, is lifted as the base after it.
+		end = start + 1
+	}
+	bs := c.blocks[chrom]
+	// the chains of .over.chain files do not overlap in the old build so the ends are also sorted.
+	i := sort.Search(len(bs), func(i int) bool { return bs[i].tEnd > start })
+	var pieces []Interval
+	var chains []int
+	mapped := 0
+	for ; i < len(bs) && bs[i].tStart < end; i++ {
+		b := &bs[i]
+		a, e := imax(start, b.tStart), imin(end, b.tEnd)
+		mapped += e - a
+		s, t := b.query(a, e)
+		k := indexOf(chains, b.chain)
+		if k == -1 {
+			chains = append(chains, b.chain)
+			pieces = append(pieces, Interval{Chrom: b.qChrom, Start: s, End: t, Reverse: b.rev})
+			continue
+		}
+		pieces[k].Start, pieces[k].End = imin(pieces[k].Start, s), imax(pieces[k].End, t)
+	}
+	if mapped == 0 {
+		return nil, Deleted
+	}
+	if float64(mapped) < c.MinMatch*float64(end-start) {
+		return nil, PartiallyDeleted
+	}
+	if len(pieces) > 1 {
+		if !split {
+			return nil, Split
+		}
+		return pieces, Split
+	}
+	return pieces, ""
+}
+
+func indexOf(vals []int, v int) int {
+	for i, x := range vals {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,334 @@
+package liftover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/biogo/hts/bgzf"
+	"github.com/brentp/goleft"
+	"github.com/brentp/xopen"
+)
+
+type cliargs struct {
+	Chain    string  `arg:"-c,required,help:UCSC chain file from the build of the input to the new build. e.g. hg19ToHg38.over.chain.gz"`
+	Out      string  `arg:"-o,required,help:path for the lifted output. it is bgzipped if it ends with .gz"`
+	Unmapped string  `arg:"help:path for the intervals that are not lifted with the reason. default is $out.unmapped"`
+	MinMatch float64 `arg:"help:lowest proportion of the bases of an interval that must map"`
+	Split    bool    `arg:"help:write an interval that maps to more than 1 chain as a piece for each rather than as unmapped"`
+	Input    string  `arg:"positional,required,help:indexcov bed.gz or calls.bed.gz or calls.vcf.gz or any file with chrom start and end columns"`
+}
+
+func (c cliargs) Version() string {
+	return fmt.Sprintf("liftover %s", goleft.Version)
+}
+
+// record is a lifted line. toks are the columns with the new coordinates.
+type record struct {
+	chrom string
+	start int
+	toks  []string
+}
+
+// counts of the outcomes of each interval for the summary.
+type counts struct {
+	n, lifted, nsplit int
+	reasons           map[string]int
+}
+
+// lifter lifts each line of a file and keeps the lifted lines so that they can be sorted in the
+// coordinates of the new build.
+type lifter struct {
+	chain    *Chain
+	split    bool
+	unmapped *bufio.Writer
+	records  []record
+	// order of the chromosomes of the new build as they are first seen.
+	order map[string]int
+	counts
+}
+
+func (l *lifter) lift(chrom string, start, end int, line string) []Interval {
+	l.n++
+	ivs, reason := l.chain.Lift(chrom, start, end, l.split)
+	if len(ivs) == 0 {
+		l.reasons[reason]++
+		fmt.Fprintf(l.unmapped, "#%s\n%s\n", reason, line)
+		return nil
+	}
+	if reason == Split {
+		l.nsplit++
+	}
+	l.lifted++
+	for _, iv := range ivs {
+		if _, ok := l.order[iv.Chrom]; !ok {
+			l.order[iv.Chrom] = len(l.order)
+		}
+	}
+	return ivs
+}
+
+func (l *lifter) sort() {
+	sort.SliceStable(l.records, func(i, j int) bool {
+		a, b := l.records[i], l.records[j]
+		if a.chrom != b.chrom {
+			return l.order[a.chrom] < l.order[b.chrom]
+		}
+		return a.start < b.start
+	})
+}
+
+// columns returns the index of the chrom, start and end columns from a header or, if hdr is nil, the
+// first 3 columns.
+func columns(hdr []string) (int, int, int, error) {
+	if hdr == nil {
+		return 0, 1, 2, nil
+	}
+	c, s, e := -1, -1, -1
+	for i, h := range hdr {
+		switch strings.ToLower(strings.TrimLeft(h, "#")) {
+		case "chrom", "chromosome", "chr":
+			c = i
+		case "start", "chromstart", "loc.start":
+			s = i
+		case "end", "chromend", "loc.end":
+			e = i
+		}
+	}
+	if c == -1 || s == -1 || e == -1 {
+		return 0, 0, 0, fmt.Errorf("liftover: no chrom, start and end columns in header: %s", strings.Join(hdr, " "))
+	}
+	return c, s, e, nil
+}
+
+// liftBed lifts a file with chrom, start and end columns. Header lines are kept. Coordinates are
+// 0-based and half-open as in the outputs of indexcov.
+func (l *lifter) liftBed(br *bufio.Reader, w io.Writer) error {
+	var header []string
+	ci, si, ei := -1, -1, -1
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			toks := strings.Split(line, "\t")
+			if ci == -1 {
+				var hdr []string
+				// a header starts with # or has a start column that is not a number as in seg files.
+				if _, serr := strconv.Atoi(at(toks, 1)); line[0] == '#' || serr != nil {
+					hdr = toks
+				}
+				var cerr error
+				if ci, si, ei, cerr = columns(hdr); cerr != nil {
+					return cerr
+				}
+				if hdr != nil {
+					header = append(header, line)
+					continue
+				}
+			} else if line[0] == '#' {
+				header = append(header, line)
+				continue
+			}
+			start, serr := strconv.Atoi(at(toks, si))
+			end, eerr := strconv.Atoi(at(toks, ei))
+			if serr != nil || eerr != nil {
+				return fmt.Errorf("liftover: expected integer start and end at line %d: %s", i, line)
+			}
+			for _, iv := range l.lift(toks[ci], start, end, line) {
+				out := append([]string{}, toks...)
+				out[ci], out[si], out[ei] = iv.Chrom, strconv.Itoa(iv.Start), strconv.Itoa(iv.End)
+				l.records = append(l.records, record{chrom: iv.Chrom, start: iv.Start, toks: out})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	l.sort()
+	bw := bufio.NewWriter(w)
+	for _, h := range header {
+		fmt.Fprintln(bw, h)
+	}
+	for _, r := range l.records {
+		if _, err := fmt.Fprintln(bw, strings.Join(r.toks, "\t")); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func at(toks []string, i int) string {
+	if i < len(toks) {
+		return toks[i]
+	}
+	return ""
+}
+
+// liftVCF lifts POS and END of each variant. The contig lines are replaced by those of the new build.
+func (l *lifter) liftVCF(br *bufio.Reader, w io.Writer, chainPath string) error {
+	var header []string
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "##contig="):
+		case line[0] == '#':
+			header = append(header, line)
+		default:
+			toks := strings.Split(line, "\t")
+			if len(toks) < 8 {
+				return fmt.Errorf("liftover: expected at least 8 columns at line %d of the vcf", i)
+			}
+			pos, perr := strconv.Atoi(toks[1])
+			if perr != nil {
+				return fmt.Errorf("liftover: bad position at line %d of the vcf: %s", i, toks[1])
+			}
+			info := strings.Split(toks[7], ";")
+			end := pos - 1 + len(toks[3])
+			for _, kv := range info {
+				if strings.HasPrefix(kv, "END=") {
+					if end, perr = strconv.Atoi(kv[4:]); perr != nil {
+						return fmt.Errorf("liftover: bad END at line %d of the vcf: %s", i, kv)
+					}
+				}
+			}
+			for _, iv := range l.lift(toks[0], pos-1, end, line) {
+				out := append([]string{}, toks...)
+				out[0], out[1] = iv.Chrom, strconv.Itoa(iv.Start+1)
+				ninfo := make([]string, len(info))
+				for k, kv := range info {
+					switch {
+					case strings.HasPrefix(kv, "END="):
+						kv = fmt.Sprintf("END=%d", iv.End)
+					case strings.HasPrefix(kv, "SVLEN=-"):
+						kv = fmt.Sprintf("SVLEN=-%d", iv.End-iv.Start)
+					case strings.HasPrefix(kv, "SVLEN="):
+						kv = fmt.Sprintf("SVLEN=%d", iv.End-iv.Start)
+					}
+					ninfo[k] = kv
+				}
+				out[7] = strings.Join(ninfo, ";")
+				l.records = append(l.records, record{chrom: iv.Chrom, start: iv.Start, toks: out})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	l.sort()
+	chroms := make([]string, len(l.order))
+	for c, i := range l.order {
+		chroms[i] = c
+	}
+	bw := bufio.NewWriter(w)
+	for i, h := range header {
+		if i == len(header)-1 {
+			fmt.Fprintf(bw, "##liftover=%s\n", chainPath)
+			for _, c := range chroms {
+				fmt.Fprintf(bw, "##contig=<ID=%s,length=%d>\n", c, l.chain.Sizes[c])
+			}
+		}
+		fmt.Fprintln(bw, h)
+	}
+	for _, r := range l.records {
+		if _, err := fmt.Fprintln(bw, strings.Join(r.toks, "\t")); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// create returns a bgzf writer if path ends with .gz.
+func create(path string) (io.WriteCloser, error) {
+	fh, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return fh, nil
+	}
+	w := bgzf.NewWriter(fh, 2)
+	w.ModTime = time.Unix(0, 0)
+	w.OS = 0xff
+	return &bgzfFile{Writer: w, fh: fh}, nil
+}
+
+type bgzfFile struct {
+	*bgzf.Writer
+	fh *os.File
+}
+
+func (b *bgzfFile) Close() error {
+	if err := b.Writer.Close(); err != nil {
+		return err
+	}
+	return b.fh.Close()
+}
+
+// Main is called from the goleft dispatcher.
+func Main() {
+	cli := &cliargs{MinMatch: 0.95}
+	p := arg.MustParse(cli)
+	if cli.MinMatch <= 0 || cli.MinMatch > 1 {
+		p.Fail("liftover: --minmatch must be in (0, 1]")
+	}
+	if cli.Unmapped == "" {
+		cli.Unmapped = cli.Out + ".unmapped"
+	}
+	chain, err := ReadChain(cli.Chain)
+	if err != nil {
+		goleft.Fatal(goleft.InputErr(err))
+	}
+	chain.MinMatch = cli.MinMatch
+
+	rdr, err := xopen.Ropen(cli.Input)
+	if err != nil {
+		goleft.Fatal(goleft.InputErr(err))
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+
+	uf, err := os.Create(cli.Unmapped)
+	if err != nil {
+		goleft.Fatal(err)
+	}
+	l := &lifter{chain: chain, split: cli.Split, unmapped: bufio.NewWriter(uf), order: make(map[string]int),
+		counts: counts{reasons: make(map[string]int)}}
+	out, err := create(cli.Out)
+	if err != nil {
+		goleft.Fatal(err)
+	}
+	if first, _ := br.Peek(16); strings.HasPrefix(string(first), "##fileformat=VCF") {
+		err = l.liftVCF(br, out, cli.Chain)
+	} else {
+		err = l.liftBed(br, out)
+	}
+	if err != nil {
+		goleft.Fatal(goleft.InputErr(err))
+	}
+	if err := out.Close(); err != nil {
+		goleft.Fatal(err)
+	}
+	if err := l.unmapped.Flush(); err != nil {
+		goleft.Fatal(err)
+	}
+	if err := uf.Close(); err != nil {
+		goleft.Fatal(err)
+	}
+	log.Printf("liftover: lifted %d of %d intervals (%d split into pieces). not lifted: %d deleted, %d partially deleted, %d split. see %s",
+		l.lifted, l.n, l.nsplit, l.reasons[Deleted], l.reasons[PartiallyDeleted], l.reasons[Split], cli.Unmapped)
+}
@@ -0,0 +1,92 @@
+package liftover_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brentp/goleft/liftover"
+)
+
+// chr1:0-1000 maps to chrA:100-1100 except for a 100 base gap at 500 in the old build.
+// chr1:2000-2500 maps to the - strand of chrB (length 10000) and chr1:2500-3000 to chrC.
+const chain = `chain 1000 chr1 100000 + 0 1000 chrA 5000 + 100 1000 1
+500 100 0
+400
+
+chain 900 chr1 100000 + 2000 2500 chrB 10000 - 1000 1500 2
+500
+
+chain 800 chr1 100000 + 2500 3000 chrC 8000 + 0 500 3
+500
+`
+
+func readChain(t *testing.T) *liftover.Chain {
+	p := filepath.Join(t.TempDir(), "test.over.chain")
+	if err := os.WriteFile(p, []byte(chain), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := liftover.ReadChain(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReadChain(t *testing.T) {
+	c := readChain(t)
+	if c.Sizes["chrA"] != 5000 || c.Sizes["chrB"] != 10000 || c.Sizes["chrC"] != 8000 {
+		t.Errorf("unexpected sizes: %v", c.Sizes)
+	}
+	p := filepath.Join(t.TempDir(), "bad.chain")
+	os.WriteFile(p, []byte("500\n"), 0644)
+	if _, err := liftover.ReadChain(p); err == nil {
+		t.Error("expected an error for data before a header")
+	}
+}
+
+func TestLift(t *testing.T) {
+	c := readChain(t)
+	for _, tc := range []struct {
+		chrom      string
+		start, end int
+		split      bool
+		reason     string
+		want       []liftover.Interval
+	}{
+		{"chr1", 10, 20, false, "", []liftover.Interval{{Chrom: "chrA", Start: 110, End: 120}}},
+		// after the gap in the old build, the new coordinates are 100 less.
+		{"chr1", 610, 620, false, "", []liftover.Interval{{Chrom: "chrA", Start: 610, End: 620}}},
+		{"chr1", 2000, 2100, false, "", []liftover.Interval{{Chrom: "chrB", Start: 8900, End: 9000, Reverse: true}}},
+		{"chr1", 500, 600, false, liftover.Deleted, nil},
+		{"chr1", 1500, 1600, false, liftover.Deleted, nil},
+		{"chr2", 10, 20, false, liftover.Deleted, nil},
+		{"chr1", 400, 600, false, liftover.PartiallyDeleted, nil},
+		{"chr1", 2400, 2600, false, liftover.Split, nil},
+		{"chr1", 2400, 2600, true, liftover.Split, []liftover.Interval{
+			{Chrom: "chrB", Start: 8500, End: 8600, Reverse: true}, {Chrom: "chrC", Start: 0, End: 100}}},
+	} {
+		got, reason := c.Lift(tc.chrom, tc.start, tc.end, tc.split)
+		if reason != tc.reason {
+			t.Errorf("%s:%d-%d: expected reason %q, got %q", tc.chrom, tc.start, tc.end, tc.reason, reason)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s:%d-%d: expected %v, got %v", tc.chrom, tc.start, tc.end, tc.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s:%d-%d: expected %v, got %v", tc.chrom, tc.start, tc.end, tc.want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestMinMatch(t *testing.T) {
+	c := readChain(t)
+	c.MinMatch = 0.5
+	got, reason := c.Lift("chr1", 400, 600, false)
+	if reason != "" || len(got) != 1 || got[0].Start != 500 || got[0].End != 600 {
+		t.Errorf("expected chrA:500-600 with a min-match of 0.5, got %v %q", got, reason)
+	}
+}
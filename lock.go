@@ -0,0 +1,159 @@
+package goleft
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// a held lock file is touched this often so that a lock of a run on another host that died
+	// can be told from one that is still running.
+	lockRefresh = 30 * time.Second
+	// lockStale is the age after which a lock that has not been touched is taken over.
+	lockStale = 4 * lockRefresh
+	// lockPoll is how often a waiting run checks the lock.
+	lockPoll = time.Second
+)
+
+// LockedError is returned when another run holds the lock of an output prefix.
+type LockedError struct {
+	Path string
+	// Owner is the host, pid and start time of the run holding the lock.
+	Owner string
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is held by another goleft run with the same output prefix (%s). wait for it to finish (see --lock-wait), use a different prefix or, if that run is no longer running, remove the lock file", e.Path, e.Owner)
+}
+
+// Lock is an exclusive lock on an output prefix so that 2 runs do not write the same files.
+type Lock struct {
+	path string
+	done chan struct{}
+	once sync.Once
+}
+
+// AcquireLock creates $prefix.lock. If another run holds it, AcquireLock waits for up to wait for
+// it to be released and then returns a *LockedError. Locks left by runs that died (on the same
+// host or that have not been touched for a few minutes) are taken over. The lock is held until
+// Release is called.
+func AcquireLock(prefix string, wait time.Duration) (*Lock, error) {
+	path := prefix + ".lock"
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s\t%d\t%s\n", host, os.Getpid(), time.Now().Format(time.RFC3339))
+	deadline := time.Now().Add(wait)
+	logged := false
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(owner)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			l := &Lock{path: path, done: make(chan struct{})}
+			go l.refresh()
+			return l, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		held, stale, err := readLock(path, host)
+		if os.IsNotExist(err) {
+			// it was released since the create.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		who := strings.Replace(strings.TrimSpace(held), "\t", " ", -1)
+		if stale {
+			// only remove the lock that was read so that a run that just took it over is not removed.
+			if cur, _ := ioutil.ReadFile(path); string(cur) == held {
+				log.Printf("goleft: removing stale lock %s from %s", path, who)
+				os.Remove(path)
+			}
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return nil, &LockedError{Path: path, Owner: who}
+		}
+		if !logged {
+			log.Printf("goleft: waiting for %s held by %s", path, who)
+			logged = true
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// readLock returns the content of the lock at path and true if the run that holds it is not
+// running: it was on this host and its process is gone or the lock has not been touched for
+// lockStale.
+func readLock(path, host string) (string, bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", false, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	held := string(b)
+	if time.Since(fi.ModTime()) > lockStale {
+		return held, true, nil
+	}
+	toks := strings.Split(strings.TrimSpace(held), "\t")
+	if len(toks) < 2 || toks[0] != host {
+		return held, false, nil
+	}
+	pid, err := strconv.Atoi(toks[1])
+	return held, err == nil && !processAlive(pid), nil
+}
+
+// processAlive returns true if a process with the pid is running on this host.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// refresh touches the lock until it is released.
+func (l *Lock) refresh() {
+	t := time.NewTicker(lockRefresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case now := <-t.C:
+			os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+// Release removes the lock. It is safe to call more than once and on a nil Lock.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = os.Remove(l.path)
+	})
+	return err
+}
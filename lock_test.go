@@ -0,0 +1,147 @@
+package goleft
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tmpPrefix(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "goleft-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "out")
+}
+
+// writeLock writes a lock at prefix as if it were held by pid on host and last touched at mod.
+func writeLock(t *testing.T, prefix, host string, pid int, mod time.Time) {
+	owner := fmt.Sprintf("%s\t%d\t%s\n", host, pid, mod.Format(time.RFC3339))
+	if err := ioutil.WriteFile(prefix+".lock", []byte(owner), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(prefix+".lock", mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockContention(t *testing.T) {
+	prefix := tmpPrefix(t)
+	l, err := AcquireLock(prefix, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(prefix + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	if toks := strings.Split(string(b), "\t"); len(toks) != 3 || toks[0] != host || toks[1] != fmt.Sprint(os.Getpid()) {
+		t.Errorf("expected host, pid and time in the lock, got %q", b)
+	}
+
+	_, err = AcquireLock(prefix, 0)
+	var le *LockedError
+	if !errors.As(err, &le) {
+		t.Fatalf("expected a *LockedError for a held lock, got %v", err)
+	}
+	if le.Path != prefix+".lock" || !strings.HasPrefix(le.Owner, host+" ") {
+		t.Errorf("unexpected LockedError: %+v", le)
+	}
+	if ExitStatus(err) != ExitLocked {
+		t.Errorf("expected exit status %d, got %d", ExitLocked, ExitStatus(err))
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(prefix + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("expected the lock to be removed, got %v", err)
+	}
+	// Release is safe to call again and on a nil Lock.
+	if err := l.Release(); err != nil {
+		t.Errorf("expected no error from a second Release, got %v", err)
+	}
+	var nl *Lock
+	if err := nl.Release(); err != nil {
+		t.Errorf("expected no error from a nil Release, got %v", err)
+	}
+
+	l, err = AcquireLock(prefix, 0)
+	if err != nil {
+		t.Fatalf("expected the released lock to be acquired, got %v", err)
+	}
+	l.Release()
+}
+
+func TestLockWait(t *testing.T) {
+	prefix := tmpPrefix(t)
+	held, err := AcquireLock(prefix, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	_, err = AcquireLock(prefix, 1500*time.Millisecond)
+	if ExitStatus(err) != ExitLocked {
+		t.Fatalf("expected a *LockedError after --lock-wait, got %v", err)
+	}
+	if d := time.Since(t0); d < 1500*time.Millisecond {
+		t.Errorf("expected to wait at least 1.5s, waited %s", d)
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		held.Release()
+	}()
+	l, err := AcquireLock(prefix, time.Minute)
+	if err != nil {
+		t.Fatalf("expected the lock once it was released, got %v", err)
+	}
+	l.Release()
+}
+
+func TestLockStale(t *testing.T) {
+	host, _ := os.Hostname()
+	// no process has a pid above the largest pid_max of linux.
+	dead := 1<<22 + 1
+
+	cases := []struct {
+		name  string
+		host  string
+		pid   int
+		age   time.Duration
+		stale bool
+	}{
+		{"dead process on this host", host, dead, 0, true},
+		{"live process on this host", host, os.Getpid(), 0, false},
+		{"other host touched recently", host + "-other", dead, lockRefresh, false},
+		{"other host not touched", host + "-other", dead, lockStale + time.Minute, true},
+		{"live process not touched", host, os.Getpid(), lockStale + time.Minute, true},
+	}
+	for _, c := range cases {
+		prefix := tmpPrefix(t)
+		writeLock(t, prefix, c.host, c.pid, time.Now().Add(-c.age))
+		l, err := AcquireLock(prefix, 0)
+		if c.stale {
+			if err != nil {
+				t.Errorf("%s: expected the stale lock to be taken over, got %v", c.name, err)
+				continue
+			}
+			b, _ := ioutil.ReadFile(prefix + ".lock")
+			if !strings.HasPrefix(string(b), fmt.Sprintf("%s\t%d\t", host, os.Getpid())) {
+				t.Errorf("%s: expected the lock to be owned by this run, got %q", c.name, b)
+			}
+			l.Release()
+			continue
+		}
+		if ExitStatus(err) != ExitLocked {
+			t.Errorf("%s: expected a *LockedError, got %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,539 @@
+// Package plots draws the depth, sex and PCA figures of indexcov so that other goleft commands and
+// external tools can make the same figures from their own data. Each chart is built with go-chartjs
+// for the interactive html and the same data is drawn with gonum/plot for the png.
+package plots
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image/color"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+
+	chartjs "github.com/brentp/go-chartjs"
+	"github.com/brentp/go-chartjs/types"
+)
+
+// MaxDepth is the default scaled depth above which values are truncated in the depth plots.
+const MaxDepth = 2.5
+
+// XYs are the points of a dataset. They implement chartjs.Values and gonum's plotter.XYer so that
+// the same data is drawn in the html and the png.
+type XYs struct {
+	X []float64
+	Y []float64
+}
+
+// Xs implements chartjs.Values.
+func (v *XYs) Xs() []float64 {
+	return v.X
+}
+
+// Ys implements chartjs.Values.
+func (v *XYs) Ys() []float64 {
+	return v.Y
+}
+
+// Rs implements chartjs.Values.
+func (v *XYs) Rs() []float64 {
+	return nil
+}
+
+// Len implements plotter.XYer.
+func (v *XYs) Len() int {
+	return len(v.X)
+}
+
+// XY implements plotter.XYer.
+func (v *XYs) XY(i int) (x, y float64) {
+	return v.X[i], v.Y[i]
+}
+
+// Sample returns every nth point.
+func (v *XYs) Sample(nth int) *XYs {
+	o := &XYs{X: make([]float64, 0, 10+len(v.X)/nth),
+		Y: make([]float64, 0, 10+len(v.X)/nth)}
+	for i, x := range v.X {
+		if i%nth == 0 {
+			o.X = append(o.X, x)
+			o.Y = append(o.Y, v.Y[i])
+		}
+	}
+	return o
+}
+
+// Steps returns the values as points step apart. Leading zeros are skipped and values above max are
+// set to max.
+func Steps(vals []float32, step float64, max float64) *XYs {
+	v := &XYs{X: make([]float64, 0, len(vals)), Y: make([]float64, 0, len(vals))}
+	seenNonZero := false
+	for i, r := range vals {
+		if r == 0 && !seenNonZero {
+			continue
+		}
+		seenNonZero = true
+		v.X = append(v.X, float64(i)*step)
+		v.Y = append(v.Y, math.Min(float64(r), max))
+	}
+	return v
+}
+
+// Options are shared by the plots.
+type Options struct {
+	// Backgrounds is the number of leading samples that are drawn in gray and are not named in the
+	// tooltips, e.g. a reference panel that the other samples are compared to.
+	Backgrounds int
+	// MaxDepth truncates the scaled depths in the depth plots. If it is 0, MaxDepth is used.
+	MaxDepth float64
+	// HTML also writes an interactive html page for the depth plots.
+	HTML bool
+	// Format, if it is "svg" or "eps", also saves each png in that format.
+	Format string
+}
+
+func (o Options) maxDepth() float64 {
+	if o.MaxDepth <= 0 {
+		return MaxDepth
+	}
+	return o.MaxDepth
+}
+
+var backgroundColor = &types.RGBA{R: 180, G: 180, B: 180, A: 240}
+
+// Color returns a color for i that is the same from run to run.
+func Color(i int) *types.RGBA {
+	rng := rand.New(rand.NewSource(int64(i)))
+	return &types.RGBA{
+		R: uint8(rng.Intn(256)),
+		G: uint8(rng.Intn(256)),
+		B: uint8(rng.Intn(256)),
+		A: 240}
+}
+
+// SampleColor returns the color of the ith sample which is gray for the backgrounds.
+func (o Options) SampleColor(i int) *types.RGBA {
+	if i < o.Backgrounds {
+		return backgroundColor
+	}
+	return Color(i)
+}
+
+// SampleSeries is the scaled depth of a sample in consecutive bins along a chromosome.
+type SampleSeries struct {
+	Sample string
+	Depths []float32
+}
+
+// Band is a named interval (0-based, half-open) of a chromosome such as a cytoband.
+type Band struct {
+	Start, End int
+	Name       string
+}
+
+// Annotations label the positions of a chromosome in the depth plots, e.g. with its cytobands. A
+// label is the Prefix followed by the name of the band, e.g. 7 and q11.23.
+type Annotations struct {
+	Prefix string
+	// Bands are sorted by Start and do not overlap.
+	Bands []Band
+}
+
+// Label returns the label of the band that contains pos or "" if there is none.
+func (a Annotations) Label(pos int) string {
+	i := sort.Search(len(a.Bands), func(i int) bool { return a.Bands[i].End > pos })
+	if i == len(a.Bands) || a.Bands[i].Start > pos {
+		return ""
+	}
+	return a.Prefix + a.Bands[i].Name
+}
+
+// js labels the x-axis ticks and the tooltips of a depth plot with the band of the position.
+func (a Annotations) js() (string, error) {
+	if len(a.Bands) == 0 {
+		return "", nil
+	}
+	js := make([][]interface{}, len(a.Bands))
+	for i, b := range a.Bands {
+		js[i] = []interface{}{b.Start, b.End, a.Prefix + b.Name}
+	}
+	bjson, err := json.Marshal(js)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`
+	var bands = %s
+	function band(pos) {
+		for (var i = 0; i < bands.length; i++) {
+			if (pos >= bands[i][0] && pos < bands[i][1]) { return bands[i][2] }
+		}
+		return ""
+	}
+	chart.options.scales.xAxes[0].ticks = chart.options.scales.xAxes[0].ticks || {}
+	chart.options.scales.xAxes[0].ticks.callback = function(v) {
+		return [(v / 1000000).toFixed(1) + "MB", band(v)]
+	}
+	chart.options.tooltips.callbacks = chart.options.tooltips.callbacks || {}
+	chart.options.tooltips.callbacks.title = function(tts, data) {
+		var x = data.datasets[tts[0].datasetIndex].data[tts[0].index].x
+		return (x / 1000000).toFixed(2) + "MB " + band(x)
+	}`, bjson), nil
+}
+
+// DepthChart returns the chart of the scaled depths of each sample along chrom in bins of the given width.
+func DepthChart(series []SampleSeries, chrom string, width int, opts Options) (chartjs.Chart, error) {
+	chart := chartjs.Chart{Label: chrom}
+	xa, err := chart.AddXAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Bottom, ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: "position on " + chrom, Display: chartjs.True}})
+	if err != nil {
+		return chart, err
+	}
+	ya, err := chart.AddYAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Left,
+		Tick:       &chartjs.Tick{Min: 0, Max: opts.maxDepth()},
+		ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: "scaled coverage", Display: chartjs.True}})
+	if err != nil {
+		return chart, err
+	}
+
+	w := 0.4
+	if len(series) > 30 {
+		w = 0.3
+	}
+	if len(series) > 50 {
+		w = 0.2
+	}
+	datasets := make([]chartjs.Dataset, 0, len(series))
+	for i, s := range series {
+		c := opts.SampleColor(i)
+		dataset := chartjs.Dataset{Data: Steps(s.Depths, float64(width), opts.maxDepth()), Label: s.Sample, Fill: chartjs.False,
+			PointRadius: 0, BorderWidth: w, BorderColor: c, BackgroundColor: c, SteppedLine: chartjs.True, PointHitRadius: 6}
+		dataset.XAxisID = xa
+		dataset.YAxisID = ya
+		datasets = append(datasets, dataset)
+	}
+	// the first samples (and the backgrounds) are drawn last so they are on top.
+	for i := len(datasets) - 1; i >= 0; i-- {
+		chart.AddDataset(datasets[i])
+	}
+	chart.Options.Responsive = chartjs.False
+	chart.Options.Tooltip = &chartjs.Tooltip{Mode: "nearest"}
+	return chart, nil
+}
+
+// Depths writes the depth plot of chrom with bins of the given width to $prefix-depth-$chrom.png and, with
+// opts.HTML, to $prefix-depth-$chrom.html. Positions are labeled with the annotations.
+func Depths(series []SampleSeries, chrom string, width int, ann Annotations, prefix string, opts Options) error {
+	chart, err := DepthChart(series, chrom, width, opts)
+	if err != nil {
+		return err
+	}
+	if opts.HTML {
+		wtr, err := os.Create(fmt.Sprintf("%s-depth-%s.html", prefix, chrom))
+		if err != nil {
+			return err
+		}
+		defer wtr.Close()
+		link := template.HTML(`<a href="index.html">back to index</a>`)
+		bandjs, err := ann.js()
+		if err != nil {
+			return err
+		}
+		if err := chart.SaveHTML(wtr, map[string]interface{}{"width": 850, "height": 550, "customHTML": link, "custom": template.JS(bandjs)}); err != nil {
+			return err
+		}
+		if err := wtr.Close(); err != nil {
+			return err
+		}
+	}
+	return savePNG(fmt.Sprintf("%s-depth-%s.png", prefix, chrom), chart, 4, 3, xticks{ann: ann}, opts)
+}
+
+// titleJS names the samples of each dataset in the tooltips. names[i] are the samples of the ith dataset.
+func titleJS(names [][]string) (string, error) {
+	sjson, err := json.Marshal(names)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`
+	chart.options.hover.mode = 'index'
+	chart.options.tooltips.callbacks.title = function(tts, data) {
+		var names = %s
+		var out = []
+		tts.forEach(function(ti) {
+			out.push(names[ti.datasetIndex][ti.index])
+		})
+		return out.join(",")
+	}`, sjson), nil
+}
+
+// SexCNs are the copy-numbers of 2 sex chromosomes for each sample along with the copy-number of the
+// first that was inferred from them.
+type SexCNs struct {
+	Chroms   [2]string
+	CNs      [2][]float64
+	Inferred []int
+}
+
+// Sex returns a scatter plot of the copy-numbers of the sex chromosomes with a dataset for each inferred
+// copy-number and javascript that names the samples in the tooltips. Backgrounds are not drawn.
+func Sex(cns SexCNs, samples []string, opts Options) (*chartjs.Chart, string, error) {
+	if len(cns.CNs[0]) != len(samples) || len(cns.CNs[1]) != len(samples) || len(cns.Inferred) != len(samples) {
+		return nil, "", fmt.Errorf("plots: expected %d copy-numbers for the sex plot", len(samples))
+	}
+	chart := chartjs.Chart{Label: "sex"}
+	xa, err := chart.AddXAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Bottom,
+		ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: cns.Chroms[0] + " Copy Number",
+			Display: chartjs.True}, Tick: &chartjs.Tick{Min: 0}})
+	if err != nil {
+		return nil, "", err
+	}
+	ya, err := chart.AddYAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Left,
+		ScaleLabel: &chartjs.ScaleLabel{FontSize: 16, LabelString: cns.Chroms[1] + " Copy Number",
+			Display: chartjs.True}, Tick: &chartjs.Tick{Min: 0}})
+	if err != nil {
+		return nil, "", err
+	}
+	var inferred []int
+	seen := make(map[int]bool)
+	for _, cn := range cns.Inferred {
+		if !seen[cn] {
+			seen[cn] = true
+			inferred = append(inferred, cn)
+		}
+	}
+	sort.Ints(inferred)
+	// chartjs separates into datasets so we need to track which samples in which datasets.
+	jssamples := make([][]string, 0, len(inferred))
+	for _, cn := range inferred {
+		names := make([]string, 0)
+		vals := &XYs{X: make([]float64, 0, len(samples)), Y: make([]float64, 0, len(samples))}
+		for i, inf := range cns.Inferred {
+			if inf != cn || i < opts.Backgrounds {
+				continue
+			}
+			names = append(names, samples[i])
+			vals.X = append(vals.X, cns.CNs[0][i])
+			vals.Y = append(vals.Y, cns.CNs[1][i])
+		}
+		jssamples = append(jssamples, names)
+		c := Color(cn)
+		dataset := chartjs.Dataset{Data: vals, Label: fmt.Sprintf("Inferred CN for %s: %d", cns.Chroms[0], cn), Fill: chartjs.False, PointRadius: 6, BorderWidth: 0,
+			BorderColor: &types.RGBA{R: 90, G: 90, B: 90, A: 150}, PointBackgroundColor: c, BackgroundColor: c, ShowLine: chartjs.False, PointHitRadius: 6}
+		dataset.XAxisID = xa
+		dataset.YAxisID = ya
+		chart.AddDataset(dataset)
+	}
+	jsfunc, err := titleJS(jssamples)
+	if err != nil {
+		return nil, "", err
+	}
+	chart.Options.Responsive = chartjs.False
+	chart.Options.Tooltip = &chartjs.Tooltip{Mode: "nearest"}
+	return &chart, jsfunc, nil
+}
+
+// scatter adds the points as a dataset of a scatter plot.
+func scatter(chart *chartjs.Chart, xys *XYs, c *types.RGBA, xa, ya string) {
+	dataset := chartjs.Dataset{Data: xys, Label: "samples", Fill: chartjs.False, PointHoverRadius: 6,
+		PointRadius: 4, BorderWidth: 0, BorderColor: &types.RGBA{R: 150, G: 150, B: 150, A: 150},
+		PointBackgroundColor: c, BackgroundColor: c, ShowLine: chartjs.False, PointHitRadius: 6}
+	dataset.XAxisID = xa
+	dataset.YAxisID = ya
+	chart.AddDataset(dataset)
+}
+
+// PCA returns scatter plots of PC1 against PC2 and against PC3 of proj, which has a row per sample, and
+// javascript that names the samples in the tooltips. vars is the variance explained by each component.
+func PCA(proj *mat.Dense, samples []string, vars []float64, opts Options) ([]chartjs.Chart, string, error) {
+	if _, c := proj.Dims(); c < 3 || len(vars) < 3 {
+		return nil, "", fmt.Errorf("plots: need 3 principal components for the PCA plots")
+	}
+	var charts []chartjs.Chart
+	c := &types.RGBA{R: 110, G: 250, B: 59, A: 240}
+	nb := opts.Backgrounds
+
+	for _, pc := range []int{2, 3} {
+		c1 := chartjs.Chart{}
+		xa, err := c1.AddXAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Bottom, ScaleLabel: &chartjs.ScaleLabel{FontSize: 16,
+			LabelString: fmt.Sprintf("PC1 (variance explained: %.2f%%)", 100*vars[0]),
+			Display:     chartjs.True}})
+		if err != nil {
+			return nil, "", err
+		}
+		ya, err := c1.AddYAxis(chartjs.Axis{Type: chartjs.Linear, Position: chartjs.Left, ScaleLabel: &chartjs.ScaleLabel{FontSize: 16,
+			LabelString: fmt.Sprintf("PC%d (variance explained: %.2f%%)", pc, 100*vars[pc-1]),
+			Display:     chartjs.True}})
+		if err != nil {
+			return nil, "", err
+		}
+		x, y := mat.Col(nil, 0, proj), mat.Col(nil, pc-1, proj)
+		if nb > 0 {
+			scatter(&c1, &XYs{X: x[:nb], Y: y[:nb]}, backgroundColor, xa, ya)
+		}
+		scatter(&c1, &XYs{X: x[nb:], Y: y[nb:]}, c, xa, ya)
+		c1.Options.Responsive = chartjs.False
+		c1.Options.Legend = &chartjs.Legend{Display: chartjs.False}
+		c1.Options.Tooltip = &chartjs.Tooltip{Mode: "nearest"}
+		charts = append(charts, c1)
+	}
+	sjson, err := json.Marshal(samples[nb:])
+	if err != nil {
+		return nil, "", err
+	}
+	jsfunc := fmt.Sprintf(`
+	chart.options.hover.mode = 'index';
+	chart.options.tooltips.callbacks.title = function(tts, data) {
+        var names = %s
+        var has_backgrounds = %v
+        var out = []
+        tts.forEach(function(ti) {
+            if(has_backgrounds == (ti.datasetIndex == 1)) {
+                out.push(names[ti.index])
+            } else {
+                out.push("background")
+            }
+        })
+        return out.join(",")
+    }`, sjson, nb > 0)
+	return charts, jsfunc, nil
+}
+
+// SavePNG draws a chart to a png of the given size in inches. Only line and scatter datasets of
+// XYs are drawn.
+func SavePNG(path string, chart chartjs.Chart, wInches float64, hInches float64, opts Options) error {
+	return savePNG(path, chart, wInches, hInches, xticks{}, opts)
+}
+
+func savePNG(path string, chart chartjs.Chart, wInches float64, hInches float64, xt xticks, opts Options) error {
+	p, err := plot.New()
+	if err != nil {
+		return err
+	}
+	p.X.Label.Text = chart.Options.Scales.XAxes[0].ScaleLabel.LabelString
+	p.Y.Label.Text = chart.Options.Scales.YAxes[0].ScaleLabel.LabelString
+	for i := range chart.Data.Datasets {
+		ds := chart.Data.Datasets[len(chart.Data.Datasets)-i-1]
+		data, ok := ds.Data.(*XYs)
+		if !ok {
+			return fmt.Errorf("plots: can only save datasets of XYs to png")
+		}
+		// gonum plotting is a significant portion of the runtime so we sample datasets.
+		if data.Len() > 2000 {
+			data = data.Sample(10)
+		} else if data.Len() > 1000 {
+			data = data.Sample(5)
+		} else {
+			// no data for some chromosome.
+			bad := false
+			for _, d := range data.Y {
+				if math.IsNaN(d) {
+					bad = true
+					break
+				}
+			}
+			if bad {
+				continue
+			}
+		}
+
+		// scatter plots like the sex chart are drawn as points.
+		if ds.ShowLine == chartjs.False {
+			sc, err := plotter.NewScatter(data)
+			if err != nil {
+				return err
+			}
+			c := color.RGBA(*ds.PointBackgroundColor)
+			c.A = 255
+			sc.GlyphStyle.Color = c
+			sc.GlyphStyle.Radius = vg.Points(math.Max(1, ds.PointRadius/2))
+			p.Add(sc)
+			continue
+		}
+
+		l, err := plotter.NewLine(data)
+		if err != nil {
+			return err
+		}
+		c := color.RGBA(*ds.BorderColor)
+		c.A = 255
+		l.LineStyle.Width = vg.Points(0.8)
+		if len(chart.Data.Datasets) > 30 {
+			l.LineStyle.Width = vg.Points(0.65)
+		}
+		l.Color = c
+		p.Add(l)
+	}
+	if len(chart.Data.Datasets) > 0 {
+		xs := chart.Data.Datasets[0].Data.Xs()
+		// check if we are in a depth plot
+		if len(xs) > 0 && xs[len(xs)-1] > 3 {
+			p.Y.Tick.Marker = yticks{max: opts.maxDepth()}
+			p.X.Tick.Marker = xt
+		}
+	}
+
+	if err := p.Save(vg.Length(wInches)*vg.Inch, vg.Length(hInches)*vg.Inch, path); err != nil {
+		return err
+	}
+	if opts.Format == "svg" || opts.Format == "eps" {
+		l := len(path) - 3
+		return p.Save(vg.Length(wInches)*vg.Inch, vg.Length(hInches)*vg.Inch, path[:l]+opts.Format)
+	}
+	return nil
+}
+
+// yticks labels the scaled depth every 0.5 up to max.
+type yticks struct {
+	max float64
+}
+
+func (y yticks) Ticks(min, max float64) []plot.Tick {
+	var tks []plot.Tick
+	for v := 0.0; v <= y.max+1e-9; v += 0.5 {
+		tks = append(tks, plot.Tick{Value: v, Label: fmt.Sprintf("%.1f", v)})
+	}
+	return tks
+}
+
+// xticks labels positions in megabases and with the annotation of the position.
+type xticks struct {
+	ann Annotations
+}
+
+func (x xticks) Ticks(min, max float64) []plot.Tick {
+	otks := plot.DefaultTicks{}.Ticks(min, max)
+	tks := make([]plot.Tick, 0, 5)
+	for _, t := range otks {
+		if t.Label == "" {
+			continue
+		}
+		t.Label = makeMillions(t.Value)
+		if b := x.ann.Label(int(t.Value)); b != "" {
+			t.Label += "\n" + b
+		}
+		tks = append(tks, t)
+	}
+	return tks
+}
+
+func makeMillions(v float64) string {
+	var lbl string
+	if v > 2000000 {
+		lbl = fmt.Sprintf("%.1fMB", v/1000000)
+	} else if v > 10000 {
+		lbl = fmt.Sprintf("%.1fKB", v/1000)
+	} else {
+		lbl = fmt.Sprintf("%.1f", v)
+	}
+	if strings.HasSuffix(lbl, ".0MB") {
+		lbl = lbl[:len(lbl)-4] + "MB"
+	}
+	return lbl
+}
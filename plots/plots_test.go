@@ -0,0 +1,62 @@
+package plots_test
+
+import (
+	"testing"
+
+	"github.com/brentp/goleft/plots"
+)
+
+func TestSteps(t *testing.T) {
+	xys := plots.Steps([]float32{0, 0, 1, 0, 3}, 10, plots.MaxDepth)
+	if xys.Len() != 3 {
+		t.Fatalf("expected leading zeros to be skipped. got %d points", xys.Len())
+	}
+	if x, y := xys.XY(0); x != 20 || y != 1 {
+		t.Errorf("expected (20, 1). got (%v, %v)", x, y)
+	}
+	if _, y := xys.XY(2); y != plots.MaxDepth {
+		t.Errorf("expected depth to be truncated to %v. got %v", plots.MaxDepth, y)
+	}
+}
+
+func TestAnnotationsLabel(t *testing.T) {
+	ann := plots.Annotations{Prefix: "7", Bands: []plots.Band{{0, 100, "p22"}, {100, 250, "p21"}, {300, 400, "q11"}}}
+	for _, c := range []struct {
+		pos int
+		exp string
+	}{{0, "7p22"}, {99, "7p22"}, {100, "7p21"}, {260, ""}, {399, "7q11"}, {400, ""}} {
+		if got := ann.Label(c.pos); got != c.exp {
+			t.Errorf("position %d: expected %q. got %q", c.pos, c.exp, got)
+		}
+	}
+	if (plots.Annotations{}).Label(10) != "" {
+		t.Errorf("expected no label without bands")
+	}
+}
+
+func TestSex(t *testing.T) {
+	cns := plots.SexCNs{Chroms: [2]string{"X", "Y"}, CNs: [2][]float64{{1, 2, 2, 1}, {1, 0, 0, 1}}, Inferred: []int{1, 2, 2, 1}}
+	chart, _, err := plots.Sex(cns, []string{"bg", "a", "b", "c"}, plots.Options{Backgrounds: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chart.Data.Datasets) != 2 {
+		t.Fatalf("expected a dataset for each inferred copy-number. got %d", len(chart.Data.Datasets))
+	}
+	if n := chart.Data.Datasets[0].Data.(*plots.XYs).Len(); n != 1 {
+		t.Errorf("expected the background sample to be left out. got %d points with CN 1", n)
+	}
+	if _, _, err := plots.Sex(cns, []string{"a"}, plots.Options{}); err == nil {
+		t.Errorf("expected an error with the wrong number of samples")
+	}
+}
+
+func TestSampleColor(t *testing.T) {
+	opts := plots.Options{Backgrounds: 2}
+	if *opts.SampleColor(0) != *opts.SampleColor(1) {
+		t.Errorf("expected backgrounds to have the same color")
+	}
+	if *opts.SampleColor(2) != *plots.Color(2) || *plots.Color(3) != *plots.Color(3) {
+		t.Errorf("expected colors to be the same from call to call")
+	}
+}
@@ -0,0 +1,163 @@
+package samplename
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// Source tells where the name of a sample came from.
+type Source int
+
+const (
+	// FromSM is the SM tag of the read-groups.
+	FromSM Source = iota
+	// FromFile is the file name. It is used when no read-group has a non-empty SM tag.
+	FromFile
+)
+
+func (s Source) String() string {
+	if s == FromSM {
+		return "SM"
+	}
+	return "file name"
+}
+
+var smTag = sam.NewTag("SM")
+
+// distinct returns the sorted, distinct, non-empty values in sms.
+func distinct(sms []string) []string {
+	m := make(map[string]bool, len(sms))
+	out := make([]string, 0, 1)
+	for _, sm := range sms {
+		if sm = strings.TrimSpace(sm); sm != "" && !m[sm] {
+			m[sm] = true
+			out = append(out, sm)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Name returns the sample name of the alignment file (or index) at p given the SM tags of its
+// read-groups. The strategy is:
+//
+//  1. if the non-empty SM tags have a single value, that value.
+//  2. if they have more than 1 value, an error as the file holds more than 1 sample.
+//  3. otherwise (no read-groups or no SM), the name from FromPath.
+func Name(p string, sms []string) (string, Source, error) {
+	sms = distinct(sms)
+	switch len(sms) {
+	case 0:
+		return FromPath(p), FromFile, nil
+	case 1:
+		return sms[0], FromSM, nil
+	}
+	return "", FromSM, fmt.Errorf("samplename: more than 1 sample (%s) in the read-groups of %s", strings.Join(sms, ","), p)
+}
+
+// FromPath returns the sample name implied by the file name of p: the base name (without the query
+// string of a URL) up to its first '.', so that sample.sorted.bam and sample.bam.bai are both
+// sample. Hidden files, whose names start with a '.', keep the part before their next '.'.
+func FromPath(p string) string {
+	if i := strings.Index(p, "://"); i != -1 {
+		if j := strings.IndexAny(p[i+3:], "?#"); j != -1 {
+			p = p[:i+3+j]
+		}
+	}
+	base := path.Base(strings.Replace(p, "\\", "/", -1))
+	dot := strings.HasPrefix(base, ".")
+	base = strings.TrimLeft(base, ".")
+	if i := strings.IndexByte(base, '.'); i != -1 {
+		base = base[:i]
+	}
+	if dot {
+		base = "." + base
+	}
+	return base
+}
+
+// bamMagic starts the uncompressed data of every bam.
+var bamMagic = []byte("BAM\x01")
+
+// maxHeaderText is the longest header text that is read. Longer headers are almost surely a
+// corrupt file rather than a bam. The largest real headers, with hundreds of thousands of @SQ or
+// @RG lines, are a few MB.
+const maxHeaderText = 64 << 20
+
+// ReadHeader reads only the header of the bam in r. Unlike bam.NewReader, it does not start
+// reading ahead into the alignments so only the first few BGZF blocks are read, which matters
+// for remote files and for the many files of a cohort.
+func ReadHeader(r io.Reader) (*sam.Header, error) {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("samplename: not a bam: %s", err)
+	}
+	defer gz.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(gz, magic); err != nil || !bytes.Equal(magic, bamMagic) {
+		return nil, errors.New("samplename: not a bam: bad magic")
+	}
+	var n int32
+	if err := binary.Read(gz, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < 0 || n > maxHeaderText {
+		return nil, fmt.Errorf("samplename: bad header length: %d", n)
+	}
+	// the text grows as it is read so a corrupt length does not allocate more than the file holds.
+	text, err := ioutil.ReadAll(io.LimitReader(gz, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("samplename: truncated header: %s", err)
+	}
+	if len(text) != int(n) {
+		return nil, fmt.Errorf("samplename: truncated header: expected %d bytes, got %d", n, len(text))
+	}
+	text = bytes.TrimRight(text, "\x00")
+
+	if err := binary.Read(gz, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("samplename: bad number of references: %d", n)
+	}
+	// n is not used as the capacity since it is not yet known to be sane.
+	var refs []*sam.Reference
+	for i := int32(0); i < n; i++ {
+		var ln int32
+		if err := binary.Read(gz, binary.LittleEndian, &ln); err != nil {
+			return nil, err
+		}
+		if ln < 1 || ln > 1<<16 {
+			return nil, fmt.Errorf("samplename: bad reference name length: %d", ln)
+		}
+		name := make([]byte, ln)
+		if _, err := io.ReadFull(gz, name); err != nil {
+			return nil, err
+		}
+		var length int32
+		if err := binary.Read(gz, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		ref, err := sam.NewReference(string(bytes.TrimRight(name, "\x00")), "", "", int(length), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	// the @SQ lines in the text, if any, give the same references as the binary list.
+	if bytes.Contains(text, []byte("@SQ\t")) {
+		return sam.NewHeader(text, nil)
+	}
+	return sam.NewHeader(text, refs)
+}
@@ -0,0 +1,118 @@
+package samplename_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+
+	"github.com/brentp/goleft/samplename"
+)
+
+func TestFromPath(t *testing.T) {
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/data/NA12878.bam", "NA12878"},
+		{"NA12878.sorted.dedup.bam", "NA12878"},
+		{"/data/NA12878.bam.bai", "NA12878"},
+		{"/data/NA12878.cram.crai", "NA12878"},
+		{"/data/run.1/NA12878.bam", "NA12878"},
+		{"https://host/bucket/NA12878.bam?X-Amz-Signature=a.b/c", "NA12878"},
+		{"s3://bucket/NA12878.bam#frag", "NA12878"},
+		{`C:\data\NA12878.bam`, "NA12878"},
+		{"/data/.hidden.bam", ".hidden"},
+		{"/data/noext", "noext"},
+	} {
+		if got := samplename.FromPath(tc.path); got != tc.want {
+			t.Errorf("FromPath(%q): got %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	for _, tc := range []struct {
+		sms  []string
+		want string
+		src  samplename.Source
+		err  bool
+	}{
+		{nil, "s1", samplename.FromFile, false},
+		{[]string{""}, "s1", samplename.FromFile, false},
+		{[]string{" ", ""}, "s1", samplename.FromFile, false},
+		{[]string{"NA12878"}, "NA12878", samplename.FromSM, false},
+		// a read-group without an SM does not make the sample ambiguous.
+		{[]string{"", "NA12878", "NA12878"}, "NA12878", samplename.FromSM, false},
+		{[]string{"b", "a"}, "", samplename.FromSM, true},
+	} {
+		got, src, err := samplename.Name("/data/s1.bam", tc.sms)
+		if (err != nil) != tc.err {
+			t.Errorf("Name(%v): unexpected error: %v", tc.sms, err)
+			continue
+		}
+		if got != tc.want || src != tc.src {
+			t.Errorf("Name(%v): got %q (%s), want %q (%s)", tc.sms, got, src, tc.want, tc.src)
+		}
+	}
+	// the error lists the samples in the same order regardless of the order of the read-groups.
+	_, _, e1 := samplename.Name("x.bam", []string{"b", "a"})
+	_, _, e2 := samplename.Name("x.bam", []string{"a", "b"})
+	if e1 == nil || e2 == nil || e1.Error() != e2.Error() {
+		t.Errorf("expected the same error for either order: %v, %v", e1, e2)
+	}
+}
+
+// bamHeader returns the compressed header of a bam with the given text and references.
+func bamHeader(text string, names []string, lens []int32) []byte {
+	var raw bytes.Buffer
+	raw.WriteString("BAM\x01")
+	binary.Write(&raw, binary.LittleEndian, int32(len(text)))
+	raw.WriteString(text)
+	binary.Write(&raw, binary.LittleEndian, int32(len(names)))
+	for i, n := range names {
+		binary.Write(&raw, binary.LittleEndian, int32(len(n)+1))
+		raw.WriteString(n + "\x00")
+		binary.Write(&raw, binary.LittleEndian, lens[i])
+	}
+	// alignments follow the header and are not read.
+	raw.WriteString("not an alignment")
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(raw.Bytes())
+	w.Close()
+	return gz.Bytes()
+}
+
+func TestReadHeader(t *testing.T) {
+	b := bamHeader("@HD\tVN:1.6\n\x00", []string{"chr1", "chr2"}, []int32{1000, 2000})
+	h, err := samplename.ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := h.Refs()
+	if len(refs) != 2 || refs[0].Name() != "chr1" || refs[1].Name() != "chr2" || refs[1].Len() != 2000 {
+		t.Errorf("unexpected references: %v", refs)
+	}
+
+	if _, err := samplename.ReadHeader(bytes.NewReader([]byte("@HD\tVN:1.6\n"))); err == nil {
+		t.Error("expected an error for a sam")
+	}
+	if _, err := samplename.ReadHeader(bytes.NewReader(b[:len(b)/2])); err == nil {
+		t.Error("expected an error for a truncated header")
+	}
+
+	// a corrupt length is an error before or while the text is read.
+	for _, n := range []int32{-1, 64<<20 + 1, 1 << 30, 60 << 20} {
+		var raw bytes.Buffer
+		raw.WriteString("BAM\x01")
+		binary.Write(&raw, binary.LittleEndian, n)
+		raw.WriteString("@HD\tVN:1.6\n")
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(raw.Bytes())
+		gz.Close()
+		if _, err := samplename.ReadHeader(&buf); err == nil {
+			t.Errorf("expected an error for a header length of %d", n)
+		}
+	}
+}
@@ -6,34 +6,20 @@ import (
 	"strings"
 
 	arg "github.com/alexflint/go-arg"
-	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
 	"github.com/brentp/goleft"
 )
 
+// Names returns the distinct, non-empty SM tags of the read-groups in h in sorted order.
 func Names(h *sam.Header) []string {
-	rgs := h.RGs()
-	if len(rgs) == 1 {
-		v := rgs[0].Get(sam.Tag([2]byte{'S', 'M'}))
-		if v == "" {
-			return nil
-		}
-		return []string{v}
+	if h == nil {
+		return nil
 	}
-	m := make(map[string]bool)
-	tag := sam.Tag([2]byte{'S', 'M'})
-	for _, rg := range rgs {
-		v := rg.Get(tag)
-		if v == "" {
-			continue
-		}
-		m[v] = true
+	sms := make([]string, 0, 1)
+	for _, rg := range h.RGs() {
+		sms = append(sms, rg.Get(smTag))
 	}
-	names := make([]string, 0, len(m))
-	for sm := range m {
-		names = append(names, sm)
-	}
-	return names
+	return distinct(sms)
 }
 
 type cliargs struct {
@@ -51,18 +37,17 @@ func Main() {
 
 	f, err := os.Open(cli.Bam)
 	if err != nil {
-		panic(err)
+		goleft.Fatal(goleft.InputErr(err))
 	}
 	defer f.Close()
-	b, err := bam.NewReader(f, 1)
+	h, err := ReadHeader(f)
 	if err != nil {
-		panic(err)
+		goleft.Fatal(goleft.InputErr(err))
 	}
-	defer b.Close()
 
-	names := Names(b.Header())
+	names := Names(h)
 	if cli.ErrorMulti && len(names) != 1 {
-		panic(fmt.Sprintf("goleft/samplename: found multiple samples in %s", cli.Bam))
+		goleft.Fatal(goleft.InputErr(fmt.Errorf("goleft/samplename: found multiple samples in %s", cli.Bam)))
 	}
 	fmt.Println(strings.Join(names, "\n"))
 }
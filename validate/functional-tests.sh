@@ -0,0 +1,26 @@
+#!/bin/bash
+
+test -e ssshtest || wget -q https://raw.githubusercontent.com/ryanlayer/ssshtest/master/ssshtest
+
+. ssshtest
+set -uo pipefail
+
+go build -o ./goleft_test ../cmd/goleft/goleft.go
+
+printf "chr1\t10\t20\nchr2\t1\t5\nchr1\t40\t50\n" > unsorted_test.bed
+printf "chr1\t10\t20\nchr1\t40\t50\n" > sorted_test.bed
+printf "f\ts1\t0\t0\t1\t-9\nf\ts1\t0\t0\tM\t-9\n" > bad_test.ped
+
+run check_unsorted ./goleft_test validate-inputs --bed unsorted_test.bed
+assert_exit_code 20
+assert_in_stdout "not sorted"
+
+run check_sorted ./goleft_test validate-inputs --bed sorted_test.bed
+assert_exit_code 0
+
+run check_ped ./goleft_test validate-inputs --ped bad_test.ped
+assert_exit_code 20
+assert_in_stdout "sample s1 is also at line 1"
+assert_in_stdout "sex must be"
+
+rm -f unsorted_test.bed sorted_test.bed bad_test.ped
@@ -0,0 +1,500 @@
+// Package validate checks the BED, ped and metadata files that are given to goleft commands, and
+// their joins with a set of bams or crams, before a long run is started. It only reads its inputs.
+package validate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/goleft"
+	"github.com/brentp/goleft/indexcov"
+	"github.com/brentp/xopen"
+)
+
+type cliargs struct {
+	Bed      []string `arg:"help:BED file(s) to check as given to depth --bed or indexcov --exclude-regions"`
+	Ped      string   `arg:"help:ped file to check as given to indexcov --ped"`
+	Metadata string   `arg:"help:tab-delimited metadata to check as given to indexcov --metadata"`
+	Fai      string   `arg:"help:fasta index with the chromosomes of the reference. By default these are read from the first bam"`
+	Strict   bool     `arg:"help:treat warnings as errors"`
+	Bams     []string `arg:"positional,help:bams or crams (or their indexes) to join with the ped and metadata"`
+}
+
+func (c cliargs) Version() string {
+	return fmt.Sprintf("validate-inputs %s", goleft.Version)
+}
+
+// issue is a problem found in an input. line is 0 for problems with the whole file.
+type issue struct {
+	path string
+	line int
+	warn bool
+	msg  string
+}
+
+func (i issue) String() string {
+	level := "ERROR"
+	if i.warn {
+		level = "WARNING"
+	}
+	loc := i.path
+	if i.line > 0 {
+		loc = fmt.Sprintf("%s:%d", i.path, i.line)
+	}
+	return fmt.Sprintf("%s\t%s\t%s", level, loc, i.msg)
+}
+
+// repeated tracks a problem that can occur on many lines of a file so that it is only reported once
+// with the first line and the count.
+type repeated struct {
+	first, n int
+	warn     bool
+	msg      string
+}
+
+// report collects the issues of all of the inputs.
+type report struct {
+	issues []issue
+	// repeats are keyed by path and the kind of problem and added to the issues by flush.
+	repeats map[string]*repeated
+	order   []string
+}
+
+func newReport() *report {
+	return &report{repeats: make(map[string]*repeated)}
+}
+
+func (r *report) errorf(path string, line int, format string, a ...interface{}) {
+	r.issues = append(r.issues, issue{path: path, line: line, msg: fmt.Sprintf(format, a...)})
+}
+
+func (r *report) warnf(path string, line int, format string, a ...interface{}) {
+	r.issues = append(r.issues, issue{path: path, line: line, warn: true, msg: fmt.Sprintf(format, a...)})
+}
+
+// repeat records a problem of a kind that is reported once per file by flush.
+func (r *report) repeat(path, kind string, line int, warn bool, msg string) {
+	key := path + "\x00" + kind
+	if rp, ok := r.repeats[key]; ok {
+		rp.n++
+		return
+	}
+	r.repeats[key] = &repeated{first: line, n: 1, warn: warn, msg: msg}
+	r.order = append(r.order, key)
+}
+
+// flush adds the repeated problems of path to the issues.
+func (r *report) flush(path string) {
+	for _, key := range r.order {
+		rp, ok := r.repeats[key]
+		if !ok || !strings.HasPrefix(key, path+"\x00") {
+			continue
+		}
+		msg := rp.msg
+		if rp.n > 1 {
+			msg += fmt.Sprintf(" (and %d more lines)", rp.n-1)
+		}
+		r.issues = append(r.issues, issue{path: path, line: rp.first, warn: rp.warn, msg: msg})
+		delete(r.repeats, key)
+	}
+}
+
+func (r *report) counts() (errors, warnings int) {
+	for _, i := range r.issues {
+		if i.warn {
+			warnings++
+		} else {
+			errors++
+		}
+	}
+	return errors, warnings
+}
+
+// eachLine calls fn with each line of path without the line ending. It reports a file that can not be
+// read and lines ending with \r.
+func eachLine(r *report, path string, fn func(line string, i int)) bool {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		r.errorf(path, 0, "can not be read: %s", err)
+		return false
+	}
+	defer rdr.Close()
+	br := bufio.NewReader(rdr)
+	for i := 1; ; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			r.errorf(path, i, "error reading: %s", err)
+			return false
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasSuffix(line, "\r") {
+			r.repeat(path, "crlf", i, true, "has windows (\\r\\n) line endings which become part of the last column")
+			line = strings.TrimSuffix(line, "\r")
+		}
+		if line != "" || err == nil {
+			fn(line, i)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return true
+}
+
+// chromHint suggests the naming used by the reference when chrom differs only by the chr prefix.
+func chromHint(chrom string, chroms map[string]bool) string {
+	if strings.HasPrefix(chrom, "chr") && chroms[chrom[3:]] {
+		return fmt.Sprintf(". the reference uses %s", chrom[3:])
+	}
+	if chroms["chr"+chrom] {
+		return fmt.Sprintf(". the reference uses chr%s", chrom)
+	}
+	return ""
+}
+
+// checkBed checks that each line has a chromosome, start and end, that the file is sorted by
+// chromosome and start as required by depth and bedtools and reports overlapping intervals.
+// If chroms is not nil, chromosomes that are not in it are reported.
+func checkBed(r *report, path string, chroms map[string]bool) {
+	// ended holds the chromosomes whose block of lines has ended.
+	ended := make(map[string]bool)
+	missing := make(map[string]bool)
+	chrom, lastStart, maxEnd, n := "", -1, -1, 0
+	ok := eachLine(r, path, func(line string, i int) {
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			return
+		}
+		toks := strings.Split(line, "\t")
+		if len(toks) < 3 {
+			msg := "expected at least 3 tab-delimited columns: chrom, start, end"
+			if len(strings.Fields(line)) >= 3 {
+				msg += ". columns must be separated by tabs, not spaces"
+			}
+			r.repeat(path, "columns", i, false, msg)
+			return
+		}
+		start, serr := strconv.Atoi(toks[1])
+		end, eerr := strconv.Atoi(toks[2])
+		if serr != nil || eerr != nil {
+			r.repeat(path, "int", i, false, fmt.Sprintf("start and end must be integers. got %q and %q", toks[1], toks[2]))
+			return
+		}
+		n++
+		if start < 0 {
+			r.repeat(path, "negative", i, false, fmt.Sprintf("negative start %d", start))
+		}
+		if end < start {
+			r.repeat(path, "end", i, false, fmt.Sprintf("end %d is before start %d", end, start))
+		} else if end == start {
+			r.repeat(path, "empty", i, true, "empty interval (start == end). BED is 0-based and half-open")
+		}
+		if toks[0] != chrom {
+			if ended[toks[0]] {
+				r.repeat(path, "sorted", i, false, fmt.Sprintf("not sorted: %s is found again after %s. sort with: sort -k1,1 -k2,2n", toks[0], chrom))
+			}
+			if chrom != "" {
+				ended[chrom] = true
+			}
+			chrom, lastStart, maxEnd = toks[0], -1, -1
+			if chroms != nil && !chroms[chrom] && !missing[chrom] {
+				missing[chrom] = true
+				r.warnf(path, i, "chromosome %s is not in the reference%s", chrom, chromHint(chrom, chroms))
+			}
+		}
+		if start < lastStart {
+			r.repeat(path, "sorted-start", i, false, fmt.Sprintf("not sorted: %s:%d is after %s:%d. sort with: sort -k1,1 -k2,2n", chrom, start, chrom, lastStart))
+		} else if start < maxEnd {
+			r.repeat(path, "overlap", i, true, fmt.Sprintf("%s:%d-%d overlaps the interval before it. merge with: bedtools merge", chrom, start, end))
+		}
+		lastStart = start
+		if end > maxEnd {
+			maxEnd = end
+		}
+	})
+	r.flush(path)
+	if ok && n == 0 {
+		r.errorf(path, 0, "no intervals found")
+	}
+}
+
+// sampleSpace reports sample names with leading or trailing spaces which will not match the bams.
+func sampleSpace(r *report, path string, i int, sample string) {
+	if strings.TrimSpace(sample) != sample {
+		r.repeat(path, "space", i, true, fmt.Sprintf("sample %q has leading or trailing spaces", sample))
+	}
+}
+
+// checkJoin reports the samples of the bams that are not in the file and the reverse. It is an
+// error if none match.
+func checkJoin(r *report, path string, found map[string]int, samples []string) {
+	if samples == nil || len(found) == 0 {
+		return
+	}
+	inBams := make(map[string]bool, len(samples))
+	var notFound []string
+	for _, s := range samples {
+		inBams[s] = true
+		if _, ok := found[s]; !ok {
+			notFound = append(notFound, s)
+		}
+	}
+	matched := len(samples) - len(notFound)
+	if matched == 0 {
+		r.errorf(path, 0, "none of the %d samples match the %d samples from the bams, e.g. %s", len(found), len(samples), samples[0])
+		return
+	}
+	if len(notFound) > 0 {
+		r.warnf(path, 0, "%d of %d samples from the bams are not found: %s", len(notFound), len(samples), abbreviate(notFound))
+	}
+	var extra []string
+	for s := range found {
+		if !inBams[s] {
+			extra = append(extra, s)
+		}
+	}
+	if len(extra) > 0 {
+		r.warnf(path, 0, "%d samples are not in the bams: %s", len(extra), abbreviate(extra))
+	}
+}
+
+func abbreviate(names []string) string {
+	if len(names) > 5 {
+		return strings.Join(names[:5], ", ") + ", ..."
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkPed checks the 6 columns of a ped file, that samples are unique and that parents are in the file.
+func checkPed(r *report, path string, samples []string) {
+	found := make(map[string]int)
+	type parents struct {
+		line     int
+		pat, mat string
+	}
+	var ps []parents
+	eachLine(r, path, func(line string, i int) {
+		if line == "" || line[0] == '#' {
+			return
+		}
+		toks := strings.Fields(line)
+		if len(toks) < 6 {
+			r.repeat(path, "columns", i, false, "expected at least 6 columns: family, sample, father, mother, sex, phenotype")
+			return
+		}
+		sample := toks[1]
+		if j, ok := found[sample]; ok {
+			r.errorf(path, i, "sample %s is also at line %d", sample, j)
+		} else {
+			found[sample] = i
+		}
+		switch toks[4] {
+		case "0", "1", "2", "-9", "other", "unknown":
+		default:
+			r.repeat(path, "sex", i, false, fmt.Sprintf("sex must be 1 (male), 2 (female) or 0 (unknown). got %q", toks[4]))
+		}
+		ps = append(ps, parents{i, toks[2], toks[3]})
+	})
+	for _, p := range ps {
+		for _, parent := range []string{p.pat, p.mat} {
+			if parent == "0" || parent == "-9" {
+				continue
+			}
+			if _, ok := found[parent]; !ok {
+				r.repeat(path, "parent", p.line, true, fmt.Sprintf("parent %s is not a sample in the file", parent))
+			}
+		}
+	}
+	r.flush(path)
+	if len(found) == 0 {
+		r.errorf(path, 0, "no samples found")
+	}
+	checkJoin(r, path, found, samples)
+}
+
+// indexcov writes these columns so they can not be used in the metadata.
+var reserved = map[string]bool{"family_id": true, "sample_id": true, "paternal_id": true, "maternal_id": true,
+	"sex": true, "phenotype": true, "bins.out": true, "bins.lo": true, "bins.hi": true, "bins.in": true,
+	"slope": true, "p.out": true, "mapped": true, "unmapped": true, "ref.match": true, "qc": true,
+	"outside.ref": true, "pca.dist": true, "sex.conf": true}
+
+var reservedPatt = regexp.MustCompile(`^(PC\d+|CN.+)$`)
+
+// checkMetadata checks the header and the number of columns of each line of a metadata file and that
+// samples in the first column are unique.
+func checkMetadata(r *report, path string, samples []string) {
+	var header []string
+	found := make(map[string]int)
+	eachLine(r, path, func(line string, i int) {
+		if line == "" {
+			return
+		}
+		toks := strings.Split(line, "\t")
+		if header == nil {
+			header = toks
+			if len(header) < 2 {
+				r.errorf(path, i, "expected a tab-delimited header with the sample and at least 1 other column")
+			}
+			cols := make(map[string]bool)
+			for _, h := range header[1:] {
+				if h == "" {
+					r.errorf(path, i, "empty column name in the header")
+				} else if cols[h] {
+					r.errorf(path, i, "column %s is in the header more than once", h)
+				} else if reserved[h] || reservedPatt.MatchString(h) {
+					r.errorf(path, i, "column %s is also written by indexcov. rename it", h)
+				}
+				cols[h] = true
+			}
+			return
+		}
+		if len(toks) != len(header) {
+			r.repeat(path, "columns", i, false, fmt.Sprintf("expected %d columns as in the header. got %d", len(header), len(toks)))
+		}
+		sample := toks[0]
+		if sample == "" {
+			r.repeat(path, "empty", i, false, "empty sample name")
+			return
+		}
+		sampleSpace(r, path, i, sample)
+		if j, ok := found[sample]; ok {
+			r.errorf(path, i, "sample %s is also at line %d", sample, j)
+		} else {
+			found[sample] = i
+		}
+	})
+	r.flush(path)
+	if header == nil {
+		r.errorf(path, 0, "no header found")
+		return
+	}
+	checkJoin(r, path, found, samples)
+}
+
+// indexPaths are the places that indexcov looks for the index of a bam or cram.
+func indexPaths(path string) []string {
+	switch {
+	case strings.HasSuffix(path, ".bam"):
+		return []string{path + ".bai", path[:len(path)-4] + ".bai", path + ".csi"}
+	case strings.HasSuffix(path, ".cram"):
+		return []string{path + ".crai", path[:len(path)-5] + ".crai"}
+	}
+	return nil
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://")
+}
+
+// checkBams checks that each bam or cram exists and has an index and returns the sample names as
+// indexcov finds them. Samples found in more than one file are errors.
+func checkBams(r *report, paths []string) []string {
+	var samples []string
+	seen := make(map[string]string)
+	for _, p := range paths {
+		if !isURL(p) {
+			if _, err := os.Stat(p); err != nil {
+				r.errorf(p, 0, "not found")
+				continue
+			}
+			if idxs := indexPaths(p); idxs != nil {
+				found := false
+				for _, idx := range idxs {
+					if xopen.Exists(idx) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					r.errorf(p, 0, "no index found. expected %s", strings.Join(idxs, " or "))
+				}
+			}
+		}
+		name, err := indexcov.GetShortName(p, !strings.HasSuffix(p, ".bam"))
+		if err != nil {
+			r.errorf(p, 0, "error getting the sample name: %s", err)
+			continue
+		}
+		if other, ok := seen[name]; ok {
+			r.errorf(p, 0, "sample %s is also in %s", name, other)
+			continue
+		}
+		seen[name] = p
+		samples = append(samples, name)
+	}
+	return samples
+}
+
+// references returns the chromosomes from the fai or from the first bam. It returns nil if
+// neither can be read.
+func references(r *report, fai string, paths []string) map[string]bool {
+	var refs []*sam.Reference
+	var err error
+	if fai != "" {
+		if refs, err = indexcov.ReadFai(fai, ""); err != nil {
+			r.errorf(fai, 0, "can not be read: %s", err)
+			return nil
+		}
+	} else {
+		for _, p := range paths {
+			if strings.HasSuffix(p, ".bam") {
+				if refs, err = indexcov.RefsFromBam(p, ""); err == nil {
+					break
+				}
+			}
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	chroms := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		chroms[ref.Name()] = true
+	}
+	return chroms
+}
+
+// Main is called from the goleft dispatcher.
+func Main() {
+	cli := &cliargs{}
+	p := arg.MustParse(cli)
+	if len(cli.Bed) == 0 && cli.Ped == "" && cli.Metadata == "" && len(cli.Bams) == 0 {
+		p.Fail("validate-inputs: expected at least one of --bed, --ped, --metadata or bams")
+	}
+
+	r := newReport()
+	var samples []string
+	if len(cli.Bams) > 0 {
+		samples = checkBams(r, cli.Bams)
+	}
+	chroms := references(r, cli.Fai, cli.Bams)
+	for _, bed := range cli.Bed {
+		checkBed(r, bed, chroms)
+	}
+	if cli.Ped != "" {
+		checkPed(r, cli.Ped, samples)
+	}
+	if cli.Metadata != "" {
+		checkMetadata(r, cli.Metadata, samples)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	for _, i := range r.issues {
+		fmt.Fprintln(w, i)
+	}
+	if err := w.Flush(); err != nil {
+		goleft.Fatal(err)
+	}
+	errs, warns := r.counts()
+	log.Printf("validate-inputs: %d errors and %d warnings", errs, warns)
+	if errs > 0 || (cli.Strict && warns > 0) {
+		os.Exit(goleft.ExitInput)
+	}
+}
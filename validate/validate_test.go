@@ -0,0 +1,166 @@
+package validate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "goleft-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// expect is an issue that must be found: its line, whether it is a warning and part of its message.
+type expect struct {
+	line int
+	warn bool
+	msg  string
+}
+
+func check(t *testing.T, name string, r *report, exp []expect) {
+	t.Helper()
+	if len(r.issues) != len(exp) {
+		t.Errorf("%s: expected %d issues, got %d: %v", name, len(exp), len(r.issues), r.issues)
+		return
+	}
+	for _, e := range exp {
+		found := false
+		for _, is := range r.issues {
+			if is.line == e.line && is.warn == e.warn && strings.Contains(is.msg, e.msg) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected an issue at line %d (warn: %v) with %q, got %v", name, e.line, e.warn, e.msg, r.issues)
+		}
+	}
+}
+
+func TestCheckBed(t *testing.T) {
+	chroms := map[string]bool{"1": true, "2": true, "X": true}
+	cases := []struct {
+		name    string
+		content string
+		chroms  map[string]bool
+		exp     []expect
+	}{
+		{"ok", "#header\ntrack name=x\n1\t10\t20\n1\t20\t30\n2\t0\t5\n", chroms, nil},
+		{"no reference", "chr9\t1\t2\n", nil, nil},
+		{"unsorted chromosomes", "1\t10\t20\n2\t1\t5\n1\t40\t50\n", chroms,
+			[]expect{{3, false, "1 is found again after 2"}}},
+		{"unsorted starts", "1\t10\t20\n1\t5\t8\n1\t6\t9\n1\t1\t2\n", chroms,
+			[]expect{{2, false, "not sorted: 1:5 is after 1:10. sort with: sort -k1,1 -k2,2n (and 1 more lines)"},
+				{3, true, "1:6-9 overlaps the interval before it"}}},
+		{"overlap", "1\t10\t20\n1\t15\t30\n", chroms, []expect{{2, true, "overlaps"}}},
+		{"spaces", "1 10 20\n1\t10\n", chroms,
+			[]expect{{1, false, "separated by tabs"}, {0, false, "no intervals"}}},
+		{"integers", "1\ta\t20\n1\t10\t20\n", chroms, []expect{{1, false, "must be integers"}}},
+		{"negative and reversed", "1\t-1\t5\n1\t30\t20\n1\t40\t40\n", chroms,
+			[]expect{{1, false, "negative start"}, {2, false, "end 20 is before start 30"}, {3, true, "empty interval"}}},
+		{"chr prefix", "chr1\t10\t20\n", chroms, []expect{{1, true, "chromosome chr1 is not in the reference. the reference uses 1"}}},
+		{"crlf", "1\t10\t20\r\n1\t30\t40\r\n", chroms, []expect{{1, true, "line endings which become part of the last column (and 1 more lines)"}}},
+		{"empty", "", chroms, []expect{{0, false, "no intervals found"}}},
+	}
+	for _, c := range cases {
+		r := newReport()
+		checkBed(r, write(t, "x.bed", c.content), c.chroms)
+		check(t, c.name, r, c.exp)
+	}
+
+	r := newReport()
+	checkBed(r, filepath.Join(os.TempDir(), "goleft-validate-missing.bed"), chroms)
+	check(t, "missing", r, []expect{{0, false, "can not be read"}})
+}
+
+func TestCheckPed(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		samples []string
+		exp     []expect
+	}{
+		{"ok", "#fam\tsample\tpat\tmat\tsex\tpheno\nf\tkid\tdad\tmom\t1\t-9\nf\tdad\t0\t0\t1\t-9\nf\tmom\t0\t0\t2\t-9\n",
+			[]string{"kid", "dad", "mom"}, nil},
+		{"duplicate and sex", "f\ts1\t0\t0\t1\t-9\nf\ts1\t0\t0\tM\t-9\n", nil,
+			[]expect{{2, false, "sample s1 is also at line 1"}, {2, false, "sex must be"}}},
+		{"columns", "f\ts1\t0\t0\t1\n", nil,
+			[]expect{{1, false, "at least 6 columns"}, {0, false, "no samples found"}}},
+		{"missing parent", "f\tkid\tdad\t0\t1\t-9\n", nil, []expect{{1, true, "parent dad is not a sample"}}},
+		{"join", "f\ts1\t0\t0\t1\t-9\nf\ts2\t0\t0\t2\t-9\n", []string{"s1", "s3"},
+			[]expect{{0, true, "1 of 2 samples from the bams are not found: s3"}, {0, true, "1 samples are not in the bams: s2"}}},
+		{"no join", "f\ts1\t0\t0\t1\t-9\n", []string{"a", "b"},
+			[]expect{{0, false, "none of the 1 samples match"}}},
+	}
+	for _, c := range cases {
+		r := newReport()
+		checkPed(r, write(t, "x.ped", c.content), c.samples)
+		check(t, c.name, r, c.exp)
+	}
+}
+
+func TestCheckMetadata(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		samples []string
+		exp     []expect
+	}{
+		{"ok", "sample\tbatch\tsite\ns1\t1\ta\ns2\t2\tb\n", []string{"s1", "s2"}, nil},
+		{"header", "sample\n", nil, []expect{{1, false, "expected a tab-delimited header"}}},
+		{"reserved", "sample\tPC1\tsex\tCN1\tbatch\tbatch\t\n", nil,
+			[]expect{{1, false, "column PC1 is also written by indexcov"}, {1, false, "column sex is also written"},
+				{1, false, "column CN1 is also written"}, {1, false, "column batch is in the header more than once"},
+				{1, false, "empty column name"}}},
+		{"rows", "sample\tbatch\ns1\t1\ns1\t2\n\t3\ns2 \t1\t9\n", nil,
+			[]expect{{3, false, "sample s1 is also at line 2"}, {4, false, "empty sample name"},
+				{5, true, `sample "s2 " has leading or trailing spaces`}, {5, false, "expected 2 columns as in the header. got 3"}}},
+		{"empty", "", nil, []expect{{0, false, "no header found"}}},
+		{"join", "sample\tbatch\ns1\t1\n", []string{"s1", "s2"},
+			[]expect{{0, true, "1 of 2 samples from the bams are not found: s2"}}},
+	}
+	for _, c := range cases {
+		r := newReport()
+		checkMetadata(r, write(t, "x.tsv", c.content), c.samples)
+		check(t, c.name, r, c.exp)
+	}
+}
+
+func TestCheckJoin(t *testing.T) {
+	many := []string{"a", "b", "c", "d", "e", "f", "g"}
+	cases := []struct {
+		name    string
+		found   map[string]int
+		samples []string
+		exp     []expect
+	}{
+		{"no bams", map[string]int{"a": 1}, nil, nil},
+		{"no samples in the file", map[string]int{}, many, nil},
+		{"all", map[string]int{"a": 1, "b": 2}, []string{"b", "a"}, nil},
+		{"none", map[string]int{"x": 1, "y": 2}, []string{"a"},
+			[]expect{{0, false, "none of the 2 samples match the 1 samples from the bams, e.g. a"}}},
+		{"abbreviated", map[string]int{"a": 1}, many,
+			[]expect{{0, true, "6 of 7 samples from the bams are not found: b, c, d, e, f, ..."}}},
+	}
+	for _, c := range cases {
+		r := newReport()
+		checkJoin(r, "x.ped", c.found, c.samples)
+		check(t, c.name, r, c.exp)
+	}
+	r := newReport()
+	checkJoin(r, "x.ped", map[string]int{"a": 1}, many)
+	if e, w := r.counts(); e != 0 || w != 1 {
+		t.Errorf("expected 0 errors and 1 warning, got %d and %d", e, w)
+	}
+}